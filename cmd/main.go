@@ -2,17 +2,50 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime/debug"
+	"syscall"
+	"time"
+	"tp-plugin/internal/anomaly"
+	"tp-plugin/internal/audit"
+	"tp-plugin/internal/capture"
+	"tp-plugin/internal/chaos"
 	"tp-plugin/internal/config"
+	"tp-plugin/internal/downlink"
+	"tp-plugin/internal/forward"
 	"tp-plugin/internal/handler"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/middleware"
+	"tp-plugin/internal/ota"
+	"tp-plugin/internal/pipeline"
 	"tp-plugin/internal/pkg/logger"
+	"tp-plugin/internal/pkg/useragent"
 	"tp-plugin/internal/platform"
+	"tp-plugin/internal/profile"
+	"tp-plugin/internal/reqlog"
+	"tp-plugin/internal/script"
+	"tp-plugin/internal/session"
+	"tp-plugin/internal/slowlog"
+	"tp-plugin/internal/standby"
+	"tp-plugin/internal/startup"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/support"
+	"tp-plugin/internal/trace"
+	"tp-plugin/internal/transform"
+	"tp-plugin/internal/tunnel"
+	"tp-plugin/internal/upstream"
+	"tp-plugin/internal/wsserver"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
-	"gopkg.in/yaml.v3"
 )
 
 func main() {
@@ -34,8 +67,25 @@ func main() {
 				Aliases: []string{"c"},
 				Value:   "../configs/config.yaml",
 				Usage:   "config file path",
+				EnvVars: []string{"TP_PLUGIN_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:  "pprof",
+				Usage: "enable profiling mode and serve pprof on the given address, e.g. localhost:6060",
+			},
+			&cli.BoolFlag{
+				Name:  "mock-upstream",
+				Usage: "serve canned xiaozhi responses from fixture files instead of calling the xiaozhi server",
+			},
+			&cli.StringFlag{
+				Name:  "mock-fixtures",
+				Value: "../configs/mock",
+				Usage: "fixture directory used by --mock-upstream",
 			},
 		},
+		Commands: []*cli.Command{
+			auditVerifyCommand,
+		},
 		Action: run,
 	}
 
@@ -56,17 +106,30 @@ func run(c *cli.Context) error {
 
 	// 2. 加载配置
 	logrus.Info("开始加载配置文件...")
-	cfg, err := loadConfig(configPath)
+	cfg, err := config.Load(configPath)
 	if err != nil {
+		var verr *config.ValidationError
+		if errors.As(err, &verr) {
+			for _, f := range verr.Fields {
+				logrus.WithField("field", f.Field).WithField("env", f.Source).Error(f.Message)
+			}
+		}
 		logrus.WithError(err).Error("加载配置文件失败")
 		return fmt.Errorf("加载配置文件失败: %v", err)
 	}
+	if limit := cfg.MemoryLimit(); limit > 0 {
+		debug.SetMemoryLimit(limit)
+	}
 	logrus.WithFields(logrus.Fields{
+		"profile":         cfg.Profile,
+		"environment":     cfg.Environment.Name,
+		"services":        cfg.Platform.Identifiers(),
 		"port":            cfg.Server.Port,
 		"max_connections": cfg.Server.MaxConnections,
 		"heartbeat":       cfg.Server.HeartbeatTimeout,
 		"log_level":       cfg.Log.Level,
 		"log_path":        cfg.Log.FilePath,
+		"locale":          cfg.Server.Locale,
 	}).Info("配置加载成功")
 
 	// 3. 日志目录检查和初始化
@@ -76,15 +139,218 @@ func run(c *cli.Context) error {
 		return fmt.Errorf("创建日志目录失败: %v", err)
 	}
 	logger.InitLogger(&cfg.Log)
+	defer logger.Close()
 	logrus.Info("日志系统初始化完成")
 
-	// 4. 创建平台客户端
+	if addr := c.String("pprof"); addr != "" {
+		startPprof(addr)
+	}
+
+	i18n.SetDefault(cfg.Server.Locale)
+	useragent.Set(useragent.Identity{
+		Name:      c.App.Name,
+		Version:   c.App.Version,
+		Instance:  cfg.Client.InstanceID,
+		UserAgent: cfg.Client.UserAgent,
+	})
+	logrus.WithField("user_agent", useragent.Get().UserAgent).Info("出站请求身份")
+	// 插件收到的请求及发往第三方的请求记录到请求日志，凭证和令牌隐藏后记录
+	reqLog, err := reqlog.New(reqlog.Config{
+		Level:      cfg.Log.Requests.Level,
+		FilePath:   cfg.Log.Requests.FilePath,
+		MaxBody:    cfg.Log.Requests.MaxBodyKB << 10,
+		MaxSize:    cfg.Log.MaxSize,
+		MaxBackups: cfg.Log.MaxBackups,
+	})
+	if err != nil {
+		return fmt.Errorf("创建请求日志失败: %v", err)
+	}
+	defer reqLog.Close()
+	httpclient.Configure(httpclient.Config{
+		Timeout:        time.Duration(cfg.Client.TimeoutMs) * time.Millisecond,
+		Retries:        cfg.Client.Retries,
+		RetryBackoff:   time.Duration(cfg.Client.BackoffMs) * time.Millisecond,
+		MaxIdleConns:   cfg.Client.IdleConns,
+		MaxIdlePerHost: cfg.Client.HostConns,
+		Wrap:           reqLog.Transport,
+	})
+
+	// 备用实例在接管前只同步主实例的存储，不打开存储也不监听端口；
+	// 配置为主实例但对端已在运行(如故障恢复后的原主实例)时同样作为备用实例运行，避免两个实例同时接入
+	standbyCfg := standby.Config(cfg.Standby)
+	if standbyCfg.LeaseTopic == "" {
+		standbyCfg.LeaseTopic = fmt.Sprintf("plugin/%s/standby/lease", cfg.Platform.ServiceIdentifier)
+	}
+	// 主实例租约以平台MQTT服务器为第三方，防止与对端之间的网络中断时两个实例同时接入
+	lease, err := standby.NewLease(standbyCfg, standby.LeaseConfig{
+		Broker:   cfg.Platform.MQTTBroker,
+		Username: cfg.Platform.MQTTUsername,
+		Password: cfg.Platform.MQTTPassword,
+	}, logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("创建主实例租约失败: %v", err)
+	}
+	defer lease.Close()
+	if standbyCfg.Role == standby.RoleStandby || standby.PeerActive(context.Background(), standbyCfg) ||
+		lease.HeldByPeer(context.Background()) {
+		follower, err := standby.NewFollower(standbyCfg, cfg.Store.Path, lease, logrus.StandardLogger())
+		if err != nil {
+			return fmt.Errorf("启动备用实例失败: %v", err)
+		}
+		if err := follower.Run(context.Background()); err != nil {
+			return fmt.Errorf("备用实例同步失败: %v", err)
+		}
+	}
+	lease.Acquire()
+	startedAt := time.Now()
+
+	// 4. 打开本地存储(自动执行结构迁移)
+	logrus.Info("正在打开本地存储...")
+	st, err := store.Open(cfg.Store.Path, logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("打开本地存储失败: %v", err)
+	}
+	defer st.Close()
+	if err := st.UseSecret(cfg.Store.SecretKey); err != nil {
+		return fmt.Errorf("加载存储密钥失败: %v", err)
+	}
+	if version, err := st.SchemaVersion(); err == nil {
+		logrus.WithField("schema_version", version).Info("本地存储打开成功")
+	}
+
+	// 故障注入仅在显式启用时生效
+	injector := chaos.New(chaos.Config{
+		Enabled:              cfg.Chaos.Enabled,
+		LatencyPercent:       cfg.Chaos.LatencyPercent,
+		LatencyMin:           time.Duration(cfg.Chaos.LatencyMinMs) * time.Millisecond,
+		LatencyMax:           time.Duration(cfg.Chaos.LatencyMaxMs) * time.Millisecond,
+		MQTTDropPercent:      cfg.Chaos.MQTTDropPercent,
+		UpstreamErrorPercent: cfg.Chaos.UpstreamErrorPercent,
+	}, logrus.StandardLogger())
+	if injector != nil {
+		logrus.WithFields(logrus.Fields{
+			"latency_percent":        cfg.Chaos.LatencyPercent,
+			"mqtt_drop_percent":      cfg.Chaos.MQTTDropPercent,
+			"upstream_error_percent": cfg.Chaos.UpstreamErrorPercent,
+		}).Warn("已启用故障注入，请勿在生产环境使用")
+	}
+
+	// 按设备追踪，通过管理接口开启
+	var tracer *trace.Tracer
+	if cfg.Trace.Enabled {
+		tracer, err = trace.New(trace.Config{
+			Dir:         cfg.Trace.Dir,
+			MaxDuration: time.Duration(cfg.Trace.MaxMinutes) * time.Minute,
+			Rate:        cfg.Trace.Rate,
+			Burst:       cfg.Trace.Burst,
+		}, logrus.StandardLogger())
+		if err != nil {
+			return fmt.Errorf("创建设备追踪失败: %v", err)
+		}
+		defer tracer.Close()
+	}
+
+	// MQTT抓包，通过管理接口开启
+	var capturer *capture.Capturer
+	if cfg.Capture.Enabled {
+		capturer = capture.New(capture.Config{
+			Dir:         cfg.Capture.Dir,
+			MaxBytes:    int64(cfg.Capture.MaxMB) << 20,
+			MaxDuration: time.Duration(cfg.Capture.MaxMinutes) * time.Minute,
+		}, logrus.StandardLogger())
+		defer capturer.Close()
+	}
+
+	// 5. 创建平台客户端
 	logrus.Info("正在初始化平台客户端...")
+	// 加载消息转换插件
+	rules := make([]transform.Rule, 0, len(cfg.Transform.Plugins))
+	for _, r := range cfg.Transform.Plugins {
+		rules = append(rules, transform.Rule(r))
+	}
+	transforms, err := transform.New(rules, logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("加载消息转换插件失败: %v", err)
+	}
+
+	// 编译载荷脚本
+	scriptRules := make([]script.Rule, 0, len(cfg.Scripts.Rules))
+	for _, r := range cfg.Scripts.Rules {
+		scriptRules = append(scriptRules, script.Rule(r))
+	}
+	scripts, err := script.New(script.Config{
+		Timeout: time.Duration(cfg.Scripts.TimeoutMs) * time.Millisecond,
+		Rules:   scriptRules,
+	}, logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("加载载荷脚本失败: %v", err)
+	}
+
+	// 插件间事件转发
+	forwarder, err := forward.New(forwardTargets(cfg), logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("创建事件转发失败: %v", err)
+	}
+	defer forwarder.Close()
+
+	// 按权限范围区分的管理接口令牌
+	scopedTokens := make([]middleware.ScopedToken, 0, len(cfg.Server.AdminScoped))
+	for _, t := range cfg.Server.AdminScoped {
+		if err := middleware.ValidScope(t.Scope); err != nil {
+			return fmt.Errorf("管理接口令牌 %s 配置错误: %v", t.Name, err)
+		}
+		scopedTokens = append(scopedTokens, middleware.ScopedToken(t))
+	}
+
+	// 遥测异常检测
+	anomalyRules := make([]anomaly.Rule, 0, len(cfg.Anomaly.Rules))
+	for _, r := range cfg.Anomaly.Rules {
+		anomalyRules = append(anomalyRules, anomaly.Rule(r))
+	}
+	detector, err := anomaly.New(anomalyRules)
+	if err != nil {
+		return fmt.Errorf("异常检测配置错误: %v", err)
+	}
+
 	platformClient, err := platform.NewPlatformClient(platform.Config{
-		BaseURL:      cfg.Platform.URL,
-		MQTTBroker:   cfg.Platform.MQTTBroker,
-		MQTTUsername: cfg.Platform.MQTTUsername,
-		MQTTPassword: cfg.Platform.MQTTPassword,
+		BaseURL:         cfg.Platform.URL,
+		MQTTBroker:      cfg.Platform.MQTTBroker,
+		MQTTUsername:    cfg.Platform.MQTTUsername,
+		MQTTPassword:    cfg.Platform.MQTTPassword,
+		MQTTClientID:    cfg.Platform.ServiceIdentifier,
+		DeviceCacheSize: cfg.Platform.DeviceCacheSize,
+		Spool:           platform.SpoolConfig(cfg.Platform.Spool),
+		Telemetry:       platform.TelemetryConfig(cfg.Platform.Telemetry),
+		DeviceCache: platform.DeviceCacheConfig{
+			TTL:        time.Duration(cfg.Platform.DeviceCache.TTLMinutes) * time.Minute,
+			Persist:    cfg.Platform.DeviceCache.Persist,
+			MaxEntries: cfg.Platform.DeviceCache.MaxEntries,
+		},
+		Batch: platform.BatchConfig{
+			Enabled:      cfg.Platform.Batch.Enabled,
+			MaxSize:      cfg.Platform.Batch.MaxSize,
+			Interval:     time.Duration(cfg.Platform.Batch.IntervalMS) * time.Millisecond,
+			QueueSize:    cfg.Platform.Batch.QueueSize,
+			BlockTimeout: time.Duration(cfg.Platform.Batch.BlockTimeoutMS) * time.Millisecond,
+		},
+		Failover: platform.FailoverConfig{
+			Secondary:        platform.Endpoint{BaseURL: cfg.Platform.Secondary.URL, MQTTBroker: cfg.Platform.Secondary.MQTTBroker},
+			FailoverAfter:    time.Duration(cfg.Platform.Secondary.FailoverAfter) * time.Second,
+			FailbackInterval: time.Duration(cfg.Platform.Secondary.FailbackInterval) * time.Second,
+		},
+		Chaos:       injector,
+		Tracer:      tracer,
+		Capture:     capturer,
+		Transforms:  transforms,
+		Scripts:     scripts,
+		Forwarder:   forwarder,
+		Store:       st,
+		Anomaly:     detector,
+		Fleet:       platform.FleetConfig(cfg.Platform.Fleet),
+		HealthScore: platform.HealthScoreConfig(cfg.Platform.HealthScore),
+		Serializers: cfg.Platform.Serializers,
+		KeyFilters:  keyFilters(cfg),
+		LazyConnect: !cfg.Startup.RequireMQTT,
 	}, logrus.StandardLogger())
 	if err != nil {
 		return fmt.Errorf("创建平台客户端失败: %v", err)
@@ -92,7 +358,34 @@ func run(c *cli.Context) error {
 	defer platformClient.Close()
 	logrus.Info("平台客户端初始化成功")
 
-	// // 5. 创建并初始化服务管理器
+	// 启动依赖在后台按依赖顺序启动，失败时各自按退避间隔重试，不阻塞HTTP服务；
+	// 在平台客户端关闭前停止重试
+	deps := startup.New(startup.Config{
+		InitialBackoff: time.Duration(cfg.Startup.RetryInitialMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(cfg.Startup.RetryMaxSeconds) * time.Second,
+	}, logrus.StandardLogger())
+	defer deps.Stop()
+	deps.Add(startup.Dependency{
+		Name:  "mqtt",
+		Start: func(context.Context) error { return platformClient.Connect() },
+	})
+
+	// 为每个服务标识符发送插件心跳
+	if cfg.Platform.HeartbeatInterval > 0 {
+		platformClient.StartHeartbeat(cfg.Platform.Identifiers(), time.Duration(cfg.Platform.HeartbeatInterval)*time.Second)
+	}
+
+	// 设备健康评分，未启用时不执行
+	platformClient.StartHealthScore()
+	platformClient.StartFleetStats()
+
+	// 配置了多个服务标识符时按请求的 protocol_type 分发，单一标识符时不做校验以兼容现有部署
+	var serviceIdentifiers []string
+	if len(cfg.Platform.ServiceIdentifiers) > 0 {
+		serviceIdentifiers = cfg.Platform.Identifiers()
+	}
+
+	// // 6. 创建并初始化服务管理器
 	// logrus.Info("正在初始化服务管理器...")
 	// serviceMgr := manager.NewServiceManager(
 	// 	platformClient,
@@ -113,35 +406,373 @@ func run(c *cli.Context) error {
 	// defer serviceMgr.Stop()
 	// logrus.Info("服务管理器启动成功")
 
-	// 6. 创建并启动HTTP服务
-	httpHandler := handler.NewHTTPHandler(platformClient, logrus.StandardLogger())
-	handlers := httpHandler.RegisterHandlers()
-	httpPort := cfg.Server.HTTPPort
+	// 加载设备能力模型
+	profiles, err := profile.Load(cfg.Profiles.Path)
+	if err != nil {
+		return fmt.Errorf("加载设备能力模型失败: %v", err)
+	}
+	logrus.WithField("count", len(profiles)).Info("设备能力模型加载完成")
+
+	// 加载设备接入流水线
+	pipelines, err := pipeline.Load(cfg.Pipeline.Path)
+	if err != nil {
+		return fmt.Errorf("加载设备接入流水线失败: %v", err)
+	}
+	var pipelineEngine *pipeline.Engine
+	if len(pipelines) > 0 {
+		pipelineEngine = pipeline.NewEngine(pipelines, logrus.StandardLogger())
+		defer pipelineEngine.Close()
+		logrus.WithField("count", len(pipelines)).Info("设备接入流水线加载完成")
+	}
+
+	// 模拟模式下从夹具文件返回小智服务端响应，便于无小智服务时联调
+	upstreamTransport := httpclient.Transport()
+	if c.Bool("mock-upstream") {
+		mock, err := upstream.NewMockTransport(c.String("mock-fixtures"), logrus.StandardLogger())
+		if err != nil {
+			return fmt.Errorf("启用模拟模式失败: %v", err)
+		}
+		upstreamTransport = mock
+		logrus.WithField("fixtures", c.String("mock-fixtures")).Warn("已启用小智服务端模拟模式")
+	}
+
+	// 内网小智服务端经反向隧道接入，tunnel:// 地址的调用经隧道转发
+	tunnelAgents := make([]tunnel.Agent, 0, len(cfg.Tunnel.Agents))
+	for _, a := range cfg.Tunnel.Agents {
+		tunnelAgents = append(tunnelAgents, tunnel.Agent(a))
+	}
+	// lite 模式下不提供隧道入口
+	if cfg.Tunnel.Enabled && !cfg.AdminEnabled() {
+		logrus.Warn("lite 模式不提供反向隧道，已忽略 tunnel 配置")
+	}
+	hub, err := tunnel.New(tunnel.Config{Enabled: cfg.Tunnel.Enabled && cfg.AdminEnabled(), Agents: tunnelAgents}, logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("创建反向隧道失败: %v", err)
+	}
+	defer hub.Close()
+	upstreamTransport = hub.Transport(upstreamTransport)
+
+	// 小智固件直连的WebSocket服务，以设备凭证鉴权，上行遥测经平台客户端发布
+	devices := wsserver.New(wsserver.Config{
+		Port:             cfg.Server.Port,
+		Bind:             cfg.Server.Bind,
+		Network:          cfg.Server.Network,
+		Path:             cfg.Server.WSPath,
+		MaxConnections:   cfg.Server.MaxConnections,
+		HeartbeatTimeout: time.Duration(cfg.Server.HeartbeatTimeout) * time.Second,
+		PingInterval:     time.Duration(cfg.Server.PingInterval) * time.Second,
+		PongTimeout:      time.Duration(cfg.Server.PongTimeout) * time.Second,
+		MaxMessageSize:   int64(cfg.Server.MaxMessageKB) << 10,
+		ResumeTTL:        time.Duration(cfg.Server.ResumeTTL) * time.Second,
+		Throttle: wsserver.ThrottleConfig{
+			ReportInterval: time.Duration(cfg.Server.Throttle.ReportInterval) * time.Second,
+			High:           cfg.Server.Throttle.High,
+			Low:            cfg.Server.Throttle.Low,
+		},
+	}, platformClient, logrus.StandardLogger())
+	// 正常退出时由 shutdown 在断开MQTT前关闭；启动中途出错返回时此处兜底
+	defer devices.Close(context.Background())
+
+	// 经小智服务端回调上报数据的设备按心跳超时判断下线
+	heartbeats := session.New(session.Config{
+		Timeout: time.Duration(cfg.Server.HeartbeatTimeout) * time.Second,
+	}, platformClient, logrus.StandardLogger())
+	defer heartbeats.Stop()
+
+	// 平台下发的控制、属性设置和命令按设备排队，经WebSocket会话下发，设备应答后回复平台
+	router := downlink.New(downlink.Config{
+		Enabled:            cfg.Downlink.Enabled,
+		ServiceIdentifiers: cfg.Platform.Identifiers(),
+		QueueSize:          cfg.Downlink.QueueSize,
+		AckTimeout:         time.Duration(cfg.Downlink.AckTimeoutSeconds) * time.Second,
+		Outbox: downlink.OutboxConfig{
+			Store:     st,
+			TTL:       time.Duration(cfg.Downlink.OutboxTTLMinutes) * time.Minute,
+			PerDevice: cfg.Downlink.OutboxPerDevice,
+		},
+	}, platformClient, devices, logrus.StandardLogger())
+	defer router.Stop()
+
+	// 固件升级命令由插件下载校验固件后经WebSocket会话下发，设备上报的进度转发到平台
+	upgrades := ota.New(ota.Config{
+		Enabled:   cfg.OTA.Enabled,
+		Dir:       cfg.OTA.Dir,
+		BaseURL:   cfg.OTA.BaseURL,
+		Mode:      cfg.OTA.Mode,
+		ChunkSize: cfg.OTA.ChunkSize,
+		MaxSize:   int64(cfg.OTA.MaxSizeMB) << 20,
+		Timeout:   time.Duration(cfg.OTA.TimeoutMinutes) * time.Minute,
+	}, platformClient, devices, logrus.StandardLogger())
+	if upgrades != nil {
+		method := cfg.OTA.Method
+		if method == "" {
+			method = ota.DefaultMethod
+		}
+		router.HandleCommand(method, upgrades.Upgrade)
+	}
+	deps.Add(startup.Dependency{
+		Name:  "downlink",
+		After: []string{"mqtt"},
+		Start: func(context.Context) error { return router.Start() },
+	})
+	upstreamTransport = injector.Transport(upstreamTransport)
+
+	// 慢调用日志包在故障注入之外，注入的延迟同样会被记录
+	slow := slowlog.New(slowlog.Config{
+		Threshold:  time.Duration(cfg.Upstream.SlowThresholdMs) * time.Millisecond,
+		FilePath:   cfg.Upstream.SlowLog,
+		MaxSize:    cfg.Log.MaxSize,
+		MaxBackups: cfg.Log.MaxBackups,
+	})
+	defer slow.Close()
+	upstreamTransport = slow.Transport(upstreamTransport)
+	// 重试包在最外层，每次尝试分别经过故障注入并计入慢调用日志
+	upstreamTransport = httpclient.Retry(upstreamTransport)
+
+	auditLog, err := audit.New(audit.Config{
+		Enabled:    cfg.Audit.Enabled,
+		FilePath:   cfg.Audit.FilePath,
+		Key:        cfg.Audit.Secret,
+		MaxSize:    cfg.Log.MaxSize,
+		MaxBackups: cfg.Log.MaxBackups,
+	}, logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("创建审计日志失败: %v", err)
+	}
+	defer auditLog.Close()
+
+	forms, err := handler.NewFormRegistry(cfg.Server.FormsDir, logrus.StandardLogger())
+	if err != nil {
+		return err
+	}
+
+	// 7. 创建并启动HTTP服务
+	httpHandler, err := handler.NewHTTPHandler(handler.Config{
+		ProtocolVersion:    cfg.Server.ProtocolVersion,
+		BasePath:           cfg.Server.BasePath,
+		ServiceIdentifiers: serviceIdentifiers,
+		Profiles:           profile.NewPublisher(profiles, st, logrus.StandardLogger()),
+		UpstreamTransport:  upstreamTransport,
+		UpstreamTimeout:    time.Duration(cfg.Upstream.Timeout) * time.Second,
+		UpstreamHeaders:    handler.UpstreamHeaders(cfg.Upstream.Headers),
+		Tracer:             tracer,
+		Capture:            capturer,
+		Store:              st,
+		Startup:            deps,
+		Forms:              forms,
+		Pipelines:          pipelineEngine,
+		Transforms:         transforms,
+		Binary:             transform.BinaryLimits(cfg.Transform.Binary),
+		Bundle:             handler.BundleConfig(cfg.Bundle),
+		DeviceCache:        handler.CacheConfig(cfg.Device.Cache),
+		Text:               handler.TextConfig(cfg.Device.Text),
+		DeviceList:         handler.ListConfig(cfg.Device.List),
+		Tunnels:            hub,
+		Devices:            devices,
+		Downlink:           router,
+		Heartbeats:         heartbeats,
+		OTA:                upgrades,
+		Audit:              auditLog,
+		Notify:             handler.NotifyConfig(cfg.Notify),
+		Broadcast:          handler.BroadcastConfig(cfg.Broadcast),
+		Breaker: handler.BreakerConfig{
+			Failures: cfg.Upstream.Breaker.Failures,
+			Open:     time.Duration(cfg.Upstream.Breaker.OpenSeconds) * time.Second,
+		},
+		Support: support.NewGenerator(support.Config{
+			ConfigPath: configPath,
+			LogPath:    cfg.Log.FilePath,
+		}, logrus.StandardLogger()),
+	}, platformClient, logrus.StandardLogger())
+	if err != nil {
+		return fmt.Errorf("创建HTTP处理器失败: %v", err)
+	}
+	// 已直连或维护中的设备心跳超时时不上报下线
+	heartbeats.KeepOnline(func(number string) bool {
+		on, _ := httpHandler.InMaintenance(number)
+		return on || devices.Connected(number)
+	})
+	heartbeats.Start()
+
+	// 向平台插件目录注册服务元数据，保持目录中的版本、设备类型和表单与插件一致
+	if cfg.Platform.Register.Enabled {
+		var metas []platform.ServiceMetadata
+		for _, id := range cfg.Platform.Identifiers() {
+			metas = append(metas, httpHandler.ServiceMetadata(id))
+		}
+		deps.Add(startup.Dependency{
+			Name:  "register",
+			After: []string{"mqtt"},
+			Start: func(context.Context) error {
+				platformClient.RegisterServicesAsync(cfg.Platform.Register.Path, metas)
+				return nil
+			},
+		})
+	}
+	if err := deps.Start(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", httpHandler)
+	mux.Handle(httpHandler.RoutePath("/metrics"), metrics.Handler())
+	mux.Handle(httpHandler.RoutePath("/device/"), httpHandler.DeviceHandler())
+	// lite 模式下不提供管理接口(含管理界面)和主备复制接口
+	if cfg.AdminEnabled() {
+		mux.Handle(httpHandler.RoutePath("/admin/"), httpHandler.AdminHandler())
+		if standbyCfg.Token != "" {
+			mux.Handle(httpHandler.RoutePath("/replication/"), standby.Handler(standbyCfg, st, startedAt, logrus.StandardLogger()))
+		}
+	} else {
+		logrus.Info("lite 模式：不提供管理接口和主备复制接口")
+	}
+	if hub != nil {
+		mux.Handle(httpHandler.RoutePath("/tunnel"), hub.Handler())
+	}
+	// 小智服务端回调，v1 带版本路径及 v0 旧路径
+	callbacks := httpHandler.CallbackHandler()
+	for _, p := range []string{"/v1/", "/events", "/bind-result"} {
+		mux.Handle(httpHandler.RoutePath(p), callbacks)
+	}
+
+	// 中间件统一作用于平台回调、管理接口及指标接口
+	log := logrus.StandardLogger()
+	httpServer := middleware.Chain(mux,
+		middleware.Recovery(log),
+		middleware.RequestID(),
+		reqLog.Middleware(),
+		middleware.Logging(log),
+		middleware.Metrics(),
+		middleware.CORS(middleware.CORSConfig(cfg.Server.CORS)),
+		middleware.RateLimit(middleware.RateLimitConfig(cfg.Server.RateLimit)),
+		middleware.Auth(middleware.AuthConfig{
+			Tokens:   cfg.Server.AdminTokens,
+			Scoped:   scopedTokens,
+			Prefixes: []string{httpHandler.RoutePath("/admin/")},
+			Lookup:   httpHandler.LookupAdminToken,
+			Required: httpHandler.AdminScope,
+		}),
+	)
+	// HTTP服务监听 TCP 端口、Unix 套接字或 systemd 传入的套接字
+	httpListener, err := cfg.Server.HTTPListener()
+	if err != nil {
+		return fmt.Errorf("HTTP服务监听失败: %v", err)
+	}
+	srv := &http.Server{Handler: httpServer}
 	go func() {
-		logrus.Infof("正在启动HTTP服务，端口: %d", httpPort)
-		if err := handlers.Start(fmt.Sprintf(":%d", httpPort)); err != nil {
+		logrus.Infof("正在启动HTTP服务，监听: %s", httpListener.Addr())
+		if err := srv.Serve(httpListener); !errors.Is(err, http.ErrServerClosed) {
 			logrus.Errorf("HTTP服务启动失败: %v", err)
 		}
 	}()
 
+	if devices != nil {
+		go func() {
+			if err := devices.ListenAndServe(); err != nil {
+				logrus.Errorf("设备WebSocket服务启动失败: %v", err)
+			}
+		}()
+	}
+
+	// 配置热加载：文件修改或收到 SIGHUP 时重新加载，只应用可热更新的配置项
+	watcher := watchConfig(configPath, cfg, httpHandler, devices, heartbeats, forwarder)
+	defer watcher.Stop()
+
 	logrus.Info("插件HTTP服务启动成功")
 
-	// 7. 阻塞主goroutine,等待信号
-	select {}
+	// 8. 阻塞主goroutine，收到 SIGTERM/SIGINT 后优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	var fenced error
+	select {
+	case <-ctx.Done():
+	case <-lease.Fenced():
+		// 对端已接管，立即停止接入；退出码非0，由进程管理器重启后以备用实例运行
+		fenced = errors.New("对端已接管主实例租约，本实例退出")
+	}
+	stop()
+	drain := time.Duration(cfg.Server.DrainTimeout) * time.Second
+	if drain <= 0 {
+		drain = defaultDrainTimeout
+	}
+	logrus.WithField("drain_timeout", drain).Info("收到退出信号，开始优雅关闭")
+	// 超时未完成时强制退出；stop 后再次收到信号按默认行为立即退出
+	// 计时覆盖下方 defer 中的关闭步骤，进程退出前不停止
+	time.AfterFunc(drain, func() {
+		logrus.Error("优雅关闭超时，强制退出")
+		os.Exit(1)
+	})
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	shutdown(shutdownCtx, srv, devices, httpHandler)
+	// 返回后按 defer 的逆序上报剩余的遥测批量、断开MQTT
+	return fenced
+}
+
+// defaultDrainTimeout 未配置 server.drain_timeout 时等待关闭完成的时长
+const defaultDrainTimeout = 30 * time.Second
+
+// shutdown 停止接受新的HTTP请求和设备连接，等待进行中的请求及排队的平台通知处理完成
+func shutdown(ctx context.Context, srv *http.Server, devices *wsserver.Server, h *handler.HTTPHandler) {
+	if err := devices.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Warn("关闭设备WebSocket服务失败")
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Warn("等待HTTP请求完成超时")
+	}
+	if err := h.DrainNotifications(ctx); err != nil {
+		logrus.WithError(err).Warn("等待平台通知处理完成超时")
+	}
+	// 断开设备会话并等待下线上报完成，之后才能关闭平台客户端
+	if err := devices.Close(ctx); err != nil {
+		logrus.WithError(err).Warn("等待设备会话上报下线超时")
+	}
+	logrus.Info("已停止接收请求")
 }
 
-func loadConfig(configPath string) (*config.Config, error) {
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, err
+// keyFilters 将配置转换为遥测字段过滤规则
+func keyFilters(cfg *config.Config) []platform.KeyFilter {
+	filters := make([]platform.KeyFilter, 0, len(cfg.Platform.KeyFilters))
+	for _, f := range cfg.Platform.KeyFilters {
+		filters = append(filters, platform.KeyFilter(f))
 	}
+	return filters
+}
 
-	var cfg config.Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+// forwardTargets 将配置转换为转发目标
+func forwardTargets(cfg *config.Config) []forward.Target {
+	targets := make([]forward.Target, 0, len(cfg.Forward.Targets))
+	for _, t := range cfg.Forward.Targets {
+		targets = append(targets, forward.Target(t))
 	}
+	return targets
+}
 
-	return &cfg, nil
+// watchConfig 启动配置监视，注册可热更新的配置项：日志级别、设备心跳超时、小智服务端超时及请求头、插件事件转发目标；
+// 其余配置项修改后记录日志提示需要重启
+func watchConfig(path string, cfg *config.Config, h *handler.HTTPHandler, devices *wsserver.Server, heartbeats *session.Manager, forwarder *forward.Forwarder) *config.Watcher {
+	w := config.NewWatcher(path, cfg, logrus.StandardLogger())
+	w.Handle([]string{"log.level"}, func(c *config.Config) error {
+		level, err := logrus.ParseLevel(c.Log.Level)
+		if err != nil {
+			level = logrus.InfoLevel
+		}
+		logrus.SetLevel(level)
+		return nil
+	})
+	w.Handle([]string{"server.heartbeatTimeout"}, func(c *config.Config) error {
+		devices.SetHeartbeatTimeout(time.Duration(c.Server.HeartbeatTimeout) * time.Second)
+		heartbeats.SetTimeout(time.Duration(c.Server.HeartbeatTimeout) * time.Second)
+		return nil
+	})
+	w.Handle([]string{"upstream.timeout", "upstream.headers"}, func(c *config.Config) error {
+		h.SetUpstream(time.Duration(c.Upstream.Timeout)*time.Second, handler.UpstreamHeaders(c.Upstream.Headers))
+		return nil
+	})
+	w.Handle([]string{"forward.targets"}, func(c *config.Config) error {
+		return forwarder.Reload(forwardTargets(c))
+	})
+	w.Start()
+	return w
 }
 
 func ensureLogDir(logPath string) error {
@@ -0,0 +1,20 @@
+// cmd/pprof.go
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startPprof 启动 pprof 性能分析服务(profiling 模式)
+func startPprof(addr string) {
+	go func() {
+		logrus.Infof("pprof 性能分析服务已启动: http://%s/debug/pprof/", addr)
+		// net/http/pprof 注册在 DefaultServeMux 上，与插件HTTP服务相互独立
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			logrus.WithError(err).Error("pprof 服务启动失败")
+		}
+	}()
+}
@@ -0,0 +1,21 @@
+-- configs/scripts/xiaozhi-box.lua
+-- 载荷脚本示例：在 config.yaml 的 scripts.rules 中按设备类型启用
+-- values 为设备上报的数据，device 包含 device_number 和 device_type；返回新的数据表，返回nil时丢弃该条数据
+-- 只能使用 base/table/string/math 库，不能访问文件和网络，执行超过 timeout_ms 视为失败
+
+function transform(values, device)
+    -- 固件上报的剩余内存单位为KB，统一换算为字节
+    if values.free_heap_kb ~= nil then
+        values.free_heap = values.free_heap_kb * 1024
+        values.free_heap_kb = nil
+    end
+    -- 信号强度无效(0)时丢弃该字段
+    if values.rssi == 0 then
+        values.rssi = nil
+    end
+    -- 心跳包不含业务数据，不上报
+    if values.type == "ping" then
+        return nil
+    end
+    return values
+end
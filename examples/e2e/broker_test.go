@@ -0,0 +1,298 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// MQTT 3.1.1 控制报文类型
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// broker 进程内MQTT broker，只实现插件和测试订阅方用到的部分：
+// CONNECT、SUBSCRIBE/UNSUBSCRIBE(支持 + 和 # 通配符)、PUBLISH、PINGREQ、DISCONNECT；
+// 消息一律以 QoS 0 转发给订阅方，不保留会话和保留消息
+type broker struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[*brokerConn]struct{}
+}
+
+// brokerConn 一个客户端连接及其订阅
+type brokerConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	filters []string // 由 broker.mu 保护
+}
+
+// startBroker 在本机随机端口启动 broker，测试结束时关闭
+func startBroker(t *testing.T) *broker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动MQTT broker失败: %v", err)
+	}
+	b := &broker{ln: ln, conns: make(map[*brokerConn]struct{})}
+	b.wg.Add(1)
+	go b.accept()
+	t.Cleanup(b.close)
+	return b
+}
+
+// URL 客户端连接地址
+func (b *broker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *broker) close() {
+	b.ln.Close()
+	b.mu.Lock()
+	for c := range b.conns {
+		c.conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &brokerConn{conn: conn}
+		b.mu.Lock()
+		b.conns[c] = struct{}{}
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go b.serve(c)
+	}
+}
+
+// serve 读取客户端报文直到连接断开或收到 DISCONNECT
+func (b *broker) serve(c *brokerConn) {
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+	r := bufio.NewReader(c.conn)
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetConnect:
+			// 接受任意客户端标识和凭证，会话不保留
+			err = c.write(packetConnack<<4, []byte{0, 0})
+		case packetSubscribe:
+			err = b.subscribe(c, body)
+		case packetUnsubscribe:
+			err = b.unsubscribe(c, body)
+		case packetPublish:
+			err = b.publish(c, header, body)
+		case packetPubrel:
+			err = c.write(packetPubcomp<<4, body[:2])
+		case packetPingreq:
+			err = c.write(packetPingresp<<4, nil)
+		case packetDisconnect:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *broker) subscribe(c *brokerConn, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("SUBSCRIBE 报文过短")
+	}
+	rest := body[2:]
+	var granted []byte
+	for len(rest) > 0 {
+		filter, n, err := readString(rest)
+		if err != nil || len(rest) < n+1 {
+			return errors.New("SUBSCRIBE 报文格式错误")
+		}
+		granted = append(granted, 0)
+		rest = rest[n+1:]
+		b.mu.Lock()
+		c.filters = append(c.filters, filter)
+		b.mu.Unlock()
+	}
+	return c.write(packetSuback<<4, append(body[:2:2], granted...))
+}
+
+func (b *broker) unsubscribe(c *brokerConn, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("UNSUBSCRIBE 报文过短")
+	}
+	rest := body[2:]
+	for len(rest) > 0 {
+		filter, n, err := readString(rest)
+		if err != nil {
+			return err
+		}
+		rest = rest[n:]
+		b.mu.Lock()
+		kept := c.filters[:0]
+		for _, f := range c.filters {
+			if f != filter {
+				kept = append(kept, f)
+			}
+		}
+		c.filters = kept
+		b.mu.Unlock()
+	}
+	return c.write(packetUnsuback<<4, body[:2])
+}
+
+// publish 应答发布方后转发给订阅了匹配主题的客户端
+func (b *broker) publish(c *brokerConn, header byte, body []byte) error {
+	topic, n, err := readString(body)
+	if err != nil {
+		return err
+	}
+	qos := header >> 1 & 3
+	payload := body[n:]
+	if qos > 0 {
+		if len(payload) < 2 {
+			return errors.New("PUBLISH 报文缺少报文标识符")
+		}
+		id := payload[:2]
+		payload = payload[2:]
+		ack := byte(packetPuback)
+		if qos == 2 {
+			ack = packetPubrec
+		}
+		if err := c.write(ack<<4, id); err != nil {
+			return err
+		}
+	}
+
+	out := append(encodeString(topic), payload...)
+	b.mu.Lock()
+	var targets []*brokerConn
+	for sub := range b.conns {
+		for _, f := range sub.filters {
+			if topicMatch(f, topic) {
+				targets = append(targets, sub)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, sub := range targets {
+		sub.write(packetPublish<<4, out)
+	}
+	return nil
+}
+
+// write 写入一个报文，固定报头之后为剩余长度和 body
+func (c *brokerConn) write(header byte, body []byte) error {
+	buf := []byte{header}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		buf = append(buf, d)
+		if n == 0 {
+			break
+		}
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(append(buf, body...))
+	return err
+}
+
+// readPacket 读取一个报文，返回固定报头首字节和剩余部分
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("剩余长度超过4字节")
+		}
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(d&0x7f) * multiplier
+		multiplier *= 128
+		if d&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// readString 读取带2字节长度前缀的字符串，返回字符串和占用的字节数
+func readString(b []byte) (string, int, error) {
+	if len(b) < 2 {
+		return "", 0, errors.New("字符串长度缺失")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", 0, fmt.Errorf("字符串长度 %d 超出报文", n)
+	}
+	return string(b[2 : 2+n]), 2 + n, nil
+}
+
+func encodeString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// topicMatch 主题是否匹配订阅过滤器，+ 匹配一级，# 匹配其后的任意级
+func topicMatch(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,397 @@
+//go:build e2e
+
+// Package e2e 端到端集成测试: 启动进程内MQTT broker，模拟ThingsPanel平台和小智服务端，
+// 依次驱动 表单 → 设备列表 → 绑定 → 上线 → 遥测 → 断开 流程，
+// 校验小智服务端收到绑定请求、平台收到设备配置查询及broker上的状态和遥测消息。
+//
+// 用法:
+//
+//	go test -tags e2e -v ./examples/e2e
+package e2e
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"tp-plugin/internal/handler"
+	"tp-plugin/internal/platform"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/upstream"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	deviceID     = "e2e-device-1"
+	deviceNumber = "A4:CF:12:00:00:01"
+	agentID      = "agent-e2e"
+	secret       = "e2e-secret"
+
+	// waitTimeout 等待每条消息到达的超时时间
+	waitTimeout = 5 * time.Second
+)
+
+var voucher = `{"ServerURL":"http://xiaozhi.mock/xiaozhi","Secret":"` + secret + `"}`
+
+// message broker上收到的一条消息
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// mockPlatform 模拟ThingsPanel平台：HTTP接口返回固定设备配置，MQTT订阅插件发布的全部设备消息
+type mockPlatform struct {
+	server   *httptest.Server
+	messages chan message
+
+	mu      sync.Mutex
+	lookups []string // 插件查询设备配置时带的设备编号
+}
+
+func newMockPlatform(t *testing.T, brokerURL string) *mockPlatform {
+	t.Helper()
+	p := &mockPlatform{messages: make(chan message, 64)}
+	p.server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	t.Cleanup(p.server.Close)
+
+	sub := mqtt.NewClient(mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID("tp-platform-e2e").
+		SetConnectTimeout(5 * time.Second))
+	if token := sub.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("模拟平台连接MQTT broker失败: %v", token.Error())
+	}
+	t.Cleanup(func() { sub.Disconnect(250) })
+	token := sub.Subscribe("devices/#", 1, func(_ mqtt.Client, m mqtt.Message) {
+		p.messages <- message{topic: m.Topic(), payload: m.Payload()}
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("模拟平台订阅失败: %v", token.Error())
+	}
+	return p
+}
+
+// serveHTTP 设备配置接口返回固定设备，其余接口返回成功
+func (p *mockPlatform) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path != "/api/v1/plugin/device/config" {
+		w.Write([]byte(`{"code":200,"message":"success"}`))
+		return
+	}
+	var req struct {
+		DeviceNumber string `json:"device_number"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	p.mu.Lock()
+	p.lookups = append(p.lookups, req.DeviceNumber)
+	p.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    200,
+		"message": "success",
+		"data": map[string]interface{}{
+			"id":            deviceID,
+			"device_number": req.DeviceNumber,
+			"device_type":   "1",
+			"protocol_type": "ESP32",
+		},
+	})
+}
+
+// lookedUp 插件是否查询过设备配置
+func (p *mockPlatform) lookedUp(number string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, n := range p.lookups {
+		if n == number {
+			return true
+		}
+	}
+	return false
+}
+
+// wait 等待指定主题的消息，跳过其他主题
+func (p *mockPlatform) wait(t *testing.T, topic string) message {
+	t.Helper()
+	deadline := time.After(waitTimeout)
+	for {
+		select {
+		case m := <-p.messages:
+			if m.topic == topic {
+				return m
+			}
+		case <-deadline:
+			t.Fatalf("等待主题 %s 超时", topic)
+		}
+	}
+}
+
+// recordingTransport 记录插件发往小智服务端的请求后交给夹具模拟
+type recordingTransport struct {
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	requests map[string][]byte // 请求路径 -> 最近一次的请求体
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	rt.mu.Lock()
+	rt.requests[req.URL.Path] = body
+	rt.mu.Unlock()
+	return rt.next.RoundTrip(req)
+}
+
+func (rt *recordingTransport) request(path string) ([]byte, bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	body, ok := rt.requests[path]
+	return body, ok
+}
+
+// pluginResponse 插件接口的通用响应
+type pluginResponse struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func decodeResponse(t *testing.T, resp *http.Response, err error) pluginResponse {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var rsp pluginResponse
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		t.Fatalf("解析响应失败: %v, body=%s", err, body)
+	}
+	if rsp.Code != 200 {
+		t.Fatalf("响应码为 %d，期望 200: %s", rsp.Code, body)
+	}
+	return rsp
+}
+
+// getJSON 发送GET请求，响应码不为200时测试失败
+func getJSON(t *testing.T, url string) pluginResponse {
+	t.Helper()
+	resp, err := http.Get(url)
+	return decodeResponse(t, resp, err)
+}
+
+// postJSON 以JSON请求体发送POST请求，响应码不为200时测试失败
+func postJSON(t *testing.T, url string, v interface{}, header http.Header) pluginResponse {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := http.DefaultClient.Do(req)
+	return decodeResponse(t, resp, err)
+}
+
+// chdir 切换到插件工作目录，表单文件按该目录解析，测试结束时恢复
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("切换工作目录失败: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestEndToEnd(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	if testing.Verbose() {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	chdir(t, filepath.Join("..", "..", "cmd"))
+
+	b := startBroker(t)
+	tp := newMockPlatform(t, b.URL())
+
+	platformClient, err := platform.NewPlatformClient(platform.Config{
+		BaseURL:    tp.server.URL,
+		MQTTBroker: b.URL(),
+	}, logger)
+	if err != nil {
+		t.Fatalf("创建平台客户端失败: %v", err)
+	}
+	t.Cleanup(platformClient.Close)
+	// NewPlatformClient 收到 CONNACK 后返回，SDK 随后在连接回调中标记已连接；该标记未加锁，
+	// 轮询 IsConnected 在 -race 下会报告SDK内部的数据竞争，首次发布前的表单、设备列表和绑定步骤已足够回调完成
+
+	mock, err := upstream.NewMockTransport(filepath.Join("..", "configs", "mock"), logger)
+	if err != nil {
+		t.Fatalf("创建小智模拟服务失败: %v", err)
+	}
+	xiaozhi := &recordingTransport{next: mock, requests: make(map[string][]byte)}
+	st, err := store.Open(filepath.Join(t.TempDir(), "plugin.db"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	h, err := handler.NewHTTPHandler(handler.Config{UpstreamTransport: xiaozhi, Store: st}, platformClient, logger)
+	if err != nil {
+		t.Fatalf("创建HTTP处理器失败: %v", err)
+	}
+
+	// 与 cmd/main.go 相同的路由，不含令牌鉴权等中间件
+	mux := http.NewServeMux()
+	mux.Handle("/", h)
+	mux.Handle(h.RoutePath("/admin/"), h.AdminHandler())
+	callbacks := h.CallbackHandler()
+	for _, p := range []string{"/v1/", "/events", "/bind-result"} {
+		mux.Handle(h.RoutePath(p), callbacks)
+	}
+	plugin := httptest.NewServer(mux)
+	t.Cleanup(plugin.Close)
+
+	step := func(name string, fn func(t *testing.T)) {
+		if !t.Run(name, fn) {
+			t.FailNow()
+		}
+	}
+
+	step("form", func(t *testing.T) {
+		getJSON(t, plugin.URL+"/api/v1/form/config?protocol_type=ESP32&device_type=1&form_type=VCR")
+	})
+
+	// 平台绑定设备前拉取设备列表，插件同时记录租户凭证
+	step("device_list", func(t *testing.T) {
+		q := url.Values{
+			"voucher":            {voucher},
+			"service_identifier": {"ESP32"},
+			"page":               {"1"},
+			"page_size":          {"10"},
+		}
+		rsp := getJSON(t, plugin.URL+"/api/v1/plugin/device/list?"+q.Encode())
+		var data struct {
+			Total int `json:"total"`
+			List  []struct {
+				DeviceNumber string `json:"device_number"`
+			} `json:"list"`
+		}
+		if err := json.Unmarshal(rsp.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Total == 0 || len(data.List) == 0 || data.List[0].DeviceNumber != deviceNumber {
+			t.Fatalf("设备列表不符: %s", rsp.Data)
+		}
+	})
+
+	step("bind", func(t *testing.T) {
+		postJSON(t, plugin.URL+"/admin/devices/bind", handler.BindRequest{
+			Voucher:      voucher,
+			DeviceNumber: deviceNumber,
+			AgentID:      agentID,
+			Code:         "123456",
+		}, nil)
+		body, ok := xiaozhi.request("/xiaozhi/device/bind")
+		if !ok {
+			t.Fatal("小智服务端未收到绑定请求")
+		}
+		var req upstream.DeviceBindRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("解析绑定请求失败: %v", err)
+		}
+		if req.DeviceNumber != deviceNumber || req.AgentID != agentID {
+			t.Fatalf("绑定请求不符: %s", body)
+		}
+	})
+
+	// 小智服务端回调设备事件，x-token 为凭证中的 Secret
+	callback := func(t *testing.T, event handler.CallbackEvent) {
+		t.Helper()
+		rsp := postJSON(t, plugin.URL+"/v1/events", handler.CallbackEvents{Events: []handler.CallbackEvent{event}},
+			http.Header{"X-Token": {secret}})
+		var result struct {
+			Accepted int      `json:"accepted"`
+			Errors   []string `json:"errors"`
+		}
+		if err := json.Unmarshal(rsp.Data, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Accepted != 1 {
+			t.Fatalf("回调事件未处理: %v", result.Errors)
+		}
+	}
+
+	step("online", func(t *testing.T) {
+		callback(t, handler.CallbackEvent{Type: handler.EventDeviceOnline, DeviceNumber: deviceNumber})
+		if m := tp.wait(t, "devices/status/"+deviceID); string(m.payload) != "1" {
+			t.Fatalf("上线状态负载不符: %s", m.payload)
+		}
+		if !tp.lookedUp(deviceNumber) {
+			t.Fatal("平台未收到设备配置查询")
+		}
+	})
+
+	step("telemetry", func(t *testing.T) {
+		callback(t, handler.CallbackEvent{
+			Type:         handler.EventTelemetry,
+			DeviceNumber: deviceNumber,
+			Data:         map[string]interface{}{"volume": 60, "battery": 87.5},
+		})
+		m := tp.wait(t, "devices/telemetry")
+		var msg struct {
+			DeviceID string `json:"device_id"`
+			Values   string `json:"values"`
+		}
+		if err := json.Unmarshal(m.payload, &msg); err != nil {
+			t.Fatalf("解析遥测消息失败: %v", err)
+		}
+		if msg.DeviceID != deviceID {
+			t.Fatalf("device_id不符: %s", msg.DeviceID)
+		}
+		raw, err := base64.StdEncoding.DecodeString(msg.Values)
+		if err != nil {
+			t.Fatalf("values不是base64: %v", err)
+		}
+		var got map[string]float64
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("解析values失败: %v", err)
+		}
+		if got["volume"] != 60 || got["battery"] != 87.5 {
+			t.Fatalf("values不符: %s", raw)
+		}
+	})
+
+	// 平台通知设备断开
+	step("disconnect", func(t *testing.T) {
+		postJSON(t, plugin.URL+"/api/v1/device/disconnect", map[string]string{"device_id": deviceID}, nil)
+		if m := tp.wait(t, "devices/status/"+deviceID); string(m.payload) != "0" {
+			t.Fatalf("离线状态负载不符: %s", m.payload)
+		}
+	})
+}
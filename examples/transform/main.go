@@ -0,0 +1,28 @@
+// 消息转换插件示例
+// 编译: go build -buildmode=plugin -o acme.so ./examples/transform
+// 插件须与主程序使用相同的Go版本和依赖版本编译，在 config.yaml 的 transform.plugins 中按凭证启用
+package main
+
+import "fmt"
+
+// Transform 将客户固件上报的摄氏度(temp_c)转换为平台物模型中的 temperature，下发配置时反向转换。
+// values 是插件主程序复制出的数据，可直接修改后返回；不要保存 values 或返回值供之后的调用使用
+func Transform(direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+	switch direction {
+	case "uplink":
+		if v, ok := values["temp_c"]; ok {
+			values["temperature"] = v
+			delete(values, "temp_c")
+		}
+	case "downlink":
+		if v, ok := values["temperature"]; ok {
+			values["temp_c"] = v
+			delete(values, "temperature")
+		}
+	default:
+		return nil, fmt.Errorf("unknown direction: %s", direction)
+	}
+	return values, nil
+}
+
+func main() {}
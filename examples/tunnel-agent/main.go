@@ -0,0 +1,34 @@
+// 反向隧道连接器示例，部署在内网小智服务端一侧，主动连接云端插件
+// 运行: go run ./examples/tunnel-agent -url wss://plugin.example.com/tunnel -name factory-a -token xxx -target http://127.0.0.1:8002
+// 插件侧在 config.yaml 的 tunnel.agents 中配置同名隧道，服务接入点凭证的 ServerURL 填写 tunnel://factory-a/xiaozhi
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"tp-plugin/internal/tunnel"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	c := &tunnel.Connector{Logger: logrus.StandardLogger()}
+	flag.StringVar(&c.URL, "url", "ws://127.0.0.1:9999/tunnel", "插件隧道入口")
+	flag.StringVar(&c.Name, "name", "", "隧道名称")
+	flag.StringVar(&c.Token, "token", "", "隧道令牌")
+	flag.StringVar(&c.Target, "target", "http://127.0.0.1:8002", "本地小智服务的主机地址")
+	flag.Parse()
+	if c.Name == "" || c.Token == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := c.Run(ctx); err != nil && ctx.Err() == nil {
+		logrus.WithError(err).Fatal("隧道连接器退出")
+	}
+}
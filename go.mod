@@ -2,7 +2,12 @@ module tp-plugin
 
 go 1.22
 
-require gopkg.in/natefinch/lumberjack.v2 v2.2.1
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/yuin/gopher-lua v1.1.1
+	go.etcd.io/bbolt v1.3.11
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+)
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
@@ -16,10 +21,10 @@ require (
 )
 
 require (
-	github.com/eclipse/paho.mqtt.golang v1.4.3 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/gorilla/websocket v1.5.3
 	golang.org/x/net v0.26.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sync v0.7.0
 )
 
 require (
@@ -0,0 +1,274 @@
+// internal/anomaly/anomaly.go
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检测方法
+const (
+	MethodZScore = "zscore" // 最近 Window 个样本的均值和标准差
+	MethodEWMA   = "ewma"   // 指数加权移动平均和方差，对缓慢漂移更平滑
+)
+
+// 偏离方向
+const (
+	DirectionBoth = "both"
+	DirectionUp   = "up"   // 只检测突增，如温度骤升
+	DirectionDown = "down" // 只检测骤降，如电量断崖
+)
+
+// 规则参数默认值
+const (
+	DefaultThreshold  = 3.0
+	DefaultAlpha      = 0.1
+	DefaultWindow     = 60
+	DefaultMinSamples = 20
+	DefaultCooldown   = 5 * time.Minute
+	minStd            = 1e-9 // 标准差下限，避免恒定数据被极小波动触发
+)
+
+// Rule 一条检测规则
+type Rule struct {
+	Key             string   // 遥测字段
+	DeviceTypes     []string // 只检测这些设备类型，为空时不限制
+	Method          string   // zscore/ewma，为空时使用 zscore
+	Threshold       float64  // 偏离均值超过多少个标准差视为异常，0使用默认值3
+	Alpha           float64  // EWMA平滑系数(0,1]，0使用默认值0.1
+	Window          int      // zscore 窗口样本数，0使用默认值60
+	MinSamples      int      // 样本数不足时不检测，0使用默认值20
+	Direction       string   // both/up/down，为空时为 both
+	MinDelta        float64  // 与均值的绝对差不超过该值时不视为异常，用于忽略量化噪声
+	CooldownSeconds int      // 同一设备同一字段两次告警的最小间隔，0使用默认值300
+}
+
+// Anomaly 一次检测到的异常
+type Anomaly struct {
+	Key       string  `json:"key"`
+	Value     float64 `json:"value"`
+	Mean      float64 `json:"mean"`
+	Std       float64 `json:"std"`
+	Score     float64 `json:"score"` // 偏离的标准差倍数，骤降为负
+	Method    string  `json:"method"`
+	Threshold float64 `json:"threshold"`
+}
+
+// rule 校验并补全默认值后的规则
+type rule struct {
+	Rule
+	types    map[string]bool
+	cooldown time.Duration
+}
+
+// state 单台设备单个字段的统计
+type state struct {
+	n        int
+	mean     float64
+	variance float64
+	window   []float64 // zscore 环形缓冲
+	next     int
+	lastHit  time.Time
+}
+
+// Detector 按设备和字段维护统计并检测偏离，在插件本地运行，不占用平台规则引擎
+type Detector struct {
+	rules  []rule
+	mu     sync.Mutex
+	states map[string]*state // 设备ID|规则序号 → 统计
+}
+
+// New 校验规则并创建检测器，未配置规则时返回nil
+func New(rules []Rule) (*Detector, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	d := &Detector{states: make(map[string]*state)}
+	for i, r := range rules {
+		if r.Key == "" {
+			return nil, fmt.Errorf("第%d条异常检测规则缺少 key", i+1)
+		}
+		switch r.Method {
+		case "":
+			r.Method = MethodZScore
+		case MethodZScore, MethodEWMA:
+		default:
+			return nil, fmt.Errorf("异常检测规则 %s 的方法不支持: %s", r.Key, r.Method)
+		}
+		switch r.Direction {
+		case "":
+			r.Direction = DirectionBoth
+		case DirectionBoth, DirectionUp, DirectionDown:
+		default:
+			return nil, fmt.Errorf("异常检测规则 %s 的方向不支持: %s", r.Key, r.Direction)
+		}
+		if r.Threshold <= 0 {
+			r.Threshold = DefaultThreshold
+		}
+		if r.Alpha <= 0 || r.Alpha > 1 {
+			r.Alpha = DefaultAlpha
+		}
+		if r.Window <= 0 {
+			r.Window = DefaultWindow
+		}
+		if r.MinSamples <= 0 {
+			r.MinSamples = DefaultMinSamples
+		}
+		if r.Method == MethodZScore && r.MinSamples > r.Window {
+			r.MinSamples = r.Window
+		}
+		cr := rule{Rule: r, types: make(map[string]bool), cooldown: DefaultCooldown}
+		for _, t := range r.DeviceTypes {
+			cr.types[t] = true
+		}
+		if r.CooldownSeconds > 0 {
+			cr.cooldown = time.Duration(r.CooldownSeconds) * time.Second
+		}
+		d.rules = append(d.rules, cr)
+	}
+	return d, nil
+}
+
+// Observe 用本次遥测更新统计并返回检测到的异常；以更新前的统计判断，异常样本同样计入统计，
+// 数值持续处于新水平时统计会逐渐适应，不会一直告警。d 为nil时不检测
+func (d *Detector) Observe(deviceID, deviceType string, values map[string]interface{}, now time.Time) []Anomaly {
+	if d == nil {
+		return nil
+	}
+	var found []Anomaly
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.rules {
+		r := &d.rules[i]
+		if len(r.types) > 0 && !r.types[deviceType] {
+			continue
+		}
+		x, ok := number(values[r.Key])
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s|%d", deviceID, i)
+		s := d.states[key]
+		if s == nil {
+			s = &state{}
+			if r.Method == MethodZScore {
+				s.window = make([]float64, 0, r.Window)
+			}
+			d.states[key] = s
+		}
+		if a, ok := r.check(s, x, now); ok {
+			found = append(found, a)
+		}
+		r.update(s, x)
+	}
+	return found
+}
+
+// check 按当前统计判断样本是否异常
+func (r *rule) check(s *state, x float64, now time.Time) (Anomaly, bool) {
+	if s.n < r.MinSamples {
+		return Anomaly{}, false
+	}
+	std := math.Sqrt(s.variance)
+	delta := x - s.mean
+	if math.Abs(delta) <= r.MinDelta {
+		return Anomaly{}, false
+	}
+	score := delta / math.Max(std, minStd)
+	switch r.Direction {
+	case DirectionUp:
+		if score < r.Threshold {
+			return Anomaly{}, false
+		}
+	case DirectionDown:
+		if score > -r.Threshold {
+			return Anomaly{}, false
+		}
+	default:
+		if math.Abs(score) < r.Threshold {
+			return Anomaly{}, false
+		}
+	}
+	if now.Sub(s.lastHit) < r.cooldown {
+		return Anomaly{}, false
+	}
+	s.lastHit = now
+	return Anomaly{
+		Key:       r.Key,
+		Value:     x,
+		Mean:      round(s.mean),
+		Std:       round(std),
+		Score:     round(score),
+		Method:    r.Method,
+		Threshold: r.Threshold,
+	}, true
+}
+
+// update 将样本计入统计
+func (r *rule) update(s *state, x float64) {
+	s.n++
+	if r.Method == MethodEWMA {
+		if s.n == 1 {
+			s.mean, s.variance = x, 0
+			return
+		}
+		delta := x - s.mean
+		s.mean += r.Alpha * delta
+		s.variance = (1 - r.Alpha) * (s.variance + r.Alpha*delta*delta)
+		return
+	}
+	if len(s.window) < r.Window {
+		s.window = append(s.window, x)
+	} else {
+		s.window[s.next] = x
+		s.next = (s.next + 1) % r.Window
+	}
+	var sum float64
+	for _, v := range s.window {
+		sum += v
+	}
+	s.mean = sum / float64(len(s.window))
+	var sq float64
+	for _, v := range s.window {
+		sq += (v - s.mean) * (v - s.mean)
+	}
+	s.variance = sq / float64(len(s.window))
+}
+
+// Forget 清除设备的统计，设备更换传感器或重新部署后调用
+func (d *Detector) Forget(deviceID string) {
+	if d == nil {
+		return
+	}
+	prefix := deviceID + "|"
+	d.mu.Lock()
+	for k := range d.states {
+		if strings.HasPrefix(k, prefix) {
+			delete(d.states, k)
+		}
+	}
+	d.mu.Unlock()
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, !math.IsNaN(n) && !math.IsInf(n, 0)
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,219 @@
+// internal/audit/audit.go
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var auditRecords = metrics.NewCounterVec("tp_plugin_audit_records_total",
+	"写入审计日志的出站变更调用数", "result")
+
+// Config 审计日志配置
+type Config struct {
+	Enabled    bool
+	FilePath   string // 审计日志文件，为空使用 logs/audit.log
+	Key        string // 签名密钥(HMAC-SHA256)，启用时必填，核验时需使用同一密钥
+	MaxSize    int    // 单个文件最大MB
+	MaxBackups int
+}
+
+// Record 一次变更类出站调用(绑定、解绑、下发命令)的审计记录。请求体只记录摘要，不保存凭证等内容；
+// Prev 为上一条记录的签名，删除或篡改任意一条都会使其后的链校验失败
+type Record struct {
+	Seq            uint64    `json:"seq"`
+	Time           time.Time `json:"ts"`
+	Actor          string    `json:"actor"` // 触发者，如 admin:<令牌名称>、platform、pipeline:<运行ID>、broadcast:<广播ID>
+	RequestID      string    `json:"request_id,omitempty"`
+	Tenant         string    `json:"tenant"` // 凭证摘要
+	ServerURL      string    `json:"server_url"`
+	Path           string    `json:"path"`
+	DeviceNumber   string    `json:"device_number,omitempty"`
+	BodySHA256     string    `json:"body_sha256"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	Status         int       `json:"status"` // 小智服务端HTTP状态码，未收到响应时为0
+	Error          string    `json:"error,omitempty"`
+	Changes        []string  `json:"changes,omitempty"` // 配置修改类记录中变化的字段
+	Prev           string    `json:"prev"`
+	Signature      string    `json:"sig"`
+}
+
+// Logger 以JSON行写入签名审计记录，为nil时不记录
+type Logger struct {
+	mu     sync.Mutex
+	key    []byte
+	file   *lumberjack.Logger
+	seq    uint64
+	prev   string
+	logger *logrus.Logger
+}
+
+// New 创建审计日志，未启用时返回nil；从现有文件的最后一条记录接续序号和签名链
+func New(cfg Config, logger *logrus.Logger) (*Logger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Key == "" {
+		return nil, errors.New("审计日志需配置签名密钥 key")
+	}
+	if cfg.FilePath == "" {
+		cfg.FilePath = "logs/audit.log"
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 20
+	}
+	l := &Logger{
+		key:    []byte(cfg.Key),
+		file:   &lumberjack.Logger{Filename: cfg.FilePath, MaxSize: cfg.MaxSize, MaxBackups: cfg.MaxBackups},
+		logger: logger,
+	}
+	last, err := lastRecord(cfg.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("读取审计日志失败: %v", err)
+	}
+	if last != nil {
+		l.seq, l.prev = last.Seq, last.Signature
+	}
+	return l, nil
+}
+
+// lastRecord 返回文件中的最后一条记录，文件不存在或为空时返回nil
+func lastRecord(path string) (*Record, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+	var r Record
+	if err := json.Unmarshal(last, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// sign 计算记录(不含签名字段)的 HMAC-SHA256
+func sign(key []byte, r Record) (string, error) {
+	r.Signature = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Record 补全序号、时间和签名链后写入一条记录
+func (l *Logger) Record(r Record) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Seq = l.seq + 1
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+	r.Prev = l.prev
+	sig, err := sign(l.key, r)
+	if err == nil {
+		r.Signature = sig
+		var data []byte
+		if data, err = json.Marshal(r); err == nil {
+			_, err = l.file.Write(append(data, '\n'))
+		}
+	}
+	if err != nil {
+		auditRecords.WithLabelValues("error").Inc()
+		l.logger.WithError(err).WithField("path", r.Path).Error("写入审计日志失败")
+		return
+	}
+	l.seq, l.prev = r.Seq, r.Signature
+	auditRecords.WithLabelValues("ok").Inc()
+}
+
+// Close 关闭审计日志文件
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Verify 逐条校验审计日志的签名和链，返回校验通过的记录数及最后一条记录的签名；
+// 多个轮转文件按时间先后核验时将上一个文件返回的签名作为 prev 传入，prev 为空时不校验第一条记录的 Prev
+func Verify(r io.Reader, key []byte, prev string) (int, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	n, line := 0, 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return n, prev, fmt.Errorf("第%d行解析失败: %v", line, err)
+		}
+		want, err := sign(key, rec)
+		if err != nil {
+			return n, prev, err
+		}
+		if !hmac.Equal([]byte(want), []byte(rec.Signature)) {
+			return n, prev, fmt.Errorf("第%d行(seq=%d)签名不匹配，记录被篡改或密钥不正确", line, rec.Seq)
+		}
+		if (n > 0 || prev != "") && rec.Prev != prev {
+			return n, prev, fmt.Errorf("第%d行(seq=%d)与上一条记录不连续，可能有记录被删除", line, rec.Seq)
+		}
+		prev = rec.Signature
+		n++
+	}
+	return n, prev, scanner.Err()
+}
+
+type actorKey struct{}
+
+// WithActor 在 context 中指定审计记录的触发者，后台任务(广播、流水线)发起调用前设置
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext 返回 WithActor 设置的触发者
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok && actor != ""
+}
@@ -0,0 +1,272 @@
+package audit
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testKey = "audit-key"
+
+func newTestLogger(t *testing.T, path string) *Logger {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	l, err := New(Config{Enabled: true, FilePath: path, Key: testKey}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// writeRecords 依次写入 n 条解绑记录
+func writeRecords(l *Logger, n int) {
+	for i := 0; i < n; i++ {
+		l.Record(Record{Actor: "platform", Path: "/device/unbind", DeviceNumber: "A4:CF:12:00:00:01", Status: 200})
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func verifyLines(lines []string, prev string) (int, string, error) {
+	return Verify(strings.NewReader(strings.Join(lines, "\n")+"\n"), []byte(testKey), prev)
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	cases := []struct {
+		name    string
+		records []Record
+	}{
+		{"empty", nil},
+		{"single", []Record{{Actor: "admin:ops", Path: "/device/bind", DeviceNumber: "A4:CF:12:00:00:01", Status: 200}}},
+		{"chain", []Record{
+			{Actor: "platform", Path: "/device/bind", Status: 200},
+			{Actor: "pipeline:r1", Path: "/device/command", Status: 0, Error: "timeout"},
+			{Actor: "admin", Path: "/service/config", Changes: []string{"server_url"}, Time: time.Date(2024, 1, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			l := newTestLogger(t, path)
+			for _, r := range c.records {
+				l.Record(r)
+			}
+			l.Close()
+
+			f, err := os.Open(path)
+			if os.IsNotExist(err) && len(c.records) == 0 {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			n, last, err := Verify(f, []byte(testKey), "")
+			if err != nil || n != len(c.records) || last != l.prev {
+				t.Fatalf("校验 %d 条, %v，期望 %d 条", n, err, len(c.records))
+			}
+			rec, err := lastRecord(path)
+			if err != nil || rec.Seq != uint64(len(c.records)) || rec.Time.Location() != time.UTC {
+				t.Fatalf("最后一条记录 %+v, %v", rec, err)
+			}
+		})
+	}
+}
+
+func TestNewContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := newTestLogger(t, path)
+	writeRecords(l, 2)
+	l.Close()
+
+	// 重启后从最后一条记录接续序号和签名链
+	l = newTestLogger(t, path)
+	if l.seq != 2 {
+		t.Fatalf("接续的序号 %d", l.seq)
+	}
+	writeRecords(l, 1)
+	l.Close()
+
+	lines := readLines(t, path)
+	if n, _, err := verifyLines(lines, ""); err != nil || n != 3 {
+		t.Fatalf("重启后校验 %d 条, %v", n, err)
+	}
+	if rec, _ := lastRecord(path); rec.Seq != 3 {
+		t.Fatalf("重启后写入的序号 %d", rec.Seq)
+	}
+}
+
+func TestLastRecord(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name    string
+		content *string
+		seq     uint64 // 0 表示无记录
+		wantErr bool
+	}{
+		{"missing", nil, 0, false},
+		{"empty", strPtr(""), 0, false},
+		{"blank lines", strPtr("\n  \n"), 0, false},
+		{"trailing blank", strPtr(`{"seq":1,"sig":"a"}` + "\n" + `{"seq":2,"sig":"b"}` + "\n\n"), 2, false},
+		{"corrupt last", strPtr(`{"seq":1,"sig":"a"}` + "\n" + `{"seq":2,`), 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, c.name+".log")
+			if c.content != nil {
+				if err := os.WriteFile(path, []byte(*c.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rec, err := lastRecord(path)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("错误 %v", err)
+			}
+			if c.seq == 0 && rec != nil || c.seq != 0 && (rec == nil || rec.Seq != c.seq) {
+				t.Fatalf("最后一条记录 %+v，期望 seq %d", rec, c.seq)
+			}
+		})
+	}
+	// 最后一行无法解析时拒绝启动，避免签名链从头开始
+	if _, err := New(Config{Enabled: true, FilePath: filepath.Join(dir, "corrupt last.log"), Key: testKey}, logrus.New()); err == nil {
+		t.Fatal("最后一条记录损坏时 New 未报错")
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := newTestLogger(t, path)
+	writeRecords(l, 4)
+	l.Close()
+	lines := readLines(t, path)
+
+	cases := []struct {
+		name   string
+		edit   func([]string) []string
+		key    string
+		wantN  int
+		errSub string
+	}{
+		{"modified record", func(ls []string) []string {
+			ls[1] = strings.Replace(ls[1], `"status":200`, `"status":500`, 1)
+			return ls
+		}, testKey, 1, "签名不匹配"},
+		{"deleted middle record", func(ls []string) []string {
+			return append(ls[:1], ls[2:]...)
+		}, testKey, 1, "不连续"},
+		{"reordered records", func(ls []string) []string {
+			ls[1], ls[2] = ls[2], ls[1]
+			return ls
+		}, testKey, 1, "不连续"},
+		{"malformed line", func(ls []string) []string {
+			ls[3] = ls[3][:len(ls[3])/2]
+			return ls
+		}, testKey, 3, "解析失败"},
+		{"wrong key", func(ls []string) []string { return ls }, "other-key", 0, "签名不匹配"},
+		{"deleted first record", func(ls []string) []string { return ls[1:] }, testKey, 3, ""}, // 单个文件无法发现删除开头的记录，需结合轮转文件核验
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			edited := c.edit(append([]string(nil), lines...))
+			n, _, err := Verify(strings.NewReader(strings.Join(edited, "\n")), []byte(c.key), "")
+			if n != c.wantN {
+				t.Fatalf("校验通过 %d 条，期望 %d 条", n, c.wantN)
+			}
+			if c.errSub == "" && err != nil || c.errSub != "" && (err == nil || !strings.Contains(err.Error(), c.errSub)) {
+				t.Fatalf("错误 %v，期望包含 %q", err, c.errSub)
+			}
+		})
+	}
+}
+
+func TestVerifyAcrossRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	l := newTestLogger(t, path)
+	writeRecords(l, 2)
+	for i := 0; i < 2; i++ {
+		// 轮转文件名精确到毫秒
+		time.Sleep(2 * time.Millisecond)
+		if err := l.file.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		writeRecords(l, 2)
+	}
+	l.Close()
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "audit-*.log"))
+	sort.Strings(backups)
+	if len(backups) != 2 {
+		t.Fatalf("轮转文件 %v", backups)
+	}
+	files := append(backups, path)
+
+	verifyFiles := func(files []string) (int, error) {
+		var prev string
+		total := 0
+		for _, name := range files {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, last, err := Verify(bytes.NewReader(data), []byte(testKey), prev)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			prev = last
+		}
+		return total, nil
+	}
+
+	cases := []struct {
+		name    string
+		files   []string
+		wantN   int
+		wantErr bool
+	}{
+		{"all files in order", files, 6, false},
+		{"current file only", files[2:], 2, false},
+		{"missing middle file", []string{files[0], files[2]}, 2, true},
+		{"wrong order", []string{files[1], files[0], files[2]}, 2, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n, err := verifyFiles(c.files)
+			if n != c.wantN || (err != nil) != c.wantErr {
+				t.Fatalf("校验通过 %d 条, %v，期望 %d 条", n, err, c.wantN)
+			}
+		})
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	l, err := New(Config{}, logrus.New())
+	if l != nil || err != nil {
+		t.Fatalf("未启用时 New 返回 %v, %v", l, err)
+	}
+	l.Record(Record{Path: "/device/bind"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(Config{Enabled: true}, logrus.New()); err == nil {
+		t.Fatal("未配置密钥时未报错")
+	}
+}
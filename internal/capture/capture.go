@@ -0,0 +1,283 @@
+// internal/capture/capture.go
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MQTT流量方向
+const (
+	Publish   = "publish"   // 插件发布到平台
+	Subscribe = "subscribe" // 插件订阅主题
+	Receive   = "receive"   // 收到平台下发的消息
+)
+
+// 默认限制
+const (
+	DefaultMaxBytes    = 10 << 20
+	DefaultMaxDuration = 30 * time.Minute
+)
+
+var captureRecords = metrics.NewCounterVec("tp_plugin_mqtt_capture_records_total",
+	"写入抓包文件的MQTT消息数", "direction")
+
+// ErrActive 已有进行中的抓包
+var ErrActive = errors.New("已有进行中的抓包，请先停止")
+
+// Config 抓包配置
+type Config struct {
+	Dir         string        // 抓包文件目录，默认 logs/capture
+	MaxBytes    int64         // 单次抓包文件大小上限，默认10MB
+	MaxDuration time.Duration // 单次抓包最长时长，默认30分钟
+}
+
+// Filter 抓包条件，设备ID和主题模式至少填写一项，同时填写时需同时满足
+type Filter struct {
+	DeviceID string `json:"device_id,omitempty"` // 主题中含该设备ID，或消息内容中含该设备ID
+	Topic    string `json:"topic,omitempty"`     // MQTT主题模式，支持 + 和 # 通配符
+}
+
+// Status 抓包状态
+type Status struct {
+	Filter
+	File      string    `json:"file"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxBytes  int64     `json:"max_bytes"`
+	Bytes     int64     `json:"bytes"`
+	Records   int64     `json:"records"`
+	Reason    string    `json:"reason,omitempty"` // 结束原因: manual/expired/size/shutdown
+}
+
+// record 抓包文件中的一条记录(JSON行)
+type record struct {
+	Time      time.Time   `json:"ts"`
+	Direction string      `json:"direction"`
+	Topic     string      `json:"topic"`
+	Payload   interface{} `json:"payload,omitempty"`
+}
+
+// Capturer 按设备或主题模式将插件收发的MQTT消息写入抓包文件，同一时间只有一次抓包，
+// 达到时长或大小上限时自动停止；为nil或未开始抓包时 Record 只有一次原子读的开销
+type Capturer struct {
+	cfg    Config
+	logger *logrus.Logger
+	active atomic.Bool
+	mu     sync.Mutex
+	status Status
+	last   *Status // 最近一次结束的抓包
+	f      *os.File
+	timer  *time.Timer
+}
+
+// New 创建抓包器
+func New(cfg Config, logger *logrus.Logger) *Capturer {
+	if cfg.Dir == "" {
+		cfg.Dir = "logs/capture"
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = DefaultMaxDuration
+	}
+	return &Capturer{cfg: cfg, logger: logger}
+}
+
+// Start 开始抓包；d 和 maxBytes 为0或超过配置上限时按上限处理
+func (c *Capturer) Start(filter Filter, d time.Duration, maxBytes int64) (Status, error) {
+	if c == nil {
+		return Status{}, errors.New("MQTT抓包未启用")
+	}
+	if filter.DeviceID == "" && filter.Topic == "" {
+		return Status{}, errors.New("设备ID和主题模式至少填写一项")
+	}
+	if d <= 0 || d > c.cfg.MaxDuration {
+		d = c.cfg.MaxDuration
+	}
+	if maxBytes <= 0 || maxBytes > c.cfg.MaxBytes {
+		maxBytes = c.cfg.MaxBytes
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f != nil {
+		return c.status, ErrActive
+	}
+	if err := os.MkdirAll(c.cfg.Dir, 0755); err != nil {
+		return Status{}, fmt.Errorf("创建抓包目录失败: %v", err)
+	}
+	now := time.Now()
+	path := filepath.Join(c.cfg.Dir, fmt.Sprintf("mqtt-%s.jsonl", now.Format("20060102-150405")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return Status{}, fmt.Errorf("创建抓包文件失败: %v", err)
+	}
+	c.f = f
+	c.status = Status{Filter: filter, File: path, StartedAt: now, ExpiresAt: now.Add(d), MaxBytes: maxBytes}
+	c.timer = time.AfterFunc(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.f == f {
+			c.stopLocked("expired")
+		}
+	})
+	c.active.Store(true)
+	c.logger.WithFields(logrus.Fields{
+		"device_id":  filter.DeviceID,
+		"topic":      filter.Topic,
+		"file":       path,
+		"expires_at": c.status.ExpiresAt,
+		"max_bytes":  maxBytes,
+	}).Info("MQTT抓包已开始")
+	return c.status, nil
+}
+
+// Stop 停止抓包并返回结果，没有进行中的抓包时返回false
+func (c *Capturer) Stop(reason string) (Status, bool) {
+	if c == nil {
+		return Status{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return Status{}, false
+	}
+	c.stopLocked(reason)
+	return *c.last, true
+}
+
+func (c *Capturer) stopLocked(reason string) {
+	c.active.Store(false)
+	c.timer.Stop()
+	c.f.Close()
+	c.f = nil
+	st := c.status
+	st.Reason = reason
+	c.last = &st
+	c.logger.WithFields(logrus.Fields{
+		"file":    st.File,
+		"records": st.Records,
+		"bytes":   st.Bytes,
+		"reason":  reason,
+	}).Info("MQTT抓包已结束")
+}
+
+// Status 返回进行中的抓包，没有时返回最近一次结束的抓包
+func (c *Capturer) Status() (Status, bool) {
+	if c == nil {
+		return Status{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f != nil {
+		return c.status, true
+	}
+	if c.last != nil {
+		return *c.last, true
+	}
+	return Status{}, false
+}
+
+// Record 记录一条MQTT消息，不符合抓包条件时忽略；payload 为字符串或[]byte且是合法JSON时原样嵌入
+func (c *Capturer) Record(direction, topic string, payload interface{}) {
+	if c == nil || !c.active.Load() {
+		return
+	}
+	data := payloadBytes(payload)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil || !c.status.match(topic, data) {
+		return
+	}
+	line, err := json.Marshal(record{Time: time.Now(), Direction: direction, Topic: topic, Payload: rawPayload(data)})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if c.status.Bytes+int64(len(line)) > c.status.MaxBytes {
+		c.stopLocked("size")
+		return
+	}
+	if _, err := c.f.Write(line); err != nil {
+		c.logger.WithError(err).Warn("写入抓包文件失败")
+		c.stopLocked("error")
+		return
+	}
+	c.status.Bytes += int64(len(line))
+	c.status.Records++
+	captureRecords.WithLabelValues(direction).Inc()
+}
+
+// match 判断消息是否符合抓包条件
+func (f Filter) match(topic string, payload []byte) bool {
+	if f.Topic != "" && !MatchTopic(f.Topic, topic) {
+		return false
+	}
+	if f.DeviceID == "" {
+		return true
+	}
+	for _, seg := range strings.Split(topic, "/") {
+		if seg == f.DeviceID {
+			return true
+		}
+	}
+	return bytes.Contains(payload, []byte(f.DeviceID))
+}
+
+// MatchTopic 按MQTT通配符规则匹配主题：+ 匹配一级，# 匹配其后的任意级(须在末尾)
+func MatchTopic(pattern, topic string) bool {
+	ps, ts := strings.Split(pattern, "/"), strings.Split(topic, "/")
+	for i, p := range ps {
+		if p == "#" {
+			return i == len(ps)-1
+		}
+		if i >= len(ts) || (p != "+" && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+func payloadBytes(payload interface{}) []byte {
+	switch v := payload.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return []byte(fmt.Sprint(v))
+		}
+		return data
+	}
+}
+
+func rawPayload(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	return string(data)
+}
+
+// Close 停止进行中的抓包
+func (c *Capturer) Close() {
+	c.Stop("shutdown")
+}
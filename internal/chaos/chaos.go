@@ -0,0 +1,125 @@
+// internal/chaos/chaos.go
+package chaos
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 故障类型，用作指标标签
+const (
+	FaultLatency       = "latency"
+	FaultMQTTDrop      = "mqtt_drop"
+	FaultUpstreamError = "upstream_error"
+)
+
+// ErrPublishDropped 故障注入丢弃的MQTT发布
+var ErrPublishDropped = errors.New("chaos: mqtt publish dropped")
+
+var injected = metrics.NewCounterVec("tp_plugin_chaos_injected_total",
+	"Faults injected by chaos testing switches.", "fault")
+
+// Config 故障注入配置，比例均为百分比(0-100)
+type Config struct {
+	Enabled              bool
+	LatencyPercent       float64       // 注入延迟的比例
+	LatencyMin           time.Duration // 注入延迟的下限
+	LatencyMax           time.Duration // 注入延迟的上限
+	MQTTDropPercent      float64       // 丢弃MQTT发布的比例
+	UpstreamErrorPercent float64       // 小智服务端调用强制返回500的比例
+}
+
+// Injector 故障注入器，为nil时所有方法均不注入故障
+type Injector struct {
+	cfg    Config
+	logger *logrus.Logger
+}
+
+// New 创建故障注入器，未启用时返回nil
+func New(cfg Config, logger *logrus.Logger) *Injector {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.LatencyMax < cfg.LatencyMin {
+		cfg.LatencyMax = cfg.LatencyMin
+	}
+	return &Injector{cfg: cfg, logger: logger}
+}
+
+// hit 按百分比判定是否命中
+func hit(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// Delay 按配置比例阻塞一段随机时长
+func (i *Injector) Delay() {
+	if i == nil || !hit(i.cfg.LatencyPercent) {
+		return
+	}
+	d := i.cfg.LatencyMin
+	if span := i.cfg.LatencyMax - i.cfg.LatencyMin; span > 0 {
+		d += time.Duration(rand.Int63n(int64(span)))
+	}
+	injected.WithLabelValues(FaultLatency).Inc()
+	i.logger.WithField("delay", d).Debug("故障注入: 延迟")
+	time.Sleep(d)
+}
+
+// DropPublish 按配置比例判定是否丢弃本次MQTT发布
+func (i *Injector) DropPublish() bool {
+	if i == nil || !hit(i.cfg.MQTTDropPercent) {
+		return false
+	}
+	injected.WithLabelValues(FaultMQTTDrop).Inc()
+	i.logger.Debug("故障注入: 丢弃MQTT发布")
+	return true
+}
+
+// Transport 包装调用小智服务端的传输层，注入延迟及强制500响应；next 为nil时使用默认传输层
+func (i *Injector) Transport(next http.RoundTripper) http.RoundTripper {
+	if i == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{injector: i, next: next}
+}
+
+type transport struct {
+	injector *Injector
+	next     http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.injector.Delay()
+	if !hit(t.injector.cfg.UpstreamErrorPercent) {
+		return t.next.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	injected.WithLabelValues(FaultUpstreamError).Inc()
+	t.injector.logger.WithField("url", req.URL.String()).Debug("故障注入: 强制返回500")
+	body := []byte(`{"code":500,"msg":"chaos: injected upstream error"}`)
+	return &http.Response{
+		Status:        "500 Internal Server Error",
+		StatusCode:    http.StatusInternalServerError,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -2,24 +2,187 @@
 package config
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Platform PlatformConfig `yaml:"platform"`
-	Log      LogConfig      `yaml:"log"`
+	Profile     string            `yaml:"profile"`     // 运行模式: standard/lite
+	Environment EnvironmentConfig `yaml:"environment"` // 部署环境，区分接入同一平台的多套插件
+	Server      ServerConfig      `yaml:"server"`
+	Platform    PlatformConfig    `yaml:"platform"`
+	Log         LogConfig         `yaml:"log"`
+	Store       StoreConfig       `yaml:"store"`
+	Profiles    ProfileConfig     `yaml:"profiles"`
+	Pipeline    PipelineConfig    `yaml:"pipelines"`
+	Chaos       ChaosConfig       `yaml:"chaos"`
+	Client      ClientConfig      `yaml:"client"`
+	Upstream    UpstreamConfig    `yaml:"upstream"`
+	Trace       TraceConfig       `yaml:"trace"`
+	Transform   TransformConfig   `yaml:"transform"`
+	Scripts     ScriptConfig      `yaml:"scripts"`
+	Bundle      BundleConfig      `yaml:"bundle"`
+	Device      DeviceConfig      `yaml:"device"`
+	Forward     ForwardConfig     `yaml:"forward"`
+	Anomaly     AnomalyConfig     `yaml:"anomaly"`
+	Tunnel      TunnelConfig      `yaml:"tunnel"`
+	Audit       AuditConfig       `yaml:"audit"`
+	Notify      NotifyConfig      `yaml:"notifications"`
+	Broadcast   BroadcastConfig   `yaml:"broadcast"` // 批量下发命令
+	Standby     StandbyConfig     `yaml:"standby"`   // 主备部署
+	Downlink    DownlinkConfig    `yaml:"downlink"`  // 平台下发消息路由到设备WebSocket会话
+	Capture     CaptureConfig     `yaml:"capture"`   // MQTT抓包
+	OTA         OTAConfig         `yaml:"ota"`       // 固件升级
+	Startup     StartupConfig     `yaml:"startup"`   // 启动依赖(MQTT连接等)的后台重试
 }
 
 type ServerConfig struct {
-	Port             int `yaml:"port"`
-	HTTPPort         int `yaml:"http_port"`
-	MaxConnections   int `yaml:"maxConnections"`
-	HeartbeatTimeout int `yaml:"heartbeatTimeout"`
+	Port             int             `yaml:"port"`    // 设备WebSocket服务端口，0为不启用
+	WSPath           string          `yaml:"ws_path"` // 设备WebSocket连接路径，默认 /xiaozhi/v1/
+	HTTPPort         int             `yaml:"http_port"`
+	Listen           string          `yaml:"listen"`      // HTTP服务监听地址，为空时监听 http_port；unix:<路径> 或 systemd 套接字激活
+	SocketMode       string          `yaml:"socket_mode"` // Unix 套接字文件权限(八进制)，如 0660
+	Bind             []string        `yaml:"bind"`        // HTTP服务及设备WebSocket服务绑定的地址(IPv4/IPv6)，为空时监听全部网卡
+	Network          string          `yaml:"network"`     // tcp(IPv4/IPv6双栈，默认)/tcp4/tcp6
+	MaxConnections   int             `yaml:"maxConnections"`
+	HeartbeatTimeout int             `yaml:"heartbeatTimeout"`
+	PingInterval     int             `yaml:"ping_interval"`       // 设备WebSocket发送ping的间隔(秒)，0为心跳超时的一半
+	PongTimeout      int             `yaml:"pong_timeout"`        // 发送ping后等待应答的秒数，超时断开，0为只按心跳超时判断
+	MaxMessageKB     int             `yaml:"max_message_kb"`      // 设备WebSocket单条上行消息的大小上限(KB)，0使用默认值1024
+	ResumeTTL        int             `yaml:"resume_ttl"`          // 设备断线续连令牌在断开后的有效期(秒)，0为不启用
+	Throttle         ThrottleConfig  `yaml:"throttle"`            // 过载时要求设备降低上报频率
+	Locale           string          `yaml:"locale"`              // 默认语言(zh/en)，请求可通过lang参数或Accept-Language覆盖
+	ProtocolVersion  string          `yaml:"protocol_version"`    // 平台插件协议版本，默认v1
+	BasePath         string          `yaml:"base_path"`           // 路由前缀，如 /plugins/esp32
+	CORS             CORSConfig      `yaml:"cors"`                // 跨域配置
+	AdminTokens      []string        `yaml:"admin_tokens"`        // 管理接口访问令牌(Authorization: Bearer)，拥有全部权限
+	AdminScoped      []AdminToken    `yaml:"admin_scoped_tokens"` // 按权限范围区分的管理接口令牌
+	RateLimit        RateLimitConfig `yaml:"rate_limit"`          // 按客户端IP限流
+	DrainTimeout     int             `yaml:"drain_timeout"`       // 收到退出信号后等待进行中请求完成的秒数，超时强制退出，默认30
+	FormsDir         string          `yaml:"forms_dir"`           // 表单目录，其中的同名表单文件覆盖内置表单，为空只使用内置表单
+}
+
+// AdminToken 带权限范围的管理接口令牌
+type AdminToken struct {
+	Name  string `yaml:"name"`  // 令牌名称，用于日志审计
+	Token string `yaml:"token"` // 令牌原文
+	Scope string `yaml:"scope"` // read 只读 / operate 运维操作 / full 全部权限
+}
+
+type RateLimitConfig struct {
+	RPS   float64 `yaml:"rps"`   // 每个客户端每秒请求数，0为不限流
+	Burst int     `yaml:"burst"` // 突发请求数
+}
+
+// ThrottleConfig 遥测上报队列积压时向设备下发 throttle 消息，队列恢复后通知设备恢复
+type ThrottleConfig struct {
+	ReportInterval int     `yaml:"report_interval"` // 限速期间建议设备使用的上报间隔(秒)，0为不启用
+	High           float64 `yaml:"high"`            // 队列占用比例达到该值时限速，默认0.8
+	Low            float64 `yaml:"low"`             // 队列占用比例低于该值时恢复，默认0.5
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"` // 允许的来源，"*"表示全部，为空不启用
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"` // 预检结果缓存秒数
 }
 
 type PlatformConfig struct {
-	URL               string `yaml:"url"`           // 平台API地址
-	MQTTBroker        string `yaml:"mqtt_broker"`   // MQTT服务器地址
-	MQTTUsername      string `yaml:"mqtt_username"` // MQTT用户名
-	MQTTPassword      string `yaml:"mqtt_password"` // MQTT密码
-	ServiceIdentifier string `yaml:"service_identifier"`
+	URL                string            `yaml:"url"`           // 平台API地址
+	MQTTBroker         string            `yaml:"mqtt_broker"`   // MQTT服务器地址
+	MQTTUsername       string            `yaml:"mqtt_username"` // MQTT用户名
+	MQTTPassword       string            `yaml:"mqtt_password"` // MQTT密码
+	ServiceIdentifier  string            `yaml:"service_identifier"`
+	ServiceIdentifiers []string          `yaml:"service_identifiers"` // 额外注册的服务标识符，如 esp32-ws、esp32-mqtt
+	HeartbeatInterval  int               `yaml:"heartbeat_interval"`  // 插件心跳间隔(秒)，0为不发送
+	DeviceCacheSize    int               `yaml:"device_cache_size"`   // 设备缓存最大条数，0为不限制
+	DeviceCache        DeviceCacheConfig `yaml:"device_cache"`        // 设备缓存有效期及本地持久化
+	Spool              SpoolConfig       `yaml:"spool"`               // MQTT不可用时的遥测磁盘队列
+	Telemetry          TelemetryConfig   `yaml:"telemetry"`           // 遥测时间戳
+	Batch              BatchConfig       `yaml:"batch"`               // 遥测批量上报
+	Secondary          EndpointConfig    `yaml:"secondary"`           // 备用平台端点，平台维护期间自动切换
+	Register           RegisterConfig    `yaml:"register"`            // 启动时向平台注册插件服务元数据
+	HealthScore        HealthScoreConfig `yaml:"health_score"`        // 设备健康评分
+	Fleet              FleetConfig       `yaml:"fleet"`               // 按凭证汇总的设备群统计
+	Serializers        map[string]string `yaml:"serializers"`         // 主题类别(telemetry/event/response) -> 序列化方式，默认 json
+	KeyFilters         []KeyFilterConfig `yaml:"key_filters"`         // 按凭证的遥测字段允许/禁止列表
+}
+
+// KeyFilterConfig 某个凭证发布到平台的遥测字段，字段名支持通配符(如 wifi_*)
+type KeyFilterConfig struct {
+	Voucher string   `yaml:"voucher"` // 凭证摘要(见 /admin/tenants/health 中的 tenant)，"*" 匹配全部；凭证专属的规则优先
+	Allow   []string `yaml:"allow"`   // 非空时只发布匹配的字段(ts 始终保留)
+	Deny    []string `yaml:"deny"`    // 不发布匹配的字段，优先于 allow
+}
+
+// DeviceCacheConfig 设备缓存有效期及本地持久化，持久化需配置 store.path
+type DeviceCacheConfig struct {
+	TTLMinutes int  `yaml:"ttl_minutes"` // 缓存有效期(分钟)，过期后重新向平台获取，0为不过期
+	Persist    bool `yaml:"persist"`     // 将设备信息保存到本地存储，重启后预热缓存，平台不可用时使用过期的记录
+	MaxEntries int  `yaml:"max_entries"` // 本地存储最多保存的设备数，超过时淘汰最早获取的，0为不限制
+}
+
+// FleetConfig 设备群统计，作为遥测发布到ThingsPanel中预先创建的服务设备
+type FleetConfig struct {
+	Enabled         bool              `yaml:"enabled"`
+	IntervalSeconds int               `yaml:"interval_seconds"` // 汇总间隔(秒)，默认60
+	RSSIKey         string            `yaml:"rssi_key"`         // 遥测中的信号强度字段
+	DeviceNumber    string            `yaml:"device_number"`    // 接收全部凭证汇总统计的服务设备编号
+	Tenants         map[string]string `yaml:"tenants"`          // 凭证摘要 -> 该凭证的服务设备编号
+}
+
+// HealthScoreConfig 设备健康评分，按权重综合连接稳定性、信号强度、电量和上报失败次数
+type HealthScoreConfig struct {
+	Enabled            bool    `yaml:"enabled"`
+	IntervalSeconds    int     `yaml:"interval_seconds"`    // 评分及上报间隔(秒)，默认600
+	Key                string  `yaml:"key"`                 // 上报到平台的遥测字段，默认 health_score
+	RSSIKey            string  `yaml:"rssi_key"`            // 遥测中的信号强度字段(dBm)
+	BatteryKey         string  `yaml:"battery_key"`         // 遥测中的电量字段(0-100)
+	ConnectivityWeight float64 `yaml:"connectivity_weight"` // 各项权重，全部为0时使用 0.4/0.2/0.2/0.2
+	RSSIWeight         float64 `yaml:"rssi_weight"`
+	BatteryWeight      float64 `yaml:"battery_weight"`
+	ErrorWeight        float64 `yaml:"error_weight"`
+}
+
+// RegisterConfig 插件服务元数据注册，平台不支持注册接口时仅记录日志
+type RegisterConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"` // 注册接口路径，为空时使用默认值
+}
+
+// EndpointConfig 备用平台端点，mqtt_broker 为空时不启用故障切换
+type EndpointConfig struct {
+	URL              string `yaml:"url"`               // 备用平台API地址，为空时沿用主地址
+	MQTTBroker       string `yaml:"mqtt_broker"`       // 备用MQTT服务器
+	FailoverAfter    int    `yaml:"failover_after"`    // 主端点MQTT断开超过该秒数后切换，默认30
+	FailbackInterval int    `yaml:"failback_interval"` // 使用备用端点时探测主端点的间隔(秒)，默认60
+}
+
+type TelemetryConfig struct {
+	Timestamps       bool `yaml:"timestamps"`         // 使用设备上报的原始时间(ts)，平台遥测主题支持 ts 时开启
+	MaxAgeHours      int  `yaml:"max_age_hours"`      // 早于该时长的历史数据丢弃，0为不限制
+	MaxFutureSeconds int  `yaml:"max_future_seconds"` // 允许超前服务器时间的秒数，超出时改用到达时间
+	// SkewThresholdSeconds 设备时钟偏差超出该秒数时在遥测中写入 clock_skew，0为不检测
+	SkewThresholdSeconds int    `yaml:"skew_threshold_seconds"`
+	SkewCorrect          bool   `yaml:"skew_correct"` // 设备时钟超前超出阈值时按估计的偏差校正时间，否则仅标记
+	SequenceKey          string `yaml:"sequence_key"` // 固件写入的消息序号字段，用于检测丢包，为空时不检测
+	// DedupSeconds 同一设备该秒数内内容完全相同的遥测只发布一次(固件重试导致的重复)，0为不去重
+	DedupSeconds int `yaml:"dedup_seconds"`
+}
+
+// BatchConfig 遥测批量上报，按设备和时间窗口合并后发布，平台发送变慢时对设备会话形成背压
+type BatchConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	MaxSize        int  `yaml:"max_size"`         // 单条消息合并的最多数据条数，默认20
+	IntervalMS     int  `yaml:"interval_ms"`      // 合并窗口(毫秒)，默认1000
+	QueueSize      int  `yaml:"queue_size"`       // 等待合并的数据条数上限，默认1024
+	BlockTimeoutMS int  `yaml:"block_timeout_ms"` // 队列满时设备会话最长等待时间(毫秒)，超时丢弃该条数据，默认2000
+}
+
+type SpoolConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Dir       string `yaml:"dir"`       // 队列目录
+	Codec     string `yaml:"codec"`     // 压缩算法: zstd/gzip/none
+	Level     int    `yaml:"level"`     // 压缩级别，zstd 1-4，gzip 1-9，0 为默认
+	BatchSize int    `yaml:"batchSize"` // 每个批次文件的消息条数
+	MaxBytes  int64  `yaml:"maxBytes"`  // 队列目录最大字节数，超出丢弃最旧批次
 }
 
 type LogConfig struct {
@@ -29,4 +192,281 @@ type LogConfig struct {
 	MaxBackups int    `yaml:"maxBackups"` // 保留的旧日志文件的最大数量
 	MaxAge     int    `yaml:"maxAge"`     // 保留日志文件的最大天数
 	Compress   bool   `yaml:"compress"`   // 是否压缩旧日志文件
+	Async      bool   `yaml:"async"`      // 是否启用异步日志写入
+	BufferSize int    `yaml:"bufferSize"` // 异步日志队列长度(条)，溢出时丢弃并计数
+	// Requests 插件收到的请求及发往第三方的请求的结构化日志，凭证和令牌隐藏后记录
+	Requests RequestLogConfig `yaml:"requests"`
+}
+
+// RequestLogConfig 请求日志(JSON行)，按 maxSize/maxBackups 轮转
+type RequestLogConfig struct {
+	Level     string `yaml:"level"`       // off 不记录 / basic 方法、地址、状态码和耗时 / full 另记录请求头和请求体、响应体
+	FilePath  string `yaml:"file_path"`   // 请求日志文件，默认 logs/requests.log
+	MaxBodyKB int    `yaml:"max_body_kb"` // full 级别记录的请求体/响应体大小上限(KB)，0使用默认值4
+}
+
+// StartupConfig 启动依赖配置：MQTT不可用时插件照常启动并提供表单配置等接口，在后台重试连接
+type StartupConfig struct {
+	RequireMQTT     bool `yaml:"require_mqtt"`      // 为 true 时启动时MQTT连接失败即退出
+	RetryInitialMs  int  `yaml:"retry_initial_ms"`  // 首次失败后的重试间隔(毫秒)，默认1000
+	RetryMaxSeconds int  `yaml:"retry_max_seconds"` // 重试间隔每次翻倍，不超过该值(秒)，默认60
+}
+
+type StoreConfig struct {
+	Path string `yaml:"path"` // 本地存储文件路径，启动时自动执行结构迁移
+	// SecretKey 加密存储中服务接入点凭证的密钥，主备实例需配置相同的值；
+	// 为空时使用存储文件旁自动生成的 .key 文件
+	SecretKey string `yaml:"secret_key"`
+}
+
+// DownlinkConfig 平台下发的控制、属性设置和命令经设备WebSocket会话下发，需启用 server.port
+type DownlinkConfig struct {
+	Enabled           bool `yaml:"enabled"`
+	QueueSize         int  `yaml:"queue_size"`          // 每台设备的队列长度，默认32
+	AckTimeoutSeconds int  `yaml:"ack_timeout_seconds"` // 等待设备应答的秒数，默认10
+	OutboxTTLMinutes  int  `yaml:"outbox_ttl_minutes"`  // 设备离线时属性设置和命令排队的有效期(分钟)，0为不排队直接应答失败
+	OutboxPerDevice   int  `yaml:"outbox_per_device"`   // 每台设备最多排队的消息数，默认20
+}
+
+// OTAConfig 固件升级，平台以命令下发升级任务，需启用 downlink
+type OTAConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Method         string `yaml:"method"`          // 固件升级的命令方法名，默认 ota_upgrade
+	Dir            string `yaml:"dir"`             // 固件缓存目录，默认 data/firmware
+	BaseURL        string `yaml:"base_url"`        // 设备访问插件HTTP服务的地址(含 base_path)，为空时下发平台原始下载地址
+	Mode           string `yaml:"mode"`            // url/chunked，默认 url
+	ChunkSize      int    `yaml:"chunk_size"`      // 分片传输时每片的字节数，默认4096
+	MaxSizeMB      int    `yaml:"max_size_mb"`     // 固件大小上限(MB)，默认16
+	TimeoutMinutes int    `yaml:"timeout_minutes"` // 设备超过该分钟数未上报进度视为升级失败，默认10
+}
+
+// StandbyConfig 主备部署，备用实例同步主实例的本地存储，主实例持续不可用时接管端口
+type StandbyConfig struct {
+	Role            string `yaml:"role"`             // active/standby，为空时按 active 运行
+	Peer            string `yaml:"peer"`             // 对端实例地址(含 base_path)
+	Token           string `yaml:"token"`            // 复制接口令牌，两端一致；为空时不启用复制
+	SyncSeconds     int    `yaml:"sync_seconds"`     // 同步间隔(秒)，默认10
+	FailoverSeconds int    `yaml:"failover_seconds"` // 主实例连续不可用超过该秒数后接管，默认30
+	// Lease 经平台MQTT服务器维护主实例租约，主实例仍在续约时备用实例不接管，接管后原主实例退出
+	Lease           bool   `yaml:"lease"`
+	LeaseTopic      string `yaml:"lease_topic"`      // 租约主题，默认 plugin/<service_identifier>/standby/lease
+	Instance        string `yaml:"instance"`         // 实例名，两端不同，默认为主机名
+	TakeoverCommand string `yaml:"takeover_command"` // 接管时执行的命令，如将虚拟IP切换到本机
+}
+
+// Identifiers 返回插件注册的全部服务标识符(去重，主标识符在前)
+func (p PlatformConfig) Identifiers() []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range append([]string{p.ServiceIdentifier}, p.ServiceIdentifiers...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+type ProfileConfig struct {
+	Path string `yaml:"path"` // 设备能力模型(物模型)文件，为空时不发布
+}
+
+type PipelineConfig struct {
+	Path string `yaml:"path"` // 设备接入流水线定义文件，为空或文件不存在时不启用
+}
+
+// ChaosConfig 故障注入配置，仅用于上线前验证重试、熔断及磁盘队列，比例为百分比(0-100)
+type ChaosConfig struct {
+	Enabled              bool    `yaml:"enabled"`
+	LatencyPercent       float64 `yaml:"latency_percent"`        // 注入随机延迟的比例
+	LatencyMinMs         int     `yaml:"latency_min_ms"`         // 延迟下限(毫秒)
+	LatencyMaxMs         int     `yaml:"latency_max_ms"`         // 延迟上限(毫秒)
+	MQTTDropPercent      float64 `yaml:"mqtt_drop_percent"`      // 丢弃MQTT发布的比例
+	UpstreamErrorPercent float64 `yaml:"upstream_error_percent"` // 小智服务端调用强制返回500的比例
+}
+
+// ClientConfig 出站请求(小智服务端、ThingsPanel开放接口)携带的身份信息及共享HTTP客户端配置
+type ClientConfig struct {
+	UserAgent  string `yaml:"user_agent"`  // 完整的 User-Agent，为空时按插件名称/版本/实例生成
+	InstanceID string `yaml:"instance_id"` // 实例标识，通过 X-Plugin-Instance 请求头发送，为空时使用主机名
+	TimeoutMs  int    `yaml:"timeout_ms"`  // 单次尝试超时(毫秒)，0 使用默认值15000
+	Retries    int    `yaml:"retries"`     // 5xx响应及网络错误的重试次数，只重试查询类及带幂等键的请求，0 不重试
+	BackoffMs  int    `yaml:"backoff_ms"`  // 首次重试前等待(毫秒)，之后每次加倍，0 使用默认值200
+	IdleConns  int    `yaml:"idle_conns"`  // 连接池空闲连接总数上限，0 使用默认值100
+	HostConns  int    `yaml:"host_conns"`  // 每个主机的空闲连接上限，0 使用默认值10
+}
+
+// UpstreamConfig 调用小智服务端的配置
+type UpstreamConfig struct {
+	Timeout         int    `yaml:"timeout"`           // 单次调用超时(秒)，0 使用默认值10秒
+	SlowThresholdMs int    `yaml:"slow_threshold_ms"` // 超过该耗时的调用写入慢日志，0 不记录
+	SlowLog         string `yaml:"slow_log"`          // 慢日志文件，记录DNS/连接/TLS/首字节等分阶段耗时
+	// Headers 附加的请求头，键为小智服务地址(与凭证 ServerURL 一致)，"*" 对全部服务地址生效
+	Headers map[string]map[string]string `yaml:"headers"`
+	// Breaker 按小智服务地址熔断，服务宕机时快速失败而不是逐个等待超时
+	Breaker BreakerConfig `yaml:"breaker"`
+}
+
+// BreakerConfig 熔断配置
+type BreakerConfig struct {
+	Failures    int `yaml:"failures"`     // 连续失败(网络错误、超时、5xx)该次数后熔断，0 不启用
+	OpenSeconds int `yaml:"open_seconds"` // 熔断时长，到期后放行一个探测请求，成功则恢复，0 使用默认值30
+}
+
+// TraceConfig 按设备开启的限时全量追踪，通过管理接口 /admin/trace 开关
+type TraceConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	Dir        string  `yaml:"dir"`         // 追踪文件目录，每个设备每次追踪一个文件
+	MaxMinutes int     `yaml:"max_minutes"` // 单次追踪最长分钟数
+	Rate       float64 `yaml:"rate"`        // 每个设备每秒最多记录条数，超出丢弃
+	Burst      int     `yaml:"burst"`       // 突发条数
+}
+
+// CaptureConfig 按设备或主题模式抓取插件收发的MQTT消息，通过管理接口开关
+type CaptureConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Dir        string `yaml:"dir"`         // 抓包文件目录
+	MaxMinutes int    `yaml:"max_minutes"` // 单次抓包最长分钟数
+	MaxMB      int    `yaml:"max_mb"`      // 单次抓包文件大小上限(MB)
+}
+
+// TransformConfig 消息转换插件配置
+type TransformConfig struct {
+	Plugins []TransformRule `yaml:"plugins"`
+	Binary  BinaryConfig    `yaml:"binary"`
+}
+
+// BinaryConfig 下行命令中二进制参数({"base64": ...})的透传限制
+type BinaryConfig struct {
+	MaxBytes        int  `yaml:"max_bytes"`        // 单个参数解码后的最大字节数，0使用默认值1MB
+	RequireChecksum bool `yaml:"require_checksum"` // 要求携带 sha256 校验值
+}
+
+// TransformRule 为指定凭证启用的转换插件
+type TransformRule struct {
+	Voucher string `yaml:"voucher"` // 凭证摘要(见 /admin/tenants/health 中的 tenant)，"*" 匹配全部
+	Path    string `yaml:"path"`    // Go插件(.so)路径
+}
+
+// ScriptConfig 按设备类型执行的Lua载荷脚本
+type ScriptConfig struct {
+	TimeoutMs int          `yaml:"timeout_ms"` // 单次执行超时(毫秒)，0为默认20ms
+	Rules     []ScriptRule `yaml:"rules"`
+}
+
+// ScriptRule 设备类型对应的脚本，source 与 file 二选一
+type ScriptRule struct {
+	DeviceType string `yaml:"device_type"`
+	Source     string `yaml:"source"` // 脚本内容
+	File       string `yaml:"file"`   // 脚本文件
+}
+
+// BundleConfig 设备配置包(GET /device/config)的默认值，CFG表单值和设备影子在此基础上覆盖
+type BundleConfig struct {
+	Defaults    map[string]interface{}            `yaml:"defaults"`     // 全部设备的默认配置
+	DeviceTypes map[string]map[string]interface{} `yaml:"device_types"` // 按设备类型覆盖
+}
+
+// DeviceConfig 设备侧接口配置
+type DeviceConfig struct {
+	Cache CacheConfig `yaml:"cache"`
+	Text  TextConfig  `yaml:"text"` // 设备列表返回给平台前的文本规范化
+	List  ListConfig  `yaml:"list"` // 设备列表分页
+}
+
+// ListConfig 设备列表分页参数的校验
+type ListConfig struct {
+	DefaultPageSize int `yaml:"default_page_size"` // 平台传入的 page_size 不合法时使用，默认20
+	MaxPageSize     int `yaml:"max_page_size"`     // page_size 上限，超过时按上限请求小智服务端，默认1000
+	CacheSeconds    int `yaml:"cache_seconds"`     // 设备列表缓存秒数，设备绑定或解绑后清除，0为不缓存
+}
+
+// TextConfig 文本字段规范化，非法UTF-8和控制字符总会处理
+type TextConfig struct {
+	NameMax            int  `yaml:"name_max"`            // 设备名称最大字符数，0为不截断
+	DescriptionMax     int  `yaml:"description_max"`     // 设备描述最大字符数，0为不截断
+	StripSupplementary bool `yaml:"strip_supplementary"` // 去掉emoji等4字节字符，平台使用不支持的存储时开启
+	// DescriptionTemplate 设备描述的默认模板，凭证中的 DescriptionTemplate 优先
+	DescriptionTemplate string `yaml:"description_template"`
+}
+
+// CacheConfig 设备侧接口的缓存响应头，响应均带 ETag，设备以 If-None-Match 校验
+type CacheConfig struct {
+	MaxAge int  `yaml:"max_age"` // 可直接复用的秒数，0 时每次校验
+	Public bool `yaml:"public"`  // 允许中间代理缓存，仅在代理按 Authorization/Device-Id 区分缓存时开启
+}
+
+// ForwardConfig 插件间事件转发
+type ForwardConfig struct {
+	Targets []ForwardTarget `yaml:"targets"`
+}
+
+// ForwardTarget 转发目标，字段含义见 forward.Target
+type ForwardTarget struct {
+	Name        string                 `yaml:"name"`
+	URL         string                 `yaml:"url"`   // http(s):// 以POST发送JSON；mqtt(s):// 发布到 topic
+	Topic       string                 `yaml:"topic"` // 支持 {event}、{device_number}、{device_id} 占位符
+	Username    string                 `yaml:"username"`
+	Password    string                 `yaml:"password"`
+	Headers     map[string]string      `yaml:"headers"`
+	Events      []string               `yaml:"events"`       // telemetry/status/event，为空时全部
+	DeviceTypes []string               `yaml:"device_types"` // 为空时不限制
+	Fields      map[string]string      `yaml:"fields"`       // 源字段 -> 目标字段，配置后只转发映射的字段
+	Static      map[string]interface{} `yaml:"static"`       // 附加的固定字段
+	QueueSize   int                    `yaml:"queue_size"`
+	TimeoutMs   int                    `yaml:"timeout_ms"`
+}
+
+// AnomalyConfig 遥测异常检测，未配置规则时不检测
+type AnomalyConfig struct {
+	Rules []AnomalyRule `yaml:"rules"`
+}
+
+// AnomalyRule 异常检测规则，字段含义见 anomaly.Rule
+type AnomalyRule struct {
+	Key             string   `yaml:"key"`
+	DeviceTypes     []string `yaml:"device_types"` // 为空时不限制
+	Method          string   `yaml:"method"`       // zscore/ewma
+	Threshold       float64  `yaml:"threshold"`    // 偏离的标准差倍数，默认3
+	Alpha           float64  `yaml:"alpha"`        // ewma 平滑系数，默认0.1
+	Window          int      `yaml:"window"`       // zscore 窗口样本数，默认60
+	MinSamples      int      `yaml:"min_samples"`  // 样本数不足时不检测，默认20
+	Direction       string   `yaml:"direction"`    // both/up/down
+	MinDelta        float64  `yaml:"min_delta"`    // 与均值的绝对差下限
+	CooldownSeconds int      `yaml:"cooldown_seconds"`
+}
+
+// TunnelConfig 内网小智服务端主动建立的反向隧道，凭证 ServerURL 为 tunnel://<name>/... 时经隧道调用
+type TunnelConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Agents  []TunnelAgent `yaml:"agents"`
+}
+
+// TunnelAgent 允许连接的隧道
+type TunnelAgent struct {
+	Name    string   `yaml:"name"`
+	Token   string   `yaml:"token"`
+	Tenants []string `yaml:"tenants"` // 允许经该隧道调用的租户(凭证摘要，见 /admin/tenants/health)，"*" 为全部
+}
+
+// AuditConfig 绑定、解绑、下发命令等变更调用的签名审计日志
+type AuditConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	FilePath string `yaml:"file_path"` // 审计日志文件，按 log.maxSize/maxBackups 轮转
+	Secret   string `yaml:"secret"`    // 签名密钥(HMAC-SHA256)，启用时必填，核验时需使用同一密钥
+}
+
+// BroadcastConfig 管理接口批量下发命令
+type BroadcastConfig struct {
+	Workers int `yaml:"workers"` // 同时进行中的下发请求数，默认16
+}
+
+// NotifyConfig 平台通知的后台处理，通知校验后立即应答平台
+type NotifyConfig struct {
+	Workers        int            `yaml:"workers"`         // 并发处理的通知数
+	QueueSize      int            `yaml:"queue_size"`      // 队列长度，满时返回错误由平台重试
+	TimeoutSeconds int            `yaml:"timeout_seconds"` // 单条通知的处理超时
+	TypeTimeouts   map[string]int `yaml:"type_timeouts"`   // 按通知类型覆盖超时(秒)，如 {"3": 60}
+	Sync           bool           `yaml:"sync"`            // 在请求内同步处理(旧行为)
+	MaxAgeSeconds  int            `yaml:"max_age_seconds"` // 携带时间戳的通知超过该时长视为重放，0不检查
 }
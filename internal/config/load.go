@@ -0,0 +1,309 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"tp-plugin/internal/pkg/listen"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix 环境变量覆盖的前缀，变量名为前缀加上配置项的 yaml 路径，
+// 如 platform.mqtt_broker 对应 TP_PLUGIN_PLATFORM_MQTT_BROKER
+const EnvPrefix = "TP_PLUGIN_"
+
+// envAliases 常用配置项的简短环境变量名
+var envAliases = map[string]string{
+	"TP_PLUGIN_MQTT_BROKER":        "platform.mqtt_broker",
+	"TP_PLUGIN_MQTT_USERNAME":      "platform.mqtt_username",
+	"TP_PLUGIN_MQTT_PASSWORD":      "platform.mqtt_password",
+	"TP_PLUGIN_SERVICE_IDENTIFIER": "platform.service_identifier",
+	"TP_PLUGIN_HTTP_PORT":          "server.http_port",
+}
+
+// FieldError 一个配置项的错误
+type FieldError struct {
+	Field   string // yaml 路径，如 platform.mqtt_broker
+	Source  string // 覆盖该配置项的环境变量名，来自配置文件时为空
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("%s(%s): %s", e.Field, e.Source, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError 配置校验失败，列出全部不合法的配置项
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return fmt.Sprintf("配置校验失败(%d项): %s", len(e.Fields), strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) add(field, source, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Source: source, Message: fmt.Sprintf(format, args...)})
+}
+
+// Load 读取配置文件，应用 TP_PLUGIN_ 环境变量覆盖并校验，最后按运行模式和部署环境调整配置；
+// 环境变量格式错误和配置项不合法时返回 *ValidationError，列出全部问题
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	verr := &ValidationError{}
+	sources := cfg.applyEnv(os.Environ(), verr)
+	cfg.validate(verr, sources)
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	cfg.ApplyProfile()
+	if err := cfg.ApplyEnvironment(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// applyEnv 按环境变量覆盖配置中的字符串、数字、布尔及字符串列表(逗号分隔)配置项，
+// 返回被覆盖的配置项路径 -> 环境变量名
+func (c *Config) applyEnv(environ []string, verr *ValidationError) map[string]string {
+	sources := make(map[string]string)
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, EnvPrefix) {
+			env[k] = v
+		}
+	}
+	if len(env) == 0 {
+		return sources
+	}
+	fields := make(map[string]reflect.Value)
+	collectFields(reflect.ValueOf(c).Elem(), "", fields)
+
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	// 别名先于完整变量名应用，两者都设置时以完整变量名为准
+	sort.Slice(names, func(i, j int) bool {
+		_, ai := envAliases[names[i]]
+		_, aj := envAliases[names[j]]
+		if ai != aj {
+			return ai
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		path, ok := envAliases[name]
+		if !ok {
+			path = envPath(name, fields)
+		}
+		field, ok := fields[path]
+		if !ok {
+			if name != "TP_PLUGIN_CONFIG" {
+				logrus.WithField("env", name).Warn("环境变量未对应任何配置项，已忽略")
+			}
+			continue
+		}
+		if err := setField(field, env[name]); err != nil {
+			verr.add(path, name, "%v", err)
+			continue
+		}
+		sources[path] = name
+	}
+	return sources
+}
+
+// envPath 按变量名查找配置项路径
+func envPath(name string, fields map[string]reflect.Value) string {
+	key := strings.TrimPrefix(name, EnvPrefix)
+	for path := range fields {
+		if envKey(path) == key {
+			return path
+		}
+	}
+	return ""
+}
+
+// envKey 配置项路径对应的环境变量名(不含前缀)：platform.mqtt_broker -> PLATFORM_MQTT_BROKER
+func envKey(path string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+}
+
+// collectFields 收集可由环境变量覆盖的配置项，键为 yaml 路径
+func collectFields(v reflect.Value, prefix string, out map[string]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		path := prefix + tag
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Struct:
+			collectFields(f, path+".", out)
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+			out[path] = f
+		case reflect.Slice:
+			if f.Type().Elem().Kind() == reflect.String {
+				out[path] = f
+			}
+		}
+	}
+}
+
+func setField(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("不是合法的布尔值: %q", s)
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return fmt.Errorf("不是合法的整数: %q", s)
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("不是合法的数字: %q", s)
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+// validate 校验必填项及取值范围，sources 为环境变量覆盖的配置项，错误中注明来源
+func (c *Config) validate(verr *ValidationError, sources map[string]string) {
+	add := func(field, format string, args ...interface{}) {
+		verr.add(field, sources[field], format, args...)
+	}
+	required := []struct{ field, value string }{
+		{"platform.url", c.Platform.URL},
+		{"platform.mqtt_broker", c.Platform.MQTTBroker},
+		{"platform.service_identifier", c.Platform.ServiceIdentifier},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			add(r.field, "必须填写")
+		}
+	}
+	if c.Server.Listen != "" {
+		if err := listen.Validate(c.Server.Listen); err != nil {
+			add("server.listen", "%v", err)
+		}
+	} else if c.Server.HTTPPort <= 0 || c.Server.HTTPPort > 65535 {
+		add("server.http_port", "端口须在1-65535之间: %d", c.Server.HTTPPort)
+	}
+	for _, host := range c.Server.Bind {
+		if h := strings.Trim(host, "[]"); h == "" || strings.ContainsAny(h, " /") {
+			add("server.bind", "不是合法的地址: %q", host)
+		}
+	}
+	if err := listen.ValidateNetwork(c.Server.Network); err != nil {
+		add("server.network", "%v", err)
+	}
+	if c.Server.DrainTimeout < 0 {
+		add("server.drain_timeout", "不能为负数: %d", c.Server.DrainTimeout)
+	}
+	if c.Startup.RetryInitialMs < 0 {
+		add("startup.retry_initial_ms", "不能为负数: %d", c.Startup.RetryInitialMs)
+	}
+	if c.Startup.RetryMaxSeconds < 0 {
+		add("startup.retry_max_seconds", "不能为负数: %d", c.Startup.RetryMaxSeconds)
+	}
+	if c.Server.SocketMode != "" {
+		if _, err := c.Server.UnixSocketMode(); err != nil {
+			add("server.socket_mode", "%v", err)
+		}
+	}
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		add("server.port", "端口须在0-65535之间: %d", c.Server.Port)
+	}
+	if c.Server.Port != 0 && c.Server.Listen == "" && c.Server.Port == c.Server.HTTPPort {
+		add("server.port", "不能与 server.http_port 相同")
+	}
+	if t := c.Server.Throttle; t.High < 0 || t.High > 1 || t.Low < 0 || t.Low > 1 {
+		add("server.throttle", "high 和 low 须在0-1之间")
+	} else if t.High > 0 && t.Low >= t.High {
+		add("server.throttle.low", "须小于 high: %v", t.Low)
+	}
+	if m := c.OTA.Mode; m != "" && m != "url" && m != "chunked" {
+		add("ota.mode", "须为 url 或 chunked: %s", m)
+	}
+	switch c.Log.Requests.Level {
+	case "", "off", "basic", "full":
+	default:
+		add("log.requests.level", "须为 off、basic 或 full: %s", c.Log.Requests.Level)
+	}
+	if c.Log.Level != "" {
+		if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+			add("log.level", "未知的日志级别: %s", c.Log.Level)
+		}
+	}
+	if c.Profile != "" && !strings.EqualFold(c.Profile, ProfileStandard) && !c.IsLite() {
+		add("profile", "须为 %s 或 %s: %s", ProfileStandard, ProfileLite, c.Profile)
+	}
+	if c.Environment.Name != "" && !environmentName.MatchString(c.Environment.Name) {
+		add("environment.name", "只能包含字母、数字、下划线和连字符: %s", c.Environment.Name)
+	}
+	if p := c.Environment.Position; p != "" && !strings.EqualFold(p, EnvironmentSuffix) && !strings.EqualFold(p, EnvironmentPrefix) {
+		add("environment.position", "须为 %s 或 %s: %s", EnvironmentSuffix, EnvironmentPrefix, p)
+	}
+}
+
+// HTTPListener 创建HTTP服务的监听：配置了 listen 时按其地址，否则在 bind 的各地址上监听 http_port
+func (s ServerConfig) HTTPListener() (net.Listener, error) {
+	if s.Listen == "" {
+		return listen.Hosts(s.Network, s.Bind, s.HTTPPort)
+	}
+	mode, err := s.UnixSocketMode()
+	if err != nil {
+		return nil, err
+	}
+	return listen.Listen(s.Listen, mode)
+}
+
+// UnixSocketMode 解析八进制的 socket_mode，未配置时返回0(使用 umask 决定的权限)
+func (s ServerConfig) UnixSocketMode() (os.FileMode, error) {
+	if s.SocketMode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("须为八进制文件权限，如 0660: %s", s.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
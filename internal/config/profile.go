@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 运行模式
+const (
+	ProfileStandard = "standard" // 标准模式
+	ProfileLite     = "lite"     // 低内存模式，面向树莓派等边缘网关
+)
+
+// liteLimits lite 模式下各项资源的上限，配置值更小时保留配置值
+var liteLimits = struct {
+	LogBufferSize    int
+	DeviceCacheSize  int
+	SpoolBatchSize   int
+	SpoolMaxBytes    int64
+	MaxConnections   int
+	NotifyWorkers    int
+	NotifyQueueSize  int
+	BatchQueueSize   int
+	BroadcastWorkers int
+	MemoryLimit      int64 // Go运行时软内存上限(字节)
+}{
+	LogBufferSize:    256,
+	DeviceCacheSize:  500,
+	SpoolBatchSize:   20,
+	SpoolMaxBytes:    8 << 20,
+	MaxConnections:   50,
+	NotifyWorkers:    1,
+	NotifyQueueSize:  100,
+	BatchQueueSize:   256,
+	BroadcastWorkers: 2,
+	MemoryLimit:      64 << 20,
+}
+
+// IsLite 是否运行在 lite 模式
+func (c *Config) IsLite() bool {
+	return strings.EqualFold(c.Profile, ProfileLite)
+}
+
+// MemoryLimit 返回运行时软内存上限，0 表示不限制
+func (c *Config) MemoryLimit() int64 {
+	if c.IsLite() {
+		return liteLimits.MemoryLimit
+	}
+	return 0
+}
+
+// ApplyProfile 按运行模式收紧缓存、队列、工作协程和连接数等资源配置；
+// lite 模式下另不提供管理接口(含管理界面)、反向隧道和主备复制接口，见 AdminEnabled
+func (c *Config) ApplyProfile() {
+	if !c.IsLite() {
+		return
+	}
+	c.Log.BufferSize = capInt(c.Log.BufferSize, liteLimits.LogBufferSize)
+	c.Platform.DeviceCacheSize = capInt(c.Platform.DeviceCacheSize, liteLimits.DeviceCacheSize)
+	c.Platform.Spool.BatchSize = capInt(c.Platform.Spool.BatchSize, liteLimits.SpoolBatchSize)
+	if c.Platform.Spool.MaxBytes <= 0 || c.Platform.Spool.MaxBytes > liteLimits.SpoolMaxBytes {
+		c.Platform.Spool.MaxBytes = liteLimits.SpoolMaxBytes
+	}
+	c.Server.MaxConnections = capInt(c.Server.MaxConnections, liteLimits.MaxConnections)
+	c.Notify.Workers = capInt(c.Notify.Workers, liteLimits.NotifyWorkers)
+	c.Notify.QueueSize = capInt(c.Notify.QueueSize, liteLimits.NotifyQueueSize)
+	c.Platform.Batch.QueueSize = capInt(c.Platform.Batch.QueueSize, liteLimits.BatchQueueSize)
+	c.Broadcast.Workers = capInt(c.Broadcast.Workers, liteLimits.BroadcastWorkers)
+}
+
+// AdminEnabled 是否提供管理接口、反向隧道和主备复制接口，lite 模式下不提供以节省内存和后台协程
+func (c *Config) AdminEnabled() bool {
+	return !c.IsLite()
+}
+
+// capInt 将 v 限制在 limit 以内，v<=0(未配置/不限制)时取 limit
+func capInt(v, limit int) int {
+	if v <= 0 || v > limit {
+		return limit
+	}
+	return v
+}
+
+// 环境标识在服务标识符中的位置
+const (
+	EnvironmentSuffix = "suffix" // Template-dev
+	EnvironmentPrefix = "prefix" // dev-Template
+)
+
+// EnvironmentConfig 部署环境。开发、测试与生产插件接入同一平台时，服务标识符按环境区分，
+// 避免注册、心跳和平台请求互相覆盖；生产环境留空
+type EnvironmentConfig struct {
+	Name     string `yaml:"name"`     // 环境标识，如 dev、staging，为空时不修改服务标识符
+	Position string `yaml:"position"` // suffix(默认)/prefix
+}
+
+var environmentName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Decorate 返回加上环境标识的服务标识符，已带有环境标识时原样返回
+func (e EnvironmentConfig) Decorate(id string) string {
+	if e.Name == "" || id == "" {
+		return id
+	}
+	if strings.EqualFold(e.Position, EnvironmentPrefix) {
+		if strings.HasPrefix(id, e.Name+"-") {
+			return id
+		}
+		return e.Name + "-" + id
+	}
+	if strings.HasSuffix(id, "-"+e.Name) {
+		return id
+	}
+	return id + "-" + e.Name
+}
+
+// ApplyEnvironment 按部署环境改写平台服务标识符，注册、心跳、请求分发及MQTT客户端ID均使用改写后的标识符
+func (c *Config) ApplyEnvironment() error {
+	env := c.Environment
+	if env.Name == "" {
+		return nil
+	}
+	if !environmentName.MatchString(env.Name) {
+		return fmt.Errorf("环境标识只能包含字母、数字、下划线和连字符: %s", env.Name)
+	}
+	if env.Position != "" && !strings.EqualFold(env.Position, EnvironmentSuffix) && !strings.EqualFold(env.Position, EnvironmentPrefix) {
+		return fmt.Errorf("未知的环境标识位置: %s", env.Position)
+	}
+	c.Platform.ServiceIdentifier = env.Decorate(c.Platform.ServiceIdentifier)
+	for i, id := range c.Platform.ServiceIdentifiers {
+		c.Platform.ServiceIdentifiers[i] = env.Decorate(id)
+	}
+	return nil
+}
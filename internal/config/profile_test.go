@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestApplyProfileLite(t *testing.T) {
+	c := &Config{Profile: ProfileLite}
+	c.Notify.Workers = 8
+	c.Notify.QueueSize = 50 // 小于上限时保留配置值
+	c.Broadcast.Workers = 16
+	c.ApplyProfile()
+
+	if c.Notify.Workers != liteLimits.NotifyWorkers || c.Notify.QueueSize != 50 {
+		t.Fatalf("通知处理 %+v", c.Notify)
+	}
+	if c.Broadcast.Workers != liteLimits.BroadcastWorkers || c.Platform.Batch.QueueSize != liteLimits.BatchQueueSize {
+		t.Fatalf("批量下发 %d 个并发，遥测批量队列 %d", c.Broadcast.Workers, c.Platform.Batch.QueueSize)
+	}
+	if c.AdminEnabled() {
+		t.Fatal("lite 模式仍提供管理接口")
+	}
+
+	std := &Config{}
+	std.ApplyProfile()
+	if std.Notify.Workers != 0 || std.Broadcast.Workers != 0 || !std.AdminEnabled() {
+		t.Fatalf("标准模式的配置被修改: %+v %+v", std.Notify, std.Broadcast)
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWatchInterval 检查配置文件修改时间的间隔
+const DefaultWatchInterval = 2 * time.Second
+
+var configReloads = metrics.NewCounterVec("tp_plugin_config_reloads_total",
+	"配置热加载次数", "result")
+
+// ApplyFunc 将新配置中可热更新的配置项应用到运行中的组件
+type ApplyFunc func(cfg *Config) error
+
+// hotApplier 一组可热更新的配置项及其应用函数
+type hotApplier struct {
+	fields []string // yaml 路径，路径本身或其下任一配置项变化时调用
+	apply  ApplyFunc
+}
+
+// Watcher 监视配置文件，文件修改或收到 SIGHUP 时重新加载并校验，只应用已注册为可热更新的配置项，
+// 其余变化的配置项记录日志提示需要重启；校验失败时保留当前配置
+type Watcher struct {
+	path     string
+	interval time.Duration
+	logger   *logrus.Logger
+
+	mu       sync.Mutex
+	current  *Config
+	modTime  time.Time
+	appliers []hotApplier
+	stopCh   chan struct{}
+	once     sync.Once
+}
+
+// NewWatcher 创建配置监视器，current 为启动时加载的配置
+func NewWatcher(path string, current *Config, logger *logrus.Logger) *Watcher {
+	w := &Watcher{
+		path:     path,
+		interval: DefaultWatchInterval,
+		logger:   logger,
+		current:  current,
+		stopCh:   make(chan struct{}),
+	}
+	if fi, err := os.Stat(path); err == nil {
+		w.modTime = fi.ModTime()
+	}
+	return w
+}
+
+// Handle 注册可热更新的配置项，fields 中任一配置项(或其下级)变化时以新配置调用 apply
+func (w *Watcher) Handle(fields []string, apply ApplyFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.appliers = append(w.appliers, hotApplier{fields: fields, apply: apply})
+}
+
+// Start 开始监视文件修改和 SIGHUP
+func (w *Watcher) Start() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stopCh:
+				return
+			case <-hup:
+				w.logger.Info("收到 SIGHUP，重新加载配置")
+				w.Reload()
+			case <-ticker.C:
+				fi, err := os.Stat(w.path)
+				w.mu.Lock()
+				unchanged := err != nil || fi.ModTime().Equal(w.modTime)
+				w.mu.Unlock()
+				if unchanged {
+					continue
+				}
+				w.logger.WithField("path", w.path).Info("配置文件已修改，重新加载配置")
+				w.Reload()
+			}
+		}
+	}()
+}
+
+// Reload 重新加载配置文件并应用可热更新的配置项，返回变化的配置项和需要重启才能生效的配置项
+func (w *Watcher) Reload() (changed, restart []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if fi, err := os.Stat(w.path); err == nil {
+		w.modTime = fi.ModTime()
+	}
+	next, err := Load(w.path)
+	if err != nil {
+		configReloads.WithLabelValues("invalid").Inc()
+		w.logger.WithError(err).Error("新配置校验失败，继续使用当前配置")
+		return nil, nil
+	}
+	changed = diffConfig(w.current, next)
+	if len(changed) == 0 {
+		configReloads.WithLabelValues("unchanged").Inc()
+		w.logger.Debug("配置未变化")
+		return nil, nil
+	}
+
+	applied := make(map[string]bool)
+	result := "ok"
+	for _, a := range w.appliers {
+		hit := matchFields(changed, a.fields)
+		if len(hit) == 0 {
+			continue
+		}
+		if err := a.apply(next); err != nil {
+			result = "error"
+			w.logger.WithError(err).WithField("fields", hit).Error("应用配置失败，需重启后生效")
+			continue
+		}
+		for _, f := range hit {
+			applied[f] = true
+		}
+		w.logger.WithField("fields", hit).Info("配置已热更新")
+	}
+	for _, f := range changed {
+		if !applied[f] {
+			restart = append(restart, f)
+		}
+	}
+	if len(restart) > 0 {
+		w.logger.WithField("fields", restart).Warn("以下配置项修改后需要重启插件才能生效")
+	}
+	configReloads.WithLabelValues(result).Inc()
+	w.current = next
+	return changed, restart
+}
+
+// Stop 停止监视
+func (w *Watcher) Stop() {
+	w.once.Do(func() { close(w.stopCh) })
+}
+
+// matchFields 返回 changed 中等于 fields 之一或位于其下级的配置项
+func matchFields(changed, fields []string) []string {
+	var hit []string
+	for _, c := range changed {
+		for _, f := range fields {
+			if c == f || strings.HasPrefix(c, f+".") {
+				hit = append(hit, c)
+				break
+			}
+		}
+	}
+	return hit
+}
+
+// diffConfig 比较两份配置，返回值不同的配置项 yaml 路径；结构体逐字段比较，其余类型(含列表和映射)整体比较
+func diffConfig(a, b *Config) []string {
+	var changed []string
+	diffValue(reflect.ValueOf(*a), reflect.ValueOf(*b), "", &changed)
+	sort.Strings(changed)
+	return changed
+}
+
+func diffValue(a, b reflect.Value, prefix string, out *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		path := prefix + tag
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Kind() == reflect.Struct {
+			diffValue(fa, fb, path+".", out)
+			continue
+		}
+		if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			*out = append(*out, path)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+// internal/downlink/downlink.go
+package downlink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/platform"
+	"tp-plugin/internal/wsserver"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 平台下发消息的类型，对应主题 plugin/<服务标识符>/devices/<类型>/<设备ID>[/<消息ID>]
+const (
+	KindControl    = "telemetry/control" // 遥测下发(控制)，不需要应答
+	KindAttributes = "attributes/set"    // 属性设置
+	KindCommand    = "command"           // 命令下发(RPC)
+)
+
+// 设备收到的下行消息类型
+var deviceTypes = map[string]string{
+	KindControl:    "control",
+	KindAttributes: "attributes",
+	KindCommand:    "command",
+}
+
+// 默认参数
+const (
+	DefaultQueueSize  = 32
+	DefaultAckTimeout = 10 * time.Second
+	workerIdle        = time.Minute // 设备队列空闲超过该时长后退出工作协程
+)
+
+var (
+	downlinkMessages = metrics.NewCounterVec("tp_plugin_downlink_messages_total",
+		"平台下发到设备的消息数", "kind", "result")
+	downlinkQueued = metrics.NewGaugeVec("tp_plugin_downlink_queued",
+		"等待下发到设备的消息数")
+)
+
+// Config 下行消息路由配置
+type Config struct {
+	Enabled            bool
+	ServiceIdentifiers []string      // 订阅这些服务标识符下的平台下发主题
+	QueueSize          int           // 每台设备的队列长度，满时直接应答失败，0使用默认值32
+	AckTimeout         time.Duration // 等待设备 ack 的时长，超时应答失败，0使用默认值10秒
+	Outbox             OutboxConfig  // 设备离线时属性设置和命令持久化排队，设备重连后下发
+}
+
+// Result 下行消息的执行结果，作为应答 values 发布到平台
+type Result struct {
+	Result  int    `json:"result"` // 0成功，1失败
+	Errcode string `json:"errcode,omitempty"`
+	Message string `json:"message"`
+	Ts      int64  `json:"ts"`
+	Method  string `json:"method,omitempty"`
+	Status  string `json:"status,omitempty"` // 离线排队消息的投递状态：pending/delivered/expired
+}
+
+// job 一条待下发的消息
+type job struct {
+	kind      string
+	deviceID  string
+	messageID string
+	method    string
+	params    map[string]interface{}
+	outboxKey string // 来自离线排队的消息在存储中的key
+}
+
+// CommandFunc 由插件自身处理的命令，返回nil时应答成功，返回错误时应答失败
+type CommandFunc func(deviceID, deviceNumber, messageID string, params map[string]interface{}) error
+
+// queue 一台设备的下行队列，消息按到达顺序逐条下发，收到 ack 或超时后下发下一条
+type queue struct {
+	jobs chan job
+}
+
+// Router 订阅平台的控制、属性设置和命令主题，经设备WebSocket会话下发到设备，并将设备 ack 作为执行结果应答平台
+type Router struct {
+	cfg      Config
+	platform *platform.PlatformClient
+	devices  *wsserver.Server
+	logger   *logrus.Logger
+	mu       sync.Mutex
+	queues   map[string]*queue // 设备编号 -> 队列
+	pending  map[string]chan wsserver.Message
+	inflight map[string]bool // 已放入设备队列、尚未下发结束的排队消息
+	commands map[string]CommandFunc
+
+	outboxSeq atomic.Uint64 // 排队消息key的序号
+	stopCh    chan struct{}
+	once      sync.Once
+}
+
+// New 创建下行消息路由，未启用或设备WebSocket服务未启用时返回nil
+func New(cfg Config, platform *platform.PlatformClient, devices *wsserver.Server, logger *logrus.Logger) *Router {
+	if !cfg.Enabled || devices == nil {
+		return nil
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = DefaultAckTimeout
+	}
+	r := &Router{
+		cfg:      cfg,
+		platform: platform,
+		devices:  devices,
+		logger:   logger,
+		queues:   make(map[string]*queue),
+		pending:  make(map[string]chan wsserver.Message),
+		inflight: make(map[string]bool),
+		commands: make(map[string]CommandFunc),
+		stopCh:   make(chan struct{}),
+	}
+	devices.OnAck(r.ack)
+	devices.OnHello(r.flushOutbox)
+	return r
+}
+
+// HandleCommand 设置由插件处理而不下发到设备的命令(如固件升级)，需在 Start 之前调用
+func (r *Router) HandleCommand(method string, fn CommandFunc) {
+	if r != nil {
+		r.commands[method] = fn
+	}
+}
+
+// Start 订阅各服务标识符的平台下发主题
+func (r *Router) Start() error {
+	if r == nil {
+		return nil
+	}
+	for _, id := range r.cfg.ServiceIdentifiers {
+		topic := fmt.Sprintf("plugin/%s/devices/#", id)
+		if err := r.platform.Subscribe(topic, r.handle); err != nil {
+			return err
+		}
+		r.logger.WithField("topic", topic).Info("已订阅平台下发主题")
+	}
+	if r.outboxEnabled() {
+		go r.sweepOutbox()
+	}
+	return nil
+}
+
+// Stop 停止清理过期的排队消息，可重复调用
+func (r *Router) Stop() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() { close(r.stopCh) })
+}
+
+// parseTopic 解析 plugin/<服务标识符>/devices/<类型>/<设备ID>[/<消息ID>]
+func parseTopic(topic string) (kind, deviceID, messageID string, ok bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 5 || parts[0] != "plugin" || parts[2] != "devices" {
+		return "", "", "", false
+	}
+	rest := parts[3:]
+	for k := range deviceTypes {
+		segs := strings.Split(k, "/")
+		if len(rest) < len(segs)+1 || strings.Join(rest[:len(segs)], "/") != k {
+			continue
+		}
+		deviceID = rest[len(segs)]
+		if len(rest) > len(segs)+1 {
+			messageID = rest[len(segs)+1]
+		}
+		return k, deviceID, messageID, deviceID != ""
+	}
+	return "", "", "", false
+}
+
+// parsePayload 解析下发内容：命令为 {"method","params"}，控制和属性设置为键值对象；
+// 带 {"device_id","values"} 外层时取 values
+func parsePayload(kind string, payload []byte) (string, map[string]interface{}, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return "", nil, fmt.Errorf("解析下发消息失败: %v", err)
+	}
+	if values, ok := body["values"].(map[string]interface{}); ok {
+		if _, wrapped := body["device_id"]; wrapped {
+			body = values
+		}
+	}
+	if kind != KindCommand {
+		return "", body, nil
+	}
+	method, _ := body["method"].(string)
+	if method == "" {
+		return "", nil, errors.New("命令缺少 method")
+	}
+	params, _ := body["params"].(map[string]interface{})
+	return method, params, nil
+}
+
+// handle 处理平台下发的一条消息，放入设备队列
+func (r *Router) handle(topic string, payload []byte) {
+	kind, deviceID, messageID, ok := parseTopic(topic)
+	if !ok {
+		downlinkMessages.WithLabelValues("unknown", "ignored").Inc()
+		r.logger.WithField("topic", topic).Debug("忽略未知的平台下发主题")
+		return
+	}
+	log := r.logger.WithFields(logrus.Fields{"device_id": deviceID, "kind": kind, "message_id": messageID})
+	method, params, err := parsePayload(kind, payload)
+	if err != nil {
+		downlinkMessages.WithLabelValues(kind, "invalid").Inc()
+		log.WithError(err).Warn("平台下发消息无效")
+		r.respond(job{kind: kind, deviceID: deviceID, messageID: messageID}, "invalid", err.Error())
+		return
+	}
+	j := job{kind: kind, deviceID: deviceID, messageID: messageID, method: method, params: params}
+
+	number := r.platform.DeviceNumber(deviceID)
+	if number == "" {
+		device, err := r.platform.GetDeviceByID(deviceID)
+		if err != nil {
+			downlinkMessages.WithLabelValues(kind, "unknown_device").Inc()
+			log.WithError(err).Warn("下发消息的设备不在缓存中")
+			r.respond(j, "unknown_device", "设备未连接到插件")
+			return
+		}
+		number = device.DeviceNumber
+	}
+	if fn := r.commands[method]; kind == KindCommand && fn != nil {
+		r.command(j, number, fn)
+		return
+	}
+	if !r.devices.Connected(number) {
+		downlinkMessages.WithLabelValues(kind, "offline").Inc()
+		// 属性设置和命令排队到设备重连，控制消息只对当前状态有意义，不排队
+		if r.outboxEnabled() && messageID != "" && kind != KindControl {
+			r.enqueueOutbox(number, j)
+			return
+		}
+		r.respond(j, "offline", wsserver.ErrNotConnected.Error())
+		return
+	}
+
+	r.mu.Lock()
+	q := r.queueLocked(number)
+	select {
+	case q.jobs <- j:
+		downlinkQueued.WithLabelValues().Inc()
+		r.mu.Unlock()
+	default:
+		r.mu.Unlock()
+		downlinkMessages.WithLabelValues(kind, "queue_full").Inc()
+		log.WithField("device_number", number).Warn("设备下行队列已满")
+		r.respond(j, "queue_full", "设备下行队列已满")
+	}
+}
+
+// command 执行插件处理的命令并应答结果
+func (r *Router) command(j job, number string, fn CommandFunc) {
+	if err := fn(j.deviceID, number, j.messageID, j.params); err != nil {
+		downlinkMessages.WithLabelValues(j.kind, "rejected").Inc()
+		r.logger.WithError(err).WithField("device_number", number).WithField("method", j.method).Warn("插件处理命令失败")
+		r.respond(j, "rejected", err.Error())
+		return
+	}
+	downlinkMessages.WithLabelValues(j.kind, "ok").Inc()
+	r.respond(j, "", "")
+}
+
+// queueLocked 返回设备的队列，不存在时创建并启动工作协程；调用方需持有锁
+func (r *Router) queueLocked(number string) *queue {
+	q := r.queues[number]
+	if q == nil {
+		q = &queue{jobs: make(chan job, r.cfg.QueueSize)}
+		r.queues[number] = q
+		go r.worker(number, q)
+	}
+	return q
+}
+
+// worker 逐条下发设备队列中的消息，空闲超过 workerIdle 后退出
+func (r *Router) worker(number string, q *queue) {
+	idle := time.NewTimer(workerIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case j := <-q.jobs:
+			downlinkQueued.WithLabelValues().Dec()
+			r.deliver(number, j)
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(workerIdle)
+		case <-idle.C:
+			r.mu.Lock()
+			if len(q.jobs) == 0 {
+				delete(r.queues, number)
+				r.mu.Unlock()
+				return
+			}
+			r.mu.Unlock()
+			idle.Reset(workerIdle)
+		}
+	}
+}
+
+// deliver 下发一条消息并等待设备 ack；控制消息及不带消息ID的下发发送成功即完成
+func (r *Router) deliver(number string, j job) {
+	msg := wsserver.Message{Type: deviceTypes[j.kind], ID: j.messageID, Method: j.method, Params: j.params}
+	if j.messageID == "" || j.kind == KindControl {
+		result := "ok"
+		if err := r.devices.Send(number, msg); err != nil {
+			result = "error"
+			r.logger.WithError(err).WithField("device_number", number).Warn("下发消息到设备失败")
+		}
+		downlinkMessages.WithLabelValues(j.kind, result).Inc()
+		return
+	}
+
+	ch := make(chan wsserver.Message, 1)
+	key := number + "/" + j.messageID
+	r.mu.Lock()
+	r.pending[key] = ch
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, key)
+		r.mu.Unlock()
+	}()
+
+	if err := r.devices.Send(number, msg); err != nil {
+		downlinkMessages.WithLabelValues(j.kind, "offline").Inc()
+		// 排队消息保留到设备下次重连，已应答过 pending，不再应答
+		if j.outboxKey != "" {
+			r.finishOutbox(j, false)
+			return
+		}
+		r.respond(j, "offline", err.Error())
+		return
+	}
+	timer := time.NewTimer(r.cfg.AckTimeout)
+	defer timer.Stop()
+	select {
+	case ack := <-ch:
+		if j.outboxKey != "" {
+			r.finishOutbox(j, true)
+		}
+		if ack.Code != 0 || ack.Error != "" {
+			downlinkMessages.WithLabelValues(j.kind, "failed").Inc()
+			r.respond(j, fmt.Sprint(ack.Code), ack.Error)
+			return
+		}
+		downlinkMessages.WithLabelValues(j.kind, "ok").Inc()
+		r.respond(j, "", "")
+	case <-timer.C:
+		downlinkMessages.WithLabelValues(j.kind, "timeout").Inc()
+		if j.outboxKey != "" {
+			r.finishOutbox(j, false)
+			return
+		}
+		r.respond(j, "timeout", "等待设备应答超时")
+	}
+}
+
+// ack 设备 ack 消息，交给等待中的下发
+func (r *Router) ack(number string, msg wsserver.Message) {
+	r.mu.Lock()
+	ch := r.pending[number+"/"+msg.ID]
+	r.mu.Unlock()
+	if ch == nil {
+		r.logger.WithField("device_number", number).WithField("message_id", msg.ID).Debug("忽略过期的设备应答")
+		return
+	}
+	select {
+	case ch <- msg:
+	default:
+	}
+}
+
+// respond 向平台应答执行结果，errcode 为空表示成功；控制消息及没有消息ID的下发不应答。
+// 来自离线排队的消息以 status 为 delivered 应答
+func (r *Router) respond(j job, errcode, message string) {
+	status := ""
+	if j.outboxKey != "" {
+		status = StatusDelivered
+	}
+	r.respondStatus(j, status, errcode, message)
+}
+
+// respondStatus 向平台应答执行结果及离线排队消息的投递状态
+func (r *Router) respondStatus(j job, status, errcode, message string) {
+	if j.messageID == "" || j.kind == KindControl {
+		return
+	}
+	res := Result{Message: "success", Ts: time.Now().Unix(), Method: j.method, Status: status}
+	if errcode != "" {
+		res.Result, res.Errcode, res.Message = 1, errcode, message
+	}
+	topic := fmt.Sprintf("devices/%s/response/%s", j.kind, j.messageID)
+	if err := r.platform.Respond(topic, j.deviceID, res); err != nil {
+		r.logger.WithError(err).WithField("topic", topic).Warn("应答平台下发消息失败")
+	}
+}
@@ -0,0 +1,275 @@
+// internal/downlink/outbox.go
+package downlink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/wsserver"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 离线消息的投递状态，随执行结果的 status 字段应答平台
+const (
+	StatusPending   = "pending"   // 设备离线，已排队等待设备重连
+	StatusDelivered = "delivered" // 设备重连后已下发并应答
+	StatusExpired   = "expired"   // 超过有效期设备仍未重连，已丢弃
+)
+
+// 离线消息默认参数
+const (
+	DefaultOutboxPerDevice = 20
+	outboxSweepInterval    = time.Minute
+)
+
+var (
+	outboxMessages = metrics.NewCounterVec("tp_plugin_downlink_outbox_total",
+		"设备离线期间排队的下行消息数", "result")
+	outboxQueued = metrics.NewGaugeVec("tp_plugin_downlink_outbox_queued",
+		"等待设备重连后下发的消息数")
+)
+
+// OutboxConfig 设备离线时属性设置和命令的持久化排队，Store 为nil或 TTL 为0时不启用，离线时直接应答失败
+type OutboxConfig struct {
+	Store     *store.Store
+	TTL       time.Duration // 排队消息的有效期，超过后丢弃并应答 expired
+	PerDevice int           // 每台设备最多排队的消息数，0使用默认值20
+}
+
+// outboxEntry 一条排队的下行消息，设备应答前不删除，插件重启或下发后未应答时在下次重连时重新下发(至少一次)
+type outboxEntry struct {
+	Kind         string                 `json:"kind"`
+	DeviceID     string                 `json:"device_id"`
+	DeviceNumber string                 `json:"device_number"`
+	MessageID    string                 `json:"message_id"`
+	Method       string                 `json:"method,omitempty"`
+	Params       map[string]interface{} `json:"params,omitempty"`
+	QueuedAt     time.Time              `json:"queued_at"`
+	ExpiresAt    time.Time              `json:"expires_at"`
+	Attempts     int                    `json:"attempts"`
+}
+
+func (e outboxEntry) job(key string) job {
+	return job{kind: e.Kind, deviceID: e.DeviceID, messageID: e.MessageID, method: e.Method, params: e.Params, outboxKey: key}
+}
+
+// outboxKey 按设备编号、排队时间和序号排序，同一设备的消息按到达顺序下发；
+// 序号区分同一时刻排队的消息(时钟精度较低的系统上可能相同)，避免后一条覆盖前一条
+func outboxKey(number string, t time.Time, seq uint64) string {
+	return fmt.Sprintf("%s/%020d-%010d", number, t.UnixNano(), seq%1e10)
+}
+
+func (r *Router) outboxEnabled() bool {
+	return r.cfg.Outbox.Store != nil && r.cfg.Outbox.TTL > 0
+}
+
+// outboxEntries 返回设备排队中的消息，number 为空时返回全部；按key前缀定位，不扫描其他设备的消息
+func (r *Router) outboxEntries(number string) (map[string]outboxEntry, error) {
+	prefix := ""
+	if number != "" {
+		prefix = number + "/"
+	}
+	entries := make(map[string]outboxEntry)
+	err := r.cfg.Outbox.Store.ForEachPrefix(store.BucketOutbox, prefix, func(key string, data []byte) error {
+		var e outboxEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			r.logger.WithError(err).WithField("key", key).Warn("解析排队消息失败")
+			return nil
+		}
+		entries[key] = e
+		return nil
+	})
+	return entries, err
+}
+
+// enqueueOutbox 设备离线时将消息持久化排队并应答 pending；排队失败时应答离线
+func (r *Router) enqueueOutbox(number string, j job) {
+	entries, err := r.outboxEntries(number)
+	if err != nil {
+		r.logger.WithError(err).WithField("device_number", number).Warn("读取排队消息失败")
+	}
+	limit := r.cfg.Outbox.PerDevice
+	if limit <= 0 {
+		limit = DefaultOutboxPerDevice
+	}
+	if err != nil || len(entries) >= limit {
+		outboxMessages.WithLabelValues("full").Inc()
+		r.respond(j, "outbox_full", "设备离线且排队消息已达上限")
+		return
+	}
+	now := time.Now()
+	e := outboxEntry{
+		Kind:         j.kind,
+		DeviceID:     j.deviceID,
+		DeviceNumber: number,
+		MessageID:    j.messageID,
+		Method:       j.method,
+		Params:       j.params,
+		QueuedAt:     now,
+		ExpiresAt:    now.Add(r.cfg.Outbox.TTL),
+	}
+	if err := r.cfg.Outbox.Store.Put(store.BucketOutbox, outboxKey(number, now, r.outboxSeq.Add(1)), e); err != nil {
+		outboxMessages.WithLabelValues("error").Inc()
+		r.logger.WithError(err).WithField("device_number", number).Warn("保存排队消息失败")
+		r.respond(j, "offline", wsserver.ErrNotConnected.Error())
+		return
+	}
+	outboxMessages.WithLabelValues("queued").Inc()
+	outboxQueued.WithLabelValues().Inc()
+	r.logger.WithFields(logrus.Fields{
+		"device_number": number,
+		"message_id":    j.messageID,
+		"expires_at":    e.ExpiresAt,
+	}).Info("设备离线，下行消息已排队等待重连")
+	r.respondStatus(j, StatusPending, "pending", "设备离线，已排队等待设备重连后下发")
+}
+
+// flushOutbox 设备 hello 握手后将排队的消息按到达顺序放入设备队列；已过期的应答 expired，
+// 正在下发的不重复放入，设备队列已满时其余消息留待下次重连
+func (r *Router) flushOutbox(number string) {
+	if !r.outboxEnabled() {
+		return
+	}
+	entries, err := r.outboxEntries(number)
+	if err != nil {
+		r.logger.WithError(err).WithField("device_number", number).Warn("读取排队消息失败")
+		return
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	now := time.Now()
+	for _, key := range keys {
+		e := entries[key]
+		if now.After(e.ExpiresAt) {
+			r.expireOutbox(key, e)
+			continue
+		}
+		r.mu.Lock()
+		if r.inflight[key] {
+			r.mu.Unlock()
+			continue
+		}
+		q := r.queueLocked(number)
+		select {
+		case q.jobs <- e.job(key):
+			r.inflight[key] = true
+			downlinkQueued.WithLabelValues().Inc()
+			r.mu.Unlock()
+		default:
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// finishOutbox 排队消息下发结束：设备已应答时删除并应答 delivered，未送达(离线、超时)时记录尝试次数留待下次重连
+func (r *Router) finishOutbox(j job, acked bool) {
+	r.mu.Lock()
+	delete(r.inflight, j.outboxKey)
+	r.mu.Unlock()
+	st := r.cfg.Outbox.Store
+	if acked {
+		if err := st.Delete(store.BucketOutbox, j.outboxKey); err != nil {
+			r.logger.WithError(err).WithField("key", j.outboxKey).Warn("删除已送达的排队消息失败")
+		}
+		outboxMessages.WithLabelValues("delivered").Inc()
+		outboxQueued.WithLabelValues().Dec()
+		return
+	}
+	var e outboxEntry
+	if err := st.Get(store.BucketOutbox, j.outboxKey, &e); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			// 下发期间设备数据被清除，见 Purge
+			outboxQueued.WithLabelValues().Dec()
+		}
+		return
+	}
+	e.Attempts++
+	if err := st.Put(store.BucketOutbox, j.outboxKey, e); err != nil {
+		r.logger.WithError(err).WithField("key", j.outboxKey).Warn("更新排队消息失败")
+	}
+	outboxMessages.WithLabelValues("retry").Inc()
+}
+
+// expireOutbox 删除过期的排队消息并应答 expired
+func (r *Router) expireOutbox(key string, e outboxEntry) {
+	if err := r.cfg.Outbox.Store.Delete(store.BucketOutbox, key); err != nil {
+		r.logger.WithError(err).WithField("key", key).Warn("删除过期的排队消息失败")
+		return
+	}
+	outboxMessages.WithLabelValues("expired").Inc()
+	outboxQueued.WithLabelValues().Dec()
+	r.logger.WithField("device_number", e.DeviceNumber).WithField("message_id", e.MessageID).Info("排队消息已过期，设备未在有效期内重连")
+	r.respondStatus(e.job(key), StatusExpired, "expired", "设备未在有效期内重连，消息已丢弃")
+}
+
+// Purge 删除设备排队中的全部消息并应答 expired，用于清除设备数据；正在下发的消息在下发结束后计数。
+// 返回删除的条数，Router 为nil或未启用离线排队时返回0
+func (r *Router) Purge(number string) (int, error) {
+	if r == nil || !r.outboxEnabled() {
+		return 0, nil
+	}
+	entries, err := r.outboxEntries(number)
+	if err != nil {
+		return 0, fmt.Errorf("读取排队消息失败: %v", err)
+	}
+	n := 0
+	for key, e := range entries {
+		if err := r.cfg.Outbox.Store.Delete(store.BucketOutbox, key); err != nil {
+			return n, fmt.Errorf("删除排队消息失败: %v", err)
+		}
+		n++
+		r.mu.Lock()
+		busy := r.inflight[key]
+		r.mu.Unlock()
+		if !busy {
+			outboxQueued.WithLabelValues().Dec()
+		}
+		outboxMessages.WithLabelValues("purged").Inc()
+		r.respondStatus(e.job(key), StatusExpired, "purged", "设备数据已清除，消息已丢弃")
+	}
+	return n, nil
+}
+
+// sweepOutbox 定期清理过期的排队消息，启动时统计排队中的消息数；Stop 后返回
+func (r *Router) sweepOutbox() {
+	if entries, err := r.outboxEntries(""); err == nil {
+		outboxQueued.WithLabelValues().Set(float64(len(entries)))
+	}
+	ticker := time.NewTicker(outboxSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			r.expireOutboxes()
+		}
+	}
+}
+
+// expireOutboxes 清理过期且未在下发中的排队消息
+func (r *Router) expireOutboxes() {
+	entries, err := r.outboxEntries("")
+	if err != nil {
+		r.logger.WithError(err).Warn("读取排队消息失败")
+		return
+	}
+	now := time.Now()
+	for key, e := range entries {
+		r.mu.Lock()
+		busy := r.inflight[key]
+		r.mu.Unlock()
+		if !busy && now.After(e.ExpiresAt) {
+			r.expireOutbox(key, e)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package downlink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+	"tp-plugin/internal/platform"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/wsserver"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// newTestRouter 创建启用离线排队的路由，平台MQTT不连接，应答发布失败只记录日志
+func newTestRouter(t *testing.T) *Router {
+	t.Helper()
+	st, err := store.Open(filepath.Join(t.TempDir(), "plugin.db"), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	p, err := platform.NewPlatformClient(platform.Config{
+		BaseURL:     "http://127.0.0.1:1",
+		MQTTBroker:  "tcp://127.0.0.1:1",
+		LazyConnect: true,
+	}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	devices := wsserver.New(wsserver.Config{Port: 1}, p, testLogger())
+	t.Cleanup(func() { devices.Close(context.Background()) })
+	r := New(Config{
+		Enabled: true,
+		Outbox:  OutboxConfig{Store: st, TTL: time.Hour},
+	}, p, devices, testLogger())
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func putEntry(t *testing.T, r *Router, key string, e outboxEntry) {
+	t.Helper()
+	if err := r.cfg.Outbox.Store.Put(store.BucketOutbox, key, e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sortedKeys(entries map[string]outboxEntry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestOutboxKeyOrder(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name        string
+		first, next string
+	}{
+		{"same tick", outboxKey("d1", now, 1), outboxKey("d1", now, 2)},
+		{"later tick", outboxKey("d1", now, 9), outboxKey("d1", now.Add(time.Nanosecond), 1)},
+		{"sequence width", outboxKey("d1", now, 9), outboxKey("d1", now, 10)},
+		{"pre-sequence key", fmt.Sprintf("d1/%020d", now.UnixNano()), outboxKey("d1", now, 1)}, // 旧版本不带序号的key
+	}
+	for _, c := range cases {
+		if c.first == c.next || c.first > c.next {
+			t.Errorf("%s: %q 应排在 %q 之前", c.name, c.first, c.next)
+		}
+	}
+}
+
+func TestEnqueueOutboxSameTick(t *testing.T) {
+	r := newTestRouter(t)
+	// 连续排队的消息即使排队时间相同也各自保存
+	for _, id := range []string{"m1", "m2", "m3"} {
+		r.enqueueOutbox("d1", job{kind: KindCommand, deviceID: "id-d1", messageID: id, method: "reboot"})
+	}
+	entries, err := r.outboxEntries("d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := sortedKeys(entries)
+	if len(keys) != 3 {
+		t.Fatalf("排队消息 %d 条，期望 3 条", len(keys))
+	}
+	for i, id := range []string{"m1", "m2", "m3"} {
+		if entries[keys[i]].MessageID != id {
+			t.Fatalf("第%d条排队消息 %s，期望 %s", i, entries[keys[i]].MessageID, id)
+		}
+	}
+}
+
+func TestOutboxEntriesByDevice(t *testing.T) {
+	r := newTestRouter(t)
+	now := time.Now()
+	// d1 是 d10 的前缀，按 "<编号>/" 定位不应包含 d10 的消息
+	for i, number := range []string{"d1", "d10", "d1", "d2", "c9"} {
+		putEntry(t, r, outboxKey(number, now, uint64(i)), outboxEntry{DeviceNumber: number, MessageID: number})
+	}
+	if err := r.cfg.Outbox.Store.Put(store.BucketOutbox, "d1/corrupt", "not an entry"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		number string
+		want   int
+	}{
+		{"d1", 2},
+		{"d10", 1},
+		{"d2", 1},
+		{"d", 0},
+		{"missing", 0},
+		{"", 5},
+	}
+	for _, c := range cases {
+		entries, err := r.outboxEntries(c.number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != c.want {
+			t.Errorf("%q: %d 条排队消息，期望 %d 条", c.number, len(entries), c.want)
+		}
+		for _, e := range entries {
+			if c.number != "" && e.DeviceNumber != c.number {
+				t.Errorf("%q: 返回了 %s 的消息", c.number, e.DeviceNumber)
+			}
+		}
+	}
+}
+
+func TestExpireOutboxes(t *testing.T) {
+	r := newTestRouter(t)
+	now := time.Now()
+	entries := map[string]outboxEntry{
+		outboxKey("d1", now, 1): {DeviceNumber: "d1", MessageID: "expired", Kind: KindCommand, ExpiresAt: now.Add(-time.Minute)},
+		outboxKey("d1", now, 2): {DeviceNumber: "d1", MessageID: "fresh", Kind: KindCommand, ExpiresAt: now.Add(time.Minute)},
+		outboxKey("d2", now, 3): {DeviceNumber: "d2", MessageID: "busy", Kind: KindCommand, ExpiresAt: now.Add(-time.Minute)},
+	}
+	for key, e := range entries {
+		putEntry(t, r, key, e)
+	}
+	// 正在下发的消息在下发结束后处理
+	r.inflight[outboxKey("d2", now, 3)] = true
+
+	r.expireOutboxes()
+	left, err := r.outboxEntries("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, key := range sortedKeys(left) {
+		ids = append(ids, left[key].MessageID)
+	}
+	if len(ids) != 2 || ids[0] != "fresh" || ids[1] != "busy" {
+		t.Fatalf("清理后剩余 %v", ids)
+	}
+}
+
+func TestSweepOutboxStops(t *testing.T) {
+	r := newTestRouter(t)
+	done := make(chan struct{})
+	go func() {
+		r.sweepOutbox()
+		close(done)
+	}()
+	r.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop 后清理协程未退出")
+	}
+	// 可重复调用，未启用时为nil
+	r.Stop()
+	var disabled *Router
+	disabled.Stop()
+}
+
+func TestPurge(t *testing.T) {
+	r := newTestRouter(t)
+	now := time.Now()
+	for i, number := range []string{"d1", "d1", "d10"} {
+		putEntry(t, r, outboxKey(number, now, uint64(i)), outboxEntry{DeviceNumber: number, Kind: KindCommand, MessageID: "m"})
+	}
+	n, err := r.Purge("d1")
+	if err != nil || n != 2 {
+		t.Fatalf("清除 %d 条, %v", n, err)
+	}
+	if left, _ := r.outboxEntries(""); len(left) != 1 {
+		t.Fatalf("清除后剩余 %d 条，期望保留 d10 的消息", len(left))
+	}
+}
@@ -0,0 +1,9 @@
+package formjson
+
+import "embed"
+
+// Files 内置的表单定义，编译进程序，不依赖运行目录；子目录 <protocol_type>/ 为协议类型专属的表单，
+// 非表单文件(如本包源码)在加载时忽略
+//
+//go:embed *
+var Files embed.FS
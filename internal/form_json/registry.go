@@ -0,0 +1,144 @@
+package formjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 表单类型
+const (
+	FormConfig         = "CFG"  // 设备配置
+	FormVoucher        = "VCR"  // 设备凭证
+	FormServiceVoucher = "SVCR" // 服务接入点凭证
+)
+
+// Key 表单的定位条件，协议类型和设备类型为空时表示公共/默认表单
+type Key struct {
+	ProtocolType string `json:"protocol_type,omitempty"`
+	FormType     string `json:"form_type"`
+	DeviceType   string `json:"device_type,omitempty"`
+}
+
+// formFilePattern 匹配表单文件名，子匹配为表单类型和设备类型
+var formFilePattern = regexp.MustCompile(`^form_(cfg|vcr|service_voucher)(?:_([A-Za-z0-9_-]{1,64}))?\.json$`)
+
+// FileName 表单文件名：CFG 为 form_cfg[_<device_type>].json，VCR 为 form_vcr[_<device_type>].json，
+// SVCR 为 form_service_voucher[_<device_type>].json
+func FileName(formType, deviceType string) string {
+	name := "form_" + strings.ToLower(formType)
+	if formType == FormServiceVoucher {
+		name = "form_service_voucher"
+	}
+	if deviceType != "" {
+		name += "_" + deviceType
+	}
+	return name + ".json"
+}
+
+// FileNames 查找表单时依次尝试的文件名，默认VCR表单兼容 form_voucher.json
+func FileNames(formType, deviceType string) []string {
+	names := []string{FileName(formType, deviceType)}
+	if formType == FormVoucher && deviceType == "" {
+		names = append(names, "form_voucher.json")
+	}
+	return names
+}
+
+// ParseFileName 解析表单文件名，不是表单文件时返回false
+func ParseFileName(name string) (formType, deviceType string, ok bool) {
+	if name == "form_voucher.json" {
+		return FormVoucher, "", true
+	}
+	m := formFilePattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", false
+	}
+	if m[1] == "service_voucher" {
+		return FormServiceVoucher, m[2], true
+	}
+	return strings.ToUpper(m[1]), m[2], true
+}
+
+// Registry 按 协议类型/设备类型/表单类型 索引的表单定义，用于为不同形态的ESP32产品(音箱、传感器节点、网关)
+// 提供不同的表单。根目录下的表单为公共表单，<protocol_type>/ 子目录下的为协议类型专属表单
+type Registry struct {
+	forms map[Key]interface{}
+}
+
+// NewRegistry 加载 fsys 中的表单，表单不是合法JSON或同一位置有多个文件时返回错误
+func NewRegistry(fsys fs.FS) (*Registry, error) {
+	r := &Registry{forms: make(map[Key]interface{})}
+	files := make(map[Key]string)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dir, name := path.Split(p)
+		dir = strings.TrimSuffix(dir, "/")
+		if d.IsDir() {
+			// 只加载根目录和一层协议类型子目录
+			if p != "." && strings.Contains(p, "/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		formType, deviceType, ok := ParseFileName(name)
+		if !ok {
+			return nil
+		}
+		key := Key{ProtocolType: dir, FormType: formType, DeviceType: deviceType}
+		if prev, dup := files[key]; dup {
+			return fmt.Errorf("表单 %s 与 %s 重复", p, prev)
+		}
+		raw, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		var form interface{}
+		if err := json.Unmarshal(raw, &form); err != nil {
+			return fmt.Errorf("解析表单 %s 失败: %v", p, err)
+		}
+		files[key] = p
+		r.forms[key] = form
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Embedded 加载内置的表单
+func Embedded() (*Registry, error) {
+	return NewRegistry(Files)
+}
+
+// Get 返回指定位置的表单，不按设备类型或协议类型回退；返回的表单会被共享，调用方不得修改
+func (r *Registry) Get(key Key) (interface{}, bool) {
+	form, ok := r.forms[key]
+	return form, ok
+}
+
+// Keys 返回全部表单的位置，按协议类型、表单类型、设备类型排序
+func (r *Registry) Keys() []Key {
+	keys := make([]Key, 0, len(r.forms))
+	for k := range r.forms {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.ProtocolType != b.ProtocolType {
+			return a.ProtocolType < b.ProtocolType
+		}
+		if a.FormType != b.FormType {
+			return a.FormType < b.FormType
+		}
+		return a.DeviceType < b.DeviceType
+	})
+	return keys
+}
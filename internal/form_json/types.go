@@ -1,5 +1,11 @@
 package formjson
 
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
 type SVCRForm struct {
 	ServerURL string `json:"ServerURL"`
 	Username  string `json:"Username"`
@@ -12,4 +18,30 @@ type Voucher struct {
 	AuthType          string `json:"AuthType"`
 	ThingsPanelApiKey string `json:"ThingsPanelApiKey"`
 	ThingsPanelApiURL string `json:"ThingsPanelApiURL"`
+	// DescriptionTemplate 返回平台的设备描述模板(text/template)，为空时使用配置的默认模板
+	DescriptionTemplate string `json:"DescriptionTemplate,omitempty"`
+	// Headers 调用小智服务端时附加的请求头，如网关要求的租户ID、API密钥，每行或以分号分隔一个 "Name: Value"
+	Headers string `json:"Headers,omitempty"`
+}
+
+// DeviceVoucher 设备凭证(form_voucher.json)，设备以此鉴权
+type DeviceVoucher struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Match 以固定时间比较设备提交的用户名和密码，凭证未设置密码时不通过
+func (v DeviceVoucher) Match(username, password string) bool {
+	if v.Password == "" {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(v.Username), []byte(username))
+	passOK := subtle.ConstantTimeCompare([]byte(v.Password), []byte(password))
+	return userOK&passOK == 1
+}
+
+// VoucherKey 凭证内容的摘要，用于在存储键、配置和接口中标识租户而不暴露密钥
+func VoucherKey(rawVoucher string) string {
+	sum := sha256.Sum256([]byte(rawVoucher))
+	return hex.EncodeToString(sum[:8])
 }
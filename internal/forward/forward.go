@@ -0,0 +1,423 @@
+// internal/forward/forward.go
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/pkg/useragent"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// 事件类型
+const (
+	EventTelemetry = "telemetry"
+	EventStatus    = "status"
+	EventEvent     = "event"
+)
+
+// 转发参数默认值
+const (
+	DefaultQueueSize = 1000
+	DefaultTimeout   = 5 * time.Second
+	sendAttempts     = 3
+	retryBackoff     = time.Second
+)
+
+var forwarded = metrics.NewCounterVec("tp_plugin_forward_total",
+	"转发到其他插件的事件数", "target", "result")
+
+// Target 一个转发目标
+type Target struct {
+	Name        string                 // 目标名称，用于日志和指标
+	URL         string                 // http(s):// 以POST发送JSON；mqtt(s):// 或 tcp:// 发布到 Topic
+	Topic       string                 // MQTT主题，支持 {event}、{device_number}、{device_id} 占位符
+	Username    string                 // MQTT用户名
+	Password    string                 // MQTT密码
+	Headers     map[string]string      // HTTP请求头，如目标插件的鉴权令牌
+	Events      []string               // 转发的事件类型 telemetry/status/event，为空时全部转发
+	DeviceTypes []string               // 只转发这些设备类型，为空时不限制
+	Fields      map[string]string      // 字段映射 源字段 -> 目标字段，配置后只转发映射的字段
+	Static      map[string]interface{} // 附加到每条事件 values 中的固定字段
+	QueueSize   int                    // 待发送队列长度，满时丢弃，0 使用默认值
+	TimeoutMs   int                    // 单次发送超时(毫秒)，0 使用默认值
+}
+
+// Event 归一化后转发给其他插件的事件
+type Event struct {
+	Source       string                 `json:"source"` // 插件名称
+	Type         string                 `json:"type"`
+	DeviceID     string                 `json:"device_id"`
+	DeviceNumber string                 `json:"device_number,omitempty"`
+	DeviceType   string                 `json:"device_type,omitempty"`
+	Timestamp    int64                  `json:"ts"` // 毫秒
+	Values       map[string]interface{} `json:"values"`
+}
+
+// sender 发送到目标的传输方式
+type sender interface {
+	send(ctx context.Context, ev *Event, body []byte) error
+	close()
+}
+
+// target 已启动的转发目标
+type target struct {
+	cfg     Target
+	events  map[string]bool
+	types   map[string]bool
+	queue   chan *Event
+	sender  sender
+	timeout time.Duration
+	stop    chan struct{} // 重新加载后停止该目标
+	done    chan struct{} // 发送协程已退出
+}
+
+// Forwarder 将设备事件按规则映射后异步转发到其他插件的HTTP/MQTT端点
+// 每个目标独立排队，目标不可用时不影响平台上报和其他目标
+type Forwarder struct {
+	mu      sync.RWMutex
+	targets []*target
+	logger  *logrus.Logger
+	wg      sync.WaitGroup
+	stopCh  chan struct{}
+	once    sync.Once
+}
+
+// New 创建转发器并启动各目标的发送协程，未配置目标时返回nil
+func New(targets []Target, logger *logrus.Logger) (*Forwarder, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	f := &Forwarder{logger: logger, stopCh: make(chan struct{})}
+	list, err := f.build(targets)
+	if err != nil {
+		return nil, err
+	}
+	f.targets = list
+	f.start(list)
+	return f, nil
+}
+
+// build 按配置创建转发目标，任一目标配置错误时关闭已创建的连接并返回错误
+func (f *Forwarder) build(targets []Target) ([]*target, error) {
+	var list []*target
+	for i, cfg := range targets {
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("target-%d", i+1)
+		}
+		s, err := newSender(cfg, f.logger)
+		if err != nil {
+			for _, t := range list {
+				t.sender.close()
+			}
+			return nil, fmt.Errorf("转发目标 %s 配置错误: %v", cfg.Name, err)
+		}
+		t := &target{
+			cfg:     cfg,
+			events:  toSet(cfg.Events),
+			types:   toSet(cfg.DeviceTypes),
+			sender:  s,
+			timeout: DefaultTimeout,
+			stop:    make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		if cfg.TimeoutMs > 0 {
+			t.timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+		}
+		size := cfg.QueueSize
+		if size <= 0 {
+			size = DefaultQueueSize
+		}
+		t.queue = make(chan *Event, size)
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// start 启动各目标的发送协程
+func (f *Forwarder) start(list []*target) {
+	for _, t := range list {
+		f.wg.Add(1)
+		go f.run(t)
+		f.logger.WithFields(logrus.Fields{"target": t.cfg.Name, "url": redactURL(t.cfg.URL)}).Info("已启用插件事件转发")
+	}
+}
+
+// Reload 以新的目标配置替换全部转发目标，配置错误时保留原目标；
+// 原目标停止接收新事件，队列中未发送的事件丢弃，正在发送的事件发送完成后断开连接
+func (f *Forwarder) Reload(targets []Target) error {
+	if f == nil {
+		return fmt.Errorf("启动时未配置转发目标，需重启后生效")
+	}
+	list, err := f.build(targets)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	old := f.targets
+	f.targets = list
+	f.mu.Unlock()
+	f.start(list)
+	for _, t := range old {
+		close(t.stop)
+		go func(t *target) {
+			<-t.done
+			t.sender.close()
+		}(t)
+	}
+	return nil
+}
+
+func newSender(cfg Target, logger *logrus.Logger) (sender, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("解析地址失败: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpSender{url: cfg.URL, headers: cfg.Headers, client: httpclient.Client()}, nil
+	case "mqtt", "mqtts", "tcp", "ssl", "ws", "wss":
+		if cfg.Topic == "" {
+			return nil, fmt.Errorf("MQTT目标缺少 topic")
+		}
+		return newMQTTSender(cfg, u, logger), nil
+	default:
+		return nil, fmt.Errorf("不支持的地址协议: %s", u.Scheme)
+	}
+}
+
+// Publish 按各目标的规则映射事件并放入发送队列，不阻塞调用方；f 为nil时不转发
+func (f *Forwarder) Publish(ev Event) {
+	if f == nil {
+		return
+	}
+	if ev.Source == "" {
+		ev.Source = useragent.Get().Name
+	}
+	if ev.Timestamp == 0 {
+		ev.Timestamp = time.Now().UnixMilli()
+	}
+	f.mu.RLock()
+	targets := f.targets
+	f.mu.RUnlock()
+	for _, t := range targets {
+		if !t.match(&ev) {
+			continue
+		}
+		mapped := ev
+		mapped.Values = t.mapValues(ev.Values)
+		select {
+		case t.queue <- &mapped:
+		default:
+			forwarded.WithLabelValues(t.cfg.Name, "queue_full").Inc()
+		}
+	}
+}
+
+// match 事件类型和设备类型是否在目标的转发范围内
+func (t *target) match(ev *Event) bool {
+	if len(t.events) > 0 && !t.events[ev.Type] {
+		return false
+	}
+	return len(t.types) == 0 || t.types[ev.DeviceType]
+}
+
+// mapValues 按字段映射复制数据并附加固定字段，返回新的map，不修改原数据
+func (t *target) mapValues(values map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(values)+len(t.cfg.Static))
+	if len(t.cfg.Fields) > 0 {
+		for src, dst := range t.cfg.Fields {
+			if v, ok := values[src]; ok {
+				out[dst] = v
+			}
+		}
+	} else {
+		for k, v := range values {
+			out[k] = v
+		}
+	}
+	for k, v := range t.cfg.Static {
+		out[k] = v
+	}
+	return out
+}
+
+// run 逐条发送队列中的事件，失败时重试，仍失败则丢弃
+func (f *Forwarder) run(t *target) {
+	defer f.wg.Done()
+	defer close(t.done)
+	for {
+		select {
+		case <-f.stopCh:
+			return
+		case <-t.stop:
+			return
+		case ev := <-t.queue:
+			f.deliver(t, ev)
+		}
+	}
+}
+
+func (f *Forwarder) deliver(t *target, ev *Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		forwarded.WithLabelValues(t.cfg.Name, "error").Inc()
+		f.logger.WithError(err).WithField("target", t.cfg.Name).Warn("序列化转发事件失败")
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+		err = t.sender.send(ctx, ev, body)
+		cancel()
+		if err == nil {
+			forwarded.WithLabelValues(t.cfg.Name, "ok").Inc()
+			return
+		}
+		if attempt >= sendAttempts {
+			break
+		}
+		select {
+		case <-f.stopCh:
+			return
+		case <-t.stop:
+			return
+		case <-time.After(retryBackoff * time.Duration(attempt)):
+		}
+	}
+	forwarded.WithLabelValues(t.cfg.Name, "error").Inc()
+	f.logger.WithError(err).WithFields(logrus.Fields{
+		"target":    t.cfg.Name,
+		"event":     ev.Type,
+		"device_id": ev.DeviceID,
+	}).Warn("转发事件失败，已丢弃")
+}
+
+// Close 停止发送协程并断开目标连接，队列中未发送的事件丢弃
+func (f *Forwarder) Close() {
+	if f == nil {
+		return
+	}
+	f.once.Do(func() {
+		close(f.stopCh)
+		f.wg.Wait()
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+		for _, t := range f.targets {
+			t.sender.close()
+		}
+	})
+}
+
+// httpSender 以POST发送JSON
+type httpSender struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (s *httpSender) send(ctx context.Context, _ *Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	useragent.Apply(req)
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("目标返回状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSender) close() {}
+
+// mqttSender 发布到MQTT主题，连接断开时由客户端自动重连
+type mqttSender struct {
+	client mqtt.Client
+	topic  string
+}
+
+func newMQTTSender(cfg Target, u *url.URL, logger *logrus.Logger) *mqttSender {
+	broker := *u
+	switch broker.Scheme {
+	case "mqtt":
+		broker.Scheme = "tcp"
+	case "mqtts":
+		broker.Scheme = "ssl"
+	}
+	if cfg.Username == "" && u.User != nil {
+		cfg.Username = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+	broker.User = nil
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker.String()).
+		SetClientID(fmt.Sprintf("%s-forward-%s-%d", useragent.Get().Name, cfg.Name, time.Now().UnixNano()%100000)).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.WithError(err).WithField("target", cfg.Name).Warn("转发目标MQTT连接断开")
+		})
+	c := mqtt.NewClient(opts)
+	c.Connect()
+	return &mqttSender{client: c, topic: cfg.Topic}
+}
+
+func (s *mqttSender) send(ctx context.Context, ev *Event, body []byte) error {
+	if !s.client.IsConnectionOpen() {
+		return fmt.Errorf("MQTT未连接")
+	}
+	topic := strings.NewReplacer(
+		"{event}", ev.Type,
+		"{device_number}", ev.DeviceNumber,
+		"{device_id}", ev.DeviceID,
+	).Replace(s.topic)
+	token := s.client.Publish(topic, 1, false, body)
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("发布超时: %v", ctx.Err())
+	case <-token.Done():
+		return token.Error()
+	}
+}
+
+func (s *mqttSender) close() {
+	s.client.Disconnect(250)
+}
+
+func toSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, v := range list {
+		set[v] = true
+	}
+	return set
+}
+
+// redactURL 日志中隐藏地址里的用户名密码
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = url.User("******")
+	return u.String()
+}
@@ -0,0 +1,545 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"tp-plugin/internal/audit"
+	"tp-plugin/internal/capture"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/pipeline"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AdminHandler 返回管理接口处理器，挂载在 RoutePath("/admin/") 下，令牌鉴权由中间件完成
+func (h *HTTPHandler) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(h.RoutePath("/admin/devices/bind"), h.adminBind)
+	mux.HandleFunc(h.RoutePath("/admin/trace"), h.adminTrace)
+	mux.HandleFunc(h.RoutePath("/admin/mqtt/capture"), h.adminCapture)
+	mux.HandleFunc(h.RoutePath("/admin/tenants/health"), h.adminTenantHealth)
+	mux.HandleFunc(h.RoutePath("/admin/forms"), h.adminForms)
+	mux.HandleFunc(h.RoutePath("/admin/forms/validate"), h.adminFormValidate)
+	mux.HandleFunc(h.RoutePath("/admin/pipelines"), h.adminPipelines)
+	mux.HandleFunc(h.RoutePath("/admin/pipelines/runs"), h.adminPipelineRuns)
+	mux.HandleFunc(h.RoutePath("/admin/shadows"), h.adminShadows)
+	mux.HandleFunc(h.RoutePath("/admin/broadcasts"), h.adminBroadcasts)
+	mux.HandleFunc(h.RoutePath("/admin/collisions"), h.adminCollisions)
+	mux.HandleFunc(h.RoutePath("/admin/support-bundle"), h.adminSupportBundle)
+	mux.HandleFunc(h.RoutePath("/admin/devices/loss"), h.adminDeviceLoss)
+	mux.HandleFunc(h.RoutePath("/admin/devices/health"), h.adminDeviceHealth)
+	mux.HandleFunc(h.RoutePath("/admin/fleet"), h.adminFleet)
+	mux.HandleFunc(h.RoutePath("/admin/tunnels"), h.adminTunnels)
+	mux.HandleFunc(h.RoutePath("/admin/startup"), h.adminStartup)
+	mux.HandleFunc(h.RoutePath("/admin/sessions"), h.adminSessions)
+	mux.HandleFunc(h.RoutePath("/admin/heartbeats"), h.adminHeartbeats)
+	mux.HandleFunc(h.RoutePath("/admin/devices/ping"), h.adminDevicePing)
+	mux.HandleFunc(h.RoutePath("/admin/devices/purge"), h.adminDevicePurge)
+	mux.HandleFunc(h.RoutePath("/admin/ota"), h.adminOTA)
+	mux.HandleFunc(h.RoutePath("/admin/tokens"), h.adminTokens)
+	mux.HandleFunc(h.RoutePath("/admin/devices/notes"), h.adminDeviceNotes)
+	mux.HandleFunc(h.RoutePath("/admin/devices/status"), h.adminDeviceStatus)
+	mux.HandleFunc(h.RoutePath("/admin/snapshots"), h.adminSnapshots)
+	mux.HandleFunc(h.RoutePath("/admin/snapshots/replay"), h.adminSnapshotReplay)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l, ok := requestLocale(r); ok {
+			r = r.WithContext(i18n.WithLocale(r.Context(), l))
+		}
+		r = r.WithContext(audit.WithActor(r.Context(), adminActor(r.Context())))
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminResponse 管理接口响应，与平台回调接口的响应格式一致
+type adminResponse struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func writeAdmin(w http.ResponseWriter, status int, resp adminResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func adminOK(w http.ResponseWriter, r *http.Request, data interface{}) {
+	writeAdmin(w, http.StatusOK, adminResponse{Code: 200, Message: i18n.Tc(r.Context(), "admin.success"), Data: data})
+}
+
+// adminError 小智服务端错误按错误码返回对应的HTTP状态，其余错误视为请求错误
+func adminError(w http.ResponseWriter, err error) {
+	var uerr *UpstreamError
+	if errors.As(err, &uerr) {
+		status := http.StatusBadGateway
+		switch uerr.Code {
+		case CodeBindConflict:
+			status = http.StatusConflict
+		case CodeUpstreamTimeout:
+			status = http.StatusGatewayTimeout
+		case CodeUpstreamThrottled:
+			status = http.StatusTooManyRequests
+		case CodeUpstreamUnavailable:
+			status = http.StatusServiceUnavailable
+		}
+		writeAdmin(w, status, adminResponse{Code: uerr.Code, Message: uerr.Error()})
+		return
+	}
+	writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: err.Error()})
+}
+
+// decodeAdmin 校验请求方法并解析JSON请求体，失败时已写入响应
+func decodeAdmin(w http.ResponseWriter, r *http.Request, method string, v interface{}) bool {
+	if r.Method != method {
+		writeAdmin(w, http.StatusMethodNotAllowed, adminResponse{Code: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)})
+		return false
+	}
+	if v == nil {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", err.Error())})
+		return false
+	}
+	return true
+}
+
+// adminBind POST /admin/devices/bind 绑定设备到智能体，force=true 时强制重新绑定
+func (h *HTTPHandler) adminBind(w http.ResponseWriter, r *http.Request) {
+	var req BindRequest
+	if !decodeAdmin(w, r, http.MethodPost, &req) {
+		return
+	}
+	if req.Voucher == "" || req.DeviceNumber == "" {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "voucher, device_number")})
+		return
+	}
+	if err := h.Bind(r.Context(), req); err != nil {
+		adminError(w, err)
+		return
+	}
+	adminOK(w, r, nil)
+}
+
+// traceRequest 开启设备追踪
+type traceRequest struct {
+	DeviceNumber string `json:"device_number"`
+	Minutes      int    `json:"minutes"` // 追踪时长，超过配置上限时按上限处理
+}
+
+// adminTrace 设备追踪开关
+// GET 列出进行中的追踪；POST {"device_number","minutes"} 开启；DELETE ?device_number= 关闭
+func (h *HTTPHandler) adminTrace(w http.ResponseWriter, r *http.Request) {
+	if h.tracer == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: i18n.Tc(r.Context(), "trace.disabled")})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		adminOK(w, r, h.tracer.Sessions())
+	case http.MethodPost:
+		var req traceRequest
+		if !decodeAdmin(w, r, http.MethodPost, &req) {
+			return
+		}
+		session, err := h.tracer.Enable(req.DeviceNumber, time.Duration(req.Minutes)*time.Minute)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, session)
+	case http.MethodDelete:
+		if !h.tracer.Disable(r.URL.Query().Get("device_number")) {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: i18n.Tc(r.Context(), "trace.not_found")})
+			return
+		}
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+type captureRequest struct {
+	DeviceID     string `json:"device_id"`
+	DeviceNumber string `json:"device_number"` // 未填写 device_id 时按设备编号查找设备ID
+	Topic        string `json:"topic"`         // MQTT主题模式，支持 + 和 # 通配符
+	Minutes      int    `json:"minutes"`       // 抓包时长，超过配置上限时按上限处理
+	MaxMB        int    `json:"max_mb"`        // 文件大小上限，超过配置上限时按上限处理
+}
+
+// adminCapture MQTT抓包开关
+// GET 返回进行中或最近一次的抓包；POST {"device_id"|"device_number","topic","minutes","max_mb"} 开始；DELETE 停止
+func (h *HTTPHandler) adminCapture(w http.ResponseWriter, r *http.Request) {
+	if h.capture == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: i18n.Tc(r.Context(), "capture.disabled")})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		status, ok := h.capture.Status()
+		if !ok {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: i18n.Tc(r.Context(), "capture.not_found")})
+			return
+		}
+		adminOK(w, r, status)
+	case http.MethodPost:
+		var req captureRequest
+		if !decodeAdmin(w, r, http.MethodPost, &req) {
+			return
+		}
+		if req.DeviceID == "" && req.DeviceNumber != "" {
+			device, err := h.platform.GetDevice(req.DeviceNumber)
+			if err != nil {
+				adminError(w, err)
+				return
+			}
+			req.DeviceID = device.ID
+		}
+		filter := capture.Filter{DeviceID: req.DeviceID, Topic: req.Topic}
+		status, err := h.capture.Start(filter, time.Duration(req.Minutes)*time.Minute, int64(req.MaxMB)<<20)
+		if errors.Is(err, capture.ErrActive) {
+			writeAdmin(w, http.StatusConflict, adminResponse{Code: http.StatusConflict, Message: err.Error(), Data: status})
+			return
+		}
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, status)
+	case http.MethodDelete:
+		status, ok := h.capture.Stop("manual")
+		if !ok {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: i18n.Tc(r.Context(), "capture.not_found")})
+			return
+		}
+		adminOK(w, r, status)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+// adminTenantHealth GET /admin/tenants/health 并发探测全部租户，返回健康矩阵
+func (h *HTTPHandler) adminTenantHealth(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	report, err := h.TenantHealth(r.Context())
+	if err != nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: err.Error()})
+		return
+	}
+	adminOK(w, r, report)
+}
+
+// formUpdate 保存表单的请求
+type formUpdate struct {
+	FormKey
+	Form json.RawMessage `json:"form"`
+}
+
+// adminForms 表单编辑
+// GET 无 form_type 时列出存储中的表单，有 form_type 时返回当前生效的表单及来源；
+// PUT {"protocol_type","form_type","device_type","form"} 保存；DELETE ?form_type=&device_type=&protocol_type= 恢复为文件
+func (h *HTTPHandler) adminForms(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: "本地存储未启用"})
+		return
+	}
+	q := r.URL.Query()
+	key := FormKey{ProtocolType: q.Get("protocol_type"), FormType: q.Get("form_type"), DeviceType: q.Get("device_type")}
+	switch r.Method {
+	case http.MethodGet:
+		if key.FormType == "" {
+			list, err := h.StoredForms()
+			if err != nil {
+				adminError(w, err)
+				return
+			}
+			adminOK(w, r, list)
+			return
+		}
+		if err := key.validate(); err != nil {
+			adminError(w, err)
+			return
+		}
+		form, source := h.resolveForm(key)
+		adminOK(w, r, map[string]interface{}{"source": source, "form": form})
+	case http.MethodPut:
+		var req formUpdate
+		if !decodeAdmin(w, r, http.MethodPut, &req) {
+			return
+		}
+		if err := h.SaveForm(req.FormKey, req.Form); err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, nil)
+	case http.MethodDelete:
+		if err := h.DeleteForm(key); err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+// formValidateRequest 按CFG表单校验配置值
+type formValidateRequest struct {
+	ProtocolType string                 `json:"protocol_type"`
+	DeviceType   string                 `json:"device_type"`
+	Values       map[string]interface{} `json:"values"`
+}
+
+// adminFormValidate 配置值校验
+// POST {"protocol_type","device_type","values"}，按当前生效的CFG表单校验(含必填)，返回不合法的项，全部合法时为空列表
+func (h *HTTPHandler) adminFormValidate(w http.ResponseWriter, r *http.Request) {
+	var req formValidateRequest
+	if !decodeAdmin(w, r, http.MethodPost, &req) {
+		return
+	}
+	form, _ := h.resolveForm(FormKey{ProtocolType: req.ProtocolType, FormType: "CFG", DeviceType: req.DeviceType})
+	errs := validateFormValues(form, req.Values, false)
+	if errs == nil {
+		errs = []FieldError{}
+	}
+	adminOK(w, r, errs)
+}
+
+// pipelineStartRequest 启动接入流水线
+type pipelineStartRequest struct {
+	Pipeline string `json:"pipeline"`
+	pipeline.Input
+}
+
+// adminPipelines 设备接入流水线
+// GET 列出流水线定义；POST {"pipeline","voucher","device_number","vars"} 启动，返回运行状态
+func (h *HTTPHandler) adminPipelines(w http.ResponseWriter, r *http.Request) {
+	if h.pipelines == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: "未配置设备接入流水线"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		adminOK(w, r, h.pipelines.Pipelines())
+	case http.MethodPost:
+		var req pipelineStartRequest
+		if !decodeAdmin(w, r, http.MethodPost, &req) {
+			return
+		}
+		if req.Voucher == "" {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "voucher")})
+			return
+		}
+		run, err := h.pipelines.Start(req.Pipeline, req.Input)
+		if errors.Is(err, pipeline.ErrRunning) {
+			writeAdmin(w, http.StatusConflict, adminResponse{Code: http.StatusConflict, Message: err.Error()})
+			return
+		}
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, run)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+// adminPipelineRuns 流水线运行状态
+// GET 列出运行记录，带 ?id= 时返回单个运行；DELETE ?id= 取消运行
+func (h *HTTPHandler) adminPipelineRuns(w http.ResponseWriter, r *http.Request) {
+	if h.pipelines == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: "未配置设备接入流水线"})
+		return
+	}
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			adminOK(w, r, h.pipelines.Runs())
+			return
+		}
+		run, ok := h.pipelines.Get(id)
+		if !ok {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		adminOK(w, r, run)
+	case http.MethodDelete:
+		if !h.pipelines.Cancel(id) {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+// adminBroadcasts 广播命令
+// GET 列出广播进度，带 ?id= 时返回单个广播；POST {"voucher","command","params","rate"} 启动；DELETE ?id= 取消
+func (h *HTTPHandler) adminBroadcasts(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			adminOK(w, r, h.broadcasts.all())
+			return
+		}
+		b, ok := h.broadcasts.get(id)
+		if !ok {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		adminOK(w, r, b.snapshot())
+	case http.MethodPost:
+		var req BroadcastRequest
+		if !decodeAdmin(w, r, http.MethodPost, &req) {
+			return
+		}
+		req.Actor = auditActor(r.Context())
+		b, err := h.StartBroadcast(req)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, b)
+	case http.MethodDelete:
+		if !h.CancelBroadcast(id) {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+// adminDeviceLoss 按消息序号统计的设备丢包情况
+// GET 列出全部设备(丢包率高的在前)，?device_number= 查询单台；DELETE ?device_number= 清除统计，不带参数时清除全部
+func (h *HTTPHandler) adminDeviceLoss(w http.ResponseWriter, r *http.Request) {
+	number := r.URL.Query().Get("device_number")
+	deviceID := ""
+	if number != "" {
+		device, err := h.platform.GetDevice(number)
+		if err != nil {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: err.Error()})
+			return
+		}
+		deviceID = device.ID
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if deviceID == "" {
+			adminOK(w, r, h.platform.AllSequenceStats())
+			return
+		}
+		stats, ok := h.platform.SequenceStats(deviceID)
+		if !ok {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		adminOK(w, r, stats)
+	case http.MethodDelete:
+		h.platform.ResetSequenceStats(deviceID)
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+// defaultWorstDevices 健康报告默认返回的设备数
+const defaultWorstDevices = 20
+
+// adminDeviceHealth GET 健康评分最低的设备，?limit= 指定数量(默认20，0为全部)
+func (h *HTTPHandler) adminDeviceHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		decodeAdmin(w, r, http.MethodGet, nil)
+		return
+	}
+	limit := defaultWorstDevices
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "limit")})
+			return
+		}
+		limit = n
+	}
+	adminOK(w, r, h.platform.WorstDevices(limit))
+}
+
+// adminFleet GET 最近一次按凭证汇总的设备群统计
+func (h *HTTPHandler) adminFleet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		decodeAdmin(w, r, http.MethodGet, nil)
+		return
+	}
+	adminOK(w, r, h.platform.FleetStats())
+}
+
+// adminTunnels GET 反向隧道的连接状态
+func (h *HTTPHandler) adminTunnels(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	adminOK(w, r, h.tunnels.Agents())
+}
+
+// adminStartup GET 启动依赖(MQTT连接、平台下发主题订阅、服务元数据注册)的就绪状态及重试情况
+func (h *HTTPHandler) adminStartup(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	adminOK(w, r, h.startup.Status())
+}
+
+// adminSessions GET 设备直连WebSocket会话
+func (h *HTTPHandler) adminSessions(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	adminOK(w, r, h.devices.Sessions())
+}
+
+// adminHeartbeats 按心跳跟踪的回调上报设备
+func (h *HTTPHandler) adminHeartbeats(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	adminOK(w, r, h.heartbeats.Devices())
+}
+
+// pingRequest 设备连通性探测请求
+type pingRequest struct {
+	DeviceNumber string `json:"device_number"`
+	TimeoutMs    int    `json:"timeout_ms"` // 等待应答的毫秒数，默认5000，最长30000
+}
+
+// adminDevicePing POST /admin/devices/ping 经设备WebSocket会话探测设备是否可达及往返时延，
+// 设备未直连插件或未应答时 reachable 为 false 并返回原因
+func (h *HTTPHandler) adminDevicePing(w http.ResponseWriter, r *http.Request) {
+	var req pingRequest
+	if !decodeAdmin(w, r, http.MethodPost, &req) {
+		return
+	}
+	if req.DeviceNumber == "" {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "device_number")})
+		return
+	}
+	result, err := h.devices.Probe(req.DeviceNumber, time.Duration(req.TimeoutMs)*time.Millisecond)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	h.log(r.Context()).WithFields(logrus.Fields{
+		"device_number": req.DeviceNumber,
+		"reachable":     result.Reachable,
+		"rtt_ms":        result.RTTMs,
+	}).Info("设备连通性探测")
+	adminOK(w, r, result)
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"tp-plugin/internal/audit"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/middleware"
+)
+
+// adminActor 管理接口请求的触发者，未启用鉴权时为 admin
+func adminActor(ctx context.Context) string {
+	if principal, ok := middleware.PrincipalFromContext(ctx); ok {
+		return "admin:" + principal.Name
+	}
+	return "admin"
+}
+
+// auditActor 审计记录的触发者：管理接口及后台任务(广播、流水线)通过 audit.WithActor 指定，其余为平台回调
+func auditActor(ctx context.Context) string {
+	if actor, ok := audit.ActorFromContext(ctx); ok {
+		return actor
+	}
+	return "platform"
+}
+
+// recordAudit 记录一次变更类出站调用，请求体只保存摘要
+func (h *HTTPHandler) recordAudit(ctx context.Context, voucher formjson.Voucher, path string, body []byte, idempotencyKey string, status int, err error) {
+	if h.auditLog == nil {
+		return
+	}
+	var target struct {
+		DeviceNumber string `json:"device_number"`
+		Voucher      string `json:"voucher"`
+	}
+	json.Unmarshal(body, &target)
+	sum := sha256.Sum256(body)
+	r := audit.Record{
+		Actor:          auditActor(ctx),
+		RequestID:      middleware.RequestIDFromContext(ctx),
+		ServerURL:      voucher.ServerURL,
+		Path:           path,
+		DeviceNumber:   target.DeviceNumber,
+		BodySHA256:     hex.EncodeToString(sum[:]),
+		IdempotencyKey: idempotencyKey,
+		Status:         status,
+	}
+	if target.Voucher != "" {
+		r.Tenant = formjson.VoucherKey(target.Voucher)
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	h.auditLog.Record(r)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"tp-plugin/internal/audit"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/middleware"
+)
+
+// requestContext 返回经过请求ID中间件的上下文
+func requestContext(id string) context.Context {
+	var ctx context.Context
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(middleware.RequestIDHeader, id)
+	middleware.RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	return ctx
+}
+
+func TestRecordAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.New(audit.Config{Enabled: true, FilePath: path, Key: "audit-key"}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t, Config{Audit: auditLog})
+	voucher := formjson.Voucher{ServerURL: "http://xiaozhi.local", Secret: "s3cret-value"}
+	const rawVoucher = `{"server_url":"http://xiaozhi.local","secret":"s3cret-value"}`
+	bindBody, _ := json.Marshal(map[string]string{"device_number": "A4:CF:12:00:00:01", "voucher": rawVoucher})
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		path string
+		body []byte
+		key  string
+		code int
+		err  error
+		want audit.Record
+	}{
+		{
+			name: "platform bind",
+			ctx:  context.Background(),
+			path: "/device/bind", body: bindBody, key: "k1", code: 200,
+			want: audit.Record{Actor: "platform", Path: "/device/bind", DeviceNumber: "A4:CF:12:00:00:01",
+				Tenant: formjson.VoucherKey(rawVoucher), IdempotencyKey: "k1", Status: 200},
+		},
+		{
+			name: "pipeline command failed",
+			ctx:  audit.WithActor(requestContext("req-1"), "pipeline:run-1"),
+			path: "/device/command", body: []byte(`{"device_number":"A4:CF:12:00:00:02","command":"reboot"}`), code: 0,
+			err: errors.New("upstream timeout"),
+			want: audit.Record{Actor: "pipeline:run-1", RequestID: "req-1", Path: "/device/command",
+				DeviceNumber: "A4:CF:12:00:00:02", Error: "upstream timeout"},
+		},
+		{
+			name: "non-json body",
+			ctx:  requestContext("req-2"),
+			path: "/device/unbind", body: []byte("raw"), code: 502,
+			want: audit.Record{Actor: "platform", RequestID: "req-2", Path: "/device/unbind", Status: 502},
+		},
+	}
+	for _, c := range cases {
+		h.recordAudit(c.ctx, voucher, c.path, c.body, c.key, c.code, c.err)
+	}
+	auditLog.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret-value") {
+		t.Fatal("审计日志中包含凭证内容")
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(cases) {
+		t.Fatalf("写入 %d 条记录，期望 %d 条", len(lines), len(cases))
+	}
+	for i, c := range cases {
+		var got audit.Record
+		if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(c.body)
+		c.want.ServerURL = voucher.ServerURL
+		c.want.BodySHA256 = hex.EncodeToString(sum[:])
+		c.want.Seq = uint64(i + 1)
+		c.want.Time, c.want.Prev, c.want.Signature = got.Time, got.Prev, got.Signature
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: 记录 %+v，期望 %+v", c.name, got, c.want)
+		}
+	}
+	if n, _, err := audit.Verify(strings.NewReader(string(data)), []byte("audit-key"), ""); err != nil || n != len(cases) {
+		t.Fatalf("校验 %d 条, %v", n, err)
+	}
+}
+
+func TestRecordAuditDisabled(t *testing.T) {
+	h := newTestHandler(t, Config{})
+	// 未启用审计日志时不记录也不报错
+	h.recordAudit(context.Background(), formjson.Voucher{}, "/device/bind", []byte(`{}`), "", 200, nil)
+}
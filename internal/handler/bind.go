@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/trace"
+	"tp-plugin/internal/upstream"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CodeBindConflict 设备已绑定到其他智能体或租户
+const CodeBindConflict = 409
+
+// bindConflictHints 小智服务端返回的信息中表示设备已被绑定的关键字
+var bindConflictHints = []string{"已绑定", "已被绑定", "already bound"}
+
+// BindRequest 绑定设备到智能体
+type BindRequest struct {
+	Voucher      string `json:"voucher"` // 服务接入点凭证(JSON字符串)
+	DeviceNumber string `json:"device_number"`
+	AgentID      string `json:"agent_id"`
+	Code         string `json:"code"`  // 设备屏幕上显示的绑定验证码
+	Force        bool   `json:"force"` // 设备已被绑定时先解绑再重新绑定
+}
+
+// deviceLocks 按设备编号串行化绑定操作，避免解绑与重新绑定之间插入其他绑定请求
+var deviceLocks sync.Map
+
+func lockDevice(deviceNumber string) func() {
+	v, _ := deviceLocks.LoadOrStore(deviceNumber, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// isBindConflict 判断绑定失败是否因为设备已被绑定
+func isBindConflict(code int, msg string) bool {
+	if code == http.StatusConflict {
+		return true
+	}
+	lower := strings.ToLower(msg)
+	for _, hint := range bindConflictHints {
+		if strings.Contains(lower, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bind 将设备绑定到智能体
+// 设备已被绑定或设备编号与其他设备冲突时返回 CodeBindConflict；Force 为 true 时在同一设备锁内先解绑再绑定
+func (h *HTTPHandler) Bind(ctx context.Context, req BindRequest) error {
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(req.Voucher), &voucher); err != nil {
+		h.log(ctx).WithError(err).Error(i18n.Td("voucher.parse_failed"))
+		return err
+	}
+
+	unlock := lockDevice(req.DeviceNumber)
+	defer unlock()
+
+	// 编号已属于其他租户或其他设备时拒绝绑定，强制绑定同样不允许，避免两台设备的遥测混在一起
+	if err := h.checkBindCollision(ctx, req.Voucher, req.DeviceNumber); err != nil {
+		h.log(ctx).WithField("device_number", req.DeviceNumber).Warn(err.Error())
+		return err
+	}
+
+	log := h.log(ctx).WithFields(logrus.Fields{
+		"device_number": req.DeviceNumber,
+		"agent_id":      req.AgentID,
+	})
+	err := h.bindUpstream(ctx, voucher, req)
+	if !isConflictError(err) || !req.Force {
+		if err == nil {
+			h.claimBound(req.Voucher, req.DeviceNumber)
+			h.listCache.invalidate(formjson.VoucherKey(req.Voucher), "bind")
+			log.Info(i18n.Td("bind.success"))
+		}
+		return err
+	}
+
+	log.Warn(i18n.Td("bind.force_rebind"))
+	if err := h.unbindUpstream(ctx, voucher, req.Voucher, req.DeviceNumber); err != nil {
+		return err
+	}
+	h.listCache.invalidate(formjson.VoucherKey(req.Voucher), "unbind")
+	if err := h.bindUpstream(ctx, voucher, req); err != nil {
+		// 解绑已生效但重新绑定失败，设备处于未绑定状态，需要人工重试
+		log.WithError(err).Error(i18n.Td("bind.rebind_failed"))
+		return err
+	}
+	h.claimBound(req.Voucher, req.DeviceNumber)
+	h.listCache.invalidate(formjson.VoucherKey(req.Voucher), "bind")
+	log.Info(i18n.Td("bind.success"))
+	return nil
+}
+
+func isConflictError(err error) bool {
+	uerr, ok := err.(*UpstreamError)
+	return ok && uerr.Code == CodeBindConflict
+}
+
+// bindUpstream 调用小智服务端 /device/bind
+func (h *HTTPHandler) bindUpstream(ctx context.Context, voucher formjson.Voucher, req BindRequest) error {
+	h.tracer.Record(req.DeviceNumber, trace.Out, "upstream_bind", map[string]string{"agent_id": req.AgentID})
+	body, err := h.callUpstream(ctx, voucher, req.Voucher, "/device/bind", upstream.DeviceBindRequest{
+		DeviceNumber: req.DeviceNumber,
+		AgentID:      req.AgentID,
+		Code:         req.Code,
+		Voucher:      req.Voucher,
+	})
+	if err != nil {
+		h.tracer.Record(req.DeviceNumber, trace.In, "upstream_bind_error", err.Error())
+	}
+	if uerr, ok := err.(*UpstreamError); ok && uerr.Status == http.StatusConflict {
+		return bindConflictError(ctx, req.DeviceNumber, uerr.Detail)
+	}
+	if err != nil {
+		return err
+	}
+	defer bufpool.Put(body)
+	h.tracer.Record(req.DeviceNumber, trace.In, "upstream_bind_response", body.Bytes())
+
+	var resp upstream.CommonResponse
+	if err := json.Unmarshal(body.Bytes(), &resp); err != nil {
+		return &UpstreamError{Code: CodeUpstreamError, Status: http.StatusOK, Message: upstreamMessage(ctx, CodeUpstreamError), Detail: err.Error()}
+	}
+	if resp.Code != 0 && resp.Code != http.StatusOK && isBindConflict(resp.Code, resp.Msg) {
+		return bindConflictError(ctx, req.DeviceNumber, resp.Msg)
+	}
+	return upstreamCodeError(ctx, resp.Code, resp.Msg)
+}
+
+func bindConflictError(ctx context.Context, deviceNumber, detail string) *UpstreamError {
+	return &UpstreamError{
+		Code:    CodeBindConflict,
+		Status:  http.StatusConflict,
+		Message: i18n.Tc(ctx, "bind.conflict", deviceNumber),
+		Detail:  detail,
+	}
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+)
+
+// CodeUpstreamUnavailable 小智服务地址连续调用失败已熔断，熔断期间直接返回不再发送请求
+const CodeUpstreamUnavailable = 503
+
+// DefaultBreakerOpen 熔断后到允许探测请求的默认时长
+const DefaultBreakerOpen = 30 * time.Second
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var (
+	breakerTransitions = metrics.NewCounterVec("tp_plugin_upstream_breaker_transitions_total",
+		"小智服务地址熔断器状态切换次数", "state")
+	breakerOpenHosts = metrics.NewGaugeVec("tp_plugin_upstream_breaker_open",
+		"当前熔断中的小智服务地址数")
+)
+
+// BreakerConfig 按小智服务地址的熔断配置
+type BreakerConfig struct {
+	Failures int           // 连续失败该次数后熔断，0为不启用
+	Open     time.Duration // 熔断时长，到期后放行一个探测请求，成功则恢复，0使用默认值30秒
+}
+
+// BreakerState 一个小智服务地址的熔断器状态
+type BreakerState struct {
+	Host      string    `json:"host"`
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`            // 连续失败次数
+	OpenedAt  time.Time `json:"opened_at,omitempty"` // 最近一次熔断时间
+	RetryAt   time.Time `json:"retry_at,omitempty"`  // 熔断中时允许探测请求的时间
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// breaker 单个服务地址的熔断器
+type breaker struct {
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool // 半开状态下已放行探测请求
+	lastError string
+}
+
+// circuitBreakers 按小智服务地址(scheme://host)的熔断器。服务地址宕机时调用不再逐个等待超时，
+// 连续失败达到阈值后快速失败，熔断时长到期后放行一个探测请求，成功则恢复、失败则继续熔断
+type circuitBreakers struct {
+	cfg   BreakerConfig
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+// breakerHost 熔断按服务地址的 scheme://host 区分，同一服务的多个租户共享
+func breakerHost(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		return serverURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (b *circuitBreakers) openFor() time.Duration {
+	if b.cfg.Open > 0 {
+		return b.cfg.Open
+	}
+	return DefaultBreakerOpen
+}
+
+// allow 检查是否允许向服务地址发送请求，不允许时返回熔断错误
+func (b *circuitBreakers) allow(ctx context.Context, host string) error {
+	if b.cfg.Failures <= 0 {
+		return nil
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.hosts[host]
+	if br == nil || br.state == BreakerClosed {
+		return nil
+	}
+	retryAt := br.openedAt.Add(b.openFor())
+	if br.state == BreakerOpen && !now.Before(retryAt) {
+		b.transition(br, BreakerHalfOpen)
+	}
+	if br.state == BreakerHalfOpen && !br.probing {
+		br.probing = true
+		return nil
+	}
+	return &UpstreamError{
+		Code:    CodeUpstreamUnavailable,
+		Status:  http.StatusServiceUnavailable,
+		Message: upstreamMessage(ctx, CodeUpstreamUnavailable),
+		Detail:  "circuit open, retry after " + retryAt.Format(time.RFC3339),
+	}
+}
+
+// record 记录一次调用结果。failed 为服务地址的故障(网络错误、超时、5xx)，
+// 请求被调用方取消等与服务地址无关的结果以 ignored 记录，只释放半开状态的探测名额
+func (b *circuitBreakers) record(host string, failed, ignored bool, errMsg string) {
+	if b.cfg.Failures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.hosts == nil {
+		b.hosts = make(map[string]*breaker)
+	}
+	br := b.hosts[host]
+	if br == nil {
+		if !failed || ignored {
+			return
+		}
+		br = &breaker{state: BreakerClosed}
+		b.hosts[host] = br
+	}
+	if ignored {
+		br.probing = false
+		return
+	}
+	if !failed {
+		if br.state != BreakerClosed {
+			b.transition(br, BreakerClosed)
+		}
+		delete(b.hosts, host)
+		b.updateGauge()
+		return
+	}
+	br.failures++
+	br.lastError = errMsg
+	if br.state == BreakerHalfOpen || (br.state == BreakerClosed && br.failures >= b.cfg.Failures) {
+		br.openedAt = time.Now()
+		b.transition(br, BreakerOpen)
+	}
+}
+
+// breakerOutcome 判断调用结果是否为服务地址故障：网络错误、超时及5xx计为失败，
+// 4xx、限流等服务端已正常处理的结果计为成功，调用方取消的请求忽略
+func breakerOutcome(ctx context.Context, err error) (failed, ignored bool) {
+	if err == nil {
+		return false, false
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return false, true
+	}
+	var uerr *UpstreamError
+	if !errors.As(err, &uerr) {
+		return true, false
+	}
+	switch uerr.Code {
+	case CodeUpstreamTimeout:
+		return true, false
+	case CodeUpstreamError:
+		return uerr.Status == 0 || uerr.Status >= 500, false
+	}
+	return false, false
+}
+
+// transition 切换状态，调用方需持有锁
+func (b *circuitBreakers) transition(br *breaker, state string) {
+	br.state = state
+	br.probing = false
+	breakerTransitions.WithLabelValues(state).Inc()
+	b.updateGauge()
+}
+
+// updateGauge 统计熔断中(含半开)的服务地址数，调用方需持有锁
+func (b *circuitBreakers) updateGauge() {
+	n := 0
+	for _, br := range b.hosts {
+		if br.state != BreakerClosed {
+			n++
+		}
+	}
+	breakerOpenHosts.WithLabelValues().Set(float64(n))
+}
+
+// state 返回服务地址的熔断器状态，未记录失败时为 closed
+func (b *circuitBreakers) state(host string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.snapshot(host, b.hosts[host])
+}
+
+// snapshot 调用方需持有锁
+func (b *circuitBreakers) snapshot(host string, br *breaker) BreakerState {
+	s := BreakerState{Host: host, State: BreakerClosed}
+	if br == nil {
+		return s
+	}
+	s.State, s.Failures, s.LastError = br.state, br.failures, br.lastError
+	if br.state != BreakerClosed {
+		s.OpenedAt = br.openedAt
+		s.RetryAt = br.openedAt.Add(b.openFor())
+	}
+	return s
+}
+
+// Breakers 返回有连续失败记录的小智服务地址的熔断器状态，熔断中的排在前面
+func (h *HTTPHandler) Breakers() []BreakerState {
+	b := &h.breakers
+	b.mu.Lock()
+	list := make([]BreakerState, 0, len(b.hosts))
+	for host, br := range b.hosts {
+		list = append(list, b.snapshot(host, br))
+	}
+	b.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].State == BreakerClosed) != (list[j].State == BreakerClosed) {
+			return list[i].State != BreakerClosed
+		}
+		return list[i].Host < list[j].Host
+	})
+	return list
+}
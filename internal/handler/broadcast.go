@@ -0,0 +1,347 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"tp-plugin/internal/audit"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/transform"
+	"tp-plugin/internal/upstream"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 广播命令参数
+const (
+	DefaultBroadcastRate    = 50 // 默认每秒下发的设备数
+	maxBroadcastRate        = 1000
+	DefaultBroadcastWorkers = 16  // 默认同时进行中的下发请求数
+	broadcastPageSize       = 100 // 拉取设备列表的每页数量
+	maxBroadcastErrors      = 20  // 保留的错误信息条数
+	maxBroadcasts           = 50  // 保留的广播记录条数
+)
+
+// 广播状态
+const (
+	BroadcastListing  = "listing" // 正在拉取设备列表
+	BroadcastRunning  = "running"
+	BroadcastDone     = "done"
+	BroadcastCanceled = "canceled"
+	BroadcastFailed   = "failed"
+)
+
+var broadcastCommands = metrics.NewCounterVec("tp_plugin_broadcast_commands_total",
+	"广播命令的逐设备下发结果", "result")
+
+// BroadcastConfig 批量下发命令
+type BroadcastConfig struct {
+	Workers int // 同时进行中的下发请求数，0使用默认值16
+}
+
+// BroadcastRequest 向租户全部在线设备广播命令
+type BroadcastRequest struct {
+	Voucher string                 `json:"voucher"` // 服务接入点凭证(JSON字符串)
+	Command string                 `json:"command"`
+	Params  map[string]interface{} `json:"params"`
+	Rate    int                    `json:"rate"` // 每秒下发的设备数，0 使用默认值
+	Actor   string                 `json:"-"`    // 发起者，记录到广播和审计日志
+}
+
+// Broadcast 一次广播的进度
+type Broadcast struct {
+	ID         string    `json:"id"`
+	Tenant     string    `json:"tenant"` // 凭证摘要
+	Command    string    `json:"command"`
+	Rate       int       `json:"rate"`
+	StartedBy  string    `json:"started_by,omitempty"`
+	State      string    `json:"state"`
+	Total      int       `json:"total"`   // 租户设备总数
+	Sent       int       `json:"sent"`    // 下发成功
+	Failed     int       `json:"failed"`  // 下发失败
+	Skipped    int       `json:"skipped"` // 离线或未在平台中找到而跳过
+	Errors     []string  `json:"errors,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// broadcastRun 进行中或已结束的广播
+type broadcastRun struct {
+	mu     sync.Mutex
+	status Broadcast
+	cancel context.CancelFunc
+}
+
+// snapshot 复制当前进度用于返回
+func (b *broadcastRun) snapshot() Broadcast {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.status
+	s.Errors = append([]string(nil), s.Errors...)
+	return s
+}
+
+// update 在锁内修改进度
+func (b *broadcastRun) update(fn func(s *Broadcast)) {
+	b.mu.Lock()
+	fn(&b.status)
+	b.mu.Unlock()
+}
+
+func (b *broadcastRun) recordError(deviceNumber string, err error) {
+	b.update(func(s *Broadcast) {
+		s.Failed++
+		if len(s.Errors) < maxBroadcastErrors {
+			s.Errors = append(s.Errors, fmt.Sprintf("%s: %v", deviceNumber, err))
+		}
+	})
+}
+
+func (b *broadcastRun) finish(state string, err error) {
+	b.update(func(s *Broadcast) {
+		s.State = state
+		if err != nil {
+			s.Error = err.Error()
+		}
+		s.FinishedAt = time.Now()
+	})
+}
+
+func (b *broadcastRun) finished() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.status.FinishedAt.IsZero()
+}
+
+// broadcastRegistry 广播记录，按启动顺序保留最近 maxBroadcasts 条
+type broadcastRegistry struct {
+	mu   sync.Mutex
+	list []*broadcastRun
+}
+
+func (r *broadcastRegistry) add(b *broadcastRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.list = append(r.list, b)
+	// 只淘汰已结束的记录
+	for len(r.list) > maxBroadcasts {
+		i := 0
+		for i < len(r.list) && !r.list[i].finished() {
+			i++
+		}
+		if i == len(r.list) {
+			break
+		}
+		r.list = append(r.list[:i], r.list[i+1:]...)
+	}
+}
+
+func (r *broadcastRegistry) get(id string) (*broadcastRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range r.list {
+		if b.status.ID == id {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// all 返回全部广播进度，最近启动的在前
+func (r *broadcastRegistry) all() []Broadcast {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Broadcast, 0, len(r.list))
+	for i := len(r.list) - 1; i >= 0; i-- {
+		out = append(out, r.list[i].snapshot())
+	}
+	return out
+}
+
+// StartBroadcast 校验请求并在后台按速率向租户的在线设备逐个下发命令，返回广播记录
+func (h *HTTPHandler) StartBroadcast(req BroadcastRequest) (Broadcast, error) {
+	if req.Command == "" {
+		return Broadcast{}, errors.New("缺少命令标识")
+	}
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(req.Voucher), &voucher); err != nil {
+		return Broadcast{}, fmt.Errorf("解析凭证失败: %v", err)
+	}
+	rate := req.Rate
+	if rate <= 0 {
+		rate = DefaultBroadcastRate
+	}
+	if rate > maxBroadcastRate {
+		rate = maxBroadcastRate
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	actor := "broadcast:" + hex.EncodeToString(id)
+	if req.Actor != "" {
+		actor = req.Actor + "/" + actor
+	}
+	ctx, cancel := context.WithCancel(audit.WithActor(context.Background(), actor))
+	b := &broadcastRun{
+		status: Broadcast{
+			ID:        hex.EncodeToString(id),
+			Tenant:    formjson.VoucherKey(req.Voucher),
+			Command:   req.Command,
+			Rate:      rate,
+			StartedBy: req.Actor,
+			State:     BroadcastListing,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	h.broadcasts.add(b)
+	go h.runBroadcast(ctx, b, voucher, req, rate)
+	return b.snapshot(), nil
+}
+
+// CancelBroadcast 取消进行中的广播，已发出的命令不撤回
+func (h *HTTPHandler) CancelBroadcast(id string) bool {
+	b, ok := h.broadcasts.get(id)
+	if !ok {
+		return false
+	}
+	b.cancel()
+	return true
+}
+
+func (h *HTTPHandler) runBroadcast(ctx context.Context, b *broadcastRun, voucher formjson.Voucher, req BroadcastRequest, rate int) {
+	defer b.cancel()
+	log := h.logger.WithFields(logrus.Fields{
+		"broadcast": b.status.ID,
+		"tenant":    b.status.Tenant,
+		"command":   req.Command,
+	})
+
+	numbers, err := h.listAllDevices(ctx, voucher, req.Voucher)
+	if err != nil {
+		log.WithError(err).Error("广播拉取设备列表失败")
+		b.finish(BroadcastFailed, err)
+		return
+	}
+	b.update(func(s *Broadcast) {
+		s.Total = len(numbers)
+		s.State = BroadcastRunning
+	})
+	log.WithField("devices", len(numbers)).Info("开始广播命令")
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	workers := h.broadcast.Workers
+	if workers <= 0 {
+		workers = DefaultBroadcastWorkers
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+loop:
+	for _, number := range numbers {
+		device, err := h.platform.GetDevice(number)
+		if err != nil || !h.platform.Online(device.ID) {
+			b.update(func(s *Broadcast) { s.Skipped++ })
+			broadcastCommands.WithLabelValues("skipped").Inc()
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+		select {
+		case <-ctx.Done():
+			break loop
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(number string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := h.SendCommand(ctx, req.Voucher, number, req.Command, req.Params); err != nil {
+				b.recordError(number, err)
+				broadcastCommands.WithLabelValues("error").Inc()
+				return
+			}
+			b.update(func(s *Broadcast) { s.Sent++ })
+			broadcastCommands.WithLabelValues("ok").Inc()
+		}(number)
+	}
+	wg.Wait()
+
+	state := BroadcastDone
+	if ctx.Err() != nil {
+		state = BroadcastCanceled
+	}
+	b.finish(state, nil)
+	s := b.snapshot()
+	log.WithFields(logrus.Fields{
+		"state":   s.State,
+		"sent":    s.Sent,
+		"failed":  s.Failed,
+		"skipped": s.Skipped,
+	}).Info("广播命令结束")
+}
+
+// listAllDevices 分页拉取租户在小智服务端的全部设备编号
+func (h *HTTPHandler) listAllDevices(ctx context.Context, voucher formjson.Voucher, rawVoucher string) ([]string, error) {
+	var numbers []string
+	for page := 1; ; page++ {
+		body, err := h.callUpstream(ctx, voucher, rawVoucher, "/device/list", upstream.DeviceListRequest{
+			Voucher:  rawVoucher,
+			Page:     page,
+			PageSize: broadcastPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		data, err := decodeDeviceList(ctx, body.Bytes())
+		bufpool.Put(body)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range data.List {
+			numbers = append(numbers, d.DeviceNumber)
+		}
+		if len(data.List) == 0 || len(numbers) >= int(data.Total) {
+			return numbers, nil
+		}
+	}
+}
+
+// SendCommand 通过小智服务端向设备下发命令，参数经下行转换插件处理，二进制参数不经转换
+func (h *HTTPHandler) SendCommand(ctx context.Context, rawVoucher, deviceNumber, command string, params map[string]interface{}) error {
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(rawVoucher), &voucher); err != nil {
+		return fmt.Errorf("解析凭证失败: %v", err)
+	}
+	// 广播时同一参数并发下发到多个设备，复制后再交给转换插件；二进制参数校验后原样透传
+	params, err := h.transforms.ApplyPassthrough(formjson.VoucherKey(rawVoucher), transform.Downlink, deviceNumber, mergeConfig(nil, params), h.binary)
+	if err != nil {
+		return err
+	}
+	body, err := h.callUpstream(ctx, voucher, rawVoucher, "/device/command", upstream.DeviceCommandRequest{
+		DeviceNumber: deviceNumber,
+		Command:      command,
+		Params:       params,
+		Voucher:      rawVoucher,
+	})
+	if err != nil {
+		return err
+	}
+	defer bufpool.Put(body)
+	var resp upstream.CommonResponse
+	if err := json.Unmarshal(body.Bytes(), &resp); err != nil {
+		return fmt.Errorf("解析响应数据失败: %v", err)
+	}
+	return upstreamCodeError(ctx, resp.Code, resp.Msg)
+}
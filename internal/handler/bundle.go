@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/trace"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// deviceIDHeader 设备请求携带设备编号(MAC地址)的请求头，与小智固件OTA请求一致
+const deviceIDHeader = "Device-Id"
+
+var bundleRequests = metrics.NewCounterVec("tp_plugin_device_bundle_total",
+	"设备配置包下载次数", "result")
+
+// BundleConfig 设备配置包的默认值，按 默认值 < 设备类型 < CFG表单 < 设备影子 的顺序合并
+type BundleConfig struct {
+	Defaults    map[string]interface{}            // 全部设备的默认配置，如服务地址、上报间隔、功能开关
+	DeviceTypes map[string]map[string]interface{} // 按设备类型覆盖的配置
+}
+
+// ConfigBundle 设备启动时下载的生效配置
+type ConfigBundle struct {
+	DeviceNumber  string                 `json:"device_number"`
+	DeviceType    string                 `json:"device_type,omitempty"`
+	ShadowVersion int                    `json:"shadow_version"` // 设备影子版本，未设置影子时为0
+	Config        map[string]interface{} `json:"config"`
+}
+
+// errDeviceUnauthorized 设备不存在或凭证不匹配，不区分两者以免泄露设备是否存在
+var errDeviceUnauthorized = errors.New("设备凭证无效")
+
+// DeviceHandler 返回设备侧接口处理器，挂载在 RoutePath("/device/") 下
+// 设备通过 Device-Id 请求头携带设备编号，HTTP Basic 认证携带设备凭证中的用户名和密码
+func (h *HTTPHandler) DeviceHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(h.RoutePath("/device/config"), h.deviceConfig)
+	mux.HandleFunc(h.RoutePath("/device/ota"), h.deviceOTA)
+	mux.HandleFunc(h.RoutePath("/device/firmware"), h.deviceFirmware)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l, ok := requestLocale(r); ok {
+			r = r.WithContext(i18n.WithLocale(r.Context(), l))
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// deviceConfig GET /device/config 返回设备的配置包
+func (h *HTTPHandler) deviceConfig(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	number := r.Header.Get(deviceIDHeader)
+	username, password, _ := r.BasicAuth()
+	bundle, err := h.Bundle(number, username, password)
+	switch {
+	case errors.Is(err, errDeviceUnauthorized):
+		bundleRequests.WithLabelValues("unauthorized").Inc()
+		h.log(r.Context()).WithField("device_number", number).Warn("设备配置包鉴权失败")
+		w.Header().Set("WWW-Authenticate", `Basic realm="device"`)
+		writeAdmin(w, http.StatusUnauthorized, adminResponse{Code: http.StatusUnauthorized, Message: i18n.Tc(r.Context(), "device.unauthorized")})
+		return
+	case err != nil:
+		bundleRequests.WithLabelValues("error").Inc()
+		h.log(r.Context()).WithError(err).WithField("device_number", number).Error("生成设备配置包失败")
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: err.Error()})
+		return
+	}
+	bundleRequests.WithLabelValues("ok").Inc()
+	h.tracer.Record(number, trace.Out, "config_bundle", bundle)
+	h.writeCached(w, r, bundle)
+}
+
+// Bundle 校验设备凭证并生成配置包
+func (h *HTTPHandler) Bundle(deviceNumber, username, password string) (*ConfigBundle, error) {
+	device, err := h.authorizeDevice(deviceNumber, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, shadow, err := h.effectiveConfig(device)
+	if err != nil {
+		return nil, err
+	}
+	h.logger.WithFields(logrus.Fields{
+		"device_number":  deviceNumber,
+		"shadow_version": shadow.Version,
+	}).Debug("生成设备配置包")
+	return &ConfigBundle{
+		DeviceNumber:  deviceNumber,
+		DeviceType:    device.DeviceType,
+		ShadowVersion: shadow.Version,
+		Config:        cfg,
+	}, nil
+}
+
+// authorizeDevice 按设备编号获取设备并校验设备凭证，设备不存在与凭证不匹配均返回 errDeviceUnauthorized
+func (h *HTTPHandler) authorizeDevice(deviceNumber, username, password string) (*types.Device, error) {
+	if deviceNumber == "" || password == "" {
+		return nil, errDeviceUnauthorized
+	}
+	device, err := h.platform.GetDevice(deviceNumber)
+	if err != nil {
+		h.logger.WithError(err).WithField("device_number", deviceNumber).Debug("获取设备信息失败")
+		return nil, errDeviceUnauthorized
+	}
+	var voucher formjson.DeviceVoucher
+	if err := json.Unmarshal([]byte(device.Voucher), &voucher); err != nil || !voucher.Match(username, password) {
+		return nil, errDeviceUnauthorized
+	}
+	return device, nil
+}
+
+// effectiveConfig 设备当前生效的配置：bundle.defaults < bundle.device_types < CFG表单值 < 设备影子
+func (h *HTTPHandler) effectiveConfig(device *types.Device) (map[string]interface{}, Shadow, error) {
+	shadow, err := h.GetShadow(device.DeviceNumber)
+	if err != nil {
+		return nil, shadow, err
+	}
+	cfg := mergeConfig(nil, h.bundle.Defaults)
+	cfg = mergeConfig(cfg, h.bundle.DeviceTypes[device.DeviceType])
+	cfg = mergeConfig(cfg, h.validDeviceConfig(device.ProtocolType, device.DeviceType, device.DeviceNumber, device.Config))
+	cfg = mergeConfig(cfg, shadow.Desired)
+	return cfg, shadow, nil
+}
+
+// shadowUpdate 修改设备影子的请求
+type shadowUpdate struct {
+	DeviceNumber string                 `json:"device_number"`
+	Desired      map[string]interface{} `json:"desired"`
+	Replace      bool                   `json:"replace"` // 为 true 时整体替换，否则与已有配置合并(值为null删除该项)
+}
+
+// adminShadows 设备影子
+// GET ?device_number= 查询；PUT {"device_number","desired","replace"} 修改；DELETE ?device_number= 删除
+func (h *HTTPHandler) adminShadows(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: "本地存储未启用"})
+		return
+	}
+	number := r.URL.Query().Get("device_number")
+	if number == "" && r.Method != http.MethodPut {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "device_number")})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		shadow, err := h.GetShadow(number)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, shadow)
+	case http.MethodPut:
+		var req shadowUpdate
+		if !decodeAdmin(w, r, http.MethodPut, &req) {
+			return
+		}
+		if errs := h.validateShadow(req.DeviceNumber, req.Desired); len(errs) > 0 {
+			formValuesInvalid.WithLabelValues("shadow").Add(float64(len(errs)))
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: "配置值不符合CFG表单规则", Data: errs})
+			return
+		}
+		shadow, err := h.SaveShadow(req.DeviceNumber, req.Desired, !req.Replace)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, shadow)
+	case http.MethodDelete:
+		if err := h.DeleteShadow(number); err != nil {
+			adminError(w, fmt.Errorf("删除设备影子失败: %v", err))
+			return
+		}
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
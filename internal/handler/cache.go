@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"tp-plugin/internal/i18n"
+)
+
+// CacheConfig 设备侧接口的缓存响应头配置
+type CacheConfig struct {
+	MaxAge int  // 响应可直接复用的秒数，0 时每次向插件校验(ETag 未变化返回304)
+	Public bool // 允许中间代理缓存；响应按设备鉴权，仅在代理按 Authorization/Device-Id 区分缓存时开启
+}
+
+// cacheControl 生成 Cache-Control 响应头
+func (c CacheConfig) cacheControl() string {
+	scope := "private"
+	if c.Public {
+		scope = "public"
+	}
+	if c.MaxAge <= 0 {
+		return scope + ", no-cache"
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, c.MaxAge)
+}
+
+// etagOf 按响应内容生成强 ETag
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch 判断 If-None-Match 是否包含当前 ETag，忽略弱校验前缀
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCached 以管理接口的响应格式返回设备侧接口的成功响应，附带 ETag 和 Cache-Control；
+// 设备重启后携带 If-None-Match 请求且内容未变化时返回304，不重复下载
+func (h *HTTPHandler) writeCached(w http.ResponseWriter, r *http.Request, data interface{}) {
+	body, err := json.Marshal(adminResponse{Code: 200, Message: i18n.Tc(r.Context(), "admin.success"), Data: data})
+	if err != nil {
+		writeAdmin(w, http.StatusInternalServerError, adminResponse{Code: http.StatusInternalServerError, Message: err.Error()})
+		return
+	}
+	etag := etagOf(body)
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", h.deviceCache.cacheControl())
+	header.Set("Vary", "Authorization, "+deviceIDHeader+", Accept-Language")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/trace"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 小智服务端回调接口版本
+// 带版本的路径(/v1/...)以路径为准，Api-Version 请求头与之不一致时拒绝；
+// 不带版本的旧路径(/events、/bind-result)按 Api-Version 选择版本，缺省为 v0
+const (
+	CallbackV0       = "0"
+	CallbackV1       = "1"
+	apiVersionHeader = "Api-Version"
+)
+
+// 回调事件类型
+const (
+	EventDeviceOnline  = "device.online"
+	EventDeviceOffline = "device.offline"
+	EventTelemetry     = "telemetry"
+)
+
+var callbackEvents = metrics.NewCounterVec("tp_plugin_callback_events_total",
+	"小智服务端回调事件数", "version", "type", "result")
+
+// CallbackEvent v1 事件
+type CallbackEvent struct {
+	Type         string                 `json:"type"`          // device.online / device.offline / telemetry
+	DeviceNumber string                 `json:"device_number"` // 设备编号(通常为MAC地址)
+	TS           int64                  `json:"ts,omitempty"`  // 事件发生时间(毫秒时间戳)
+	Data         map[string]interface{} `json:"data,omitempty"`
+}
+
+// CallbackEvents POST /v1/events 请求体，一次可上报多个事件
+type CallbackEvents struct {
+	Events []CallbackEvent `json:"events"`
+}
+
+// BindResult POST /v1/bind-result 请求体，小智服务端异步完成绑定后回调
+type BindResult struct {
+	DeviceNumber string `json:"device_number"`
+	AgentID      string `json:"agent_id"`
+	Success      bool   `json:"success"`
+	Code         int    `json:"code,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// legacyEvent v0 事件，每次请求一个事件，时间为秒级时间戳
+type legacyEvent struct {
+	Mac       string                 `json:"mac"`
+	Type      string                 `json:"type"` // online / offline / data
+	Payload   map[string]interface{} `json:"payload"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+// legacyBindResult v0 绑定结果
+type legacyBindResult struct {
+	Mac     string `json:"mac"`
+	AgentID string `json:"agentId"`
+	Status  string `json:"status"` // ok / fail
+	Msg     string `json:"msg"`
+}
+
+// legacyEventTypes v0 事件类型到 v1 的映射
+var legacyEventTypes = map[string]string{
+	"online":  EventDeviceOnline,
+	"offline": EventDeviceOffline,
+	"data":    EventTelemetry,
+}
+
+// upgrade 将 v0 事件转换为 v1 格式
+func (e legacyEvent) upgrade() CallbackEvents {
+	typ, ok := legacyEventTypes[e.Type]
+	if !ok {
+		typ = e.Type
+	}
+	return CallbackEvents{Events: []CallbackEvent{{
+		Type:         typ,
+		DeviceNumber: e.Mac,
+		TS:           e.Timestamp * 1000,
+		Data:         e.Payload,
+	}}}
+}
+
+// upgrade 将 v0 绑定结果转换为 v1 格式
+func (b legacyBindResult) upgrade() BindResult {
+	r := BindResult{DeviceNumber: b.Mac, AgentID: b.AgentID, Success: b.Status == "ok"}
+	if !r.Success {
+		r.Message = b.Msg
+	}
+	return r
+}
+
+// CallbackHandler 返回小智服务端回调接口处理器，需挂载 RoutePath("/v1/")、RoutePath("/events") 和 RoutePath("/bind-result")
+// 请求通过 x-token 请求头携带服务接入点凭证中的 Secret 鉴权
+func (h *HTTPHandler) CallbackHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(h.RoutePath("/v1/events"), h.callback(CallbackV1, h.callbackEvents))
+	mux.HandleFunc(h.RoutePath("/v1/bind-result"), h.callback(CallbackV1, h.callbackBindResult))
+	mux.HandleFunc(h.RoutePath("/events"), h.callback("", h.callbackEvents))
+	mux.HandleFunc(h.RoutePath("/bind-result"), h.callback("", h.callbackBindResult))
+	return mux
+}
+
+// callbackFunc 处理已鉴权并确定版本的回调请求
+type callbackFunc func(w http.ResponseWriter, r *http.Request, version string)
+
+// callback 校验请求方法、版本及凭证
+func (h *HTTPHandler) callback(pathVersion string, next callbackFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l, ok := requestLocale(r); ok {
+			r = r.WithContext(i18n.WithLocale(r.Context(), l))
+		}
+		if r.Method != http.MethodPost {
+			writeAdmin(w, http.StatusMethodNotAllowed, adminResponse{Code: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)})
+			return
+		}
+		version, err := callbackVersion(r.Header.Get(apiVersionHeader), pathVersion)
+		if err != nil {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "callback.bad_version", err.Error())})
+			return
+		}
+		w.Header().Set(apiVersionHeader, version)
+		if !h.verifySecret(r.Header.Get("x-token")) {
+			writeAdmin(w, http.StatusUnauthorized, adminResponse{Code: http.StatusUnauthorized, Message: i18n.Tc(r.Context(), "callback.unauthorized")})
+			return
+		}
+		next(w, r, version)
+	}
+}
+
+// callbackVersion 确定回调版本，Api-Version 可写作 1 或 v1
+func callbackVersion(header, pathVersion string) (string, error) {
+	header = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(header)), "v")
+	if pathVersion != "" {
+		if header != "" && header != pathVersion {
+			return "", fmt.Errorf("路径版本 v%s 与 Api-Version(%s) 不一致", pathVersion, header)
+		}
+		return pathVersion, nil
+	}
+	switch header {
+	case "":
+		return CallbackV0, nil
+	case CallbackV0, CallbackV1:
+		return header, nil
+	default:
+		return "", fmt.Errorf("不支持的版本: %s", header)
+	}
+}
+
+// voucherSecrets 已验证过的 Secret
+var voucherSecrets sync.Map
+
+// verifySecret 检查 Secret 是否属于已记录的服务接入点凭证
+func (h *HTTPHandler) verifySecret(secret string) bool {
+	if secret == "" {
+		return false
+	}
+	if _, ok := voucherSecrets.Load(secret); ok {
+		return true
+	}
+	if h.store == nil {
+		return false
+	}
+	found := false
+	h.store.ForEach(store.BucketVouchers, func(_ string, data []byte) error {
+		var rec voucherRecord
+		var voucher formjson.Voucher
+		if json.Unmarshal(data, &rec) != nil {
+			return nil
+		}
+		raw, err := h.voucherOf(rec)
+		if err != nil || json.Unmarshal([]byte(raw), &voucher) != nil || voucher.Secret == "" {
+			return nil
+		}
+		if subtle.ConstantTimeCompare([]byte(voucher.Secret), []byte(secret)) == 1 {
+			found = true
+			return errStopIteration
+		}
+		return nil
+	})
+	if found {
+		voucherSecrets.Store(secret, true)
+	}
+	return found
+}
+
+var errStopIteration = errors.New("stop")
+
+// callbackResult 事件处理结果
+type callbackResult struct {
+	Accepted int      `json:"accepted"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// callbackEvents 处理设备事件：上下线转为设备状态，telemetry 转为遥测(ts 作为原始时间)
+func (h *HTTPHandler) callbackEvents(w http.ResponseWriter, r *http.Request, version string) {
+	var req CallbackEvents
+	var err error
+	if version == CallbackV0 {
+		var legacy legacyEvent
+		err = json.NewDecoder(r.Body).Decode(&legacy)
+		req = legacy.upgrade()
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&req)
+	}
+	if err != nil {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", err.Error())})
+		return
+	}
+
+	var result callbackResult
+	for _, event := range req.Events {
+		err := h.handleCallbackEvent(r.Context(), event)
+		status := "ok"
+		if err != nil {
+			status = "error"
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s(%s): %v", event.Type, event.DeviceNumber, err))
+		} else {
+			result.Accepted++
+		}
+		callbackEvents.WithLabelValues(version, eventTypeLabel(event.Type), status).Inc()
+	}
+	adminOK(w, r, result)
+}
+
+func (h *HTTPHandler) handleCallbackEvent(ctx context.Context, event CallbackEvent) error {
+	if event.DeviceNumber == "" {
+		return errors.New("缺少设备编号")
+	}
+	h.tracer.Record(event.DeviceNumber, trace.In, "callback_event", event)
+	device, err := h.platform.GetDevice(event.DeviceNumber)
+	if err != nil {
+		return fmt.Errorf("获取设备信息失败: %v", err)
+	}
+	switch event.Type {
+	case EventDeviceOnline:
+		if err := h.platform.SendDeviceStatus(device.ID, "1"); err != nil {
+			return err
+		}
+		h.heartbeats.Online(device.ID, event.DeviceNumber)
+		return nil
+	case EventDeviceOffline:
+		h.heartbeats.Forget(device.ID)
+		if h.suppressOffline(ctx, event.DeviceNumber, "callback") {
+			return nil
+		}
+		return h.platform.SendDeviceStatus(device.ID, "0")
+	case EventTelemetry:
+		values := make(map[string]interface{}, len(event.Data)+1)
+		for k, v := range event.Data {
+			values[k] = v
+		}
+		if event.TS > 0 {
+			values["ts"] = event.TS
+		}
+		h.heartbeats.Seen(device.ID, event.DeviceNumber)
+		return h.platform.SendTelemetry(device.ID, values)
+	default:
+		return fmt.Errorf("未知的事件类型: %s", event.Type)
+	}
+}
+
+// eventTypeLabel 将事件类型归并为有限的指标标签值
+func eventTypeLabel(t string) string {
+	switch t {
+	case EventDeviceOnline, EventDeviceOffline, EventTelemetry:
+		return t
+	default:
+		return "other"
+	}
+}
+
+// callbackBindResult 记录小智服务端异步绑定的结果
+func (h *HTTPHandler) callbackBindResult(w http.ResponseWriter, r *http.Request, version string) {
+	var req BindResult
+	var err error
+	if version == CallbackV0 {
+		var legacy legacyBindResult
+		err = json.NewDecoder(r.Body).Decode(&legacy)
+		req = legacy.upgrade()
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&req)
+	}
+	if err == nil && req.DeviceNumber == "" {
+		err = errors.New("device_number")
+	}
+	if err != nil {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", err.Error())})
+		return
+	}
+
+	h.tracer.Record(req.DeviceNumber, trace.In, "bind_result", req)
+	log := h.log(r.Context()).WithFields(logrus.Fields{
+		"device_number": req.DeviceNumber,
+		"agent_id":      req.AgentID,
+		"api_version":   version,
+	})
+	status := "ok"
+	if req.Success {
+		// 异步绑定的结果不带凭证，按编号归属清除该租户的设备列表缓存，归属未知时全部清除
+		h.listCache.invalidate(h.deviceTenant(req.DeviceNumber), "bind_result")
+		log.Info(i18n.Td("bind.success"))
+	} else {
+		status = "error"
+		log.WithFields(logrus.Fields{"code": req.Code, "message": req.Message}).Warn(i18n.Td("callback.bind_failed"))
+	}
+	callbackEvents.WithLabelValues(version, "bind_result", status).Inc()
+	adminOK(w, r, nil)
+}
@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+	"github.com/sirupsen/logrus"
+)
+
+// 设备编号归属参数
+const (
+	deviceOwnerTTL   = 7 * 24 * time.Hour // 归属租户超过该时长未再出现该设备时，允许其他租户接管
+	maxCollisionSeen = 20                 // 每个冲突保留的冲突方条数
+)
+
+// 冲突来源
+const (
+	collisionSourceList      = "list"      // 拉取设备列表时发现
+	collisionSourceDuplicate = "duplicate" // 同一页设备列表中重复出现
+	collisionSourceBind      = "bind"      // 绑定时发现，绑定已被拒绝
+)
+
+var deviceCollisions = metrics.NewCounterVec("tp_plugin_device_collisions_total",
+	"多个小智设备映射到同一设备编号的次数", "source")
+
+// deviceOwner 设备编号的归属：最先在设备列表或绑定中出现该编号的租户
+type deviceOwner struct {
+	Tenant       string    `json:"tenant"`        // 凭证摘要
+	DeviceNumber string    `json:"device_number"` // 小智服务端返回的原始编号
+	DeviceName   string    `json:"device_name,omitempty"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// CollisionClaim 与归属租户冲突的一方
+type CollisionClaim struct {
+	Tenant       string    `json:"tenant"`
+	DeviceNumber string    `json:"device_number"`
+	DeviceName   string    `json:"device_name,omitempty"`
+	Source       string    `json:"source"`
+	Count        int       `json:"count"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// Collision 多个小智设备映射到同一设备编号，遥测会混在同一个平台设备上
+type Collision struct {
+	Key       string           `json:"key"` // 归一化后的设备编号
+	Owner     deviceOwner      `json:"owner"`
+	Claimants []CollisionClaim `json:"claimants"`
+}
+
+// deviceOwners 设备编号归属表，启用本地存储时持久化，重启后仍能拒绝冲突的绑定；冲突记录只保存在内存中
+type deviceOwners struct {
+	mu         sync.Mutex
+	loaded     bool
+	owners     map[string]*deviceOwner
+	collisions map[string]*Collision
+}
+
+// normalizeDeviceNumber 归一化设备编号：忽略大小写和MAC分隔符，"AA:BB-CC" 与 "aabbcc" 视为同一设备
+func normalizeDeviceNumber(number string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '-', '.', ' ':
+			return -1
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, number)
+}
+
+// loadOwners 首次使用时从存储加载归属表，调用方需持有锁
+func (h *HTTPHandler) loadOwners() {
+	o := &h.owners
+	if o.loaded {
+		return
+	}
+	o.loaded = true
+	o.owners = make(map[string]*deviceOwner)
+	o.collisions = make(map[string]*Collision)
+	if h.store == nil {
+		return
+	}
+	err := h.store.ForEach(store.BucketOwners, func(key string, data []byte) error {
+		var owner deviceOwner
+		if err := json.Unmarshal(data, &owner); err != nil {
+			h.logger.WithError(err).WithField("key", key).Warn("解析设备编号归属失败")
+			return nil
+		}
+		o.owners[key] = &owner
+		return nil
+	})
+	if err != nil {
+		h.logger.WithError(err).Warn("加载设备编号归属失败")
+	}
+}
+
+// claimDevice 登记设备编号归属，编号已属于其他租户或其他原始编号时记录冲突并返回归属方。
+// 需要写入存储的归属记录放入 dirty，调用方需持有锁
+func (h *HTTPHandler) claimDevice(tenant, number, name, source string, now time.Time, dirty map[string]interface{}) *deviceOwner {
+	key := normalizeDeviceNumber(number)
+	if key == "" {
+		return nil
+	}
+	o := &h.owners
+	owner := o.owners[key]
+	switch {
+	case owner == nil || now.Sub(owner.LastSeen) > deviceOwnerTTL:
+		owner = &deviceOwner{Tenant: tenant, DeviceNumber: number, DeviceName: name, FirstSeen: now, LastSeen: now}
+		o.owners[key] = owner
+		delete(o.collisions, key)
+		dirty[key] = *owner
+		return nil
+	case owner.Tenant == tenant && owner.DeviceNumber == number:
+		// 归属方每次出现都刷新时间，但只按凭证写入间隔落盘
+		stale := now.Sub(owner.LastSeen) > voucherSaveInterval
+		owner.LastSeen = now
+		if name != "" {
+			owner.DeviceName = name
+		}
+		if stale {
+			dirty[key] = *owner
+		}
+		return nil
+	}
+	h.recordCollision(key, CollisionClaim{Tenant: tenant, DeviceNumber: number, DeviceName: name, Source: source}, now)
+	conflict := *owner
+	return &conflict
+}
+
+// recordCollision 记录冲突方，同一租户和原始编号只记一条，调用方需持有锁
+func (h *HTTPHandler) recordCollision(key string, claim CollisionClaim, now time.Time) {
+	o := &h.owners
+	deviceCollisions.WithLabelValues(claim.Source).Inc()
+	c := o.collisions[key]
+	if c == nil {
+		c = &Collision{Key: key, Owner: *o.owners[key]}
+		o.collisions[key] = c
+		h.logger.WithFields(logrus.Fields{
+			"device_number": claim.DeviceNumber,
+			"owner_tenant":  c.Owner.Tenant,
+			"owner_number":  c.Owner.DeviceNumber,
+			"tenant":        claim.Tenant,
+			"source":        claim.Source,
+		}).Warn("发现设备编号冲突，多个小智设备映射到同一设备编号")
+	}
+	c.Owner = *o.owners[key]
+	for i := range c.Claimants {
+		cl := &c.Claimants[i]
+		if cl.Tenant == claim.Tenant && cl.DeviceNumber == claim.DeviceNumber {
+			cl.Count++
+			cl.LastSeen = now
+			cl.Source = claim.Source
+			return
+		}
+	}
+	if len(c.Claimants) >= maxCollisionSeen {
+		return
+	}
+	claim.Count = 1
+	claim.FirstSeen = now
+	claim.LastSeen = now
+	c.Claimants = append(c.Claimants, claim)
+}
+
+// saveOwners 写入归属记录，失败只记录日志
+func (h *HTTPHandler) saveOwners(dirty map[string]interface{}) {
+	if h.store == nil || len(dirty) == 0 {
+		return
+	}
+	if err := h.store.PutMany(store.BucketOwners, dirty); err != nil {
+		h.logger.WithError(err).Warn("保存设备编号归属失败")
+	}
+}
+
+// observeDeviceList 登记设备列表中的设备编号，发现跨租户或同一页内的编号冲突
+func (h *HTTPHandler) observeDeviceList(rawVoucher string, list []handler.DeviceItem) {
+	tenant := formjson.VoucherKey(rawVoucher)
+	now := time.Now()
+	dirty := make(map[string]interface{})
+	seen := make(map[string]bool, len(list))
+
+	h.owners.mu.Lock()
+	h.loadOwners()
+	for _, d := range list {
+		key := normalizeDeviceNumber(d.DeviceNumber)
+		if key == "" {
+			continue
+		}
+		if seen[key] {
+			// 同一页出现两次，说明小智服务端存在编号相同(或仅大小写/分隔符不同)的两台设备
+			h.recordCollision(key, CollisionClaim{Tenant: tenant, DeviceNumber: d.DeviceNumber, DeviceName: d.DeviceName, Source: collisionSourceDuplicate}, now)
+			continue
+		}
+		seen[key] = true
+		h.claimDevice(tenant, d.DeviceNumber, d.DeviceName, collisionSourceList, now, dirty)
+	}
+	h.owners.mu.Unlock()
+	h.saveOwners(dirty)
+}
+
+// checkBindCollision 绑定前校验设备编号归属，编号已属于其他租户或其他设备时记录冲突并拒绝绑定
+func (h *HTTPHandler) checkBindCollision(ctx context.Context, rawVoucher, deviceNumber string) error {
+	key := normalizeDeviceNumber(deviceNumber)
+	tenant := formjson.VoucherKey(rawVoucher)
+	now := time.Now()
+	h.owners.mu.Lock()
+	h.loadOwners()
+	owner := h.owners.owners[key]
+	if key == "" || owner == nil || now.Sub(owner.LastSeen) > deviceOwnerTTL ||
+		(owner.Tenant == tenant && owner.DeviceNumber == deviceNumber) {
+		h.owners.mu.Unlock()
+		return nil
+	}
+	h.recordCollision(key, CollisionClaim{Tenant: tenant, DeviceNumber: deviceNumber, Source: collisionSourceBind}, now)
+	conflict := *owner
+	h.owners.mu.Unlock()
+	return &UpstreamError{
+		Code:    CodeBindConflict,
+		Status:  http.StatusConflict,
+		Message: i18n.Tc(ctx, "bind.collision", deviceNumber, conflict.DeviceNumber, conflict.Tenant),
+		Detail:  "device number collision",
+	}
+}
+
+// claimBound 绑定成功后登记设备编号归属
+func (h *HTTPHandler) claimBound(rawVoucher, deviceNumber string) {
+	dirty := make(map[string]interface{})
+	h.owners.mu.Lock()
+	h.loadOwners()
+	h.claimDevice(formjson.VoucherKey(rawVoucher), deviceNumber, "", collisionSourceBind, time.Now(), dirty)
+	h.owners.mu.Unlock()
+	h.saveOwners(dirty)
+}
+
+// deviceTenant 返回设备编号归属的租户(凭证摘要)，未登记归属时为空
+func (h *HTTPHandler) deviceTenant(deviceNumber string) string {
+	h.owners.mu.Lock()
+	defer h.owners.mu.Unlock()
+	h.loadOwners()
+	if owner := h.owners.owners[normalizeDeviceNumber(deviceNumber)]; owner != nil {
+		return owner.Tenant
+	}
+	return ""
+}
+
+// releaseDevice 解绑成功后释放归属，之后其他租户可以绑定该编号
+func (h *HTTPHandler) releaseDevice(rawVoucher, deviceNumber string) {
+	key := normalizeDeviceNumber(deviceNumber)
+	h.owners.mu.Lock()
+	h.loadOwners()
+	owner := h.owners.owners[key]
+	if owner == nil || owner.Tenant != formjson.VoucherKey(rawVoucher) || owner.DeviceNumber != deviceNumber {
+		h.owners.mu.Unlock()
+		return
+	}
+	delete(h.owners.owners, key)
+	h.owners.mu.Unlock()
+	h.deleteOwner(key)
+}
+
+func (h *HTTPHandler) deleteOwner(key string) {
+	if h.store == nil {
+		return
+	}
+	if err := h.store.Delete(store.BucketOwners, key); err != nil {
+		h.logger.WithError(err).Warn("删除设备编号归属失败")
+	}
+}
+
+// transferOwners 服务接入点凭证修改后凭证摘要随之变化，将旧凭证名下的设备编号归属转到新凭证，
+// 避免新凭证拉取设备列表时与自己原有的设备冲突
+func (h *HTTPHandler) transferOwners(oldVoucher, newVoucher string) int {
+	from, to := formjson.VoucherKey(oldVoucher), formjson.VoucherKey(newVoucher)
+	if from == to {
+		return 0
+	}
+	dirty := make(map[string]interface{})
+	h.owners.mu.Lock()
+	h.loadOwners()
+	for key, owner := range h.owners.owners {
+		if owner.Tenant == from {
+			owner.Tenant = to
+			dirty[key] = *owner
+		}
+	}
+	h.owners.mu.Unlock()
+	h.saveOwners(dirty)
+	return len(dirty)
+}
+
+// Collisions 返回当前发现的设备编号冲突，最近出现的在前
+func (h *HTTPHandler) Collisions() []Collision {
+	h.owners.mu.Lock()
+	h.loadOwners()
+	list := make([]Collision, 0, len(h.owners.collisions))
+	for _, c := range h.owners.collisions {
+		cp := *c
+		cp.Claimants = append([]CollisionClaim(nil), c.Claimants...)
+		list = append(list, cp)
+	}
+	h.owners.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return lastClaim(list[i]).After(lastClaim(list[j]))
+	})
+	return list
+}
+
+func lastClaim(c Collision) time.Time {
+	var t time.Time
+	for _, cl := range c.Claimants {
+		if cl.LastSeen.After(t) {
+			t = cl.LastSeen
+		}
+	}
+	return t
+}
+
+// ResolveCollision 清除设备编号的归属和冲突记录，下一个出现该编号的租户成为归属方
+func (h *HTTPHandler) ResolveCollision(deviceNumber string) bool {
+	key := normalizeDeviceNumber(deviceNumber)
+	h.owners.mu.Lock()
+	h.loadOwners()
+	_, owned := h.owners.owners[key]
+	_, collided := h.owners.collisions[key]
+	delete(h.owners.owners, key)
+	delete(h.owners.collisions, key)
+	h.owners.mu.Unlock()
+	if owned {
+		h.deleteOwner(key)
+	}
+	return owned || collided
+}
+
+// adminCollisions 设备编号冲突报告
+// GET 列出冲突；DELETE ?device_number= 清除该编号的归属，设备迁移到其他账号后使用
+func (h *HTTPHandler) adminCollisions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		adminOK(w, r, h.Collisions())
+	case http.MethodDelete:
+		number := r.URL.Query().Get("device_number")
+		if number == "" {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "device_number")})
+			return
+		}
+		if !h.ResolveCollision(number) {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		h.log(r.Context()).WithField("device_number", number).Info("已清除设备编号归属")
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/trace"
+
+	"github.com/sirupsen/logrus"
+)
+
+var deviceConfigPushes = metrics.NewCounterVec("tp_plugin_device_config_push_total",
+	"设备配置修改通知下发到设备的次数", "result")
+
+// deviceConfigMessage 设备配置修改通知的消息内容
+// device_number 缺省时按 device_id 从设备缓存中查找；voucher 缺省时依次使用设备的凭证和设备编号归属租户的凭证
+type deviceConfigMessage struct {
+	DeviceID     string `json:"device_id"`
+	DeviceNumber string `json:"device_number"`
+	Voucher      string `json:"voucher"`
+}
+
+// handleDeviceConfigChange 设备配置修改后清除设备缓存并重新获取设备，将生效配置经小智服务端下发到在线设备；
+// 离线设备不下发，上线后通过配置包(/device/config)获取新配置
+func (h *HTTPHandler) handleDeviceConfigChange(ctx context.Context, message string) error {
+	var msg deviceConfigMessage
+	if err := json.Unmarshal([]byte(message), &msg); err != nil {
+		return err
+	}
+	if msg.DeviceNumber == "" && msg.DeviceID != "" {
+		if device, err := h.platform.GetDeviceByID(msg.DeviceID); err == nil {
+			msg.DeviceNumber = device.DeviceNumber
+		}
+	}
+	if msg.DeviceNumber == "" {
+		return errors.New("设备配置修改通知缺少设备编号，且设备不在缓存中")
+	}
+	if msg.DeviceID != "" {
+		h.platform.ClearDeviceCacheByID(msg.DeviceID)
+	}
+	h.platform.ClearDeviceCache(msg.DeviceNumber)
+
+	log := h.log(ctx).WithField("device_number", msg.DeviceNumber)
+	device, err := h.platform.GetDevice(msg.DeviceNumber)
+	if err != nil {
+		log.WithError(err).Error("获取设备信息失败")
+		return err
+	}
+	h.tracer.Record(msg.DeviceNumber, trace.In, "device_config", message)
+	if !h.platform.Online(device.ID) {
+		deviceConfigPushes.WithLabelValues("offline").Inc()
+		log.Info("设备离线，上线后通过配置包获取新配置")
+		return nil
+	}
+
+	rawVoucher, voucher, ok := h.deviceVoucher(msg.DeviceNumber, msg.Voucher, device.Voucher)
+	if !ok {
+		deviceConfigPushes.WithLabelValues("error").Inc()
+		return errors.New("未找到设备所属服务接入点的凭证")
+	}
+	config, shadow, err := h.effectiveConfig(device)
+	if err != nil {
+		deviceConfigPushes.WithLabelValues("error").Inc()
+		return err
+	}
+	if err := h.PushConfig(ctx, voucher, rawVoucher, msg.DeviceNumber, config); err != nil {
+		deviceConfigPushes.WithLabelValues("error").Inc()
+		log.WithError(err).Error("下发设备配置失败")
+		return err
+	}
+	deviceConfigPushes.WithLabelValues("ok").Inc()
+	h.tracer.Record(msg.DeviceNumber, trace.Out, "device_config", config)
+	log.WithFields(logrus.Fields{
+		"device_id":      device.ID,
+		"shadow_version": shadow.Version,
+	}).Info("设备配置已下发")
+	return nil
+}
+
+// deviceVoucher 依次尝试候选凭证，最后使用设备编号归属租户记录的凭证，返回第一个包含服务地址的服务接入点凭证
+func (h *HTTPHandler) deviceVoucher(deviceNumber string, candidates ...string) (string, formjson.Voucher, bool) {
+	for _, raw := range candidates {
+		var voucher formjson.Voucher
+		if raw != "" && json.Unmarshal([]byte(raw), &voucher) == nil && voucher.ServerURL != "" {
+			return raw, voucher, true
+		}
+	}
+	if h.store == nil {
+		return "", formjson.Voucher{}, false
+	}
+	h.owners.mu.Lock()
+	h.loadOwners()
+	var tenant string
+	if owner := h.owners.owners[normalizeDeviceNumber(deviceNumber)]; owner != nil {
+		tenant = owner.Tenant
+	}
+	h.owners.mu.Unlock()
+	var rec voucherRecord
+	if tenant == "" || h.store.Get(store.BucketVouchers, tenant, &rec) != nil {
+		return "", formjson.Voucher{}, false
+	}
+	raw, err := h.voucherOf(rec)
+	if err != nil {
+		return "", formjson.Voucher{}, false
+	}
+	var voucher formjson.Voucher
+	if json.Unmarshal([]byte(raw), &voucher) != nil || voucher.ServerURL == "" {
+		return "", formjson.Voucher{}, false
+	}
+	return raw, voucher, true
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/upstream"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+)
+
+// serveDeviceInfo GET /api/v1/plugin/device/info?key=设备编号
+func (h *HTTPHandler) serveDeviceInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writePlatform(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	req := handler.GetDeviceInfoRequest{Key: r.URL.Query().Get("key")}
+	if req.Key == "" {
+		writePlatform(w, http.StatusBadRequest, "missing required query parameters", nil)
+		return
+	}
+
+	item, err := h.handleGetDeviceInfo(r.Context(), &req)
+	observeRequest(endpointDeviceInfo, "", "", err)
+	if err != nil {
+		writePlatform(w, platformCode(err), err.Error(), nil)
+		return
+	}
+	writePlatform(w, http.StatusOK, "success", item)
+}
+
+// handleGetDeviceInfo 处理获取设备信息请求，key 为设备编号
+// 小智服务端没有单台设备的查询接口，使用设备编号归属租户的凭证按编号搜索设备列表后精确匹配；
+// 与设备列表相同经过 callUpstream，受超时限制并按状态码映射插件错误码，未找到设备时返回 CodeUpstreamNotFound
+func (h *HTTPHandler) handleGetDeviceInfo(ctx context.Context, req *handler.GetDeviceInfoRequest) (*handler.DeviceItem, error) {
+	log := h.log(ctx).WithField("device_number", req.Key)
+	log.Debug("收到获取设备信息请求")
+
+	rawVoucher, voucher, ok := h.deviceVoucher(req.Key)
+	if !ok {
+		return nil, &UpstreamError{Code: CodeUpstreamNotFound, Message: upstreamMessage(ctx, CodeUpstreamNotFound), Detail: "未找到设备所属服务接入点的凭证"}
+	}
+	_, pageSize, _ := h.deviceList.page(1, 0)
+	body, err := h.callUpstream(ctx, voucher, rawVoucher, "/device/list", upstream.DeviceListRequest{
+		Voucher:  rawVoucher,
+		Page:     1,
+		PageSize: pageSize,
+		Search:   req.Key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer bufpool.Put(body)
+
+	data, err := decodeDescribedDeviceList(ctx, body.Bytes(), h.descriptionTemplate(ctx, voucher))
+	if err != nil {
+		log.WithError(err).Error("获取设备信息失败")
+		return nil, err
+	}
+	key := normalizeDeviceNumber(req.Key)
+	for i := range data.List {
+		if normalizeDeviceNumber(data.List[i].DeviceNumber) != key {
+			continue
+		}
+		list := data.List[i : i+1]
+		h.annotateMaintenance(ctx, list)
+		h.normalizeDeviceList(ctx, list)
+		log.WithField("device_name", list[0].DeviceName).Debug("获取设备信息成功")
+		return &list[0], nil
+	}
+	return nil, &UpstreamError{Code: CodeUpstreamNotFound, Status: http.StatusOK, Message: upstreamMessage(ctx, CodeUpstreamNotFound), Detail: "设备不存在: " + req.Key}
+}
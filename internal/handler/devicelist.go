@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/pkg/textnorm"
+	"tp-plugin/internal/upstream"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+	"github.com/sirupsen/logrus"
+)
+
+var textNormalized = metrics.NewCounterVec("tp_plugin_text_normalized_total",
+	"返回平台前被规范化的文本字段数", "field")
+
+// TextConfig 设备列表文本字段的规范化配置
+type TextConfig struct {
+	NameMax             int    // 设备名称最大字符数，0为不截断
+	DescriptionMax      int    // 设备描述最大字符数，0为不截断
+	StripSupplementary  bool   // 去掉emoji等4字节字符
+	DescriptionTemplate string // 设备描述模板，凭证未配置 DescriptionTemplate 时使用，为空时使用小智服务端返回的描述
+}
+
+// descriptionTemplates 已解析的设备描述模板，解析失败的模板记为nil
+var descriptionTemplates sync.Map
+
+// descriptionTemplate 返回凭证使用的设备描述模板：凭证中的 DescriptionTemplate 优先，其次为配置的默认模板。
+// 模板以 upstream.Device 为数据，如 "{{.Model}} · fw {{.Firmware}} · {{.Location}}"；
+// 解析失败时记录一次警告并使用原始描述
+func (h *HTTPHandler) descriptionTemplate(ctx context.Context, voucher formjson.Voucher) *template.Template {
+	text := voucher.DescriptionTemplate
+	if text == "" {
+		text = h.text.DescriptionTemplate
+	}
+	if text == "" {
+		return nil
+	}
+	if v, ok := descriptionTemplates.Load(text); ok {
+		return v.(*template.Template)
+	}
+	tmpl, err := template.New("description").Parse(text)
+	if err != nil {
+		h.log(ctx).WithError(err).WithField("template", text).Warn("设备描述模板解析失败，使用原始描述")
+		tmpl = nil
+	}
+	descriptionTemplates.Store(text, tmpl)
+	return tmpl
+}
+
+// readBody 将响应体读入池化缓冲区，调用方使用完毕后需调用 bufpool.Put 归还
+func readBody(r io.Reader) (*bytes.Buffer, error) {
+	buf := bufpool.Get()
+	if _, err := buf.ReadFrom(r); err != nil {
+		bufpool.Put(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// decodeDeviceList 解析第三方设备列表响应并组装为平台的 DeviceListData
+// 业务状态码非成功时返回 UpstreamError
+func decodeDeviceList(ctx context.Context, body []byte) (handler.DeviceListData, error) {
+	return decodeDescribedDeviceList(ctx, body, nil)
+}
+
+// decodeDescribedDeviceList 同 decodeDeviceList，describe 不为nil时以其结果作为设备描述
+func decodeDescribedDeviceList(ctx context.Context, body []byte, describe *template.Template) (handler.DeviceListData, error) {
+	var responseData upstream.DeviceListResponse
+	if err := json.Unmarshal(body, &responseData); err != nil {
+		return handler.DeviceListData{}, &UpstreamError{
+			Code:    CodeUpstreamError,
+			Status:  http.StatusOK,
+			Message: upstreamMessage(ctx, CodeUpstreamError),
+			Detail:  err.Error(),
+		}
+	}
+	if err := upstreamCodeError(ctx, responseData.Code, responseData.Msg); err != nil {
+		return handler.DeviceListData{}, err
+	}
+
+	// 按实际数量一次性分配，避免万级设备时反复扩容
+	deviceListData := handler.DeviceListData{
+		List:  make([]handler.DeviceItem, 0, len(responseData.Data.List)),
+		Total: responseData.Data.Total,
+	}
+	var buf strings.Builder
+	for _, device := range responseData.Data.List {
+		description := device.Description
+		if describe != nil {
+			buf.Reset()
+			if err := describe.Execute(&buf, device); err == nil {
+				description = buf.String()
+			}
+		}
+		deviceListData.List = append(deviceListData.List, handler.DeviceItem{
+			DeviceName:   device.DeviceName,
+			DeviceNumber: device.DeviceNumber,
+			Description:  description,
+		})
+	}
+	return deviceListData, nil
+}
+
+// normalizeDeviceList 返回平台前规范化设备列表的文本字段；设备编号是设备的标识，只清理非法字符不截断
+func (h *HTTPHandler) normalizeDeviceList(ctx context.Context, list []handler.DeviceItem) {
+	cfg := h.text
+	fields := []struct {
+		name string
+		max  int
+		get  func(*handler.DeviceItem) *string
+	}{
+		{"device_number", 0, func(d *handler.DeviceItem) *string { return &d.DeviceNumber }},
+		{"device_name", cfg.NameMax, func(d *handler.DeviceItem) *string { return &d.DeviceName }},
+		{"description", cfg.DescriptionMax, func(d *handler.DeviceItem) *string { return &d.Description }},
+	}
+	for i := range list {
+		for _, f := range fields {
+			v := f.get(&list[i])
+			out, changed := textnorm.Clean(*v, textnorm.Options{MaxRunes: f.max, StripSupplementary: cfg.StripSupplementary})
+			if !changed {
+				continue
+			}
+			textNormalized.WithLabelValues(f.name).Inc()
+			h.log(ctx).WithFields(logrus.Fields{
+				"field":         f.name,
+				"device_number": list[i].DeviceNumber,
+			}).Debug("设备列表文本字段已规范化")
+			*v = out
+		}
+	}
+}
+
+// 设备列表分页默认值
+const (
+	DefaultPageSize    = 20   // 平台传入的 page_size 不合法时使用
+	DefaultMaxPageSize = 1000 // page_size 上限
+	maxSearchRunes     = 64   // 搜索关键字最大字符数，超出部分截断
+)
+
+var deviceListPages = metrics.NewCounterVec("tp_plugin_device_list_pages_total",
+	"设备列表请求的分页处理：ok 原样转发，defaulted 使用默认值，clamped 超过上限，unpaginated 小智服务端未分页由插件截取", "result")
+
+// ListConfig 设备列表分页配置
+type ListConfig struct {
+	DefaultPageSize int // 平台传入的 page_size 不合法时使用，0使用默认值20
+	MaxPageSize     int // page_size 上限，超过时按上限请求，0使用默认值1000
+	CacheSeconds    int // 相同租户、分页参数和搜索关键字的设备列表缓存秒数，0为不缓存
+}
+
+// page 校验平台传入的分页参数：page 小于1时为1，page_size 不合法时使用默认值、超过上限时按上限；
+// 返回校验后的参数及处理结果(指标标签)
+func (c ListConfig) page(page, size int) (int, int, string) {
+	def, max := c.DefaultPageSize, c.MaxPageSize
+	if max <= 0 {
+		max = DefaultMaxPageSize
+	}
+	if def <= 0 || def > max {
+		def = min(DefaultPageSize, max)
+	}
+	result := "ok"
+	if page < 1 {
+		page, result = 1, "defaulted"
+	}
+	switch {
+	case size <= 0:
+		size, result = def, "defaulted"
+	case size > max:
+		size, result = max, "clamped"
+	}
+	return page, size, result
+}
+
+// paginateDeviceList 小智服务端忽略分页参数返回了全部设备时按分页参数截取；
+// total 小于已翻过的设备数(服务端未返回或少报总数)时按实际数量修正。返回是否由插件截取
+func paginateDeviceList(data *handler.DeviceListData, page, size int) bool {
+	offset := (page - 1) * size
+	unpaginated := len(data.List) > size
+	if unpaginated {
+		data.Total = max(data.Total, len(data.List))
+		start := min(offset, len(data.List))
+		data.List = data.List[start:min(start+size, len(data.List))]
+	}
+	if len(data.List) > 0 {
+		data.Total = max(data.Total, offset+len(data.List))
+	}
+	return unpaginated
+}
+
+// deviceSearchKey 设备列表搜索关键字在 ctx 中的键
+type deviceSearchKey struct{}
+
+// withDeviceSearch 取出平台请求的 search 查询参数经 ctx 传给设备列表处理；
+// 关键字去掉控制字符和首尾空白，超过64个字符时截断
+func withDeviceSearch(ctx context.Context, r *http.Request) context.Context {
+	search := r.URL.Query().Get("search")
+	if search == "" {
+		return ctx
+	}
+	search, _ = textnorm.Clean(search, textnorm.Options{})
+	if runes := []rune(search); len(runes) > maxSearchRunes {
+		search = strings.TrimSpace(string(runes[:maxSearchRunes]))
+	}
+	if search == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, deviceSearchKey{}, search)
+}
+
+// deviceSearch 返回请求的设备列表搜索关键字，未指定时为空
+func deviceSearch(ctx context.Context) string {
+	s, _ := ctx.Value(deviceSearchKey{}).(string)
+	return s
+}
+
+// serveDeviceList GET /api/v1/plugin/device/list，查询参数的校验与SDK一致
+func (h *HTTPHandler) serveDeviceList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writePlatform(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	q := r.URL.Query()
+	req := handler.GetDeviceListRequest{Voucher: q.Get("voucher"), ServiceIdentifier: q.Get("service_identifier")}
+	if req.Voucher == "" || q.Get("page") == "" || q.Get("page_size") == "" {
+		writePlatform(w, http.StatusBadRequest, "missing required query parameters", nil)
+		return
+	}
+	var err error
+	if req.PageSize, err = strconv.Atoi(q.Get("page_size")); err != nil {
+		writePlatform(w, http.StatusBadRequest, "invalid page_size", nil)
+		return
+	}
+	if req.Page, err = strconv.Atoi(q.Get("page")); err != nil {
+		writePlatform(w, http.StatusBadRequest, "invalid page", nil)
+		return
+	}
+
+	resp, err := h.handleGetDeviceList(withDeviceSearch(r.Context(), r), &req)
+	observeRequest(endpointDeviceList, req.ServiceIdentifier, "", err)
+	if err != nil {
+		writePlatform(w, platformCode(err), err.Error(), nil)
+		return
+	}
+	writePlatform(w, resp.Code, resp.Message, resp.Data)
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/upstream"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+)
+
+// benchDeviceCounts 基准测试模拟的单租户设备数量，评估万级设备租户下的耗时与内存分配
+var benchDeviceCounts = []int{100, 1000, 10000}
+
+// fakeDeviceListPayload 生成指定数量设备的第三方 /device/list 响应
+func fakeDeviceListPayload(tb testing.TB, devices int) []byte {
+	tb.Helper()
+	var resp upstream.DeviceListResponse
+	resp.Data.Total = devices
+	resp.Data.List = make([]upstream.Device, 0, devices)
+	for i := 0; i < devices; i++ {
+		resp.Data.List = append(resp.Data.List, upstream.Device{
+			DeviceName:   fmt.Sprintf("xiaozhi-%05d", i),
+			DeviceNumber: fmt.Sprintf("A4:CF:12:%02X:%02X:%02X", i>>16&0xff, i>>8&0xff, i&0xff),
+			Description:  "ESP32-S3 小智语音助手",
+		})
+	}
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return payload
+}
+
+// benchDeviceList 按设备数量分组运行基准测试，fn 的 payload 为对应数量的第三方响应
+func benchDeviceList(b *testing.B, fn func(b *testing.B, devices int, payload []byte)) {
+	for _, devices := range benchDeviceCounts {
+		payload := fakeDeviceListPayload(b, devices)
+		b.Run(fmt.Sprintf("devices=%d", devices), func(b *testing.B) {
+			b.ReportAllocs()
+			fn(b, devices, payload)
+		})
+	}
+}
+
+func BenchmarkDecodeDeviceList(b *testing.B) {
+	benchDeviceList(b, func(b *testing.B, _ int, payload []byte) {
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeDeviceList(context.Background(), payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncodeDeviceList(b *testing.B) {
+	benchDeviceList(b, func(b *testing.B, _ int, payload []byte) {
+		data, err := decodeDeviceList(context.Background(), payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(len(payload)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf := bufpool.Get()
+			if err := json.NewEncoder(buf).Encode(handler.CommonResponse{Code: 200, Message: "success", Data: data}); err != nil {
+				b.Fatal(err)
+			}
+			bufpool.Put(buf)
+		}
+	})
+}
+
+func BenchmarkEncodeUpstreamRequest(b *testing.B) {
+	benchDeviceList(b, func(b *testing.B, devices int, _ []byte) {
+		requestData := upstream.DeviceListRequest{
+			Voucher:           testVoucher,
+			ServiceIdentifier: "ESP32",
+			Page:              1,
+			PageSize:          devices,
+		}
+		for i := 0; i < b.N; i++ {
+			buf, err := bufpool.EncodeJSON(requestData)
+			if err != nil {
+				b.Fatal(err)
+			}
+			bufpool.Put(buf)
+		}
+	})
+}
+
+// BenchmarkDeviceListRoundTrip 读取第三方响应、解码转换、编码平台响应的完整链路
+func BenchmarkDeviceListRoundTrip(b *testing.B) {
+	benchDeviceList(b, func(b *testing.B, _ int, payload []byte) {
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			body, err := readBody(bytes.NewReader(payload))
+			if err != nil {
+				b.Fatal(err)
+			}
+			list, err := decodeDeviceList(context.Background(), body.Bytes())
+			if err != nil {
+				b.Fatal(err)
+			}
+			out := bufpool.Get()
+			if err := json.NewEncoder(out).Encode(handler.CommonResponse{Code: 200, Message: "success", Data: list}); err != nil {
+				b.Fatal(err)
+			}
+			bufpool.Put(out)
+			bufpool.Put(body)
+		}
+	})
+}
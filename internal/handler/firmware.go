@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/trace"
+)
+
+var firmwareRequests = metrics.NewCounterVec("tp_plugin_device_firmware_total",
+	"设备经插件下载固件的次数", "result")
+
+// FirmwareInfo 设备查询到的进行中的固件升级，字段与 ota 消息的 params 一致
+type FirmwareInfo struct {
+	TaskID  string `json:"task_id"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Module  string `json:"module,omitempty"`
+}
+
+// deviceAuthorized 校验设备侧请求的凭证，失败时写入401响应
+func (h *HTTPHandler) deviceAuthorized(w http.ResponseWriter, r *http.Request) (string, bool) {
+	number := r.Header.Get(deviceIDHeader)
+	username, password, _ := r.BasicAuth()
+	if _, err := h.authorizeDevice(number, username, password); err != nil {
+		h.log(r.Context()).WithField("device_number", number).Warn("设备侧接口鉴权失败")
+		w.Header().Set("WWW-Authenticate", `Basic realm="device"`)
+		writeAdmin(w, http.StatusUnauthorized, adminResponse{Code: http.StatusUnauthorized, Message: i18n.Tc(r.Context(), "device.unauthorized")})
+		return number, false
+	}
+	return number, true
+}
+
+// deviceOTA GET /device/ota 返回设备进行中的固件升级，没有时 data 为null；
+// 设备升级期间重启后查询，继续按下载地址升级
+func (h *HTTPHandler) deviceOTA(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	number, ok := h.deviceAuthorized(w, r)
+	if !ok {
+		return
+	}
+	var info *FirmwareInfo
+	if t, ok := h.upgrades.Pending(number); ok {
+		info = &FirmwareInfo{TaskID: t.TaskID, Version: t.Version, URL: t.URL, Size: t.Size, SHA256: t.SHA256, Module: t.Module}
+	}
+	h.writeCached(w, r, info)
+}
+
+// deviceFirmware GET /device/firmware?task_id= 下载进行中任务的固件，支持 Range 断点续传
+func (h *HTTPHandler) deviceFirmware(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeAdmin(w, http.StatusMethodNotAllowed, adminResponse{Code: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)})
+		return
+	}
+	number, ok := h.deviceAuthorized(w, r)
+	if !ok {
+		firmwareRequests.WithLabelValues("unauthorized").Inc()
+		return
+	}
+	t, path, ok := h.upgrades.Firmware(number, r.URL.Query().Get("task_id"))
+	if !ok {
+		firmwareRequests.WithLabelValues("not_found").Inc()
+		writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: i18n.Tc(r.Context(), "ota.not_found")})
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		firmwareRequests.WithLabelValues("error").Inc()
+		h.log(r.Context()).WithError(err).WithField("device_number", number).Error("读取固件文件失败")
+		writeAdmin(w, http.StatusInternalServerError, adminResponse{Code: http.StatusInternalServerError, Message: http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		firmwareRequests.WithLabelValues("error").Inc()
+		writeAdmin(w, http.StatusInternalServerError, adminResponse{Code: http.StatusInternalServerError, Message: http.StatusText(http.StatusInternalServerError)})
+		return
+	}
+	firmwareRequests.WithLabelValues("ok").Inc()
+	h.tracer.Record(number, trace.Out, "firmware", map[string]interface{}{"task_id": t.TaskID, "version": t.Version, "range": r.Header.Get("Range")})
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+t.SHA256+`"`)
+	http.ServeContent(w, r, t.Version+".bin", fi.ModTime(), f)
+}
+
+// adminOTA 各设备最近一次固件升级任务
+func (h *HTTPHandler) adminOTA(w http.ResponseWriter, r *http.Request) {
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	adminOK(w, r, h.upgrades.Tasks())
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	formRequests = metrics.NewCounterVec("tp_plugin_form_requests_total",
+		"表单配置请求数", "form_type", "protocol_type", "device_type")
+	formCacheHits = metrics.NewCounterVec("tp_plugin_form_cache_total",
+		"表单缓存命中情况", "result")
+)
+
+// deviceTypePattern 设备类型/协议类型只允许字母、数字、下划线和中划线，防止路径穿越
+var deviceTypePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// formTypeLabel 将表单类型归并为有限的指标标签值
+func formTypeLabel(formType string) string {
+	switch formType {
+	case "CFG", "VCR", "SVCR":
+		return formType
+	default:
+		return "other"
+	}
+}
+
+// formEntry 已解析的表单及其文件状态
+type formEntry struct {
+	modTime time.Time
+	size    int64
+	data    interface{}
+}
+
+// FormRegistry 按 协议类型/设备类型/表单类型 查找表单定义：内置表单(internal/form_json，见 formjson.Registry)编译进程序，
+// 不依赖运行目录；配置了表单目录时，目录中的同名文件优先于同一位置的内置表单，目录下 <protocol_type>/ 子目录
+// 为协议类型专属的表单。返回的表单会被多个请求共享，调用方不得修改
+type FormRegistry struct {
+	dir      string
+	embedded *formjson.Registry
+	logger   *logrus.Logger
+
+	mu      sync.RWMutex
+	entries map[string]*formEntry // 表单目录中的文件按修改时间和大小缓存
+}
+
+// NewFormRegistry 创建表单注册表，dir 为空时只使用内置表单；dir 不是目录或内置表单无法解析时返回错误
+func NewFormRegistry(dir string, logger *logrus.Logger) (*FormRegistry, error) {
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("读取表单目录失败: %v", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("表单目录不是目录: %s", dir)
+		}
+	}
+	embedded, err := formjson.Embedded()
+	if err != nil {
+		return nil, fmt.Errorf("加载内置表单失败: %v", err)
+	}
+	return &FormRegistry{dir: dir, embedded: embedded, logger: logger, entries: map[string]*formEntry{}}, nil
+}
+
+// protocols 依次查找的协议类型：协议类型专属、公共
+func protocols(protocolType string) []string {
+	if deviceTypePattern.MatchString(protocolType) {
+		return []string{protocolType, ""}
+	}
+	return []string{""}
+}
+
+// Lookup 按设备类型精确查找表单，返回表单及来源(formSourceFile/formSourceEmbedded)；协议类型专属的表单优先于公共表单，
+// 同一位置表单目录中的文件优先于内置表单，文件读取失败时记录警告并使用内置表单。都不存在时返回nil
+func (r *FormRegistry) Lookup(key FormKey) (interface{}, string) {
+	for _, proto := range protocols(key.ProtocolType) {
+		if r.dir != "" {
+			for _, name := range formjson.FileNames(key.FormType, key.DeviceType) {
+				path := filepath.Join(r.dir, proto, name)
+				if _, err := os.Stat(path); err != nil {
+					continue
+				}
+				data, err := r.load(path)
+				if err == nil {
+					return data, formSourceFile
+				}
+				var pathErr *os.PathError
+				if errors.As(err, &pathErr) {
+					r.logger.Warn(i18n.Td("form.open_failed", err.Error()))
+				} else {
+					r.logger.Warn(i18n.Td("form.decode_failed", err.Error()))
+				}
+			}
+		}
+		if data, ok := r.embedded.Get(formjson.Key{ProtocolType: proto, FormType: key.FormType, DeviceType: key.DeviceType}); ok {
+			return data, formSourceEmbedded
+		}
+	}
+	return nil, ""
+}
+
+// Available 列出协议类型可用的表单(含公共表单)，返回 表单类型 -> 设备类型集合，默认表单的设备类型为空
+func (r *FormRegistry) Available(protocolType string) map[string]map[string]bool {
+	forms := map[string]map[string]bool{formjson.FormConfig: {}, formjson.FormVoucher: {}, formjson.FormServiceVoucher: {}}
+	protos := protocols(protocolType)
+	for _, k := range r.embedded.Keys() {
+		if k.ProtocolType == protos[0] || k.ProtocolType == "" {
+			forms[k.FormType][k.DeviceType] = true
+		}
+	}
+	if r.dir == "" {
+		return forms
+	}
+	for _, proto := range protos {
+		dir := filepath.Join(r.dir, proto)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if proto == "" || !os.IsNotExist(err) {
+				r.logger.WithError(err).WithField("dir", dir).Warn("读取表单目录失败")
+			}
+			continue
+		}
+		for _, e := range entries {
+			if formType, deviceType, ok := formjson.ParseFileName(e.Name()); ok && !e.IsDir() {
+				forms[formType][deviceType] = true
+			}
+		}
+	}
+	return forms
+}
+
+// load 读取表单目录中的文件，文件未变化时直接返回缓存
+func (r *FormRegistry) load(path string) (interface{}, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	entry, ok := r.entries[path]
+	r.mu.RUnlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		formCacheHits.WithLabelValues("hit").Inc()
+		return entry.data, nil
+	}
+	formCacheHits.WithLabelValues("miss").Inc()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.entries[path] = &formEntry{modTime: info.ModTime(), size: info.Size(), data: data}
+	r.mu.Unlock()
+	r.logger.WithField("path", path).Info(i18n.Td("form.read_success", path))
+	return data, nil
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"tp-plugin/internal/store"
+)
+
+// 表单来源
+const (
+	formSourceStore    = "store"    // 管理接口编辑后保存在本地存储中
+	formSourceFile     = "file"     // 表单目录(server.forms_dir)中的表单文件
+	formSourceEmbedded = "embedded" // 编译进程序的内置表单
+)
+
+// formRecord 存储中的表单覆盖
+type formRecord struct {
+	Form      json.RawMessage `json:"form"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// FormKey 表单的定位条件，协议类型和设备类型为空时表示公共/默认表单
+type FormKey struct {
+	ProtocolType string `json:"protocol_type,omitempty"`
+	FormType     string `json:"form_type"`
+	DeviceType   string `json:"device_type,omitempty"`
+}
+
+func (k FormKey) String() string {
+	return k.ProtocolType + "/" + k.FormType + "/" + k.DeviceType
+}
+
+func parseFormKey(s string) FormKey {
+	parts := strings.SplitN(s, "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return FormKey{ProtocolType: parts[0], FormType: parts[1], DeviceType: parts[2]}
+}
+
+// validate 校验表单类型，协议类型和设备类型只允许字母、数字、下划线和中划线
+func (k FormKey) validate() error {
+	if formTypeLabel(k.FormType) == "other" {
+		return fmt.Errorf("不支持的表单类型: %s", k.FormType)
+	}
+	for _, v := range []string{k.ProtocolType, k.DeviceType} {
+		if v != "" && !deviceTypePattern.MatchString(v) {
+			return fmt.Errorf("类型不合法: %s", v)
+		}
+	}
+	return nil
+}
+
+// resolveForm 按设备类型表单、默认表单的顺序查找，同一层级存储中的表单优先于表单目录和内置表单；
+// 存储中协议专属的表单优先于公共表单。都不存在时返回nil
+func (h *HTTPHandler) resolveForm(key FormKey) (interface{}, string) {
+	deviceTypes := []string{""}
+	if deviceTypePattern.MatchString(key.DeviceType) {
+		deviceTypes = []string{key.DeviceType, ""}
+	}
+	for _, dt := range deviceTypes {
+		for _, proto := range protocols(key.ProtocolType) {
+			if form, ok := h.storedForm(FormKey{ProtocolType: proto, FormType: key.FormType, DeviceType: dt}); ok {
+				return form, formSourceStore
+			}
+		}
+		if form, source := h.forms.Lookup(FormKey{ProtocolType: key.ProtocolType, FormType: key.FormType, DeviceType: dt}); form != nil {
+			return form, source
+		}
+	}
+	return nil, ""
+}
+
+// storedForm 读取存储中的表单，未启用存储或不存在时返回false
+func (h *HTTPHandler) storedForm(key FormKey) (interface{}, bool) {
+	if h.store == nil {
+		return nil, false
+	}
+	var rec formRecord
+	if err := h.store.Get(store.BucketForms, key.String(), &rec); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			h.logger.WithError(err).WithField("form", key.String()).Warn("读取存储中的表单失败")
+		}
+		return nil, false
+	}
+	var form interface{}
+	if err := json.Unmarshal(rec.Form, &form); err != nil {
+		h.logger.WithError(err).WithField("form", key.String()).Warn("解析存储中的表单失败")
+		return nil, false
+	}
+	return form, true
+}
+
+// SaveForm 校验并保存表单，之后的表单请求优先返回该表单
+func (h *HTTPHandler) SaveForm(key FormKey, form json.RawMessage) error {
+	if h.store == nil {
+		return errors.New("本地存储未启用")
+	}
+	if err := key.validate(); err != nil {
+		return err
+	}
+	if err := validateForm(form); err != nil {
+		return err
+	}
+	if err := h.store.Put(store.BucketForms, key.String(), formRecord{Form: form, UpdatedAt: time.Now()}); err != nil {
+		return err
+	}
+	h.logger.WithField("form", key.String()).Info("表单已更新")
+	return nil
+}
+
+// DeleteForm 删除存储中的表单，恢复使用表单文件
+func (h *HTTPHandler) DeleteForm(key FormKey) error {
+	if h.store == nil {
+		return errors.New("本地存储未启用")
+	}
+	if err := key.validate(); err != nil {
+		return err
+	}
+	if err := h.store.Delete(store.BucketForms, key.String()); err != nil {
+		return err
+	}
+	h.logger.WithField("form", key.String()).Info("表单已恢复为文件默认值")
+	return nil
+}
+
+// storedFormInfo 存储中的表单概要
+type storedFormInfo struct {
+	FormKey
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StoredForms 列出存储中的全部表单
+func (h *HTTPHandler) StoredForms() ([]storedFormInfo, error) {
+	if h.store == nil {
+		return nil, errors.New("本地存储未启用")
+	}
+	list := []storedFormInfo{}
+	err := h.store.ForEach(store.BucketForms, func(key string, data []byte) error {
+		var rec formRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			h.logger.WithError(err).WithField("form", key).Warn("解析存储中的表单失败")
+			return nil
+		}
+		list = append(list, storedFormInfo{FormKey: parseFormKey(key), UpdatedAt: rec.UpdatedAt})
+		return nil
+	})
+	return list, err
+}
+
+// validateForm 校验表单结构：顶层为表单元素数组，或值为表单元素数组的对象(如 {"config": [...]})；
+// 每个元素必须包含 type 和 dataKey，同一数组内 dataKey 不得重复，table 元素的 array 按同样规则校验
+func validateForm(raw json.RawMessage) error {
+	var form interface{}
+	if err := json.Unmarshal(raw, &form); err != nil {
+		return fmt.Errorf("表单不是合法的JSON: %v", err)
+	}
+	switch v := form.(type) {
+	case []interface{}:
+		return validateFormElements("", v)
+	case map[string]interface{}:
+		for name, field := range v {
+			if elements, ok := field.([]interface{}); ok {
+				if err := validateFormElements(name, elements); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	default:
+		return errors.New("表单必须是数组或对象")
+	}
+}
+
+func validateFormElements(path string, elements []interface{}) error {
+	seen := make(map[string]bool, len(elements))
+	for i, e := range elements {
+		where := fmt.Sprintf("%s[%d]", path, i)
+		element, ok := e.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("表单元素%s必须是对象", where)
+		}
+		typ, _ := element["type"].(string)
+		dataKey, _ := element["dataKey"].(string)
+		if typ == "" || dataKey == "" {
+			return fmt.Errorf("表单元素%s缺少 type 或 dataKey", where)
+		}
+		if seen[dataKey] {
+			return fmt.Errorf("表单元素%s的 dataKey 重复: %s", where, dataKey)
+		}
+		seen[dataKey] = true
+		if typ == "table" {
+			columns, ok := element["array"].([]interface{})
+			if !ok {
+				return fmt.Errorf("表格元素%s缺少 array", where)
+			}
+			if err := validateFormElements(where+".array", columns); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tp-plugin/internal/metrics"
+)
+
+var formValuesInvalid = metrics.NewCounterVec("tp_plugin_form_values_invalid_total",
+	"不符合CFG表单校验规则的配置值", "source")
+
+// FieldError 单个配置项的校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// formElements 取表单的顶层元素：数组表单直接返回，对象表单合并各数组字段；table 元素不参与值校验
+func formElements(form interface{}) []map[string]interface{} {
+	var raw []interface{}
+	switch v := form.(type) {
+	case []interface{}:
+		raw = v
+	case map[string]interface{}:
+		for _, field := range v {
+			if elements, ok := field.([]interface{}); ok {
+				raw = append(raw, elements...)
+			}
+		}
+	}
+	elements := make([]map[string]interface{}, 0, len(raw))
+	for _, e := range raw {
+		if element, ok := e.(map[string]interface{}); ok && element["type"] != "table" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// validateFormValues 按表单元素的 validate 规则(required、type、rules、min/max)及 select 选项校验配置值，
+// partial 为 true 时只校验提交的项，不检查必填
+func validateFormValues(form interface{}, values map[string]interface{}, partial bool) []FieldError {
+	var errs []FieldError
+	for _, element := range formElements(form) {
+		key, _ := element["dataKey"].(string)
+		if key == "" {
+			continue
+		}
+		rule, _ := element["validate"].(map[string]interface{})
+		message, _ := rule["message"].(string)
+		v, ok := values[key]
+		if !ok || v == nil || v == "" {
+			if required, _ := rule["required"].(bool); required && !partial {
+				errs = append(errs, fieldError(key, message, "不能为空"))
+			}
+			continue
+		}
+		if err := checkFormValue(element, rule, v); err != "" {
+			errs = append(errs, fieldError(key, message, err))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+func fieldError(key, message, fallback string) FieldError {
+	if message == "" {
+		message = fallback
+	}
+	return FieldError{Field: key, Message: message}
+}
+
+// checkFormValue 校验单个值，返回错误说明，合法时返回空字符串
+func checkFormValue(element, rule map[string]interface{}, v interface{}) string {
+	text := formValueText(v)
+	if options, ok := element["options"].([]interface{}); ok && len(options) > 0 {
+		found := false
+		for _, o := range options {
+			if option, ok := o.(map[string]interface{}); ok && formValueText(option["value"]) == text {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "不是可选的值"
+		}
+	}
+	if typ, _ := rule["type"].(string); typ == "number" {
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return "必须是数字"
+		}
+		if min, ok := rule["min"].(float64); ok && n < min {
+			return fmt.Sprintf("不能小于%v", min)
+		}
+		if max, ok := rule["max"].(float64); ok && n > max {
+			return fmt.Sprintf("不能大于%v", max)
+		}
+	}
+	if rules, _ := rule["rules"].(string); rules != "" {
+		re, err := compileFormRule(rules)
+		if err == nil && !re.MatchString(text) {
+			return "格式不正确"
+		}
+	}
+	return ""
+}
+
+// formValueText 配置值的文本形式，平台保存的表单值可能是数字或字符串
+func formValueText(v interface{}) string {
+	switch n := v.(type) {
+	case string:
+		return n
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case json.Number:
+		return n.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formRules 已编译的表单校验正则
+var formRules = struct {
+	mu    sync.Mutex
+	cache map[string]*regexp.Regexp
+}{cache: map[string]*regexp.Regexp{}}
+
+// compileFormRule 编译前端格式的正则 /pattern/flags，只支持 i 标志
+func compileFormRule(rules string) (*regexp.Regexp, error) {
+	formRules.mu.Lock()
+	defer formRules.mu.Unlock()
+	if re, ok := formRules.cache[rules]; ok {
+		return re, nil
+	}
+	pattern := rules
+	if strings.HasPrefix(pattern, "/") {
+		if end := strings.LastIndex(pattern, "/"); end > 0 {
+			flags := pattern[end+1:]
+			pattern = pattern[1:end]
+			if strings.Contains(flags, "i") {
+				pattern = "(?i)" + pattern
+			}
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	formRules.cache[rules] = re
+	return re, nil
+}
+
+// validDeviceConfig 按设备的CFG表单校验平台中保存的配置值，去掉不合法的项后返回副本
+func (h *HTTPHandler) validDeviceConfig(protocolType, deviceType, deviceNumber string, config map[string]interface{}) map[string]interface{} {
+	if len(config) == 0 {
+		return config
+	}
+	form, _ := h.resolveForm(FormKey{ProtocolType: protocolType, FormType: "CFG", DeviceType: deviceType})
+	errs := validateFormValues(form, config, true)
+	if len(errs) == 0 {
+		return config
+	}
+	out := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		out[k] = v
+	}
+	for _, e := range errs {
+		delete(out, e.Field)
+		formValuesInvalid.WithLabelValues("bundle").Inc()
+		h.logger.WithField("device_number", deviceNumber).WithField("field", e.Field).WithField("value", config[e.Field]).
+			Warn("设备CFG配置值不合法，已从配置包中去掉: " + e.Message)
+	}
+	return out
+}
+
+// validateShadow 按设备的CFG表单校验设备影子中要修改的配置项；获取设备信息失败时使用默认CFG表单
+func (h *HTTPHandler) validateShadow(deviceNumber string, desired map[string]interface{}) []FieldError {
+	if len(desired) == 0 {
+		return nil
+	}
+	key := FormKey{FormType: "CFG"}
+	if device, err := h.platform.GetDevice(deviceNumber); err == nil {
+		key.ProtocolType, key.DeviceType = device.ProtocolType, device.DeviceType
+	}
+	form, _ := h.resolveForm(key)
+	return validateFormValues(form, desired, true)
+}
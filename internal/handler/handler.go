@@ -2,15 +2,37 @@
 package handler
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tp-plugin/internal/audit"
+	"tp-plugin/internal/capture"
+	"tp-plugin/internal/downlink"
 	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/middleware"
+	"tp-plugin/internal/ota"
+	"tp-plugin/internal/pipeline"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/pkg/redact"
 	"tp-plugin/internal/platform"
+	"tp-plugin/internal/profile"
+	"tp-plugin/internal/session"
+	"tp-plugin/internal/startup"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/support"
+	"tp-plugin/internal/trace"
+	"tp-plugin/internal/transform"
+	"tp-plugin/internal/tunnel"
+	"tp-plugin/internal/upstream"
+	"tp-plugin/internal/wsserver"
 
 	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
 	"github.com/sirupsen/logrus"
@@ -21,110 +43,362 @@ type logrusWriter struct {
 	logger *logrus.Logger
 }
 
+// Write 访问日志由中间件统一记录，SDK内部日志降为Debug级别
 func (w *logrusWriter) Write(p []byte) (n int, err error) {
-	w.logger.Info(string(p))
+	w.logger.Debug(strings.TrimRight(string(p), "\n"))
 	return len(p), nil
 }
 
 // HTTPHandler HTTP服务处理器
 type HTTPHandler struct {
-	platform *platform.PlatformClient
-	logger   *logrus.Logger
-	stdlog   *log.Logger
+	platform  *platform.PlatformClient
+	logger    *logrus.Logger
+	stdlog    *log.Logger
+	protocols *protocolHandlers
+	basePath  string
+	services  map[string]bool
+	profiles  *profile.Publisher
+	upstream  *http.Client
+	// upstreamOptions 单次调用小智服务端的超时时间及附加的请求头，可通过 SetUpstream 热更新
+	upstreamOptions atomic.Pointer[upstreamSettings]
+	tracer          *trace.Tracer
+	capture         *capture.Capturer
+	store           *store.Store
+	startup         *startup.Manager
+	forms           *FormRegistry
+	pipelines       *pipeline.Engine
+	transforms      *transform.Registry
+	binary          transform.BinaryLimits
+	bundle          BundleConfig
+	deviceCache     CacheConfig
+	text            TextConfig
+	deviceList      ListConfig
+	listCache       deviceListCache
+	broadcasts      broadcastRegistry
+	quota           quotaThrottle
+	breakers        circuitBreakers
+	idempotency     idempotencyKeys
+	sessions        sessionTokens
+	owners          deviceOwners
+	notes           deviceNotes
+	support         *support.Generator
+	tunnels         *tunnel.Hub
+	devices         *wsserver.Server
+	downlink        *downlink.Router
+	heartbeats      *session.Manager
+	upgrades        *ota.Manager
+	auditLog        *audit.Logger
+	notify          NotifyConfig
+	broadcast       BroadcastConfig
+	notifyJobs      chan notifyJob
+	notifyPending   sync.WaitGroup // 已入队未处理完的通知，退出时等待
+	notifyOrder     notifyOrder
+	voucherSaved    sync.Map // 凭证摘要 -> 最近一次写入存储的时间
+}
+
+// Config HTTP处理器配置
+type Config struct {
+	ProtocolVersion string // 平台插件协议版本，为空时使用 DefaultProtocol
+	BasePath        string // 路由前缀，部署在按路径转发的网关后时使用，如 /plugins/esp32
+	// ServiceIdentifiers 插件注册的服务标识符(协议类型)，请求按 protocol_type/service_identifier 分发；
+	// 为空时不校验
+	ServiceIdentifiers []string
+	Profiles           *profile.Publisher // 设备能力模型发布器，为nil时不发布
+	// UpstreamTransport 调用小智服务端使用的传输层，为nil时使用默认传输层；
+	// 模拟模式下替换为 upstream.MockTransport
+	UpstreamTransport http.RoundTripper
+	UpstreamTimeout   time.Duration          // 调用小智服务端的超时时间，为0时使用 DefaultUpstreamTimeout
+	UpstreamHeaders   UpstreamHeaders        // 调用小智服务端时附加的请求头，为nil时只附加凭证中的请求头
+	Tracer            *trace.Tracer          // 设备追踪，为nil时不记录且管理接口不可用
+	Capture           *capture.Capturer      // MQTT抓包，为nil时管理接口不可用
+	Store             *store.Store           // 本地存储，用于记录租户凭证和保存编辑后的表单，为nil时不记录且表单只读文件
+	Startup           *startup.Manager       // 启动依赖，用于运行状态和 /admin/startup，为nil时不返回
+	Forms             *FormRegistry          // 表单定义，为nil时只使用内置表单
+	Pipelines         *pipeline.Engine       // 设备接入流水线引擎，步骤执行器在创建处理器时注册；为nil时管理接口不可用
+	Transforms        *transform.Registry    // 下行消息转换插件，为nil时不转换
+	Binary            transform.BinaryLimits // 下行二进制参数的大小和校验限制
+	Bundle            BundleConfig           // 设备配置包默认值
+	DeviceCache       CacheConfig            // 设备侧接口的缓存响应头
+	Text              TextConfig             // 设备列表文本字段的规范化
+	DeviceList        ListConfig             // 设备列表分页参数的默认值和上限
+	Support           *support.Generator     // 支持包生成器，为nil时管理接口不可用
+	Tunnels           *tunnel.Hub            // 反向隧道，为nil时管理接口返回空列表
+	Devices           *wsserver.Server       // 设备WebSocket服务，为nil时管理接口返回空列表
+	Downlink          *downlink.Router       // 平台下发消息路由，清除设备数据时删除离线排队消息，为nil时不处理
+	Heartbeats        *session.Manager       // 回调上报设备的心跳超时跟踪，为nil时不跟踪
+	OTA               *ota.Manager           // 固件升级，为nil时设备侧固件下载不可用
+	Audit             *audit.Logger          // 绑定、解绑、命令等变更调用的签名审计日志，为nil时不记录
+	Notify            NotifyConfig           // 平台通知的后台处理并发和超时
+	Broadcast         BroadcastConfig        // 批量下发命令的并发
+	Breaker           BreakerConfig          // 按小智服务地址熔断，Failures为0时不启用
 }
 
 // NewHTTPHandler 创建HTTP处理器
-func NewHTTPHandler(platform *platform.PlatformClient, logger *logrus.Logger) *HTTPHandler {
+func NewHTTPHandler(config Config, platform *platform.PlatformClient, logger *logrus.Logger) (*HTTPHandler, error) {
+	protocol, version, err := lookupProtocol(config.ProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithFields(logrus.Fields{
+		"protocol_version": version,
+		"base_path":        normalizeBasePath(config.BasePath),
+	}).Info("插件协议版本")
+
 	// 创建适配器
 	writer := &logrusWriter{logger: logger}
 	stdlog := log.New(writer, "[HTTP] ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	return &HTTPHandler{
+	h := &HTTPHandler{
 		platform: platform,
 		logger:   logger,
 		stdlog:   stdlog,
+		basePath: normalizeBasePath(config.BasePath),
+		services: toSet(config.ServiceIdentifiers),
+		profiles: config.Profiles,
+		upstream: &http.Client{Transport: config.UpstreamTransport},
+
+		tracer:      config.Tracer,
+		capture:     config.Capture,
+		store:       config.Store,
+		startup:     config.Startup,
+		forms:       config.Forms,
+		pipelines:   config.Pipelines,
+		transforms:  config.Transforms,
+		binary:      config.Binary,
+		bundle:      config.Bundle,
+		deviceCache: config.DeviceCache,
+		text:        config.Text,
+		deviceList:  config.DeviceList,
+		support:     config.Support,
+		tunnels:     config.Tunnels,
+		devices:     config.Devices,
+		downlink:    config.Downlink,
+		heartbeats:  config.Heartbeats,
+		upgrades:    config.OTA,
+		auditLog:    config.Audit,
+		notify:      config.Notify,
+		broadcast:   config.Broadcast,
+		breakers:    circuitBreakers{cfg: config.Breaker},
+	}
+	// 协议处理器在创建时构建，之后的请求复用
+	h.protocols = newProtocolHandlers(h, protocol)
+	if h.forms == nil {
+		if h.forms, err = NewFormRegistry("", logger); err != nil {
+			return nil, err
+		}
+	}
+	h.SetUpstream(config.UpstreamTimeout, config.UpstreamHeaders)
+	if h.store != nil {
+		h.sealVouchers()
+	}
+	if err := h.idempotency.load(h.store); err != nil {
+		logger.WithError(err).Warn("加载幂等键记录失败")
+	}
+	h.startNotifyWorkers()
+	go h.resumeCallbacks()
+	if h.pipelines != nil {
+		h.registerPipelineSteps(h.pipelines)
+		if err := h.pipelines.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// supportsService 检查协议类型/服务标识符是否由本插件注册
+func (h *HTTPHandler) supportsService(id string) bool {
+	return len(h.services) == 0 || h.services[id]
+}
+
+// normalizeBasePath 规范化路由前缀为 "/a/b" 形式，根路径返回空串
+func normalizeBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// RoutePath 返回加上路由前缀后的路径，用于对外上报回调地址
+func (h *HTTPHandler) RoutePath(p string) string {
+	return h.basePath + "/" + strings.TrimPrefix(p, "/")
+}
+
+// stripBasePath 去掉请求路径中的路由前缀，不匹配前缀时返回false
+func (h *HTTPHandler) stripBasePath(r *http.Request) (*http.Request, bool) {
+	if h.basePath == "" {
+		return r, true
+	}
+	rest := strings.TrimPrefix(r.URL.Path, h.basePath)
+	if len(rest) == len(r.URL.Path) || (rest != "" && rest[0] != '/') {
+		return nil, false
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+	r2.URL.RawPath = ""
+	return r2, true
+}
+
+// ServeHTTP 去掉路由前缀、解析请求语言后交给所选协议版本的处理器
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stripped, ok := h.stripBasePath(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
 	}
+	r = stripped
+
+	ctx := r.Context()
+	if l, ok := requestLocale(r); ok {
+		ctx = i18n.WithLocale(ctx, l)
+	}
+	h.protocols.serve(ctx, w, r)
+}
+
+// requestLocale 从 lang 查询参数或 Accept-Language 头中获取请求语言
+func requestLocale(r *http.Request) (i18n.Locale, bool) {
+	if l, ok := i18n.Parse(r.URL.Query().Get("lang")); ok {
+		return l, true
+	}
+	return i18n.Parse(r.Header.Get("Accept-Language"))
+}
+
+// 平台插件协议v1中由插件直接应答的接口
+const (
+	pathDeviceList = "/api/v1/plugin/device/list"
+	pathDeviceInfo = "/api/v1/plugin/device/info"
+)
+
+// protocolV1 v1协议路由：设备列表和设备信息由插件直接应答，小智服务端调用失败时响应码为插件错误码；
+// 其余接口交给SDK处理器。SDK对回调返回的错误一律应答500，且未提供设备信息回调的设置方法
+func (h *HTTPHandler) protocolV1(ctx func() context.Context) http.Handler {
+	sdk := h.RegisterHandlers(ctx)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case pathDeviceList:
+			h.serveDeviceList(w, r)
+		case pathDeviceInfo:
+			h.serveDeviceInfo(w, r)
+		default:
+			sdk.ServeHTTP(w, r)
+		}
+	})
+}
+
+// writePlatform 以SDK相同的格式应答平台，HTTP状态码固定为200，结果以响应体中的 code 区分
+func writePlatform(w http.ResponseWriter, code int, message string, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handler.CommonResponse{Code: code, Message: message, Data: data})
 }
 
-// RegisterHandlers 注册所有HTTP处理器
-func (h *HTTPHandler) RegisterHandlers() *handler.Handler {
+// RegisterHandlers 注册所有HTTP处理器(v1协议)
+// SDK回调不携带请求上下文，回调中通过 ctx 取得当前请求的上下文
+func (h *HTTPHandler) RegisterHandlers(ctx func() context.Context) *handler.Handler {
 	// 创建处理器，使用标准库Logger
 	hdl := handler.NewHandler(handler.HandlerConfig{
 		Logger: h.stdlog,
 	})
 
 	// 设置表单配置处理函数
-	hdl.SetFormConfigHandler(h.handleGetFormConfig)
+	// 各回调按接口、协议类型和设备类型计数，了解租户实际使用的流程
+	hdl.SetFormConfigHandler(func(req *handler.GetFormConfigRequest) (interface{}, error) {
+		form, err := h.handleGetFormConfig(ctx(), req)
+		observeRequest(endpointFormConfig, req.ProtocolType, req.DeviceType, err)
+		return form, err
+	})
 
 	// 设置设备断开连接处理函数
-	hdl.SetDeviceDisconnectHandler(h.handleDeviceDisconnect)
-
-	// 设置通知处理函数
-	hdl.SetNotificationHandler(h.handleNotification)
+	hdl.SetDeviceDisconnectHandler(func(req *handler.DeviceDisconnectRequest) error {
+		protocolType, deviceType := h.cachedDeviceTypes(req.DeviceID)
+		err := h.handleDeviceDisconnect(ctx(), req)
+		observeRequest(endpointDisconnect, protocolType, deviceType, err)
+		return err
+	})
 
-	// 设置获取设备列表处理函数
-	hdl.SetGetDeviceListHandler(h.handleGetDeviceList)
+	// 设置通知处理函数，校验后排队由后台处理，立即应答平台
+	hdl.SetNotificationHandler(func(req *handler.NotificationRequest) error {
+		err := h.enqueueNotification(ctx(), req)
+		observeRequest(endpointNotification, "", "", err)
+		return err
+	})
 
 	return hdl
 }
 
+// cachedDeviceTypes 从设备缓存中取协议类型和设备类型，未缓存时为空
+// 断开连接会清理缓存，需在处理前调用
+func (h *HTTPHandler) cachedDeviceTypes(deviceID string) (string, string) {
+	device, err := h.platform.GetDeviceByID(deviceID)
+	if err != nil {
+		return "", ""
+	}
+	return device.ProtocolType, device.DeviceType
+}
+
+// log 返回带请求ID的日志记录器
+func (h *HTTPHandler) log(ctx context.Context) *logrus.Entry {
+	return h.logger.WithField("request_id", middleware.RequestIDFromContext(ctx))
+}
+
 // handleGetFormConfig 处理获取表单配置请求
-func (h *HTTPHandler) handleGetFormConfig(req *handler.GetFormConfigRequest) (interface{}, error) {
-	h.logger.WithFields(logrus.Fields{
+func (h *HTTPHandler) handleGetFormConfig(ctx context.Context, req *handler.GetFormConfigRequest) (interface{}, error) {
+	h.log(ctx).WithFields(logrus.Fields{
 		"protocol_type": req.ProtocolType,
 		"device_type":   req.DeviceType,
 		"form_type":     req.FormType,
-	}).Info("收到获取表单配置请求")
+	}).Debug(i18n.Td("form.request"))
+	formRequests.WithLabelValues(formTypeLabel(req.FormType), protocolLabels.value(req.ProtocolType), deviceTypeLabels.value(req.DeviceType)).Inc()
 
-	// 根据请求类型返回不同的配置表单
+	if !h.supportsService(req.ProtocolType) {
+		return nil, errors.New(i18n.Tc(ctx, "protocol.unsupported", req.ProtocolType))
+	}
 	switch req.FormType {
-	case "CFG": // 设备配置表单
-		return nil, nil
-	case "VCR": // 设备凭证表单
-		return nil, nil
-	case "SVCR": // 服务接入点凭证表单
-		return readFormConfigByPath("../internal/form_json/form_service_voucher.json"), nil
+	case "CFG", "VCR", "SVCR":
+		// 按设备类型查找，没有时回退到默认表单；管理接口保存的表单优先于文件，
+		// 不同协议类型可在表单目录的 <protocol_type>/ 下提供各自的表单文件
+		form, _ := h.resolveForm(FormKey{ProtocolType: req.ProtocolType, FormType: req.FormType, DeviceType: req.DeviceType})
+		return form, nil
 	default:
-		return nil, fmt.Errorf("不支持的表单类型: %s", req.FormType)
+		return nil, errors.New(i18n.Tc(ctx, "form.unsupported_type", req.FormType))
 	}
 }
 
-// ./form_config.json
-func readFormConfigByPath(path string) interface{} {
-	filePtr, err := os.Open(path)
-	if err != nil {
-		logrus.Warn("文件打开失败...", err.Error())
-		return nil
-	}
-	defer filePtr.Close()
-	var info interface{}
-	// 创建json解码器
-	decoder := json.NewDecoder(filePtr)
-	err = decoder.Decode(&info)
-	if err != nil {
-		logrus.Warn("解码失败", err.Error())
-		return info
-	} else {
-		logrus.Info("读取文件[form_config.json]成功...")
-		return info
-	}
+// handleDeviceDisconnect 处理设备断开连接请求，处理状态记录在本地存储，中途退出时重启后补处理
+func (h *HTTPHandler) handleDeviceDisconnect(ctx context.Context, req *handler.DeviceDisconnectRequest) error {
+	h.log(ctx).WithField("device_id", req.DeviceID).Debug(i18n.Td("disconnect.request"))
+	rec := callbackRecord{Kind: "disconnect", DeviceID: req.DeviceID}
+	return h.trackCallback(ctx, "disconnect/"+req.DeviceID, rec, func(ctx context.Context) error {
+		return h.deviceDisconnect(ctx, req.DeviceID)
+	})
 }
 
-// handleDeviceDisconnect 处理设备断开连接请求
-func (h *HTTPHandler) handleDeviceDisconnect(req *handler.DeviceDisconnectRequest) error {
-	h.logger.WithField("device_id", req.DeviceID).Info("收到设备断开连接请求")
+// deviceDisconnect 清理设备缓存并上报离线，重复执行不产生额外影响
+func (h *HTTPHandler) deviceDisconnect(ctx context.Context, deviceID string) error {
+	deviceNumber := h.platform.DeviceNumber(deviceID)
+	h.tracer.Record(deviceNumber, trace.In, "disconnect", handler.DeviceDisconnectRequest{DeviceID: deviceID})
 
-	// 清理设备缓存
-	// Note: 因为原缓存是按 device_number 存储的,这里要先查出设备信息
-	device, err := h.platform.GetDeviceByID(req.DeviceID)
-	if err == nil { // 如果能找到设备就清理缓存
-		h.platform.ClearDeviceCache(device.DeviceNumber)
+	// 清理设备缓存，查找与删除原子完成，避免与并发的设备上线请求交错
+	h.platform.ClearDeviceCacheByID(deviceID)
+	h.heartbeats.Forget(deviceID)
+
+	if h.suppressOffline(ctx, deviceNumber, "disconnect") {
+		return nil
 	}
 
 	// 发送设备离线状态
-	err = h.platform.SendDeviceStatus(req.DeviceID, "0")
-	if err != nil {
-		h.logger.WithError(err).Error("发送设备离线状态失败")
+	if err := h.platform.SendDeviceStatus(deviceID, "0"); err != nil {
+		h.log(ctx).WithError(err).Error(i18n.Td("disconnect.status_failed"))
 		return err
 	}
 
@@ -132,143 +406,134 @@ func (h *HTTPHandler) handleDeviceDisconnect(req *handler.DeviceDisconnectReques
 }
 
 // handleNotification 处理通知请求
-func (h *HTTPHandler) handleNotification(req *handler.NotificationRequest) error {
-	h.logger.WithFields(logrus.Fields{
+func (h *HTTPHandler) handleNotification(ctx context.Context, req *handler.NotificationRequest) error {
+	h.log(ctx).WithFields(logrus.Fields{
 		"message_type": req.MessageType,
-		"message":      req.Message,
-	}).Info("收到通知请求")
+		"message":      redact.String(req.Message),
+	}).Debug(i18n.Td("notify.request"))
 
 	// 解析消息内容
 	var msgData map[string]interface{}
 	if err := json.Unmarshal([]byte(req.Message), &msgData); err != nil {
-		h.logger.WithError(err).Error("解析通知消息失败")
+		h.log(ctx).WithError(err).Error(i18n.Td("notify.parse_failed"))
 		return err
 	}
 
-	// 处理不同类型的通知
+	// 设备生命周期通知记录处理状态，中途退出时重启后补处理，已处理的重复通知跳过
+	if lifecycleNotification(req.MessageType) {
+		id, rec := notificationCallback(req)
+		return h.trackCallback(ctx, id, rec, func(ctx context.Context) error {
+			return h.dispatchNotification(ctx, req)
+		})
+	}
+	return h.dispatchNotification(ctx, req)
+}
+
+// dispatchNotification 按通知类型处理
+func (h *HTTPHandler) dispatchNotification(ctx context.Context, req *handler.NotificationRequest) error {
 	switch req.MessageType {
 	case "1": // 服务配置修改
-		h.logger.Info("处理服务配置修改通知")
-		// TODO: 实现服务配置修改逻辑
+		h.log(ctx).Info(i18n.Td("notify.service_config"))
+		return h.handleServiceConfigChange(ctx, req.Message)
 	case "2": // 设备配置修改
-		h.logger.Info("处理设备配置修改通知")
-		// TODO: 实现设备配置修改逻辑
+		h.log(ctx).Info(i18n.Td("notify.device_config"))
+		return h.handleDeviceConfigChange(ctx, req.Message)
+	case notifyDeviceUnassigned: // 设备从服务接入点移除
+		h.log(ctx).Info(i18n.Td("notify.device_unassigned"))
+		return h.handleDeviceUnassigned(ctx, req.Message)
 	default:
-		h.logger.Warnf("未知的通知类型: %s", req.MessageType)
+		h.log(ctx).Warn(i18n.Td("notify.unknown_type", req.MessageType))
 	}
 
 	return nil
 }
 
 // handleGetDeviceList 处理获取设备列表请求
-func (h *HTTPHandler) handleGetDeviceList(req *handler.GetDeviceListRequest) (*handler.DeviceListResponse, error) {
-	h.logger.WithFields(logrus.Fields{
-		"voucher":            req.Voucher,
+func (h *HTTPHandler) handleGetDeviceList(ctx context.Context, req *handler.GetDeviceListRequest) (*handler.DeviceListResponse, error) {
+	search := deviceSearch(ctx)
+	h.log(ctx).WithFields(logrus.Fields{
+		"voucher":            redact.String(req.Voucher),
 		"service_identifier": req.ServiceIdentifier,
 		"page":               req.Page,
 		"page_size":          req.PageSize,
-	}).Info("收到获取设备列表请求")
+		"search":             search,
+	}).Debug(i18n.Td("device_list.request"))
+
+	if req.ServiceIdentifier != "" && !h.supportsService(req.ServiceIdentifier) {
+		return nil, errors.New(i18n.Tc(ctx, "protocol.unsupported", req.ServiceIdentifier))
+	}
 
 	// 解析voucher, 其结构为：{"ServerURL":"http://127.0.0.1:8002/xiaozhi","Secret":"7cecb9b4-acde-4fb1-9c40-2a7f60e135ea","ThingsPanelApiKey":"sk_e6e72a3ef2aa2e7f8f15a9822a72c58bbc754aba4589df84d5d58a71c046c5fe","ThingsPanelApiURL":"http://thingspanel.local/api/v1"}
 	var voucher formjson.Voucher
 	if err := json.Unmarshal([]byte(req.Voucher), &voucher); err != nil {
-		h.logger.WithError(err).Error("解析凭证失败")
+		h.log(ctx).WithError(err).Error(i18n.Td("voucher.parse_failed"))
 		return nil, err
 	}
 
-	// 调用vourcher中的serverurl的/device/list接口, header中带上secret, 并将原始req中所有参数原封不动用post传递给/device/list接口
-	requestData := map[string]interface{}{
-		"voucher":            req.Voucher,
-		"service_identifier": req.ServiceIdentifier,
-		"page":               req.Page,
-		"page_size":          req.PageSize,
-	}
-	requestBody, err := json.Marshal(requestData)
-	if err != nil {
-		h.logger.WithError(err).Error("序列化请求数据失败")
-		return nil, err
-	}
+	// 记录租户凭证，供健康检查等运维操作使用
+	h.rememberVoucher(ctx, req.Voucher, req.ServiceIdentifier)
 
-	// 发送POST请求
-	httpReq, err := http.NewRequest("POST", voucher.ServerURL+"/device/list", bytes.NewBuffer(requestBody))
-	if err != nil {
-		h.logger.WithError(err).Error("创建请求失败")
-		return nil, err
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-token", voucher.Secret)
-
-	// 将请求的request url, header, body写入日志
-	h.logger.WithFields(logrus.Fields{
-		"url":    httpReq.URL.String(),
-		"header": httpReq.Header,
-		"body":   string(requestBody),
-	}).Info("发送第三方请求")
-
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		h.logger.WithError(err).Error("调用第三方接口失败")
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// 读取响应体
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		h.logger.WithError(err).Error("读取响应体失败")
-		return nil, err
-	}
+	// 租户首次拉取设备列表时发布设备能力模型
+	h.profiles.EnsurePublishedAsync(voucher.ThingsPanelApiURL, voucher.ThingsPanelApiKey)
 
-	// 将接口返回的信息写入日志
-	h.logger.WithFields(logrus.Fields{
-		"status_code": resp.StatusCode,
-		"body":        string(bodyBytes),
-	}).Info("第三方接口响应")
-
-	// 解析响应
-	var responseData struct {
-		Code int    `json:"code"`
-		Msg  string `json:"msg"`
-		Data struct {
-			Total int `json:"total"`
-			List  []struct {
-				DeviceName   string `json:"device_name"`
-				DeviceNumber string `json:"device_number"`
-				Description  string `json:"description"`
-			} `json:"list"`
-		} `json:"data"`
+	// 校验分页参数后调用凭证中 ServerURL 的 /device/list 接口，请求头带上密钥，搜索关键字一并转发
+	page, pageSize, result := h.deviceList.page(req.Page, req.PageSize)
+	if result != "ok" {
+		h.log(ctx).WithFields(logrus.Fields{
+			"page":      req.Page,
+			"page_size": req.PageSize,
+		}).Debug("设备列表分页参数已修正")
 	}
-	if err := json.Unmarshal(bodyBytes, &responseData); err != nil {
-		h.logger.WithError(err).Error("解析响应数据失败")
-		return nil, err
-	}
-
-	// 组装DeviceListData
-	deviceListData := handler.DeviceListData{
-		List:  []handler.DeviceItem{},
-		Total: responseData.Data.Total,
+	// 短时间内相同的请求(平台页面反复刷新)直接使用缓存，设备绑定或解绑后清除
+	cacheKey := listCacheKey{
+		tenant:   formjson.VoucherKey(req.Voucher),
+		service:  req.ServiceIdentifier,
+		page:     page,
+		pageSize: pageSize,
+		search:   search,
 	}
-	for _, device := range responseData.Data.List {
-		deviceListData.List = append(deviceListData.List, handler.DeviceItem{
-			DeviceName:   device.DeviceName,
-			DeviceNumber: device.DeviceNumber,
-			Description:  device.Description,
+	deviceListData, cached := h.listCache.get(cacheKey)
+	if !cached {
+		body, err := h.callUpstream(ctx, voucher, req.Voucher, "/device/list", upstream.DeviceListRequest{
+			Voucher:           req.Voucher,
+			ServiceIdentifier: req.ServiceIdentifier,
+			Page:              page,
+			PageSize:          pageSize,
+			Search:            search,
 		})
+		if err != nil {
+			return nil, err
+		}
+		defer bufpool.Put(body)
+
+		// 解析响应并组装DeviceListData
+		deviceListData, err = decodeDescribedDeviceList(ctx, body.Bytes(), h.descriptionTemplate(ctx, voucher))
+		if err != nil {
+			h.log(ctx).WithError(err).Error(i18n.Td("upstream.call_failed"))
+			return nil, err
+		}
+		h.observeDeviceList(req.Voucher, deviceListData.List)
+		if paginateDeviceList(&deviceListData, page, pageSize) {
+			result = "unpaginated"
+		}
+		deviceListPages.WithLabelValues(result).Inc()
+		h.listCache.put(cacheKey, deviceListData, time.Duration(h.deviceList.CacheSeconds)*time.Second)
 	}
+	h.annotateMaintenance(ctx, deviceListData.List)
+	h.normalizeDeviceList(ctx, deviceListData.List)
 
 	rsp := handler.DeviceListResponse{
 		Code:    200,
-		Message: "获取成功",
+		Message: i18n.Tc(ctx, "device_list.success"),
 		Data:    deviceListData,
 	}
 
 	// 将最终的rsp写入日志
-	h.logger.WithFields(logrus.Fields{
+	h.log(ctx).WithFields(logrus.Fields{
 		"code":    rsp.Code,
 		"message": rsp.Message,
 		"data":    rsp.Data,
-	}).Info("接口响应")
+	}).Debug(i18n.Td("handler.response"))
 
 	return &rsp, nil
 }
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"tp-plugin/internal/platform"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/upstream"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// testVoucher 测试使用的服务接入点凭证，小智服务端由 config.UpstreamTransport 模拟
+const testVoucher = `{"ServerURL":"http://xiaozhi.test/xiaozhi","Secret":"secret"}`
+
+// testDeviceID 模拟平台为设备编号分配的设备ID
+func testDeviceID(number string) string {
+	return "id-" + number
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// newTestPlatform 启动模拟ThingsPanel平台接口并创建不连接MQTT的平台客户端
+func newTestPlatform(t testing.TB) *platform.PlatformClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/plugin/device/config" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			DeviceNumber string `json:"device_number"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    200,
+			"message": "success",
+			"data": types.Device{
+				ID:           testDeviceID(req.DeviceNumber),
+				DeviceNumber: req.DeviceNumber,
+				DeviceType:   "1",
+				ProtocolType: "Template",
+				Voucher:      `{"username":"u","password":"p"}`,
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := platform.NewPlatformClient(platform.Config{
+		BaseURL:     srv.URL,
+		MQTTBroker:  "tcp://127.0.0.1:1",
+		LazyConnect: true,
+	}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// newTestHandler 创建使用临时本地存储、模拟平台和 config 中传输层的处理器；
+// 未指定 UpstreamTransport 时使用 configs/mock 下的夹具模拟小智服务端
+func newTestHandler(t testing.TB, config Config) *HTTPHandler {
+	t.Helper()
+	logger := testLogger()
+	if config.UpstreamTransport == nil {
+		mock, err := upstream.NewMockTransport(filepath.Join("..", "..", "configs", "mock"), logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.UpstreamTransport = mock
+	}
+	if config.Store == nil {
+		st, err := store.Open(filepath.Join(t.TempDir(), "plugin.db"), logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		config.Store = st
+	}
+	h, err := NewHTTPHandler(config, newTestPlatform(t), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// TestDeviceDisconnectConcurrentWithLookups 并发执行平台断开回调、设备列表回调和设备查询，
+// 校验设备缓存在按ID清理与回源写入交错时保持编号与ID一致；配合 go test -race 发现数据竞争
+func TestDeviceDisconnectConcurrentWithLookups(t *testing.T) {
+	h := newTestHandler(t, Config{})
+	// 前三个编号出现在模拟设备列表中
+	numbers := []string{"A4:CF:12:00:00:01", "A4:CF:12:00:00:02", "A4:CF:12:00:00:03", "A4:CF:12:00:00:04", "A4:CF:12:00:00:05"}
+
+	const (
+		workers = 16
+		ops     = 200
+	)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errCh := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < ops; i++ {
+				number := numbers[rnd.Intn(len(numbers))]
+				id := testDeviceID(number)
+				var err error
+				switch rnd.Intn(5) {
+				case 0: // 设备上线、WebSocket鉴权等按编号查询
+					var d *types.Device
+					if d, err = h.platform.GetDevice(number); err == nil && d.ID != id {
+						err = fmt.Errorf("设备 %s 的ID为 %s，期望 %s", number, d.ID, id)
+					}
+				case 1: // 平台断开设备，MQTT未连接时上报离线失败，只校验缓存清理
+					h.handleDeviceDisconnect(ctx, &handler.DeviceDisconnectRequest{DeviceID: id})
+				case 2:
+					var resp *handler.DeviceListResponse
+					resp, err = h.handleGetDeviceList(ctx, &handler.GetDeviceListRequest{Voucher: testVoucher, Page: 1, PageSize: 10})
+					if err == nil && len(resp.Data.List) != 3 {
+						err = fmt.Errorf("设备列表返回 %d 台，期望 3 台", len(resp.Data.List))
+					}
+				case 3: // 断开回调计数前按ID取缓存的设备类型
+					if protocolType, _ := h.cachedDeviceTypes(id); protocolType != "" && protocolType != "Template" {
+						err = fmt.Errorf("设备 %s 的协议类型为 %s", id, protocolType)
+					}
+				default:
+					if d, e := h.platform.GetDeviceByID(id); e == nil && d.DeviceNumber != number {
+						err = fmt.Errorf("设备 %s 的编号为 %s，期望 %s", id, d.DeviceNumber, number)
+					}
+				}
+				if err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+
+	for _, number := range numbers {
+		d, err := h.platform.GetDevice(number)
+		if err != nil {
+			t.Fatalf("获取设备 %s 失败: %v", number, err)
+		}
+		byID, err := h.platform.GetDeviceByID(d.ID)
+		if err != nil || byID.DeviceNumber != number {
+			t.Fatalf("设备ID索引不一致: %s -> %+v (%v)", d.ID, byID, err)
+		}
+	}
+}
+
+func TestProtocolHandlerRequestLocale(t *testing.T) {
+	h := newTestHandler(t, Config{})
+	const workers = 8
+	var wg sync.WaitGroup
+	errCh := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			lang, want := "zh", "不支持的表单类型: XYZ"
+			if w%2 == 1 {
+				lang, want = "en", "unsupported form type: XYZ"
+			}
+			for i := 0; i < 50; i++ {
+				// SDK回调不携带请求上下文，并发请求各自的语言需经复用的协议处理器传入
+				rsp := servePlatform(t, h, "/api/v1/form/config?protocol_type=ESP32&device_type=1&form_type=XYZ&lang="+lang)
+				if rsp.Message != want {
+					errCh <- fmt.Errorf("lang=%s 响应 %+v，期望 %q", lang, rsp, want)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+	if n := len(h.protocols.idle); n < 1 || n > workers {
+		t.Fatalf("空闲协议处理器 %d 个，期望 1~%d 个", n, workers)
+	}
+}
+
+func TestProtocolHandlersIdleCap(t *testing.T) {
+	h := newTestHandler(t, Config{})
+	ps := h.protocols
+	// 突发并发创建的处理器多于上限，归还后只保留上限个
+	busy := make([]*protocolHandler, 0, maxIdleProtocolHandlers+10)
+	for i := 0; i < cap(busy); i++ {
+		busy = append(busy, ps.get())
+	}
+	for _, p := range busy {
+		ps.put(p)
+	}
+	if n := len(ps.idle); n != maxIdleProtocolHandlers {
+		t.Fatalf("空闲协议处理器 %d 个，期望 %d 个", n, maxIdleProtocolHandlers)
+	}
+	// 之后的请求复用空闲处理器
+	p := ps.get()
+	if p != busy[maxIdleProtocolHandlers-1] {
+		t.Fatal("未复用空闲的协议处理器")
+	}
+	ps.put(p)
+}
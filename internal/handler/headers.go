@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+	formjson "tp-plugin/internal/form_json"
+)
+
+// UpstreamHeaders 调用小智服务端时附加的请求头，键为服务地址(与凭证 ServerURL 一致)，"*" 对全部服务地址生效
+type UpstreamHeaders map[string]map[string]string
+
+// reservedHeaders 插件自行设置的请求头，不允许被自定义请求头覆盖
+var reservedHeaders = map[string]bool{
+	"Content-Type":    true,
+	"Content-Length":  true,
+	"Host":            true,
+	"X-Token":         true,
+	idempotencyHeader: true,
+}
+
+// parseHeaders 解析凭证中的自定义请求头，每行或以分号分隔一个 "Name: Value"；
+// 格式错误或保留的请求头忽略并返回在 invalid 中
+func parseHeaders(s string) (header http.Header, invalid []string) {
+	header = make(http.Header)
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ';' }) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !validHeader(name, value) {
+			invalid = append(invalid, name)
+			continue
+		}
+		header.Set(name, value)
+	}
+	return header, invalid
+}
+
+// validHeader 请求头名称只能包含字母、数字和连字符，值不能含控制字符，且不是保留的请求头
+func validHeader(name, value string) bool {
+	if name == "" || reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
+
+// applyUpstreamHeaders 为调用小智服务端的请求附加自定义请求头：先应用配置中 "*" 和该服务地址的请求头，
+// 再应用凭证 Headers 字段中的请求头，同名时后者覆盖前者；返回附加的请求头名称，供日志记录(不记录值)
+func (h *HTTPHandler) applyUpstreamHeaders(req *http.Request, voucher formjson.Voucher) []string {
+	var names []string
+	set := func(name, value string) {
+		if !validHeader(name, value) {
+			return
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+		req.Header.Set(name, value)
+	}
+	headers := h.upstreamOptions.Load().headers
+	for _, key := range []string{"*", strings.TrimRight(voucher.ServerURL, "/")} {
+		for name, value := range headers[key] {
+			set(name, value)
+		}
+	}
+	if voucher.Headers != "" {
+		header, invalid := parseHeaders(voucher.Headers)
+		if len(invalid) > 0 {
+			h.logger.WithField("server_url", voucher.ServerURL).WithField("headers", invalid).Warn("凭证中的自定义请求头格式错误或为保留请求头，已忽略")
+		}
+		for name := range header {
+			set(name, header.Get(name))
+		}
+	}
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
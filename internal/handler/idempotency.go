@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/store"
+)
+
+// idempotencyHeader 变更类请求携带的幂等键请求头，小智服务端支持时据此去重
+const idempotencyHeader = httpclient.IdempotencyHeader
+
+// idempotentPaths 需要携带幂等键的小智服务端接口
+var idempotentPaths = map[string]bool{
+	"/device/bind":    true,
+	"/device/unbind":  true,
+	"/device/command": true,
+	"/device/config":  true,
+}
+
+// readOnlyPaths 查询类接口，以POST实现但可安全重试；其他接口既不在此处也不在 idempotentPaths 中时不重试
+var readOnlyPaths = map[string]bool{
+	"/device/list": true,
+}
+
+// 幂等键记录的保留时长及条数上限，达到上限时先淘汰过期记录，仍超出时淘汰最早的十分之一
+const (
+	idempotencyTTL     = 24 * time.Hour
+	maxIdempotencyKeys = 10000
+)
+
+// idempotencyKeys 按请求内容生成幂等键
+// 同一内容的请求在收到小智服务端的响应(5xx除外)之前使用相同的键，超时后的重试因此会被服务端去重；
+// 收到响应后再次发起相同内容的请求(如解绑后重新绑定)视为新的操作，使用新的键。
+// 已完成次数保存到本地存储，插件重启后的重试沿用重启前的键，重新绑定等新操作也不会与重启前的键重复
+type idempotencyKeys struct {
+	mu    sync.Mutex
+	done  map[string]*idempotencyEntry
+	store *store.Store // 为nil时只保存在内存中
+}
+
+type idempotencyEntry struct {
+	Count int       `json:"count"` // 该内容已完成的请求数
+	At    time.Time `json:"at"`
+}
+
+// load 从本地存储恢复未过期的记录，过期及超出上限的记录同时删除
+func (k *idempotencyKeys) load(st *store.Store) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.store = st
+	k.done = make(map[string]*idempotencyEntry)
+	if st == nil {
+		return nil
+	}
+	now := time.Now()
+	var stale []string
+	err := st.ForEach(store.BucketIdempotency, func(content string, data []byte) error {
+		var e idempotencyEntry
+		if json.Unmarshal(data, &e) != nil || now.Sub(e.At) > idempotencyTTL {
+			stale = append(stale, content)
+			return nil
+		}
+		k.done[content] = &e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(k.done) >= maxIdempotencyKeys {
+		stale = append(stale, k.evict(now)...)
+	}
+	return st.DeleteMany(store.BucketIdempotency, stale)
+}
+
+// key 返回请求内容摘要及本次请求的幂等键
+func (k *idempotencyKeys) key(path string, body []byte) (content, key string) {
+	h := sha256.New()
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	content = hex.EncodeToString(h.Sum(nil))
+
+	k.mu.Lock()
+	count := 0
+	if e, ok := k.done[content]; ok {
+		count = e.Count
+	}
+	k.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(content + "/" + strconv.Itoa(count)))
+	return content, hex.EncodeToString(sum[:16])
+}
+
+// complete 记录该内容的请求已被服务端处理
+func (k *idempotencyKeys) complete(content string) error {
+	now := time.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.done == nil {
+		k.done = make(map[string]*idempotencyEntry)
+	}
+	var evicted []string
+	e, ok := k.done[content]
+	if !ok {
+		if len(k.done) >= maxIdempotencyKeys {
+			evicted = k.evict(now)
+		}
+		e = &idempotencyEntry{}
+		k.done[content] = e
+	}
+	e.Count++
+	e.At = now
+	if k.store == nil {
+		return nil
+	}
+	if err := k.store.DeleteMany(store.BucketIdempotency, evicted); err != nil {
+		return err
+	}
+	return k.store.Put(store.BucketIdempotency, content, e)
+}
+
+// evict 淘汰过期记录，仍达到上限时按完成时间淘汰最早的十分之一，返回淘汰的内容摘要；调用方持有锁
+func (k *idempotencyKeys) evict(now time.Time) []string {
+	var evicted []string
+	for c, e := range k.done {
+		if now.Sub(e.At) > idempotencyTTL {
+			delete(k.done, c)
+			evicted = append(evicted, c)
+		}
+	}
+	if len(k.done) < maxIdempotencyKeys {
+		return evicted
+	}
+	contents := make([]string, 0, len(k.done))
+	for c := range k.done {
+		contents = append(contents, c)
+	}
+	sort.Slice(contents, func(i, j int) bool { return k.done[contents[i]].At.Before(k.done[contents[j]].At) })
+	for _, c := range contents[:len(contents)-maxIdempotencyKeys*9/10] {
+		delete(k.done, c)
+		evicted = append(evicted, c)
+	}
+	return evicted
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/store"
+)
+
+func openTestStore(t *testing.T, path string) *store.Store {
+	t.Helper()
+	st, err := store.Open(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestIdempotencyKeySurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.db")
+	body := []byte(`{"device_number":"A4:CF:12:00:00:01"}`)
+
+	st := openTestStore(t, path)
+	var before idempotencyKeys
+	if err := before.load(st); err != nil {
+		t.Fatal(err)
+	}
+	_, first := before.key("/device/bind", body)
+	content, _ := before.key("/device/bind", body)
+	if err := before.complete(content); err != nil {
+		t.Fatal(err)
+	}
+	_, second := before.key("/device/bind", body)
+	if first == second {
+		t.Fatal("请求完成后相同内容的键未变化")
+	}
+	st.Close()
+
+	// 重启后沿用已完成次数：重试使用同一键，已完成的内容不会回到第一次的键
+	st = openTestStore(t, path)
+	defer st.Close()
+	var after idempotencyKeys
+	if err := after.load(st); err != nil {
+		t.Fatal(err)
+	}
+	if _, key := after.key("/device/bind", body); key != second {
+		t.Fatalf("重启后的键 %s，期望 %s", key, second)
+	}
+	if _, key := after.key("/device/unbind", body); key == first {
+		t.Fatal("不同接口的键相同")
+	}
+}
+
+func TestIdempotencyLoadDropsExpired(t *testing.T) {
+	st := openTestStore(t, filepath.Join(t.TempDir(), "plugin.db"))
+	defer st.Close()
+	old := idempotencyEntry{Count: 1, At: time.Now().Add(-idempotencyTTL - time.Minute)}
+	if err := st.Put(store.BucketIdempotency, "expired", old); err != nil {
+		t.Fatal(err)
+	}
+	var k idempotencyKeys
+	if err := k.load(st); err != nil {
+		t.Fatal(err)
+	}
+	if len(k.done) != 0 {
+		t.Fatalf("加载了过期记录 %+v", k.done)
+	}
+	if err := st.Get(store.BucketIdempotency, "expired", &old); err != store.ErrNotFound {
+		t.Fatalf("过期记录未从存储中删除: %v", err)
+	}
+}
+
+func TestIdempotencyEvictsOldest(t *testing.T) {
+	var k idempotencyKeys
+	k.load(nil)
+	now := time.Now()
+	// 全部未过期，达到上限后仍需淘汰
+	for i := 0; i < maxIdempotencyKeys; i++ {
+		k.done[fmt.Sprint("c", i)] = &idempotencyEntry{Count: 1, At: now.Add(time.Duration(i-maxIdempotencyKeys) * time.Second)}
+	}
+	if err := k.complete("new"); err != nil {
+		t.Fatal(err)
+	}
+	if len(k.done) > maxIdempotencyKeys {
+		t.Fatalf("记录数 %d 超过上限", len(k.done))
+	}
+	if _, ok := k.done["c0"]; ok {
+		t.Fatal("最早的记录未被淘汰")
+	}
+	for _, c := range []string{"new", fmt.Sprint("c", maxIdempotencyKeys-1)} {
+		if _, ok := k.done[c]; !ok {
+			t.Fatalf("记录 %s 被淘汰", c)
+		}
+	}
+}
+
+func TestUpstreamRetryClassification(t *testing.T) {
+	httpclient.Configure(httpclient.Config{Retries: 1, RetryBackoff: time.Millisecond})
+	t.Cleanup(func() { httpclient.Configure(httpclient.Config{}) })
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	keys := make(map[string]string)
+	// 每个接口第一次尝试返回502
+	srv := newUpstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts[r.URL.Path]++
+		n := attempts[r.URL.Path]
+		keys[r.URL.Path] = r.Header.Get(idempotencyHeader)
+		mu.Unlock()
+		if n == 1 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"code":0}`))
+	})
+	h := newTestHandler(t, Config{UpstreamTransport: httpclient.Retry(http.DefaultTransport)})
+	voucher := formjson.Voucher{ServerURL: srv.URL, Secret: "retry-classification"}
+	for _, path := range []string{"/device/list", "/device/config", "/device/unknown"} {
+		h.callUpstream(context.Background(), voucher, "", path, map[string]string{"device_number": "A4:CF:12:00:00:01"})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	cases := []struct {
+		path     string
+		attempts int
+		key      bool
+	}{
+		{"/device/list", 2, false},    // 查询接口
+		{"/device/config", 2, true},   // 设备配置下发是变更类调用，携带幂等键后重试
+		{"/device/unknown", 1, false}, // 未声明的接口不重试
+	}
+	for _, c := range cases {
+		if attempts[c.path] != c.attempts || (keys[c.path] != "") != c.key {
+			t.Errorf("%s 尝试 %d 次、幂等键 %q，期望尝试 %d 次", c.path, attempts[c.path], keys[c.path], c.attempts)
+		}
+	}
+}
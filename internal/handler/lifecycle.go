@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+)
+
+// 设备生命周期回调处理记录的参数
+const (
+	callbackTTL         = 24 * time.Hour // 已处理记录的保留时长，期间重复的回调不再处理
+	callbackMaxAttempts = 5              // 重启后补处理的次数上限，超过后放弃并记录警告
+	callbackSweep       = time.Hour
+)
+
+// 回调处理状态
+const (
+	callbackPending = "pending"
+	callbackDone    = "done"
+)
+
+var lifecycleCallbacks = metrics.NewCounterVec("tp_plugin_lifecycle_callbacks_total",
+	"设备生命周期回调(断开、移除、配置修改)的处理结果", "kind", "result")
+
+// callbackRecord 本地存储中的设备生命周期回调处理记录，按回调ID保存在 BucketCallbacks；
+// 开始处理前写入 pending，处理成功后改为 done 或删除，进程在处理中途退出时重启后据此补处理
+type callbackRecord struct {
+	Kind        string    `json:"kind"`                   // disconnect 或通知类型(notify_2/notify_3)
+	DeviceID    string    `json:"device_id,omitempty"`    // 断开请求的设备ID
+	MessageType string    `json:"message_type,omitempty"` // 通知类型
+	Message     string    `json:"message,omitempty"`      // 通知内容
+	State       string    `json:"state"`
+	Keep        bool      `json:"keep"` // 处理成功后保留记录用于去重
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	DoneAt      time.Time `json:"done_at,omitempty"`
+}
+
+// lifecycleNotification 需要记录处理状态的通知类型：设备配置修改、设备移除
+func lifecycleNotification(messageType string) bool {
+	return messageType == "2" || messageType == notifyDeviceUnassigned
+}
+
+// notificationCallback 通知的回调ID。平台携带 seq/timestamp 时每次回调内容不同，按内容摘要区分并保留记录去重；
+// 未携带时同一设备的多次回调内容相同(如多次修改配置)，按 类型/设备 区分且处理后删除记录，只用于重启后补处理
+func notificationCallback(req *handler.NotificationRequest) (string, callbackRecord) {
+	rec := callbackRecord{Kind: "notify_" + req.MessageType, MessageType: req.MessageType, Message: req.Message}
+	var meta notifyMeta
+	json.Unmarshal([]byte(req.Message), &meta)
+	if order, _ := notifyOrderValue(meta); order > 0 {
+		sum := sha256.Sum256([]byte(req.Message))
+		rec.Keep = true
+		return rec.Kind + "/" + hex.EncodeToString(sum[:16]), rec
+	}
+	return rec.Kind + "/" + notifyOrderKey(req, meta), rec
+}
+
+// trackCallback 在本地存储中记录回调的处理状态后执行 fn：已处理过的回调直接返回成功；
+// 处理失败时保留 pending 记录，平台未重发时由重启后的补处理完成。未配置本地存储时直接执行
+func (h *HTTPHandler) trackCallback(ctx context.Context, id string, rec callbackRecord, fn func(context.Context) error) error {
+	if h.store == nil {
+		return fn(ctx)
+	}
+	log := h.log(ctx).WithField("callback_id", id).WithField("kind", rec.Kind)
+	var prev callbackRecord
+	switch err := h.store.Get(store.BucketCallbacks, id, &prev); {
+	case err == nil && prev.State == callbackDone && time.Since(prev.DoneAt) < callbackTTL:
+		lifecycleCallbacks.WithLabelValues(rec.Kind, "duplicate").Inc()
+		log.Info("回调已处理，跳过")
+		return nil
+	case err == nil && prev.State == callbackPending:
+		rec.Attempts = prev.Attempts
+	case err != nil && !errors.Is(err, store.ErrNotFound):
+		log.WithError(err).Warn("读取回调处理记录失败")
+	}
+
+	rec.State = callbackPending
+	rec.Attempts++
+	rec.StartedAt = time.Now()
+	if err := h.store.Put(store.BucketCallbacks, id, rec); err != nil {
+		log.WithError(err).Warn("保存回调处理记录失败")
+	}
+	if err := fn(ctx); err != nil {
+		lifecycleCallbacks.WithLabelValues(rec.Kind, "error").Inc()
+		rec.Error = err.Error()
+		if err := h.store.Put(store.BucketCallbacks, id, rec); err != nil {
+			log.WithError(err).Warn("保存回调处理记录失败")
+		}
+		return err
+	}
+	lifecycleCallbacks.WithLabelValues(rec.Kind, "ok").Inc()
+	var err error
+	if rec.Keep {
+		rec.State, rec.Error, rec.DoneAt = callbackDone, "", time.Now()
+		err = h.store.Put(store.BucketCallbacks, id, rec)
+	} else {
+		err = h.store.Delete(store.BucketCallbacks, id)
+	}
+	if err != nil {
+		log.WithError(err).Warn("保存回调处理记录失败")
+	}
+	return nil
+}
+
+// resumeCallbacks 补处理上次运行时未处理完成的回调，并定期清理过期的已处理记录
+func (h *HTTPHandler) resumeCallbacks() {
+	if h.store == nil {
+		return
+	}
+	pending := make(map[string]callbackRecord)
+	h.sweepCallbacks(func(id string, rec callbackRecord) {
+		pending[id] = rec
+	})
+	ctx := context.Background()
+	for id, rec := range pending {
+		log := h.logger.WithField("callback_id", id).WithField("kind", rec.Kind).WithField("attempts", rec.Attempts)
+		if rec.Attempts >= callbackMaxAttempts {
+			lifecycleCallbacks.WithLabelValues(rec.Kind, "abandoned").Inc()
+			log.WithField("error", rec.Error).Warn("回调多次处理失败，放弃补处理")
+			h.store.Delete(store.BucketCallbacks, id)
+			continue
+		}
+		var err error
+		if rec.Kind == "disconnect" {
+			err = h.trackCallback(ctx, id, rec, func(ctx context.Context) error {
+				return h.deviceDisconnect(ctx, rec.DeviceID)
+			})
+		} else {
+			err = h.handleNotification(ctx, &handler.NotificationRequest{MessageType: rec.MessageType, Message: rec.Message})
+		}
+		if err != nil {
+			log.WithError(err).Warn("补处理回调失败")
+			continue
+		}
+		log.Info("已补处理上次未完成的回调")
+	}
+
+	ticker := time.NewTicker(callbackSweep)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.sweepCallbacks(nil)
+	}
+}
+
+// sweepCallbacks 删除过期的已处理记录，pending 不为nil时逐条返回未处理完成的记录
+func (h *HTTPHandler) sweepCallbacks(pending func(id string, rec callbackRecord)) {
+	var expired []string
+	err := h.store.ForEach(store.BucketCallbacks, func(id string, data []byte) error {
+		var rec callbackRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			expired = append(expired, id)
+			return nil
+		}
+		switch {
+		case rec.State == callbackDone && time.Since(rec.DoneAt) >= callbackTTL:
+			expired = append(expired, id)
+		case rec.State == callbackPending && pending != nil:
+			pending(id, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		h.logger.WithError(err).Warn("读取回调处理记录失败")
+		return
+	}
+	for _, id := range expired {
+		if err := h.store.Delete(store.BucketCallbacks, id); err != nil {
+			h.logger.WithError(err).WithField("callback_id", id).Warn("删除回调处理记录失败")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+)
+
+// maxListCacheEntries 设备列表缓存条数上限，超过时先清除过期条目，仍超过时全部清除
+const maxListCacheEntries = 1024
+
+var (
+	deviceListCacheLookups = metrics.NewCounterVec("tp_plugin_device_list_cache_total",
+		"设备列表缓存查询次数", "result")
+	deviceListCacheInvalidations = metrics.NewCounterVec("tp_plugin_device_list_cache_invalidations_total",
+		"设备绑定/解绑后清除的设备列表缓存条数", "reason")
+)
+
+// listCacheKey 设备列表缓存键：租户(凭证摘要)、服务标识符、校验后的分页参数及搜索关键字
+type listCacheKey struct {
+	tenant   string
+	service  string
+	page     int
+	pageSize int
+	search   string
+}
+
+type listCacheEntry struct {
+	data    handler.DeviceListData
+	expires time.Time
+}
+
+// deviceListCache 短时缓存小智服务端返回的设备列表，平台页面反复刷新时不重复请求小智服务端；
+// 缓存分页和解析后的结果，维护标注和文本规范化每次请求时处理
+type deviceListCache struct {
+	mu      sync.Mutex
+	entries map[listCacheKey]listCacheEntry
+}
+
+// get 返回未过期的缓存，设备列表为副本，调用方可直接修改
+func (c *deviceListCache) get(key listCacheKey) (handler.DeviceListData, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok || time.Now().After(e.expires) {
+		deviceListCacheLookups.WithLabelValues("miss").Inc()
+		return handler.DeviceListData{}, false
+	}
+	deviceListCacheLookups.WithLabelValues("hit").Inc()
+	data := e.data
+	data.List = append([]handler.DeviceItem(nil), e.data.List...)
+	return data, true
+}
+
+// put 缓存设备列表的副本，ttl 不大于0时不缓存
+func (c *deviceListCache) put(key listCacheKey, data handler.DeviceListData, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	data.List = append([]handler.DeviceItem(nil), data.List...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[listCacheKey]listCacheEntry)
+	}
+	if len(c.entries) >= maxListCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxListCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = listCacheEntry{data: data, expires: now.Add(ttl)}
+}
+
+// invalidate 清除租户的全部缓存，tenant 为空时清除全部；reason 为指标标签
+func (c *deviceListCache) invalidate(tenant, reason string) {
+	c.mu.Lock()
+	n := 0
+	for k := range c.entries {
+		if tenant == "" || k.tenant == tenant {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	c.mu.Unlock()
+	if n > 0 {
+		deviceListCacheInvalidations.WithLabelValues(reason).Add(float64(n))
+	}
+}
+
+// len 当前缓存条数
+func (c *deviceListCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/middleware"
+	"tp-plugin/internal/store"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+)
+
+// maxDeviceNotes 每台设备保留的备注条数，超出时丢弃最早的
+const maxDeviceNotes = 100
+
+var offlineSuppressed = metrics.NewCounterVec("tp_plugin_offline_suppressed_total",
+	"设备维护中而未上报的离线状态次数", "source")
+
+// DeviceNote 运维人员对设备的一条备注
+type DeviceNote struct {
+	Text      string    `json:"text"`
+	Author    string    `json:"author,omitempty"` // 管理接口令牌名称
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeviceMaintenance 设备的备注和维护标记，维护中的设备不上报离线状态，设备列表中带维护标注
+type DeviceMaintenance struct {
+	DeviceNumber string       `json:"device_number"`
+	Notes        []DeviceNote `json:"notes"`
+	Maintenance  bool         `json:"maintenance"`
+	Reason       string       `json:"reason,omitempty"`
+	Until        time.Time    `json:"until,omitempty"` // 维护到期时间，零值为手动解除
+	UpdatedBy    string       `json:"updated_by,omitempty"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// active 维护标记在 now 时是否有效
+func (m *DeviceMaintenance) active(now time.Time) bool {
+	return m.Maintenance && (m.Until.IsZero() || now.Before(m.Until))
+}
+
+// noteRequest 添加备注
+type noteRequest struct {
+	DeviceNumber string `json:"device_number"`
+	Text         string `json:"text"`
+}
+
+// maintenanceRequest 设置或解除维护标记
+type maintenanceRequest struct {
+	DeviceNumber string `json:"device_number"`
+	Maintenance  bool   `json:"maintenance"`
+	Reason       string `json:"reason"`
+	Minutes      int    `json:"minutes"` // 维护时长，0为手动解除
+}
+
+// deviceNotes 设备备注表，首次使用时从存储加载到内存，设备列表和离线事件只读内存
+type deviceNotes struct {
+	mu      sync.RWMutex
+	loaded  bool
+	devices map[string]*DeviceMaintenance
+}
+
+// loadNotes 首次使用时从存储加载备注表
+func (h *HTTPHandler) loadNotes() {
+	n := &h.notes
+	n.mu.RLock()
+	loaded := n.loaded
+	n.mu.RUnlock()
+	if loaded {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.loaded {
+		return
+	}
+	n.loaded = true
+	n.devices = make(map[string]*DeviceMaintenance)
+	if h.store == nil {
+		return
+	}
+	err := h.store.ForEach(store.BucketNotes, func(key string, data []byte) error {
+		var m DeviceMaintenance
+		if err := json.Unmarshal(data, &m); err != nil {
+			h.logger.WithError(err).WithField("device_number", key).Warn("解析设备备注失败")
+			return nil
+		}
+		n.devices[key] = &m
+		return nil
+	})
+	if err != nil {
+		h.logger.WithError(err).Warn("加载设备备注失败")
+	}
+}
+
+// DeviceNotes 返回设备的备注和维护标记，未设置时返回空记录
+func (h *HTTPHandler) DeviceNotes(deviceNumber string) DeviceMaintenance {
+	h.loadNotes()
+	h.notes.mu.RLock()
+	defer h.notes.mu.RUnlock()
+	if m, ok := h.notes.devices[deviceNumber]; ok {
+		c := *m
+		c.Notes = append([]DeviceNote(nil), m.Notes...)
+		return c
+	}
+	return DeviceMaintenance{DeviceNumber: deviceNumber, Notes: []DeviceNote{}}
+}
+
+// AllDeviceNotes 返回全部有备注或维护标记的设备，维护中的在前
+func (h *HTTPHandler) AllDeviceNotes() []DeviceMaintenance {
+	h.loadNotes()
+	now := time.Now()
+	h.notes.mu.RLock()
+	list := make([]DeviceMaintenance, 0, len(h.notes.devices))
+	for _, m := range h.notes.devices {
+		c := *m
+		c.Notes = append([]DeviceNote(nil), m.Notes...)
+		list = append(list, c)
+	}
+	h.notes.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if a, b := list[i].active(now), list[j].active(now); a != b {
+			return a
+		}
+		return list[i].DeviceNumber < list[j].DeviceNumber
+	})
+	return list
+}
+
+// InMaintenance 设备当前是否处于维护中，返回维护原因
+func (h *HTTPHandler) InMaintenance(deviceNumber string) (bool, string) {
+	if deviceNumber == "" {
+		return false, ""
+	}
+	h.loadNotes()
+	h.notes.mu.RLock()
+	defer h.notes.mu.RUnlock()
+	m, ok := h.notes.devices[deviceNumber]
+	if !ok || !m.active(time.Now()) {
+		return false, ""
+	}
+	return true, m.Reason
+}
+
+// updateNotes 修改设备记录并写入存储，记录的备注和维护标记都为空时删除
+func (h *HTTPHandler) updateNotes(deviceNumber, author string, update func(m *DeviceMaintenance) error) (DeviceMaintenance, error) {
+	if h.store == nil {
+		return DeviceMaintenance{}, errors.New("本地存储未启用")
+	}
+	if deviceNumber == "" {
+		return DeviceMaintenance{}, errors.New("缺少设备编号")
+	}
+	h.loadNotes()
+	h.notes.mu.Lock()
+	defer h.notes.mu.Unlock()
+	m := DeviceMaintenance{DeviceNumber: deviceNumber}
+	if prev, ok := h.notes.devices[deviceNumber]; ok {
+		m = *prev
+		m.Notes = append([]DeviceNote(nil), prev.Notes...)
+	}
+	if err := update(&m); err != nil {
+		return DeviceMaintenance{}, err
+	}
+	m.UpdatedBy = author
+	m.UpdatedAt = time.Now()
+	if len(m.Notes) == 0 && !m.Maintenance {
+		if err := h.store.Delete(store.BucketNotes, deviceNumber); err != nil {
+			return DeviceMaintenance{}, fmt.Errorf("删除设备备注失败: %v", err)
+		}
+		delete(h.notes.devices, deviceNumber)
+	} else {
+		if err := h.store.Put(store.BucketNotes, deviceNumber, m); err != nil {
+			return DeviceMaintenance{}, fmt.Errorf("保存设备备注失败: %v", err)
+		}
+		h.notes.devices[deviceNumber] = &m
+	}
+	c := m
+	c.Notes = append([]DeviceNote{}, m.Notes...)
+	return c, nil
+}
+
+// AddDeviceNote 为设备追加一条备注
+func (h *HTTPHandler) AddDeviceNote(deviceNumber, text, author string) (DeviceMaintenance, error) {
+	if text == "" {
+		return DeviceMaintenance{}, errors.New("备注内容为空")
+	}
+	return h.updateNotes(deviceNumber, author, func(m *DeviceMaintenance) error {
+		m.Notes = append(m.Notes, DeviceNote{Text: text, Author: author, CreatedAt: time.Now()})
+		if len(m.Notes) > maxDeviceNotes {
+			m.Notes = m.Notes[len(m.Notes)-maxDeviceNotes:]
+		}
+		return nil
+	})
+}
+
+// SetMaintenance 设置或解除设备的维护标记，duration 为0时需手动解除
+func (h *HTTPHandler) SetMaintenance(deviceNumber string, on bool, reason string, duration time.Duration, author string) (DeviceMaintenance, error) {
+	return h.updateNotes(deviceNumber, author, func(m *DeviceMaintenance) error {
+		m.Maintenance = on
+		m.Reason, m.Until = "", time.Time{}
+		if on {
+			m.Reason = reason
+			if duration > 0 {
+				m.Until = time.Now().Add(duration)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteDeviceNotes 删除设备的全部备注和维护标记
+func (h *HTTPHandler) DeleteDeviceNotes(deviceNumber string) error {
+	_, err := h.updateNotes(deviceNumber, "", func(m *DeviceMaintenance) error {
+		m.Notes, m.Maintenance = nil, false
+		return nil
+	})
+	return err
+}
+
+// suppressOffline 设备维护中时不上报离线状态，以免平台产生离线告警；返回true表示已抑制
+func (h *HTTPHandler) suppressOffline(ctx context.Context, deviceNumber, source string) bool {
+	on, reason := h.InMaintenance(deviceNumber)
+	if !on {
+		return false
+	}
+	offlineSuppressed.WithLabelValues(source).Inc()
+	h.log(ctx).WithField("device_number", deviceNumber).WithField("reason", reason).Info("设备维护中，不上报离线状态")
+	return true
+}
+
+// annotateMaintenance 在设备列表中为维护中的设备的描述加上维护标注
+func (h *HTTPHandler) annotateMaintenance(ctx context.Context, list []handler.DeviceItem) {
+	for i := range list {
+		on, reason := h.InMaintenance(list[i].DeviceNumber)
+		if !on {
+			continue
+		}
+		tag := i18n.Tc(ctx, "maintenance.tag")
+		if reason != "" {
+			tag = i18n.Tc(ctx, "maintenance.tag_reason", reason)
+		}
+		if list[i].Description != "" {
+			tag += " " + list[i].Description
+		}
+		list[i].Description = tag
+	}
+}
+
+// adminDeviceNotes 设备备注和维护标记
+// GET ?device_number= 查询单台，不带参数时列出全部；POST {"device_number","text"} 追加备注；
+// PUT {"device_number","maintenance","reason","minutes"} 设置或解除维护；DELETE ?device_number= 清除
+func (h *HTTPHandler) adminDeviceNotes(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: "本地存储未启用"})
+		return
+	}
+	principal, _ := middleware.PrincipalFromContext(r.Context())
+	number := r.URL.Query().Get("device_number")
+	switch r.Method {
+	case http.MethodGet:
+		if number == "" {
+			adminOK(w, r, h.AllDeviceNotes())
+			return
+		}
+		adminOK(w, r, h.DeviceNotes(number))
+	case http.MethodPost:
+		var req noteRequest
+		if !decodeAdmin(w, r, http.MethodPost, &req) {
+			return
+		}
+		m, err := h.AddDeviceNote(req.DeviceNumber, req.Text, principal.Name)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, m)
+	case http.MethodPut:
+		var req maintenanceRequest
+		if !decodeAdmin(w, r, http.MethodPut, &req) {
+			return
+		}
+		m, err := h.SetMaintenance(req.DeviceNumber, req.Maintenance, req.Reason, time.Duration(req.Minutes)*time.Minute, principal.Name)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		h.log(r.Context()).WithField("device_number", req.DeviceNumber).WithField("maintenance", req.Maintenance).WithField("by", principal.Name).Info("已修改设备维护标记")
+		adminOK(w, r, m)
+	case http.MethodDelete:
+		if number == "" {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "device_number")})
+			return
+		}
+		if err := h.DeleteDeviceNotes(number); err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
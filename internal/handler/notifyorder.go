@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+)
+
+// 通知顺序记录的保留时长及清理阈值
+const (
+	notifyOrderTTL   = 24 * time.Hour
+	notifyOrderPrune = 10000
+)
+
+// notifyMeta 通知消息中用于去重和排序的字段，平台未携带 seq/timestamp 时按消息内容去重
+type notifyMeta struct {
+	Voucher      string          `json:"voucher"`
+	DeviceID     string          `json:"device_id"`
+	DeviceNumber string          `json:"device_number"`
+	Seq          json.Number     `json:"seq"`
+	Timestamp    json.RawMessage `json:"timestamp"` // 秒/毫秒时间戳或 RFC3339 字符串
+}
+
+// notifyMark 某个通知来源最后处理的消息
+type notifyMark struct {
+	mu     sync.Mutex // 处理期间持有，保证同一来源的通知按序处理
+	order  int64      // 最后处理的序号或时间戳(纳秒)，0表示未携带
+	digest [32]byte
+	seen   time.Time
+}
+
+// notifyOrder 按 凭证/通知类型/设备 记录最后处理的通知，丢弃重复、乱序和过期的通知，
+// 避免后台并发处理时旧的配置修改覆盖新的状态；记录只保存在内存中，重启后重新开始
+type notifyOrder struct {
+	mu    sync.Mutex
+	marks map[string]*notifyMark
+}
+
+// notifyOrderKey 通知来源：同一凭证下同类型的通知，设备相关的通知再按设备区分
+func notifyOrderKey(req *handler.NotificationRequest, meta notifyMeta) string {
+	key := formjson.VoucherKey(meta.Voucher) + "/" + req.MessageType
+	if meta.DeviceNumber != "" {
+		return key + "/" + meta.DeviceNumber
+	}
+	return key + "/" + meta.DeviceID
+}
+
+// notifyOrderValue 通知的先后顺序：优先使用 seq，其次 timestamp；都未携带时返回0
+func notifyOrderValue(meta notifyMeta) (int64, time.Time) {
+	if n, err := meta.Seq.Int64(); err == nil && n > 0 {
+		return n, time.Time{}
+	}
+	ts := parseNotifyTime(meta.Timestamp)
+	if ts.IsZero() {
+		return 0, ts
+	}
+	return ts.UnixNano(), ts
+}
+
+// parseNotifyTime 解析数字(秒或毫秒)或 RFC3339 字符串形式的时间戳
+func parseNotifyTime(raw json.RawMessage) time.Time {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+		raw = json.RawMessage(s)
+	}
+	n, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+	if n > 1e12 {
+		return time.UnixMilli(n)
+	}
+	return time.Unix(n, 0)
+}
+
+// acquire 判断通知是否需要处理。需要处理时锁定该来源并返回 commit，处理结束后必须调用：
+// 处理成功时 commit(true) 记录该通知，失败时 commit(false) 不记录，平台重发时可再次处理。
+// 不需要处理时返回丢弃原因(duplicate/stale/expired)
+func (o *notifyOrder) acquire(req *handler.NotificationRequest, maxAge time.Duration) (commit func(ok bool), reason string) {
+	var meta notifyMeta
+	json.Unmarshal([]byte(req.Message), &meta)
+	order, ts := notifyOrderValue(meta)
+	if maxAge > 0 && !ts.IsZero() && time.Since(ts) > maxAge {
+		return nil, "expired"
+	}
+	digest := sha256.Sum256([]byte(req.Message))
+	key := notifyOrderKey(req, meta)
+
+	now := time.Now()
+	o.mu.Lock()
+	if o.marks == nil {
+		o.marks = make(map[string]*notifyMark)
+	}
+	if len(o.marks) > notifyOrderPrune {
+		for k, m := range o.marks {
+			if now.Sub(m.seen) > notifyOrderTTL && m.mu.TryLock() {
+				delete(o.marks, k)
+				m.mu.Unlock()
+			}
+		}
+	}
+	m := o.marks[key]
+	if m == nil {
+		m = &notifyMark{}
+		o.marks[key] = m
+	}
+	m.seen = now
+	o.mu.Unlock()
+
+	m.mu.Lock()
+	switch {
+	case m.digest == digest:
+		reason = "duplicate"
+	case order > 0 && m.order > 0 && order <= m.order:
+		reason = "stale"
+	}
+	if reason != "" {
+		m.mu.Unlock()
+		return nil, reason
+	}
+	return func(ok bool) {
+		if ok {
+			if order > 0 {
+				m.order = order
+			}
+			m.digest = digest
+		}
+		m.mu.Unlock()
+	}, ""
+}
+
+// orderedNotification 按顺序处理通知，重复、乱序或过期的通知不处理，返回丢弃原因，由调用方直接应答成功
+func (h *HTTPHandler) orderedNotification(ctx context.Context, req *handler.NotificationRequest) (string, error) {
+	commit, reason := h.notifyOrder.acquire(req, time.Duration(h.notify.MaxAgeSeconds)*time.Second)
+	if reason != "" {
+		h.log(ctx).WithField("message_type", req.MessageType).WithField("reason", reason).Info("丢弃重复或过期的通知")
+		return reason, nil
+	}
+	err := h.handleNotification(ctx, req)
+	commit(err == nil)
+	return "", err
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/handler"
+)
+
+// 通知处理默认参数
+const (
+	DefaultNotifyWorkers   = 4
+	DefaultNotifyQueueSize = 1000
+	DefaultNotifyTimeout   = 30 * time.Second
+)
+
+// ErrNotifyQueueFull 通知队列已满，返回错误由平台重试
+var ErrNotifyQueueFull = errors.New("通知队列已满，请稍后重试")
+
+var (
+	notifyProcessed = metrics.NewCounterVec("tp_plugin_notifications_total",
+		"后台处理的平台通知数", "type", "result")
+	notifyQueueDepth = metrics.NewGaugeVec("tp_plugin_notify_queue_depth",
+		"等待处理的平台通知数")
+)
+
+// NotifyConfig 平台通知的处理方式：默认校验后立即应答平台，由后台工作协程处理
+type NotifyConfig struct {
+	Workers        int            // 并发处理的通知数，0使用默认值4
+	QueueSize      int            // 队列长度，0使用默认值1000；队列满时返回错误由平台重试
+	TimeoutSeconds int            // 单条通知的处理超时，0使用默认值30
+	TypeTimeouts   map[string]int // 按通知类型(message_type)覆盖处理超时(秒)
+	Sync           bool           // 在请求内同步处理，处理结果作为应答返回
+	MaxAgeSeconds  int            // 携带 timestamp 的通知超过该时长视为重放并丢弃，0不检查
+}
+
+// notifyJob 排队中的通知
+type notifyJob struct {
+	ctx context.Context
+	req handler.NotificationRequest
+}
+
+// startNotifyWorkers 创建通知队列并启动工作协程，同步处理时不启动
+func (h *HTTPHandler) startNotifyWorkers() {
+	cfg := h.notify
+	if cfg.Sync {
+		return
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultNotifyWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultNotifyQueueSize
+	}
+	h.notifyJobs = make(chan notifyJob, cfg.QueueSize)
+	for i := 0; i < cfg.Workers; i++ {
+		go h.notifyWorker()
+	}
+}
+
+// enqueueNotification 校验通知内容后放入队列并立即返回；同步模式下直接处理
+func (h *HTTPHandler) enqueueNotification(ctx context.Context, req *handler.NotificationRequest) error {
+	if h.notifyJobs == nil {
+		_, err := h.orderedNotification(ctx, req)
+		return err
+	}
+	var msgData map[string]interface{}
+	if err := json.Unmarshal([]byte(req.Message), &msgData); err != nil {
+		h.log(ctx).WithError(err).Error(i18n.Td("notify.parse_failed"))
+		return err
+	}
+	// 请求结束后继续处理，保留请求ID和语言
+	job := notifyJob{ctx: context.WithoutCancel(ctx), req: *req}
+	h.notifyPending.Add(1)
+	select {
+	case h.notifyJobs <- job:
+		notifyQueueDepth.WithLabelValues().Set(float64(len(h.notifyJobs)))
+		return nil
+	default:
+		h.notifyPending.Done()
+		notifyProcessed.WithLabelValues(notifyTypeLabel(req.MessageType), "rejected").Inc()
+		h.log(ctx).WithField("message_type", req.MessageType).Warn(ErrNotifyQueueFull.Error())
+		return ErrNotifyQueueFull
+	}
+}
+
+func (h *HTTPHandler) notifyWorker() {
+	for job := range h.notifyJobs {
+		notifyQueueDepth.WithLabelValues().Set(float64(len(h.notifyJobs)))
+		timeout := DefaultNotifyTimeout
+		if h.notify.TimeoutSeconds > 0 {
+			timeout = time.Duration(h.notify.TimeoutSeconds) * time.Second
+		}
+		if s, ok := h.notify.TypeTimeouts[job.req.MessageType]; ok && s > 0 {
+			timeout = time.Duration(s) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(job.ctx, timeout)
+		dropped, err := h.orderedNotification(ctx, &job.req)
+		result := "ok"
+		switch {
+		case dropped != "":
+			result = dropped
+		case err == nil:
+		case ctx.Err() == context.DeadlineExceeded:
+			result = "timeout"
+		default:
+			result = "error"
+		}
+		cancel()
+		notifyProcessed.WithLabelValues(notifyTypeLabel(job.req.MessageType), result).Inc()
+		if err != nil {
+			h.log(job.ctx).WithError(err).WithField("message_type", job.req.MessageType).WithField("result", result).Warn("后台处理通知失败")
+		}
+		h.notifyPending.Done()
+	}
+}
+
+// DrainNotifications 等待队列中的通知处理完成，在停止接收HTTP请求后调用；
+// ctx 结束时返回其错误，未处理的通知由平台重发
+func (h *HTTPHandler) DrainNotifications(ctx context.Context) error {
+	if h.notifyJobs == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		h.notifyPending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		h.logger.WithField("pending", len(h.notifyJobs)).Warn("等待平台通知处理超时")
+		return ctx.Err()
+	}
+}
+
+// notifyTypeLabel 通知类型标签，平台未定义的类型记为 other
+func notifyTypeLabel(t string) string {
+	switch t {
+	case "1", "2", notifyDeviceUnassigned:
+		return t
+	}
+	return "other"
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"tp-plugin/internal/audit"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/middleware"
+)
+
+const (
+	defaultOverrideMinutes = 60      // 未指定时长时状态覆盖的持续时间
+	maxOverrideBody        = 4 << 10 // 请求体上限
+)
+
+// statusOverrideRequest 手动覆盖设备上下线状态
+type statusOverrideRequest struct {
+	DeviceNumber string `json:"device_number"`
+	Online       bool   `json:"online"`
+	Reason       string `json:"reason"`
+	Minutes      int    `json:"minutes"` // 覆盖时长，0使用默认值60分钟，最长24小时
+}
+
+// adminDeviceStatus 手动覆盖设备上下线状态，用于设备已断电但平台仍显示在线等状态卡住的情况
+// GET 列出进行中的覆盖；PUT {"device_number","online","reason","minutes"} 强制上报状态并在到期前忽略相反的上下线事件；
+// DELETE ?device_number= 提前解除
+func (h *HTTPHandler) adminDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		adminOK(w, r, h.platform.StatusOverrides())
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxOverrideBody))
+		if err != nil {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", err.Error())})
+			return
+		}
+		var req statusOverrideRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", err.Error())})
+			return
+		}
+		if req.DeviceNumber == "" {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "device_number")})
+			return
+		}
+		if req.Minutes == 0 {
+			req.Minutes = defaultOverrideMinutes
+		}
+		device, err := h.platform.GetDevice(req.DeviceNumber)
+		if err != nil {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: err.Error()})
+			return
+		}
+		principal, _ := middleware.PrincipalFromContext(r.Context())
+		ov, err := h.platform.OverrideStatus(device.ID, req.Online, time.Duration(req.Minutes)*time.Minute, req.Reason, principal.Name)
+		h.recordStatusOverride(r.Context(), device.Voucher, req.DeviceNumber, body,
+			[]string{fmt.Sprintf("online=%t", req.Online), fmt.Sprintf("minutes=%d", req.Minutes)}, err)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, ov)
+	case http.MethodDelete:
+		number := r.URL.Query().Get("device_number")
+		if number == "" {
+			writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "device_number")})
+			return
+		}
+		device, err := h.platform.GetDevice(number)
+		if err != nil {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: err.Error()})
+			return
+		}
+		ov, ok := h.platform.ClearStatusOverride(device.ID)
+		if !ok {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: i18n.Tc(r.Context(), "override.not_found")})
+			return
+		}
+		h.recordStatusOverride(r.Context(), device.Voucher, number, nil, []string{"cleared"}, nil)
+		h.log(r.Context()).WithField("device_number", number).Info("已解除设备状态覆盖")
+		adminOK(w, r, ov)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
+
+// recordStatusOverride 将状态覆盖的设置和解除写入审计日志
+func (h *HTTPHandler) recordStatusOverride(ctx context.Context, voucher, deviceNumber string, body []byte, changes []string, err error) {
+	if h.auditLog == nil {
+		return
+	}
+	var v formjson.Voucher
+	json.Unmarshal([]byte(voucher), &v)
+	sum := sha256.Sum256(body)
+	rec := audit.Record{
+		Actor:        auditActor(ctx),
+		RequestID:    middleware.RequestIDFromContext(ctx),
+		ServerURL:    v.ServerURL,
+		Path:         "/admin/devices/status",
+		DeviceNumber: deviceNumber,
+		BodySHA256:   hex.EncodeToString(sum[:]),
+		Status:       http.StatusOK,
+		Changes:      changes,
+	}
+	if voucher != "" {
+		rec.Tenant = formjson.VoucherKey(voucher)
+	}
+	if err != nil {
+		rec.Status = http.StatusBadRequest
+		rec.Error = err.Error()
+	}
+	h.auditLog.Record(rec)
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"tp-plugin/internal/audit"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/pipeline"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/thingspanel"
+	"tp-plugin/internal/transform"
+	"tp-plugin/internal/upstream"
+)
+
+// 接入流水线步骤类型
+const (
+	StepBindUpstream  = "bind_upstream"  // 绑定设备到智能体，参数 agent_id、code、force
+	StepCreateDevice  = "create_device"  // 在ThingsPanel中创建设备，参数 name、device_config_id；输出变量 device_id
+	StepPushConfig    = "push_config"    // 向小智服务端下发设备初始配置，参数即配置项
+	StepWaitTelemetry = "wait_telemetry" // 等待设备在流水线启动后上报第一条遥测，超时由步骤 timeout 控制
+)
+
+// telemetryPollInterval 等待首条遥测时的检查间隔
+const telemetryPollInterval = time.Second
+
+// registerPipelineSteps 注册接入流水线的步骤执行器
+func (h *HTTPHandler) registerPipelineSteps(e *pipeline.Engine) {
+	e.Register(StepBindUpstream, h.stepBindUpstream)
+	e.Register(StepCreateDevice, h.stepCreateDevice)
+	e.Register(StepPushConfig, h.stepPushConfig)
+	e.Register(StepWaitTelemetry, h.stepWaitTelemetry)
+}
+
+func (h *HTTPHandler) stepBindUpstream(ctx context.Context, run *pipeline.Run, params map[string]string) error {
+	force, _ := strconv.ParseBool(params["force"])
+	return h.Bind(audit.WithActor(ctx, "pipeline:"+run.ID()), BindRequest{
+		Voucher:      run.Voucher,
+		DeviceNumber: run.DeviceNumber,
+		AgentID:      params["agent_id"],
+		Code:         params["code"],
+		Force:        force,
+	})
+}
+
+func (h *HTTPHandler) stepCreateDevice(ctx context.Context, run *pipeline.Run, params map[string]string) error {
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(run.Voucher), &voucher); err != nil {
+		return fmt.Errorf("解析凭证失败: %v", err)
+	}
+	if voucher.ThingsPanelApiURL == "" || voucher.ThingsPanelApiKey == "" {
+		return errors.New("凭证中未配置ThingsPanel开放接口")
+	}
+	name := params["name"]
+	if name == "" {
+		name = run.DeviceNumber
+	}
+	id, err := thingspanel.NewClient(voucher.ThingsPanelApiURL, voucher.ThingsPanelApiKey).CreateDevice(ctx, thingspanel.Device{
+		Name:           name,
+		DeviceNumber:   run.DeviceNumber,
+		DeviceConfigID: params["device_config_id"],
+	})
+	if err != nil {
+		return err
+	}
+	run.Set("device_id", id)
+	return nil
+}
+
+func (h *HTTPHandler) stepPushConfig(ctx context.Context, run *pipeline.Run, params map[string]string) error {
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(run.Voucher), &voucher); err != nil {
+		return fmt.Errorf("解析凭证失败: %v", err)
+	}
+	// 参数值为合法JSON(数字、布尔等)时按JSON下发，否则按字符串下发；值为空的配置项不下发
+	config := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if v == "" {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(v), &value); err != nil {
+			value = v
+		}
+		config[k] = value
+	}
+	return h.PushConfig(ctx, voucher, run.Voucher, run.DeviceNumber, config)
+}
+
+// PushConfig 经小智服务端向设备下发配置，配置项先经下行转换插件处理
+func (h *HTTPHandler) PushConfig(ctx context.Context, voucher formjson.Voucher, rawVoucher, deviceNumber string, config map[string]interface{}) error {
+	config, err := h.transforms.ApplyPassthrough(formjson.VoucherKey(rawVoucher), transform.Downlink, deviceNumber, config, h.binary)
+	if err != nil {
+		return err
+	}
+	body, err := h.callUpstream(ctx, voucher, rawVoucher, "/device/config", upstream.DeviceConfigRequest{
+		DeviceNumber: deviceNumber,
+		Config:       config,
+		Voucher:      rawVoucher,
+	})
+	if err != nil {
+		return err
+	}
+	defer bufpool.Put(body)
+	var resp upstream.CommonResponse
+	if err := json.Unmarshal(body.Bytes(), &resp); err != nil {
+		return fmt.Errorf("解析响应数据失败: %v", err)
+	}
+	return upstreamCodeError(ctx, resp.Code, resp.Msg)
+}
+
+func (h *HTTPHandler) stepWaitTelemetry(ctx context.Context, run *pipeline.Run, params map[string]string) error {
+	ticker := time.NewTicker(telemetryPollInterval)
+	defer ticker.Stop()
+	for {
+		deviceID := run.Get("device_id")
+		if deviceID == "" {
+			if device, err := h.platform.GetDevice(run.DeviceNumber); err == nil {
+				deviceID = device.ID
+			}
+		}
+		if deviceID != "" {
+			if last, ok := h.platform.LastTelemetry(deviceID); ok && last.After(run.StartedAt) {
+				run.Set("first_telemetry_at", last.Format(time.RFC3339))
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("等待设备上报遥测超时")
+		case <-ticker.C:
+		}
+	}
+}
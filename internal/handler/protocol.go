@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 插件协议版本
+const (
+	ProtocolV1      = "v1" // tp-protocol-sdk-go handler 协议(/api/v1/...)
+	DefaultProtocol = ProtocolV1
+)
+
+// protocolAdapter 将平台某一版本的插件协议请求转换为插件内部处理函数调用
+// 返回的 http.Handler 创建后复用，内部处理函数所需的请求上下文通过 ctx 取得
+type protocolAdapter func(h *HTTPHandler, ctx func() context.Context) http.Handler
+
+// protocolAdapters 已支持的协议版本
+// 平台发布新版插件协议(SDK升级)后，在此注册新版本的适配器即可，内部处理函数保持不变
+var protocolAdapters = map[string]protocolAdapter{
+	ProtocolV1: func(h *HTTPHandler, ctx func() context.Context) http.Handler {
+		return h.protocolV1(ctx)
+	},
+}
+
+// protocolHandler 协议处理器及其正在服务的请求上下文
+// SDK回调的参数不携带请求上下文，回调从所属的 protocolHandler 取当前请求的上下文(语言、请求ID)
+type protocolHandler struct {
+	http.Handler
+	ctx context.Context
+}
+
+func (p *protocolHandler) context() context.Context {
+	return p.ctx
+}
+
+// maxIdleProtocolHandlers 保留的空闲协议处理器上限，突发并发过后多余的处理器交给GC回收
+const maxIdleProtocolHandlers = 32
+
+// protocolHandlers 复用已创建的协议处理器，每个处理器同一时间只服务一个请求；
+// 并发请求多于空闲处理器时才创建新的，空闲处理器最多保留 maxIdleProtocolHandlers 个
+type protocolHandlers struct {
+	mu      sync.Mutex
+	idle    []*protocolHandler
+	adapter protocolAdapter
+	h       *HTTPHandler
+}
+
+// newProtocolHandlers 创建协议处理器集合，并立即构建一个处理器
+func newProtocolHandlers(h *HTTPHandler, adapter protocolAdapter) *protocolHandlers {
+	ps := &protocolHandlers{adapter: adapter, h: h}
+	ps.put(ps.build())
+	return ps
+}
+
+func (ps *protocolHandlers) build() *protocolHandler {
+	p := &protocolHandler{}
+	p.Handler = ps.adapter(ps.h, p.context)
+	return p
+}
+
+// serve 取一个空闲的处理器处理请求，ctx 为回调使用的请求上下文
+func (ps *protocolHandlers) serve(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p := ps.get()
+	p.ctx = ctx
+	defer func() {
+		p.ctx = nil
+		ps.put(p)
+	}()
+	p.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (ps *protocolHandlers) get() *protocolHandler {
+	ps.mu.Lock()
+	if n := len(ps.idle); n > 0 {
+		p := ps.idle[n-1]
+		ps.idle = ps.idle[:n-1]
+		ps.mu.Unlock()
+		return p
+	}
+	ps.mu.Unlock()
+	return ps.build()
+}
+
+// put 归还处理器，空闲处理器已达上限时丢弃
+func (ps *protocolHandlers) put(p *protocolHandler) {
+	ps.mu.Lock()
+	if len(ps.idle) < maxIdleProtocolHandlers {
+		ps.idle = append(ps.idle, p)
+	}
+	ps.mu.Unlock()
+}
+
+// lookupProtocol 按配置的版本号查找协议适配器，为空时使用默认版本
+func lookupProtocol(version string) (protocolAdapter, string, error) {
+	version = strings.ToLower(strings.TrimSpace(version))
+	if version == "" {
+		version = DefaultProtocol
+	}
+	adapter, ok := protocolAdapters[version]
+	if !ok {
+		return nil, "", fmt.Errorf("不支持的插件协议版本: %s, 可选: %s", version, strings.Join(SupportedProtocols(), ","))
+	}
+	return adapter, version, nil
+}
+
+// SupportedProtocols 返回已支持的协议版本
+func SupportedProtocols() []string {
+	versions := make([]string, 0, len(protocolAdapters))
+	for v := range protocolAdapters {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
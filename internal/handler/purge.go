@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"tp-plugin/internal/audit"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/middleware"
+	"tp-plugin/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+var devicePurges = metrics.NewCounterVec("tp_plugin_device_purges_total",
+	"设备数据清除(隐私删除请求)次数", "result")
+
+// purgeRetained 清除设备数据时按规定保留的数据及原因
+var purgeRetained = map[string]string{
+	"audit": "审计日志为签名链，删除任意一条都会使校验失败；记录只含设备编号和请求摘要，不含遥测和凭证",
+	"logs":  "运行日志、请求日志和抓包文件不按设备拆分，按轮转周期删除",
+}
+
+// PurgeResult 设备数据清除结果
+type PurgeResult struct {
+	DeviceNumber string            `json:"device_number"`
+	DeviceID     string            `json:"device_id,omitempty"`
+	Purged       map[string]int    `json:"purged"`   // 数据类别 -> 清除的条数，没有数据的类别不返回
+	Retained     map[string]string `json:"retained"` // 保留的数据类别及原因
+	Errors       []string          `json:"errors,omitempty"`
+	Event        bool              `json:"event"` // 是否已向平台发布清除确认事件
+	PurgedAt     time.Time         `json:"purged_at"`
+}
+
+// PurgeDevice 清除插件为设备保存的全部本地数据：设备影子、备注和维护标记、编号归属、离线排队消息、
+// 生命周期回调记录、追踪文件、心跳跟踪，以及平台客户端中的设备缓存、遥测快照和统计状态。
+// deviceID 为空时按设备编号向平台查询，设备已从平台删除时只清除按编号保存的数据。
+// 全部清除成功且已知设备ID时发布确认事件 device_data_purged，审计日志中记录本次清除
+func (h *HTTPHandler) PurgeDevice(ctx context.Context, deviceNumber, deviceID string) (PurgeResult, error) {
+	if deviceNumber == "" {
+		return PurgeResult{}, errors.New("缺少设备编号")
+	}
+	log := h.log(ctx).WithField("device_number", deviceNumber)
+	if deviceID == "" {
+		if device, err := h.platform.GetDevice(deviceNumber); err == nil {
+			deviceID = device.ID
+		} else {
+			log.WithError(err).Warn("查询设备ID失败，只清除按设备编号保存的数据")
+		}
+	}
+	res := PurgeResult{
+		DeviceNumber: deviceNumber,
+		DeviceID:     deviceID,
+		Purged:       h.platform.PurgeDevice(deviceID, deviceNumber),
+		Retained:     purgeRetained,
+	}
+	fail := func(kind string, err error) {
+		res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", kind, err))
+	}
+
+	if h.store != nil {
+		var shadow json.RawMessage
+		if h.store.Get(store.BucketShadows, deviceNumber, &shadow) == nil {
+			if err := h.DeleteShadow(deviceNumber); err != nil {
+				fail("shadow", err)
+			} else {
+				res.Purged["shadow"] = 1
+			}
+		}
+		if m := h.DeviceNotes(deviceNumber); len(m.Notes) > 0 || m.Maintenance {
+			if err := h.DeleteDeviceNotes(deviceNumber); err != nil {
+				fail("notes", err)
+			} else {
+				res.Purged["notes"] = len(m.Notes)
+			}
+		}
+		if n, err := h.purgeCallbacks(deviceID); err != nil {
+			fail("callbacks", err)
+		} else if n > 0 {
+			res.Purged["callbacks"] = n
+		}
+	}
+
+	key := normalizeDeviceNumber(deviceNumber)
+	h.owners.mu.Lock()
+	h.loadOwners()
+	owner := h.owners.owners[key]
+	delete(h.owners.owners, key)
+	delete(h.owners.collisions, key)
+	h.owners.mu.Unlock()
+	var tenant string
+	if owner != nil {
+		tenant = owner.Tenant
+		h.deleteOwner(key)
+		res.Purged["owner"] = 1
+	}
+
+	if n, err := h.downlink.Purge(deviceNumber); err != nil {
+		fail("outbox", err)
+	} else if n > 0 {
+		res.Purged["outbox"] = n
+	}
+	if n, err := h.tracer.Purge(deviceNumber); err != nil {
+		fail("trace", err)
+	} else if n > 0 {
+		res.Purged["trace"] = n
+	}
+	if deviceID != "" {
+		h.heartbeats.Forget(deviceID)
+	}
+	res.PurgedAt = time.Now()
+
+	categories := make([]string, 0, len(res.Purged))
+	for kind := range res.Purged {
+		categories = append(categories, kind)
+	}
+	sort.Strings(categories)
+	h.auditLog.Record(audit.Record{
+		Actor:        auditActor(ctx),
+		RequestID:    middleware.RequestIDFromContext(ctx),
+		Tenant:       tenant,
+		Path:         "device_purge",
+		DeviceNumber: deviceNumber,
+		Changes:      categories,
+		Error:        strings.Join(res.Errors, "; "),
+	})
+
+	log = log.WithFields(logrus.Fields{"device_id": deviceID, "purged": res.Purged})
+	if len(res.Errors) > 0 {
+		devicePurges.WithLabelValues("error").Inc()
+		log.WithField("errors", res.Errors).Warn("设备数据未能全部清除")
+		return res, fmt.Errorf("设备数据未能全部清除: %s", strings.Join(res.Errors, "; "))
+	}
+	devicePurges.WithLabelValues("ok").Inc()
+	if deviceID != "" {
+		err := h.platform.PublishPurged(deviceID, map[string]interface{}{
+			"device_number": deviceNumber,
+			"categories":    categories,
+			"request_id":    middleware.RequestIDFromContext(ctx),
+			"ts":            res.PurgedAt.UnixMilli(),
+		})
+		if err != nil {
+			log.WithError(err).Warn("发布设备数据清除确认事件失败")
+		} else {
+			res.Event = true
+		}
+	}
+	log.Info("设备数据已清除")
+	return res, nil
+}
+
+// purgeCallbacks 删除设备的断开回调处理记录
+func (h *HTTPHandler) purgeCallbacks(deviceID string) (int, error) {
+	if deviceID == "" {
+		return 0, nil
+	}
+	var ids []string
+	err := h.store.ForEach(store.BucketCallbacks, func(id string, data []byte) error {
+		var rec callbackRecord
+		if json.Unmarshal(data, &rec) == nil && rec.DeviceID == deviceID {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := h.store.Delete(store.BucketCallbacks, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// purgeRequest 设备数据清除请求
+type purgeRequest struct {
+	DeviceNumber string `json:"device_number"`
+	DeviceID     string `json:"device_id"` // 可选，设备已从平台删除时提供以清除按设备ID保存的数据
+}
+
+// adminDevicePurge POST /admin/devices/purge 清除设备的全部本地数据，用于终端用户的隐私删除请求
+func (h *HTTPHandler) adminDevicePurge(w http.ResponseWriter, r *http.Request) {
+	var req purgeRequest
+	if !decodeAdmin(w, r, http.MethodPost, &req) {
+		return
+	}
+	if req.DeviceNumber == "" {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "device_number")})
+		return
+	}
+	res, err := h.PurgeDevice(r.Context(), req.DeviceNumber, req.DeviceID)
+	if err != nil {
+		writeAdmin(w, http.StatusInternalServerError, adminResponse{Code: http.StatusInternalServerError, Message: err.Error(), Data: res})
+		return
+	}
+	adminOK(w, r, res)
+}
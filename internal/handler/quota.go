@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+)
+
+// CodeUpstreamThrottled 小智服务端限流(429)或配额用尽，插件暂停向该租户发请求
+const CodeUpstreamThrottled = 429
+
+// 配额退避参数
+const (
+	minThrottleBackoff = time.Second      // 429 未带 Retry-After 时的首次退避
+	maxThrottleBackoff = time.Minute      // 连续 429 时退避的上限
+	quotaLowRemaining  = 10               // 剩余配额不足该值(或上限的10%)时开始均匀放缓请求
+	maxQuotaWindow     = 24 * time.Hour   // 忽略超出该时长的配额重置时间
+	epochThreshold     = 1_000_000_000    // X-RateLimit-Reset 大于该值时按Unix时间戳处理，否则按秒数
+	quotaIdleTTL       = 10 * time.Minute // 恢复正常后保留状态的时长
+)
+
+var (
+	upstreamThrottles = metrics.NewCounterVec("tp_plugin_upstream_throttle_total",
+		"小智服务端限流/配额事件数", "reason")
+	throttledTenants = metrics.NewGaugeVec("tp_plugin_upstream_throttled_tenants",
+		"当前被限流而放缓请求的租户数")
+)
+
+// quotaState 单个租户的配额状态
+type quotaState struct {
+	blockedUntil time.Time     // 429 或配额用尽后，在此之前不发送请求
+	interval     time.Duration // 配额将尽时两次请求的最小间隔
+	next         time.Time     // 按 interval 排队的下一个可发送时间
+	resetAt      time.Time     // 配额窗口重置时间，之后恢复正常速率
+	backoff      time.Duration // 连续 429 的退避时长
+	seen         time.Time
+}
+
+func (s *quotaState) throttled(now time.Time) bool {
+	return now.Before(s.resetAt) && (now.Before(s.blockedUntil) || s.interval > 0)
+}
+
+// quotaThrottle 按租户(服务地址+密钥)跟踪小智服务端的限流与配额响应头，自适应放缓请求
+type quotaThrottle struct {
+	mu      sync.Mutex
+	tenants map[string]*quotaState
+}
+
+// quotaKey 租户标识，同一小智账号的配额共享
+func quotaKey(voucher formjson.Voucher) string {
+	return formjson.VoucherKey(voucher.ServerURL + "\n" + voucher.Secret)
+}
+
+// wait 等待到该租户允许发送请求；需等待的时间超过请求剩余时间时直接返回限流错误
+func (q *quotaThrottle) wait(ctx context.Context, key string) error {
+	now := time.Now()
+	q.mu.Lock()
+	s := q.tenants[key]
+	if s == nil || !s.throttled(now) {
+		if s != nil && now.After(s.resetAt) && s.interval > 0 {
+			s.interval = 0
+		}
+		q.mu.Unlock()
+		return nil
+	}
+	at := s.blockedUntil
+	if s.interval > 0 {
+		if s.next.Before(now) {
+			s.next = now
+		}
+		if s.next.After(at) {
+			at = s.next
+		}
+		s.next = at.Add(s.interval)
+	}
+	retryAfter := s.resetAt
+	q.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(at) {
+		return throttledError(ctx, retryAfter)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return throttledError(ctx, retryAfter)
+	case <-timer.C:
+		return nil
+	}
+}
+
+func throttledError(ctx context.Context, resetAt time.Time) error {
+	return &UpstreamError{
+		Code:    CodeUpstreamThrottled,
+		Status:  http.StatusTooManyRequests,
+		Message: upstreamMessage(ctx, CodeUpstreamThrottled),
+		Detail:  "retry after " + resetAt.Format(time.RFC3339),
+	}
+}
+
+// observe 根据响应更新租户的配额状态：429 时按 Retry-After(缺省时指数退避)暂停，
+// 带 X-RateLimit-Remaining/X-RateLimit-Reset 时在剩余配额不足时均匀分摊到重置前，用尽时暂停到重置
+func (q *quotaThrottle) observe(key string, resp *http.Response) {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.tenants == nil {
+		q.tenants = make(map[string]*quotaState)
+	}
+	s := q.tenants[key]
+	if s == nil {
+		s = &quotaState{}
+		q.tenants[key] = s
+	}
+	s.seen = now
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+		if !ok {
+			s.backoff *= 2
+			if s.backoff < minThrottleBackoff {
+				s.backoff = minThrottleBackoff
+			}
+			if s.backoff > maxThrottleBackoff {
+				s.backoff = maxThrottleBackoff
+			}
+			wait = s.backoff
+		}
+		s.blockedUntil = now.Add(wait)
+		if s.resetAt.Before(s.blockedUntil) {
+			s.resetAt = s.blockedUntil
+		}
+		upstreamThrottles.WithLabelValues("429").Inc()
+	} else {
+		s.backoff = 0
+		remaining, okRemaining := headerInt(resp.Header, "X-RateLimit-Remaining", "RateLimit-Remaining")
+		reset, okReset := parseReset(resp.Header, now)
+		if okRemaining && okReset {
+			limit, _ := headerInt(resp.Header, "X-RateLimit-Limit", "RateLimit-Limit")
+			low := int64(quotaLowRemaining)
+			if limit/10 > low {
+				low = limit / 10
+			}
+			s.resetAt = reset
+			switch {
+			case remaining <= 0:
+				s.blockedUntil = reset
+				s.interval = 0
+				upstreamThrottles.WithLabelValues("quota_exhausted").Inc()
+			case remaining < low:
+				s.interval = reset.Sub(now) / time.Duration(remaining)
+				upstreamThrottles.WithLabelValues("quota_low").Inc()
+			default:
+				s.interval = 0
+			}
+		}
+	}
+	q.updateGauge(now)
+}
+
+// updateGauge 统计被限流的租户数并清理长时间未使用的状态，调用方需持有锁
+func (q *quotaThrottle) updateGauge(now time.Time) {
+	n := 0
+	for key, s := range q.tenants {
+		if s.throttled(now) {
+			n++
+		} else if now.Sub(s.seen) > quotaIdleTTL {
+			delete(q.tenants, key)
+		}
+	}
+	throttledTenants.WithLabelValues().Set(float64(n))
+}
+
+// headerInt 读取第一个存在的整数响应头
+func headerInt(h http.Header, names ...string) (int64, bool) {
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// parseReset 解析配额重置时间，支持Unix时间戳和剩余秒数
+func parseReset(h http.Header, now time.Time) (time.Time, bool) {
+	n, ok := headerInt(h, "X-RateLimit-Reset", "RateLimit-Reset")
+	if !ok || n < 0 {
+		return time.Time{}, false
+	}
+	reset := now.Add(time.Duration(n) * time.Second)
+	if n > epochThreshold {
+		reset = time.Unix(n, 0)
+	}
+	if reset.Before(now) || reset.Sub(now) > maxQuotaWindow {
+		return time.Time{}, false
+	}
+	return reset, true
+}
+
+// parseRetryAfter 解析 Retry-After，支持秒数和HTTP日期
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
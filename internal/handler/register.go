@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"sort"
+	"tp-plugin/internal/pkg/useragent"
+	"tp-plugin/internal/platform"
+)
+
+// ServiceMetadata 汇总服务标识符对应的插件元数据：设备类型取自能力模型和设备类型专属表单，
+// 表单取自内置表单、表单目录(公共目录及 <service_identifier>/ 子目录)和管理接口保存的表单
+func (h *HTTPHandler) ServiceMetadata(serviceIdentifier string) platform.ServiceMetadata {
+	forms := h.forms.Available(serviceIdentifier)
+	if h.store != nil {
+		stored, err := h.StoredForms()
+		if err != nil {
+			h.logger.WithError(err).Warn("读取存储中的表单失败")
+		}
+		for _, f := range stored {
+			if f.ProtocolType == "" || f.ProtocolType == serviceIdentifier {
+				if types, ok := forms[f.FormType]; ok {
+					types[f.DeviceType] = true
+				}
+			}
+		}
+	}
+
+	deviceTypes := map[string]bool{}
+	for _, dt := range h.profiles.DeviceTypes() {
+		deviceTypes[dt] = true
+	}
+	caps := []platform.FormCapability{}
+	for _, formType := range []string{"CFG", "VCR", "SVCR"} {
+		c := platform.FormCapability{FormType: formType}
+		for dt := range forms[formType] {
+			if dt == "" {
+				c.Default = true
+				continue
+			}
+			c.DeviceTypes = append(c.DeviceTypes, dt)
+			deviceTypes[dt] = true
+		}
+		if !c.Default && len(c.DeviceTypes) == 0 {
+			continue
+		}
+		sort.Strings(c.DeviceTypes)
+		caps = append(caps, c)
+	}
+
+	id := useragent.Get()
+	meta := platform.ServiceMetadata{
+		ServiceIdentifier: serviceIdentifier,
+		Name:              id.Name,
+		Version:           id.Version,
+		Instance:          id.Instance,
+		DeviceTypes:       make([]string, 0, len(deviceTypes)),
+		Forms:             caps,
+	}
+	for dt := range deviceTypes {
+		meta.DeviceTypes = append(meta.DeviceTypes, dt)
+	}
+	sort.Strings(meta.DeviceTypes)
+	return meta
+}
@@ -0,0 +1,309 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"tp-plugin/internal/audit"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/middleware"
+	"tp-plugin/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+var serviceConfigChanges = metrics.NewCounterVec("tp_plugin_service_config_changes_total",
+	"服务接入点配置修改通知中实际变化的字段数", "reconnect")
+
+// reconnectFields 变化后需要重新建立与小智服务端连接的凭证字段，其余字段(描述、备注等)只记录
+var reconnectFields = map[string]bool{
+	"voucher.ServerURL": true,
+	"voucher.Secret":    true,
+	"voucher.AuthType":  true,
+}
+
+// serviceConfigMessage 服务配置修改通知的消息内容
+type serviceConfigMessage struct {
+	ServiceAccessID string `json:"service_access_id"`
+}
+
+// serviceSnapshot 保存在本地存储中的服务接入点配置，收到修改通知时与平台中的新配置对比
+type serviceSnapshot struct {
+	ServiceAccessID   string    `json:"service_access_id"`
+	ServiceIdentifier string    `json:"service_identifier"`
+	Description       string    `json:"description"`
+	Remark            string    `json:"remark"`
+	Voucher           string    `json:"voucher,omitempty"` // 只在内存中使用，存储中为 Sealed(旧版本写入的记录为明文)
+	Sealed            string    `json:"sealed,omitempty"`  // 加密的凭证原文，见 voucherRecord
+	Devices           []string  `json:"devices"`           // 设备编号，已排序
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ConfigChange 一个变化的配置字段，密钥类字段不记录值
+type ConfigChange struct {
+	Field     string `json:"field"`
+	Old       string `json:"old,omitempty"`
+	New       string `json:"new,omitempty"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+// secretField 值需要隐藏的凭证字段
+func secretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"secret", "key", "password", "token"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffServiceConfig 逐字段对比两个版本的服务接入点配置，凭证按其中的各项分别对比
+func diffServiceConfig(old, cur serviceSnapshot) []ConfigChange {
+	var changes []ConfigChange
+	add := func(field, o, n string) {
+		if o == n {
+			return
+		}
+		c := ConfigChange{Field: field, Old: o, New: n, Reconnect: reconnectFields[field]}
+		if secretField(field) {
+			c.Old, c.New = "", ""
+		}
+		changes = append(changes, c)
+	}
+	add("service_identifier", old.ServiceIdentifier, cur.ServiceIdentifier)
+	add("description", old.Description, cur.Description)
+	add("remark", old.Remark, cur.Remark)
+
+	var ov, nv map[string]interface{}
+	json.Unmarshal([]byte(old.Voucher), &ov)
+	json.Unmarshal([]byte(cur.Voucher), &nv)
+	keys := make(map[string]bool, len(ov)+len(nv))
+	for k := range ov {
+		keys[k] = true
+	}
+	for k := range nv {
+		keys[k] = true
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		var o, n string
+		if v, ok := ov[k]; ok && v != nil {
+			o = formValueText(v)
+		}
+		if v, ok := nv[k]; ok && v != nil {
+			n = formValueText(v)
+		}
+		add("voucher."+k, o, n)
+	}
+
+	added, removed := diffStrings(old.Devices, cur.Devices)
+	if len(added) > 0 || len(removed) > 0 {
+		changes = append(changes, ConfigChange{Field: "devices", Old: strings.Join(removed, ","), New: strings.Join(added, ",")})
+	}
+	return changes
+}
+
+// diffStrings 对比两个已排序的列表，返回新增和删除的项
+func diffStrings(old, cur []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(old) || j < len(cur) {
+		switch {
+		case j == len(cur) || (i < len(old) && old[i] < cur[j]):
+			removed = append(removed, old[i])
+			i++
+		case i == len(old) || cur[j] < old[i]:
+			added = append(added, cur[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return added, removed
+}
+
+// platformFields 变化后需要重新向新的ThingsPanel环境发布能力模型的凭证字段
+var platformFields = map[string]bool{
+	"voucher.ThingsPanelApiURL": true,
+	"voucher.ThingsPanelApiKey": true,
+}
+
+// handleServiceConfigChange 处理服务配置修改通知：从平台获取新配置并校验凭证，与本地快照对比，
+// 记录变化的字段后按变化刷新相关缓存，描述、备注等字段变化不影响连接
+func (h *HTTPHandler) handleServiceConfigChange(ctx context.Context, message string) error {
+	var msg serviceConfigMessage
+	if err := json.Unmarshal([]byte(message), &msg); err != nil {
+		return err
+	}
+	if msg.ServiceAccessID == "" {
+		return errors.New("服务配置修改通知缺少 service_access_id")
+	}
+	access, err := h.platform.GetServiceAccess(ctx, msg.ServiceAccessID)
+	if err != nil {
+		h.log(ctx).WithError(err).WithField("service_access_id", msg.ServiceAccessID).Error("获取服务接入点配置失败")
+		return err
+	}
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(access.Voucher), &voucher); err != nil || voucher.ServerURL == "" {
+		h.log(ctx).WithError(err).WithField("service_access_id", msg.ServiceAccessID).Error(i18n.Td("voucher.parse_failed"))
+		return fmt.Errorf("服务接入点 %s 的凭证无效", msg.ServiceAccessID)
+	}
+	cur := serviceSnapshot{
+		ServiceAccessID:   msg.ServiceAccessID,
+		ServiceIdentifier: access.ServiceIdentifier,
+		Description:       access.Description,
+		Remark:            access.Remark,
+		Voucher:           access.Voucher,
+		UpdatedAt:         time.Now(),
+	}
+	for _, d := range access.Devices {
+		cur.Devices = append(cur.Devices, d.DeviceNumber)
+	}
+	sort.Strings(cur.Devices)
+
+	// 没有上一版本(未启用存储或首次收到通知)时无法判断变化的字段，按全部变化刷新
+	if h.store == nil {
+		h.applyServiceConfig(ctx, nil, cur, nil)
+		return nil
+	}
+	var old serviceSnapshot
+	err = h.store.Get(store.BucketServices, msg.ServiceAccessID, &old)
+	first := errors.Is(err, store.ErrNotFound)
+	if err != nil && !first {
+		return err
+	}
+	if old.Sealed != "" {
+		raw, err := h.store.Unseal(old.Sealed)
+		if err != nil {
+			// 无法解密时按首次记录处理，全部刷新
+			h.log(ctx).WithError(err).WithField("service_access_id", msg.ServiceAccessID).Warn("解密服务接入点配置失败")
+			first = true
+		}
+		old.Voucher = string(raw)
+	}
+	stored := cur
+	if stored.Sealed, err = h.store.Seal([]byte(cur.Voucher)); err != nil {
+		return err
+	}
+	stored.Voucher = ""
+	if err := h.store.Put(store.BucketServices, msg.ServiceAccessID, stored); err != nil {
+		return err
+	}
+	if first {
+		h.log(ctx).WithField("service_access_id", msg.ServiceAccessID).Info("首次记录服务接入点配置")
+		h.applyServiceConfig(ctx, nil, cur, nil)
+		return nil
+	}
+
+	changes := diffServiceConfig(old, cur)
+	if len(changes) == 0 {
+		h.log(ctx).WithField("service_access_id", msg.ServiceAccessID).Info("服务接入点配置无变化")
+		return nil
+	}
+	fields := make([]string, 0, len(changes))
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+		serviceConfigChanges.WithLabelValues(strconv.FormatBool(c.Reconnect)).Inc()
+		h.log(ctx).WithFields(logrus.Fields{
+			"service_access_id": msg.ServiceAccessID,
+			"field":             c.Field,
+			"old":               c.Old,
+			"new":               c.New,
+			"reconnect":         c.Reconnect,
+		}).Info("服务接入点配置已修改")
+	}
+	h.recordServiceChange(ctx, cur, fields)
+	h.applyServiceConfig(ctx, &old, cur, changes)
+	return nil
+}
+
+// applyServiceConfig 按变化的字段刷新插件中与该服务接入点相关的缓存，old 为nil时全部刷新：
+//   - 服务地址、密钥、鉴权方式变化：丢弃会话令牌，之后的调用使用新凭证重新建立连接
+//   - 凭证内容变化：以新凭证替换记录的租户，设备编号归属转到新凭证
+//   - ThingsPanel接口地址或密钥变化：向新环境发布设备能力模型
+//   - 凭证或设备变化：清除该接入点新旧设备的平台设备缓存
+//
+// 平台MQTT连接由插件配置(platform.mqtt_broker)决定，为全部租户共用，服务配置修改不影响MQTT连接
+func (h *HTTPHandler) applyServiceConfig(ctx context.Context, old *serviceSnapshot, cur serviceSnapshot, changes []ConfigChange) {
+	var nv formjson.Voucher
+	json.Unmarshal([]byte(cur.Voucher), &nv)
+	reconnect, republish, devices := old == nil, old == nil, old == nil
+	for _, c := range changes {
+		reconnect = reconnect || c.Reconnect
+		republish = republish || platformFields[c.Field]
+		devices = devices || c.Field == "devices" || strings.HasPrefix(c.Field, "voucher.")
+	}
+	log := h.log(ctx).WithField("service_access_id", cur.ServiceAccessID)
+
+	if reconnect {
+		h.sessions.drop(quotaKey(nv))
+	}
+	if old != nil && old.Voucher != cur.Voucher {
+		var ov formjson.Voucher
+		json.Unmarshal([]byte(old.Voucher), &ov)
+		h.sessions.drop(quotaKey(ov))
+		if h.store != nil {
+			if err := h.store.Delete(store.BucketVouchers, formjson.VoucherKey(old.Voucher)); err != nil {
+				log.WithError(err).Warn("删除旧的服务接入点凭证失败")
+			}
+		}
+		if n := h.transferOwners(old.Voucher, cur.Voucher); n > 0 {
+			log.WithField("devices", n).Info("设备编号归属已转到新凭证")
+		}
+	}
+	h.rememberVoucher(ctx, cur.Voucher, cur.ServiceIdentifier)
+	if republish {
+		h.profiles.EnsurePublishedAsync(nv.ThingsPanelApiURL, nv.ThingsPanelApiKey)
+	}
+	cleared := 0
+	if devices {
+		numbers := cur.Devices
+		if old != nil {
+			numbers = append(append([]string(nil), old.Devices...), cur.Devices...)
+		}
+		for _, number := range numbers {
+			h.platform.ClearDeviceCache(number)
+		}
+		cleared = len(numbers)
+	}
+	log.WithFields(logrus.Fields{
+		"server_url":      nv.ServerURL,
+		"reconnect":       reconnect,
+		"republish":       republish,
+		"devices_cleared": cleared,
+	}).Info("服务接入点配置已生效")
+}
+
+// recordServiceChange 将配置修改写入审计日志，只记录变化的字段名和新配置摘要
+func (h *HTTPHandler) recordServiceChange(ctx context.Context, cur serviceSnapshot, fields []string) {
+	if h.auditLog == nil {
+		return
+	}
+	var voucher formjson.Voucher
+	json.Unmarshal([]byte(cur.Voucher), &voucher)
+	data, _ := json.Marshal(cur)
+	sum := sha256.Sum256(data)
+	h.auditLog.Record(audit.Record{
+		Actor:      auditActor(ctx),
+		RequestID:  middleware.RequestIDFromContext(ctx),
+		Tenant:     formjson.VoucherKey(cur.Voucher),
+		ServerURL:  voucher.ServerURL,
+		Path:       "service_config",
+		BodySHA256: hex.EncodeToString(sum[:]),
+		Changes:    fields,
+	})
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/pkg/useragent"
+	"tp-plugin/internal/upstream"
+)
+
+// AuthTypeSession 凭证 AuthType 取该值时，先以密钥调用 /auth/login 换取会话令牌，再以令牌调用其他接口
+const AuthTypeSession = "session"
+
+// 会话令牌参数
+const (
+	sessionLoginPath     = "/auth/login"
+	defaultSessionTTL    = 30 * time.Minute // 登录响应未给出有效期时使用
+	sessionRefreshBefore = time.Minute      // 提前刷新的时间，不超过有效期的10%
+)
+
+var sessionLogins = metrics.NewCounterVec("tp_plugin_upstream_session_logins_total",
+	"向小智服务端登录换取会话令牌的次数", "result")
+
+// sessionEntry 单个租户的会话令牌，mu 保证并发请求只登录一次
+type sessionEntry struct {
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+}
+
+// sessionTokens 按租户(服务地址+密钥)缓存的会话令牌
+type sessionTokens struct {
+	mu      sync.Mutex
+	entries map[string]*sessionEntry
+}
+
+func (s *sessionTokens) entry(key string) *sessionEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]*sessionEntry)
+	}
+	e := s.entries[key]
+	if e == nil {
+		e = &sessionEntry{}
+		s.entries[key] = e
+	}
+	return e
+}
+
+// invalidate 清除缓存的令牌；缓存中已是并发刷新得到的新令牌时保留
+func (s *sessionTokens) invalidate(key, token string) {
+	e := s.entry(key)
+	e.mu.Lock()
+	if e.token == token {
+		e.token = ""
+	}
+	e.mu.Unlock()
+}
+
+// drop 删除租户的会话令牌，租户的服务地址或密钥变更后旧令牌不再使用
+func (s *sessionTokens) drop(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+}
+
+// upstreamToken 返回调用小智服务端时 x-token 请求头的值：静态密钥凭证直接返回密钥，
+// 会话凭证返回缓存的令牌，临近过期时重新登录
+func (h *HTTPHandler) upstreamToken(ctx context.Context, voucher formjson.Voucher) (string, error) {
+	if voucher.AuthType != AuthTypeSession {
+		return voucher.Secret, nil
+	}
+	e := h.sessions.entry(quotaKey(voucher))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.token != "" && time.Now().Before(e.refreshAt) {
+		return e.token, nil
+	}
+	token, ttl, err := h.login(ctx, voucher)
+	if err != nil {
+		sessionLogins.WithLabelValues("error").Inc()
+		h.log(ctx).WithError(err).WithField("server_url", voucher.ServerURL).Warn("获取小智服务端会话令牌失败")
+		return "", err
+	}
+	sessionLogins.WithLabelValues("ok").Inc()
+	before := ttl / 10
+	if before > sessionRefreshBefore {
+		before = sessionRefreshBefore
+	}
+	e.token = token
+	e.refreshAt = time.Now().Add(ttl - before)
+	return token, nil
+}
+
+// login 以密钥调用 /auth/login 换取会话令牌
+func (h *HTTPHandler) login(ctx context.Context, voucher formjson.Voucher) (string, time.Duration, error) {
+	data, err := json.Marshal(upstream.AuthLoginRequest{Secret: voucher.Secret})
+	if err != nil {
+		return "", 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, voucher.ServerURL+sessionLoginPath, bytes.NewReader(data))
+	if err != nil {
+		return "", 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-token", voucher.Secret)
+	useragent.Apply(httpReq)
+	h.applyUpstreamHeaders(httpReq, voucher)
+
+	resp, err := h.upstream.Do(httpReq)
+	if err != nil {
+		return "", 0, &UpstreamError{Code: CodeUpstreamError, Message: upstreamMessage(ctx, CodeUpstreamError), Detail: err.Error()}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUpstreamBody))
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", 0, upstreamStatusError(ctx, resp.StatusCode, body)
+	}
+	var result upstream.AuthLoginResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", 0, fmt.Errorf("解析登录响应失败: %v", err)
+	}
+	if err := upstreamCodeError(ctx, result.Code, result.Msg); err != nil {
+		return "", 0, err
+	}
+	if result.Data.Token == "" {
+		return "", 0, fmt.Errorf("登录响应中缺少会话令牌")
+	}
+	ttl := time.Duration(result.Data.ExpiresIn) * time.Second
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return result.Data.Token, ttl, nil
+}
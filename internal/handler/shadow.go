@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"tp-plugin/internal/store"
+)
+
+// Shadow 设备影子，保存管理员为单个设备设置的期望配置，合并到设备配置包中
+type Shadow struct {
+	DeviceNumber string                 `json:"device_number"`
+	Desired      map[string]interface{} `json:"desired"`
+	Version      int                    `json:"version"` // 每次修改递增
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// GetShadow 读取设备影子，未设置时返回版本为0的空影子
+func (h *HTTPHandler) GetShadow(deviceNumber string) (Shadow, error) {
+	shadow := Shadow{DeviceNumber: deviceNumber}
+	if h.store == nil {
+		return shadow, nil
+	}
+	err := h.store.Get(store.BucketShadows, deviceNumber, &shadow)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return shadow, fmt.Errorf("读取设备影子失败: %v", err)
+	}
+	return shadow, nil
+}
+
+// SaveShadow 保存设备影子的期望配置，merge 为 true 时与已有配置合并，否则整体替换
+func (h *HTTPHandler) SaveShadow(deviceNumber string, desired map[string]interface{}, merge bool) (Shadow, error) {
+	if h.store == nil {
+		return Shadow{}, errors.New("本地存储未启用")
+	}
+	if deviceNumber == "" {
+		return Shadow{}, errors.New("缺少设备编号")
+	}
+	shadow, err := h.GetShadow(deviceNumber)
+	if err != nil {
+		return Shadow{}, err
+	}
+	if merge {
+		desired = mergeConfig(mergeConfig(nil, shadow.Desired), desired)
+	}
+	shadow.Desired = desired
+	shadow.Version++
+	shadow.UpdatedAt = time.Now()
+	if err := h.store.Put(store.BucketShadows, deviceNumber, shadow); err != nil {
+		return Shadow{}, fmt.Errorf("保存设备影子失败: %v", err)
+	}
+	return shadow, nil
+}
+
+// DeleteShadow 删除设备影子
+func (h *HTTPHandler) DeleteShadow(deviceNumber string) error {
+	if h.store == nil {
+		return errors.New("本地存储未启用")
+	}
+	return h.store.Delete(store.BucketShadows, deviceNumber)
+}
+
+// mergeConfig 将 src 深度合并到 dst，同名的对象逐层合并，其余值以 src 为准；src 中的 nil 删除该项
+func mergeConfig(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{}, len(src))
+	}
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			prev, _ := dst[k].(map[string]interface{})
+			dst[k] = mergeConfig(mergeConfig(nil, prev), sub)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
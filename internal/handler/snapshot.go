@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/platform"
+)
+
+// replayRequest 重放设备遥测快照
+type replayRequest struct {
+	DeviceNumbers []string `json:"device_numbers"` // 为空时重放全部设备
+	Rate          int      `json:"rate"`           // 每秒发布的设备数，0使用默认值
+}
+
+// deviceIDs 将设备编号解析为平台设备ID
+func (h *HTTPHandler) deviceIDs(numbers []string) ([]string, error) {
+	ids := make([]string, 0, len(numbers))
+	for _, number := range numbers {
+		device, err := h.platform.GetDevice(number)
+		if err != nil {
+			return nil, fmt.Errorf("获取设备 %s 失败: %v", number, err)
+		}
+		ids = append(ids, device.ID)
+	}
+	return ids, nil
+}
+
+// adminSnapshots 设备遥测快照：GET ?device_number= 查询单台，不带参数时列出全部
+func (h *HTTPHandler) adminSnapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		decodeAdmin(w, r, http.MethodGet, nil)
+		return
+	}
+	number := r.URL.Query().Get("device_number")
+	if number == "" {
+		adminOK(w, r, h.platform.Snapshots(nil))
+		return
+	}
+	ids, err := h.deviceIDs([]string{number})
+	if err != nil {
+		writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: err.Error()})
+		return
+	}
+	snapshot, ok := h.platform.Snapshot(ids[0])
+	if !ok {
+		writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+		return
+	}
+	adminOK(w, r, snapshot)
+}
+
+// adminSnapshotReplay 将设备最近的遥测快照重新发布到平台
+// POST {"device_numbers", "rate"} 异步启动，同时只能有一次重放；GET 查询进度
+func (h *HTTPHandler) adminSnapshotReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		adminOK(w, r, h.platform.ReplayStatus())
+		return
+	}
+	var req replayRequest
+	if !decodeAdmin(w, r, http.MethodPost, &req) {
+		return
+	}
+	if req.Rate < 0 {
+		writeAdmin(w, http.StatusBadRequest, adminResponse{Code: http.StatusBadRequest, Message: i18n.Tc(r.Context(), "admin.bad_request", "rate")})
+		return
+	}
+	ids, err := h.deviceIDs(req.DeviceNumbers)
+	if err != nil {
+		adminError(w, err)
+		return
+	}
+	progress, err := h.platform.ReplaySnapshots(ids, req.Rate)
+	if errors.Is(err, platform.ErrReplayRunning) {
+		writeAdmin(w, http.StatusConflict, adminResponse{Code: http.StatusConflict, Message: err.Error(), Data: progress})
+		return
+	}
+	h.log(r.Context()).WithField("count", progress.Total).Info("已启动设备遥测快照重放")
+	adminOK(w, r, progress)
+}
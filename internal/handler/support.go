@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+	"tp-plugin/internal/support"
+)
+
+// HealthSnapshot 插件运行状态快照
+type HealthSnapshot struct {
+	Platform      interface{} `json:"platform"`
+	SchemaVersion int         `json:"schema_version,omitempty"`
+	StoreError    string      `json:"store_error,omitempty"`
+	Broadcasts    int         `json:"broadcasts"`             // 进行中的广播数
+	Collisions    int         `json:"collisions"`             // 当前的设备编号冲突数
+	Dependencies  interface{} `json:"dependencies,omitempty"` // 启动依赖(MQTT连接等)的状态
+}
+
+// CacheStats 插件内各缓存的条数
+type CacheStats struct {
+	Forms           int `json:"forms"`            // 已解析的表单文件
+	IdempotencyKeys int `json:"idempotency_keys"` // 跟踪中的幂等键
+	QuotaTenants    int `json:"quota_tenants"`    // 跟踪配额的租户
+	DeviceOwners    int `json:"device_owners"`    // 已登记归属的设备编号
+	DeviceNotes     int `json:"device_notes"`     // 有备注或维护标记的设备
+	SessionTokens   int `json:"session_tokens"`   // 缓存会话令牌的租户
+	DeviceLists     int `json:"device_lists"`     // 缓存的设备列表页
+}
+
+// Health 返回平台连接、本地存储及后台任务的状态
+func (h *HTTPHandler) Health() HealthSnapshot {
+	s := HealthSnapshot{Platform: h.platform.Health()}
+	if h.store != nil {
+		if v, err := h.store.SchemaVersion(); err != nil {
+			s.StoreError = err.Error()
+		} else {
+			s.SchemaVersion = v
+		}
+	}
+	for _, b := range h.broadcasts.all() {
+		if b.FinishedAt.IsZero() {
+			s.Broadcasts++
+		}
+	}
+	s.Collisions = len(h.Collisions())
+	if deps := h.startup.Status(); len(deps) > 0 {
+		s.Dependencies = deps
+	}
+	return s
+}
+
+// CacheStats 返回各缓存的当前条数，命中率见指标
+func (h *HTTPHandler) CacheStats() CacheStats {
+	var s CacheStats
+	h.forms.mu.RLock()
+	s.Forms = len(h.forms.entries)
+	h.forms.mu.RUnlock()
+	h.idempotency.mu.Lock()
+	s.IdempotencyKeys = len(h.idempotency.done)
+	h.idempotency.mu.Unlock()
+	h.quota.mu.Lock()
+	s.QuotaTenants = len(h.quota.tenants)
+	h.quota.mu.Unlock()
+	h.owners.mu.Lock()
+	s.DeviceOwners = len(h.owners.owners)
+	h.owners.mu.Unlock()
+	h.notes.mu.RLock()
+	s.DeviceNotes = len(h.notes.devices)
+	h.notes.mu.RUnlock()
+	h.sessions.mu.Lock()
+	s.SessionTokens = len(h.sessions.entries)
+	h.sessions.mu.Unlock()
+	s.DeviceLists = h.listCache.len()
+	return s
+}
+
+// adminSupportBundle GET 下载支持包(zip)：脱敏配置、最近日志、版本信息、运行状态、缓存统计和指标
+func (h *HTTPHandler) adminSupportBundle(w http.ResponseWriter, r *http.Request) {
+	if h.support == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: "支持包未启用"})
+		return
+	}
+	if !decodeAdmin(w, r, http.MethodGet, nil) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+h.support.FileName(time.Now())+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	err := h.support.Write(w,
+		support.Section{Name: "health.json", Collect: func() (interface{}, error) { return h.Health(), nil }},
+		support.Section{Name: "caches.json", Collect: func() (interface{}, error) { return h.CacheStats(), nil }},
+		support.Section{Name: "collisions.json", Collect: func() (interface{}, error) { return h.Collisions(), nil }},
+	)
+	if err != nil {
+		h.log(r.Context()).WithError(err).Error("生成支持包失败")
+		return
+	}
+	h.log(r.Context()).Info("已生成支持包")
+}
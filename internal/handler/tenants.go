@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/thingspanel"
+	"tp-plugin/internal/upstream"
+)
+
+// voucherSaveInterval 同一凭证重复写入存储的最小间隔，避免每次拉取设备列表都写盘
+const voucherSaveInterval = time.Hour
+
+// healthConcurrency 健康检查并发探测的租户数
+const healthConcurrency = 8
+
+// voucherRecord 持久化的服务接入点凭证。存储文件会经备用实例复制和备份导出，凭证原文含密钥，只以密文保存
+type voucherRecord struct {
+	Sealed            string    `json:"sealed"`            // 加密的凭证原文，见 store.Seal
+	Voucher           string    `json:"voucher,omitempty"` // 旧版本写入的明文凭证，启动时重新加密
+	ServiceIdentifier string    `json:"service_identifier"`
+	LastSeen          time.Time `json:"last_seen"`
+}
+
+// rememberVoucher 记录平台下发的凭证，供租户健康检查等运维操作使用
+func (h *HTTPHandler) rememberVoucher(ctx context.Context, rawVoucher, serviceIdentifier string) {
+	if h.store == nil {
+		return
+	}
+	key := formjson.VoucherKey(rawVoucher)
+	now := time.Now()
+	if last, ok := h.voucherSaved.Load(key); ok && now.Sub(last.(time.Time)) < voucherSaveInterval {
+		return
+	}
+	sealed, err := h.store.Seal([]byte(rawVoucher))
+	if err != nil {
+		h.log(ctx).WithError(err).Warn("加密服务接入点凭证失败")
+		return
+	}
+	h.voucherSaved.Store(key, now)
+	record := voucherRecord{Sealed: sealed, ServiceIdentifier: serviceIdentifier, LastSeen: now}
+	if err := h.store.Put(store.BucketVouchers, key, record); err != nil {
+		h.log(ctx).WithError(err).Warn("保存服务接入点凭证失败")
+	}
+}
+
+// voucherOf 解密记录中的凭证原文
+func (h *HTTPHandler) voucherOf(rec voucherRecord) (string, error) {
+	if rec.Sealed == "" {
+		return rec.Voucher, nil
+	}
+	raw, err := h.store.Unseal(rec.Sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// sealVouchers 将旧版本明文保存的凭证改为加密保存
+func (h *HTTPHandler) sealVouchers() {
+	legacy := make(map[string]interface{})
+	h.store.ForEach(store.BucketVouchers, func(key string, data []byte) error {
+		var rec voucherRecord
+		if json.Unmarshal(data, &rec) != nil || rec.Voucher == "" {
+			return nil
+		}
+		sealed, err := h.store.Seal([]byte(rec.Voucher))
+		if err != nil {
+			return err
+		}
+		rec.Sealed, rec.Voucher = sealed, ""
+		legacy[key] = rec
+		return nil
+	})
+	if err := h.store.PutMany(store.BucketVouchers, legacy); err != nil {
+		h.logger.WithError(err).Warn("加密旧版本保存的服务接入点凭证失败")
+		return
+	}
+	if len(legacy) > 0 {
+		h.logger.WithField("count", len(legacy)).Info("已加密旧版本明文保存的服务接入点凭证")
+	}
+}
+
+// ProbeResult 单项探测结果
+type ProbeResult struct {
+	OK        bool    `json:"ok"`
+	Skipped   bool    `json:"skipped,omitempty"` // 凭证中未配置该接口
+	LatencyMs float64 `json:"latency_ms"`
+	Code      int     `json:"code,omitempty"` // 失败时的插件错误码或ThingsPanel业务码
+	Error     string  `json:"error,omitempty"`
+}
+
+// TenantHealth 一个租户(服务接入点)的健康状况
+type TenantHealth struct {
+	Tenant            string      `json:"tenant"`
+	ServerURL         string      `json:"server_url"`
+	ThingsPanelApiURL string      `json:"thingspanel_api_url,omitempty"`
+	ServiceIdentifier string      `json:"service_identifier,omitempty"`
+	LastSeen          time.Time   `json:"last_seen"`
+	Upstream          ProbeResult `json:"upstream"`
+	ThingsPanel       ProbeResult `json:"thingspanel"`
+	Breaker           string      `json:"breaker"` // 小智服务地址的熔断器状态
+}
+
+// TenantHealthReport 全部租户的健康矩阵
+type TenantHealthReport struct {
+	CheckedAt time.Time      `json:"checked_at"`
+	Total     int            `json:"total"`
+	Healthy   int            `json:"healthy"`
+	Tenants   []TenantHealth `json:"tenants"`
+	Breakers  []BreakerState `json:"breakers"` // 有连续失败记录的小智服务地址
+}
+
+// TenantHealth 并发探测所有已记录租户的小智服务端与ThingsPanel接口，异常租户排在前面
+func (h *HTTPHandler) TenantHealth(ctx context.Context) (*TenantHealthReport, error) {
+	if h.store == nil {
+		return nil, errors.New("本地存储未启用")
+	}
+	var records []TenantHealth
+	var vouchers []string
+	err := h.store.ForEach(store.BucketVouchers, func(key string, data []byte) error {
+		var rec voucherRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			h.log(ctx).WithError(err).WithField("tenant", key).Warn("解析凭证记录失败")
+			return nil
+		}
+		raw, err := h.voucherOf(rec)
+		if err != nil {
+			// 备用实例未配置与主实例相同的 store.secret_key 时无法解密，平台再次下发凭证后恢复
+			h.log(ctx).WithError(err).WithField("tenant", key).Warn("解密凭证记录失败")
+			return nil
+		}
+		records = append(records, TenantHealth{Tenant: key, ServiceIdentifier: rec.ServiceIdentifier, LastSeen: rec.LastSeen})
+		vouchers = append(vouchers, raw)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sem := make(chan struct{}, healthConcurrency)
+	var wg sync.WaitGroup
+	for i := range records {
+		wg.Add(1)
+		go func(t *TenantHealth, raw string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			h.probeTenant(ctx, t, raw)
+		}(&records[i], vouchers[i])
+	}
+	wg.Wait()
+
+	report := &TenantHealthReport{CheckedAt: time.Now(), Total: len(records), Tenants: records, Breakers: h.Breakers()}
+	for _, t := range records {
+		if t.healthy() {
+			report.Healthy++
+		}
+	}
+	sort.SliceStable(report.Tenants, func(i, j int) bool {
+		a, b := report.Tenants[i], report.Tenants[j]
+		if a.healthy() != b.healthy() {
+			return !a.healthy()
+		}
+		return a.ServerURL < b.ServerURL
+	})
+	return report, nil
+}
+
+func (t TenantHealth) healthy() bool {
+	return t.Upstream.OK && (t.ThingsPanel.OK || t.ThingsPanel.Skipped)
+}
+
+// probeTenant 同时探测小智服务端和ThingsPanel接口
+func (h *HTTPHandler) probeTenant(ctx context.Context, t *TenantHealth, rawVoucher string) {
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(rawVoucher), &voucher); err != nil {
+		t.Upstream.Error = err.Error()
+		t.ThingsPanel.Error = err.Error()
+		return
+	}
+	t.ServerURL = voucher.ServerURL
+	t.ThingsPanelApiURL = voucher.ThingsPanelApiURL
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t.Upstream = probe(func() error {
+			body, err := h.callUpstream(ctx, voucher, rawVoucher, "/device/list", upstream.DeviceListRequest{
+				Voucher:           rawVoucher,
+				ServiceIdentifier: t.ServiceIdentifier,
+				Page:              1,
+				PageSize:          1,
+			})
+			if err != nil {
+				return err
+			}
+			defer bufpool.Put(body)
+			_, err = decodeDeviceList(ctx, body.Bytes())
+			return err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		if voucher.ThingsPanelApiURL == "" || voucher.ThingsPanelApiKey == "" {
+			t.ThingsPanel = ProbeResult{Skipped: true}
+			return
+		}
+		pctx, cancel := context.WithTimeout(ctx, h.upstreamOptions.Load().timeout)
+		defer cancel()
+		t.ThingsPanel = probe(func() error {
+			return thingspanel.NewClient(voucher.ThingsPanelApiURL, voucher.ThingsPanelApiKey).Ping(pctx)
+		})
+	}()
+	wg.Wait()
+	t.Breaker = h.breakers.state(breakerHost(voucher.ServerURL)).State
+}
+
+func probe(fn func() error) ProbeResult {
+	start := time.Now()
+	err := fn()
+	r := ProbeResult{OK: err == nil, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		r.Error = err.Error()
+		var uerr *UpstreamError
+		var aerr *thingspanel.APIError
+		switch {
+		case errors.As(err, &uerr):
+			r.Code = uerr.Code
+		case errors.As(err, &aerr):
+			r.Code = aerr.Code
+		}
+	}
+	return r
+}
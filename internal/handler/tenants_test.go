@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/store"
+)
+
+const sealedVoucher = `{"server_url":"http://xiaozhi.example.com","secret":"voucher-secret","thingspanel_api_key":"api-key"}`
+
+// rawVouchers 返回凭证bucket中的原始记录
+func rawVouchers(t *testing.T, st *store.Store) map[string]string {
+	t.Helper()
+	out := make(map[string]string)
+	err := st.ForEach(store.BucketVouchers, func(key string, data []byte) error {
+		out[key] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRememberVoucherSealed(t *testing.T) {
+	h := newTestHandler(t, Config{})
+	h.rememberVoucher(context.Background(), sealedVoucher, "xiaozhi")
+
+	key := formjson.VoucherKey(sealedVoucher)
+	data, ok := rawVouchers(t, h.store)[key]
+	if !ok {
+		t.Fatal("凭证未保存")
+	}
+	for _, secret := range []string{"voucher-secret", "api-key", "xiaozhi.example.com"} {
+		if strings.Contains(data, secret) {
+			t.Fatalf("存储中的凭证记录含明文 %q: %s", secret, data)
+		}
+	}
+
+	var rec voucherRecord
+	if err := h.store.Get(store.BucketVouchers, key, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := h.voucherOf(rec); err != nil || raw != sealedVoucher {
+		t.Fatalf("解密凭证得到 %q, %v", raw, err)
+	}
+	if rec.ServiceIdentifier != "xiaozhi" {
+		t.Fatalf("服务标识符 %q", rec.ServiceIdentifier)
+	}
+}
+
+func TestSealLegacyVouchers(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "plugin.db"), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	key := formjson.VoucherKey(sealedVoucher)
+	legacy := voucherRecord{Voucher: sealedVoucher, ServiceIdentifier: "xiaozhi", LastSeen: time.Now()}
+	if err := st.Put(store.BucketVouchers, key, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestHandler(t, Config{Store: st})
+	if data := rawVouchers(t, st)[key]; strings.Contains(data, "voucher-secret") {
+		t.Fatalf("旧版本明文凭证未重新加密: %s", data)
+	}
+	var rec voucherRecord
+	if err := st.Get(store.BucketVouchers, key, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := h.voucherOf(rec); err != nil || raw != sealedVoucher {
+		t.Fatalf("解密凭证得到 %q, %v", raw, err)
+	}
+}
+
+func TestVoucherKeyMismatch(t *testing.T) {
+	h := newTestHandler(t, Config{})
+	h.rememberVoucher(context.Background(), sealedVoucher, "xiaozhi")
+	// 备用实例使用了不同的密钥：记录无法解密时跳过，不影响其他功能
+	if err := h.store.UseSecret("other"); err != nil {
+		t.Fatal(err)
+	}
+	var rec voucherRecord
+	if err := h.store.Get(store.BucketVouchers, formjson.VoucherKey(sealedVoucher), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.voucherOf(rec); err == nil {
+		t.Fatal("密钥不一致时仍能解密")
+	}
+	report, err := h.TenantHealth(context.Background())
+	if err != nil || report.Total != 0 {
+		t.Fatalf("健康检查返回 %+v, %v", report, err)
+	}
+	data, _ := json.Marshal(report)
+	if strings.Contains(string(data), "voucher-secret") {
+		t.Fatalf("健康检查结果含凭证密钥: %s", data)
+	}
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"tp-plugin/internal/middleware"
+	"tp-plugin/internal/store"
+)
+
+// tokenIDLength 令牌ID为令牌摘要的前缀，用于列出和删除
+const tokenIDLength = 12
+
+// tokenRecord 存储中的访问令牌，key 为令牌的SHA-256摘要，不保存令牌原文
+type tokenRecord struct {
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AdminToken 令牌概要
+type AdminToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Token     string    `json:"token,omitempty"` // 仅创建时返回一次
+}
+
+// tokenRequest 创建令牌的请求
+type tokenRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+}
+
+// adminScopes 写操作需要 full 的接口，其余接口写操作需要 operate、读操作需要 read
+var adminScopes = map[string]bool{
+	"/admin/forms":          true,
+	"/admin/tokens":         true,
+	"/admin/support-bundle": true,
+	"/admin/devices/purge":  true,
+}
+
+// adminReadFull 读取同样需要 full 的接口：支持包含日志和配置，令牌列表可用于枚举
+var adminReadFull = map[string]bool{
+	"/admin/tokens":         true,
+	"/admin/support-bundle": true,
+}
+
+// AdminScope 返回管理接口请求需要的权限范围，供鉴权中间件使用
+func (h *HTTPHandler) AdminScope(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, h.basePath)
+	switch {
+	case readOnly(r.Method) && !adminReadFull[path]:
+		return middleware.ScopeRead
+	case adminScopes[path]:
+		return middleware.ScopeFull
+	default:
+		return middleware.ScopeOperate
+	}
+}
+
+func readOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// LookupAdminToken 查找管理接口创建的令牌，供鉴权中间件使用
+func (h *HTTPHandler) LookupAdminToken(token string) (middleware.ScopedToken, bool) {
+	if h.store == nil {
+		return middleware.ScopedToken{}, false
+	}
+	var rec tokenRecord
+	if err := h.store.Get(store.BucketTokens, tokenDigest(token), &rec); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			h.logger.WithError(err).Warn("读取访问令牌失败")
+		}
+		return middleware.ScopedToken{}, false
+	}
+	return middleware.ScopedToken{Name: rec.Name, Scope: rec.Scope}, true
+}
+
+// CreateAdminToken 生成并保存令牌，令牌原文只在返回值中出现一次
+func (h *HTTPHandler) CreateAdminToken(name, scope, createdBy string) (*AdminToken, error) {
+	if h.store == nil {
+		return nil, errors.New("本地存储未启用")
+	}
+	if name == "" {
+		return nil, errors.New("缺少令牌名称")
+	}
+	if err := middleware.ValidScope(scope); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := "tpa_" + hex.EncodeToString(raw)
+	digest := tokenDigest(token)
+	rec := tokenRecord{Name: name, Scope: scope, CreatedBy: createdBy, CreatedAt: time.Now()}
+	if err := h.store.Put(store.BucketTokens, digest, rec); err != nil {
+		return nil, err
+	}
+	h.logger.WithField("name", name).WithField("scope", scope).WithField("created_by", createdBy).Info("已创建管理接口访问令牌")
+	return &AdminToken{ID: digest[:tokenIDLength], Name: name, Scope: scope, CreatedBy: createdBy, CreatedAt: rec.CreatedAt, Token: token}, nil
+}
+
+// AdminTokens 列出存储中的令牌，不含令牌原文
+func (h *HTTPHandler) AdminTokens() ([]AdminToken, error) {
+	if h.store == nil {
+		return nil, errors.New("本地存储未启用")
+	}
+	list := []AdminToken{}
+	err := h.store.ForEach(store.BucketTokens, func(key string, data []byte) error {
+		var rec tokenRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil
+		}
+		list = append(list, AdminToken{ID: key[:tokenIDLength], Name: rec.Name, Scope: rec.Scope, CreatedBy: rec.CreatedBy, CreatedAt: rec.CreatedAt})
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, err
+}
+
+// RevokeAdminToken 按ID删除令牌，返回是否找到
+func (h *HTTPHandler) RevokeAdminToken(id string) (bool, error) {
+	if h.store == nil {
+		return false, errors.New("本地存储未启用")
+	}
+	if len(id) < tokenIDLength {
+		return false, nil
+	}
+	var keys []string
+	err := h.store.ForEach(store.BucketTokens, func(key string, _ []byte) error {
+		if strings.HasPrefix(key, id) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if err := h.store.Delete(store.BucketTokens, key); err != nil {
+			return false, err
+		}
+	}
+	return len(keys) > 0, nil
+}
+
+// adminTokens 管理接口访问令牌
+// GET 列出；POST {"name","scope"} 创建，响应中的 token 只返回一次；DELETE ?id= 吊销
+func (h *HTTPHandler) adminTokens(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, adminResponse{Code: http.StatusServiceUnavailable, Message: "本地存储未启用"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.AdminTokens()
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, list)
+	case http.MethodPost:
+		var req tokenRequest
+		if !decodeAdmin(w, r, http.MethodPost, &req) {
+			return
+		}
+		principal, _ := middleware.PrincipalFromContext(r.Context())
+		token, err := h.CreateAdminToken(req.Name, req.Scope, principal.Name)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		adminOK(w, r, token)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		found, err := h.RevokeAdminToken(id)
+		if err != nil {
+			adminError(w, err)
+			return
+		}
+		if !found {
+			writeAdmin(w, http.StatusNotFound, adminResponse{Code: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)})
+			return
+		}
+		h.log(r.Context()).WithField("id", id).Info("已吊销管理接口访问令牌")
+		adminOK(w, r, nil)
+	default:
+		decodeAdmin(w, r, http.MethodGet, nil)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/trace"
+	"tp-plugin/internal/upstream"
+
+	"github.com/sirupsen/logrus"
+)
+
+// notifyDeviceUnassigned 设备从服务接入点移除的通知类型
+const notifyDeviceUnassigned = "3"
+
+// deviceUnassignedMessage 设备移除通知的消息内容
+// voucher 缺省时使用设备缓存中的凭证
+type deviceUnassignedMessage struct {
+	DeviceID     string `json:"device_id"`
+	DeviceNumber string `json:"device_number"`
+	Voucher      string `json:"voucher"`
+}
+
+// handleDeviceUnassigned 设备从服务接入点移除后通知小智服务端解除绑定，
+// 避免重新添加同一设备时提示设备已被绑定
+func (h *HTTPHandler) handleDeviceUnassigned(ctx context.Context, message string) error {
+	var msg deviceUnassignedMessage
+	if err := json.Unmarshal([]byte(message), &msg); err != nil {
+		h.log(ctx).WithError(err).Error(i18n.Td("notify.parse_failed"))
+		return err
+	}
+
+	if msg.DeviceID != "" {
+		if device, err := h.platform.GetDeviceByID(msg.DeviceID); err == nil {
+			if msg.DeviceNumber == "" {
+				msg.DeviceNumber = device.DeviceNumber
+			}
+			if msg.Voucher == "" {
+				msg.Voucher = device.Voucher
+			}
+		}
+		h.platform.ClearDeviceCacheByID(msg.DeviceID)
+	}
+	if msg.DeviceNumber == "" || msg.Voucher == "" {
+		return errors.New(i18n.Tc(ctx, "unbind.missing_device"))
+	}
+	h.tracer.Record(msg.DeviceNumber, trace.In, "unassigned", message)
+
+	var voucher formjson.Voucher
+	if err := json.Unmarshal([]byte(msg.Voucher), &voucher); err != nil {
+		h.log(ctx).WithError(err).Error(i18n.Td("voucher.parse_failed"))
+		return err
+	}
+
+	if err := h.unbindUpstream(ctx, voucher, msg.Voucher, msg.DeviceNumber); err != nil {
+		h.log(ctx).WithError(err).WithField("device_number", msg.DeviceNumber).Error(i18n.Td("unbind.failed"))
+		return err
+	}
+	h.releaseDevice(msg.Voucher, msg.DeviceNumber)
+	h.listCache.invalidate(formjson.VoucherKey(msg.Voucher), "unbind")
+	h.log(ctx).WithFields(logrus.Fields{
+		"device_id":     msg.DeviceID,
+		"device_number": msg.DeviceNumber,
+	}).Info(i18n.Td("unbind.success"))
+	return nil
+}
+
+// unbindUpstream 调用小智服务端 /device/unbind 释放设备绑定
+func (h *HTTPHandler) unbindUpstream(ctx context.Context, voucher formjson.Voucher, rawVoucher, deviceNumber string) error {
+	h.tracer.Record(deviceNumber, trace.Out, "upstream_unbind", deviceNumber)
+	body, err := h.callUpstream(ctx, voucher, rawVoucher, "/device/unbind", upstream.DeviceUnbindRequest{
+		DeviceNumber: deviceNumber,
+		Voucher:      rawVoucher,
+	})
+	if err != nil {
+		h.tracer.Record(deviceNumber, trace.In, "upstream_unbind_error", err.Error())
+		return err
+	}
+	defer bufpool.Put(body)
+	h.tracer.Record(deviceNumber, trace.In, "upstream_unbind_response", body.Bytes())
+
+	var resp upstream.CommonResponse
+	if err := json.Unmarshal(body.Bytes(), &resp); err != nil {
+		return &UpstreamError{Code: CodeUpstreamError, Status: http.StatusOK, Message: upstreamMessage(ctx, CodeUpstreamError), Detail: err.Error()}
+	}
+	return upstreamCodeError(ctx, resp.Code, resp.Msg)
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/i18n"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/pkg/redact"
+	"tp-plugin/internal/pkg/useragent"
+	"tp-plugin/internal/tunnel"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultUpstreamTimeout 调用小智服务端的默认超时时间
+const DefaultUpstreamTimeout = 10 * time.Second
+
+// maxUpstreamBody 响应体读取上限，防止异常或流式响应耗尽内存
+const maxUpstreamBody = 32 << 20
+
+// 小智服务端调用失败时返回给平台的错误码
+const (
+	CodeUpstreamBadRequest = 400 // 请求参数错误
+	CodeUpstreamAuth       = 401 // 凭证(Secret)无效或无权限
+	CodeUpstreamNotFound   = 404 // 设备或接口不存在
+	CodeUpstreamError      = 502 // 小智服务端内部错误或返回了无法识别的响应
+	CodeUpstreamTimeout    = 504 // 小智服务端超时
+)
+
+// UpstreamError 小智服务端调用失败
+type UpstreamError struct {
+	Code    int    // 插件错误码，见 CodeUpstream*
+	Status  int    // 小智服务端HTTP状态码，未收到响应时为0
+	Message string // 面向用户的错误信息(已按请求语言翻译)
+	Detail  string // 小智服务端返回的原始信息
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("[%d] %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("[%d] %s: %s", e.Code, e.Message, e.Detail)
+}
+
+// platformCode 返回给平台的响应码：小智服务端调用失败时为 UpstreamError.Code，其余错误与SDK一致为500
+func platformCode(err error) int {
+	var uerr *UpstreamError
+	if errors.As(err, &uerr) {
+		return uerr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// upstreamStatusError 将非2xx的HTTP状态码映射为插件错误
+func upstreamStatusError(ctx context.Context, status int, body []byte) *UpstreamError {
+	code := CodeUpstreamError
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		code = CodeUpstreamAuth
+	case status == http.StatusNotFound:
+		code = CodeUpstreamNotFound
+	case status == http.StatusGatewayTimeout || status == http.StatusRequestTimeout:
+		code = CodeUpstreamTimeout
+	case status == http.StatusTooManyRequests:
+		code = CodeUpstreamThrottled
+	case status >= 400 && status < 500:
+		code = CodeUpstreamBadRequest
+	}
+	return &UpstreamError{
+		Code:    code,
+		Status:  status,
+		Message: upstreamMessage(ctx, code),
+		Detail:  truncate(strings.TrimSpace(string(body)), 256),
+	}
+}
+
+// upstreamCodeError 校验响应体中的业务状态码，0 和 200 视为成功
+func upstreamCodeError(ctx context.Context, code int, msg string) error {
+	var mapped int
+	switch code {
+	case 0, http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		mapped = CodeUpstreamAuth
+	case http.StatusNotFound:
+		mapped = CodeUpstreamNotFound
+	case http.StatusBadRequest:
+		mapped = CodeUpstreamBadRequest
+	default:
+		mapped = CodeUpstreamError
+	}
+	return &UpstreamError{
+		Code:    mapped,
+		Status:  http.StatusOK,
+		Message: upstreamMessage(ctx, mapped),
+		Detail:  fmt.Sprintf("code=%d, msg=%s", code, msg),
+	}
+}
+
+func upstreamMessage(ctx context.Context, code int) string {
+	switch code {
+	case CodeUpstreamAuth:
+		return i18n.Tc(ctx, "upstream.auth_failed")
+	case CodeUpstreamNotFound:
+		return i18n.Tc(ctx, "upstream.not_found")
+	case CodeUpstreamTimeout:
+		return i18n.Tc(ctx, "upstream.timeout")
+	case CodeUpstreamBadRequest:
+		return i18n.Tc(ctx, "upstream.bad_request")
+	case CodeUpstreamThrottled:
+		return i18n.Tc(ctx, "upstream.throttled")
+	case CodeUpstreamUnavailable:
+		return i18n.Tc(ctx, "upstream.unavailable")
+	default:
+		return i18n.Tc(ctx, "upstream.server_error")
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// upstreamSettings 调用小智服务端的超时时间和附加的请求头
+type upstreamSettings struct {
+	timeout time.Duration
+	headers UpstreamHeaders
+}
+
+// SetUpstream 修改调用小智服务端的超时时间和附加的请求头，对之后发起的调用生效；timeout 为0时使用默认值
+func (h *HTTPHandler) SetUpstream(timeout time.Duration, headers UpstreamHeaders) {
+	if timeout <= 0 {
+		timeout = DefaultUpstreamTimeout
+	}
+	h.upstreamOptions.Store(&upstreamSettings{timeout: timeout, headers: headers})
+}
+
+// callUpstream 以 POST JSON 调用小智服务端接口，返回2xx响应的响应体(调用方需 bufpool.Put 归还)
+// 请求受 upstream.timeout 限制；非2xx响应在解析JSON前即转换为 UpstreamError。
+// 会话凭证的令牌由 upstreamToken 缓存和刷新，返回401时重新登录并重试一次。
+// rawVoucher 为凭证原文，其摘要标识租户，用于校验租户能否使用 tunnel:// 地址指向的隧道
+func (h *HTTPHandler) callUpstream(ctx context.Context, voucher formjson.Voucher, rawVoucher, path string, payload interface{}) (_ *bytes.Buffer, err error) {
+	// 凭证 ServerURL 由租户填写，指向未授权隧道的调用在熔断和限流之前拒绝，不影响隧道所属租户的熔断状态
+	tenant := formjson.VoucherKey(rawVoucher)
+	if err := h.tunnels.Authorize(voucher.ServerURL, tenant); err != nil {
+		h.log(ctx).WithField("tenant", tenant).WithField("server_url", voucher.ServerURL).Warn(err.Error())
+		return nil, &UpstreamError{Code: CodeUpstreamAuth, Message: upstreamMessage(ctx, CodeUpstreamAuth), Detail: err.Error()}
+	}
+	ctx = tunnel.WithTenant(ctx, tenant)
+
+	requestBody, err := bufpool.EncodeJSON(payload)
+	if err != nil {
+		h.log(ctx).WithError(err).Error(i18n.Td("upstream.marshal_failed"))
+		return nil, err
+	}
+	defer bufpool.Put(requestBody)
+
+	ctx, cancel := context.WithTimeout(ctx, h.upstreamOptions.Load().timeout)
+	defer cancel()
+
+	// 服务地址熔断中时直接返回，不再等待超时
+	host := breakerHost(voucher.ServerURL)
+	if err := h.breakers.allow(ctx, host); err != nil {
+		h.log(ctx).WithField("server_url", voucher.ServerURL).Warn(err.Error())
+		return nil, err
+	}
+	defer func() {
+		failed, ignored := breakerOutcome(ctx, err)
+		h.breakers.record(host, failed, ignored, fmt.Sprint(err))
+	}()
+
+	// 租户被小智服务端限流时先等待，等待超出超时时间时直接返回限流错误
+	key := quotaKey(voucher)
+	if err := h.quota.wait(ctx, key); err != nil {
+		h.log(ctx).WithField("server_url", voucher.ServerURL).Warn(err.Error())
+		return nil, err
+	}
+
+	var idempotencyContent, idempotencyKey string
+	var status int
+	switch {
+	case readOnlyPaths[path]:
+		// 查询类接口可安全重试；其他接口既无幂等键也未标记，失败时不重试
+		ctx = httpclient.Retryable(ctx)
+	case idempotentPaths[path]:
+		// 变更类接口携带幂等键后同样可重试，由服务端去重
+		idempotencyContent, idempotencyKey = h.idempotency.key(path, requestBody.Bytes())
+		// 变更类调用无论成败都写入审计日志，在归还请求体之前执行
+		defer func() {
+			h.recordAudit(ctx, voucher, path, requestBody.Bytes(), idempotencyKey, status, err)
+		}()
+	}
+
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		token, err := h.upstreamToken(ctx, voucher)
+		if err != nil {
+			return nil, err
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, voucher.ServerURL+path, bytes.NewReader(requestBody.Bytes()))
+		if err != nil {
+			h.log(ctx).WithError(err).Error(i18n.Td("upstream.request_failed"))
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("x-token", token)
+		if idempotencyKey != "" {
+			httpReq.Header.Set(idempotencyHeader, idempotencyKey)
+		}
+		useragent.Apply(httpReq)
+
+		// 将请求的request url, header, body写入日志，令牌及凭证中的密钥隐藏；自定义请求头可能含网关密钥，在记录之后附加，只记录名称
+		h.log(ctx).WithFields(logrus.Fields{
+			"url":    redact.URL(httpReq.URL),
+			"header": redact.Header(httpReq.Header),
+			"body":   redact.String(requestBody.String()),
+		}).Info(i18n.Td("upstream.sending"))
+		if names := h.applyUpstreamHeaders(httpReq, voucher); len(names) > 0 {
+			h.log(ctx).WithField("extra_headers", names).Debug("已附加自定义请求头")
+		}
+
+		resp, err = h.upstream.Do(httpReq)
+		if err != nil {
+			h.log(ctx).WithError(err).Error(i18n.Td("upstream.call_failed"))
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, &UpstreamError{Code: CodeUpstreamTimeout, Message: upstreamMessage(ctx, CodeUpstreamTimeout), Detail: err.Error()}
+			}
+			return nil, &UpstreamError{Code: CodeUpstreamError, Message: upstreamMessage(ctx, CodeUpstreamError), Detail: err.Error()}
+		}
+		// 会话令牌被服务端提前作废时清除缓存重新登录，只重试一次
+		if resp.StatusCode == http.StatusUnauthorized && voucher.AuthType == AuthTypeSession && attempt == 0 {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxUpstreamBody))
+			resp.Body.Close()
+			h.sessions.invalidate(quotaKey(voucher), token)
+			continue
+		}
+		break
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	h.quota.observe(key, resp)
+	// 限流(429)及5xx响应视为未处理，重试时沿用同一幂等键
+	if idempotencyContent != "" && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		if err := h.idempotency.complete(idempotencyContent); err != nil {
+			h.log(ctx).WithError(err).Warn("保存幂等键记录失败")
+		}
+	}
+
+	// 读取响应体
+	body, err := readBody(io.LimitReader(resp.Body, maxUpstreamBody))
+	if err != nil {
+		h.log(ctx).WithError(err).Error(i18n.Td("upstream.read_failed"))
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, &UpstreamError{Code: CodeUpstreamTimeout, Status: resp.StatusCode, Message: upstreamMessage(ctx, CodeUpstreamTimeout), Detail: err.Error()}
+		}
+		return nil, err
+	}
+
+	// 将接口返回的信息写入日志
+	h.log(ctx).WithFields(logrus.Fields{
+		"status_code": resp.StatusCode,
+		"body":        redact.String(body.String()),
+	}).Info(i18n.Td("upstream.response"))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		uerr := upstreamStatusError(ctx, resp.StatusCode, body.Bytes())
+		bufpool.Put(body)
+		return nil, uerr
+	}
+	return body, nil
+}
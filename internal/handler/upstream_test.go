@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/tunnel"
+)
+
+// platformResponse 插件应答平台的响应
+type platformResponse struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// servePlatform 经 ServeHTTP 发送平台GET请求并解析响应
+func servePlatform(t *testing.T, h *HTTPHandler, target string) platformResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	var rsp platformResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &rsp); err != nil {
+		t.Fatalf("解析响应失败: %v, body=%s", err, rec.Body.Bytes())
+	}
+	return rsp
+}
+
+// deviceListTarget 平台获取设备列表的请求地址
+func deviceListTarget(voucher string) string {
+	q := url.Values{"voucher": {voucher}, "page": {"1"}, "page_size": {"10"}}
+	return pathDeviceList + "?" + q.Encode()
+}
+
+// newUpstreamServer 启动模拟小智服务端，按 fn 应答全部请求
+func newUpstreamServer(t *testing.T, fn http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(fn)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCallUpstreamStatusCodes(t *testing.T) {
+	h := newTestHandler(t, Config{UpstreamTransport: http.DefaultTransport})
+	cases := []struct {
+		status int
+		code   int
+	}{
+		{http.StatusBadRequest, CodeUpstreamBadRequest},
+		{http.StatusUnauthorized, CodeUpstreamAuth},
+		{http.StatusForbidden, CodeUpstreamAuth},
+		{http.StatusNotFound, CodeUpstreamNotFound},
+		{http.StatusRequestTimeout, CodeUpstreamTimeout},
+		{http.StatusTooManyRequests, CodeUpstreamThrottled},
+		{http.StatusInternalServerError, CodeUpstreamError},
+		{http.StatusBadGateway, CodeUpstreamError},
+		{http.StatusGatewayTimeout, CodeUpstreamTimeout},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.status), func(t *testing.T) {
+			srv := newUpstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+				// 非2xx响应体不是JSON，需在解析前按状态码处理
+				http.Error(w, "upstream says no", c.status)
+			})
+			// 每个用例使用不同密钥，429 的限流状态不影响其他用例
+			voucher := formjson.Voucher{ServerURL: srv.URL, Secret: fmt.Sprint("secret-", c.status)}
+			_, err := h.callUpstream(context.Background(), voucher, "", "/device/list", map[string]int{"page": 1})
+			var uerr *UpstreamError
+			if !errors.As(err, &uerr) {
+				t.Fatalf("错误 %v 不是 UpstreamError", err)
+			}
+			if uerr.Code != c.code || uerr.Status != c.status || !strings.Contains(uerr.Detail, "upstream says no") {
+				t.Fatalf("状态码 %d 映射为 %+v，期望错误码 %d", c.status, uerr, c.code)
+			}
+		})
+	}
+}
+
+func TestDeviceListResponseCode(t *testing.T) {
+	h := newTestHandler(t, Config{UpstreamTransport: http.DefaultTransport})
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		code   int
+	}{
+		{"http_401", http.StatusUnauthorized, `unauthorized`, CodeUpstreamAuth},
+		{"http_404", http.StatusNotFound, `not found`, CodeUpstreamNotFound},
+		{"http_502", http.StatusBadGateway, `bad gateway`, CodeUpstreamError},
+		{"business_404", http.StatusOK, `{"code":404,"msg":"no such agent"}`, CodeUpstreamNotFound},
+		{"invalid_json", http.StatusOK, `<html>`, CodeUpstreamError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := newUpstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+				w.Write([]byte(c.body))
+			})
+			voucher := fmt.Sprintf(`{"ServerURL":%q,"Secret":"secret-%s"}`, srv.URL, c.name)
+			rsp := servePlatform(t, h, deviceListTarget(voucher))
+			if rsp.Code != c.code || !strings.HasPrefix(rsp.Message, fmt.Sprintf("[%d]", c.code)) {
+				t.Fatalf("响应 %+v，期望错误码 %d", rsp, c.code)
+			}
+		})
+	}
+}
+
+func TestCallUpstreamTimeout(t *testing.T) {
+	h := newTestHandler(t, Config{UpstreamTransport: http.DefaultTransport})
+	h.SetUpstream(50*time.Millisecond, nil)
+	release := make(chan struct{})
+	srv := newUpstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	// 先于 srv.Close 执行，避免关闭服务时等待未返回的处理函数
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	rsp := servePlatform(t, h, deviceListTarget(fmt.Sprintf(`{"ServerURL":%q,"Secret":"timeout"}`, srv.URL)))
+	if rsp.Code != CodeUpstreamTimeout {
+		t.Fatalf("响应 %+v，期望错误码 %d", rsp, CodeUpstreamTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("超时 %v 后才返回", elapsed)
+	}
+}
+
+func TestGetDeviceInfo(t *testing.T) {
+	h := newTestHandler(t, Config{})
+	// 设备信息使用设备编号归属租户的凭证，先拉取设备列表登记归属
+	voucher := `{"ServerURL":"http://xiaozhi.test/xiaozhi","Secret":"device-info"}`
+	if rsp := servePlatform(t, h, deviceListTarget(voucher)); rsp.Code != http.StatusOK {
+		t.Fatalf("获取设备列表失败: %+v", rsp)
+	}
+
+	rsp := servePlatform(t, h, pathDeviceInfo+"?key="+url.QueryEscape("A4:CF:12:00:00:02"))
+	var item struct {
+		DeviceNumber string `json:"device_number"`
+	}
+	if rsp.Code != http.StatusOK || json.Unmarshal(rsp.Data, &item) != nil || item.DeviceNumber != "A4:CF:12:00:00:02" {
+		t.Fatalf("获取设备信息响应 %+v", rsp)
+	}
+
+	// 未登记归属的设备找不到凭证
+	if rsp := servePlatform(t, h, pathDeviceInfo+"?key="+url.QueryEscape("A4:CF:12:00:00:09")); rsp.Code != CodeUpstreamNotFound {
+		t.Fatalf("未知设备响应 %+v，期望错误码 %d", rsp, CodeUpstreamNotFound)
+	}
+	if rsp := servePlatform(t, h, pathDeviceInfo); rsp.Code != http.StatusBadRequest {
+		t.Fatalf("缺少 key 时响应 %+v", rsp)
+	}
+}
+
+func TestCallUpstreamTunnelTenant(t *testing.T) {
+	owner := `{"ServerURL":"tunnel://factory-a/xiaozhi","Secret":"owner"}`
+	hub, err := tunnel.New(tunnel.Config{Enabled: true, Agents: []tunnel.Agent{
+		{Name: "factory-a", Token: "token", Tenants: []string{formjson.VoucherKey(owner)}},
+	}}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t, Config{
+		Tunnels:           hub,
+		UpstreamTransport: hub.Transport(http.DefaultTransport),
+		Breaker:           BreakerConfig{Failures: 1},
+	})
+
+	// 其他租户在凭证中填写该隧道的地址，调用在发往隧道前即被拒绝
+	other := `{"ServerURL":"tunnel://factory-a/xiaozhi","Secret":"other"}`
+	rsp := servePlatform(t, h, deviceListTarget(other))
+	if rsp.Code != CodeUpstreamAuth || !strings.Contains(rsp.Message, "无权使用") {
+		t.Fatalf("未授权租户响应 %+v，期望错误码 %d", rsp, CodeUpstreamAuth)
+	}
+	if state := h.breakers.state("tunnel://factory-a"); state.Failures != 0 {
+		t.Fatalf("未授权调用计入了隧道的熔断状态: %+v", state)
+	}
+
+	// 授权的租户通过校验，隧道未连接时调用失败
+	rsp = servePlatform(t, h, deviceListTarget(owner))
+	if rsp.Code != CodeUpstreamError || !strings.Contains(rsp.Message, "未连接") {
+		t.Fatalf("授权租户响应 %+v，期望隧道未连接", rsp)
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"sync"
+	"tp-plugin/internal/metrics"
+)
+
+// 平台回调接口，作为 tp_plugin_platform_requests_total 的 endpoint 标签
+const (
+	endpointFormConfig   = "form_config"
+	endpointDisconnect   = "device_disconnect"
+	endpointNotification = "notification"
+	endpointDeviceList   = "device_list"
+	endpointDeviceInfo   = "device_info"
+)
+
+var platformRequests = metrics.NewCounterVec("tp_plugin_platform_requests_total",
+	"平台回调请求数", "endpoint", "protocol_type", "device_type", "result")
+
+// maxLabelValues 每种标签记录的不同取值上限，超出后记为 other，避免租户自定义类型撑爆指标
+const maxLabelValues = 100
+
+// labelSet 有上限的标签取值集合
+type labelSet struct {
+	mu     sync.Mutex
+	values map[string]bool
+}
+
+var (
+	protocolLabels   = &labelSet{values: map[string]bool{}}
+	deviceTypeLabels = &labelSet{values: map[string]bool{}}
+)
+
+// value 返回可用作标签的取值：空值为 none，不合法或超出上限的取值为 other
+func (s *labelSet) value(v string) string {
+	if v == "" {
+		return "none"
+	}
+	if !deviceTypePattern.MatchString(v) {
+		return "other"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values[v] {
+		return v
+	}
+	if len(s.values) >= maxLabelValues {
+		return "other"
+	}
+	s.values[v] = true
+	return v
+}
+
+// observeRequest 记录一次平台回调请求
+func observeRequest(endpoint, protocolType, deviceType string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	platformRequests.WithLabelValues(endpoint, protocolLabels.value(protocolType), deviceTypeLabels.value(deviceType), result).Inc()
+}
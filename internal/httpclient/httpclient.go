@@ -0,0 +1,189 @@
+// internal/httpclient/httpclient.go
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"tp-plugin/internal/metrics"
+)
+
+// 默认参数
+const (
+	DefaultTimeout        = 15 * time.Second
+	DefaultRetryBackoff   = 200 * time.Millisecond
+	DefaultMaxIdleConns   = 100
+	DefaultMaxIdlePerHost = 10
+	maxRetryBackoff       = 5 * time.Second
+)
+
+// IdempotencyHeader 携带该请求头的非幂等请求(如POST)也可重试，服务端按幂等键去重
+const IdempotencyHeader = "Idempotency-Key"
+
+var retries = metrics.NewCounterVec("tp_plugin_http_client_retries_total",
+	"出站HTTP请求的重试次数", "reason")
+
+// Config 共享HTTP客户端配置
+type Config struct {
+	Timeout        time.Duration // 单次尝试的超时，0使用默认值15秒
+	Retries        int           // 5xx响应及网络错误的重试次数，0不重试
+	RetryBackoff   time.Duration // 首次重试前等待时长，之后每次加倍(最多5秒)，0使用默认值200毫秒
+	MaxIdleConns   int           // 连接池空闲连接总数上限，0使用默认值100
+	MaxIdlePerHost int           // 每个主机的空闲连接上限，0使用默认值10
+	// Wrap 包装共享连接池的传输层(如记录请求日志)，位于重试之内、每次尝试经过一次，为nil时不包装
+	Wrap func(http.RoundTripper) http.RoundTripper
+}
+
+// pool 进程共享的连接池及客户端
+type pool struct {
+	cfg       Config
+	transport http.RoundTripper
+	client    *http.Client
+}
+
+var shared atomic.Pointer[pool]
+
+func init() {
+	Configure(Config{})
+}
+
+// Configure 按配置重建共享连接池，启动时在创建各组件之前调用一次
+func Configure(cfg Config) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultRetryBackoff
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if cfg.MaxIdlePerHost <= 0 {
+		cfg.MaxIdlePerHost = DefaultMaxIdlePerHost
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = cfg.MaxIdleConns
+	t.MaxIdleConnsPerHost = cfg.MaxIdlePerHost
+	var rt http.RoundTripper = t
+	if cfg.Wrap != nil {
+		rt = cfg.Wrap(t)
+	}
+	p := &pool{cfg: cfg, transport: rt}
+	p.client = &http.Client{Transport: &retryTransport{next: rt, cfg: cfg}}
+	shared.Store(p)
+}
+
+// Client 返回共享客户端：复用连接池，每次尝试受 Timeout 限制，可重试的请求在5xx响应及网络错误时按指数退避重试
+func Client() *http.Client {
+	return shared.Load().client
+}
+
+// Transport 返回共享连接池的传输层(不含超时和重试)，供需要在中间插入其他传输层的调用方作为最内层
+func Transport() http.RoundTripper {
+	return shared.Load().transport
+}
+
+// Retry 以共享配置为 next 加上单次尝试超时及重试
+func Retry(next http.RoundTripper) http.RoundTripper {
+	return &retryTransport{next: next, cfg: shared.Load().cfg}
+}
+
+type retryableKey struct{}
+
+// Retryable 标记请求可安全重试，用于以POST实现的查询类接口
+func Retryable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryableKey{}, true)
+}
+
+// canRetry 幂等方法、携带幂等键或经 Retryable 标记，且请求体可重新读取的请求才重试
+func canRetry(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if req.Header.Get(IdempotencyHeader) != "" {
+		return true
+	}
+	marked, _ := req.Context().Value(retryableKey{}).(bool)
+	return marked
+}
+
+type retryTransport struct {
+	next http.RoundTripper
+	cfg  Config
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	attempts := 1
+	if canRetry(req) {
+		attempts += t.cfg.Retries
+	}
+	backoff := t.cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), t.cfg.Timeout)
+		resp, err := next.RoundTrip(r.WithContext(ctx))
+
+		reason := ""
+		switch {
+		case err != nil && req.Context().Err() == nil:
+			reason = "network"
+		case err == nil && resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented:
+			reason = "status"
+		}
+		if reason == "" || attempt >= attempts {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		cancel()
+		retries.WithLabelValues(reason).Inc()
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// cancelBody 响应体关闭时释放单次尝试的超时上下文
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
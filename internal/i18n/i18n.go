@@ -0,0 +1,97 @@
+// internal/i18n/i18n.go
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Locale 语言标识
+type Locale string
+
+const (
+	LocaleZH Locale = "zh" // 简体中文
+	LocaleEN Locale = "en" // 英文
+)
+
+type ctxKey struct{}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLocale = LocaleZH
+)
+
+// SetDefault 设置默认语言(用于日志以及未指定语言的请求)
+func SetDefault(locale string) {
+	l, ok := Parse(locale)
+	if !ok {
+		l = LocaleZH
+	}
+	defaultMu.Lock()
+	defaultLocale = l
+	defaultMu.Unlock()
+}
+
+// Default 获取默认语言
+func Default() Locale {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLocale
+}
+
+// Parse 解析语言标识，兼容 "en"、"en-US"、"zh_CN" 以及 Accept-Language 格式
+func Parse(s string) (Locale, bool) {
+	for _, part := range strings.Split(s, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+		if tag == "" {
+			continue
+		}
+		lang := strings.SplitN(tag, "-", 2)[0]
+		if _, ok := catalogs[Locale(lang)]; ok {
+			return Locale(lang), true
+		}
+	}
+	return "", false
+}
+
+// WithLocale 将语言写入上下文
+func WithLocale(ctx context.Context, locale Locale) context.Context {
+	return context.WithValue(ctx, ctxKey{}, locale)
+}
+
+// FromContext 从上下文获取语言，未设置时返回默认语言
+func FromContext(ctx context.Context) Locale {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(Locale); ok {
+			return l
+		}
+	}
+	return Default()
+}
+
+// T 按指定语言翻译消息，找不到时依次回退到中文和key本身
+func T(locale Locale, key string, args ...interface{}) string {
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		if msg, ok = catalogs[LocaleZH][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Tc 按上下文中的语言翻译消息
+func Tc(ctx context.Context, key string, args ...interface{}) string {
+	return T(FromContext(ctx), key, args...)
+}
+
+// Td 按默认语言翻译消息(主要用于日志)
+func Td(key string, args ...interface{}) string {
+	return T(Default(), key, args...)
+}
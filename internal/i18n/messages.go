@@ -0,0 +1,118 @@
+// internal/i18n/messages.go
+package i18n
+
+// catalogs 消息目录，新增消息时需同时维护中英文两份
+var catalogs = map[Locale]map[string]string{
+	LocaleZH: {
+		"form.request":              "收到获取表单配置请求",
+		"form.unsupported_type":     "不支持的表单类型: %s",
+		"form.open_failed":          "文件打开失败: %s",
+		"form.decode_failed":        "解码失败: %s",
+		"form.read_success":         "读取文件[%s]成功",
+		"disconnect.request":        "收到设备断开连接请求",
+		"disconnect.status_failed":  "发送设备离线状态失败",
+		"notify.request":            "收到通知请求",
+		"notify.parse_failed":       "解析通知消息失败",
+		"notify.service_config":     "处理服务配置修改通知",
+		"notify.device_config":      "处理设备配置修改通知",
+		"notify.unknown_type":       "未知的通知类型: %s",
+		"notify.device_unassigned":  "处理设备移除通知",
+		"unbind.missing_device":     "设备移除通知缺少设备编号或凭证",
+		"unbind.failed":             "小智服务端解除设备绑定失败",
+		"unbind.success":            "小智服务端已解除设备绑定",
+		"bind.success":              "设备绑定成功",
+		"bind.conflict":             "设备 %s 已绑定到其他智能体或租户，请先在原账号解绑，或通过管理接口强制重新绑定(force=true)",
+		"bind.force_rebind":         "设备已被绑定，强制解绑后重新绑定",
+		"bind.collision":            "设备编号 %s 与已登记的设备 %s(租户 %s)冲突，拒绝绑定；确认设备已迁移后可通过管理接口 /admin/collisions 清除归属",
+		"bind.rebind_failed":        "强制重新绑定失败，设备已解绑但未完成绑定，请重试",
+		"admin.success":             "成功",
+		"admin.bad_request":         "请求参数错误: %s",
+		"callback.unauthorized":     "x-token 无效",
+		"callback.bad_version":      "接口版本错误: %s",
+		"callback.bind_failed":      "小智服务端回调设备绑定失败",
+		"device.unauthorized":       "设备编号或凭证无效",
+		"trace.disabled":            "设备追踪未启用",
+		"trace.not_found":           "该设备没有进行中的追踪",
+		"capture.disabled":          "MQTT抓包未启用",
+		"capture.not_found":         "没有进行中的MQTT抓包",
+		"override.not_found":        "该设备没有进行中的状态覆盖",
+		"ota.not_found":             "该设备没有进行中的固件升级",
+		"device_list.request":       "收到获取设备列表请求",
+		"device_list.success":       "获取成功",
+		"maintenance.tag":           "[维护中]",
+		"maintenance.tag_reason":    "[维护中: %s]",
+		"voucher.parse_failed":      "解析凭证失败",
+		"upstream.marshal_failed":   "序列化请求数据失败",
+		"upstream.request_failed":   "创建请求失败",
+		"upstream.sending":          "发送第三方请求",
+		"upstream.call_failed":      "调用第三方接口失败",
+		"upstream.read_failed":      "读取响应体失败",
+		"upstream.response":         "第三方接口响应",
+		"upstream.unmarshal_failed": "解析响应数据失败",
+		"upstream.auth_failed":      "小智服务端鉴权失败，请检查凭证中的密钥",
+		"upstream.not_found":        "小智服务端未找到请求的资源",
+		"upstream.timeout":          "小智服务端响应超时",
+		"upstream.bad_request":      "小智服务端拒绝了请求参数",
+		"upstream.server_error":     "小智服务端异常",
+		"upstream.throttled":        "小智服务端限流或配额已用尽，请稍后重试",
+		"upstream.unavailable":      "小智服务端连续调用失败，已暂停请求，请稍后重试",
+		"handler.response":          "接口响应",
+		"protocol.unsupported":      "不支持的协议类型: %s",
+	},
+	LocaleEN: {
+		"form.request":              "received form config request",
+		"form.unsupported_type":     "unsupported form type: %s",
+		"form.open_failed":          "failed to open file: %s",
+		"form.decode_failed":        "failed to decode: %s",
+		"form.read_success":         "read file [%s] successfully",
+		"disconnect.request":        "received device disconnect request",
+		"disconnect.status_failed":  "failed to send device offline status",
+		"notify.request":            "received notification request",
+		"notify.parse_failed":       "failed to parse notification message",
+		"notify.service_config":     "handling service config change notification",
+		"notify.device_config":      "handling device config change notification",
+		"notify.unknown_type":       "unknown notification type: %s",
+		"notify.device_unassigned":  "handling device unassigned notification",
+		"unbind.missing_device":     "device unassigned notification is missing device number or voucher",
+		"unbind.failed":             "failed to unbind device on xiaozhi server",
+		"unbind.success":            "device unbound on xiaozhi server",
+		"bind.success":              "device bound",
+		"bind.conflict":             "device %s is already bound to another agent or tenant; unbind it from the original account first, or force a rebind via the admin API (force=true)",
+		"bind.force_rebind":         "device already bound, unbinding and rebinding",
+		"bind.collision":            "device number %s collides with registered device %s (tenant %s), bind refused; clear the ownership via /admin/collisions once the device has moved",
+		"bind.rebind_failed":        "forced rebind failed: the device was unbound but not bound again, please retry",
+		"admin.success":             "success",
+		"admin.bad_request":         "bad request: %s",
+		"callback.unauthorized":     "invalid x-token",
+		"callback.bad_version":      "invalid api version: %s",
+		"callback.bind_failed":      "xiaozhi server reported device bind failure",
+		"device.unauthorized":       "invalid device number or credential",
+		"trace.disabled":            "device tracing is disabled",
+		"trace.not_found":           "no active trace for this device",
+		"capture.disabled":          "MQTT capture is disabled",
+		"capture.not_found":         "no active MQTT capture",
+		"override.not_found":        "no active status override for this device",
+		"ota.not_found":             "no firmware upgrade in progress for this device",
+		"device_list.request":       "received device list request",
+		"device_list.success":       "success",
+		"maintenance.tag":           "[maintenance]",
+		"maintenance.tag_reason":    "[maintenance: %s]",
+		"voucher.parse_failed":      "failed to parse voucher",
+		"upstream.marshal_failed":   "failed to serialize request data",
+		"upstream.request_failed":   "failed to create request",
+		"upstream.sending":          "sending upstream request",
+		"upstream.call_failed":      "upstream call failed",
+		"upstream.read_failed":      "failed to read response body",
+		"upstream.response":         "upstream response",
+		"upstream.unmarshal_failed": "failed to parse response data",
+		"upstream.auth_failed":      "xiaozhi server authentication failed, check the secret in the voucher",
+		"upstream.not_found":        "xiaozhi server could not find the requested resource",
+		"upstream.timeout":          "xiaozhi server timed out",
+		"upstream.bad_request":      "xiaozhi server rejected the request",
+		"upstream.server_error":     "xiaozhi server error",
+		"upstream.throttled":        "xiaozhi server rate limit or quota exceeded, retry later",
+		"upstream.unavailable":      "xiaozhi server is failing repeatedly, requests are paused, retry later",
+		"handler.response":          "handler response",
+		"protocol.unsupported":      "unsupported protocol type: %s",
+	},
+}
@@ -0,0 +1,175 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 指标类型
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// vec 带标签的指标集合
+type vec struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	mu         sync.RWMutex
+	values     map[string]*Value
+}
+
+// Value 单个标签组合对应的指标值
+type Value struct {
+	labels []string
+	val    float64
+	mu     sync.Mutex
+}
+
+// Add 累加
+func (v *Value) Add(delta float64) {
+	v.mu.Lock()
+	v.val += delta
+	v.mu.Unlock()
+}
+
+// Inc 加1
+func (v *Value) Inc() { v.Add(1) }
+
+// Dec 减1(仅用于gauge)
+func (v *Value) Dec() { v.Add(-1) }
+
+// Set 设置值(仅用于gauge)
+func (v *Value) Set(val float64) {
+	v.mu.Lock()
+	v.val = val
+	v.mu.Unlock()
+}
+
+// Get 读取当前值
+func (v *Value) Get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.val
+}
+
+// CounterVec 只增不减的计数器
+type CounterVec struct{ *vec }
+
+// GaugeVec 可增可减的仪表
+type GaugeVec struct{ *vec }
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]*vec{}
+)
+
+// NewCounterVec 注册计数器，同名指标重复注册时返回已有指标
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{register(name, help, typeCounter, labelNames)}
+}
+
+// NewGaugeVec 注册仪表，同名指标重复注册时返回已有指标
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{register(name, help, typeGauge, labelNames)}
+}
+
+func register(name, help, kind string, labelNames []string) *vec {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if v, ok := registry[name]; ok {
+		return v
+	}
+	v := &vec{name: name, help: help, kind: kind, labelNames: labelNames, values: map[string]*Value{}}
+	registry[name] = v
+	return v
+}
+
+// WithLabelValues 按标签值获取指标，标签值数量需与注册时一致
+func (v *vec) WithLabelValues(values ...string) *Value {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s 需要 %d 个标签值，实际 %d 个", v.name, len(v.labelNames), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	val, ok := v.values[key]
+	v.mu.RUnlock()
+	if ok {
+		return val
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if val, ok = v.values[key]; !ok {
+		val = &Value{labels: append([]string(nil), values...)}
+		v.values[key] = val
+	}
+	return val
+}
+
+// Snapshot 返回各标签组合的当前值，key 为以逗号连接的标签值
+func (v *vec) Snapshot() map[string]float64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	out := make(map[string]float64, len(v.values))
+	for _, val := range v.values {
+		out[strings.Join(val.labels, ",")] = val.Get()
+	}
+	return out
+}
+
+// WriteText 以 Prometheus 文本格式输出所有指标
+func WriteText(w io.Writer) {
+	registryMu.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryMu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		registryMu.RLock()
+		v := registry[name]
+		registryMu.RUnlock()
+
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+		v.mu.RLock()
+		lines := make([]string, 0, len(v.values))
+		for _, val := range v.values {
+			lines = append(lines, v.name+formatLabels(v.labelNames, val.labels)+" "+strconv.FormatFloat(val.Get(), 'g', -1, 64))
+		}
+		v.mu.RUnlock()
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Handler 返回输出 Prometheus 文本格式指标的 HTTP 处理器
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
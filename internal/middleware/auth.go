@@ -0,0 +1,136 @@
+// internal/middleware/auth.go
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 管理令牌的权限范围，高级别包含低级别的全部权限
+const (
+	ScopeRead    = "read"    // 只读，供监控系统使用
+	ScopeOperate = "operate" // 日常运维操作，如绑定设备、开关追踪、广播命令
+	ScopeFull    = "full"    // 全部权限，包括编辑表单、下载支持包和管理令牌
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeOperate: 2, ScopeFull: 3}
+
+// ValidScope 校验权限范围名称
+func ValidScope(scope string) error {
+	if scopeRank[scope] == 0 {
+		return fmt.Errorf("未知的权限范围: %s(可选 read/operate/full)", scope)
+	}
+	return nil
+}
+
+// ScopeAllows 判断 have 是否满足 need
+func ScopeAllows(have, need string) bool {
+	return scopeRank[have] > 0 && scopeRank[have] >= scopeRank[need]
+}
+
+// ScopedToken 带权限范围的访问令牌
+type ScopedToken struct {
+	Name  string // 令牌名称，用于日志审计
+	Token string
+	Scope string // read/operate/full
+}
+
+// AuthConfig 接口鉴权配置
+type AuthConfig struct {
+	Tokens   []string      // 全部权限的访问令牌，通过 Authorization: Bearer <token> 传递
+	Scoped   []ScopedToken // 按权限范围区分的访问令牌
+	Prefixes []string      // 需要鉴权的路径前缀，平台回调接口不在其中
+	// Lookup 查找配置之外(如本地存储中)的令牌，为nil时不查找
+	Lookup func(token string) (ScopedToken, bool)
+	// Required 返回请求需要的权限范围，为nil时一律要求 full
+	Required func(r *http.Request) string
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext 返回通过鉴权的令牌名称及权限范围，未经鉴权的请求返回false
+func PrincipalFromContext(ctx context.Context) (ScopedToken, bool) {
+	p, ok := ctx.Value(principalKey{}).(ScopedToken)
+	return p, ok
+}
+
+// Auth 对指定前缀的路径做令牌鉴权；未配置令牌时这些路径一律拒绝访问，
+// 令牌有效但权限范围不足时返回403
+func Auth(cfg AuthConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !matchPrefix(r.URL.Path, cfg.Prefixes) || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			principal, ok := cfg.authenticate(bearerToken(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tp-plugin"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			need := ScopeFull
+			if cfg.Required != nil {
+				need = cfg.Required(r)
+			}
+			if !ScopeAllows(principal.Scope, need) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="tp-plugin", error="insufficient_scope", scope="%s"`, need))
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			principal.Token = ""
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+		})
+	}
+}
+
+// authenticate 依次匹配全权限令牌、带权限范围的令牌和 Lookup
+func (cfg AuthConfig) authenticate(token string) (ScopedToken, bool) {
+	if token == "" {
+		return ScopedToken{}, false
+	}
+	if validToken(token, cfg.Tokens) {
+		return ScopedToken{Name: "admin", Scope: ScopeFull}, true
+	}
+	for _, t := range cfg.Scoped {
+		if t.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	if cfg.Lookup != nil {
+		return cfg.Lookup(token)
+	}
+	return ScopedToken{}, false
+}
+
+func matchPrefix(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if p != "" && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func validToken(token string, tokens []string) bool {
+	if token == "" {
+		return false
+	}
+	for _, t := range tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+// internal/middleware/logging.go
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logging 统一的访问日志
+func Logging(logger *logrus.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			entry := logger.WithFields(logrus.Fields{
+				"request_id": RequestIDFromContext(r.Context()),
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"status":     rec.Status(),
+				"bytes":      rec.bytes,
+				"duration":   time.Since(start).String(),
+				"remote":     r.RemoteAddr,
+			})
+			if rec.Status() >= http.StatusInternalServerError {
+				entry.Warn("HTTP请求")
+			} else {
+				entry.Info("HTTP请求")
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// internal/middleware/metrics.go
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"tp-plugin/internal/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("tp_plugin_http_requests_total",
+		"HTTP请求数", "method", "path", "code")
+	httpDurationSum = metrics.NewCounterVec("tp_plugin_http_request_duration_seconds_sum",
+		"HTTP请求累计耗时(秒)", "method", "path")
+	httpInFlight = metrics.NewGaugeVec("tp_plugin_http_requests_in_flight",
+		"正在处理的HTTP请求数")
+)
+
+// Metrics 记录请求数、耗时和并发数
+// 未匹配路由(404)的请求统一记为 unmatched，避免扫描请求产生大量标签值
+func Metrics() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			inFlight := httpInFlight.WithLabelValues()
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			path := r.URL.Path
+			if rec.Status() == http.StatusNotFound {
+				path = "unmatched"
+			}
+			httpRequests.WithLabelValues(r.Method, path, strconv.Itoa(rec.Status())).Inc()
+			httpDurationSum.WithLabelValues(r.Method, path).Add(time.Since(start).Seconds())
+		})
+	}
+}
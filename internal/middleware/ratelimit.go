@@ -0,0 +1,101 @@
+// internal/middleware/ratelimit.go
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig 限流配置
+type RateLimitConfig struct {
+	RPS   float64 // 每个客户端每秒允许的请求数，<=0 不限流
+	Burst int     // 突发请求数
+}
+
+// bucket 令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter 按客户端IP限流的令牌桶集合
+type limiter struct {
+	cfg     RateLimitConfig
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func (l *limiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 定期清理长时间未访问的客户端
+	if now.Sub(l.swept) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.cfg.RPS
+	if b.tokens > float64(l.cfg.Burst) {
+		b.tokens = float64(l.cfg.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RateLimit 按客户端IP限流，超出时返回429
+func RateLimit(cfg RateLimitConfig) Middleware {
+	if cfg.RPS <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = int(cfg.RPS) + 1
+	}
+	l := &limiter{cfg: cfg, buckets: map[string]*bucket{}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.allow(clientIP(r), time.Now()) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP 返回客户端IP。监听 Unix 套接字时连接均来自本机反向代理，RemoteAddr 为 "@" 或空，
+// 改用代理设置的 X-Real-IP 或 X-Forwarded-For 第一项
+func clientIP(r *http.Request) string {
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+		if ip, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ","); ip != "" {
+			return strings.TrimSpace(ip)
+		}
+		return r.RemoteAddr
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,33 @@
+// internal/middleware/recovery.go
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Recovery 捕获处理过程中的 panic，返回500并记录堆栈，避免单个请求拖垮整个进程
+func Recovery(logger *logrus.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					logger.WithFields(logrus.Fields{
+						"request_id": RequestIDFromContext(r.Context()),
+						"method":     r.Method,
+						"path":       r.URL.Path,
+						"panic":      err,
+						"stack":      string(debug.Stack()),
+					}).Error("处理请求时发生panic")
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
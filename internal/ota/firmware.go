@@ -0,0 +1,150 @@
+// internal/ota/firmware.go
+package ota
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/wsserver"
+)
+
+// errChecksum 固件内容与平台下发的签名不一致
+var errChecksum = errors.New("固件校验失败")
+
+// fetch 下载固件到缓存目录并校验签名及大小，返回文件路径、SHA256和大小；
+// 平台以SHA256签名且缓存中已有该固件时不重复下载
+func (m *Manager) fetch(req request) (string, string, int64, error) {
+	if err := os.MkdirAll(m.cfg.Dir, 0755); err != nil {
+		return "", "", 0, fmt.Errorf("创建固件目录失败: %v", err)
+	}
+	if req.signMethod == "SHA256" {
+		path := filepath.Join(m.cfg.Dir, req.sign+".bin")
+		if fi, err := os.Stat(path); err == nil && (req.size == 0 || fi.Size() == req.size) {
+			return path, req.sign, fi.Size(), nil
+		}
+	}
+
+	client := &http.Client{Transport: httpclient.Transport(), Timeout: downloadTimeout}
+	resp, err := client.Get(req.url)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("下载固件失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, fmt.Errorf("下载固件失败: HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > m.cfg.MaxSize {
+		return "", "", 0, fmt.Errorf("固件大小 %d 超过上限 %d", resp.ContentLength, m.cfg.MaxSize)
+	}
+
+	tmp, err := os.CreateTemp(m.cfg.Dir, "download-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("创建固件文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	md5sum, shasum := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, md5sum, shasum), io.LimitReader(resp.Body, m.cfg.MaxSize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", "", 0, fmt.Errorf("下载固件失败: %v", err)
+	}
+	if size > m.cfg.MaxSize {
+		return "", "", 0, fmt.Errorf("固件大小超过上限 %d", m.cfg.MaxSize)
+	}
+	if req.size > 0 && size != req.size {
+		return "", "", 0, fmt.Errorf("%w: 大小 %d 与平台下发的 %d 不一致", errChecksum, size, req.size)
+	}
+	sum := hex.EncodeToString(shasum.Sum(nil))
+	switch req.signMethod {
+	case "MD5":
+		if got := hex.EncodeToString(md5sum.Sum(nil)); got != req.sign {
+			return "", "", 0, fmt.Errorf("%w: MD5 %s 与签名 %s 不一致", errChecksum, got, req.sign)
+		}
+	case "SHA256":
+		if sum != req.sign {
+			return "", "", 0, fmt.Errorf("%w: SHA256 %s 与签名 %s 不一致", errChecksum, sum, req.sign)
+		}
+	}
+	path := filepath.Join(m.cfg.Dir, sum+".bin")
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", "", 0, fmt.Errorf("保存固件文件失败: %v", err)
+	}
+	return path, sum, size, nil
+}
+
+// sendChunks 先下发不含地址的 ota 消息，再按序下发 ota_chunk 分片(base64)，
+// 每片等待设备以 ota_progress 的 params.received(已接收字节数)确认后发送下一片
+func (m *Manager) sendChunks(t *task) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return fmt.Errorf("读取固件文件失败: %v", err)
+	}
+	defer f.Close()
+	if err := m.devices.Send(t.DeviceNumber, wsserver.Message{Type: "ota", ID: t.TaskID, Params: t.params()}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, m.cfg.ChunkSize)
+	var offset int64
+	lastPercent := 0
+	for offset < t.Size {
+		n, err := io.ReadFull(f, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("读取固件文件失败: %v", err)
+		}
+		end := offset + int64(n)
+		err = m.devices.Send(t.DeviceNumber, wsserver.Message{
+			Type: "ota_chunk",
+			ID:   t.TaskID,
+			Params: map[string]interface{}{
+				"offset": offset,
+				"data":   base64.StdEncoding.EncodeToString(buf[:n]),
+				"last":   end >= t.Size,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := m.awaitChunk(t, end); err != nil {
+			return err
+		}
+		offset = end
+		// 传输进度按10%上报，设备烧写完成后上报100
+		if percent := int(offset * 90 / t.Size); percent/10 > lastPercent/10 {
+			lastPercent = percent
+			m.report(t, StateDelivering, percent, "正在传输固件")
+		}
+	}
+	m.report(t, StateUpgrading, 90, "固件传输完成，等待设备烧写")
+	return nil
+}
+
+// awaitChunk 等待设备确认已接收到 end 字节，设备上报失败或超时时返回错误
+func (m *Manager) awaitChunk(t *task, end int64) error {
+	timer := time.NewTimer(chunkTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-t.events:
+			if step, desc := deviceStep(msg); step < 0 {
+				return fmt.Errorf("设备接收固件失败: %s", desc)
+			}
+			if received, _ := msg.Params["received"].(float64); int64(received) >= end {
+				return nil
+			}
+		case <-timer.C:
+			return errors.New("等待设备确认固件分片超时")
+		}
+	}
+}
@@ -0,0 +1,504 @@
+// internal/ota/ota.go
+package ota
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/platform"
+	"tp-plugin/internal/wsserver"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMethod 平台下发固件升级的命令方法名，params 与ThingsPanel OTA推送的 params 一致
+const DefaultMethod = "ota_upgrade"
+
+// ProgressTopic 向平台上报升级进度的主题
+const ProgressTopic = "ota/devices/progress"
+
+// 固件下发方式
+const (
+	ModeURL     = "url"     // 下发下载地址，设备自行下载
+	ModeChunked = "chunked" // 插件经WebSocket会话分片传输
+)
+
+// 升级任务状态
+const (
+	StateDownloading = "downloading" // 插件正在下载并校验固件
+	StateDelivering  = "delivering"  // 正在向设备下发地址或分片
+	StateUpgrading   = "upgrading"   // 设备正在下载、烧写
+	StateSucceeded   = "succeeded"
+	StateFailed      = "failed"
+)
+
+// 上报平台的失败步骤，与ThingsPanel OTA进度约定一致，1-100为升级进度
+const (
+	StepFailed         = -1 // 升级失败(含超时、设备离线)
+	StepDownloadFailed = -2 // 下载失败
+	StepVerifyFailed   = -3 // 校验失败
+	StepFlashFailed    = -4 // 烧写失败
+)
+
+// 默认参数
+const (
+	DefaultChunkSize = 4096
+	DefaultMaxSize   = 16 << 20
+	DefaultTimeout   = 10 * time.Minute
+	DefaultDir       = "data/firmware"
+	downloadTimeout  = 5 * time.Minute
+	chunkTimeout     = 30 * time.Second // 等待设备确认一个分片的时长
+)
+
+var (
+	otaUpgrades = metrics.NewCounterVec("tp_plugin_ota_upgrades_total",
+		"固件升级任务数", "result")
+	otaActive = metrics.NewGaugeVec("tp_plugin_ota_active",
+		"进行中的固件升级任务数")
+)
+
+// ErrBusy 设备已有进行中的升级任务
+var ErrBusy = errors.New("设备正在升级")
+
+// Config 固件升级配置
+type Config struct {
+	Enabled   bool
+	Dir       string        // 固件缓存目录，按SHA256保存，为空时使用 data/firmware
+	BaseURL   string        // 设备访问插件HTTP服务的地址(含 base_path)，为空时下发平台原始下载地址
+	Mode      string        // 默认下发方式 url/chunked，为空时为 url
+	ChunkSize int           // 分片传输时每片的字节数，0使用默认值4096
+	MaxSize   int64         // 固件大小上限(字节)，0使用默认值16MB
+	Timeout   time.Duration // 设备超过该时长未上报进度视为升级失败，0使用默认值10分钟
+}
+
+// Task 一台设备的升级任务
+type Task struct {
+	TaskID       string    `json:"task_id"`
+	DeviceID     string    `json:"device_id"`
+	DeviceNumber string    `json:"device_number"`
+	MessageID    string    `json:"message_id,omitempty"` // 平台命令的消息ID
+	Version      string    `json:"version"`
+	Module       string    `json:"module,omitempty"`
+	Mode         string    `json:"mode"`
+	State        string    `json:"state"`
+	Step         int       `json:"step"` // 最近一次上报平台的进度，失败时为负数
+	Desc         string    `json:"desc,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	URL          string    `json:"url,omitempty"` // 下发给设备的下载地址
+	StartedAt    time.Time `json:"started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (t *Task) done() bool {
+	return t.State == StateSucceeded || t.State == StateFailed
+}
+
+// task 升级任务及其运行状态
+type task struct {
+	Task
+	source string                // 平台下发的固件地址
+	path   string                // 本地缓存的固件文件
+	events chan wsserver.Message // 设备上报的进度
+}
+
+// Manager 处理平台下发的固件升级命令：下载并校验固件，经设备WebSocket会话下发下载地址或分片传输，
+// 跟踪设备上报的进度并转发到平台。每台设备同时只有一个进行中的任务
+type Manager struct {
+	cfg      Config
+	platform *platform.PlatformClient
+	devices  *wsserver.Server
+	logger   *logrus.Logger
+	mu       sync.Mutex
+	tasks    map[string]*task // 设备编号 -> 最近一次任务
+}
+
+// New 创建固件升级管理器，未启用或设备WebSocket服务未启用时返回nil
+func New(cfg Config, platform *platform.PlatformClient, devices *wsserver.Server, logger *logrus.Logger) *Manager {
+	if !cfg.Enabled || devices == nil {
+		return nil
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = DefaultDir
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeURL
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DefaultChunkSize
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	m := &Manager{
+		cfg:      cfg,
+		platform: platform,
+		devices:  devices,
+		logger:   logger,
+		tasks:    make(map[string]*task),
+	}
+	devices.OnOTAProgress(m.progress)
+	return m
+}
+
+func newTaskID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// request 平台下发的升级参数，字段名与ThingsPanel OTA推送一致
+type request struct {
+	url        string
+	version    string
+	module     string
+	signMethod string
+	sign       string
+	size       int64
+	mode       string
+}
+
+func stringParam(params map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		switch v := params[k].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// parseRequest 校验升级参数：url 和 version 必填，sign 为空时不校验
+func parseRequest(params map[string]interface{}, defaultMode string) (request, error) {
+	req := request{
+		url:        stringParam(params, "url"),
+		version:    stringParam(params, "version"),
+		module:     stringParam(params, "module"),
+		signMethod: strings.ToUpper(stringParam(params, "signMethod", "sign_method")),
+		sign:       strings.ToLower(stringParam(params, "sign")),
+		mode:       stringParam(params, "mode"),
+	}
+	if req.url == "" || req.version == "" {
+		return req, errors.New("升级参数缺少 url 或 version")
+	}
+	if !strings.HasPrefix(req.url, "http://") && !strings.HasPrefix(req.url, "https://") {
+		return req, fmt.Errorf("不支持的固件地址: %s", req.url)
+	}
+	if s := stringParam(params, "size"); s != "" {
+		size, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || size < 0 {
+			return req, fmt.Errorf("固件大小无效: %s", s)
+		}
+		req.size = size
+	}
+	if req.sign != "" {
+		switch req.signMethod {
+		case "":
+			req.signMethod = "MD5"
+		case "MD5", "SHA256":
+		default:
+			return req, fmt.Errorf("不支持的签名算法: %s", req.signMethod)
+		}
+	}
+	if req.mode == "" {
+		req.mode = defaultMode
+	}
+	if req.mode != ModeURL && req.mode != ModeChunked {
+		return req, fmt.Errorf("不支持的下发方式: %s", req.mode)
+	}
+	return req, nil
+}
+
+// Upgrade 处理一条固件升级命令，参数校验通过且设备已连接时开始升级并立即返回，
+// 升级进度经 ProgressTopic 上报平台
+func (m *Manager) Upgrade(deviceID, number, messageID string, params map[string]interface{}) error {
+	req, err := parseRequest(params, m.cfg.Mode)
+	if err != nil {
+		otaUpgrades.WithLabelValues("rejected").Inc()
+		return err
+	}
+	if !m.devices.Connected(number) {
+		otaUpgrades.WithLabelValues("rejected").Inc()
+		return wsserver.ErrNotConnected
+	}
+	now := time.Now()
+	t := &task{
+		Task: Task{
+			TaskID:       newTaskID(),
+			DeviceID:     deviceID,
+			DeviceNumber: number,
+			MessageID:    messageID,
+			Version:      req.version,
+			Module:       req.module,
+			Mode:         req.mode,
+			State:        StateDownloading,
+			Size:         req.size,
+			StartedAt:    now,
+			UpdatedAt:    now,
+		},
+		source: req.url,
+		events: make(chan wsserver.Message, 16),
+	}
+	m.mu.Lock()
+	if old := m.tasks[number]; old != nil && !old.done() {
+		m.mu.Unlock()
+		otaUpgrades.WithLabelValues("rejected").Inc()
+		return ErrBusy
+	}
+	m.tasks[number] = t
+	m.updateGauge()
+	m.mu.Unlock()
+
+	m.logger.WithFields(logrus.Fields{
+		"device_number": number,
+		"version":       req.version,
+		"mode":          req.mode,
+		"task_id":       t.TaskID,
+	}).Info("开始固件升级")
+	go m.run(t, req)
+	return nil
+}
+
+// run 下载校验固件、下发到设备并等待设备上报升级结果
+func (m *Manager) run(t *task, req request) {
+	m.report(t, StateDownloading, 0, "插件正在下载固件")
+	path, sum, size, err := m.fetch(req)
+	if err != nil {
+		step := StepDownloadFailed
+		if errors.Is(err, errChecksum) {
+			step = StepVerifyFailed
+		}
+		m.fail(t, step, err.Error())
+		return
+	}
+	m.mu.Lock()
+	t.path, t.SHA256, t.Size = path, sum, size
+	m.mu.Unlock()
+
+	m.report(t, StateDelivering, 0, "正在下发固件到设备")
+	if t.Mode == ModeChunked {
+		err = m.sendChunks(t)
+	} else {
+		err = m.sendURL(t)
+	}
+	if err != nil {
+		m.fail(t, StepFailed, err.Error())
+		return
+	}
+	m.wait(t)
+}
+
+// sendURL 下发下载地址：配置了 BaseURL 时为插件的固件下载接口，否则为平台原始地址
+func (m *Manager) sendURL(t *task) error {
+	url := t.source
+	if m.cfg.BaseURL != "" {
+		url = m.cfg.BaseURL + "/device/firmware?task_id=" + t.TaskID
+	}
+	m.mu.Lock()
+	t.URL = url
+	m.mu.Unlock()
+	return m.devices.Send(t.DeviceNumber, wsserver.Message{
+		Type:   "ota",
+		ID:     t.TaskID,
+		Params: t.params(),
+	})
+}
+
+// params 下发给设备的升级消息内容
+func (t *task) params() map[string]interface{} {
+	p := map[string]interface{}{
+		"version": t.Version,
+		"mode":    t.Mode,
+		"size":    t.Size,
+		"sha256":  t.SHA256,
+	}
+	if t.URL != "" {
+		p["url"] = t.URL
+	}
+	if t.Module != "" {
+		p["module"] = t.Module
+	}
+	return p
+}
+
+// wait 等待设备上报进度，进度达到100为成功，负数为失败，超时未上报为失败
+func (m *Manager) wait(t *task) {
+	timer := time.NewTimer(m.cfg.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-t.events:
+			step, desc := deviceStep(msg)
+			switch {
+			case step == 0 && desc == "":
+				continue // 分片确认等不含进度的消息
+			case step < 0:
+				m.fail(t, step, desc)
+				return
+			case step >= 100:
+				m.succeed(t, desc)
+				return
+			}
+			m.report(t, StateUpgrading, step, desc)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(m.cfg.Timeout)
+		case <-timer.C:
+			m.fail(t, StepFailed, "等待设备上报升级进度超时")
+			return
+		}
+	}
+}
+
+// deviceStep 解析设备上报的进度：params.step 为进度或失败步骤；code 不为0或 error 不为空时按烧写失败处理
+func deviceStep(msg wsserver.Message) (int, string) {
+	desc, _ := msg.Params["desc"].(string)
+	if msg.Code != 0 || msg.Error != "" {
+		if desc == "" {
+			desc = msg.Error
+		}
+		return StepFlashFailed, desc
+	}
+	step, _ := msg.Params["step"].(float64)
+	if s, ok := msg.Params["step"].(string); ok {
+		n, _ := strconv.Atoi(s)
+		step = float64(n)
+	}
+	return int(step), desc
+}
+
+// progress 设备上报的 ota_progress 消息，交给对应的任务
+func (m *Manager) progress(number string, msg wsserver.Message) {
+	m.mu.Lock()
+	t := m.tasks[number]
+	active := t != nil && !t.done() && (msg.ID == "" || msg.ID == t.TaskID)
+	m.mu.Unlock()
+	if !active {
+		m.logger.WithField("device_number", number).WithField("task_id", msg.ID).Debug("忽略无对应任务的升级进度")
+		return
+	}
+	select {
+	case t.events <- msg:
+	default:
+		// 进度积压时丢弃中间进度，分片确认按已接收字节数判断，不受影响
+	}
+}
+
+// report 更新任务状态，进度或描述变化时上报平台
+func (m *Manager) report(t *task, state string, step int, desc string) {
+	m.mu.Lock()
+	changed := t.Step != step || t.Desc != desc
+	t.State, t.Step, t.Desc, t.UpdatedAt = state, step, desc, time.Now()
+	done := t.done()
+	if done {
+		m.updateGauge()
+	}
+	m.mu.Unlock()
+	if !changed && !done {
+		return
+	}
+	values := map[string]interface{}{
+		"step": strconv.Itoa(step),
+		"desc": desc,
+	}
+	if t.Module != "" {
+		values["module"] = t.Module
+	}
+	if err := m.platform.Respond(ProgressTopic, t.DeviceID, values); err != nil {
+		m.logger.WithError(err).WithField("device_number", t.DeviceNumber).Warn("上报升级进度失败")
+	}
+}
+
+func (m *Manager) fail(t *task, step int, desc string) {
+	otaUpgrades.WithLabelValues("failed").Inc()
+	m.logger.WithFields(logrus.Fields{
+		"device_number": t.DeviceNumber,
+		"task_id":       t.TaskID,
+		"step":          step,
+	}).Warn("固件升级失败: " + desc)
+	m.report(t, StateFailed, step, desc)
+}
+
+func (m *Manager) succeed(t *task, desc string) {
+	if desc == "" {
+		desc = "升级成功"
+	}
+	otaUpgrades.WithLabelValues("succeeded").Inc()
+	m.logger.WithField("device_number", t.DeviceNumber).WithField("version", t.Version).Info("固件升级成功")
+	m.report(t, StateSucceeded, 100, desc)
+}
+
+// updateGauge 调用方需持有锁
+func (m *Manager) updateGauge() {
+	n := 0
+	for _, t := range m.tasks {
+		if !t.done() {
+			n++
+		}
+	}
+	otaActive.WithLabelValues().Set(float64(n))
+}
+
+// Firmware 返回进行中任务的固件文件，供设备经插件下载；任务不存在、已结束或尚未下载完成时返回false
+func (m *Manager) Firmware(number, taskID string) (Task, string, bool) {
+	if m == nil {
+		return Task{}, "", false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.tasks[number]
+	if t == nil || t.done() || t.path == "" || (taskID != "" && taskID != t.TaskID) {
+		return Task{}, "", false
+	}
+	return t.Task, t.path, true
+}
+
+// Pending 返回设备进行中且已下发的任务，设备重启后查询以继续升级
+func (m *Manager) Pending(number string) (Task, bool) {
+	if m == nil {
+		return Task{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.tasks[number]
+	if t == nil || t.done() || t.URL == "" {
+		return Task{}, false
+	}
+	return t.Task, true
+}
+
+// Tasks 返回各设备最近一次升级任务，进行中的排在前面，其余按开始时间倒序
+func (m *Manager) Tasks() []Task {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	list := make([]Task, 0, len(m.tasks))
+	for _, t := range m.tasks {
+		list = append(list, t.Task)
+	}
+	m.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].done() != list[j].done() {
+			return !list[i].done()
+		}
+		return list[i].StartedAt.After(list[j].StartedAt)
+	})
+	return list
+}
@@ -0,0 +1,89 @@
+// internal/pipeline/definition.go
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 步骤默认值
+const (
+	defaultStepTimeout = 30 * time.Second
+	defaultBackoff     = 2 * time.Second
+)
+
+// Step 流水线中的一个步骤
+// Params 中的 ${name} 在执行时替换为运行变量(启动时传入的变量及前序步骤的输出)
+type Step struct {
+	Name    string            `yaml:"name" json:"name"`
+	Type    string            `yaml:"type" json:"type"`       // 步骤类型，需在引擎中注册执行器
+	Retries int               `yaml:"retries" json:"retries"` // 失败后的重试次数
+	Backoff time.Duration     `yaml:"backoff" json:"backoff"` // 首次重试间隔，之后每次翻倍
+	Timeout time.Duration     `yaml:"timeout" json:"timeout"` // 单次执行超时
+	Params  map[string]string `yaml:"params" json:"params,omitempty"`
+}
+
+// Pipeline 设备接入流水线定义
+type Pipeline struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	Steps       []Step `yaml:"steps" json:"steps"`
+}
+
+// file 流水线配置文件结构
+type file struct {
+	Pipelines []Pipeline `yaml:"pipelines"`
+}
+
+// Load 从YAML文件加载流水线定义，文件不存在时返回空列表
+func Load(path string) ([]Pipeline, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取流水线文件失败: %v", err)
+	}
+
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("解析流水线文件失败: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range f.Pipelines {
+		p := &f.Pipelines[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("第%d个流水线缺少 name", i+1)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("流水线 name 重复: %s", p.Name)
+		}
+		seen[p.Name] = true
+		if len(p.Steps) == 0 {
+			return nil, fmt.Errorf("流水线 %s 没有步骤", p.Name)
+		}
+		for j := range p.Steps {
+			s := &p.Steps[j]
+			if s.Type == "" {
+				return nil, fmt.Errorf("流水线 %s 第%d个步骤缺少 type", p.Name, j+1)
+			}
+			if s.Name == "" {
+				s.Name = s.Type
+			}
+			if s.Timeout <= 0 {
+				s.Timeout = defaultStepTimeout
+			}
+			if s.Backoff <= 0 {
+				s.Backoff = defaultBackoff
+			}
+		}
+	}
+	return f.Pipelines, nil
+}
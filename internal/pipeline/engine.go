@@ -0,0 +1,400 @@
+// internal/pipeline/engine.go
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxRuns 保留的运行记录数，超出后丢弃最早结束的记录
+const maxRuns = 200
+
+var pipelineRuns = metrics.NewCounterVec("tp_plugin_pipeline_runs_total",
+	"设备接入流水线运行次数", "pipeline", "result")
+
+// 运行及步骤状态
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+)
+
+// ErrRunning 同一设备已有运行中的流水线
+var ErrRunning = errors.New("该设备已有运行中的接入流水线")
+
+// StepFunc 步骤执行器，返回错误时按步骤配置重试
+type StepFunc func(ctx context.Context, run *Run, params map[string]string) error
+
+// Input 启动流水线的参数
+type Input struct {
+	Voucher      string            `json:"voucher"`
+	DeviceNumber string            `json:"device_number"`
+	Vars         map[string]string `json:"vars,omitempty"`
+}
+
+// StepStatus 步骤执行状态
+type StepStatus struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	State      string     `json:"state"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// RunStatus 流水线运行状态快照，不包含凭证
+type RunStatus struct {
+	ID           string            `json:"id"`
+	Pipeline     string            `json:"pipeline"`
+	DeviceNumber string            `json:"device_number"`
+	State        string            `json:"state"`
+	Error        string            `json:"error,omitempty"`
+	StartedAt    time.Time         `json:"started_at"`
+	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
+	Vars         map[string]string `json:"vars,omitempty"`
+	Steps        []StepStatus      `json:"steps"`
+}
+
+// Run 一次流水线运行，供步骤执行器读取输入和传递输出
+type Run struct {
+	Voucher      string
+	DeviceNumber string
+	StartedAt    time.Time
+
+	mu     sync.Mutex
+	status RunStatus
+	cancel context.CancelFunc
+}
+
+// Get 读取运行变量
+func (r *Run) Get(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Vars[key]
+}
+
+// Set 写入运行变量，后续步骤的参数可通过 ${key} 引用
+func (r *Run) Set(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Vars[key] = value
+}
+
+// ID 运行ID
+func (r *Run) ID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.ID
+}
+
+func (r *Run) snapshot() RunStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	s.Vars = make(map[string]string, len(r.status.Vars))
+	for k, v := range r.status.Vars {
+		s.Vars[k] = v
+	}
+	s.Steps = append([]StepStatus(nil), r.status.Steps...)
+	return s
+}
+
+func (r *Run) update(fn func(s *RunStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.status)
+}
+
+// Engine 设备接入流水线引擎
+// 流水线异步执行，步骤按顺序运行，失败时按步骤配置的次数和间隔重试
+type Engine struct {
+	pipelines map[string]Pipeline
+	steps     map[string]StepFunc
+	logger    *logrus.Logger
+
+	mu     sync.Mutex
+	runs   map[string]*Run
+	active map[string]string // 设备编号 → 运行中的运行ID
+	wg     sync.WaitGroup
+}
+
+// NewEngine 创建引擎，步骤执行器需在启动流水线前通过 Register 注册
+func NewEngine(pipelines []Pipeline, logger *logrus.Logger) *Engine {
+	e := &Engine{
+		pipelines: make(map[string]Pipeline, len(pipelines)),
+		steps:     make(map[string]StepFunc),
+		logger:    logger,
+		runs:      make(map[string]*Run),
+		active:    make(map[string]string),
+	}
+	for _, p := range pipelines {
+		e.pipelines[p.Name] = p
+	}
+	return e
+}
+
+// Register 注册步骤执行器
+func (e *Engine) Register(stepType string, fn StepFunc) {
+	e.steps[stepType] = fn
+}
+
+// Validate 检查所有流水线的步骤类型均已注册
+func (e *Engine) Validate() error {
+	for _, p := range e.pipelines {
+		for _, s := range p.Steps {
+			if _, ok := e.steps[s.Type]; !ok {
+				return fmt.Errorf("流水线 %s 的步骤 %s 类型未知: %s", p.Name, s.Name, s.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// Pipelines 返回全部流水线定义
+func (e *Engine) Pipelines() []Pipeline {
+	list := make([]Pipeline, 0, len(e.pipelines))
+	for _, p := range e.pipelines {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Start 异步启动流水线，同一设备同时只能运行一条流水线
+func (e *Engine) Start(name string, input Input) (RunStatus, error) {
+	p, ok := e.pipelines[name]
+	if !ok {
+		return RunStatus{}, fmt.Errorf("流水线不存在: %s", name)
+	}
+	if input.DeviceNumber == "" {
+		return RunStatus{}, errors.New("缺少设备编号")
+	}
+
+	e.mu.Lock()
+	if _, busy := e.active[input.DeviceNumber]; busy {
+		e.mu.Unlock()
+		return RunStatus{}, ErrRunning
+	}
+	vars := map[string]string{"device_number": input.DeviceNumber}
+	for k, v := range input.Vars {
+		vars[k] = v
+	}
+	steps := make([]StepStatus, len(p.Steps))
+	for i, s := range p.Steps {
+		steps[i] = StepStatus{Name: s.Name, Type: s.Type, State: StatePending}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &Run{
+		Voucher:      input.Voucher,
+		DeviceNumber: input.DeviceNumber,
+		StartedAt:    time.Now(),
+		cancel:       cancel,
+	}
+	run.status = RunStatus{
+		ID:           newRunID(),
+		Pipeline:     p.Name,
+		DeviceNumber: input.DeviceNumber,
+		State:        StateRunning,
+		StartedAt:    run.StartedAt,
+		Vars:         vars,
+		Steps:        steps,
+	}
+	e.runs[run.status.ID] = run
+	e.active[input.DeviceNumber] = run.status.ID
+	e.pruneLocked()
+	e.mu.Unlock()
+
+	e.wg.Add(1)
+	go e.execute(ctx, p, run)
+	return run.snapshot(), nil
+}
+
+// execute 依次执行步骤，任一步骤重试耗尽后终止
+func (e *Engine) execute(ctx context.Context, p Pipeline, run *Run) {
+	defer e.wg.Done()
+	defer run.cancel()
+	log := e.logger.WithFields(logrus.Fields{
+		"pipeline":      p.Name,
+		"run_id":        run.status.ID,
+		"device_number": run.DeviceNumber,
+	})
+	log.Info("设备接入流水线开始")
+
+	var runErr error
+	for i, step := range p.Steps {
+		if runErr = e.executeStep(ctx, run, i, step); runErr != nil {
+			log.WithError(runErr).WithField("step", step.Name).Warn("设备接入流水线步骤失败")
+			break
+		}
+	}
+
+	state := StateSucceeded
+	switch {
+	case runErr == nil:
+	case ctx.Err() != nil:
+		state = StateCanceled
+	default:
+		state = StateFailed
+	}
+	now := time.Now()
+	run.update(func(s *RunStatus) {
+		s.State = state
+		s.FinishedAt = &now
+		if runErr != nil {
+			s.Error = runErr.Error()
+		}
+	})
+	pipelineRuns.WithLabelValues(p.Name, state).Inc()
+
+	e.mu.Lock()
+	delete(e.active, run.DeviceNumber)
+	e.mu.Unlock()
+	log.WithField("state", state).Info("设备接入流水线结束")
+}
+
+func (e *Engine) executeStep(ctx context.Context, run *Run, index int, step Step) error {
+	fn := e.steps[step.Type]
+	if fn == nil {
+		return fmt.Errorf("步骤类型未知: %s", step.Type)
+	}
+	started := time.Now()
+	run.update(func(s *RunStatus) {
+		s.Steps[index].State = StateRunning
+		s.Steps[index].StartedAt = &started
+	})
+
+	backoff := step.Backoff
+	var err error
+	for attempt := 1; attempt <= step.Retries+1; attempt++ {
+		params := expandParams(step.Params, run)
+		stepCtx, cancel := context.WithTimeout(ctx, step.Timeout)
+		err = fn(stepCtx, run, params)
+		cancel()
+		run.update(func(s *RunStatus) {
+			s.Steps[index].Attempts = attempt
+			s.Steps[index].Error = ""
+			if err != nil {
+				s.Steps[index].Error = err.Error()
+			}
+		})
+		if err == nil || attempt > step.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(backoff):
+			backoff *= 2
+			continue
+		}
+		break
+	}
+
+	finished := time.Now()
+	state := StateSucceeded
+	if err != nil {
+		state = StateFailed
+	}
+	run.update(func(s *RunStatus) {
+		s.Steps[index].State = state
+		s.Steps[index].FinishedAt = &finished
+	})
+	if err != nil {
+		return fmt.Errorf("步骤 %s 失败: %v", step.Name, err)
+	}
+	return nil
+}
+
+// expandParams 将参数中的 ${name} 替换为运行变量
+func expandParams(params map[string]string, run *Run) map[string]string {
+	out := make(map[string]string, len(params))
+	for k, v := range params {
+		out[k] = os.Expand(v, run.Get)
+	}
+	return out
+}
+
+// Get 按运行ID查询状态
+func (e *Engine) Get(id string) (RunStatus, bool) {
+	e.mu.Lock()
+	run, ok := e.runs[id]
+	e.mu.Unlock()
+	if !ok {
+		return RunStatus{}, false
+	}
+	return run.snapshot(), true
+}
+
+// Runs 返回运行记录，按开始时间倒序
+func (e *Engine) Runs() []RunStatus {
+	e.mu.Lock()
+	list := make([]RunStatus, 0, len(e.runs))
+	for _, run := range e.runs {
+		list = append(list, run.snapshot())
+	}
+	e.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.After(list[j].StartedAt) })
+	return list
+}
+
+// Cancel 取消运行中的流水线
+func (e *Engine) Cancel(id string) bool {
+	e.mu.Lock()
+	run, ok := e.runs[id]
+	e.mu.Unlock()
+	if !ok {
+		return false
+	}
+	run.cancel()
+	return true
+}
+
+// Close 取消全部运行中的流水线并等待结束
+func (e *Engine) Close() {
+	e.mu.Lock()
+	for _, run := range e.runs {
+		run.cancel()
+	}
+	e.mu.Unlock()
+	e.wg.Wait()
+}
+
+// pruneLocked 运行记录超出上限时丢弃最早结束的记录，调用方需持有 e.mu
+func (e *Engine) pruneLocked() {
+	if len(e.runs) <= maxRuns {
+		return
+	}
+	var oldestID string
+	var oldest time.Time
+	for id, run := range e.runs {
+		s := run.snapshot()
+		if s.FinishedAt == nil {
+			continue
+		}
+		if oldestID == "" || s.FinishedAt.Before(oldest) {
+			oldestID, oldest = id, *s.FinishedAt
+		}
+	}
+	if oldestID != "" {
+		delete(e.runs, oldestID)
+	}
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
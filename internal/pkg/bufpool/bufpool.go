@@ -0,0 +1,41 @@
+package bufpool
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// maxPooledSize 超过该大小的缓冲区不放回池中，避免偶发的大报文长期占用内存
+const maxPooledSize = 4 << 20
+
+var pool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Get 从池中获取一个已清空的缓冲区，使用完毕后需调用 Put 归还
+func Get() *bytes.Buffer {
+	buf := pool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// Put 归还缓冲区，归还后不得再引用其内容
+func Put(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledSize {
+		return
+	}
+	pool.Put(buf)
+}
+
+// EncodeJSON 将 v 编码到池化缓冲区，输出与 json.Marshal 一致(不含结尾换行)
+// 调用方使用完毕后需调用 Put 归还
+func EncodeJSON(v interface{}) (*bytes.Buffer, error) {
+	buf := Get()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		Put(buf)
+		return nil, err
+	}
+	buf.Truncate(buf.Len() - 1)
+	return buf, nil
+}
@@ -0,0 +1,154 @@
+// internal/pkg/listen/listen.go
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 监听地址前缀
+const (
+	UnixPrefix = "unix:"   // unix:/run/tp-plugin/http.sock
+	Systemd    = "systemd" // systemd 或 systemd:<FileDescriptorName>
+)
+
+// listenFdsStart systemd 传入的第一个文件描述符
+const listenFdsStart = 3
+
+// Validate 校验监听地址格式：host:port、unix:<路径>、systemd 或 systemd:<名称>
+func Validate(addr string) error {
+	switch {
+	case addr == Systemd || strings.HasPrefix(addr, Systemd+":"):
+		return nil
+	case strings.HasPrefix(addr, UnixPrefix):
+		if strings.TrimPrefix(addr, UnixPrefix) == "" {
+			return errors.New("缺少套接字路径")
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("须为 host:port、unix:<路径> 或 systemd: %s", addr)
+	}
+	return nil
+}
+
+// TCP 监听的网络类型
+const (
+	TCP  = "tcp"  // IPv4/IPv6 双栈
+	TCP4 = "tcp4" // 只监听 IPv4
+	TCP6 = "tcp6" // 只监听 IPv6
+)
+
+// ValidateNetwork 校验网络类型，空字符串视为 tcp
+func ValidateNetwork(network string) error {
+	switch network {
+	case "", TCP, TCP4, TCP6:
+		return nil
+	}
+	return fmt.Errorf("须为 %s、%s 或 %s: %s", TCP, TCP4, TCP6, network)
+}
+
+// Hosts 在 hosts 的每个地址上监听 port，hosts 为空时监听全部网卡。network 为 tcp 时通配地址同时接受
+// IPv4 和 IPv6 连接(双栈)，tcp4/tcp6 只接受对应协议；多个地址的连接合并由一个 net.Listener 接受
+func Hosts(network string, hosts []string, port int) (net.Listener, error) {
+	if network == "" {
+		network = TCP
+	}
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	list := make([]net.Listener, 0, len(hosts))
+	for _, host := range hosts {
+		addr := net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			for _, opened := range list {
+				opened.Close()
+			}
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	return newMulti(list), nil
+}
+
+// Listen 按地址创建监听。unix: 地址创建 Unix 套接字，mode 不为0时设置文件权限，
+// 已存在且无进程监听的套接字文件会先删除；systemd 地址使用 systemd 套接字激活传入的套接字
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	switch {
+	case addr == Systemd:
+		return systemdListener("")
+	case strings.HasPrefix(addr, Systemd+":"):
+		return systemdListener(strings.TrimPrefix(addr, Systemd+":"))
+	case strings.HasPrefix(addr, UnixPrefix):
+		return unixListener(strings.TrimPrefix(addr, UnixPrefix), mode)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func unixListener(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是套接字文件", path)
+		}
+		// 上次退出时未删除的套接字文件，仍有进程监听时不删除
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("套接字 %s 已被其他进程监听", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("删除旧套接字文件失败: %v", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("设置套接字文件权限失败: %v", err)
+		}
+	}
+	return l, nil
+}
+
+// systemdListener 返回 systemd 传入的套接字(LISTEN_PID/LISTEN_FDS/LISTEN_FDNAMES)，
+// name 为空时使用第一个，否则使用 FileDescriptorName 与之相同的套接字
+func systemdListener(name string) (net.Listener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, errors.New("插件不是由 systemd 套接字激活启动的(LISTEN_PID 不匹配)")
+	}
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if n <= 0 {
+		return nil, errors.New("systemd 未传入套接字(LISTEN_FDS)")
+	}
+	idx := 0
+	if name != "" {
+		idx = -1
+		for i, fdName := range strings.Split(os.Getenv("LISTEN_FDNAMES"), ":") {
+			if fdName == name && i < n {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("systemd 未传入名为 %s 的套接字", name)
+		}
+	}
+	f := os.NewFile(uintptr(listenFdsStart+idx), "systemd:"+name)
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("使用 systemd 套接字失败: %v", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,96 @@
+// internal/pkg/listen/multi.go
+package listen
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// multiListener 将多个监听地址的连接合并为一个 net.Listener
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan accepted
+	closed    chan struct{}
+	once      sync.Once
+}
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+func newMulti(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		conns:     make(chan accepted),
+		closed:    make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go m.acceptLoop(l)
+	}
+	return m
+}
+
+func (m *multiListener) acceptLoop(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		select {
+		case m.conns <- accepted{conn: c, err: err}:
+		case <-m.closed:
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// Accept 返回任一地址上的下一个连接
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case a := <-m.conns:
+		return a.conn, a.err
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭全部监听
+func (m *multiListener) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.closed)
+		for _, l := range m.listeners {
+			if e := l.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Addr 返回全部监听地址，String 以逗号分隔
+func (m *multiListener) Addr() net.Addr {
+	addrs := make(addrList, len(m.listeners))
+	for i, l := range m.listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+type addrList []net.Addr
+
+func (a addrList) Network() string { return a[0].Network() }
+
+func (a addrList) String() string {
+	s := make([]string, len(a))
+	for i, addr := range a {
+		s[i] = addr.String()
+	}
+	return strings.Join(s, ",")
+}
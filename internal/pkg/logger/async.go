@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"tp-plugin/internal/metrics"
+)
+
+var logsDropped = metrics.NewCounterVec("tp_plugin_log_dropped_total",
+	"异步日志队列溢出或关闭后丢弃的日志条数")
+
+// AsyncWriter 异步缓冲日志写入器
+// Write 只负责把日志放入队列，由后台协程写入底层 Writer；队列满时直接丢弃并计数，
+// 保证磁盘或网络日志端缓慢时不会阻塞请求处理
+type AsyncWriter struct {
+	out     io.Writer
+	queue   chan []byte
+	dropped atomic.Uint64
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// NewAsyncWriter 创建异步写入器，bufferSize 为队列可容纳的日志条数
+func NewAsyncWriter(out io.Writer, bufferSize int) *AsyncWriter {
+	if bufferSize <= 0 {
+		bufferSize = 1024
+	}
+	w := &AsyncWriter{
+		out:   out,
+		queue: make(chan []byte, bufferSize),
+		done:  make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// Write 实现 io.Writer 接口，永不阻塞
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	// logrus 会复用缓冲区，这里必须拷贝
+	buf := make([]byte, len(p))
+	copy(buf, p)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.drop()
+		return len(p), nil
+	}
+	select {
+	case w.queue <- buf:
+	default:
+		w.drop()
+	}
+	return len(p), nil
+}
+
+func (w *AsyncWriter) drop() {
+	w.dropped.Add(1)
+	logsDropped.WithLabelValues().Inc()
+}
+
+// Dropped 返回因队列溢出而丢弃的日志条数
+func (w *AsyncWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Close 停止接收新日志并等待队列中的日志写完
+func (w *AsyncWriter) Close() error {
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.queue)
+		w.mu.Unlock()
+	})
+	<-w.done
+	return nil
+}
+
+func (w *AsyncWriter) loop() {
+	defer close(w.done)
+	var reported uint64
+	for p := range w.queue {
+		// 有新的丢弃时在日志流中留下痕迹，便于排查
+		if d := w.dropped.Load(); d != reported {
+			_, _ = w.out.Write([]byte("[logger] 异步日志队列溢出，累计丢弃 " + strconv.FormatUint(d, 10) + " 条\n"))
+			reported = d
+		}
+		_, _ = w.out.Write(p)
+	}
+}
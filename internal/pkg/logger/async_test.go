@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"tp-plugin/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// blockingWriter 在 release 关闭前阻塞写入，用于填满异步队列
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestAsyncWriterDropsWhenFull(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	w := NewAsyncWriter(out, 1)
+	before := logsDropped.WithLabelValues().Get()
+	// 后台协程最多取走一条阻塞在写入上，队列再容纳一条，其余丢弃
+	for i := 0; i < 10; i++ {
+		w.Write([]byte("line\n"))
+	}
+	if d := w.Dropped(); d < 8 {
+		t.Fatalf("丢弃 %d 条，期望至少8条", d)
+	}
+	if got := logsDropped.WithLabelValues().Get() - before; got != float64(w.Dropped()) {
+		t.Fatalf("指标计入 %v 条，写入器丢弃 %d 条", got, w.Dropped())
+	}
+	close(out.release)
+	w.Close()
+	if !strings.Contains(out.buf.String(), "累计丢弃") {
+		t.Fatalf("日志流中没有丢弃提示: %q", out.buf.String())
+	}
+}
+
+func TestCloseKeepsLaterLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.log")
+	InitLogger(&config.LogConfig{Level: "info", FilePath: path, Async: true, BufferSize: 16})
+	t.Cleanup(func() {
+		asyncWriter = nil
+		logrus.SetOutput(os.Stderr)
+	})
+	logrus.Info("关闭前的日志")
+	Close()
+	// 退出前的致命错误在关闭异步写入器之后记录
+	logrus.Error("关闭后的日志")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"关闭前的日志", "关闭后的日志"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("日志文件缺少 %q: %s", want, data)
+		}
+	}
+}
@@ -114,6 +114,9 @@ func (f *CustomFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return []byte(logMessage + "\n"), nil
 }
 
+// asyncWriter 启用异步日志时的写入器
+var asyncWriter *AsyncWriter
+
 // InitLogger 初始化日志系统
 func InitLogger(cfg *config.LogConfig) {
 	// 1. 创建文件日志写入器
@@ -126,10 +129,14 @@ func InitLogger(cfg *config.LogConfig) {
 	}
 
 	// 2. 创建多重输出
-	multiWriter := io.MultiWriter(os.Stdout, fileLogger)
+	var output io.Writer = io.MultiWriter(os.Stdout, fileLogger)
 
-	// 3. 设置日志输出
-	logrus.SetOutput(multiWriter)
+	// 3. 设置日志输出，启用异步时由后台协程落盘
+	if cfg.Async {
+		asyncWriter = NewAsyncWriter(output, cfg.BufferSize)
+		output = asyncWriter
+	}
+	logrus.SetOutput(output)
 
 	// 4. 启用调用者信息报告
 	logrus.SetReportCaller(true)
@@ -147,3 +154,11 @@ func InitLogger(cfg *config.LogConfig) {
 	}
 	logrus.SetLevel(level)
 }
+
+// Close 刷新并关闭异步日志写入器，之后的日志(如退出前的致命错误)改为同步写入，不会丢失
+func Close() {
+	if asyncWriter != nil {
+		logrus.SetOutput(asyncWriter.out)
+		asyncWriter.Close()
+	}
+}
@@ -0,0 +1,125 @@
+// internal/pkg/redact/redact.go
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mask 替换敏感字段值的占位符
+const Mask = "***"
+
+// sensitive 字段名(不区分大小写)包含这些片段时视为敏感字段，如凭证中的 Secret、ThingsPanelApiKey，请求头 x-token
+var sensitive = []string{"secret", "apikey", "api_key", "api-key", "password", "passwd", "token", "authorization", "cookie", "credential"}
+
+// Key 字段名或请求头名称是否为敏感字段
+func Key(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitive {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON 返回隐藏敏感字段值后的JSON；值为JSON对象或数组的字符串字段(如平台传入的 voucher)同样处理。
+// 不是合法JSON时返回 ok=false，调用方不应记录原文
+func JSON(data []byte) (out []byte, ok bool) {
+	v, ok := decode(data)
+	if !ok {
+		return nil, false
+	}
+	out, err := marshal(value(v))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// String 返回隐藏敏感字段值后的JSON字符串，不是JSON时原样返回(用于日志中本身不含凭证的文本)
+func String(s string) string {
+	if out, ok := JSON([]byte(s)); ok {
+		return string(out)
+	}
+	return s
+}
+
+// Header 返回隐藏敏感请求头值后的副本
+func Header(h http.Header) http.Header {
+	out := h.Clone()
+	for k := range out {
+		if Key(k) {
+			out[k] = []string{Mask}
+		}
+	}
+	return out
+}
+
+// URL 返回隐藏敏感查询参数值后的地址
+func URL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	q := u.Query()
+	for k := range q {
+		if Key(k) {
+			q[k] = []string{Mask}
+		}
+	}
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+func decode(data []byte) (interface{}, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+// marshal 与 json.Marshal 相同但不转义HTML字符，便于阅读日志
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, x := range t {
+			if Key(k) {
+				if x != nil && x != "" {
+					t[k] = Mask
+				}
+				continue
+			}
+			t[k] = value(x)
+		}
+	case []interface{}:
+		for i, x := range t {
+			t[i] = value(x)
+		}
+	case string:
+		if s := strings.TrimSpace(t); strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+			if inner, ok := decode([]byte(s)); ok {
+				if out, err := marshal(value(inner)); err == nil {
+					return string(out)
+				}
+			}
+		}
+	}
+	return v
+}
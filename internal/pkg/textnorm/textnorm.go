@@ -0,0 +1,73 @@
+// internal/pkg/textnorm/textnorm.go
+package textnorm
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ellipsis 截断时追加的省略号，计入长度限制
+const Ellipsis = "…"
+
+// Options 规范化选项
+type Options struct {
+	MaxRunes           int  // 最大字符数(按Unicode码点计)，0为不截断
+	StripSupplementary bool // 去掉BMP之外的字符(多数emoji)，用于只支持3字节UTF-8的存储
+}
+
+// Clean 规范化文本：非法UTF-8替换为U+FFFD，换行和制表符替换为空格，去掉其他控制字符和格式字符(如零宽字符)，
+// 去掉首尾空白，超出长度时截断并追加省略号。第二个返回值表示文本是否被修改
+func Clean(s string, opts Options) (string, bool) {
+	if clean(s, opts) {
+		return s, false
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range strings.ToValidUTF8(s, string(utf8.RuneError)) {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+		case opts.StripSupplementary && r > 0xFFFF:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	out := strings.TrimSpace(b.String())
+	if opts.MaxRunes > 0 && utf8.RuneCountInString(out) > opts.MaxRunes {
+		out = truncate(out, opts.MaxRunes)
+	}
+	return out, out != s
+}
+
+// clean 快速判断文本是否无需处理，常见的短ASCII/中文文本不分配内存
+func clean(s string, opts Options) bool {
+	if !utf8.ValidString(s) || strings.TrimSpace(s) != s {
+		return false
+	}
+	n := 0
+	for _, r := range s {
+		n++
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || (opts.StripSupplementary && r > 0xFFFF) {
+			return false
+		}
+	}
+	return opts.MaxRunes <= 0 || n <= opts.MaxRunes
+}
+
+// truncate 截断到 max 个字符(含省略号)
+func truncate(s string, max int) string {
+	keep := max - utf8.RuneCountInString(Ellipsis)
+	if keep <= 0 {
+		return string([]rune(s)[:max])
+	}
+	i := 0
+	for pos := range s {
+		if i == keep {
+			return strings.TrimRightFunc(s[:pos], unicode.IsSpace) + Ellipsis
+		}
+		i++
+	}
+	return s
+}
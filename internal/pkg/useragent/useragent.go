@@ -0,0 +1,63 @@
+// internal/pkg/useragent/useragent.go
+package useragent
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// InstanceHeader 标识插件实例的请求头，便于对端排查问题及按实例限流
+const InstanceHeader = "X-Plugin-Instance"
+
+// Identity 插件对外请求使用的身份信息
+type Identity struct {
+	Name      string // 插件名称
+	Version   string // 插件版本
+	Instance  string // 实例标识，为空时使用主机名
+	UserAgent string // 完整的 User-Agent，为空时按名称/版本/实例生成
+}
+
+var (
+	mu      sync.RWMutex
+	current = Identity{Name: "tp-plugin", Version: "dev"}
+)
+
+// Set 设置进程级身份信息，启动时调用一次
+func Set(id Identity) {
+	if id.Name == "" {
+		id.Name = "tp-plugin"
+	}
+	if id.Version == "" {
+		id.Version = "dev"
+	}
+	if id.Instance == "" {
+		id.Instance, _ = os.Hostname()
+	}
+	if id.UserAgent == "" {
+		id.UserAgent = fmt.Sprintf("%s/%s (instance=%s; %s/%s)", id.Name, id.Version, id.Instance, runtime.GOOS, runtime.GOARCH)
+	}
+	mu.Lock()
+	current = id
+	mu.Unlock()
+}
+
+// Get 返回当前身份信息
+func Get() Identity {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Apply 为出站请求设置 User-Agent 及实例请求头
+func Apply(req *http.Request) {
+	id := Get()
+	if id.UserAgent != "" {
+		req.Header.Set("User-Agent", id.UserAgent)
+	}
+	if id.Instance != "" {
+		req.Header.Set(InstanceHeader, id.Instance)
+	}
+}
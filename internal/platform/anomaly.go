@@ -0,0 +1,61 @@
+// internal/platform/anomaly.go
+package platform
+
+import (
+	"time"
+	"tp-plugin/internal/forward"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AnomalyMethod 遥测偏离历史统计时发布的设备事件
+const AnomalyMethod = "telemetry_anomaly"
+
+var telemetryAnomalies = metrics.NewCounterVec("tp_plugin_telemetry_anomalies_total",
+	"检测到的遥测异常数", "key", "result")
+
+// detectAnomalies 按规则检测遥测异常，异常作为设备事件发布到平台，发布失败不影响遥测上报
+func (p *PlatformClient) detectAnomalies(deviceID string, values map[string]interface{}, ts time.Time) {
+	if p.anomaly == nil {
+		return
+	}
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	var deviceType string
+	if device, ok := p.devices.getByID(deviceID); ok {
+		deviceType = device.DeviceType
+	}
+	for _, a := range p.anomaly.Observe(deviceID, deviceType, values, ts) {
+		params := map[string]interface{}{
+			"key":       a.Key,
+			"value":     a.Value,
+			"mean":      a.Mean,
+			"std":       a.Std,
+			"score":     a.Score,
+			"method":    a.Method,
+			"threshold": a.Threshold,
+			"ts":        ts.UnixMilli(),
+		}
+		payload, err := encodeEvent(p.codecs.get(ClassEvent), deviceID, AnomalyMethod, params)
+		if err == nil {
+			err = p.publish("devices/event/"+newMessageID(), payload)
+		}
+		log := p.logger.WithFields(logrus.Fields{
+			"device_id": deviceID,
+			"key":       a.Key,
+			"value":     a.Value,
+			"mean":      a.Mean,
+			"score":     a.Score,
+		})
+		if err != nil {
+			telemetryAnomalies.WithLabelValues(a.Key, "error").Inc()
+			log.WithError(err).Warn("发布遥测异常事件失败")
+			continue
+		}
+		telemetryAnomalies.WithLabelValues(a.Key, "ok").Inc()
+		log.Info("检测到遥测异常")
+		p.forward(forward.EventEvent, deviceID, map[string]interface{}{"method": AnomalyMethod, "params": params}, ts)
+	}
+}
@@ -0,0 +1,214 @@
+// internal/platform/batch.go
+package platform
+
+import (
+	"errors"
+	"reflect"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/trace"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 批量上报默认参数
+const (
+	DefaultBatchSize         = 20
+	DefaultBatchInterval     = time.Second
+	DefaultBatchQueueSize    = 1024
+	DefaultBatchBlockTimeout = 2 * time.Second
+)
+
+// ErrTelemetryBacklogged 批量上报队列已满且等待超时，平台MQTT发送持续变慢时返回
+var ErrTelemetryBacklogged = errors.New("遥测上报队列已满，平台发送过慢")
+
+var (
+	batchFlushes = metrics.NewCounterVec("tp_plugin_telemetry_batches_total",
+		"批量上报发布的遥测消息数", "reason")
+	batchMeasurements = metrics.NewCounterVec("tp_plugin_telemetry_batch_measurements_total",
+		"进入批量上报的遥测数据条数", "result")
+	batchQueued = metrics.NewGaugeVec("tp_plugin_telemetry_batch_queued",
+		"等待合并发布的遥测数据条数")
+)
+
+// BatchConfig 遥测批量上报配置
+type BatchConfig struct {
+	Enabled      bool
+	MaxSize      int           // 单条消息合并的最多数据条数，达到后立即发布，0使用默认值20
+	Interval     time.Duration // 合并窗口，设备的第一条数据到达后超过该时长发布，0使用默认值1秒
+	QueueSize    int           // 等待合并的数据条数上限，0使用默认值1024
+	BlockTimeout time.Duration // 队列满时调用方最长等待时间，超时返回 ErrTelemetryBacklogged，0使用默认值2秒
+}
+
+// measurement 一条已处理待发布的遥测数据
+type measurement struct {
+	deviceID string
+	values   map[string]interface{}
+	ts       time.Time
+}
+
+// pendingBatch 一台设备正在合并的遥测数据
+type pendingBatch struct {
+	values  map[string]interface{}
+	ts      time.Time
+	count   int
+	started time.Time
+}
+
+// batcher 按设备和时间窗口合并遥测数据后发布到平台遥测主题。
+// 单个协程依次发布，平台发送变慢时队列积压，队列满后 SendTelemetry 阻塞调用方(设备会话)形成背压
+type batcher struct {
+	cfg    BatchConfig
+	queue  chan measurement
+	done   chan struct{}
+	logger *logrus.Logger
+}
+
+func newBatcher(cfg BatchConfig, logger *logrus.Logger) *batcher {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultBatchSize
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultBatchInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultBatchQueueSize
+	}
+	if cfg.BlockTimeout <= 0 {
+		cfg.BlockTimeout = DefaultBatchBlockTimeout
+	}
+	return &batcher{
+		cfg:    cfg,
+		queue:  make(chan measurement, cfg.QueueSize),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+}
+
+// TelemetryPressure 批量上报队列的占用比例(0~1)，未启用批量上报时为0
+func (p *PlatformClient) TelemetryPressure() float64 {
+	if p == nil || p.batch == nil {
+		return 0
+	}
+	return float64(len(p.batch.queue)) / float64(cap(p.batch.queue))
+}
+
+// ingest 将数据放入队列，队列满时最多等待 BlockTimeout
+func (b *batcher) ingest(m measurement, stop <-chan struct{}) error {
+	select {
+	case b.queue <- m:
+		batchQueued.WithLabelValues().Inc()
+		batchMeasurements.WithLabelValues("queued").Inc()
+		return nil
+	default:
+	}
+	timer := time.NewTimer(b.cfg.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case b.queue <- m:
+		batchQueued.WithLabelValues().Inc()
+		batchMeasurements.WithLabelValues("delayed").Inc()
+		return nil
+	case <-timer.C:
+	case <-stop:
+	}
+	batchMeasurements.WithLabelValues("rejected").Inc()
+	return ErrTelemetryBacklogged
+}
+
+// batchLoop 合并队列中的数据，批次达到条数上限或超过时间窗口时发布；
+// 客户端关闭时发布队列中剩余的数据
+func (p *PlatformClient) batchLoop() {
+	b := p.batch
+	defer close(b.done)
+	pending := make(map[string]*pendingBatch)
+	tick := b.cfg.Interval / 2
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-b.queue:
+			batchQueued.WithLabelValues().Dec()
+			p.mergeMeasurement(pending, m)
+		case now := <-ticker.C:
+			for id, pb := range pending {
+				if now.Sub(pb.started) >= b.cfg.Interval {
+					p.flushBatch(id, pb, "interval")
+					delete(pending, id)
+				}
+			}
+		case <-p.stopCh:
+		drain:
+			for {
+				select {
+				case m := <-b.queue:
+					batchQueued.WithLabelValues().Dec()
+					p.mergeMeasurement(pending, m)
+				default:
+					break drain
+				}
+			}
+			for id, pb := range pending {
+				p.flushBatch(id, pb, "shutdown")
+			}
+			return
+		}
+	}
+}
+
+// mergeMeasurement 将数据合并到设备的批次。新数据的原始采集时间与批次不同，或同一字段的值与批次不同时，
+// 先发布原批次，合并不会丢失数据或改变采集时间
+func (p *PlatformClient) mergeMeasurement(pending map[string]*pendingBatch, m measurement) {
+	pb := pending[m.deviceID]
+	if pb != nil && (!m.ts.Equal(pb.ts) || overlaps(pb.values, m.values)) {
+		p.flushBatch(m.deviceID, pb, "conflict")
+		pb = nil
+	}
+	if pb == nil {
+		pb = &pendingBatch{values: make(map[string]interface{}, len(m.values)), started: time.Now()}
+		pending[m.deviceID] = pb
+	}
+	for k, v := range m.values {
+		pb.values[k] = v
+	}
+	pb.ts = m.ts
+	pb.count++
+	if pb.count >= p.batch.cfg.MaxSize {
+		p.flushBatch(m.deviceID, pb, "size")
+		delete(pending, m.deviceID)
+	}
+}
+
+// overlaps 两组数据是否有值不同的相同字段
+func overlaps(a, b map[string]interface{}) bool {
+	for k, v := range b {
+		if old, ok := a[k]; ok && !reflect.DeepEqual(old, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushBatch 编码并发布一个批次，发送失败时与单条上报一样写入磁盘队列
+func (p *PlatformClient) flushBatch(deviceID string, pb *pendingBatch, reason string) {
+	payload, err := encodeTelemetry(p.codecs.get(ClassTelemetry), deviceID, pb.values, pb.ts)
+	if err != nil {
+		p.logger.WithError(err).WithField("device_id", deviceID).Warn("编码批量遥测数据失败")
+		return
+	}
+	batchFlushes.WithLabelValues(reason).Inc()
+	p.tracer.Record(p.DeviceNumber(deviceID), trace.Out, "telemetry", payload)
+	if err := p.publishTelemetry(deviceID, payload); err != nil {
+		p.healthError(deviceID)
+		p.logger.WithError(err).WithField("device_id", deviceID).Warn("批量遥测数据发送失败")
+		return
+	}
+	p.logger.WithFields(logrus.Fields{
+		"device_id": deviceID,
+		"count":     pb.count,
+		"reason":    reason,
+	}).Debug("批量遥测数据发送成功")
+}
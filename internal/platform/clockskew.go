@@ -0,0 +1,102 @@
+// internal/platform/clockskew.go
+package platform
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ClockSkewKey 时钟偏差(秒)的遥测字段，正数表示设备时钟超前
+const ClockSkewKey = "clock_skew"
+
+// 时钟偏差估计的采样窗口及每台设备保留的样本数
+const (
+	skewWindow     = 5 * time.Minute
+	skewMaxSamples = 32
+)
+
+type skewSample struct {
+	offset time.Duration // 设备时间 - 到达时间
+	at     time.Time
+}
+
+// skewState 单台设备的时钟偏差估计
+// 补传的历史数据只会让偏移量更小，窗口内最大的偏移量最接近实时数据，以此作为偏差估计
+type skewState struct {
+	mu      sync.Mutex
+	samples []skewSample
+	flagged bool // 上一次估计是否超出阈值
+}
+
+// observe 记录一个样本并返回当前的偏差估计
+func (s *skewState) observe(offset time.Duration, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.samples[:0]
+	for _, sample := range s.samples {
+		if now.Sub(sample.at) <= skewWindow {
+			kept = append(kept, sample)
+		}
+	}
+	if len(kept) >= skewMaxSamples {
+		kept = kept[1:]
+	}
+	s.samples = append(kept, skewSample{offset: offset, at: now})
+
+	skew := s.samples[0].offset
+	for _, sample := range s.samples[1:] {
+		if sample.offset > skew {
+			skew = sample.offset
+		}
+	}
+	return skew
+}
+
+// setFlagged 更新是否超出阈值，返回状态是否变化
+func (s *skewState) setFlagged(flagged bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.flagged != flagged
+	s.flagged = flagged
+	return changed
+}
+
+// checkClockSkew 估计设备的时钟偏差，超出阈值时在遥测中写入 clock_skew，
+// 开启校正且设备时钟超前时返回扣除偏差后的时间；偏差恢复到阈值内时发送一次 clock_skew=0
+func (p *PlatformClient) checkClockSkew(deviceID string, ts time.Time, values map[string]interface{}) time.Time {
+	threshold := time.Duration(p.telemetry.SkewThresholdSeconds) * time.Second
+	if threshold <= 0 {
+		return ts
+	}
+	now := time.Now()
+	v, _ := p.skews.LoadOrStore(deviceID, &skewState{})
+	state := v.(*skewState)
+	skew := state.observe(ts.Sub(now), now)
+
+	exceeded := skew > threshold || skew < -threshold
+	if state.setFlagged(exceeded) {
+		log := p.logger.WithFields(logrus.Fields{
+			"device_id":  deviceID,
+			"clock_skew": skew.Round(time.Second).String(),
+		})
+		if exceeded {
+			log.Warn("设备时钟偏差超出阈值")
+		} else {
+			log.Info("设备时钟偏差已恢复")
+			values[ClockSkewKey] = 0
+		}
+	}
+	if !exceeded {
+		return ts
+	}
+	values[ClockSkewKey] = int64(skew.Round(time.Second) / time.Second)
+	// 设备时钟滞后与补传历史数据无法区分，只校正超前的偏差，滞后时仅标记
+	if p.telemetry.SkewCorrect && skew > 0 {
+		telemetryTimestamps.WithLabelValues("skew_corrected").Inc()
+		return ts.Add(-skew)
+	}
+	telemetryTimestamps.WithLabelValues("skew_flagged").Inc()
+	return ts
+}
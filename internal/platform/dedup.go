@@ -0,0 +1,73 @@
+// internal/platform/dedup.go
+package platform
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+)
+
+// dedupHistory 每台设备记住的最近遥测内容数，固件重试与新数据交替到达时也能识别
+const dedupHistory = 8
+
+var telemetryDuplicates = metrics.NewCounterVec("tp_plugin_telemetry_duplicates_total",
+	"窗口内内容完全相同而未发布的遥测消息数")
+
+// dedupEntry 一条最近收到的遥测内容摘要
+type dedupEntry struct {
+	sum [sha256.Size]byte
+	at  time.Time
+}
+
+// dedupState 一台设备最近收到的遥测内容
+type dedupState struct {
+	mu      sync.Mutex
+	entries []dedupEntry
+}
+
+// seen 窗口内收到过相同内容时返回true，否则记录本次内容
+func (s *dedupState) seen(sum [sha256.Size]byte, now time.Time, window time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.entries[:0]
+	dup := false
+	for _, e := range s.entries {
+		if now.Sub(e.at) > window {
+			continue
+		}
+		if e.sum == sum {
+			dup = true
+		}
+		kept = append(kept, e)
+	}
+	s.entries = kept
+	if dup {
+		return true
+	}
+	if len(s.entries) >= dedupHistory {
+		s.entries = append(s.entries[:0], s.entries[1:]...)
+	}
+	s.entries = append(s.entries, dedupEntry{sum: sum, at: now})
+	return false
+}
+
+// duplicate 配置了去重窗口时，判断设备是否在窗口内上报过内容完全相同的遥测(固件重试导致的重复发送)。
+// 内容按字段排序后的JSON计算摘要，ts、序号等字段不同即视为不同的数据
+func (p *PlatformClient) duplicate(deviceID string, values map[string]interface{}) bool {
+	window := time.Duration(p.telemetry.DedupSeconds) * time.Second
+	if window <= 0 {
+		return false
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return false
+	}
+	v, _ := p.dedup.LoadOrStore(deviceID, &dedupState{})
+	if !v.(*dedupState).seen(sha256.Sum256(data), time.Now(), window) {
+		return false
+	}
+	telemetryDuplicates.WithLabelValues().Inc()
+	return true
+}
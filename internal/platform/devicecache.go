@@ -0,0 +1,143 @@
+package platform
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/types"
+)
+
+// deviceCache 并发安全的设备缓存
+// 按设备编号存储并维护设备ID索引；读取返回副本，调用方修改返回值不会影响缓存
+type deviceCache struct {
+	mu       sync.RWMutex
+	byNumber map[string]*types.Device
+	byID     map[string]string    // 设备ID -> 设备编号
+	cachedAt map[string]time.Time // 设备编号 -> 从平台获取的时间
+	size     int                  // 最大条数，0为不限制
+	ttl      time.Duration        // 有效期，过期后按编号读取视为未命中，0为不过期
+	gen      uint64               // 每次删除递增，用于丢弃删除前发起的回源结果
+}
+
+func newDeviceCache(size int, ttl time.Duration) *deviceCache {
+	return &deviceCache{
+		byNumber: make(map[string]*types.Device),
+		byID:     make(map[string]string),
+		cachedAt: make(map[string]time.Time),
+		size:     size,
+		ttl:      ttl,
+	}
+}
+
+// expired 判断从平台获取的时间是否已超过有效期
+func (c *deviceCache) expired(at time.Time) bool {
+	return c.ttl > 0 && time.Since(at) > c.ttl
+}
+
+// get 按设备编号读取，过期的条目视为未命中(由回源结果覆盖)
+func (c *deviceCache) get(number string) (*types.Device, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	d, ok := c.byNumber[number]
+	if !ok || c.expired(c.cachedAt[number]) {
+		return nil, false
+	}
+	cp := *d
+	return &cp, true
+}
+
+// getByID 按设备ID读取，用于设备ID到编号的转换，过期的条目仍返回
+func (c *deviceCache) getByID(id string) (*types.Device, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	number, ok := c.byID[id]
+	if !ok {
+		return nil, false
+	}
+	cp := *c.byNumber[number]
+	return &cp, true
+}
+
+// generation 返回当前删除代数，回源前获取，写回时传给 putIfUnchanged
+func (c *deviceCache) generation() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.gen
+}
+
+// putIfUnchanged 写入设备，期间发生过删除时放弃写入，避免断开后又被旧的回源结果回填
+func (c *deviceCache) putIfUnchanged(number string, d types.Device, gen uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return false
+	}
+	c.putLocked(number, d, time.Now())
+	return true
+}
+
+// putAt 写入从本地存储预热的设备，保留原获取时间
+func (c *deviceCache) putAt(number string, d types.Device, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.putLocked(number, d, at)
+}
+
+func (c *deviceCache) putLocked(number string, d types.Device, at time.Time) {
+	if old, ok := c.byNumber[number]; ok {
+		delete(c.byID, old.ID)
+	} else if c.size > 0 && len(c.byNumber) >= c.size {
+		// 达到上限时随机淘汰一条
+		for key, old := range c.byNumber {
+			c.removeLocked(key, old)
+			break
+		}
+	}
+	if d.ID != "" {
+		// 同一设备ID更换了设备编号时移除旧条目
+		if other, ok := c.byID[d.ID]; ok && other != number {
+			c.removeLocked(other, c.byNumber[other])
+		}
+		c.byID[d.ID] = number
+	}
+	c.byNumber[number] = &d
+	c.cachedAt[number] = at
+}
+
+// delete 按设备编号删除
+func (c *deviceCache) delete(number string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if d, ok := c.byNumber[number]; ok {
+		c.removeLocked(number, d)
+	}
+}
+
+// deleteByID 按设备ID删除，返回被删除设备的编号
+func (c *deviceCache) deleteByID(id string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	number, ok := c.byID[id]
+	if !ok {
+		return "", false
+	}
+	c.removeLocked(number, c.byNumber[number])
+	return number, true
+}
+
+func (c *deviceCache) removeLocked(number string, d *types.Device) {
+	delete(c.byNumber, number)
+	delete(c.cachedAt, number)
+	if c.byID[d.ID] == number {
+		delete(c.byID, d.ID)
+	}
+}
+
+// len 返回缓存条数
+func (c *deviceCache) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.byNumber)
+}
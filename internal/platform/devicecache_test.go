@@ -0,0 +1,82 @@
+package platform
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/types"
+)
+
+// TestDeviceCacheConcurrentAccess 并发执行设备上线(回源写缓存)、查询与断开(按ID清理)，结束后校验缓存索引一致性；
+// 配合 go test -race 发现设备缓存上的数据竞争
+func TestDeviceCacheConcurrentAccess(t *testing.T) {
+	const (
+		workers = 32
+		ops     = 5000
+		devices = 64
+	)
+	cache := newDeviceCache(32, 0)
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < ops; i++ {
+				n := rnd.Intn(devices)
+				number := fmt.Sprintf("A4:CF:12:00:00:%02X", n)
+				id := fmt.Sprintf("device-%d", n)
+				switch rnd.Intn(4) {
+				case 0: // 设备上线
+					gen := cache.generation()
+					cache.putIfUnchanged(number, types.Device{ID: id, DeviceNumber: number}, gen)
+				case 1: // 平台断开设备
+					cache.deleteByID(id)
+				case 2:
+					if d, ok := cache.get(number); ok && d.ID != id {
+						errCh <- fmt.Errorf("设备 %s 的ID为 %s，期望 %s", number, d.ID, id)
+						return
+					}
+				default:
+					if d, ok := cache.getByID(id); ok && d.DeviceNumber != number {
+						errCh <- fmt.Errorf("设备 %s 的编号为 %s，期望 %s", id, d.DeviceNumber, number)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+	if err := cache.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// check 校验索引一致性及容量上限
+func (c *deviceCache) check() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.size > 0 && len(c.byNumber) > c.size {
+		return fmt.Errorf("缓存条数 %d 超过上限 %d", len(c.byNumber), c.size)
+	}
+	for id, number := range c.byID {
+		d, ok := c.byNumber[number]
+		if !ok || d.ID != id {
+			return fmt.Errorf("设备ID索引不一致: %s -> %s", id, number)
+		}
+	}
+	for number, d := range c.byNumber {
+		if d.ID != "" && c.byID[d.ID] != number {
+			return fmt.Errorf("设备 %s 缺少ID索引", number)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// internal/platform/devicestore.go
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/types"
+)
+
+// deviceStoreSweepInterval 清理本地存储中过期设备的间隔
+const deviceStoreSweepInterval = 10 * time.Minute
+
+var deviceCacheLookups = metrics.NewCounterVec("tp_plugin_device_cache_total",
+	"按设备编号查询设备信息的来源", "result")
+
+// DeviceCacheConfig 设备缓存有效期及本地持久化配置
+type DeviceCacheConfig struct {
+	TTL        time.Duration // 缓存有效期，过期后重新向平台获取，0为不过期
+	Persist    bool          // 将设备信息保存到本地存储，重启后预热缓存，平台不可用时使用过期的记录
+	MaxEntries int           // 本地存储最多保存的设备数，超过时淘汰最早获取的，0为不限制
+}
+
+// persistedDevice 本地存储中的设备信息，按设备编号保存，设备ID索引保存在 BucketDevIndex
+type persistedDevice struct {
+	Device   types.Device `json:"device"`
+	CachedAt time.Time    `json:"cached_at"` // 从平台获取的时间
+}
+
+func (p *PlatformClient) persistDevices() bool {
+	return p.store != nil && p.cacheCfg.Persist
+}
+
+// loadDevices 启动时用本地存储中未过期的设备预热缓存，已过期的删除
+func (p *PlatformClient) loadDevices() {
+	if !p.persistDevices() {
+		return
+	}
+	loaded := 0
+	var stale []persistedDevice
+	err := p.store.ForEach(store.BucketDevices, func(key string, data []byte) error {
+		var d persistedDevice
+		if err := json.Unmarshal(data, &d); err != nil {
+			p.logger.WithError(err).WithField("device_number", key).Warn("解析本地设备缓存失败")
+			return nil
+		}
+		d.Device.DeviceNumber = key
+		if p.devices.expired(d.CachedAt) {
+			stale = append(stale, d)
+			return nil
+		}
+		p.devices.putAt(key, d.Device, d.CachedAt)
+		loaded++
+		return nil
+	})
+	if err != nil {
+		p.logger.WithError(err).Warn("加载本地设备缓存失败")
+		return
+	}
+	for _, d := range stale {
+		p.unpersistDevice(d.Device.DeviceNumber, d.Device.ID)
+	}
+	if loaded > 0 {
+		p.logger.WithField("count", loaded).Info("已从本地存储预热设备缓存")
+	}
+}
+
+// storedDevice 从本地存储读取设备信息
+func (p *PlatformClient) storedDevice(number string) (persistedDevice, bool) {
+	var d persistedDevice
+	if !p.persistDevices() {
+		return d, false
+	}
+	if err := p.store.Get(store.BucketDevices, number, &d); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			p.logger.WithError(err).WithField("device_number", number).Warn("读取本地设备缓存失败")
+		}
+		return d, false
+	}
+	if d.Device.DeviceNumber == "" {
+		d.Device.DeviceNumber = number
+	}
+	return d, true
+}
+
+// storedDeviceByID 通过设备ID索引从本地存储读取设备信息
+func (p *PlatformClient) storedDeviceByID(id string) (persistedDevice, bool) {
+	var number string
+	if !p.persistDevices() || p.store.Get(store.BucketDevIndex, id, &number) != nil {
+		return persistedDevice{}, false
+	}
+	return p.storedDevice(number)
+}
+
+// persistDevice 保存从平台获取的设备信息
+func (p *PlatformClient) persistDevice(number string, d types.Device) {
+	if !p.persistDevices() {
+		return
+	}
+	if err := p.store.Put(store.BucketDevices, number, persistedDevice{Device: d, CachedAt: time.Now()}); err != nil {
+		p.logger.WithError(err).WithField("device_number", number).Warn("保存本地设备缓存失败")
+		return
+	}
+	if d.ID != "" {
+		if err := p.store.Put(store.BucketDevIndex, d.ID, number); err != nil {
+			p.logger.WithError(err).WithField("device_id", d.ID).Warn("保存设备ID索引失败")
+		}
+	}
+}
+
+// unpersistDevice 删除本地存储中的设备信息及其ID索引，id 为空时从记录中读取
+func (p *PlatformClient) unpersistDevice(number, id string) {
+	if !p.persistDevices() {
+		return
+	}
+	if id == "" {
+		if d, ok := p.storedDevice(number); ok {
+			id = d.Device.ID
+		}
+	}
+	if err := p.store.Delete(store.BucketDevices, number); err != nil {
+		p.logger.WithError(err).WithField("device_number", number).Warn("删除本地设备缓存失败")
+	}
+	if id == "" {
+		return
+	}
+	// 索引已指向其他编号(设备更换了编号)时保留
+	var indexed string
+	if p.store.Get(store.BucketDevIndex, id, &indexed) == nil && indexed == number {
+		if err := p.store.Delete(store.BucketDevIndex, id); err != nil {
+			p.logger.WithError(err).WithField("device_id", id).Warn("删除设备ID索引失败")
+		}
+	}
+}
+
+// sweepDevices 删除本地存储中过期的设备，超过上限时淘汰最早获取的
+func (p *PlatformClient) sweepDevices() {
+	var list []persistedDevice
+	err := p.store.ForEach(store.BucketDevices, func(key string, data []byte) error {
+		var d persistedDevice
+		if json.Unmarshal(data, &d) == nil {
+			d.Device.DeviceNumber = key
+			list = append(list, d)
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.WithError(err).Warn("读取本地设备缓存失败")
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CachedAt.Before(list[j].CachedAt) })
+	evict := 0
+	if max := p.cacheCfg.MaxEntries; max > 0 && len(list) > max {
+		evict = len(list) - max
+	}
+	removed := 0
+	for i, d := range list {
+		if i >= evict && !p.devices.expired(d.CachedAt) {
+			break
+		}
+		p.unpersistDevice(d.Device.DeviceNumber, d.Device.ID)
+		removed++
+	}
+	if removed > 0 {
+		p.logger.WithField("count", removed).Debug("已清理本地设备缓存")
+	}
+}
+
+// deviceStoreLoop 定期清理本地存储中的设备
+func (p *PlatformClient) deviceStoreLoop() {
+	ticker := time.NewTicker(deviceStoreSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.sweepDevices()
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// internal/platform/failover.go
+package platform
+
+import (
+	"fmt"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/client"
+)
+
+// Endpoint 平台接口地址及MQTT服务器
+type Endpoint struct {
+	BaseURL    string
+	MQTTBroker string
+}
+
+// 平台端点名称
+const (
+	endpointPrimary   = "primary"
+	endpointSecondary = "secondary"
+)
+
+// 故障切换默认参数
+const (
+	defaultFailoverAfter    = 30 * time.Second
+	defaultFailbackInterval = time.Minute
+	failoverCheckInterval   = 5 * time.Second
+)
+
+var (
+	activeEndpoint = metrics.NewGaugeVec("tp_plugin_platform_endpoint_active",
+		"当前使用的平台端点(1为使用中)", "endpoint")
+	endpointSwitches = metrics.NewCounterVec("tp_plugin_platform_endpoint_switches_total",
+		"平台端点切换次数", "to")
+)
+
+// FailoverConfig 平台端点故障切换配置，Secondary 为空时不启用
+type FailoverConfig struct {
+	Secondary        Endpoint
+	FailoverAfter    time.Duration // 主端点MQTT断开超过该时长后切换到备用端点
+	FailbackInterval time.Duration // 使用备用端点时探测主端点的间隔，主端点恢复后切回
+}
+
+// hasSecondary 是否配置了备用端点
+func (p *PlatformClient) hasSecondary() bool {
+	return p.failover.Secondary.MQTTBroker != ""
+}
+
+// newClient 创建SDK客户端，不连接MQTT
+func (p *PlatformClient) newClient(ep Endpoint) (*client.Client, error) {
+	return client.NewClient(client.ClientConfig{
+		BaseURL:      ep.BaseURL,
+		MQTTBroker:   ep.MQTTBroker,
+		MQTTUsername: p.mqttUser,
+		MQTTPassword: p.mqttPass,
+		MQTTClientID: fmt.Sprintf("%s-%d", p.mqttID, time.Now().UnixNano()),
+	})
+}
+
+// connect 创建SDK客户端并连接MQTT
+func (p *PlatformClient) connect(ep Endpoint) (*client.Client, error) {
+	c, err := p.newClient(ep)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Connect(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// switchTo 切换当前使用的SDK客户端并关闭旧客户端
+func (p *PlatformClient) switchTo(name string, c *client.Client) {
+	old := p.sdk.Swap(c)
+	p.endpoint.Store(name)
+	for _, n := range []string{endpointPrimary, endpointSecondary} {
+		v := 0.0
+		if n == name {
+			v = 1
+		}
+		activeEndpoint.WithLabelValues(n).Set(v)
+	}
+	if old != nil && old != c {
+		old.Close()
+	}
+}
+
+// ActiveEndpoint 返回当前使用的平台端点: primary/secondary
+func (p *PlatformClient) ActiveEndpoint() string {
+	name, _ := p.endpoint.Load().(string)
+	return name
+}
+
+// failoverLoop 主端点MQTT持续断开时切换到备用端点，使用备用端点期间定期探测主端点并切回
+// SDK的MQTT客户端自带断线重连，短暂断开不会触发切换
+func (p *PlatformClient) failoverLoop() {
+	ticker := time.NewTicker(failoverCheckInterval)
+	defer ticker.Stop()
+	var disconnectedSince, lastProbe time.Time
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		switch p.ActiveEndpoint() {
+		case endpointPrimary:
+			if p.IsConnected() {
+				disconnectedSince = time.Time{}
+				continue
+			}
+			if disconnectedSince.IsZero() {
+				disconnectedSince = now
+			}
+			if now.Sub(disconnectedSince) < p.failover.FailoverAfter {
+				continue
+			}
+			c, err := p.connect(p.failover.Secondary)
+			if err != nil {
+				p.logger.WithError(err).Warn("连接备用平台端点失败")
+				continue
+			}
+			p.switchTo(endpointSecondary, c)
+			endpointSwitches.WithLabelValues(endpointSecondary).Inc()
+			disconnectedSince, lastProbe = time.Time{}, now
+			p.logger.WithField("broker", p.failover.Secondary.MQTTBroker).Warn("主平台端点不可用，已切换到备用端点")
+		case endpointSecondary:
+			if now.Sub(lastProbe) < p.failover.FailbackInterval {
+				continue
+			}
+			lastProbe = now
+			c, err := p.connect(p.primary)
+			if err != nil {
+				p.logger.WithError(err).Debug("主平台端点仍不可用")
+				continue
+			}
+			p.switchTo(endpointPrimary, c)
+			endpointSwitches.WithLabelValues(endpointPrimary).Inc()
+			p.logger.WithField("broker", p.primary.MQTTBroker).Info("主平台端点已恢复，已切回")
+		}
+	}
+}
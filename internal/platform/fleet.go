@@ -0,0 +1,233 @@
+// internal/platform/fleet.go
+package platform
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 设备群统计参数
+const (
+	DefaultFleetInterval = time.Minute
+	fleetIdleTTL         = 24 * time.Hour // 超过该时长没有任何活动的设备不再计入
+	fleetAll             = "*"            // 全部凭证汇总
+	fleetUnknown         = "unknown"      // 设备未缓存、无法确定凭证
+)
+
+var fleetPublished = metrics.NewCounterVec("tp_plugin_fleet_rollups_total",
+	"发布到服务设备的设备群统计次数", "result")
+
+// FleetConfig 按凭证汇总设备群统计并作为遥测发布到ThingsPanel中的服务设备
+type FleetConfig struct {
+	Enabled         bool
+	IntervalSeconds int               // 汇总间隔，0使用默认值60
+	RSSIKey         string            // 遥测中的信号强度字段
+	DeviceNumber    string            // 接收全部凭证汇总统计的服务设备编号，为空时不发布汇总
+	Tenants         map[string]string // 凭证摘要 -> 该凭证的服务设备编号
+}
+
+// FleetStats 一个凭证(或全部凭证)下设备的统计
+type FleetStats struct {
+	Tenant         string    `json:"tenant"` // 凭证摘要，* 为全部
+	Devices        int       `json:"devices"`
+	Online         int       `json:"online"`
+	OnlinePct      float64   `json:"online_pct"`
+	AvgRSSI        *float64  `json:"avg_rssi,omitempty"`
+	Messages       int       `json:"messages"`
+	MessagesPerMin float64   `json:"messages_per_min"`
+	At             time.Time `json:"at"`
+}
+
+// fleetDevice 单台设备本周期的数据
+type fleetDevice struct {
+	tenant   string
+	messages int
+	rssi     *float64
+	lastSeen time.Time
+}
+
+// fleetTracker 设备群统计
+type fleetTracker struct {
+	mu      sync.Mutex
+	devices map[string]*fleetDevice
+	last    []FleetStats
+	since   time.Time
+}
+
+// fleetTenant 设备所属凭证的摘要
+func (p *PlatformClient) fleetTenant(deviceID string) string {
+	if device, ok := p.devices.getByID(deviceID); ok && device.Voucher != "" {
+		return formjson.VoucherKey(device.Voucher)
+	}
+	return fleetUnknown
+}
+
+// observeFleet 记录设备活动，telemetry 为true时计一条消息并记录信号强度
+func (p *PlatformClient) observeFleet(deviceID string, values map[string]interface{}, telemetry bool) {
+	if !p.fleetCfg.Enabled {
+		return
+	}
+	tenant := p.fleetTenant(deviceID)
+	rssi, hasRSSI := numberValue(values[p.fleetCfg.RSSIKey])
+	f := &p.fleet
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.devices == nil {
+		f.devices = make(map[string]*fleetDevice)
+		f.since = time.Now()
+	}
+	d := f.devices[deviceID]
+	if d == nil {
+		d = &fleetDevice{}
+		f.devices[deviceID] = d
+	}
+	if tenant != fleetUnknown || d.tenant == "" {
+		d.tenant = tenant
+	}
+	d.lastSeen = time.Now()
+	if telemetry {
+		d.messages++
+	}
+	if hasRSSI {
+		d.rssi = &rssi
+	}
+}
+
+// rollupFleet 按凭证汇总本周期的统计并清空消息计数
+func (p *PlatformClient) rollupFleet(now time.Time) []FleetStats {
+	type acc struct {
+		stats   FleetStats
+		rssiSum float64
+		rssiN   int
+	}
+	groups := map[string]*acc{fleetAll: {stats: FleetStats{Tenant: fleetAll}}}
+	f := &p.fleet
+	f.mu.Lock()
+	minutes := now.Sub(f.since).Minutes()
+	f.since = now
+	for id, d := range f.devices {
+		if now.Sub(d.lastSeen) > fleetIdleTTL {
+			delete(f.devices, id)
+			continue
+		}
+		online := p.Online(id)
+		for _, key := range []string{fleetAll, d.tenant} {
+			g := groups[key]
+			if g == nil {
+				g = &acc{stats: FleetStats{Tenant: key}}
+				groups[key] = g
+			}
+			g.stats.Devices++
+			g.stats.Messages += d.messages
+			if online {
+				g.stats.Online++
+			}
+			if d.rssi != nil {
+				g.rssiSum += *d.rssi
+				g.rssiN++
+			}
+		}
+		d.messages = 0
+	}
+	f.mu.Unlock()
+
+	list := make([]FleetStats, 0, len(groups))
+	for _, g := range groups {
+		s := g.stats
+		s.At = now
+		if s.Devices > 0 {
+			s.OnlinePct = math.Round(float64(s.Online)/float64(s.Devices)*1000) / 10
+		}
+		if g.rssiN > 0 {
+			avg := math.Round(g.rssiSum/float64(g.rssiN)*10) / 10
+			s.AvgRSSI = &avg
+		}
+		if minutes > 0 {
+			s.MessagesPerMin = math.Round(float64(s.Messages)/minutes*10) / 10
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tenant < list[j].Tenant })
+	f.mu.Lock()
+	f.last = list
+	f.mu.Unlock()
+	return list
+}
+
+// StartFleetStats 按周期汇总设备群统计并发布到服务设备
+func (p *PlatformClient) StartFleetStats() {
+	if !p.fleetCfg.Enabled {
+		return
+	}
+	interval := time.Duration(p.fleetCfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = DefaultFleetInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+				p.publishFleetStats(p.rollupFleet(time.Now()))
+			}
+		}
+	}()
+}
+
+// publishFleetStats 将汇总发布到 device_number，各凭证的统计发布到 tenants 中配置的服务设备
+func (p *PlatformClient) publishFleetStats(list []FleetStats) {
+	for _, s := range list {
+		number := p.fleetCfg.Tenants[s.Tenant]
+		if s.Tenant == fleetAll {
+			number = p.fleetCfg.DeviceNumber
+		}
+		if number == "" {
+			continue
+		}
+		values := map[string]interface{}{
+			"fleet_devices":          s.Devices,
+			"fleet_online":           s.Online,
+			"fleet_online_pct":       s.OnlinePct,
+			"fleet_messages_per_min": s.MessagesPerMin,
+		}
+		if s.AvgRSSI != nil {
+			values["fleet_avg_rssi"] = *s.AvgRSSI
+		}
+		err := p.publishServiceTelemetry(number, values)
+		if err != nil {
+			fleetPublished.WithLabelValues("error").Inc()
+			p.logger.WithError(err).WithFields(logrus.Fields{"tenant": s.Tenant, "device_number": number}).Warn("发布设备群统计失败")
+			continue
+		}
+		fleetPublished.WithLabelValues("ok").Inc()
+	}
+}
+
+// publishServiceTelemetry 向服务设备发布遥测，不经过转换插件、快照和统计
+func (p *PlatformClient) publishServiceTelemetry(deviceNumber string, values map[string]interface{}) error {
+	device, err := p.GetDevice(deviceNumber)
+	if err != nil {
+		return err
+	}
+	payload, err := encodeTelemetry(p.codecs.get(ClassTelemetry), device.ID, values, time.Time{})
+	if err != nil {
+		return err
+	}
+	return p.publish("devices/telemetry", payload)
+}
+
+// FleetStats 返回最近一次的设备群统计
+func (p *PlatformClient) FleetStats() []FleetStats {
+	p.fleet.mu.Lock()
+	defer p.fleet.mu.Unlock()
+	return append([]FleetStats(nil), p.fleet.last...)
+}
@@ -0,0 +1,38 @@
+// internal/platform/health.go
+package platform
+
+// Health 平台连接状态快照，用于支持包等运维场景
+type Health struct {
+	Connected       bool        `json:"connected"`
+	Endpoint        string      `json:"endpoint"` // primary/secondary
+	DeviceCache     int         `json:"device_cache"`
+	DeviceCacheSize int         `json:"device_cache_size"` // 最大条数，0为不限制
+	TrackedDevices  int         `json:"tracked_devices"`   // 记录了遥测时间的设备数
+	OnlineDevices   int         `json:"online_devices"`
+	Spool           *SpoolStats `json:"spool,omitempty"` // 未启用磁盘队列时为空
+}
+
+// Health 返回当前连接、缓存和磁盘队列状态
+func (p *PlatformClient) Health() Health {
+	h := Health{
+		Connected:       p.IsConnected(),
+		Endpoint:        p.ActiveEndpoint(),
+		DeviceCache:     p.devices.len(),
+		DeviceCacheSize: p.devices.size,
+	}
+	p.lastSeen.Range(func(_, _ interface{}) bool {
+		h.TrackedDevices++
+		return true
+	})
+	p.online.Range(func(_, v interface{}) bool {
+		if v.(bool) {
+			h.OnlineDevices++
+		}
+		return true
+	})
+	if p.spool != nil {
+		stats := p.spool.Stats()
+		h.Spool = &stats
+	}
+	return h
+}
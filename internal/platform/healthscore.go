@@ -0,0 +1,285 @@
+// internal/platform/healthscore.go
+package platform
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+)
+
+// 健康评分默认参数
+const (
+	DefaultHealthInterval = 10 * time.Minute
+	DefaultHealthKey      = "health_score"
+	healthIdleTTL         = 24 * time.Hour // 超过该时长没有任何活动的设备不再评分
+	rssiFloor             = -90.0          // 低于该值(dBm)信号分为0
+	rssiCeiling           = -50.0          // 高于该值(dBm)信号分为100
+	flapPenalty           = 20.0           // 每次掉线扣除的连接稳定性分
+	errorPenalty          = 10.0           // 每次上报失败扣除的分数
+)
+
+var healthScores = metrics.NewGaugeVec("tp_plugin_device_health_devices",
+	"各健康评分区间的设备数", "range")
+
+// HealthScoreConfig 设备健康评分配置，各项权重为0时该项不参与评分
+type HealthScoreConfig struct {
+	Enabled            bool
+	IntervalSeconds    int     // 评分及上报间隔，0使用默认值
+	Key                string  // 上报到平台的遥测字段，为空使用 health_score
+	RSSIKey            string  // 遥测中的信号强度字段(dBm)
+	BatteryKey         string  // 遥测中的电量字段(0-100)
+	ConnectivityWeight float64 // 连接稳定性：掉线次数和丢包率
+	RSSIWeight         float64 // 信号强度
+	BatteryWeight      float64 // 电量
+	ErrorWeight        float64 // 上报失败次数(转换失败、时间戳过期、发送失败等)
+}
+
+// DeviceHealth 单台设备的健康评分，各分项为0-100，设备未上报的分项为nil且不参与加权
+type DeviceHealth struct {
+	DeviceID     string    `json:"device_id"`
+	DeviceNumber string    `json:"device_number,omitempty"`
+	Score        float64   `json:"score"`
+	Connectivity *float64  `json:"connectivity,omitempty"`
+	RSSI         *float64  `json:"rssi,omitempty"`
+	Battery      *float64  `json:"battery,omitempty"`
+	Errors       *float64  `json:"errors,omitempty"`
+	Flaps        int       `json:"flaps"`       // 本周期掉线次数
+	ErrorCount   int       `json:"error_count"` // 本周期上报失败次数
+	LossRate     float64   `json:"loss_rate"`
+	Online       bool      `json:"online"`
+	RawRSSI      *float64  `json:"raw_rssi,omitempty"`
+	RawBattery   *float64  `json:"raw_battery,omitempty"`
+	ScoredAt     time.Time `json:"scored_at"`
+}
+
+// healthState 单台设备本周期的原始数据
+type healthState struct {
+	rssi     *float64
+	battery  *float64
+	flaps    int
+	errors   int
+	lastSeen time.Time
+	last     DeviceHealth // 最近一次评分结果
+}
+
+// healthTracker 设备健康评分
+type healthTracker struct {
+	mu      sync.Mutex
+	devices map[string]*healthState
+}
+
+func (t *healthTracker) state(deviceID string, now time.Time) *healthState {
+	if t.devices == nil {
+		t.devices = make(map[string]*healthState)
+	}
+	s := t.devices[deviceID]
+	if s == nil {
+		s = &healthState{}
+		t.devices[deviceID] = s
+	}
+	s.lastSeen = now
+	return s
+}
+
+// observeHealth 记录遥测中的信号强度和电量
+func (p *PlatformClient) observeHealth(deviceID string, values map[string]interface{}) {
+	cfg := p.healthCfg
+	if !cfg.Enabled {
+		return
+	}
+	rssi, hasRSSI := numberValue(values[cfg.RSSIKey])
+	battery, hasBattery := numberValue(values[cfg.BatteryKey])
+	p.health.mu.Lock()
+	defer p.health.mu.Unlock()
+	s := p.health.state(deviceID, time.Now())
+	if hasRSSI {
+		s.rssi = &rssi
+	}
+	if hasBattery {
+		s.battery = &battery
+	}
+}
+
+// healthError 记录一次上报失败
+func (p *PlatformClient) healthError(deviceID string) {
+	if !p.healthCfg.Enabled {
+		return
+	}
+	p.health.mu.Lock()
+	p.health.state(deviceID, time.Now()).errors++
+	p.health.mu.Unlock()
+}
+
+// healthStatus 记录设备上下线，在线转为离线计为一次掉线
+func (p *PlatformClient) healthStatus(deviceID string, wasOnline, online bool) {
+	if !p.healthCfg.Enabled {
+		return
+	}
+	p.health.mu.Lock()
+	s := p.health.state(deviceID, time.Now())
+	if wasOnline && !online {
+		s.flaps++
+	}
+	p.health.mu.Unlock()
+}
+
+// numberValue 解析数值字段
+func numberValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, !math.IsNaN(n) && !math.IsInf(n, 0)
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func clampScore(v float64) float64 {
+	return math.Round(math.Max(0, math.Min(100, v))*10) / 10
+}
+
+// score 计算评分并清空本周期的计数，信号强度和电量保留最近一次的值，调用方需持有锁
+func (p *PlatformClient) score(deviceID string, s *healthState, now time.Time) DeviceHealth {
+	cfg := p.healthCfg
+	h := DeviceHealth{DeviceID: deviceID, Flaps: s.flaps, ErrorCount: s.errors, Online: p.Online(deviceID), ScoredAt: now}
+	if device, ok := p.devices.getByID(deviceID); ok {
+		h.DeviceNumber = device.DeviceNumber
+	}
+	var total, weights float64
+	add := func(field **float64, v, weight float64) {
+		if weight <= 0 {
+			return
+		}
+		v = clampScore(v)
+		*field = &v
+		total += v * weight
+		weights += weight
+	}
+
+	conn := 100 - flapPenalty*float64(s.flaps)
+	if st, ok := p.SequenceStats(deviceID); ok {
+		h.LossRate = st.LossRate
+		conn -= st.LossRate * 100
+	}
+	if !h.Online {
+		conn = 0
+	}
+	add(&h.Connectivity, conn, cfg.ConnectivityWeight)
+	if s.rssi != nil {
+		h.RawRSSI = s.rssi
+		add(&h.RSSI, (*s.rssi-rssiFloor)/(rssiCeiling-rssiFloor)*100, cfg.RSSIWeight)
+	}
+	if s.battery != nil {
+		h.RawBattery = s.battery
+		add(&h.Battery, *s.battery, cfg.BatteryWeight)
+	}
+	add(&h.Errors, 100-errorPenalty*float64(s.errors), cfg.ErrorWeight)
+	if weights > 0 {
+		h.Score = clampScore(total / weights)
+	}
+	s.flaps, s.errors = 0, 0
+	s.last = h
+	return h
+}
+
+// StartHealthScore 按周期计算设备健康评分并作为遥测上报
+func (p *PlatformClient) StartHealthScore() {
+	if !p.healthCfg.Enabled {
+		return
+	}
+	cfg := &p.healthCfg
+	if cfg.ConnectivityWeight+cfg.RSSIWeight+cfg.BatteryWeight+cfg.ErrorWeight <= 0 {
+		cfg.ConnectivityWeight, cfg.RSSIWeight, cfg.BatteryWeight, cfg.ErrorWeight = 0.4, 0.2, 0.2, 0.2
+	}
+	interval := time.Duration(p.healthCfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+				p.publishHealthScores()
+			}
+		}
+	}()
+}
+
+// publishHealthScores 为近期有活动的设备评分并上报，MQTT不可用时只更新评分不补发
+func (p *PlatformClient) publishHealthScores() {
+	now := time.Now()
+	key := p.healthCfg.Key
+	if key == "" {
+		key = DefaultHealthKey
+	}
+	p.health.mu.Lock()
+	results := make([]DeviceHealth, 0, len(p.health.devices))
+	for id, s := range p.health.devices {
+		if now.Sub(s.lastSeen) > healthIdleTTL {
+			delete(p.health.devices, id)
+			continue
+		}
+		results = append(results, p.score(id, s, now))
+	}
+	p.health.mu.Unlock()
+
+	buckets := map[string]float64{"0-25": 0, "25-50": 0, "50-75": 0, "75-100": 0}
+	failed := 0
+	for _, h := range results {
+		switch {
+		case h.Score < 25:
+			buckets["0-25"]++
+		case h.Score < 50:
+			buckets["25-50"]++
+		case h.Score < 75:
+			buckets["50-75"]++
+		default:
+			buckets["75-100"]++
+		}
+		payload, err := encodeTelemetry(p.codecs.get(ClassTelemetry), h.DeviceID, map[string]interface{}{key: h.Score}, time.Time{})
+		if err == nil {
+			err = p.publish("devices/telemetry", payload)
+		}
+		if err != nil {
+			failed++
+		}
+	}
+	for r, n := range buckets {
+		healthScores.WithLabelValues(r).Set(n)
+	}
+	if failed > 0 {
+		p.logger.WithField("failed", failed).WithField("total", len(results)).Warn("部分设备健康评分上报失败")
+	}
+}
+
+// WorstDevices 返回最近一次评分最低的 n 台设备，n<=0 时返回全部；尚未评分的设备不在其中
+func (p *PlatformClient) WorstDevices(n int) []DeviceHealth {
+	p.health.mu.Lock()
+	list := make([]DeviceHealth, 0, len(p.health.devices))
+	for _, s := range p.health.devices {
+		if !s.last.ScoredAt.IsZero() {
+			list = append(list, s.last)
+		}
+	}
+	p.health.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score < list[j].Score
+		}
+		return list[i].DeviceID < list[j].DeviceID
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
@@ -0,0 +1,36 @@
+package platform
+
+import (
+	"context"
+	"time"
+)
+
+// StartHeartbeat 定期为每个服务标识符发送插件心跳，平台据此登记插件支持的服务
+func (p *PlatformClient) StartHeartbeat(identifiers []string, interval time.Duration) {
+	if len(identifiers) == 0 || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.sendHeartbeats(identifiers, interval)
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *PlatformClient) sendHeartbeats(identifiers []string, timeout time.Duration) {
+	for _, id := range identifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := p.SendHeartbeat(ctx, id); err != nil {
+			p.logger.WithError(err).WithField("service_identifier", id).Warn("插件心跳发送失败")
+		}
+		cancel()
+	}
+}
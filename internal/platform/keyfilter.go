@@ -0,0 +1,93 @@
+// internal/platform/keyfilter.go
+package platform
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/transform"
+)
+
+var telemetryKeysFiltered = metrics.NewCounterVec("tp_plugin_telemetry_keys_filtered_total",
+	"按凭证的允许/禁止列表过滤掉的遥测字段数", "tenant", "reason")
+
+// KeyFilter 某个凭证的遥测字段过滤规则，字段名支持通配符(如 wifi_*)
+type KeyFilter struct {
+	Voucher string   // 凭证摘要(见 formjson.VoucherKey)，"*" 匹配全部
+	Allow   []string // 非空时只发布匹配的字段
+	Deny    []string // 不发布匹配的字段，优先于 Allow
+}
+
+// keyFilters 按凭证摘要组织的过滤规则，凭证专属的规则优先于 "*"
+type keyFilters map[string]KeyFilter
+
+// newKeyFilters 校验规则中的通配符，同一凭证配置多条时返回错误
+func newKeyFilters(rules []KeyFilter) (keyFilters, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	f := make(keyFilters, len(rules))
+	for _, r := range rules {
+		if r.Voucher == "" {
+			return nil, fmt.Errorf("遥测字段过滤规则缺少 voucher")
+		}
+		if _, ok := f[r.Voucher]; ok {
+			return nil, fmt.Errorf("凭证 %s 配置了多条遥测字段过滤规则", r.Voucher)
+		}
+		for _, p := range append(r.Allow[:len(r.Allow):len(r.Allow)], r.Deny...) {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("遥测字段过滤规则 %q 格式错误: %v", p, err)
+			}
+		}
+		f[r.Voucher] = r
+	}
+	return f, nil
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterKeys 按设备所属凭证过滤遥测字段，原始时间 ts 始终保留；返回被过滤的字段名
+func (p *PlatformClient) filterKeys(deviceID string, values map[string]interface{}) []string {
+	if len(p.keyRules) == 0 {
+		return nil
+	}
+	tenant := transform.AnyVoucher
+	if device, ok := p.devices.getByID(deviceID); ok {
+		tenant = formjson.VoucherKey(device.Voucher)
+	}
+	rule, ok := p.keyRules[tenant]
+	if !ok {
+		if rule, ok = p.keyRules[transform.AnyVoucher]; !ok {
+			return nil
+		}
+	}
+	var removed []string
+	for key := range values {
+		if key == TimestampKey {
+			continue
+		}
+		reason := ""
+		switch {
+		case matchAny(rule.Deny, key):
+			reason = "deny"
+		case len(rule.Allow) > 0 && !matchAny(rule.Allow, key):
+			reason = "allow"
+		}
+		if reason != "" {
+			delete(values, key)
+			removed = append(removed, key)
+			telemetryKeysFiltered.WithLabelValues(rule.Voucher, reason).Inc()
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
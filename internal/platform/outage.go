@@ -0,0 +1,164 @@
+// internal/platform/outage.go
+package platform
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+	"tp-plugin/internal/forward"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/pkg/bufpool"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OutageSummaryMethod 平台恢复后按设备发布的离线缓存汇总事件
+const OutageSummaryMethod = "telemetry_outage_summary"
+
+var outagePoints = metrics.NewCounterVec("tp_plugin_outage_points_total",
+	"MQTT不可用期间的遥测数据条数", "result")
+
+// outageCounts 单个设备在中断期间的统计
+type outageCounts struct {
+	Buffered int `json:"buffered"` // 写入磁盘队列，恢复后重放
+	Dropped  int `json:"dropped"`  // 未启用磁盘队列、写入失败或超出队列容量而丢弃
+}
+
+// outageTracker 记录MQTT不可用期间各设备缓存和丢弃的遥测条数，恢复后作为事件发布
+type outageTracker struct {
+	mu      sync.Mutex
+	start   time.Time
+	end     time.Time
+	devices map[string]*outageCounts
+}
+
+func (o *outageTracker) add(deviceID string, buffered, dropped int) {
+	o.count(deviceID, buffered, dropped)
+	if buffered > 0 {
+		outagePoints.WithLabelValues("buffered").Add(float64(buffered))
+	}
+	if dropped > 0 {
+		outagePoints.WithLabelValues("dropped").Add(float64(dropped))
+	}
+}
+
+// count 累加统计，不计入指标
+func (o *outageTracker) count(deviceID string, buffered, dropped int) {
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.devices == nil {
+		o.devices = make(map[string]*outageCounts)
+		o.start = now
+	}
+	c := o.devices[deviceID]
+	if c == nil {
+		c = &outageCounts{}
+		o.devices[deviceID] = c
+	}
+	c.Buffered += buffered
+	c.Dropped += dropped
+	o.end = now
+}
+
+// spoolDropped 磁盘队列丢弃批次时，已计为缓存的条数改计为丢弃
+func (o *outageTracker) spoolDropped(counts map[string]int) {
+	o.mu.Lock()
+	for deviceID, n := range counts {
+		if c := o.devices[deviceID]; c != nil {
+			moved := n
+			if moved > c.Buffered {
+				moved = c.Buffered
+			}
+			c.Buffered -= moved
+		}
+	}
+	o.mu.Unlock()
+	for deviceID, n := range counts {
+		o.add(deviceID, 0, n)
+	}
+}
+
+// take 取出并清空本次中断的统计，无中断时返回false
+func (o *outageTracker) take() (time.Time, time.Time, map[string]*outageCounts, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.devices == nil {
+		return time.Time{}, time.Time{}, nil, false
+	}
+	start, end, devices := o.start, o.end, o.devices
+	o.devices = nil
+	return start, end, devices, true
+}
+
+// reportOutage 连接恢复(且磁盘队列已重放完)后，为中断期间有数据的设备发布汇总事件，便于运维确认数据损失
+func (p *PlatformClient) reportOutage() {
+	start, end, devices, ok := p.outage.take()
+	if !ok {
+		return
+	}
+	var buffered, dropped, failed int
+	for deviceID, c := range devices {
+		params := map[string]interface{}{
+			"start":    start.UnixMilli(),
+			"end":      end.UnixMilli(),
+			"buffered": c.Buffered,
+			"dropped":  c.Dropped,
+		}
+		payload, err := encodeEvent(p.codecs.get(ClassEvent), deviceID, OutageSummaryMethod, params)
+		if err == nil {
+			err = p.publish("devices/event/"+newMessageID(), payload)
+		}
+		if err != nil {
+			// 发布失败时放回，下次恢复后一并上报
+			p.outage.count(deviceID, c.Buffered, c.Dropped)
+			failed++
+			continue
+		}
+		p.forward(forward.EventEvent, deviceID, map[string]interface{}{"method": OutageSummaryMethod, "params": params}, time.Time{})
+		buffered += c.Buffered
+		dropped += c.Dropped
+	}
+	p.logger.WithFields(logrus.Fields{
+		"start":    start,
+		"end":      end,
+		"devices":  len(devices),
+		"buffered": buffered,
+		"dropped":  dropped,
+		"failed":   failed,
+	}).Warn("平台连接已恢复，已发布中断期间的数据缓存汇总")
+}
+
+// encodeEvent 构造设备事件消息，格式与遥测消息一致，values 为 {"method","params"}
+func encodeEvent(s Serializer, deviceID, method string, params map[string]interface{}) (string, error) {
+	values := bufpool.Get()
+	defer bufpool.Put(values)
+	err := s.Encode(values, map[string]interface{}{
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		return "", fmt.Errorf("序列化事件失败: %v", err)
+	}
+
+	payload := bufpool.Get()
+	defer bufpool.Put(payload)
+	err = s.Encode(payload, map[string]interface{}{
+		"device_id": deviceID,
+		"values":    base64.StdEncoding.EncodeToString(values.Bytes()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("序列化消息失败: %v", err)
+	}
+	return payload.String(), nil
+}
+
+// newMessageID 事件主题中的消息ID
+func newMessageID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,135 @@
+// internal/platform/override.go
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxStatusOverride 状态覆盖的最长时长，到期后恢复按实际事件上报
+const MaxStatusOverride = 24 * time.Hour
+
+var statusOverrideSuppressed = metrics.NewCounterVec("tp_plugin_status_override_suppressed_total",
+	"设备状态覆盖期间未上报的上下线状态", "status")
+
+// StatusOverride 手动强制设置的设备上下线状态
+type StatusOverride struct {
+	DeviceID     string    `json:"device_id"`
+	DeviceNumber string    `json:"device_number,omitempty"`
+	Online       bool      `json:"online"`
+	Reason       string    `json:"reason,omitempty"`
+	By           string    `json:"by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	Until        time.Time `json:"until"`
+}
+
+// statusOverrides 进行中的状态覆盖，只保存在内存中，重启后不再覆盖
+type statusOverrides struct {
+	mu      sync.Mutex
+	devices map[string]StatusOverride // 设备ID -> 覆盖
+}
+
+// active 返回设备未到期的状态覆盖，已到期的顺便删除
+func (o *statusOverrides) active(deviceID string, now time.Time) (StatusOverride, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ov, ok := o.devices[deviceID]
+	if ok && !now.Before(ov.Until) {
+		delete(o.devices, deviceID)
+		return StatusOverride{}, false
+	}
+	return ov, ok
+}
+
+// OverrideStatus 强制设置设备的上下线状态并立即上报，在 d 内忽略与之不同的上下线事件，
+// 用于设备已断电但平台仍显示在线等状态卡住的情况
+func (p *PlatformClient) OverrideStatus(deviceID string, online bool, d time.Duration, reason, by string) (StatusOverride, error) {
+	if deviceID == "" {
+		return StatusOverride{}, errors.New("缺少设备ID")
+	}
+	if d <= 0 || d > MaxStatusOverride {
+		return StatusOverride{}, fmt.Errorf("覆盖时长需大于0且不超过%v", MaxStatusOverride)
+	}
+	now := time.Now()
+	ov := StatusOverride{
+		DeviceID:     deviceID,
+		DeviceNumber: p.DeviceNumber(deviceID),
+		Online:       online,
+		Reason:       reason,
+		By:           by,
+		CreatedAt:    now,
+		Until:        now.Add(d),
+	}
+	// 先清除旧的覆盖，使强制状态本身不被抑制
+	p.overrides.mu.Lock()
+	delete(p.overrides.devices, deviceID)
+	p.overrides.mu.Unlock()
+	status := "0"
+	if online {
+		status = "1"
+	}
+	if err := p.SendDeviceStatus(deviceID, status); err != nil {
+		return StatusOverride{}, err
+	}
+	p.overrides.mu.Lock()
+	if p.overrides.devices == nil {
+		p.overrides.devices = make(map[string]StatusOverride)
+	}
+	p.overrides.devices[deviceID] = ov
+	p.overrides.mu.Unlock()
+	p.logger.WithFields(logrus.Fields{
+		"device_id": deviceID,
+		"online":    online,
+		"until":     ov.Until,
+		"reason":    reason,
+		"by":        by,
+	}).Warn("设备状态已手动覆盖")
+	return ov, nil
+}
+
+// ClearStatusOverride 解除设备的状态覆盖，之后的上下线事件正常上报；没有覆盖时返回false
+func (p *PlatformClient) ClearStatusOverride(deviceID string) (StatusOverride, bool) {
+	p.overrides.mu.Lock()
+	defer p.overrides.mu.Unlock()
+	ov, ok := p.overrides.devices[deviceID]
+	delete(p.overrides.devices, deviceID)
+	return ov, ok
+}
+
+// StatusOverrides 返回未到期的状态覆盖，按到期时间排序
+func (p *PlatformClient) StatusOverrides() []StatusOverride {
+	now := time.Now()
+	p.overrides.mu.Lock()
+	list := make([]StatusOverride, 0, len(p.overrides.devices))
+	for id, ov := range p.overrides.devices {
+		if !now.Before(ov.Until) {
+			delete(p.overrides.devices, id)
+			continue
+		}
+		list = append(list, ov)
+	}
+	p.overrides.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Until.Before(list[j].Until) })
+	return list
+}
+
+// overridden 设备处于状态覆盖中且要上报的状态与覆盖不同时返回true，调用方不再上报
+func (p *PlatformClient) overridden(deviceID string, online bool) bool {
+	ov, ok := p.overrides.active(deviceID, time.Now())
+	if !ok || ov.Online == online {
+		return false
+	}
+	label := "offline"
+	if online {
+		label = "online"
+	}
+	statusOverrideSuppressed.WithLabelValues(label).Inc()
+	p.logger.WithField("device_id", deviceID).WithField("online", online).Debug("设备状态覆盖中，不上报")
+	return true
+}
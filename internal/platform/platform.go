@@ -4,92 +4,263 @@ package platform
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
+	"tp-plugin/internal/anomaly"
+	"tp-plugin/internal/capture"
+	"tp-plugin/internal/chaos"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/forward"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/script"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/trace"
+	"tp-plugin/internal/transform"
 
 	"github.com/ThingsPanel/tp-protocol-sdk-go/client"
 	"github.com/ThingsPanel/tp-protocol-sdk-go/types"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 // PlatformClient 平台客户端
 type PlatformClient struct {
-	sdkClient   *client.Client
-	logger      *logrus.Logger
-	deviceCache map[string]*types.Device
-	cacheMutex  sync.RWMutex
+	sdk       atomic.Pointer[client.Client] // 当前使用的SDK客户端，故障切换时替换
+	endpoint  atomic.Value                  // 当前端点名称
+	primary   Endpoint
+	failover  FailoverConfig
+	mqttUser  string
+	mqttPass  string
+	mqttID    string
+	logger    *logrus.Logger
+	devices   *deviceCache
+	fetches   singleflight.Group
+	spool     *Spool
+	chaos     *chaos.Injector
+	tracer    *trace.Tracer
+	telemetry TelemetryConfig
+	cacheCfg  DeviceCacheConfig
+	transform *transform.Registry
+	scripts   *script.Engine
+	lastSeen  sync.Map // 设备ID → 最近一次收到遥测的时间
+	online    sync.Map // 设备ID → 最近一次发送的上下线状态
+	outage    outageTracker
+	skews     sync.Map // 设备ID → *skewState
+	sequences sync.Map // 设备ID → *seqState
+	dedup     sync.Map // 设备ID → *dedupState
+	forwarder *forward.Forwarder
+	store     *store.Store
+	healthCfg HealthScoreConfig
+	health    healthTracker
+	anomaly   *anomaly.Detector
+	fleetCfg  FleetConfig
+	fleet     fleetTracker
+	snapshots snapshotTable
+	subs      subscriptions
+	capture   *capture.Capturer
+	overrides statusOverrides
+	batch     *batcher // 遥测批量上报，未启用时为nil
+	codecs    serializerSet
+	keyRules  keyFilters // 按凭证的遥测字段允许/禁止列表
+	connectMu sync.Mutex // 串行化 Connect
+	connected bool       // 是否已完成首次MQTT连接，此后由SDK自动重连及故障切换维持
+	stopCh    chan struct{}
+	closeOnce sync.Once
 }
 
 // Config 平台配置
 type Config struct {
-	BaseURL      string
-	MQTTBroker   string
-	MQTTUsername string
-	MQTTPassword string
+	BaseURL         string
+	MQTTBroker      string
+	MQTTUsername    string
+	MQTTPassword    string
+	MQTTClientID    string // MQTT客户端ID前缀，连接时追加时间戳，为空时使用 Template
+	DeviceCacheSize int    // 设备缓存最大条数，0为不限制
+	Spool           SpoolConfig
+	Telemetry       TelemetryConfig
+	Chaos           *chaos.Injector     // 故障注入，为nil时不注入
+	Tracer          *trace.Tracer       // 设备追踪，为nil时不记录
+	Transforms      *transform.Registry // 上行消息转换插件，为nil时不转换
+	Scripts         *script.Engine      // 按设备类型执行的载荷脚本，为nil时不执行
+	Failover        FailoverConfig      // 备用平台端点，为空时不切换
+	Forwarder       *forward.Forwarder  // 插件间事件转发，为nil时不转发
+	Store           *store.Store        // 本地存储，保存设备遥测快照，为nil时快照只在内存中
+	HealthScore     HealthScoreConfig   // 设备健康评分
+	Anomaly         *anomaly.Detector   // 遥测异常检测，为nil时不检测
+	Fleet           FleetConfig         // 设备群统计
+	Capture         *capture.Capturer   // MQTT抓包，为nil时不抓包
+	Batch           BatchConfig         // 遥测批量上报
+	DeviceCache     DeviceCacheConfig   // 设备缓存有效期及本地持久化
+	Serializers     map[string]string   // 主题类别(telemetry/event/response) -> 序列化方式名称，未配置的使用 json
+	KeyFilters      []KeyFilter         // 按凭证的遥测字段允许/禁止列表，为空时不过滤
+	LazyConnect     bool                // 创建时不连接MQTT，由调用方调用 Connect(可重试)；连接前发布的遥测进入磁盘队列或丢弃
 }
 
-// NewPlatformClient 创建平台客户端
+// spoolReplayInterval 磁盘队列重放检查间隔
+const spoolReplayInterval = 10 * time.Second
+
+// NewPlatformClient 创建平台客户端，未启用 LazyConnect 时连接MQTT，连接失败返回错误
 func NewPlatformClient(config Config, logger *logrus.Logger) (*PlatformClient, error) {
-	sdkConfig := client.ClientConfig{
-		BaseURL:      config.BaseURL,
-		MQTTBroker:   config.MQTTBroker,
-		MQTTUsername: config.MQTTUsername,
-		MQTTPassword: config.MQTTPassword,
-		MQTTClientID: fmt.Sprintf("Template-%d", time.Now().Unix()),
+	p := &PlatformClient{
+		primary:   Endpoint{BaseURL: config.BaseURL, MQTTBroker: config.MQTTBroker},
+		failover:  config.Failover,
+		mqttUser:  config.MQTTUsername,
+		mqttPass:  config.MQTTPassword,
+		mqttID:    config.MQTTClientID,
+		logger:    logger,
+		devices:   newDeviceCache(config.DeviceCacheSize, config.DeviceCache.TTL),
+		chaos:     config.Chaos,
+		tracer:    config.Tracer,
+		telemetry: config.Telemetry,
+		cacheCfg:  config.DeviceCache,
+		transform: config.Transforms,
+		scripts:   config.Scripts,
+		forwarder: config.Forwarder,
+		store:     config.Store,
+		healthCfg: config.HealthScore,
+		anomaly:   config.Anomaly,
+		fleetCfg:  config.Fleet,
+		capture:   config.Capture,
+		stopCh:    make(chan struct{}),
 	}
-
-	sdkClient, err := client.NewClient(sdkConfig)
+	codecs, err := newSerializerSet(config.Serializers)
 	if err != nil {
 		return nil, err
 	}
+	p.codecs = codecs
+	if p.keyRules, err = newKeyFilters(config.KeyFilters); err != nil {
+		return nil, err
+	}
+	if p.mqttID == "" {
+		p.mqttID = "Template"
+	}
 
-	if err := sdkClient.Connect(); err != nil {
+	if p.hasSecondary() {
+		if p.failover.Secondary.BaseURL == "" {
+			p.failover.Secondary.BaseURL = config.BaseURL
+		}
+		if p.failover.FailoverAfter <= 0 {
+			p.failover.FailoverAfter = defaultFailoverAfter
+		}
+		if p.failover.FailbackInterval <= 0 {
+			p.failover.FailbackInterval = defaultFailbackInterval
+		}
+	}
+	if config.LazyConnect {
+		// 连接前使用未连接的SDK客户端，平台HTTP接口可正常调用，发布返回未连接错误
+		c, err := p.newClient(p.primary)
+		if err != nil {
+			return nil, err
+		}
+		p.switchTo(endpointPrimary, c)
+	} else if err := p.Connect(); err != nil {
 		return nil, err
 	}
 
-	return &PlatformClient{
-		sdkClient:   sdkClient,
-		logger:      logger,
-		deviceCache: make(map[string]*types.Device),
-	}, nil
+	if config.Spool.Enabled {
+		spool, err := NewSpool(config.Spool, logger)
+		if err != nil {
+			return nil, err
+		}
+		spool.OnDrop(p.outage.spoolDropped)
+		p.spool = spool
+	}
+	p.loadSnapshots()
+	p.loadDevices()
+	if config.Batch.Enabled {
+		p.batch = newBatcher(config.Batch, logger)
+		go p.batchLoop()
+	}
+	go p.replayLoop()
+	go p.snapshotLoop()
+	if p.persistDevices() {
+		go p.deviceStoreLoop()
+	}
+
+	return p, nil
+}
+
+// Connect 连接MQTT，主端点不可用且配置了备用端点时使用备用端点；首次连接成功后启动故障切换，
+// 之后再调用直接返回。启用 LazyConnect 时由启动依赖管理按退避间隔重试调用
+func (p *PlatformClient) Connect() error {
+	p.connectMu.Lock()
+	defer p.connectMu.Unlock()
+	if p.connected {
+		return nil
+	}
+	c, err := p.connect(p.primary)
+	switch {
+	case err == nil:
+		p.switchTo(endpointPrimary, c)
+	case p.hasSecondary():
+		p.logger.WithError(err).Warn("连接主平台端点失败，尝试备用端点")
+		if c, err = p.connect(p.failover.Secondary); err != nil {
+			return err
+		}
+		p.switchTo(endpointSecondary, c)
+	default:
+		return err
+	}
+	p.connected = true
+	if p.hasSecondary() {
+		go p.failoverLoop()
+	}
+	return nil
 }
 
 // GetDevice 获取设备信息(带缓存)
+// 同一设备的并发缓存未命中合并为一次平台请求；启用本地持久化时先查本地存储，
+// 平台请求失败时使用本地存储中已过期的记录
 func (p *PlatformClient) GetDevice(deviceNumber string) (*types.Device, error) {
 	// 先查缓存
-	p.cacheMutex.RLock()
-	if device, ok := p.deviceCache[deviceNumber]; ok {
-		p.cacheMutex.RUnlock()
+	if device, ok := p.devices.get(deviceNumber); ok {
+		deviceCacheLookups.WithLabelValues("hit").Inc()
 		return device, nil
 	}
-	p.cacheMutex.RUnlock()
 
 	// 缓存未命中,从平台获取
-	req := &client.DeviceConfigRequest{
-		DeviceNumber: deviceNumber,
-	}
-
-	resp, err := p.sdkClient.Device().GetDeviceConfig(context.Background(), req)
+	v, err, _ := p.fetches.Do(deviceNumber, func() (interface{}, error) {
+		gen := p.devices.generation()
+		stored, found := p.storedDevice(deviceNumber)
+		if found && !p.devices.expired(stored.CachedAt) {
+			deviceCacheLookups.WithLabelValues("store").Inc()
+			p.devices.putAt(deviceNumber, stored.Device, stored.CachedAt)
+			return stored.Device, nil
+		}
+		req := &client.DeviceConfigRequest{
+			DeviceNumber: deviceNumber,
+		}
+		resp, err := p.sdk.Load().Device().GetDeviceConfig(context.Background(), req)
+		if err != nil {
+			if found {
+				deviceCacheLookups.WithLabelValues("stale").Inc()
+				p.logger.WithError(err).WithField("device_number", deviceNumber).Warn("获取设备信息失败，使用本地存储中已过期的记录")
+				return stored.Device, nil
+			}
+			return nil, err
+		}
+		deviceCacheLookups.WithLabelValues("platform").Inc()
+		if p.devices.putIfUnchanged(deviceNumber, resp.Data, gen) {
+			p.persistDevice(deviceNumber, resp.Data)
+		}
+		return resp.Data, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// 更新缓存
-	p.cacheMutex.Lock()
-	p.deviceCache[deviceNumber] = &resp.Data
-	p.cacheMutex.Unlock()
-
-	return &resp.Data, nil
+	device := v.(types.Device)
+	return &device, nil
 }
 
-// 获取服务接入点列表
-func (p *PlatformClient) GetServiceAccessPoints() ([]types.ServiceAccessRsp, error) {
+// GetServiceAccessPoints 获取指定服务标识符下的服务接入点列表
+func (p *PlatformClient) GetServiceAccessPoints(serviceIdentifier string) ([]types.ServiceAccessRsp, error) {
 	req := &client.ServiceAccessRequest{
-		ServiceIdentifier: "OPC-UA",
+		ServiceIdentifier: serviceIdentifier,
 	}
-	resp, err := p.sdkClient.Service().GetServiceAccessList(context.Background(), req)
+	resp, err := p.sdk.Load().Service().GetServiceAccessList(context.Background(), req)
 	if err != nil {
 		return nil, err
 	}
@@ -100,77 +271,314 @@ func (p *PlatformClient) GetServiceAccessPoints() ([]types.ServiceAccessRsp, err
 	return resp.Data, nil
 }
 
+// GetServiceAccess 获取服务接入点的当前配置(凭证、描述及设备)
+func (p *PlatformClient) GetServiceAccess(ctx context.Context, serviceAccessID string) (*types.ServiceAccess, error) {
+	req := &client.ServiceAccessRequest{
+		ServiceAccessID: serviceAccessID,
+	}
+	resp, err := p.sdk.Load().Service().GetServiceAccess(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Code != 200 {
+		return nil, fmt.Errorf("获取服务接入点失败: code=%d, message=%s", resp.Code, resp.Message)
+	}
+	return &resp.Data, nil
+}
+
 // ClearDeviceCache 清理指定设备的缓存
 func (p *PlatformClient) ClearDeviceCache(deviceNumber string) {
-	p.cacheMutex.Lock()
-	delete(p.deviceCache, deviceNumber)
-	p.cacheMutex.Unlock()
+	p.devices.delete(deviceNumber)
+	p.unpersistDevice(deviceNumber, "")
 	p.logger.WithField("device_number", deviceNumber).Debug("设备缓存已清理")
 }
 
-// GetDeviceByID 通过设备ID查找设备
-func (p *PlatformClient) GetDeviceByID(deviceID string) (*types.Device, error) {
-	var foundDevice *types.Device
-	p.cacheMutex.RLock()
-	for _, device := range p.deviceCache {
-		if device.ID == deviceID {
-			foundDevice = device
-			break
+// ClearDeviceCacheByID 按设备ID清理缓存，查找与删除在同一把锁内完成
+func (p *PlatformClient) ClearDeviceCacheByID(deviceID string) {
+	number, ok := p.devices.deleteByID(deviceID)
+	if !ok {
+		if d, found := p.storedDeviceByID(deviceID); found {
+			number, ok = d.Device.DeviceNumber, true
 		}
 	}
-	p.cacheMutex.RUnlock()
-	if foundDevice != nil {
-		return foundDevice, nil
+	if ok {
+		p.unpersistDevice(number, deviceID)
+		p.logger.WithFields(logrus.Fields{
+			"device_id":     deviceID,
+			"device_number": number,
+		}).Debug("设备缓存已清理")
+	}
+}
+
+// DeviceNumber 通过缓存将设备ID转换为设备编号，未缓存时返回设备ID本身
+func (p *PlatformClient) DeviceNumber(deviceID string) string {
+	if device, ok := p.devices.getByID(deviceID); ok && device.DeviceNumber != "" {
+		return device.DeviceNumber
+	}
+	return deviceID
+}
+
+// GetDeviceByID 通过设备ID查找缓存中的设备，内存中没有时查找本地存储
+func (p *PlatformClient) GetDeviceByID(deviceID string) (*types.Device, error) {
+	if device, ok := p.devices.getByID(deviceID); ok {
+		return device, nil
+	}
+	if d, ok := p.storedDeviceByID(deviceID); ok {
+		p.devices.putAt(d.Device.DeviceNumber, d.Device, d.CachedAt)
+		return &d.Device, nil
 	}
 	return nil, fmt.Errorf("device not found")
 }
 
 // SendTelemetry 发送遥测数据
+// 开启时间戳支持时，values 中的 ts 字段作为原始采集时间发送，缓存数据补传时保留设备端时间
 func (p *PlatformClient) SendTelemetry(deviceID string, values map[string]interface{}) error {
-	// 1. 先将 values 转换为 JSON
-	valuesJSON, err := json.Marshal(values)
+	err := p.sendTelemetry(deviceID, values)
 	if err != nil {
-		return fmt.Errorf("序列化values失败: %v", err)
+		p.healthError(deviceID)
 	}
+	return err
+}
 
-	// 2. 将 JSON 进行 base64 编码
-	valuesBase64 := base64.StdEncoding.EncodeToString(valuesJSON)
+func (p *PlatformClient) sendTelemetry(deviceID string, values map[string]interface{}) error {
+	// 固件重试导致的重复消息在全部处理之前丢弃，不重复计入健康、转发等统计
+	if p.duplicate(deviceID, values) {
+		p.logger.WithField("device_id", deviceID).Debug("遥测数据与去重窗口内的消息相同，已丢弃")
+		return nil
+	}
+	// 序号由固件写入，在转换插件和载荷脚本之前检测，脚本丢弃的消息不会被误判为丢包
+	p.trackSequence(deviceID, values)
+	values, err := p.transformUplink(deviceID, values)
+	if errors.Is(err, script.ErrDropped) {
+		p.logger.WithField("device_id", deviceID).Debug("遥测数据已被载荷脚本丢弃")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// 按凭证去掉不应发布到平台的字段(如 SSID、IP)，在健康统计、转发和快照之前执行
+	if removed := p.filterKeys(deviceID, values); len(removed) > 0 {
+		p.logger.WithField("device_id", deviceID).WithField("keys", removed).Debug("已过滤遥测字段")
+		if _, hasTS := values[TimestampKey]; len(values) == 0 || (hasTS && len(values) == 1) {
+			p.logger.WithField("device_id", deviceID).Debug("遥测字段已全部过滤，不再发布")
+			return nil
+		}
+	}
+	ts, values, err := p.telemetryTime(deviceID, values)
+	if err != nil {
+		return err
+	}
+	p.observeHealth(deviceID, values)
+	p.detectAnomalies(deviceID, values, ts)
+	p.observeFleet(deviceID, values, true)
+	p.lastSeen.Store(deviceID, time.Now())
+	p.recordSnapshot(deviceID, values, ts)
+	p.forward(forward.EventTelemetry, deviceID, values, ts)
 
-	// 3. 构造最终消息
-	msg := map[string]interface{}{
+	// 启用批量上报时合并后由 batchLoop 发布
+	if p.batch != nil {
+		return p.batch.ingest(measurement{deviceID: deviceID, values: values, ts: ts}, p.stopCh)
+	}
+	payload, err := encodeTelemetry(p.codecs.get(ClassTelemetry), deviceID, values, ts)
+	if err != nil {
+		return err
+	}
+
+	if p.tracer != nil {
+		p.tracer.Record(p.DeviceNumber(deviceID), trace.Out, "telemetry", payload)
+	}
+	if err := p.publishTelemetry(deviceID, payload); err != nil {
+		return err
+	}
+	p.logger.WithFields(logrus.Fields{
 		"device_id": deviceID,
-		"values":    valuesBase64, // base64 编码的字符串
+	}).Debug("遥测数据发送成功", payload)
+
+	return nil
+}
+
+// publishTelemetry 发布遥测消息，失败时写入磁盘队列待恢复后重放
+func (p *PlatformClient) publishTelemetry(deviceID, payload string) error {
+	if err := p.publish("devices/telemetry", payload); err != nil {
+		if p.spool == nil {
+			p.outage.add(deviceID, 0, 1)
+			return fmt.Errorf("发送消息失败: %v", err)
+		}
+		if spoolErr := p.spool.Append("devices/telemetry", deviceID, payload); spoolErr != nil {
+			p.outage.add(deviceID, 0, 1)
+			return fmt.Errorf("发送消息失败: %v, 写入磁盘队列失败: %v", err, spoolErr)
+		}
+		p.outage.add(deviceID, 1, 0)
+		p.logger.WithError(err).WithField("device_id", deviceID).Warn("遥测数据发送失败，已写入磁盘队列")
 	}
+	return nil
+}
 
-	// 4. 将整个消息转换为 JSON
-	payload, err := json.Marshal(msg)
+// transformUplink 按设备凭证执行上行转换插件，再按设备类型执行载荷脚本；
+// 设备未缓存时只执行 "*" 规则的转换插件
+func (p *PlatformClient) transformUplink(deviceID string, values map[string]interface{}) (map[string]interface{}, error) {
+	if p.transform == nil && p.scripts == nil {
+		return values, nil
+	}
+	key, number := transform.AnyVoucher, deviceID
+	device, cached := p.devices.getByID(deviceID)
+	if cached {
+		key, number = formjson.VoucherKey(device.Voucher), device.DeviceNumber
+	}
+	out, err := p.transform.Apply(key, transform.Uplink, number, values)
 	if err != nil {
-		return fmt.Errorf("序列化消息失败: %v", err)
+		return nil, fmt.Errorf("转换遥测数据失败: %v", err)
+	}
+	if cached {
+		if out, err = p.scripts.Apply(device.DeviceType, number, out); err != nil {
+			return nil, err
+		}
 	}
+	return out, nil
+}
 
-	// 5. 发送消息
-	if err := p.sdkClient.MQTT().Publish("devices/telemetry", 1, string(payload)); err != nil {
-		return fmt.Errorf("发送消息失败: %v", err)
+// LastTelemetry 返回设备最近一次上报遥测的时间，插件启动后未上报过时返回false
+func (p *PlatformClient) LastTelemetry(deviceID string) (time.Time, bool) {
+	v, ok := p.lastSeen.Load(deviceID)
+	if !ok {
+		return time.Time{}, false
 	}
+	return v.(time.Time), true
+}
 
-	p.logger.WithFields(logrus.Fields{
+// onlineWindow 未发送过上下线状态的设备，该时长内上报过遥测即视为在线
+const onlineWindow = 5 * time.Minute
+
+// Online 判断设备是否在线：以插件最近发送的上下线状态为准，未发送过时按最近遥测时间判断
+func (p *PlatformClient) Online(deviceID string) bool {
+	if v, ok := p.online.Load(deviceID); ok {
+		return v.(bool)
+	}
+	last, ok := p.LastTelemetry(deviceID)
+	return ok && time.Since(last) < onlineWindow
+}
+
+// encodeTelemetry 构造遥测消息，序列化使用池化缓冲区以降低高频上报时的GC压力
+// ts 非零时以毫秒时间戳写入 values 的 ts 字段
+func encodeTelemetry(s Serializer, deviceID string, values map[string]interface{}, ts time.Time) (string, error) {
+	if !ts.IsZero() {
+		values[TimestampKey] = ts.UnixMilli()
+	}
+	// 1. 先序列化 values
+	valuesJSON := bufpool.Get()
+	defer bufpool.Put(valuesJSON)
+	if err := s.Encode(valuesJSON, values); err != nil {
+		return "", fmt.Errorf("序列化values失败: %v", err)
+	}
+
+	// 2. 将 JSON 进行 base64 编码，构造最终消息
+	msg := map[string]interface{}{
 		"device_id": deviceID,
-	}).Debug("遥测数据发送成功", string(valuesJSON))
+		"values":    base64.StdEncoding.EncodeToString(valuesJSON.Bytes()), // base64 编码的字符串
+	}
 
-	return nil
+	// 3. 序列化整个消息
+	payload := bufpool.Get()
+	defer bufpool.Put(payload)
+	if err := s.Encode(payload, msg); err != nil {
+		return "", fmt.Errorf("序列化消息失败: %v", err)
+	}
+
+	// MQTT 客户端异步发送，这里拷贝一份后再归还缓冲区
+	return payload.String(), nil
+}
+
+// replayLoop MQTT连接恢复后重放磁盘队列，重放完成后发布中断期间的数据缓存汇总
+func (p *PlatformClient) replayLoop() {
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			if !p.IsConnected() {
+				continue
+			}
+			if p.spool != nil {
+				sent, err := p.spool.Replay(func(topic, payload string) error {
+					return p.publish(topic, payload)
+				})
+				if sent > 0 {
+					p.logger.WithField("count", sent).Info("磁盘队列遥测数据已重放")
+				}
+				if err != nil {
+					p.logger.WithError(err).Warn("磁盘队列重放中断")
+					continue
+				}
+			}
+			p.reportOutage()
+		}
+	}
+}
+
+// IsConnected MQTT是否已连接
+func (p *PlatformClient) IsConnected() bool {
+	return p.sdk.Load().MQTT().IsConnected()
 }
 
 // Close 关闭客户端
+// 可重复调用，仅第一次生效
 func (p *PlatformClient) Close() {
-	if p.sdkClient != nil {
-		p.sdkClient.Close()
-	}
+	p.closeOnce.Do(func() {
+		close(p.stopCh)
+		if p.batch != nil {
+			<-p.batch.done
+		}
+		p.flushSnapshots()
+		if p.spool != nil {
+			if err := p.spool.Flush(); err != nil {
+				p.logger.WithError(err).Error("磁盘队列落盘失败")
+			}
+		}
+		if c := p.sdk.Load(); c != nil {
+			c.Close()
+		}
+	})
 }
 
 func (p *PlatformClient) SendDeviceStatus(deviceID string, msg interface{}) error {
+	if p.overridden(deviceID, fmt.Sprint(msg) == "1") {
+		return nil
+	}
 	logrus.WithField("deviceID", deviceID).Debugf("发送设备状态: %s", msg)
+	p.tracer.Record(p.DeviceNumber(deviceID), trace.Out, "status", msg)
+	p.healthStatus(deviceID, p.Online(deviceID), fmt.Sprint(msg) == "1")
+	p.online.Store(deviceID, fmt.Sprint(msg) == "1")
+	p.observeFleet(deviceID, nil, false)
+	p.forward(forward.EventStatus, deviceID, map[string]interface{}{"online": fmt.Sprint(msg) == "1"}, time.Time{})
+
+	return p.publish("devices/status/"+deviceID, msg)
+}
 
-	return p.sdkClient.MQTT().Publish("devices/status/"+deviceID, 1, msg)
+// forward 将事件转发给其他插件，设备已缓存时附带设备编号和类型；ts 为零时使用当前时间
+func (p *PlatformClient) forward(eventType, deviceID string, values map[string]interface{}, ts time.Time) {
+	if p.forwarder == nil {
+		return
+	}
+	ev := forward.Event{Type: eventType, DeviceID: deviceID, Values: values}
+	if !ts.IsZero() {
+		ev.Timestamp = ts.UnixMilli()
+	}
+	if device, ok := p.devices.getByID(deviceID); ok {
+		ev.DeviceNumber, ev.DeviceType = device.DeviceNumber, device.DeviceType
+	}
+	p.forwarder.Publish(ev)
+}
+
+// publish 以QoS 1发布MQTT消息，启用故障注入时可能被延迟或丢弃
+func (p *PlatformClient) publish(topic string, payload interface{}) error {
+	p.capture.Record(capture.Publish, topic, payload)
+	p.chaos.Delay()
+	if p.chaos.DropPublish() {
+		return chaos.ErrPublishDropped
+	}
+	return p.sdk.Load().MQTT().Publish(topic, 1, payload)
 }
 
 // SendHeartbeat 发送插件心跳
@@ -179,7 +587,7 @@ func (p *PlatformClient) SendHeartbeat(ctx context.Context, serviceIdentifier st
 		ServiceIdentifier: serviceIdentifier,
 	}
 
-	resp, err := p.sdkClient.Service().SendHeartbeat(ctx, req)
+	resp, err := p.sdk.Load().Service().SendHeartbeat(ctx, req)
 	if err != nil {
 		return fmt.Errorf("发送心跳失败: %v", err)
 	}
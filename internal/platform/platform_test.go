@@ -0,0 +1,25 @@
+package platform
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// BenchmarkEncodeTelemetry 遥测消息序列化，按每条消息的键数量分组
+func BenchmarkEncodeTelemetry(b *testing.B) {
+	for _, keys := range []int{20, 200} {
+		values := make(map[string]interface{}, keys)
+		for i := 0; i < keys; i++ {
+			values[fmt.Sprintf("key_%d", i)] = float64(i) * 1.5
+		}
+		b.Run(fmt.Sprintf("keys=%d", keys), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := encodeTelemetry(JSON, "device-id", values, time.Time{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// internal/platform/purge.go
+package platform
+
+import (
+	"sync"
+	"time"
+	"tp-plugin/internal/forward"
+	"tp-plugin/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PurgedMethod 设备本地数据清除后发布的确认事件
+const PurgedMethod = "device_data_purged"
+
+// PurgeDevice 清除插件为设备保存的全部数据：设备缓存(内存及本地存储)、遥测快照，以及时钟偏差、消息序号、
+// 重复抑制、健康评分、设备群统计和异常检测的统计状态。返回 数据类别 -> 清除的条数，没有数据的类别不返回
+func (p *PlatformClient) PurgeDevice(deviceID, deviceNumber string) map[string]int {
+	purged := make(map[string]int)
+	count := func(kind string, ok bool) {
+		if ok {
+			purged[kind]++
+		}
+	}
+
+	if deviceID != "" {
+		t := &p.snapshots
+		t.mu.Lock()
+		_, ok := t.devices[deviceID]
+		delete(t.devices, deviceID)
+		delete(t.dirty, deviceID)
+		t.mu.Unlock()
+		if p.store != nil {
+			var s Snapshot
+			if p.store.Get(store.BucketSnapshots, deviceID, &s) == nil {
+				ok = true
+				if err := p.store.Delete(store.BucketSnapshots, deviceID); err != nil {
+					p.logger.WithError(err).WithField("device_id", deviceID).Warn("删除遥测快照失败")
+				}
+			}
+		}
+		count("snapshot", ok)
+
+		// 最近上报时间、上下线状态、时钟偏差、消息序号和重复抑制
+		ok = false
+		for _, m := range []*sync.Map{&p.lastSeen, &p.online, &p.skews, &p.sequences, &p.dedup} {
+			if _, loaded := m.LoadAndDelete(deviceID); loaded {
+				ok = true
+			}
+		}
+		count("telemetry_state", ok)
+
+		p.health.mu.Lock()
+		_, ok = p.health.devices[deviceID]
+		delete(p.health.devices, deviceID)
+		p.health.mu.Unlock()
+		count("health", ok)
+
+		p.fleet.mu.Lock()
+		_, ok = p.fleet.devices[deviceID]
+		delete(p.fleet.devices, deviceID)
+		p.fleet.mu.Unlock()
+		count("fleet", ok)
+
+		p.anomaly.Forget(deviceID)
+	}
+
+	_, cachedByID := p.devices.getByID(deviceID)
+	_, cachedByNumber := p.devices.get(deviceNumber)
+	_, storedByNumber := p.storedDevice(deviceNumber)
+	count("device_cache", cachedByID || cachedByNumber || storedByNumber)
+	if deviceID != "" {
+		p.ClearDeviceCacheByID(deviceID)
+	}
+	if deviceNumber != "" {
+		p.ClearDeviceCache(deviceNumber)
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"device_id":     deviceID,
+		"device_number": deviceNumber,
+		"purged":        purged,
+	}).Info("已清除设备本地数据")
+	return purged
+}
+
+// PublishPurged 发布设备数据已清除的确认事件，params 为清除结果
+func (p *PlatformClient) PublishPurged(deviceID string, params map[string]interface{}) error {
+	payload, err := encodeEvent(p.codecs.get(ClassEvent), deviceID, PurgedMethod, params)
+	if err != nil {
+		return err
+	}
+	if err := p.publish("devices/event/"+newMessageID(), payload); err != nil {
+		return err
+	}
+	p.forward(forward.EventEvent, deviceID, map[string]interface{}{"method": PurgedMethod, "params": params}, time.Time{})
+	return nil
+}
@@ -0,0 +1,104 @@
+// internal/platform/register.go
+package platform
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/client"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRegisterPath 插件服务元数据注册接口
+const DefaultRegisterPath = "/api/v1/plugin/service/register"
+
+// 注册重试参数
+const (
+	registerAttempts = 3
+	registerBackoff  = 10 * time.Second
+	registerTimeout  = 10 * time.Second
+)
+
+// ServiceMetadata 插件服务元数据，启动时推送到ThingsPanel插件目录
+type ServiceMetadata struct {
+	ServiceIdentifier string           `json:"service_identifier"`
+	Name              string           `json:"name"`
+	Version           string           `json:"version"`
+	Instance          string           `json:"instance,omitempty"`
+	DeviceTypes       []string         `json:"device_types"`
+	Forms             []FormCapability `json:"forms"`
+}
+
+// FormCapability 插件提供的一类表单
+type FormCapability struct {
+	FormType    string   `json:"form_type"`              // CFG/VCR/SVCR
+	Default     bool     `json:"default"`                // 是否有不区分设备类型的默认表单
+	DeviceTypes []string `json:"device_types,omitempty"` // 有专属表单的设备类型
+}
+
+// registerResponse 注册接口响应
+type registerResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// baseURL 当前使用的端点的平台接口地址
+func (p *PlatformClient) baseURL() string {
+	if p.ActiveEndpoint() == endpointSecondary {
+		return p.failover.Secondary.BaseURL
+	}
+	return p.primary.BaseURL
+}
+
+// RegisterService 推送或更新插件服务元数据
+func (p *PlatformClient) RegisterService(ctx context.Context, path string, meta ServiceMetadata) error {
+	if path == "" {
+		path = DefaultRegisterPath
+	}
+	api := client.NewAPIClient(p.baseURL(), client.WithTimeout(registerTimeout))
+	var resp registerResponse
+	if err := api.Post(ctx, path, meta, &resp); err != nil {
+		return err
+	}
+	if resp.Code != 0 && resp.Code != 200 {
+		return fmt.Errorf("注册服务元数据失败: code=%d, message=%s", resp.Code, resp.Message)
+	}
+	return nil
+}
+
+// RegisterServicesAsync 在后台为每个服务标识符注册元数据，网络错误时重试；
+// 平台不支持该接口(404)时只记录日志，不影响插件运行
+func (p *PlatformClient) RegisterServicesAsync(path string, metas []ServiceMetadata) {
+	go func() {
+		for _, meta := range metas {
+			log := p.logger.WithFields(logrus.Fields{
+				"service_identifier": meta.ServiceIdentifier,
+				"version":            meta.Version,
+			})
+			for attempt := 1; ; attempt++ {
+				ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+				err := p.RegisterService(ctx, path, meta)
+				cancel()
+				if err == nil {
+					log.Info("插件服务元数据已注册")
+					break
+				}
+				if strings.Contains(err.Error(), "状态码: 404") {
+					log.WithError(err).Warn("平台不支持服务元数据注册接口，已跳过")
+					return
+				}
+				if attempt >= registerAttempts {
+					log.WithError(err).Error("注册插件服务元数据失败")
+					break
+				}
+				select {
+				case <-p.stopCh:
+					return
+				case <-time.After(registerBackoff):
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,213 @@
+// internal/platform/sequence.go
+package platform
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 序号跟踪参数
+const (
+	seqWindow  = 64      // 记录最近收到的序号数，窗口内迟到的消息不计为丢失
+	maxSeqJump = 1 << 16 // 序号前跳超过该值视为设备重启或计数器回绕，不计为丢失
+)
+
+var (
+	packetLoss = metrics.NewCounterVec("tp_plugin_packet_loss_total",
+		"按序号检测到的丢失消息数")
+	sequenceEvents = metrics.NewCounterVec("tp_plugin_sequence_events_total",
+		"消息序号异常事件数", "event")
+)
+
+// SequenceStats 单台设备的序号统计
+type SequenceStats struct {
+	DeviceID     string    `json:"device_id"`
+	DeviceNumber string    `json:"device_number,omitempty"`
+	LastSeq      uint64    `json:"last_seq"`
+	Received     uint64    `json:"received"`
+	Lost         uint64    `json:"lost"`       // 序号缺口中移出窗口时仍未补到的消息数
+	Duplicates   uint64    `json:"duplicates"` // 重复收到的序号
+	Reordered    uint64    `json:"reordered"`  // 迟到但在窗口内补到的消息
+	Resets       uint64    `json:"resets"`     // 序号回退或大幅前跳(设备重启)的次数
+	LossRate     float64   `json:"loss_rate"`  // lost / (received + lost)
+	Since        time.Time `json:"since"`
+	LastGapAt    time.Time `json:"last_gap_at,omitempty"`
+}
+
+// seqState 单台设备的序号跟踪，window 第 i 位表示序号 last-i 是否已收到
+type seqState struct {
+	mu     sync.Mutex
+	stats  SequenceStats
+	window uint64
+	seen   bool
+}
+
+// observe 记录一个序号，返回本次确认丢失的条数；
+// 缺口中的序号移出窗口时仍未收到才计为丢失，以免把乱序当作丢包
+func (s *seqState) observe(seq uint64, now time.Time) (lost uint64, event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &s.stats
+	st.Received++
+	if !s.seen {
+		s.seen = true
+		st.LastSeq = seq
+		st.Since = now
+		s.window = ^uint64(0) // 首个序号之前的视为已收到
+		return 0, ""
+	}
+	last := st.LastSeq
+	switch {
+	case seq > last && seq-last <= maxSeqJump:
+		gap := seq - last
+		if gap >= seqWindow {
+			// 移出窗口的序号中未收到的计为丢失
+			lost = seqWindow - uint64(bits.OnesCount64(s.window))
+			lost += gap - seqWindow
+			s.window = 1 // 缺口中较新的序号仍在窗口内，补到时计为乱序
+		} else {
+			dropped := s.window >> (seqWindow - gap)
+			lost = gap - uint64(bits.OnesCount64(dropped))
+			s.window = s.window<<gap | 1
+		}
+		st.Lost += lost
+		st.LastSeq = seq
+		if gap > 1 {
+			st.LastGapAt = now
+			event = "gap"
+		}
+	case seq <= last && last-seq < seqWindow:
+		bit := uint64(1) << (last - seq)
+		if s.window&bit != 0 {
+			st.Duplicates++
+			st.Received--
+			return 0, "duplicate"
+		}
+		s.window |= bit
+		st.Reordered++
+		event = "reordered"
+	default:
+		// 序号回退到窗口之外或大幅前跳，按设备重启处理，窗口中未收到的序号不再计为丢失
+		st.Resets++
+		st.LastSeq = seq
+		s.window = ^uint64(0)
+		event = "reset"
+	}
+	return lost, event
+}
+
+func (s *seqState) snapshot() SequenceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	if total := st.Received + st.Lost; total > 0 {
+		st.LossRate = float64(st.Lost) / float64(total)
+	}
+	return st
+}
+
+// parseSequence 解析序号，支持非负整数及其字符串形式
+func parseSequence(v interface{}) (uint64, bool) {
+	switch t := v.(type) {
+	case float64:
+		if t < 0 || t > math.MaxUint64 || t != math.Trunc(t) {
+			return 0, false
+		}
+		return uint64(t), true
+	case int:
+		return uint64(t), t >= 0
+	case int64:
+		return uint64(t), t >= 0
+	case json.Number:
+		n, err := strconv.ParseUint(t.String(), 10, 64)
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseUint(t, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// trackSequence 固件携带序号时检测缺口、重复和乱序
+func (p *PlatformClient) trackSequence(deviceID string, values map[string]interface{}) {
+	key := p.telemetry.SequenceKey
+	if key == "" {
+		return
+	}
+	raw, ok := values[key]
+	if !ok {
+		return
+	}
+	seq, ok := parseSequence(raw)
+	if !ok {
+		sequenceEvents.WithLabelValues("invalid").Inc()
+		return
+	}
+	v, _ := p.sequences.LoadOrStore(deviceID, &seqState{})
+	lost, event := v.(*seqState).observe(seq, time.Now())
+	if lost > 0 {
+		packetLoss.WithLabelValues().Add(float64(lost))
+	}
+	if event == "" {
+		return
+	}
+	sequenceEvents.WithLabelValues(event).Inc()
+	if event == "gap" || event == "reset" {
+		p.logger.WithFields(logrus.Fields{
+			"device_id": deviceID,
+			"seq":       seq,
+			"lost":      lost,
+			"event":     event,
+		}).Debug("设备消息序号不连续")
+	}
+}
+
+// SequenceStats 返回设备的序号统计，设备未携带过序号时返回false
+func (p *PlatformClient) SequenceStats(deviceID string) (SequenceStats, bool) {
+	v, ok := p.sequences.Load(deviceID)
+	if !ok {
+		return SequenceStats{}, false
+	}
+	st := v.(*seqState).snapshot()
+	st.DeviceID = deviceID
+	st.DeviceNumber = p.DeviceNumber(deviceID)
+	return st, true
+}
+
+// AllSequenceStats 返回全部设备的序号统计，丢包率高的在前
+func (p *PlatformClient) AllSequenceStats() []SequenceStats {
+	list := []SequenceStats{}
+	p.sequences.Range(func(k, _ interface{}) bool {
+		if st, ok := p.SequenceStats(k.(string)); ok {
+			list = append(list, st)
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].LossRate != list[j].LossRate {
+			return list[i].LossRate > list[j].LossRate
+		}
+		return list[i].DeviceID < list[j].DeviceID
+	})
+	return list
+}
+
+// ResetSequenceStats 清除设备的序号统计，设备号为空时清除全部
+func (p *PlatformClient) ResetSequenceStats(deviceID string) {
+	if deviceID != "" {
+		p.sequences.Delete(deviceID)
+		return
+	}
+	p.sequences.Range(func(k, _ interface{}) bool {
+		p.sequences.Delete(k)
+		return true
+	})
+}
@@ -0,0 +1,99 @@
+// internal/platform/serializer.go
+package platform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 按主题类别选择序列化方式，设备上下线状态为固定的 0/1，不经过序列化
+const (
+	ClassTelemetry = "telemetry" // devices/telemetry 遥测消息
+	ClassEvent     = "event"     // devices/event/+ 设备事件(异常检测、中断汇总)
+	ClassResponse  = "response"  // 下行消息(属性设置、命令、升级进度)的执行结果
+)
+
+// Serializer 平台消息的序列化方式，Encode 将 v 追加写入 buf；
+// 遥测和事件消息先序列化 values 并base64编码，再序列化整条消息，两层使用同一序列化方式
+type Serializer interface {
+	Name() string
+	Encode(buf *bytes.Buffer, v interface{}) error
+}
+
+// JSON 默认的序列化方式，输出与 json.Marshal 一致(不含结尾换行)
+var JSON Serializer = jsonSerializer{}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Name() string { return "json" }
+
+func (jsonSerializer) Encode(buf *bytes.Buffer, v interface{}) error {
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// serializers 按名称注册的序列化方式
+var serializers = struct {
+	mu sync.RWMutex
+	m  map[string]Serializer
+}{m: map[string]Serializer{"json": JSON}}
+
+// RegisterSerializer 注册序列化方式，供 platform.serializers 配置按名称选用；需在创建平台客户端之前调用，同名时替换
+func RegisterSerializer(s Serializer) {
+	serializers.mu.Lock()
+	defer serializers.mu.Unlock()
+	serializers.m[s.Name()] = s
+}
+
+// LookupSerializer 按名称查找已注册的序列化方式
+func LookupSerializer(name string) (Serializer, bool) {
+	serializers.mu.RLock()
+	defer serializers.mu.RUnlock()
+	s, ok := serializers.m[name]
+	return s, ok
+}
+
+// serializerSet 各主题类别使用的序列化方式，未配置的类别使用 JSON
+type serializerSet map[string]Serializer
+
+// newSerializerSet 按 主题类别 -> 序列化方式名称 的配置选择序列化方式
+func newSerializerSet(names map[string]string) (serializerSet, error) {
+	set := make(serializerSet, len(names))
+	for class, name := range names {
+		switch class {
+		case ClassTelemetry, ClassEvent, ClassResponse:
+		default:
+			return nil, fmt.Errorf("未知的主题类别: %s(可选 %s/%s/%s)", class, ClassTelemetry, ClassEvent, ClassResponse)
+		}
+		s, ok := LookupSerializer(name)
+		if !ok {
+			return nil, fmt.Errorf("主题类别 %s 的序列化方式 %s 未注册(已注册: %v)", class, name, serializerNames())
+		}
+		set[class] = s
+	}
+	return set, nil
+}
+
+func (s serializerSet) get(class string) Serializer {
+	if v, ok := s[class]; ok {
+		return v
+	}
+	return JSON
+}
+
+func serializerNames() []string {
+	serializers.mu.RLock()
+	defer serializers.mu.RUnlock()
+	names := make([]string, 0, len(serializers.m))
+	for name := range serializers.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
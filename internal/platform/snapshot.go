@@ -0,0 +1,272 @@
+// internal/platform/snapshot.go
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+)
+
+// 遥测快照参数
+const (
+	snapshotFlushInterval = 30 * time.Second // 快照写入本地存储的间隔
+	DefaultReplayRate     = 50               // 重放快照时每秒发布的设备数
+)
+
+// ErrReplayRunning 已有快照重放在进行中
+var ErrReplayRunning = errors.New("已有快照重放在进行中")
+
+var snapshotReplayed = metrics.NewCounterVec("tp_plugin_snapshot_replay_total",
+	"重放到平台的设备遥测快照数", "result")
+
+// Snapshot 设备最近一次的遥测值，按字段合并，平台数据库恢复后可重放
+type Snapshot struct {
+	DeviceID     string                 `json:"device_id"`
+	DeviceNumber string                 `json:"device_number,omitempty"`
+	Values       map[string]interface{} `json:"values"`
+	Timestamp    int64                  `json:"ts"` // 最近一次上报的时间(毫秒)
+}
+
+// ReplayProgress 快照重放进度
+type ReplayProgress struct {
+	Running    bool      `json:"running"`
+	Total      int       `json:"total"`
+	Sent       int       `json:"sent"`
+	Failed     int       `json:"failed"`
+	Rate       int       `json:"rate"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// snapshotTable 内存中的快照表，定期将变化的快照写入存储
+type snapshotTable struct {
+	mu      sync.Mutex
+	devices map[string]*Snapshot
+	dirty   map[string]bool
+	replay  ReplayProgress
+}
+
+// loadSnapshots 启动时从存储加载快照
+func (p *PlatformClient) loadSnapshots() {
+	t := &p.snapshots
+	t.devices = make(map[string]*Snapshot)
+	t.dirty = make(map[string]bool)
+	if p.store == nil {
+		return
+	}
+	err := p.store.ForEach(store.BucketSnapshots, func(key string, data []byte) error {
+		var s Snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			p.logger.WithError(err).WithField("device_id", key).Warn("解析遥测快照失败")
+			return nil
+		}
+		t.devices[key] = &s
+		return nil
+	})
+	if err != nil {
+		p.logger.WithError(err).Warn("加载遥测快照失败")
+		return
+	}
+	if len(t.devices) > 0 {
+		p.logger.WithField("count", len(t.devices)).Info("已加载设备遥测快照")
+	}
+}
+
+// recordSnapshot 将本次遥测合并到设备快照，时间字段和消息序号不保存
+func (p *PlatformClient) recordSnapshot(deviceID string, values map[string]interface{}, ts time.Time) {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	t := &p.snapshots
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.devices[deviceID]
+	if s == nil {
+		s = &Snapshot{DeviceID: deviceID, Values: make(map[string]interface{}, len(values))}
+		t.devices[deviceID] = s
+	}
+	for k, v := range values {
+		if k == TimestampKey || k == p.telemetry.SequenceKey {
+			continue
+		}
+		s.Values[k] = v
+	}
+	if ms := ts.UnixMilli(); ms > s.Timestamp {
+		s.Timestamp = ms
+	}
+	if s.DeviceNumber == "" {
+		if device, ok := p.devices.getByID(deviceID); ok {
+			s.DeviceNumber = device.DeviceNumber
+		}
+	}
+	t.dirty[deviceID] = true
+}
+
+// flushSnapshots 将变化的快照写入存储，失败时保留待下次写入
+func (p *PlatformClient) flushSnapshots() {
+	if p.store == nil {
+		return
+	}
+	t := &p.snapshots
+	t.mu.Lock()
+	if len(t.dirty) == 0 {
+		t.mu.Unlock()
+		return
+	}
+	// 在锁内序列化，避免与并发的遥测更新同一个 values
+	data := make(map[string]interface{}, len(t.dirty))
+	for id := range t.dirty {
+		if s, ok := t.devices[id]; ok {
+			if b, err := json.Marshal(s); err == nil {
+				data[id] = json.RawMessage(b)
+			}
+		}
+	}
+	dirty := t.dirty
+	t.dirty = make(map[string]bool)
+	t.mu.Unlock()
+
+	if err := p.store.PutMany(store.BucketSnapshots, data); err != nil {
+		p.logger.WithError(err).Warn("保存遥测快照失败")
+		t.mu.Lock()
+		for id := range dirty {
+			t.dirty[id] = true
+		}
+		t.mu.Unlock()
+	}
+}
+
+// snapshotLoop 定期保存快照
+func (p *PlatformClient) snapshotLoop() {
+	ticker := time.NewTicker(snapshotFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			p.flushSnapshots()
+		}
+	}
+}
+
+// Snapshot 返回设备的遥测快照
+func (p *PlatformClient) Snapshot(deviceID string) (Snapshot, bool) {
+	t := &p.snapshots
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.devices[deviceID]
+	if !ok {
+		return Snapshot{}, false
+	}
+	return copySnapshot(s), true
+}
+
+// Snapshots 返回全部设备的遥测快照，deviceIDs 非空时只返回这些设备
+func (p *PlatformClient) Snapshots(deviceIDs []string) []Snapshot {
+	want := make(map[string]bool, len(deviceIDs))
+	for _, id := range deviceIDs {
+		want[id] = true
+	}
+	t := &p.snapshots
+	t.mu.Lock()
+	list := make([]Snapshot, 0, len(t.devices))
+	for _, s := range t.devices {
+		if len(want) == 0 || want[s.DeviceID] {
+			list = append(list, copySnapshot(s))
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].DeviceID < list[j].DeviceID })
+	return list
+}
+
+func copySnapshot(s *Snapshot) Snapshot {
+	c := *s
+	c.Values = make(map[string]interface{}, len(s.Values))
+	for k, v := range s.Values {
+		c.Values[k] = v
+	}
+	return c
+}
+
+// ReplaySnapshots 异步将设备的遥测快照重新发布到平台，用于平台数据库恢复或迁移到新环境后填充看板；
+// 启用 timestamps 时保留原始上报时间，否则平台按到达时间记录。rate 为每秒发布的设备数
+func (p *PlatformClient) ReplaySnapshots(deviceIDs []string, rate int) (ReplayProgress, error) {
+	if rate <= 0 {
+		rate = DefaultReplayRate
+	}
+	list := p.Snapshots(deviceIDs)
+	t := &p.snapshots
+	t.mu.Lock()
+	if t.replay.Running {
+		progress := t.replay
+		t.mu.Unlock()
+		return progress, ErrReplayRunning
+	}
+	t.replay = ReplayProgress{Running: true, Total: len(list), Rate: rate, StartedAt: time.Now()}
+	progress := t.replay
+	t.mu.Unlock()
+
+	p.logger.WithField("count", len(list)).WithField("rate", rate).Info("开始重放设备遥测快照")
+	go p.replaySnapshots(list, rate)
+	return progress, nil
+}
+
+func (p *PlatformClient) replaySnapshots(list []Snapshot, rate int) {
+	t := &p.snapshots
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	finish := func(errMsg string) {
+		t.mu.Lock()
+		t.replay.Running = false
+		t.replay.FinishedAt = time.Now()
+		t.replay.Error = errMsg
+		progress := t.replay
+		t.mu.Unlock()
+		p.logger.WithField("sent", progress.Sent).WithField("failed", progress.Failed).Info("设备遥测快照重放结束")
+	}
+	for _, s := range list {
+		select {
+		case <-p.stopCh:
+			finish("插件已停止")
+			return
+		case <-ticker.C:
+		}
+		var ts time.Time
+		if p.telemetry.Timestamps && s.Timestamp > 0 {
+			ts = time.UnixMilli(s.Timestamp)
+		}
+		payload, err := encodeTelemetry(p.codecs.get(ClassTelemetry), s.DeviceID, s.Values, ts)
+		if err == nil {
+			err = p.publish("devices/telemetry", payload)
+		}
+		t.mu.Lock()
+		if err != nil {
+			t.replay.Failed++
+		} else {
+			t.replay.Sent++
+		}
+		t.mu.Unlock()
+		if err != nil {
+			snapshotReplayed.WithLabelValues("error").Inc()
+			p.logger.WithError(err).WithField("device_id", s.DeviceID).Warn("重放遥测快照失败")
+			continue
+		}
+		snapshotReplayed.WithLabelValues("ok").Inc()
+	}
+	finish("")
+}
+
+// ReplayStatus 返回最近一次快照重放的进度
+func (p *PlatformClient) ReplayStatus() ReplayProgress {
+	t := &p.snapshots
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.replay
+}
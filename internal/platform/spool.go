@@ -0,0 +1,351 @@
+package platform
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// 支持的压缩算法
+const (
+	CodecZstd = "zstd"
+	CodecGzip = "gzip"
+	CodecNone = "none"
+)
+
+// tmpSuffix 改写批次文件时的临时文件后缀，重放和统计时忽略
+const tmpSuffix = ".tmp"
+
+// SpoolConfig 离线遥测磁盘队列配置
+type SpoolConfig struct {
+	Enabled   bool
+	Dir       string // 队列目录
+	Codec     string // 压缩算法: zstd/gzip/none
+	Level     int    // 压缩级别，zstd 为 1-4(越大压缩率越高)，gzip 为 1-9，0 使用默认级别
+	BatchSize int    // 每个批次文件包含的消息条数
+	MaxBytes  int64  // 队列目录最大占用字节数，超出后丢弃最旧的批次
+}
+
+// spoolRecord 队列中的一条待发送消息
+type spoolRecord struct {
+	Topic   string `json:"topic"`
+	Device  string `json:"device,omitempty"` // 设备ID，容量超限丢弃批次时按设备统计
+	Payload string `json:"payload"`
+	Time    int64  `json:"ts"`
+}
+
+// Spool MQTT不可用时缓存遥测数据的磁盘队列
+// 消息先在内存中攒批，满批或 Flush 时压缩写入一个批次文件，恢复连接后按写入顺序重放
+type Spool struct {
+	cfg     SpoolConfig
+	logger  *logrus.Logger
+	mu      sync.Mutex
+	pending []spoolRecord
+	seq     uint64
+	// onDrop 容量超限丢弃批次时按设备回调丢弃的条数
+	onDrop func(counts map[string]int)
+}
+
+// NewSpool 创建磁盘队列
+func NewSpool(cfg SpoolConfig, logger *logrus.Logger) (*Spool, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "spool"
+	}
+	if cfg.Codec == "" {
+		cfg.Codec = CodecZstd
+	}
+	if _, ok := codecExt[cfg.Codec]; !ok {
+		return nil, fmt.Errorf("不支持的压缩算法: %s", cfg.Codec)
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建队列目录失败: %v", err)
+	}
+	// 改写批次文件时中断留下的临时文件，原批次文件仍完整
+	if tmps, err := filepath.Glob(filepath.Join(cfg.Dir, "*.batch*"+tmpSuffix)); err == nil {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}
+	return &Spool{cfg: cfg, logger: logger}, nil
+}
+
+// codecExt 压缩算法对应的文件扩展名，重放时按扩展名选择解压方式
+var codecExt = map[string]string{
+	CodecZstd: ".zst",
+	CodecGzip: ".gz",
+	CodecNone: "",
+}
+
+// OnDrop 设置丢弃批次时的回调，需在开始写入前调用
+func (s *Spool) OnDrop(fn func(counts map[string]int)) {
+	s.onDrop = fn
+}
+
+// Append 追加一条设备消息，满批后写盘
+func (s *Spool) Append(topic, deviceID, payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, spoolRecord{Topic: topic, Device: deviceID, Payload: payload, Time: time.Now().UnixMilli()})
+	if len(s.pending) >= s.cfg.BatchSize {
+		return s.flushLocked()
+	}
+	return nil
+}
+
+// Flush 将内存中的消息写为一个批次文件
+func (s *Spool) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+func (s *Spool) flushLocked() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d.batch%s", time.Now().UnixNano(), s.seq%1000000, codecExt[s.cfg.Codec])
+	path := filepath.Join(s.cfg.Dir, name)
+	if err := s.writeBatch(path, s.cfg.Codec, s.pending); err != nil {
+		os.Remove(path)
+		return err
+	}
+	s.logger.WithFields(logrus.Fields{
+		"file":  name,
+		"count": len(s.pending),
+	}).Debug("遥测批次已写入磁盘队列")
+	s.pending = s.pending[:0]
+	s.enforceLimit()
+	return nil
+}
+
+func (s *Spool) writeBatch(path, codec string, records []spoolRecord) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("创建批次文件失败: %v", err)
+	}
+	defer f.Close()
+
+	w, err := newCompressor(f, codec, s.cfg.Level)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			w.Close()
+			return fmt.Errorf("写入批次文件失败: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("写入批次文件失败: %v", err)
+	}
+	return f.Sync()
+}
+
+// Replay 按写入顺序重放磁盘队列中的批次，批次全部发送成功后删除文件
+// publish 返回错误时停止重放，当前批次改写为只含未发送的消息，剩余批次留待下次
+func (s *Spool) Replay(publish func(topic, payload string) error) (int, error) {
+	if err := s.Flush(); err != nil {
+		return 0, err
+	}
+	files, err := s.batchFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, name := range files {
+		path := filepath.Join(s.cfg.Dir, name)
+		records, err := readBatch(path)
+		if err != nil {
+			// 损坏的批次无法恢复，直接丢弃避免阻塞后续批次
+			s.logger.WithError(err).WithField("file", name).Error("读取批次文件失败，已丢弃")
+			os.Remove(path)
+			continue
+		}
+		for i, r := range records {
+			if err := publish(r.Topic, r.Payload); err != nil {
+				if i > 0 {
+					s.rewriteBatch(path, records[i:])
+				}
+				return sent, err
+			}
+			sent++
+		}
+		os.Remove(path)
+	}
+	return sent, nil
+}
+
+// rewriteBatch 将批次文件替换为只含 records 的新文件，文件名及压缩算法不变，下次重放时不再重复发送已发送的消息
+func (s *Spool) rewriteBatch(path string, records []spoolRecord) {
+	codec := CodecNone
+	for c, ext := range codecExt {
+		if ext != "" && filepath.Ext(path) == ext {
+			codec = c
+		}
+	}
+	tmp := path + tmpSuffix
+	err := s.writeBatch(tmp, codec, records)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		s.logger.WithError(err).WithField("file", filepath.Base(path)).Error("改写批次文件失败，下次重放时将重复发送已发送的消息")
+	}
+}
+
+// batchFiles 返回按文件名(即写入时间)排序的批次文件
+func (s *Spool) batchFiles() ([]string, error) {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取队列目录失败: %v", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.Contains(e.Name(), ".batch") && !strings.HasSuffix(e.Name(), tmpSuffix) {
+			files = append(files, e.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// enforceLimit 队列目录超过 MaxBytes 时删除最旧的批次
+func (s *Spool) enforceLimit() {
+	if s.cfg.MaxBytes <= 0 {
+		return
+	}
+	files, err := s.batchFiles()
+	if err != nil {
+		return
+	}
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, name := range files {
+		if info, err := os.Stat(filepath.Join(s.cfg.Dir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; total > s.cfg.MaxBytes && i < len(files)-1; i++ {
+		path := filepath.Join(s.cfg.Dir, files[i])
+		if s.onDrop != nil {
+			if records, err := readBatch(path); err == nil {
+				counts := make(map[string]int)
+				for _, r := range records {
+					counts[r.Device]++
+				}
+				s.onDrop(counts)
+			}
+		}
+		os.Remove(path)
+		total -= sizes[i]
+		s.logger.WithField("file", files[i]).Warn("磁盘队列超出容量限制，丢弃最旧批次")
+	}
+}
+
+func readBatch(path string) ([]spoolRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := newDecompressor(bufio.NewReader(f), path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var records []spoolRecord
+	dec := json.NewDecoder(r)
+	for {
+		var rec spoolRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// nopWriteCloser 不压缩时使用
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func newCompressor(w io.Writer, codec string, level int) (io.WriteCloser, error) {
+	switch codec {
+	case CodecZstd:
+		opts := []zstd.EOption{}
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevel(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case CodecGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+func newDecompressor(r io.Reader, path string) (io.ReadCloser, error) {
+	switch filepath.Ext(path) {
+	case codecExt[CodecZstd]:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case codecExt[CodecGzip]:
+		return gzip.NewReader(r)
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// SpoolStats 磁盘队列占用情况
+type SpoolStats struct {
+	Batches int   `json:"batches"` // 待重放的批次文件数
+	Bytes   int64 `json:"bytes"`
+	Pending int   `json:"pending"` // 内存中尚未写盘的消息数
+}
+
+// Stats 返回磁盘队列的当前占用
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	stats := SpoolStats{Pending: len(s.pending)}
+	s.mu.Unlock()
+	files, err := s.batchFiles()
+	if err != nil {
+		return stats
+	}
+	stats.Batches = len(files)
+	for _, name := range files {
+		if info, err := os.Stat(filepath.Join(s.cfg.Dir, name)); err == nil {
+			stats.Bytes += info.Size()
+		}
+	}
+	return stats
+}
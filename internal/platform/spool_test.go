@@ -0,0 +1,184 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestSpool(t *testing.T, cfg SpoolConfig) *Spool {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+	s, err := NewSpool(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// appendN 依次追加 n 条消息，payload 为 p0..p(n-1)
+func appendN(t *testing.T, s *Spool, device string, n int) []string {
+	t.Helper()
+	var payloads []string
+	for i := 0; i < n; i++ {
+		p := fmt.Sprintf("p%d", i)
+		if err := s.Append("devices/telemetry", device, p); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, p)
+	}
+	return payloads
+}
+
+func TestSpoolRoundTrip(t *testing.T) {
+	for _, codec := range []string{CodecZstd, CodecGzip, CodecNone} {
+		t.Run(codec, func(t *testing.T) {
+			s := newTestSpool(t, SpoolConfig{Codec: codec, BatchSize: 3})
+			want := appendN(t, s, "d1", 7)
+			files, _ := s.batchFiles()
+			if len(files) != 2 || !strings.HasSuffix(files[0], ".batch"+codecExt[codec]) {
+				t.Fatalf("批次文件 %v", files)
+			}
+
+			var got []string
+			n, err := s.Replay(func(topic, payload string) error {
+				got = append(got, payload)
+				return nil
+			})
+			if err != nil || n != len(want) || !reflect.DeepEqual(got, want) {
+				t.Fatalf("重放 %d 条 %v, %v，期望 %v", n, got, err, want)
+			}
+			if stats := s.Stats(); stats.Batches != 0 || stats.Pending != 0 {
+				t.Fatalf("重放后队列 %+v", stats)
+			}
+		})
+	}
+}
+
+func TestSpoolReplayResumesAfterFailure(t *testing.T) {
+	for _, codec := range []string{CodecZstd, CodecGzip, CodecNone} {
+		t.Run(codec, func(t *testing.T) {
+			s := newTestSpool(t, SpoolConfig{Codec: codec, BatchSize: 4})
+			want := appendN(t, s, "d1", 8)
+
+			// 第一个批次发送两条后失败
+			var got []string
+			errBroken := errors.New("mqtt disconnected")
+			n, err := s.Replay(func(topic, payload string) error {
+				if len(got) == 2 {
+					return errBroken
+				}
+				got = append(got, payload)
+				return nil
+			})
+			if !errors.Is(err, errBroken) || n != 2 {
+				t.Fatalf("重放返回 %d, %v", n, err)
+			}
+			if stats := s.Stats(); stats.Batches != 2 {
+				t.Fatalf("失败后剩余 %d 个批次", stats.Batches)
+			}
+
+			// 再次重放从未发送的消息继续，不重复发送
+			if _, err := s.Replay(func(topic, payload string) error {
+				got = append(got, payload)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("两次重放共发送 %v，期望 %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSpoolEnforceLimit(t *testing.T) {
+	s := newTestSpool(t, SpoolConfig{Codec: CodecNone, BatchSize: 2})
+	dropped := make(map[string]int)
+	s.OnDrop(func(counts map[string]int) {
+		for device, n := range counts {
+			dropped[device] += n
+		}
+	})
+	appendN(t, s, "d1", 2)
+	appendN(t, s, "d2", 2)
+	files, _ := s.batchFiles()
+	info, err := os.Stat(filepath.Join(s.cfg.Dir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 容量只够两个批次，第三个批次写入后丢弃最旧的批次
+	s.cfg.MaxBytes = 2*info.Size() + info.Size()/2
+	appendN(t, s, "d3", 2)
+
+	files, _ = s.batchFiles()
+	if len(files) != 2 {
+		t.Fatalf("剩余批次 %v", files)
+	}
+	if !reflect.DeepEqual(dropped, map[string]int{"d1": 2}) {
+		t.Fatalf("按设备统计的丢弃条数 %v", dropped)
+	}
+
+	// 最新的批次即使超出容量也保留
+	s.cfg.MaxBytes = 1
+	appendN(t, s, "d4", 2)
+	if files, _ = s.batchFiles(); len(files) != 1 {
+		t.Fatalf("超出容量时剩余批次 %v，期望保留最新的一个", files)
+	}
+	if dropped["d2"] != 2 || dropped["d3"] != 2 || dropped["d4"] != 0 {
+		t.Fatalf("按设备统计的丢弃条数 %v", dropped)
+	}
+}
+
+func TestSpoolCorruptBatch(t *testing.T) {
+	for _, codec := range []string{CodecZstd, CodecGzip, CodecNone} {
+		t.Run(codec, func(t *testing.T) {
+			s := newTestSpool(t, SpoolConfig{Codec: codec, BatchSize: 2})
+			appendN(t, s, "d1", 2)
+			files, _ := s.batchFiles()
+			// 截断或写入无法解析的内容
+			if err := os.WriteFile(filepath.Join(s.cfg.Dir, files[0]), []byte("not a batch{"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			want := appendN(t, s, "d1", 2)
+
+			var got []string
+			n, err := s.Replay(func(topic, payload string) error {
+				got = append(got, payload)
+				return nil
+			})
+			if err != nil || n != 2 || !reflect.DeepEqual(got, want) {
+				t.Fatalf("重放 %d 条 %v, %v，期望跳过损坏批次后发送 %v", n, got, err, want)
+			}
+			if stats := s.Stats(); stats.Batches != 0 {
+				t.Fatalf("损坏的批次未删除: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestSpoolRemovesStaleTemp(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "00000000000000000001-000001.batch.zst"+tmpSuffix)
+	if err := os.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSpool(t, SpoolConfig{Dir: dir})
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("遗留的临时文件未清理: %v", err)
+	}
+	if stats := s.Stats(); stats.Batches != 0 {
+		t.Fatalf("临时文件计入了批次: %+v", stats)
+	}
+}
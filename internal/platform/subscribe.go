@@ -0,0 +1,106 @@
+// internal/platform/subscribe.go
+package platform
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"tp-plugin/internal/capture"
+	"tp-plugin/internal/pkg/bufpool"
+	"tp-plugin/internal/trace"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/client"
+)
+
+// resubscribeInterval 检查MQTT重连及端点切换的间隔
+const resubscribeInterval = 5 * time.Second
+
+// MessageHandler 平台下发的MQTT消息处理函数
+type MessageHandler func(topic string, payload []byte)
+
+// subscriptions 已订阅的主题。SDK以 clean session 连接且不恢复订阅，
+// MQTT重连或切换端点后由 resubscribeLoop 重新订阅
+type subscriptions struct {
+	mu        sync.Mutex
+	handlers  map[string]MessageHandler
+	client    *client.Client // 最近一次完成订阅的SDK客户端
+	connected bool
+	start     sync.Once
+}
+
+// Subscribe 以QoS 1订阅平台主题，MQTT重连或切换端点后自动重新订阅；
+// 当前未连接时只记录，连接后订阅
+func (p *PlatformClient) Subscribe(topic string, handler MessageHandler) error {
+	p.subs.mu.Lock()
+	if p.subs.handlers == nil {
+		p.subs.handlers = make(map[string]MessageHandler)
+	}
+	p.subs.handlers[topic] = func(topic string, payload []byte) {
+		p.capture.Record(capture.Receive, topic, payload)
+		handler(topic, payload)
+	}
+	handler = p.subs.handlers[topic]
+	p.subs.mu.Unlock()
+	p.subs.start.Do(func() { go p.resubscribeLoop() })
+
+	c := p.sdk.Load()
+	if !c.MQTT().IsConnected() {
+		return nil
+	}
+	p.capture.Record(capture.Subscribe, topic, nil)
+	if err := c.MQTT().Subscribe(topic, 1, client.MessageHandler(handler)); err != nil {
+		return fmt.Errorf("订阅主题 %s 失败: %v", topic, err)
+	}
+	return nil
+}
+
+// resubscribeLoop 发现SDK客户端更换或MQTT由断开恢复连接时重新订阅全部主题
+func (p *PlatformClient) resubscribeLoop() {
+	ticker := time.NewTicker(resubscribeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+		}
+		c := p.sdk.Load()
+		connected := c.MQTT().IsConnected()
+		p.subs.mu.Lock()
+		changed := c != p.subs.client || (connected && !p.subs.connected)
+		p.subs.client, p.subs.connected = c, connected
+		handlers := make(map[string]MessageHandler, len(p.subs.handlers))
+		for topic, h := range p.subs.handlers {
+			handlers[topic] = h
+		}
+		p.subs.mu.Unlock()
+		if !changed || !connected {
+			continue
+		}
+		for topic, h := range handlers {
+			p.capture.Record(capture.Subscribe, topic, nil)
+			if err := c.MQTT().Subscribe(topic, 1, client.MessageHandler(h)); err != nil {
+				p.logger.WithError(err).WithField("topic", topic).Warn("重新订阅主题失败")
+				p.subs.mu.Lock()
+				p.subs.connected = false // 下一周期重试
+				p.subs.mu.Unlock()
+			}
+		}
+		p.logger.WithField("topics", len(handlers)).Info("MQTT已重新订阅")
+	}
+}
+
+// Respond 向平台发布下行消息的执行结果，消息格式与遥测消息一致，values 为执行结果
+func (p *PlatformClient) Respond(topic, deviceID string, values interface{}) error {
+	payload := bufpool.Get()
+	defer bufpool.Put(payload)
+	err := p.codecs.get(ClassResponse).Encode(payload, map[string]interface{}{
+		"device_id": deviceID,
+		"values":    values,
+	})
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %v", err)
+	}
+	p.tracer.Record(p.DeviceNumber(deviceID), trace.Out, "response", values)
+	return p.publish(topic, payload.String())
+}
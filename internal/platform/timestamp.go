@@ -0,0 +1,114 @@
+// internal/platform/timestamp.go
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TimestampKey 设备上报数据中携带原始采集时间的字段
+const TimestampKey = "ts"
+
+// TelemetryConfig 遥测时间戳配置
+type TelemetryConfig struct {
+	Timestamps       bool // 使用设备上报的原始时间(ts)，平台遥测主题支持 ts 时开启
+	MaxAgeHours      int  // 早于该时长的历史数据直接丢弃，0为不限制
+	MaxFutureSeconds int  // 允许超前服务器时间的秒数，超出时改用到达时间
+	// SkewThresholdSeconds 设备时钟偏差超出该秒数时在遥测中写入 clock_skew，0为不检测
+	SkewThresholdSeconds int
+	SkewCorrect          bool   // 设备时钟超前超出阈值时按估计的偏差校正时间，否则仅标记
+	SequenceKey          string // 固件写入的消息序号字段，用于检测丢包，为空时不检测
+	DedupSeconds         int    // 同一设备该秒数内内容完全相同的遥测只发布一次，0为不去重
+}
+
+// ErrTelemetryExpired 遥测数据的原始时间超出允许的最大时长
+var ErrTelemetryExpired = errors.New("遥测数据时间过早，已丢弃")
+
+var telemetryTimestamps = metrics.NewCounterVec("tp_plugin_telemetry_timestamp_total",
+	"遥测时间戳处理结果", "result")
+
+// 毫秒/秒时间戳的分界：小于该值按秒处理(对应 2286 年)
+const secondsLimit = 1e10
+
+// ParseTimestamp 解析设备上报的时间，支持秒/毫秒时间戳(数字或数字字符串)及 RFC3339 字符串
+func ParseTimestamp(v interface{}) (time.Time, error) {
+	var f float64
+	switch t := v.(type) {
+	case float64:
+		f = t
+	case int64:
+		f = float64(t)
+	case int:
+		f = float64(t)
+	case json.Number:
+		n, err := t.Float64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("时间戳格式错误: %v", err)
+		}
+		f = n
+	case string:
+		if n, err := strconv.ParseFloat(t, 64); err == nil {
+			f = n
+			break
+		}
+		ts, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("时间格式错误: %v", err)
+		}
+		return ts, nil
+	default:
+		return time.Time{}, fmt.Errorf("不支持的时间类型: %T", v)
+	}
+	if f <= 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, fmt.Errorf("时间戳无效: %v", v)
+	}
+	if f < secondsLimit {
+		return time.UnixMilli(int64(f * 1000)), nil
+	}
+	return time.UnixMilli(int64(f)), nil
+}
+
+// telemetryTime 从上报数据中取出原始时间并校验，返回去掉 ts 字段后的数据副本；
+// 未开启、未携带或时间无效时返回零值，由平台使用到达时间
+func (p *PlatformClient) telemetryTime(deviceID string, values map[string]interface{}) (time.Time, map[string]interface{}, error) {
+	raw, ok := values[TimestampKey]
+	if !p.telemetry.Timestamps || !ok {
+		return time.Time{}, values, nil
+	}
+	rest := make(map[string]interface{}, len(values)-1)
+	for k, v := range values {
+		if k != TimestampKey {
+			rest[k] = v
+		}
+	}
+
+	ts, err := ParseTimestamp(raw)
+	if err != nil {
+		telemetryTimestamps.WithLabelValues("invalid").Inc()
+		p.logger.WithError(err).WithField("device_id", deviceID).Debug("遥测时间戳无效，使用到达时间")
+		return time.Time{}, rest, nil
+	}
+	ts = p.checkClockSkew(deviceID, ts, rest)
+	now := time.Now()
+	if p.telemetry.MaxAgeHours > 0 && now.Sub(ts) > time.Duration(p.telemetry.MaxAgeHours)*time.Hour {
+		telemetryTimestamps.WithLabelValues("expired").Inc()
+		return time.Time{}, nil, ErrTelemetryExpired
+	}
+	if ts.Sub(now) > time.Duration(p.telemetry.MaxFutureSeconds)*time.Second {
+		telemetryTimestamps.WithLabelValues("future").Inc()
+		p.logger.WithFields(logrus.Fields{
+			"device_id": deviceID,
+			"ts":        ts,
+		}).Debug("遥测时间超前服务器时间，使用到达时间")
+		return time.Time{}, rest, nil
+	}
+	telemetryTimestamps.WithLabelValues("original").Inc()
+	return ts, rest, nil
+}
@@ -0,0 +1,91 @@
+// internal/profile/profile.go
+package profile
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Field 遥测或属性定义
+type Field struct {
+	Key         string `yaml:"key"`
+	Name        string `yaml:"name"`
+	DataType    string `yaml:"data_type"` // Number/String/Boolean
+	Unit        string `yaml:"unit"`
+	Writable    bool   `yaml:"writable"` // 属性是否可写
+	Description string `yaml:"description"`
+}
+
+// Command 命令定义
+type Command struct {
+	Identifier  string  `yaml:"identifier"`
+	Name        string  `yaml:"name"`
+	Params      []Field `yaml:"params"`
+	Description string  `yaml:"description"`
+}
+
+// Profile 某一设备类型的能力模型(物模型)
+// 修改能力定义后需递增 Version，插件会向已发布过的租户重新发布
+type Profile struct {
+	DeviceType  string    `yaml:"device_type"`
+	Name        string    `yaml:"name"`
+	Version     int       `yaml:"version"`
+	Description string    `yaml:"description"`
+	Telemetry   []Field   `yaml:"telemetry"`
+	Attributes  []Field   `yaml:"attributes"`
+	Commands    []Command `yaml:"commands"`
+}
+
+// file 能力模型配置文件结构
+type file struct {
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// Load 从YAML文件加载能力模型，文件不存在时返回空列表
+func Load(path string) ([]Profile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取能力模型文件失败: %v", err)
+	}
+
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("解析能力模型文件失败: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, p := range f.Profiles {
+		if p.DeviceType == "" {
+			return nil, fmt.Errorf("第%d个能力模型缺少 device_type", i+1)
+		}
+		if seen[p.DeviceType] {
+			return nil, fmt.Errorf("能力模型 device_type 重复: %s", p.DeviceType)
+		}
+		seen[p.DeviceType] = true
+		if p.Name == "" {
+			f.Profiles[i].Name = p.DeviceType
+		}
+		if p.Version <= 0 {
+			f.Profiles[i].Version = 1
+		}
+	}
+	return f.Profiles, nil
+}
+
+// Find 按设备类型查找能力模型
+func Find(profiles []Profile, deviceType string) (Profile, bool) {
+	for _, p := range profiles {
+		if p.DeviceType == deviceType {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
@@ -0,0 +1,168 @@
+// internal/profile/publisher.go
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"tp-plugin/internal/store"
+	"tp-plugin/internal/thingspanel"
+
+	"github.com/sirupsen/logrus"
+)
+
+// publishTimeout 单个租户发布全部能力模型的超时时间
+const publishTimeout = time.Minute
+
+// publishRecord 能力模型在某个ThingsPanel环境中的发布记录
+type publishRecord struct {
+	Version     int       `json:"version"`
+	TemplateID  string    `json:"template_id"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Publisher 将能力模型发布为 ThingsPanel 设备模板
+type Publisher struct {
+	profiles []Profile
+	store    *store.Store
+	logger   *logrus.Logger
+	inflight sync.Map // 正在发布的 apiURL，避免并发请求重复发布
+}
+
+// NewPublisher 创建发布器
+func NewPublisher(profiles []Profile, st *store.Store, logger *logrus.Logger) *Publisher {
+	return &Publisher{profiles: profiles, store: st, logger: logger}
+}
+
+// EnsurePublishedAsync 在后台确保能力模型已发布到指定租户，不阻塞调用方
+func (p *Publisher) EnsurePublishedAsync(apiURL, apiKey string) {
+	if p == nil || len(p.profiles) == 0 || apiURL == "" || apiKey == "" {
+		return
+	}
+	if _, busy := p.inflight.LoadOrStore(apiURL, true); busy {
+		return
+	}
+	go func() {
+		defer p.inflight.Delete(apiURL)
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		defer cancel()
+		if err := p.EnsurePublished(ctx, apiURL, apiKey); err != nil {
+			p.logger.WithError(err).WithField("api_url", apiURL).Warn("发布设备能力模型失败")
+		}
+	}()
+}
+
+// EnsurePublished 发布尚未发布或版本已更新的能力模型
+func (p *Publisher) EnsurePublished(ctx context.Context, apiURL, apiKey string) error {
+	client := thingspanel.NewClient(apiURL, apiKey)
+	var errs []error
+	for _, prof := range p.profiles {
+		key := apiURL + "|" + prof.DeviceType
+		var rec publishRecord
+		err := p.store.Get(store.BucketProfiles, key, &rec)
+		if err == nil && rec.Version >= prof.Version {
+			continue
+		}
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		templateID, err := publish(ctx, client, prof)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", prof.DeviceType, err))
+			continue
+		}
+		rec = publishRecord{Version: prof.Version, TemplateID: templateID, PublishedAt: time.Now()}
+		if err := p.store.Put(store.BucketProfiles, key, rec); err != nil {
+			return err
+		}
+		p.logger.WithFields(logrus.Fields{
+			"api_url":     apiURL,
+			"device_type": prof.DeviceType,
+			"version":     prof.Version,
+			"template_id": templateID,
+		}).Info("设备能力模型已发布")
+	}
+	return errors.Join(errs...)
+}
+
+// publish 创建设备模板及其遥测、属性、命令模型
+func publish(ctx context.Context, client *thingspanel.Client, prof Profile) (string, error) {
+	templateID, err := client.CreateDeviceTemplate(ctx, thingspanel.DeviceTemplate{
+		Name:        prof.Name,
+		Author:      "tp-plugin",
+		Version:     strconv.Itoa(prof.Version),
+		Description: prof.Description,
+		Label:       prof.DeviceType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("创建设备模板失败: %v", err)
+	}
+
+	for _, f := range prof.Telemetry {
+		if err := client.CreateTelemetryModel(ctx, modelItem(templateID, f, "R")); err != nil {
+			return templateID, fmt.Errorf("创建遥测模型 %s 失败: %v", f.Key, err)
+		}
+	}
+	for _, f := range prof.Attributes {
+		flag := "R"
+		if f.Writable {
+			flag = "RW"
+		}
+		if err := client.CreateAttributeModel(ctx, modelItem(templateID, f, flag)); err != nil {
+			return templateID, fmt.Errorf("创建属性模型 %s 失败: %v", f.Key, err)
+		}
+	}
+	for _, c := range prof.Commands {
+		params, err := json.Marshal(c.Params)
+		if err != nil {
+			return templateID, err
+		}
+		if err := client.CreateCommandModel(ctx, thingspanel.CommandModel{
+			DeviceTemplateID: templateID,
+			DataName:         c.Name,
+			DataIdentifier:   c.Identifier,
+			Params:           string(params),
+			Description:      c.Description,
+		}); err != nil {
+			return templateID, fmt.Errorf("创建命令模型 %s 失败: %v", c.Identifier, err)
+		}
+	}
+	return templateID, nil
+}
+
+func modelItem(templateID string, f Field, flag string) thingspanel.ModelItem {
+	name := f.Name
+	if name == "" {
+		name = f.Key
+	}
+	dataType := f.DataType
+	if dataType == "" {
+		dataType = "Number"
+	}
+	return thingspanel.ModelItem{
+		DeviceTemplateID: templateID,
+		DataName:         name,
+		DataIdentifier:   f.Key,
+		ReadWriteFlag:    flag,
+		DataType:         dataType,
+		Unit:             f.Unit,
+		Description:      f.Description,
+	}
+}
+
+// DeviceTypes 返回已加载能力模型的设备类型
+func (p *Publisher) DeviceTypes() []string {
+	if p == nil {
+		return nil
+	}
+	types := make([]string, 0, len(p.profiles))
+	for _, prof := range p.profiles {
+		types = append(types, prof.DeviceType)
+	}
+	return types
+}
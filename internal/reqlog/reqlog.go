@@ -0,0 +1,318 @@
+// internal/reqlog/reqlog.go
+package reqlog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+	"tp-plugin/internal/middleware"
+	"tp-plugin/internal/pkg/redact"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// 记录级别
+const (
+	LevelOff   = "off"   // 不记录
+	LevelBasic = "basic" // 方法、地址、状态码、耗时及错误
+	LevelFull  = "full"  // 另记录隐藏凭证后的请求头、请求体和响应体
+)
+
+// DefaultMaxBody 记录的请求体/响应体默认上限
+const DefaultMaxBody = 4 << 10
+
+// maxRedactBody 超过该大小的请求体/响应体不解析，只记录大小
+const maxRedactBody = 1 << 20
+
+// Config 请求日志配置
+type Config struct {
+	Level      string // off/basic/full，为空时为 off
+	FilePath   string // 请求日志文件，为空使用 logs/requests.log
+	MaxBody    int    // full 级别记录的请求体/响应体字节数上限，超出部分截断，0使用默认值4KB
+	MaxSize    int    // 单个文件最大MB
+	MaxBackups int
+}
+
+// Logger 以JSON行记录插件收到的请求和发往第三方的请求，凭证中的 Secret、ThingsPanelApiKey、
+// 密码及令牌类字段和请求头一律隐藏；不是JSON的内容只记录大小。为nil时不记录
+type Logger struct {
+	full    bool
+	maxBody int
+	logger  *logrus.Logger
+	file    *lumberjack.Logger
+}
+
+// New 创建请求日志，级别为 off 时返回nil
+func New(cfg Config) (*Logger, error) {
+	switch cfg.Level {
+	case "", LevelOff:
+		return nil, nil
+	case LevelBasic, LevelFull:
+	default:
+		return nil, fmt.Errorf("未知的请求日志级别: %s(可选 off/basic/full)", cfg.Level)
+	}
+	if cfg.FilePath == "" {
+		cfg.FilePath = "logs/requests.log"
+	}
+	if cfg.MaxBody <= 0 {
+		cfg.MaxBody = DefaultMaxBody
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 20
+	}
+	file := &lumberjack.Logger{Filename: cfg.FilePath, MaxSize: cfg.MaxSize, MaxBackups: cfg.MaxBackups}
+	logger := logrus.New()
+	logger.SetOutput(file)
+	logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	return &Logger{full: cfg.Level == LevelFull, maxBody: cfg.MaxBody, logger: logger, file: file}, nil
+}
+
+// Close 关闭请求日志文件
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// body 隐藏凭证后的内容，超过上限时截断；不是JSON或过大时只记录大小
+func (l *Logger) body(data []byte, total int) string {
+	if total == 0 {
+		return ""
+	}
+	if total > maxRedactBody {
+		return fmt.Sprintf("<%d 字节，未记录>", total)
+	}
+	out, ok := redact.JSON(data)
+	if !ok {
+		return fmt.Sprintf("<非JSON内容 %d 字节，未记录>", total)
+	}
+	if len(out) > l.maxBody {
+		return string(out[:l.maxBody]) + "...(已截断)"
+	}
+	return string(out)
+}
+
+// Middleware 记录插件收到的请求，需位于 RequestID 之后；WebSocket 升级请求只记录握手
+func (l *Logger) Middleware() middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			var reqBody *capped
+			if l.full && r.Body != nil && r.Body != http.NoBody {
+				reqBody = &capped{}
+				r.Body = &teeBody{ReadCloser: r.Body, buf: reqBody}
+			}
+			rec := &recorder{ResponseWriter: w}
+			if l.full {
+				rec.body = &capped{}
+			}
+			next.ServeHTTP(rec, r)
+
+			fields := logrus.Fields{
+				"direction":   "inbound",
+				"request_id":  middleware.RequestIDFromContext(r.Context()),
+				"method":      r.Method,
+				"url":         redact.URL(r.URL),
+				"status":      rec.Status(),
+				"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"remote":      r.RemoteAddr,
+			}
+			if l.full {
+				fields["request_header"] = redact.Header(r.Header)
+				if reqBody != nil {
+					fields["request_body"] = l.body(reqBody.Bytes(), reqBody.total)
+				}
+				if !rec.hijacked {
+					fields["response_body"] = l.body(rec.body.Bytes(), rec.body.total)
+				}
+			}
+			l.logger.WithFields(fields).Info("inbound")
+		})
+	}
+}
+
+// Transport 记录发往第三方(小智服务端、ThingsPanel接口等)的请求，每次尝试记录一条；next 为nil时使用默认传输层
+func (l *Logger) Transport(next http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{logger: l, next: next}
+}
+
+type transport struct {
+	logger *Logger
+	next   http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper，响应体读取完毕并关闭时写入记录
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	fields := logrus.Fields{
+		"direction":  "outbound",
+		"request_id": middleware.RequestIDFromContext(req.Context()),
+		"method":     req.Method,
+		"url":        redact.URL(req.URL),
+	}
+	if t.logger.full {
+		fields["request_header"] = redact.Header(req.Header)
+		// 请求体可重新读取时才记录，不消耗原请求体
+		if req.GetBody != nil {
+			if rc, err := req.GetBody(); err == nil {
+				buf := &capped{}
+				io.Copy(buf, rc)
+				rc.Close()
+				fields["request_body"] = t.logger.body(buf.Bytes(), buf.total)
+			}
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		fields["duration_ms"] = float64(time.Since(start).Microseconds()) / 1000
+		fields["error"] = err.Error()
+		t.logger.logger.WithFields(fields).Info("outbound")
+		return nil, err
+	}
+	fields["status"] = resp.StatusCode
+	body := &loggedBody{ReadCloser: resp.Body}
+	if t.logger.full {
+		body.buf = &capped{}
+	}
+	body.done = func(err error) {
+		fields["duration_ms"] = float64(time.Since(start).Microseconds()) / 1000
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		if body.buf != nil {
+			fields["response_body"] = t.logger.body(body.buf.Bytes(), body.buf.total)
+		}
+		t.logger.logger.WithFields(fields).Info("outbound")
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// capped 保存前 maxRedactBody 字节，total 为写入的总字节数
+type capped struct {
+	buf   bytes.Buffer
+	total int
+}
+
+func (c *capped) Write(p []byte) (int, error) {
+	n := len(p)
+	c.total += n
+	if room := maxRedactBody - c.buf.Len(); room > 0 {
+		if n > room {
+			p = p[:room]
+		}
+		c.buf.Write(p)
+	}
+	return n, nil
+}
+
+func (c *capped) Bytes() []byte {
+	return c.buf.Bytes()
+}
+
+// teeBody 读取请求体时同时保存一份
+type teeBody struct {
+	io.ReadCloser
+	buf *capped
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+// loggedBody 响应体读取到结尾或关闭时调用一次 done
+type loggedBody struct {
+	io.ReadCloser
+	buf  *capped
+	once sync.Once
+	done func(err error)
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.buf != nil {
+		b.buf.Write(p[:n])
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		b.once.Do(func() { b.done(err) })
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(nil) })
+	return err
+}
+
+// recorder 记录响应状态码和响应体，支持流式响应和 WebSocket 升级
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     *capped
+	hijacked bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	if r.body != nil {
+		r.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// Status 返回响应状态码，未写入时为200
+func (r *recorder) Status() int {
+	if r.hijacked {
+		return http.StatusSwitchingProtocols
+	}
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.hijacked = true
+	return h.Hijack()
+}
@@ -0,0 +1,81 @@
+// internal/script/convert.go
+package script
+
+import (
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// toLua 将JSON解码得到的值转换为Lua值，数组转换为从1开始的表
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch t := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(t)
+	case string:
+		return lua.LString(t)
+	case float64:
+		return lua.LNumber(t)
+	case float32:
+		return lua.LNumber(t)
+	case int:
+		return lua.LNumber(t)
+	case int64:
+		return lua.LNumber(t)
+	case map[string]interface{}:
+		tbl := L.CreateTable(0, len(t))
+		for k, item := range t {
+			tbl.RawSetString(k, toLua(L, item))
+		}
+		return tbl
+	case []interface{}:
+		tbl := L.CreateTable(len(t), 0)
+		for _, item := range t {
+			tbl.Append(toLua(L, item))
+		}
+		return tbl
+	default:
+		return lua.LString(fmt.Sprint(t))
+	}
+}
+
+// fromLua 将Lua值转换回JSON兼容的值，键为 1..n 的表转换为数组，其余转换为对象
+func fromLua(v lua.LValue) interface{} {
+	switch t := v.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		return bool(t)
+	case lua.LString:
+		return string(t)
+	case lua.LNumber:
+		return float64(t)
+	case *lua.LTable:
+		if n := t.Len(); n > 0 {
+			isArray := true
+			count := 0
+			t.ForEach(func(k, _ lua.LValue) {
+				count++
+				if _, ok := k.(lua.LNumber); !ok {
+					isArray = false
+				}
+			})
+			if isArray && count == n {
+				arr := make([]interface{}, 0, n)
+				for i := 1; i <= n; i++ {
+					arr = append(arr, fromLua(t.RawGetInt(i)))
+				}
+				return arr
+			}
+		}
+		m := make(map[string]interface{})
+		t.ForEach(func(k, item lua.LValue) {
+			m[k.String()] = fromLua(item)
+		})
+		return m
+	default:
+		return v.String()
+	}
+}
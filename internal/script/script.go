@@ -0,0 +1,234 @@
+// internal/script/script.go
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// DefaultTimeout 单次脚本执行的默认超时
+const DefaultTimeout = 20 * time.Millisecond
+
+// 脚本虚拟机的调用栈和数据栈上限，限制递归深度和内存占用
+const (
+	callStackSize   = 64
+	registrySize    = 1024
+	registryMaxSize = 64 * 1024
+)
+
+// ErrDropped 脚本返回nil，该条数据不再发送
+var ErrDropped = errors.New("数据已被脚本丢弃")
+
+var scriptRuns = metrics.NewCounterVec("tp_plugin_script_runs_total",
+	"载荷脚本执行次数", "device_type", "result")
+
+// Rule 某一设备类型的载荷脚本，Source 与 File 二选一
+// 脚本需定义 transform(values, device) 函数，返回新的数据表，返回nil时丢弃该条数据；
+// device 包含 device_number 和 device_type
+type Rule struct {
+	DeviceType string
+	Source     string
+	File       string
+}
+
+// Config 载荷脚本配置
+type Config struct {
+	Timeout time.Duration // 单次执行超时，0时使用 DefaultTimeout
+	Rules   []Rule
+}
+
+// program 编译后的脚本及其虚拟机池
+type program struct {
+	deviceType string
+	proto      *lua.FunctionProto
+	pool       sync.Pool
+}
+
+// Engine 按设备类型执行载荷脚本
+// 虚拟机只开放 base(去掉文件加载相关函数)、table、string、math 库，不能访问文件、网络和进程
+type Engine struct {
+	programs map[string]*program
+	timeout  time.Duration
+	logger   *logrus.Logger
+}
+
+// New 编译全部脚本，没有规则时返回nil
+func New(cfg Config, logger *logrus.Logger) (*Engine, error) {
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	e := &Engine{
+		programs: make(map[string]*program, len(cfg.Rules)),
+		timeout:  cfg.Timeout,
+		logger:   logger,
+	}
+	if e.timeout <= 0 {
+		e.timeout = DefaultTimeout
+	}
+	for _, rule := range cfg.Rules {
+		if rule.DeviceType == "" {
+			return nil, errors.New("载荷脚本缺少 device_type")
+		}
+		if _, dup := e.programs[rule.DeviceType]; dup {
+			return nil, fmt.Errorf("载荷脚本 device_type 重复: %s", rule.DeviceType)
+		}
+		source, name := rule.Source, rule.DeviceType
+		if rule.File != "" {
+			data, err := os.ReadFile(rule.File)
+			if err != nil {
+				return nil, fmt.Errorf("读取载荷脚本失败: %v", err)
+			}
+			source, name = string(data), rule.File
+		}
+		proto, err := compile(source, name)
+		if err != nil {
+			return nil, err
+		}
+		p := &program{deviceType: rule.DeviceType, proto: proto}
+		// 加载一次以检查 transform 函数是否存在
+		L, err := p.get()
+		if err != nil {
+			return nil, fmt.Errorf("加载载荷脚本 %s 失败: %v", name, err)
+		}
+		p.pool.Put(L)
+		e.programs[rule.DeviceType] = p
+		logger.WithFields(logrus.Fields{
+			"device_type": rule.DeviceType,
+			"script":      name,
+		}).Info("载荷脚本已加载")
+	}
+	return e, nil
+}
+
+func compile(source, name string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, fmt.Errorf("解析载荷脚本 %s 失败: %v", name, err)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("编译载荷脚本 %s 失败: %v", name, err)
+	}
+	return proto, nil
+}
+
+// get 从池中取出虚拟机，池为空时创建并执行脚本定义 transform 函数
+func (p *program) get() (*lua.LState, error) {
+	if L, ok := p.pool.Get().(*lua.LState); ok {
+		return L, nil
+	}
+	L := newSandbox()
+	L.Push(L.NewFunctionFromProto(p.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if L.GetGlobal("transform").Type() != lua.LTFunction {
+		L.Close()
+		return nil, errors.New("脚本未定义 transform 函数")
+	}
+	return L, nil
+}
+
+// newSandbox 创建只开放安全库的虚拟机
+func newSandbox() *lua.LState {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   callStackSize,
+		RegistrySize:    registrySize,
+		RegistryMaxSize: registryMaxSize,
+	})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage", "setfenv", "getfenv"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// Apply 执行设备类型对应的脚本，没有脚本时原样返回；脚本返回nil时返回 ErrDropped
+func (e *Engine) Apply(deviceType, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+	if e == nil {
+		return values, nil
+	}
+	p, ok := e.programs[deviceType]
+	if !ok {
+		return values, nil
+	}
+	out, err := e.run(p, deviceNumber, values)
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrDropped):
+		result = "dropped"
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	scriptRuns.WithLabelValues(deviceType, result).Inc()
+	return out, err
+}
+
+func (e *Engine) run(p *program, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+	L, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	device := L.NewTable()
+	device.RawSetString("device_number", lua.LString(deviceNumber))
+	device.RawSetString("device_type", lua.LString(p.deviceType))
+	err = L.CallByParam(lua.P{Fn: L.GetGlobal("transform"), NRet: 1, Protect: true}, toLua(L, values), device)
+	if err != nil {
+		// 超时或出错后虚拟机状态不确定，直接丢弃不放回池中
+		L.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("载荷脚本执行超时: %w", context.DeadlineExceeded)
+		}
+		return nil, fmt.Errorf("载荷脚本执行失败: %v", err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	L.RemoveContext()
+	p.pool.Put(L)
+
+	switch v := ret.(type) {
+	case *lua.LNilType:
+		return nil, ErrDropped
+	case *lua.LTable:
+		out, ok := fromLua(v).(map[string]interface{})
+		if !ok {
+			if v.Len() == 0 {
+				return map[string]interface{}{}, nil
+			}
+			return nil, errors.New("载荷脚本须返回以字段名为键的表")
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("载荷脚本返回值类型错误: %s", ret.Type())
+	}
+}
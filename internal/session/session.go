@@ -0,0 +1,258 @@
+// internal/session/session.go
+package session
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 默认参数
+const (
+	DefaultTimeout = 60 * time.Second
+	forgetAfter    = 24 * time.Hour // 超时下线后超过该时长仍未重连的设备不再跟踪
+)
+
+var (
+	heartbeatTimeouts = metrics.NewCounterVec("tp_plugin_heartbeat_timeouts_total",
+		"心跳超时的设备次数", "result")
+	heartbeatDevices = metrics.NewGaugeVec("tp_plugin_heartbeat_devices",
+		"按心跳跟踪的设备数", "state")
+)
+
+// Config 设备会话心跳配置
+type Config struct {
+	Timeout time.Duration // 超过该时长未收到设备数据即上报下线，0使用默认值60秒
+}
+
+// DeviceState 一台设备的心跳状态
+type DeviceState struct {
+	DeviceID     string    `json:"device_id"`
+	DeviceNumber string    `json:"device_number,omitempty"`
+	Online       bool      `json:"online"`
+	LastSeen     time.Time `json:"last_seen"`
+	OfflineAt    time.Time `json:"offline_at,omitempty"` // 心跳超时上报下线的时间
+}
+
+// device 一台设备的跟踪状态
+type device struct {
+	number    string
+	lastSeen  time.Time
+	online    bool
+	offlineAt time.Time
+}
+
+// StatusPublisher 上报设备上下线状态，由平台客户端实现
+type StatusPublisher interface {
+	SendDeviceStatus(deviceID string, msg interface{}) error
+	Online(deviceID string) bool // 平台上设备是否已在线
+}
+
+// Manager 跟踪经小智服务端回调上报数据的设备(不经设备WebSocket直连)，超过心跳超时未收到数据时
+// 向平台上报下线，设备再次上报数据时上报上线。直连设备由设备WebSocket服务按连接判断，不在此跟踪
+type Manager struct {
+	platform StatusPublisher
+	logger   *logrus.Logger
+	now      func() time.Time // 当前时间，测试中替换
+	timeout  atomic.Int64     // 纳秒，可通过 SetTimeout 热更新
+
+	mu      sync.Mutex
+	devices map[string]*device // 设备ID -> 状态
+	keep    func(deviceNumber string) bool
+	stopCh  chan struct{}
+	once    sync.Once
+}
+
+// New 创建会话管理器
+func New(cfg Config, platform StatusPublisher, logger *logrus.Logger) *Manager {
+	m := &Manager{
+		platform: platform,
+		logger:   logger,
+		now:      time.Now,
+		devices:  make(map[string]*device),
+		stopCh:   make(chan struct{}),
+	}
+	m.SetTimeout(cfg.Timeout)
+	return m
+}
+
+// SetTimeout 更新心跳超时，下一次检查起生效
+func (m *Manager) SetTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultTimeout
+	}
+	m.timeout.Store(int64(d))
+}
+
+// KeepOnline 设置超时前的检查函数，返回true时(设备已直连、维护中等)不上报下线并重新计时，需在 Start 之前调用
+func (m *Manager) KeepOnline(fn func(deviceNumber string) bool) {
+	m.keep = fn
+}
+
+// Start 开始定期检查心跳超时，检查间隔为心跳超时的四分之一(至少1秒)
+func (m *Manager) Start() {
+	go func() {
+		for {
+			interval := time.Duration(m.timeout.Load()) / 4
+			if interval < time.Second {
+				interval = time.Second
+			}
+			select {
+			case <-m.stopCh:
+				return
+			case <-time.After(interval):
+				m.sweep()
+			}
+		}
+	}()
+}
+
+// Stop 停止检查
+func (m *Manager) Stop() {
+	m.once.Do(func() { close(m.stopCh) })
+}
+
+// Seen 记录设备上报了数据；设备此前因心跳超时下线时上报上线
+func (m *Manager) Seen(deviceID, deviceNumber string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	d := m.devices[deviceID]
+	if d == nil {
+		// 首次上报数据的设备，平台上已在线(如已收到上线事件)时不重复上报
+		d = &device{number: deviceNumber, online: m.platform.Online(deviceID)}
+		m.devices[deviceID] = d
+	}
+	d.lastSeen = m.now()
+	if deviceNumber != "" {
+		d.number = deviceNumber
+	}
+	reconnected := !d.online
+	d.online = true
+	d.offlineAt = time.Time{}
+	m.updateGauge()
+	m.mu.Unlock()
+	if !reconnected {
+		return
+	}
+	if err := m.platform.SendDeviceStatus(deviceID, "1"); err != nil {
+		m.logger.WithError(err).WithField("device_number", deviceNumber).Warn("上报设备上线失败")
+		return
+	}
+	m.logger.WithField("device_number", deviceNumber).Info("设备恢复上报数据，已上报上线")
+}
+
+// Online 记录设备已上线(调用方已上报上线状态)，从此时开始计时
+func (m *Manager) Online(deviceID, deviceNumber string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices[deviceID] = &device{number: deviceNumber, lastSeen: m.now(), online: true}
+	m.updateGauge()
+}
+
+// Forget 设备已明确下线(断开事件、平台解绑等)，不再跟踪
+func (m *Manager) Forget(deviceID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.devices, deviceID)
+	m.updateGauge()
+}
+
+// sweep 将心跳超时的在线设备标记为下线并上报，清理长期未重连的设备
+func (m *Manager) sweep() {
+	now := m.now()
+	timeout := time.Duration(m.timeout.Load())
+	type expired struct{ id, number string }
+	var list []expired
+	m.mu.Lock()
+	for id, d := range m.devices {
+		switch {
+		case d.online && now.Sub(d.lastSeen) > timeout:
+			list = append(list, expired{id, d.number})
+		case !d.online && now.Sub(d.offlineAt) > forgetAfter:
+			delete(m.devices, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, e := range list {
+		// 检查函数可能较慢(如查询维护状态)，不持有锁调用
+		if m.keep != nil && m.keep(e.number) {
+			m.mu.Lock()
+			if d := m.devices[e.id]; d != nil {
+				d.lastSeen = now
+			}
+			m.mu.Unlock()
+			heartbeatTimeouts.WithLabelValues("kept").Inc()
+			continue
+		}
+		m.mu.Lock()
+		d := m.devices[e.id]
+		// 检查期间设备已重新上报或被移除
+		stale := d != nil && d.online && now.Sub(d.lastSeen) > timeout
+		if stale {
+			d.online = false
+			d.offlineAt = now
+		}
+		m.updateGauge()
+		m.mu.Unlock()
+		if !stale {
+			continue
+		}
+		heartbeatTimeouts.WithLabelValues("offline").Inc()
+		if err := m.platform.SendDeviceStatus(e.id, "0"); err != nil {
+			m.logger.WithError(err).WithField("device_number", e.number).Warn("上报设备下线失败")
+			continue
+		}
+		m.logger.WithField("device_number", e.number).WithField("timeout", timeout).Info("设备心跳超时，已上报下线")
+	}
+}
+
+// updateGauge 调用方需持有锁
+func (m *Manager) updateGauge() {
+	online := 0
+	for _, d := range m.devices {
+		if d.online {
+			online++
+		}
+	}
+	heartbeatDevices.WithLabelValues("online").Set(float64(online))
+	heartbeatDevices.WithLabelValues("offline").Set(float64(len(m.devices) - online))
+}
+
+// Devices 返回跟踪中的设备，心跳超时下线的排在前面，其余按最近上报时间倒序
+func (m *Manager) Devices() []DeviceState {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	list := make([]DeviceState, 0, len(m.devices))
+	for id, d := range m.devices {
+		list = append(list, DeviceState{
+			DeviceID:     id,
+			DeviceNumber: d.number,
+			Online:       d.online,
+			LastSeen:     d.lastSeen,
+			OfflineAt:    d.offlineAt,
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Online != list[j].Online {
+			return !list[i].Online
+		}
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
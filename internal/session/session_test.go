@@ -0,0 +1,169 @@
+package session
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakePlatform 记录上报的上下线状态
+type fakePlatform struct {
+	mu       sync.Mutex
+	statuses []string // 设备ID:状态
+	online   map[string]bool
+}
+
+func (f *fakePlatform) SendDeviceStatus(deviceID string, msg interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses = append(f.statuses, deviceID+":"+msg.(string))
+	return nil
+}
+
+func (f *fakePlatform) Online(deviceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.online[deviceID]
+}
+
+func (f *fakePlatform) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.statuses...)
+}
+
+// fakeClock 手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func newTestManager(t *testing.T) (*Manager, *fakePlatform, *fakeClock) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := &fakePlatform{online: make(map[string]bool)}
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := New(Config{Timeout: time.Minute}, p, logger)
+	m.now = clock.Now
+	return m, p, clock
+}
+
+func assertSent(t *testing.T, p *fakePlatform, want ...string) {
+	t.Helper()
+	got := p.sent()
+	if len(got) != len(want) {
+		t.Fatalf("上报状态 %v，期望 %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("上报状态 %v，期望 %v", got, want)
+		}
+	}
+}
+
+func TestSweepTimeoutSendsOffline(t *testing.T) {
+	m, p, clock := newTestManager(t)
+	m.Online("dev-1", "A1")
+
+	clock.Advance(59 * time.Second)
+	m.sweep()
+	assertSent(t, p)
+
+	clock.Advance(2 * time.Second)
+	m.sweep()
+	assertSent(t, p, "dev-1:0")
+
+	// 已下线的设备不重复上报
+	clock.Advance(time.Minute)
+	m.sweep()
+	assertSent(t, p, "dev-1:0")
+	if states := m.Devices(); len(states) != 1 || states[0].Online || !states[0].OfflineAt.Equal(clock.Now().Add(-time.Minute)) {
+		t.Fatalf("设备状态 %+v", states)
+	}
+}
+
+func TestSeenAfterTimeoutSendsOnline(t *testing.T) {
+	m, p, clock := newTestManager(t)
+	m.Online("dev-1", "A1")
+	clock.Advance(2 * time.Minute)
+	m.sweep()
+	assertSent(t, p, "dev-1:0")
+
+	m.Seen("dev-1", "A1")
+	assertSent(t, p, "dev-1:0", "dev-1:1")
+
+	// 重新上线后从最近一次上报开始计时
+	clock.Advance(30 * time.Second)
+	m.sweep()
+	assertSent(t, p, "dev-1:0", "dev-1:1")
+	if states := m.Devices(); len(states) != 1 || !states[0].Online {
+		t.Fatalf("设备状态 %+v", states)
+	}
+}
+
+func TestSeenOnlineDeviceDoesNotResend(t *testing.T) {
+	m, p, _ := newTestManager(t)
+	p.online["dev-1"] = true
+	m.Seen("dev-1", "A1")
+	m.Seen("dev-1", "A1")
+	assertSent(t, p)
+}
+
+func TestForgetStopsTimer(t *testing.T) {
+	m, p, clock := newTestManager(t)
+	m.Online("dev-1", "A1")
+	m.Forget("dev-1")
+
+	clock.Advance(time.Hour)
+	m.sweep()
+	assertSent(t, p)
+	if states := m.Devices(); len(states) != 0 {
+		t.Fatalf("Forget 后仍在跟踪: %+v", states)
+	}
+}
+
+func TestKeepOnlineSuppressesTimeout(t *testing.T) {
+	m, p, clock := newTestManager(t)
+	keep := true
+	var checked []string
+	m.KeepOnline(func(number string) bool {
+		checked = append(checked, number)
+		return keep
+	})
+	m.Online("dev-1", "A1")
+
+	clock.Advance(2 * time.Minute)
+	m.sweep()
+	assertSent(t, p)
+	if len(checked) != 1 || checked[0] != "A1" {
+		t.Fatalf("检查函数调用 %v", checked)
+	}
+
+	// 保持在线后重新计时，未再次超时前不检查
+	clock.Advance(30 * time.Second)
+	m.sweep()
+	if len(checked) != 1 {
+		t.Fatalf("未超时也调用了检查函数: %v", checked)
+	}
+
+	keep = false
+	clock.Advance(time.Minute)
+	m.sweep()
+	assertSent(t, p, "dev-1:0")
+}
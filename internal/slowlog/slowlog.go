@@ -0,0 +1,190 @@
+// internal/slowlog/slowlog.go
+package slowlog
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var slowCalls = metrics.NewCounterVec("tp_plugin_upstream_slow_calls_total",
+	"Upstream calls slower than the slow log threshold.", "host")
+
+// Config 慢调用日志配置
+type Config struct {
+	Threshold  time.Duration // 超过该耗时的调用写入慢日志，<=0 不记录
+	FilePath   string        // 慢日志文件
+	MaxSize    int           // 单个文件最大MB
+	MaxBackups int
+}
+
+// Recorder 记录超过阈值的出站调用及其分阶段耗时，为nil时不记录
+type Recorder struct {
+	threshold time.Duration
+	logger    *logrus.Logger
+	file      *lumberjack.Logger
+}
+
+// New 创建慢调用记录器，阈值未配置时返回nil
+func New(cfg Config) *Recorder {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	if cfg.FilePath == "" {
+		cfg.FilePath = "logs/slow.log"
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 20
+	}
+	file := &lumberjack.Logger{Filename: cfg.FilePath, MaxSize: cfg.MaxSize, MaxBackups: cfg.MaxBackups}
+	logger := logrus.New()
+	logger.SetOutput(file)
+	logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	return &Recorder{threshold: cfg.Threshold, logger: logger, file: file}
+}
+
+// Close 关闭慢日志文件
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// Transport 包装传输层，通过 httptrace 采集 DNS、连接、TLS、首字节及读取响应体的耗时；next 为nil时使用默认传输层
+func (r *Recorder) Transport(next http.RoundTripper) http.RoundTripper {
+	if r == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{recorder: r, next: next}
+}
+
+type transport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+// timing 一次调用的各阶段时间点
+type timing struct {
+	mu                  sync.Mutex
+	start               time.Time
+	dnsStart, dnsDone   time.Time
+	connStart, connDone time.Time
+	tlsStart, tlsDone   time.Time
+	wroteRequest        time.Time
+	firstByte           time.Time
+	reused              bool
+	remoteAddr          string
+}
+
+func (t *timing) set(p *time.Time, v time.Time) {
+	t.mu.Lock()
+	*p = v
+	t.mu.Unlock()
+}
+
+func (t *timing) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { t.set(&t.dnsStart, time.Now()) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone, time.Now()) },
+		ConnectStart:      func(string, string) { t.set(&t.connStart, time.Now()) },
+		ConnectDone:       func(string, string, error) { t.set(&t.connDone, time.Now()) },
+		TLSHandshakeStart: func() { t.set(&t.tlsStart, time.Now()) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.set(&t.tlsDone, time.Now()) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			if info.Conn != nil {
+				t.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+			t.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wroteRequest, time.Now()) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte, time.Now()) },
+	}
+}
+
+func span(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return float64(to.Sub(from).Microseconds()) / 1000
+}
+
+// RoundTrip 实现 http.RoundTripper，响应体读取完毕或关闭时判断是否超过阈值
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tm := &timing{start: time.Now()}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tm.trace()))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.recorder.finish(req, 0, tm, time.Now(), err)
+		return nil, err
+	}
+	resp.Body = &timedBody{ReadCloser: resp.Body, done: func(err error) {
+		t.recorder.finish(req, resp.StatusCode, tm, time.Now(), err)
+	}}
+	return resp, nil
+}
+
+func (r *Recorder) finish(req *http.Request, status int, tm *timing, end time.Time, err error) {
+	total := end.Sub(tm.start)
+	if total < r.threshold {
+		return
+	}
+	slowCalls.WithLabelValues(req.URL.Host).Inc()
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	fields := logrus.Fields{
+		"method":        req.Method,
+		"url":           req.URL.String(),
+		"status":        status,
+		"remote_addr":   tm.remoteAddr,
+		"reused_conn":   tm.reused,
+		"total_ms":      float64(total.Microseconds()) / 1000,
+		"dns_ms":        span(tm.dnsStart, tm.dnsDone),
+		"connect_ms":    span(tm.connStart, tm.connDone),
+		"tls_ms":        span(tm.tlsStart, tm.tlsDone),
+		"ttfb_ms":       span(tm.wroteRequest, tm.firstByte),
+		"body_ms":       span(tm.firstByte, end),
+		"threshold_ms":  r.threshold.Milliseconds(),
+		"request_bytes": req.ContentLength,
+	}
+	entry := r.logger.WithFields(fields)
+	if err != nil && err != io.EOF {
+		entry = entry.WithError(err)
+	}
+	entry.Warn("小智服务端慢调用")
+}
+
+// timedBody 响应体读到EOF或关闭时回调一次
+type timedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func(err error)
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(func() { b.done(err) })
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(nil) })
+	return err
+}
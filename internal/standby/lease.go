@@ -0,0 +1,329 @@
+// internal/standby/lease.go
+package standby
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// retainedWait 订阅租约主题后等待保留消息的时长
+const retainedWait = 2 * time.Second
+
+// LeaseConfig 主实例租约所用的MQTT服务器，一般为平台的MQTT服务器；两个实例都能访问，作为判断谁是主实例的第三方
+type LeaseConfig struct {
+	Broker   string // MQTT服务器地址，如 tcp://10.0.0.5:1883
+	Username string
+	Password string
+}
+
+// leaseRecord 租约内容，以保留消息发布在租约主题上
+type leaseRecord struct {
+	Holder    string    `json:"holder"` // 持有租约的实例
+	Epoch     int64     `json:"epoch"`  // 每次接管加一，较大者有效
+	RenewedAt time.Time `json:"renewed_at"`
+}
+
+// outranks 比较两个租约，epoch 相同(两个实例同时接管)时按实例名决定
+func (r leaseRecord) outranks(o leaseRecord) bool {
+	return r.Epoch > o.Epoch || (r.Epoch == o.Epoch && r.Holder > o.Holder)
+}
+
+// leaseBus 租约消息的发布和订阅
+type leaseBus interface {
+	publish(data []byte) error
+	// subscribe 注册租约消息回调，retained 表示订阅时收到的保留消息
+	subscribe(fn func(data []byte, retained bool))
+	connected() bool
+	close()
+}
+
+// Lease 主实例租约：主实例每个同步间隔续约一次，备用实例接管前确认租约已停止续约，
+// 接管时以更大的 epoch 获取租约；原主实例(如网络分区恢复后)收到更大 epoch 的租约即被隔离，应立即停止服务
+type Lease struct {
+	holder   string
+	interval time.Duration
+	bus      leaseBus
+	logger   *logrus.Logger
+
+	mu        sync.Mutex
+	current   leaseRecord // 已收到的最大租约
+	seen      bool        // 已收到租约消息(含保留消息)
+	renewedAt time.Time   // 最近一次收到其他实例续约的时间(本地时间)
+	held      *leaseRecord
+	fenced    chan struct{}
+	stop      chan struct{}
+	seenCh    chan struct{}
+}
+
+// NewLease 连接租约所用的MQTT服务器并订阅租约主题；未启用租约时返回nil
+func NewLease(cfg Config, lc LeaseConfig, logger *logrus.Logger) (*Lease, error) {
+	if !cfg.Lease {
+		return nil, nil
+	}
+	if lc.Broker == "" {
+		return nil, fmt.Errorf("启用主实例租约需配置MQTT服务器")
+	}
+	holder := cfg.instance()
+	bus := dialMQTTBus(lc, cfg.leaseTopic(), holder, logger)
+	return newLease(holder, cfg.syncInterval(), bus, logger), nil
+}
+
+func newLease(holder string, interval time.Duration, bus leaseBus, logger *logrus.Logger) *Lease {
+	l := &Lease{
+		holder:   holder,
+		interval: interval,
+		bus:      bus,
+		logger:   logger,
+		fenced:   make(chan struct{}),
+		stop:     make(chan struct{}),
+		seenCh:   make(chan struct{}),
+	}
+	bus.subscribe(l.observe)
+	return l
+}
+
+// observe 处理收到的租约消息
+func (l *Lease) observe(data []byte, retained bool) {
+	var rec leaseRecord
+	if json.Unmarshal(data, &rec) != nil || rec.Holder == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.seen {
+		l.seen = true
+		close(l.seenCh)
+	}
+	if rec.outranks(l.current) || rec == l.current {
+		l.current = rec
+	}
+	if rec.Holder == l.holder {
+		return
+	}
+	if !retained {
+		l.renewedAt = time.Now()
+	}
+	if l.held != nil && rec.outranks(*l.held) {
+		select {
+		case <-l.fenced:
+		default:
+			l.logger.WithFields(logrus.Fields{
+				"holder": rec.Holder,
+				"epoch":  rec.Epoch,
+				"own":    l.held.Epoch,
+			}).Error("对端以更大的租约接管，本实例已被隔离")
+			close(l.fenced)
+		}
+	}
+}
+
+// HeldByPeer 判断对端是否仍持有租约：保留消息显示租约属于其他实例时，等待两个续约周期内是否收到对端续约；
+// 期间连接不上MQTT服务器时无法判断，按对端持有处理，避免与主实例同时运行。l 为nil时返回 false
+func (l *Lease) HeldByPeer(ctx context.Context) bool {
+	if l == nil {
+		return false
+	}
+	start := time.Now()
+	deadline := time.NewTimer(2 * l.interval)
+	defer deadline.Stop()
+	tick := time.NewTicker(l.interval / 10)
+	defer tick.Stop()
+	for !l.bus.connected() {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-deadline.C:
+			l.logger.Warn("无法连接租约所用的MQTT服务器，按对端持有租约处理")
+			return true
+		case <-tick.C:
+		}
+	}
+	// 主题上没有租约时不会收到保留消息
+	select {
+	case <-l.seenCh:
+	case <-time.After(retainedWait):
+	case <-ctx.Done():
+		return true
+	}
+	l.mu.Lock()
+	rec := l.current
+	l.mu.Unlock()
+	if rec.Holder == "" || rec.Holder == l.holder {
+		return false
+	}
+	for {
+		l.mu.Lock()
+		renewed := l.renewedAt.After(start)
+		l.mu.Unlock()
+		if renewed {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return true
+		case <-deadline.C:
+			return false
+		case <-tick.C:
+		}
+	}
+}
+
+// Acquire 以比已知租约大一的 epoch 获取租约并开始定期续约，发布失败时在下一个续约周期重试；l 为nil时不处理
+func (l *Lease) Acquire() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	rec := leaseRecord{Holder: l.holder, Epoch: l.current.Epoch + 1}
+	if l.current.Holder == l.holder {
+		// 本实例重启，沿用原租约
+		rec.Epoch = l.current.Epoch
+	}
+	if rec.Epoch == 0 {
+		rec.Epoch = 1
+	}
+	l.held = &rec
+	l.mu.Unlock()
+	log := l.logger.WithFields(logrus.Fields{"holder": rec.Holder, "epoch": rec.Epoch})
+	if err := l.renew(); err != nil {
+		log.WithError(err).Warn("发布主实例租约失败")
+	} else {
+		log.Info("已获取主实例租约")
+	}
+	go l.keepAlive()
+}
+
+func (l *Lease) renew() error {
+	l.mu.Lock()
+	rec := *l.held
+	l.mu.Unlock()
+	rec.RenewedAt = time.Now()
+	data, _ := json.Marshal(rec)
+	return l.bus.publish(data)
+}
+
+func (l *Lease) keepAlive() {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.fenced:
+			return
+		case <-ticker.C:
+			if err := l.renew(); err != nil {
+				l.logger.WithError(err).Warn("续约主实例租约失败")
+			}
+		}
+	}
+}
+
+// Fenced 本实例持有的租约被对端以更大的 epoch 取代时关闭；l 为nil时返回nil(永不关闭)
+func (l *Lease) Fenced() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	return l.fenced
+}
+
+// Close 停止续约并断开MQTT连接，租约保留在主题上，备用实例等待一个续约周期未收到续约后接管
+func (l *Lease) Close() {
+	if l == nil {
+		return
+	}
+	close(l.stop)
+	l.bus.close()
+}
+
+// mqttBus 以MQTT保留消息传递租约
+type mqttBus struct {
+	client mqtt.Client
+	topic  string
+	mu     sync.Mutex
+	fn     func(data []byte, retained bool)
+}
+
+func dialMQTTBus(lc LeaseConfig, topic, holder string, logger *logrus.Logger) *mqttBus {
+	b := &mqttBus{topic: topic}
+	broker := lc.Broker
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("lease-%s-%d", holder, time.Now().UnixNano()%100000)).
+		SetUsername(lc.Username).
+		SetPassword(lc.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// 重连后重新订阅，再次收到保留消息
+			c.Subscribe(topic, 1, func(_ mqtt.Client, m mqtt.Message) {
+				b.mu.Lock()
+				fn := b.fn
+				b.mu.Unlock()
+				if fn != nil {
+					fn(m.Payload(), m.Retained())
+				}
+			})
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.WithError(err).Warn("租约MQTT连接断开")
+		})
+	b.client = mqtt.NewClient(opts)
+	return b
+}
+
+func (b *mqttBus) publish(data []byte) error {
+	if !b.client.IsConnectionOpen() {
+		return fmt.Errorf("MQTT未连接")
+	}
+	token := b.client.Publish(b.topic, 1, true, data)
+	if !token.WaitTimeout(requestTimeout) {
+		return fmt.Errorf("发布租约超时")
+	}
+	return token.Error()
+}
+
+// subscribe 注册回调后再连接，不会错过连接后立即收到的保留消息
+func (b *mqttBus) subscribe(fn func(data []byte, retained bool)) {
+	b.mu.Lock()
+	b.fn = fn
+	b.mu.Unlock()
+	b.client.Connect()
+}
+
+func (b *mqttBus) connected() bool {
+	return b.client.IsConnectionOpen()
+}
+
+func (b *mqttBus) close() {
+	b.client.Disconnect(250)
+}
+
+// instance 实例名，默认为主机名
+func (c Config) instance() string {
+	if c.Instance != "" {
+		return c.Instance
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "plugin"
+}
+
+func (c Config) leaseTopic() string {
+	if c.LeaseTopic != "" {
+		return c.LeaseTopic
+	}
+	return DefaultLeaseTopic
+}
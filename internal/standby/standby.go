@@ -0,0 +1,267 @@
+// internal/standby/standby.go
+package standby
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/store"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// 实例角色
+const (
+	RoleActive  = "active"  // 主实例：监听端口、连接平台并提供存储快照
+	RoleStandby = "standby" // 备用实例：只同步主实例的存储，主实例持续不可用时接管
+)
+
+// 复制接口路径
+const (
+	SnapshotPath = "/replication/snapshot" // GET 存储快照(BoltDB文件)
+	StatusPath   = "/replication/status"   // GET 实例角色
+)
+
+// 默认同步参数
+const (
+	DefaultSyncInterval  = 10 * time.Second
+	DefaultFailoverAfter = 30 * time.Second
+	DefaultLeaseTopic    = "tp-plugin/standby/lease"
+	requestTimeout       = 30 * time.Second
+)
+
+var (
+	standbySyncs = metrics.NewCounterVec("tp_plugin_standby_syncs_total",
+		"备用实例同步主实例存储的次数", "result")
+	standbyBytes = metrics.NewGaugeVec("tp_plugin_standby_snapshot_bytes",
+		"最近一次提供或同步的存储快照大小")
+)
+
+// Config 主备部署配置，两个实例互相将对方配置为 Peer
+type Config struct {
+	Role            string // active/standby，为空时按 active 运行
+	Peer            string // 对端实例地址(含 base_path)，如 http://10.0.0.2:8080
+	Token           string // 复制接口令牌，两端一致；为空时不提供快照
+	SyncSeconds     int    // 同步间隔，0使用默认值10
+	FailoverSeconds int    // 连续同步失败超过该时长后接管，0使用默认值30
+	// Lease 启用主实例租约(见 Lease)：与对端之间的网络中断而主实例仍在续约时不接管，接管后原主实例被隔离
+	Lease      bool
+	LeaseTopic string // 租约主题，两端一致，为空时使用 DefaultLeaseTopic
+	Instance   string // 实例名，两端不同，为空时使用主机名
+	// TakeoverCommand 接管时、打开存储和监听端口之前执行的命令(sh -c)，如将虚拟IP或DNS切换到本机；为空时不执行
+	TakeoverCommand string
+}
+
+func (c Config) syncInterval() time.Duration {
+	if c.SyncSeconds > 0 {
+		return time.Duration(c.SyncSeconds) * time.Second
+	}
+	return DefaultSyncInterval
+}
+
+func (c Config) failoverAfter() time.Duration {
+	if c.FailoverSeconds > 0 {
+		return time.Duration(c.FailoverSeconds) * time.Second
+	}
+	return DefaultFailoverAfter
+}
+
+// Status 实例状态，备用实例启动时据此判断对端是否已接管
+type Status struct {
+	Role      string    `json:"role"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Handler 主实例的复制接口，以 Authorization: Bearer <token> 鉴权；正在运行的实例总是主实例
+func Handler(cfg Config, st *store.Store, startedAt time.Time, logger *logrus.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if cfg.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, StatusPath) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(Status{Role: RoleActive, StartedAt: startedAt})
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		n, err := st.Backup(w)
+		if err != nil {
+			logger.WithError(err).WithField("remote_addr", r.RemoteAddr).Warn("发送存储快照失败")
+			return
+		}
+		standbyBytes.WithLabelValues().Set(float64(n))
+	})
+}
+
+// PeerActive 查询对端是否正以主实例运行，对端不可达或未配置时返回 false
+func PeerActive(ctx context.Context, cfg Config) bool {
+	if cfg.Peer == "" || cfg.Token == "" {
+		return false
+	}
+	resp, err := get(ctx, cfg, StatusPath)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var s Status
+	return json.NewDecoder(resp.Body).Decode(&s) == nil && s.Role == RoleActive
+}
+
+func get(ctx context.Context, cfg Config, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(cfg.Peer, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	resp, err := httpclient.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("对端返回状态码: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// Follower 备用实例：定期将主实例的存储快照写入本地存储文件，本地存储在接管前不打开。
+// 只复制本地存储；设备缓存、会话令牌、限流和熔断等内存状态不复制，接管后由存储预热或按需重建
+type Follower struct {
+	cfg    Config
+	path   string
+	lease  *Lease
+	logger *logrus.Logger
+}
+
+// NewFollower 创建备用实例的同步器，path 为本地存储文件路径；lease 为nil时只按对端是否可达判断接管
+func NewFollower(cfg Config, path string, lease *Lease, logger *logrus.Logger) (*Follower, error) {
+	if cfg.Peer == "" || cfg.Token == "" {
+		return nil, errors.New("备用实例需配置 peer 和 token")
+	}
+	if path == "" {
+		path = store.DefaultPath
+	}
+	return &Follower{cfg: cfg, path: path, lease: lease, logger: logger}, nil
+}
+
+// Run 同步主实例的存储，直到主实例连续不可用超过 FailoverSeconds 时返回 nil，由调用方继续以主实例启动；
+// ctx 取消时返回 ctx 的错误
+func (f *Follower) Run(ctx context.Context) error {
+	interval, failover := f.cfg.syncInterval(), f.cfg.failoverAfter()
+	f.logger.WithFields(logrus.Fields{
+		"peer":     f.cfg.Peer,
+		"interval": interval.String(),
+		"failover": failover.String(),
+	}).Info("以备用实例运行，同步主实例存储")
+	lastOK, synced := time.Now(), false
+	for {
+		err := f.sync(ctx)
+		switch {
+		case err == nil:
+			standbySyncs.WithLabelValues("ok").Inc()
+			lastOK, synced = time.Now(), true
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			standbySyncs.WithLabelValues("error").Inc()
+			down := time.Since(lastOK)
+			f.logger.WithError(err).WithField("down", down.Round(time.Second).String()).Warn("同步主实例存储失败")
+			if down >= failover {
+				log := f.logger.WithField("down", down.Round(time.Second).String())
+				if f.lease.HeldByPeer(ctx) {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					log.Warn("主实例仍在续约租约，可能只是与主实例之间的网络中断，暂不接管")
+					break
+				}
+				if !synced {
+					log.Warn("未同步到主实例存储，以本地已有存储接管")
+				}
+				log.Warn("主实例持续不可用，备用实例接管")
+				f.takeover(ctx)
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// takeover 执行接管命令，失败时记录错误后继续接管
+func (f *Follower) takeover(ctx context.Context) {
+	if f.cfg.TakeoverCommand == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sh", "-c", f.cfg.TakeoverCommand).CombinedOutput()
+	log := f.logger.WithField("output", strings.TrimSpace(string(out)))
+	if err != nil {
+		log.WithError(err).Error("执行接管命令失败")
+		return
+	}
+	log.Info("接管命令执行完成")
+}
+
+// sync 下载存储快照到临时文件，校验可以打开后替换本地存储文件
+func (f *Follower) sync(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := get(ctx, f.cfg, SnapshotPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp := f.path + ".sync"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(file, resp.Body)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = verify(tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	standbyBytes.WithLabelValues().Set(float64(n))
+	return os.Rename(tmp, f.path)
+}
+
+// verify 以只读方式打开快照，确认是完整的存储文件
+func verify(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("存储快照无效: %v", err)
+	}
+	return db.Close()
+}
@@ -0,0 +1,348 @@
+package standby
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"tp-plugin/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testToken = "replication-token"
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// startActive 启动提供复制接口的主实例，返回对端地址
+func startActive(t *testing.T) (*store.Store, string) {
+	t.Helper()
+	st, err := store.Open(filepath.Join(t.TempDir(), "active.db"), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	cfg := Config{Token: testToken}
+	mux := http.NewServeMux()
+	mux.Handle("/replication/", Handler(cfg, st, time.Now(), testLogger()))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return st, srv.URL
+}
+
+func TestFollowerSync(t *testing.T) {
+	st, peer := startActive(t)
+	if err := st.Put(store.BucketDevices, "A4:CF:12:00:00:01", map[string]string{"id": "d1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "standby.db")
+	f, err := NewFollower(Config{Peer: peer, Token: testToken}, path, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.sync(context.Background()); err != nil {
+		t.Fatalf("同步失败: %v", err)
+	}
+	if _, err := os.Stat(path + ".sync"); !os.IsNotExist(err) {
+		t.Fatal("同步后临时文件未删除")
+	}
+
+	replica, err := store.Open(path, testLogger())
+	if err != nil {
+		t.Fatalf("打开同步到的存储失败: %v", err)
+	}
+	defer replica.Close()
+	var device map[string]string
+	if err := replica.Get(store.BucketDevices, "A4:CF:12:00:00:01", &device); err != nil || device["id"] != "d1" {
+		t.Fatalf("同步到的记录 %v, %v", device, err)
+	}
+}
+
+func TestFollowerSyncRejectsInvalidSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not a bolt database"))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "standby.db")
+	if err := os.WriteFile(path, []byte("previous"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := NewFollower(Config{Peer: srv.URL, Token: testToken}, path, nil, testLogger())
+	if err := f.sync(context.Background()); err == nil {
+		t.Fatal("无效快照同步成功")
+	}
+	// 本地存储保持不变
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Fatalf("无效快照替换了本地存储: %q", data)
+	}
+	if err := verify(path); err == nil {
+		t.Fatal("verify 接受了无效文件")
+	}
+}
+
+func TestFollowerSyncUnauthorized(t *testing.T) {
+	_, peer := startActive(t)
+	f, _ := NewFollower(Config{Peer: peer, Token: "wrong"}, filepath.Join(t.TempDir(), "standby.db"), nil, testLogger())
+	if err := f.sync(context.Background()); err == nil {
+		t.Fatal("令牌错误时同步成功")
+	}
+}
+
+func TestPeerActive(t *testing.T) {
+	_, peer := startActive(t)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	cases := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{"active", Config{Peer: peer + "/", Token: testToken}, true},
+		{"wrong token", Config{Peer: peer, Token: "wrong"}, false},
+		{"unreachable", Config{Peer: closed.URL, Token: testToken}, false},
+		{"no peer", Config{Token: testToken}, false},
+		{"no token", Config{Peer: peer}, false},
+	}
+	for _, c := range cases {
+		if got := PeerActive(context.Background(), c.cfg); got != c.want {
+			t.Errorf("%s: PeerActive 返回 %v", c.name, got)
+		}
+	}
+}
+
+func TestFollowerTakeover(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	marker := filepath.Join(t.TempDir(), "takeover")
+	cfg := Config{
+		Peer:            closed.URL,
+		Token:           testToken,
+		SyncSeconds:     1,
+		FailoverSeconds: 1,
+		TakeoverCommand: "touch " + marker,
+	}
+	f, _ := NewFollower(cfg, filepath.Join(t.TempDir(), "standby.db"), nil, testLogger())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := f.Run(ctx); err != nil {
+		t.Fatalf("主实例不可用时未接管: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("接管命令未执行: %v", err)
+	}
+}
+
+func TestFollowerWaitsWhileLeaseRenewed(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	broker := newMemBroker()
+	active := newLease("a", 20*time.Millisecond, broker.client("a"), testLogger())
+	active.Acquire()
+	defer active.Close()
+
+	// 与主实例之间的网络中断，但主实例仍在续约
+	lease := newLease("b", 20*time.Millisecond, broker.client("b"), testLogger())
+	cfg := Config{Peer: closed.URL, Token: testToken, SyncSeconds: 1, FailoverSeconds: 1}
+	f, _ := NewFollower(cfg, filepath.Join(t.TempDir(), "standby.db"), lease, testLogger())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := f.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("主实例仍在续约时 Run 返回 %v", err)
+	}
+}
+
+func TestLeaseFencing(t *testing.T) {
+	broker := newMemBroker()
+	const interval = 20 * time.Millisecond
+	a := newLease("a", interval, broker.client("a"), testLogger())
+	b := newLease("b", interval, broker.client("b"), testLogger())
+	ctx := context.Background()
+
+	if a.HeldByPeer(ctx) {
+		t.Fatal("没有租约时判断为对端持有")
+	}
+	a.Acquire()
+	if !b.HeldByPeer(ctx) {
+		t.Fatal("主实例续约期间判断为未持有")
+	}
+
+	// 主实例与MQTT服务器断开，停止续约
+	broker.partition("a", true)
+	if b.HeldByPeer(ctx) {
+		t.Fatal("主实例停止续约后仍判断为持有")
+	}
+	b.Acquire()
+	defer b.Close()
+	broker.partition("a", false)
+	// 分区恢复后主实例收到更大的租约即被隔离
+	select {
+	case <-a.Fenced():
+	case <-time.After(time.Second):
+		t.Fatal("原主实例未被隔离")
+	}
+	select {
+	case <-b.Fenced():
+		t.Fatal("新主实例被隔离")
+	default:
+	}
+	if got := broker.retainedRecord(t); got.Holder != "b" || got.Epoch != 2 {
+		t.Fatalf("租约 %+v，期望 b 以 epoch 2 持有", got)
+	}
+	a.Close()
+}
+
+func TestLeaseDisconnected(t *testing.T) {
+	broker := newMemBroker()
+	l := newLease("b", 10*time.Millisecond, broker.client("b"), testLogger())
+	broker.partition("b", true)
+	// 连接不上MQTT服务器时无法确认主实例已停止，不接管
+	if !l.HeldByPeer(context.Background()) {
+		t.Fatal("无法连接MQTT服务器时判断为可接管")
+	}
+}
+
+func TestLeaseRestartKeepsEpoch(t *testing.T) {
+	broker := newMemBroker()
+	first := newLease("a", 10*time.Millisecond, broker.client("a"), testLogger())
+	first.Acquire()
+	first.Close()
+
+	// 同一实例重启：租约属于自己，不等待、沿用原 epoch
+	restarted := newLease("a", 10*time.Millisecond, broker.client("a"), testLogger())
+	if restarted.HeldByPeer(context.Background()) {
+		t.Fatal("本实例持有的租约被判断为对端持有")
+	}
+	restarted.Acquire()
+	defer restarted.Close()
+	if got := broker.retainedRecord(t); got.Holder != "a" || got.Epoch != 1 {
+		t.Fatalf("重启后租约 %+v", got)
+	}
+}
+
+func TestNilLease(t *testing.T) {
+	var l *Lease
+	if l.HeldByPeer(context.Background()) || l.Fenced() != nil {
+		t.Fatal("未启用租约时应按无租约处理")
+	}
+	l.Acquire()
+	l.Close()
+	if lease, err := NewLease(Config{}, LeaseConfig{}, testLogger()); lease != nil || err != nil {
+		t.Fatalf("未启用时 NewLease 返回 %v, %v", lease, err)
+	}
+	if _, err := NewLease(Config{Lease: true}, LeaseConfig{}, testLogger()); err == nil {
+		t.Fatal("未配置MQTT服务器时未报错")
+	}
+}
+
+// memBroker 内存中的租约主题，保留最后一条消息
+type memBroker struct {
+	mu       sync.Mutex
+	retained []byte
+	clients  []*memClient
+}
+
+type memClient struct {
+	broker *memBroker
+	holder string
+	down   bool
+	fn     func(data []byte, retained bool)
+}
+
+func newMemBroker() *memBroker {
+	return &memBroker{}
+}
+
+func (b *memBroker) client(holder string) *memClient {
+	c := &memClient{broker: b, holder: holder}
+	b.mu.Lock()
+	b.clients = append(b.clients, c)
+	b.mu.Unlock()
+	return c
+}
+
+// partition 断开或恢复实例与MQTT服务器的连接，恢复时重新收到保留消息
+func (b *memBroker) partition(holder string, down bool) {
+	b.mu.Lock()
+	var targets []*memClient
+	for _, c := range b.clients {
+		if c.holder == holder {
+			c.down = down
+			targets = append(targets, c)
+		}
+	}
+	retained := b.retained
+	b.mu.Unlock()
+	if !down && retained != nil {
+		for _, c := range targets {
+			c.fn(retained, true)
+		}
+	}
+}
+
+func (b *memBroker) retainedRecord(t *testing.T) leaseRecord {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var rec leaseRecord
+	if err := json.Unmarshal(b.retained, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func (c *memClient) publish(data []byte) error {
+	b := c.broker
+	b.mu.Lock()
+	if c.down {
+		b.mu.Unlock()
+		return errors.New("mqtt disconnected")
+	}
+	b.retained = data
+	var targets []*memClient
+	for _, o := range b.clients {
+		if !o.down && o.fn != nil {
+			targets = append(targets, o)
+		}
+	}
+	b.mu.Unlock()
+	for _, o := range targets {
+		o.fn(data, false)
+	}
+	return nil
+}
+
+func (c *memClient) subscribe(fn func(data []byte, retained bool)) {
+	b := c.broker
+	b.mu.Lock()
+	c.fn = fn
+	retained := b.retained
+	b.mu.Unlock()
+	if retained != nil {
+		fn(retained, true)
+	}
+}
+
+func (c *memClient) connected() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	return !c.down
+}
+
+func (c *memClient) close() {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.down = true
+}
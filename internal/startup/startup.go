@@ -0,0 +1,276 @@
+// internal/startup/startup.go
+package startup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 默认重试参数
+const (
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Minute
+)
+
+var (
+	dependencyReady = metrics.NewGaugeVec("tp_plugin_dependency_ready",
+		"启动依赖是否已就绪(1为就绪)", "dependency")
+	dependencyAttempts = metrics.NewCounterVec("tp_plugin_dependency_attempts_total",
+		"启动依赖的连接尝试次数", "dependency", "result")
+)
+
+// Config 启动依赖重试配置
+type Config struct {
+	InitialBackoff time.Duration // 首次失败后的重试间隔，0使用默认值1秒
+	MaxBackoff     time.Duration // 重试间隔每次翻倍，不超过该值，0使用默认值1分钟
+}
+
+// Dependency 一项启动依赖，如MQTT连接、平台下发主题订阅
+type Dependency struct {
+	Name  string
+	After []string                        // 这些依赖就绪后才开始启动
+	Start func(ctx context.Context) error // 返回nil为就绪，出错时按退避间隔重试；Stop 后 ctx 取消
+}
+
+// Status 一项启动依赖的状态
+type Status struct {
+	Name      string    `json:"name"`
+	Ready     bool      `json:"ready"`
+	Waiting   []string  `json:"waiting,omitempty"` // 尚未就绪的前置依赖
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	NextRetry time.Time `json:"next_retry,omitempty"`
+	ReadyAt   time.Time `json:"ready_at,omitempty"`
+}
+
+// dependency 一项依赖的运行状态
+type dependency struct {
+	Dependency
+	ready  chan struct{} // 就绪后关闭
+	status Status
+}
+
+// Manager 按依赖顺序在后台启动各项依赖，每项依赖失败时独立按指数退避重试，不阻塞插件启动；
+// 依赖未就绪期间插件照常提供表单配置等不需要该依赖的接口。为nil时不做任何事
+type Manager struct {
+	cfg    Config
+	logger *logrus.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	deps    map[string]*dependency
+	order   []string
+	started bool
+}
+
+// New 创建启动依赖管理器
+func New(cfg Config, logger *logrus.Logger) *Manager {
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = DefaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		cfg:    cfg,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		deps:   make(map[string]*dependency),
+	}
+}
+
+// Add 登记一项依赖，需在 Start 之前调用；名称重复时返回错误
+func (m *Manager) Add(d Dependency) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started {
+		return fmt.Errorf("启动依赖 %s 需在启动前登记", d.Name)
+	}
+	if d.Name == "" || d.Start == nil {
+		return fmt.Errorf("启动依赖缺少名称或启动函数")
+	}
+	if _, ok := m.deps[d.Name]; ok {
+		return fmt.Errorf("启动依赖 %s 重复登记", d.Name)
+	}
+	m.deps[d.Name] = &dependency{
+		Dependency: d,
+		ready:      make(chan struct{}),
+		status:     Status{Name: d.Name},
+	}
+	m.order = append(m.order, d.Name)
+	dependencyReady.WithLabelValues(d.Name).Set(0)
+	return nil
+}
+
+// Start 校验依赖关系后在后台启动全部依赖；前置依赖不存在或存在循环依赖时返回错误，不启动任何依赖
+func (m *Manager) Start() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started {
+		return nil
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	m.started = true
+	for _, name := range m.order {
+		d := m.deps[name]
+		m.wg.Add(1)
+		go m.run(d)
+	}
+	return nil
+}
+
+// validate 检查前置依赖是否存在及是否有循环
+func (m *Manager) validate() error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(m.deps))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("启动依赖存在循环: %v", append(path, name))
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		for _, after := range m.deps[name].After {
+			if _, ok := m.deps[after]; !ok {
+				return fmt.Errorf("启动依赖 %s 的前置依赖 %s 不存在", name, after)
+			}
+			if err := visit(after, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+	for _, name := range m.order {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run 等待前置依赖就绪后启动依赖，失败时按指数退避重试直到成功或 Stop
+func (m *Manager) run(d *dependency) {
+	defer m.wg.Done()
+	log := m.logger.WithField("dependency", d.Name)
+	for _, after := range d.After {
+		select {
+		case <-m.deps[after].ready:
+		case <-m.ctx.Done():
+			return
+		}
+	}
+
+	backoff := m.cfg.InitialBackoff
+	for {
+		err := d.Start(m.ctx)
+		if m.ctx.Err() != nil {
+			return
+		}
+		m.mu.Lock()
+		d.status.Attempts++
+		attempts := d.status.Attempts
+		if err == nil {
+			d.status.LastError, d.status.NextRetry, d.status.ReadyAt = "", time.Time{}, time.Now()
+			m.mu.Unlock()
+			close(d.ready)
+			dependencyReady.WithLabelValues(d.Name).Set(1)
+			dependencyAttempts.WithLabelValues(d.Name, "ok").Inc()
+			log.WithField("attempts", attempts).Info("启动依赖已就绪")
+			return
+		}
+		d.status.LastError, d.status.NextRetry = err.Error(), time.Now().Add(backoff)
+		m.mu.Unlock()
+		dependencyAttempts.WithLabelValues(d.Name, "error").Inc()
+		log.WithError(err).WithFields(logrus.Fields{
+			"attempts": attempts,
+			"retry_in": backoff,
+		}).Warn("启动依赖未就绪，稍后重试")
+
+		select {
+		case <-time.After(backoff):
+		case <-m.ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, m.cfg.MaxBackoff)
+	}
+}
+
+// Ready 依赖是否已就绪，未登记的依赖返回 false
+func (m *Manager) Ready(name string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	d, ok := m.deps[name]
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case <-d.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status 返回各项依赖的状态，按名称排序
+func (m *Manager) Status() []Status {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Status, 0, len(m.deps))
+	for _, d := range m.deps {
+		s := d.status
+		select {
+		case <-d.ready:
+			s.Ready = true
+		default:
+		}
+		for _, after := range d.After {
+			select {
+			case <-m.deps[after].ready:
+			default:
+				s.Waiting = append(s.Waiting, after)
+			}
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Stop 停止重试并等待各依赖的启动函数返回，可重复调用
+func (m *Manager) Stop() {
+	if m == nil {
+		return
+	}
+	m.cancel()
+	m.wg.Wait()
+}
@@ -0,0 +1,213 @@
+// internal/store/migrate.go
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// 元数据bucket及其中的key
+const (
+	bucketMeta       = "meta"
+	bucketMigrations = "migrations"
+	keySchemaVersion = "schema_version"
+)
+
+// 业务bucket
+const (
+	BucketVouchers    = "vouchers"    // 服务接入点凭证
+	BucketDevices     = "devices"     // 设备信息
+	BucketProfiles    = "profiles"    // 设备能力模型发布记录
+	BucketForms       = "forms"       // 管理接口编辑的表单，优先于表单文件
+	BucketShadows     = "shadows"     // 设备影子(期望配置)，下发到设备配置包
+	BucketOwners      = "owners"      // 设备编号的归属租户，用于发现编号冲突
+	BucketTokens      = "tokens"      // 管理接口创建的访问令牌(只保存摘要)
+	BucketNotes       = "notes"       // 设备备注和维护标记
+	BucketSnapshots   = "snapshots"   // 设备最近一次的遥测值，平台数据恢复后重放
+	BucketServices    = "services"    // 服务接入点配置快照，与配置修改通知中的新配置对比
+	BucketOutbox      = "outbox"      // 设备离线期间排队的下行消息，设备重连后下发
+	BucketDevIndex    = "dev_index"   // 设备ID -> 设备编号，持久化设备缓存的ID索引
+	BucketCallbacks   = "callbacks"   // 设备生命周期回调的处理记录，重启后补处理未完成的回调
+	BucketIdempotency = "idempotency" // 变更类请求内容摘要 -> 已完成次数，重启后的重试沿用同一幂等键
+)
+
+// Migration 一次结构迁移
+// 存储是 BoltDB 键值库而不是SQL数据库，结构只有bucket，没有表和列可供SQL脚本修改，
+// 因此迁移以Go函数实现并编译进程序，不使用嵌入的SQL文件；记录格式的变化由读取方兼容旧字段(如 voucherRecord)。
+// 迁移在单个事务中执行，失败时整体回滚，不会停留在应用了一半的版本；
+// 步骤需可重复执行(如 CreateBucketIfNotExists)，bucket已存在但版本未记录时再次执行不报错。
+// 已发布的迁移不得修改，只能追加新版本
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *bolt.Tx) error
+}
+
+// migrationRecord 已应用迁移的记录
+type migrationRecord struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// migrations 按版本号递增排列的迁移列表
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "init",
+		Up:      createBuckets(BucketVouchers, BucketDevices),
+	},
+	{
+		Version: 2,
+		Name:    "profiles",
+		Up:      createBuckets(BucketProfiles),
+	},
+	{
+		Version: 3,
+		Name:    "forms",
+		Up:      createBuckets(BucketForms),
+	},
+	{
+		Version: 4,
+		Name:    "shadows",
+		Up:      createBuckets(BucketShadows),
+	},
+	{
+		Version: 5,
+		Name:    "owners",
+		Up:      createBuckets(BucketOwners),
+	},
+	{
+		Version: 6,
+		Name:    "tokens",
+		Up:      createBuckets(BucketTokens),
+	},
+	{
+		Version: 7,
+		Name:    "notes",
+		Up:      createBuckets(BucketNotes),
+	},
+	{
+		Version: 8,
+		Name:    "snapshots",
+		Up:      createBuckets(BucketSnapshots),
+	},
+	{
+		Version: 9,
+		Name:    "services",
+		Up:      createBuckets(BucketServices),
+	},
+	{
+		Version: 10,
+		Name:    "outbox",
+		Up:      createBuckets(BucketOutbox),
+	},
+	{
+		Version: 11,
+		Name:    "dev_index",
+		Up:      createBuckets(BucketDevIndex),
+	},
+	{
+		Version: 12,
+		Name:    "callbacks",
+		Up:      createBuckets(BucketCallbacks),
+	},
+	{
+		Version: 13,
+		Name:    "idempotency",
+		Up:      createBuckets(BucketIdempotency),
+	},
+}
+
+// createBuckets 创建bucket的迁移步骤
+func createBuckets(names ...string) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return fmt.Errorf("创建bucket %s 失败: %v", name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// SchemaVersion 返回当前结构版本
+func (s *Store) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+func schemaVersion(tx *bolt.Tx) int {
+	b := tx.Bucket([]byte(bucketMeta))
+	if b == nil {
+		return 0
+	}
+	data := b.Get([]byte(keySchemaVersion))
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// migrate 依次执行高于当前版本的迁移，每个迁移单独提交
+func (s *Store) migrate() error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].Version
+	if current > latest {
+		return fmt.Errorf("存储结构版本(%d)高于程序支持的版本(%d)，请升级插件", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return recordMigration(tx, m)
+		})
+		if err != nil {
+			return fmt.Errorf("执行迁移 %d(%s) 失败: %v", m.Version, m.Name, err)
+		}
+		s.logger.WithFields(logrus.Fields{
+			"version": m.Version,
+			"name":    m.Name,
+		}).Info("存储迁移已应用")
+	}
+	return nil
+}
+
+func recordMigration(tx *bolt.Tx, m Migration) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(bucketMeta))
+	if err != nil {
+		return err
+	}
+	history, err := tx.CreateBucketIfNotExists([]byte(bucketMigrations))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(migrationRecord{Version: m.Version, Name: m.Name, AppliedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(m.Version))
+	if err := history.Put(key, data); err != nil {
+		return err
+	}
+	return meta.Put([]byte(keySchemaVersion), key)
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// withMigrations 在测试期间替换迁移列表
+func withMigrations(t *testing.T, list []Migration) {
+	t.Helper()
+	saved := migrations
+	migrations = list
+	t.Cleanup(func() { migrations = saved })
+}
+
+func openStore(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// history 返回已应用迁移的记录
+func history(t *testing.T, s *Store) []migrationRecord {
+	t.Helper()
+	var records []migrationRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketMigrations))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var r migrationRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func hasBucket(t *testing.T, s *Store, name string) bool {
+	t.Helper()
+	var ok bool
+	s.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket([]byte(name)) != nil
+		return nil
+	})
+	return ok
+}
+
+func TestMigrateFromEmpty(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "plugin.db"))
+	defer s.Close()
+	latest := migrations[len(migrations)-1].Version
+	if v, err := s.SchemaVersion(); err != nil || v != latest {
+		t.Fatalf("结构版本 %d, %v，期望 %d", v, err, latest)
+	}
+	records := history(t, s)
+	if len(records) != len(migrations) {
+		t.Fatalf("迁移记录 %d 条，期望 %d 条", len(records), len(migrations))
+	}
+	for i, m := range migrations {
+		if records[i].Version != m.Version || records[i].Name != m.Name || records[i].AppliedAt.IsZero() {
+			t.Fatalf("第%d条迁移记录 %+v", i, records[i])
+		}
+	}
+	for _, name := range []string{BucketVouchers, BucketDevices, BucketOutbox, BucketIdempotency} {
+		if !hasBucket(t, s, name) {
+			t.Fatalf("bucket %s 未创建", name)
+		}
+	}
+}
+
+func TestMigrateRerunIsNoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.db")
+	s := openStore(t, path)
+	if err := s.Put(BucketDevices, "A4:CF:12:00:00:01", "d1"); err != nil {
+		t.Fatal(err)
+	}
+	before := history(t, s)
+	s.Close()
+
+	s = openStore(t, path)
+	defer s.Close()
+	// 再次启动不重复执行已应用的迁移，也不改写应用时间
+	after := history(t, s)
+	if len(after) != len(before) {
+		t.Fatalf("重复启动后迁移记录 %d 条，期望 %d 条", len(after), len(before))
+	}
+	for i := range before {
+		if !after[i].AppliedAt.Equal(before[i].AppliedAt) {
+			t.Fatalf("迁移 %d 被重复执行", after[i].Version)
+		}
+	}
+	var device string
+	if err := s.Get(BucketDevices, "A4:CF:12:00:00:01", &device); err != nil || device != "d1" {
+		t.Fatalf("重复启动后记录 %q, %v", device, err)
+	}
+}
+
+func TestMigratePartiallyApplied(t *testing.T) {
+	// 子测试中会替换 migrations
+	all := migrations
+	errStep := errors.New("step failed")
+	cases := []struct {
+		name string
+		// prepare 在版本10的存储上模拟上次未完成的状态
+		prepare func(t *testing.T, s *Store)
+		// next 本次启动执行的第11个迁移
+		next Migration
+		// failed 本次启动失败，修复迁移后再次启动
+		failed bool
+	}{
+		{
+			name: "bucket created but version not recorded",
+			prepare: func(t *testing.T, s *Store) {
+				err := s.db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucket([]byte(BucketDevIndex))
+					if err != nil {
+						return err
+					}
+					return b.Put([]byte("id-1"), []byte(`"A4:CF:12:00:00:01"`))
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			next: all[10],
+		},
+		{
+			name:    "step fails midway",
+			prepare: func(t *testing.T, s *Store) {},
+			next: Migration{Version: 11, Name: "dev_index", Up: func(tx *bolt.Tx) error {
+				if err := createBuckets(BucketDevIndex)(tx); err != nil {
+					return err
+				}
+				return errStep
+			}},
+			failed: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plugin.db")
+			withMigrations(t, all[:10])
+			s := openStore(t, path)
+			c.prepare(t, s)
+			s.Close()
+
+			withMigrations(t, append(all[:10:10], c.next))
+			s, err := Open(path, testLogger())
+			if c.failed {
+				if err == nil || !strings.Contains(err.Error(), "执行迁移 11(dev_index) 失败") {
+					t.Fatalf("迁移失败时 Open 返回 %v", err)
+				}
+				// 失败的迁移整体回滚，版本停留在上一个版本
+				withMigrations(t, all[:10])
+				s = openStore(t, path)
+				if v, _ := s.SchemaVersion(); v != 10 || hasBucket(t, s, BucketDevIndex) {
+					t.Fatalf("失败的迁移未回滚: 版本 %d", v)
+				}
+				s.Close()
+				// 修复后再次启动从失败的版本继续
+				withMigrations(t, append(all[:10:10], all[10]))
+				s, err = Open(path, testLogger())
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if v, _ := s.SchemaVersion(); v != 11 || !hasBucket(t, s, BucketDevIndex) {
+				t.Fatalf("结构版本 %d", v)
+			}
+			if records := history(t, s); len(records) != 11 || records[10].Name != "dev_index" {
+				t.Fatalf("迁移记录 %+v", records)
+			}
+		})
+	}
+	// 上次创建的bucket中已有的记录保留
+	t.Run("keeps existing records", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "plugin.db")
+		withMigrations(t, all[:10])
+		s := openStore(t, path)
+		cases[0].prepare(t, s)
+		s.Close()
+		withMigrations(t, all[:11])
+		s = openStore(t, path)
+		defer s.Close()
+		var number string
+		if err := s.Get(BucketDevIndex, "id-1", &number); err != nil || number != "A4:CF:12:00:00:01" {
+			t.Fatalf("已有记录 %q, %v", number, err)
+		}
+	})
+}
+
+func TestMigrateRejectsNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.db")
+	openStore(t, path).Close()
+
+	// 降级到较旧的程序
+	withMigrations(t, migrations[:5:5])
+	if _, err := Open(path, testLogger()); err == nil || !strings.Contains(err.Error(), "请升级插件") {
+		t.Fatalf("存储版本较新时 Open 返回 %v", err)
+	}
+}
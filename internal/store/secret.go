@@ -0,0 +1,97 @@
+// internal/store/secret.go
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keySuffix 自动生成的密钥文件与存储文件同目录，文件名为存储文件名加该后缀；
+// 密钥不写入存储文件，备份和备用实例复制的存储中只有密文
+const keySuffix = ".key"
+
+// ErrSealed 密文无法解密(密钥不一致或数据损坏)
+var ErrSealed = errors.New("无法解密存储中的加密数据")
+
+// UseSecret 使用配置的密钥加密敏感字段，替换自动生成的密钥文件；主备实例配置相同的密钥后，
+// 备用实例接管时可解密复制过来的数据。secret 为空时不做修改
+func (s *Store) UseSecret(secret string) error {
+	if secret == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(secret))
+	aead, err := newAEAD(sum[:])
+	if err != nil {
+		return err
+	}
+	s.aead = aead
+	return nil
+}
+
+// Seal 加密敏感数据，返回 base64 编码的随机数和密文
+func (s *Store) Seal(plain []byte) (string, error) {
+	if s.aead == nil {
+		return "", errors.New("存储未配置加密密钥")
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// Unseal 解密 Seal 的结果，密钥不一致或数据被篡改时返回 ErrSealed
+func (s *Store) Unseal(sealed string) ([]byte, error) {
+	if s.aead == nil {
+		return nil, errors.New("存储未配置加密密钥")
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return nil, ErrSealed
+	}
+	n := s.aead.NonceSize()
+	plain, err := s.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	return plain, nil
+}
+
+// loadKey 读取存储文件旁的密钥文件，不存在时生成随机密钥
+func loadKey(path string) (cipher.AEAD, error) {
+	keyPath := path + keySuffix
+	data, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("生成存储密钥失败: %v", err)
+		}
+		data = []byte(hex.EncodeToString(key))
+		if err := os.WriteFile(keyPath, data, 0600); err != nil {
+			return nil, fmt.Errorf("写入存储密钥文件失败: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("读取存储密钥文件失败: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("存储密钥文件 %s 格式错误", keyPath)
+	}
+	return newAEAD(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,189 @@
+// internal/store/store.go
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultPath 未配置时使用的存储文件路径
+const DefaultPath = "data/plugin.db"
+
+// ErrNotFound 记录不存在
+var ErrNotFound = errors.New("record not found")
+
+// Store 插件本地持久化存储(基于 BoltDB)
+type Store struct {
+	db     *bolt.DB
+	logger *logrus.Logger
+	aead   cipher.AEAD // 加密敏感字段，见 Seal
+}
+
+// Open 打开存储文件并执行未应用的迁移
+func Open(path string, logger *logrus.Logger) (*Store, error) {
+	if path == "" {
+		path = DefaultPath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建存储目录失败: %v", err)
+	}
+	aead, err := loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开存储文件失败: %v", err)
+	}
+
+	s := &Store{db: db, logger: logger, aead: aead}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close 关闭存储
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Backup 将存储的一致性快照写入 w，在只读事务中执行，不阻塞写入；返回写入的字节数
+func (s *Store) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Put 以JSON格式写入一条记录
+func (s *Store) Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("序列化记录失败: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket不存在: %s", bucket)
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// PutMany 在一个事务中写入多条记录，避免逐条写入时每条都落盘
+func (s *Store) PutMany(bucket string, records map[string]interface{}) error {
+	if len(records) == 0 {
+		return nil
+	}
+	encoded := make(map[string][]byte, len(records))
+	for key, v := range records {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("序列化记录失败: %v", err)
+		}
+		encoded[key] = data
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket不存在: %s", bucket)
+		}
+		for key, data := range encoded {
+			if err := b.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get 读取一条记录，不存在时返回 ErrNotFound
+func (s *Store) Get(bucket, key string, v interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket不存在: %s", bucket)
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+
+// Delete 删除一条记录，记录不存在时不报错
+func (s *Store) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket不存在: %s", bucket)
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// DeleteMany 在一个事务中删除多条记录，记录不存在时不报错
+func (s *Store) DeleteMany(bucket string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket不存在: %s", bucket)
+		}
+		for _, key := range keys {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForEachPrefix 以游标定位到 prefix 并遍历以其开头的记录，不扫描bucket中的其他记录；fn 返回错误时停止遍历
+func (s *Store) ForEachPrefix(bucket, prefix string, fn func(key string, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket不存在: %s", bucket)
+		}
+		p := []byte(prefix)
+		c := b.Cursor()
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForEach 遍历bucket中的所有记录，fn 返回错误时停止遍历
+func (s *Store) ForEach(bucket string, fn func(key string, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket不存在: %s", bucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
@@ -0,0 +1,318 @@
+// internal/support/bundle.go
+package support
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/pkg/useragent"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLogBytes 未配置时打包的日志总字节数
+const DefaultLogBytes = 5 << 20
+
+// redacted 敏感值的替换文本
+const redacted = "******"
+
+// sensitiveKey 配置项名称命中时整体脱敏
+var sensitiveKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|api[_-]?key|credential|private|authorization)`)
+
+// sensitiveText 日志中需要脱敏的片段：凭证JSON中的密钥、ThingsPanel开放接口密钥、URL中的用户名密码、Authorization头
+var sensitiveText = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)(\\?"(?:secret|password|thingspanelapikey|api_?key|token)\\?"\s*:\s*\\?")[^"\\]*`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)((?:secret|password|api_?key|token)=)[^\s&"]+`), "${1}" + redacted},
+	{regexp.MustCompile(`sk_[0-9A-Za-z]{8,}`), "sk_" + redacted},
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^@\s/]+@`), "${1}" + redacted + "@"},
+	{regexp.MustCompile(`(?i)(authorization:\s*(?:basic|bearer)\s+)\S+`), "${1}" + redacted},
+}
+
+// Section 由调用方提供的一段运行时快照，以JSON格式写入压缩包
+type Section struct {
+	Name    string // 压缩包中的文件名，如 health.json
+	Collect func() (interface{}, error)
+}
+
+// Config 支持包配置
+type Config struct {
+	ConfigPath string // 配置文件路径，脱敏后打包
+	LogPath    string // 当前日志文件路径，同目录下轮转的旧日志一并打包
+	LogBytes   int64  // 打包的日志总字节数(从最新的日志往前截取)，0 使用默认值
+}
+
+// Generator 生成问题排查用的支持包：脱敏后的配置、最近的日志、版本信息、运行状态快照和指标
+type Generator struct {
+	cfg     Config
+	started time.Time
+	logger  *logrus.Logger
+}
+
+// NewGenerator 创建支持包生成器
+func NewGenerator(cfg Config, logger *logrus.Logger) *Generator {
+	if cfg.LogBytes <= 0 {
+		cfg.LogBytes = DefaultLogBytes
+	}
+	return &Generator{cfg: cfg, started: time.Now(), logger: logger}
+}
+
+// FileName 下载时使用的文件名
+func (g *Generator) FileName(now time.Time) string {
+	return fmt.Sprintf("%s-support-%s.zip", useragent.Get().Name, now.Format("20060102-150405"))
+}
+
+// Write 将支持包以zip格式写入w；单个部分失败时写入错误说明，不中断其余部分
+func (g *Generator) Write(w io.Writer, sections ...Section) error {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	var errs []string
+	add := func(name string, data []byte) {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			_, err = f.Write(data)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	addJSON := func(name string, collect func() (interface{}, error)) {
+		v, err := collect()
+		if err != nil {
+			v = map[string]string{"error": err.Error()}
+		}
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			data = []byte(fmt.Sprintf(`{"error": %q}`, err.Error()))
+		}
+		add(name, data)
+	}
+
+	addJSON("version.json", func() (interface{}, error) { return g.version(now), nil })
+	if data, err := g.config(); err != nil {
+		add("config.error.txt", []byte(err.Error()))
+	} else {
+		add("config.yaml", data)
+	}
+	for _, s := range sections {
+		addJSON(s.Name, s.Collect)
+	}
+	var metricsText bytes.Buffer
+	metrics.WriteText(&metricsText)
+	add("metrics.txt", metricsText.Bytes())
+	for _, f := range g.logs() {
+		add("logs/"+f.name, f.data)
+	}
+	if len(errs) > 0 {
+		g.logger.WithField("errors", errs).Warn("支持包部分内容写入失败")
+	}
+	return zw.Close()
+}
+
+// version 版本及运行环境信息
+func (g *Generator) version(now time.Time) map[string]interface{} {
+	id := useragent.Get()
+	info := map[string]interface{}{
+		"name":         id.Name,
+		"version":      id.Version,
+		"instance":     id.Instance,
+		"go_version":   runtime.Version(),
+		"os":           runtime.GOOS,
+		"arch":         runtime.GOARCH,
+		"num_cpu":      runtime.NumCPU(),
+		"goroutines":   runtime.NumGoroutine(),
+		"started_at":   g.started,
+		"generated_at": now,
+		"uptime":       now.Sub(g.started).Round(time.Second).String(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		deps := make(map[string]string, len(bi.Deps))
+		for _, d := range bi.Deps {
+			deps[d.Path] = d.Version
+		}
+		settings := make(map[string]string, len(bi.Settings))
+		for _, s := range bi.Settings {
+			settings[s.Key] = s.Value
+		}
+		info["module"] = bi.Main.Path
+		info["build"] = settings
+		info["dependencies"] = deps
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	info["memory"] = map[string]uint64{
+		"heap_alloc": mem.HeapAlloc,
+		"heap_sys":   mem.HeapSys,
+		"sys":        mem.Sys,
+		"num_gc":     uint64(mem.NumGC),
+	}
+	return info
+}
+
+// config 读取配置文件并将敏感配置项替换为 ******
+func (g *Generator) config() ([]byte, error) {
+	if g.cfg.ConfigPath == "" {
+		return nil, fmt.Errorf("未指定配置文件")
+	}
+	data, err := os.ReadFile(g.cfg.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	redactNode(&doc)
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("序列化配置失败: %v", err)
+	}
+	return out, nil
+}
+
+// redactNode 递归脱敏：键名命中敏感词的非空标量值整体替换，其余字符串值按日志规则脱敏(如URL中的密码)
+func redactNode(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			redactNode(c)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if val.Kind == yaml.ScalarNode && val.Value != "" && sensitiveKey.MatchString(key.Value) {
+				val.Value = redacted
+				val.Tag = "!!str"
+				val.Style = yaml.DoubleQuotedStyle
+				continue
+			}
+			// 自定义请求头常用于传递网关密钥，值全部替换
+			if key.Value == "headers" {
+				redactValues(val)
+				continue
+			}
+			redactNode(val)
+		}
+	case yaml.ScalarNode:
+		n.Value = Redact(n.Value)
+	}
+}
+
+// redactValues 替换映射中的全部非空标量值，保留键名
+func redactValues(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			redactValues(n.Content[i])
+		}
+	case yaml.SequenceNode:
+		for _, c := range n.Content {
+			redactValues(c)
+		}
+	case yaml.ScalarNode:
+		if n.Value != "" {
+			n.Value, n.Tag, n.Style = redacted, "!!str", yaml.DoubleQuotedStyle
+		}
+	}
+}
+
+// Redact 脱敏一段文本中的密钥、密码和凭证
+func Redact(s string) string {
+	for _, r := range sensitiveText {
+		s = r.re.ReplaceAllString(s, r.repl)
+	}
+	return s
+}
+
+type logFile struct {
+	name string
+	data []byte
+}
+
+// logs 从最新的日志文件往前截取，总量不超过 LogBytes；压缩的旧日志跳过
+func (g *Generator) logs() []logFile {
+	if g.cfg.LogPath == "" {
+		return nil
+	}
+	dir := filepath.Dir(g.cfg.LogPath)
+	base := filepath.Base(g.cfg.LogPath)
+	prefix := strings.TrimSuffix(base, filepath.Ext(base))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		g.logger.WithError(err).Warn("读取日志目录失败")
+		return nil
+	}
+	type candidate struct {
+		name    string
+		modTime time.Time
+	}
+	var files []candidate
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasSuffix(name, ".gz") || (name != base && !strings.HasPrefix(name, prefix+"-")) {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			files = append(files, candidate{name: name, modTime: info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	remaining := g.cfg.LogBytes
+	var out []logFile
+	for _, f := range files {
+		if remaining <= 0 {
+			break
+		}
+		data, err := tail(filepath.Join(dir, f.name), remaining)
+		if err != nil {
+			g.logger.WithError(err).WithField("file", f.name).Warn("读取日志文件失败")
+			continue
+		}
+		remaining -= int64(len(data))
+		out = append(out, logFile{name: f.name, data: []byte(Redact(string(data)))})
+	}
+	return out
+}
+
+// tail 读取文件最后 n 字节，从截取处的下一行开始
+func tail(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - n
+	if offset <= 0 {
+		return io.ReadAll(f)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
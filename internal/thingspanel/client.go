@@ -0,0 +1,98 @@
+// internal/thingspanel/client.go
+package thingspanel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"tp-plugin/internal/httpclient"
+	"tp-plugin/internal/pkg/useragent"
+)
+
+// Client ThingsPanel 开放接口客户端，使用租户的 API Key 鉴权
+// 凭证(voucher)中的 ThingsPanelApiURL/ThingsPanelApiKey 即为其配置
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewClient 创建客户端，baseURL 形如 http://thingspanel.local/api/v1
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: httpclient.Client(),
+	}
+}
+
+// response ThingsPanel 通用响应
+type response struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// APIError 接口返回的业务错误
+type APIError struct {
+	Status  int
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ThingsPanel接口错误: status=%d, code=%d, message=%s", e.Status, e.Code, e.Message)
+}
+
+// do 发送请求并解析 data 字段到 out(可为nil)
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("序列化请求失败: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.apiKey)
+	useragent.Apply(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求ThingsPanel失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %v", err)
+	}
+	var r response
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return &APIError{Status: resp.StatusCode, Message: string(raw)}
+	}
+	if resp.StatusCode != http.StatusOK || r.Code != 200 {
+		return &APIError{Status: resp.StatusCode, Code: r.Code, Message: r.Message}
+	}
+	if out != nil && len(r.Data) > 0 {
+		if err := json.Unmarshal(r.Data, out); err != nil {
+			return fmt.Errorf("解析响应数据失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// Ping 以分页查询设备模板的方式检查接口地址和 API Key 是否可用
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/device/template?page=1&page_size=1", nil, nil)
+}
@@ -0,0 +1,26 @@
+// internal/thingspanel/device.go
+package thingspanel
+
+import (
+	"context"
+	"net/http"
+)
+
+// Device ThingsPanel 设备
+type Device struct {
+	ID             string `json:"id,omitempty"`
+	Name           string `json:"name"`
+	DeviceNumber   string `json:"device_number"`
+	DeviceConfigID string `json:"device_config_id,omitempty"` // 设备配置模板ID
+	Label          string `json:"label,omitempty"`
+	Description    string `json:"description,omitempty"`
+}
+
+// CreateDevice 创建设备，返回设备ID
+func (c *Client) CreateDevice(ctx context.Context, device Device) (string, error) {
+	var out Device
+	if err := c.do(ctx, http.MethodPost, "/device", device, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
@@ -0,0 +1,61 @@
+// internal/thingspanel/model.go
+package thingspanel
+
+import (
+	"context"
+	"net/http"
+)
+
+// DeviceTemplate 设备模板(物模型)
+type DeviceTemplate struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Author      string `json:"author,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	Label       string `json:"label,omitempty"`
+}
+
+// ModelItem 遥测/属性模型
+type ModelItem struct {
+	DeviceTemplateID string `json:"device_template_id"`
+	DataName         string `json:"data_name"`
+	DataIdentifier   string `json:"data_identifier"`
+	ReadWriteFlag    string `json:"read_write_flag,omitempty"` // R/RW
+	DataType         string `json:"data_type"`                 // Number/String/Boolean
+	Unit             string `json:"unit,omitempty"`
+	Description      string `json:"description,omitempty"`
+}
+
+// CommandModel 命令模型，Params 为参数定义的JSON字符串
+type CommandModel struct {
+	DeviceTemplateID string `json:"device_template_id"`
+	DataName         string `json:"data_name"`
+	DataIdentifier   string `json:"data_identifier"`
+	Params           string `json:"params,omitempty"`
+	Description      string `json:"description,omitempty"`
+}
+
+// CreateDeviceTemplate 创建设备模板，返回模板ID
+func (c *Client) CreateDeviceTemplate(ctx context.Context, tpl DeviceTemplate) (string, error) {
+	var out DeviceTemplate
+	if err := c.do(ctx, http.MethodPost, "/device/template", tpl, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// CreateTelemetryModel 创建遥测模型
+func (c *Client) CreateTelemetryModel(ctx context.Context, item ModelItem) error {
+	return c.do(ctx, http.MethodPost, "/device/model/telemetry", item, nil)
+}
+
+// CreateAttributeModel 创建属性模型
+func (c *Client) CreateAttributeModel(ctx context.Context, item ModelItem) error {
+	return c.do(ctx, http.MethodPost, "/device/model/attributes", item, nil)
+}
+
+// CreateCommandModel 创建命令模型
+func (c *Client) CreateCommandModel(ctx context.Context, cmd CommandModel) error {
+	return c.do(ctx, http.MethodPost, "/device/model/commands", cmd, nil)
+}
@@ -0,0 +1,292 @@
+// internal/trace/trace.go
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 流量方向
+const (
+	In  = "in"  // 平台或设备发给插件
+	Out = "out" // 插件发给平台或小智服务端
+)
+
+// Config 设备追踪配置
+type Config struct {
+	Dir         string        // 追踪文件目录
+	MaxDuration time.Duration // 单次追踪最长时长
+	Rate        float64       // 每个设备每秒最多记录的条数，超出丢弃并计数
+	Burst       int           // 突发条数
+}
+
+// Session 一个设备的追踪会话
+type Session struct {
+	DeviceNumber string    `json:"device_number"`
+	File         string    `json:"file"`
+	StartedAt    time.Time `json:"started_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Records      int64     `json:"records"`
+	Dropped      int64     `json:"dropped"`
+}
+
+// record 追踪文件中的一条记录
+type record struct {
+	Time      time.Time   `json:"ts"`
+	Direction string      `json:"direction"`
+	Kind      string      `json:"kind"`
+	Payload   interface{} `json:"payload"`
+}
+
+type session struct {
+	Session
+	f      *os.File
+	enc    *json.Encoder
+	tokens float64
+	last   time.Time
+}
+
+// Tracer 按设备编号开启的限时全量追踪，记录写入独立文件，到期自动关闭
+// 为nil时所有方法均为空操作，未开启追踪的设备只有一次map查找的开销
+type Tracer struct {
+	cfg      Config
+	logger   *logrus.Logger
+	mu       sync.Mutex
+	sessions map[string]*session
+	stopCh   chan struct{}
+}
+
+// New 创建追踪器并启动到期清理
+func New(cfg Config, logger *logrus.Logger) (*Tracer, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "logs/trace"
+	}
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = time.Hour
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = 20
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = int(cfg.Rate) * 2
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建追踪目录失败: %v", err)
+	}
+	t := &Tracer{cfg: cfg, logger: logger, sessions: make(map[string]*session), stopCh: make(chan struct{})}
+	go t.expireLoop()
+	return t, nil
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Enable 为设备开启追踪，已开启时延长到期时间；d 超过上限时按上限处理
+func (t *Tracer) Enable(deviceNumber string, d time.Duration) (Session, error) {
+	if t == nil {
+		return Session{}, fmt.Errorf("设备追踪未启用")
+	}
+	if deviceNumber == "" {
+		return Session{}, fmt.Errorf("设备编号不能为空")
+	}
+	if d <= 0 || d > t.cfg.MaxDuration {
+		d = t.cfg.MaxDuration
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.sessions[deviceNumber]; ok {
+		s.ExpiresAt = now.Add(d)
+		return s.Session, nil
+	}
+
+	name := fmt.Sprintf("%s-%s.jsonl", unsafeChars.ReplaceAllString(deviceNumber, "_"), now.Format("20060102-150405"))
+	path := filepath.Join(t.cfg.Dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return Session{}, fmt.Errorf("创建追踪文件失败: %v", err)
+	}
+	s := &session{
+		Session: Session{DeviceNumber: deviceNumber, File: path, StartedAt: now, ExpiresAt: now.Add(d)},
+		f:       f,
+		enc:     json.NewEncoder(f),
+		tokens:  float64(t.cfg.Burst),
+		last:    now,
+	}
+	t.sessions[deviceNumber] = s
+	t.logger.WithFields(logrus.Fields{
+		"device_number": deviceNumber,
+		"file":          path,
+		"expires_at":    s.ExpiresAt,
+	}).Info("设备追踪已开启")
+	return s.Session, nil
+}
+
+// Disable 关闭设备追踪
+func (t *Tracer) Disable(deviceNumber string) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[deviceNumber]
+	if ok {
+		t.closeLocked(s, "manual")
+	}
+	return ok
+}
+
+// Purge 关闭设备追踪并删除设备的全部追踪文件，返回删除的文件数
+func (t *Tracer) Purge(deviceNumber string) (int, error) {
+	if t == nil || deviceNumber == "" {
+		return 0, nil
+	}
+	t.Disable(deviceNumber)
+	// 文件名为 <设备编号>-<开启时间>.jsonl，时间部分定长，避免匹配到以该编号为前缀的其他设备
+	pattern := unsafeChars.ReplaceAllString(deviceNumber, "_") + "-????????-??????.jsonl"
+	files, err := filepath.Glob(filepath.Join(t.cfg.Dir, pattern))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return n, fmt.Errorf("删除追踪文件失败: %v", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Sessions 返回进行中的追踪会话
+func (t *Tracer) Sessions() []Session {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]Session, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		list = append(list, s.Session)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeviceNumber < list[j].DeviceNumber })
+	return list
+}
+
+// Enabled 设备是否处于追踪中，调用方可据此跳过构造负载的开销
+func (t *Tracer) Enabled(deviceNumber string) bool {
+	if t == nil || deviceNumber == "" {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[deviceNumber]
+	return ok && time.Now().Before(s.ExpiresAt)
+}
+
+// Record 记录一条与设备相关的流量，设备未开启追踪时忽略
+// payload 为字符串或[]byte且是合法JSON时原样嵌入，否则按值序列化
+func (t *Tracer) Record(deviceNumber, direction, kind string, payload interface{}) {
+	if t == nil || deviceNumber == "" {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[deviceNumber]
+	if !ok {
+		return
+	}
+	if !now.Before(s.ExpiresAt) {
+		t.closeLocked(s, "expired")
+		return
+	}
+
+	// 令牌桶限速，防止高频设备写满磁盘
+	s.tokens += now.Sub(s.last).Seconds() * t.cfg.Rate
+	if s.tokens > float64(t.cfg.Burst) {
+		s.tokens = float64(t.cfg.Burst)
+	}
+	s.last = now
+	if s.tokens < 1 {
+		s.Dropped++
+		return
+	}
+	s.tokens--
+
+	if err := s.enc.Encode(record{Time: now, Direction: direction, Kind: kind, Payload: rawPayload(payload)}); err != nil {
+		t.logger.WithError(err).WithField("device_number", deviceNumber).Warn("写入追踪文件失败")
+		return
+	}
+	s.Records++
+}
+
+func rawPayload(payload interface{}) interface{} {
+	var data []byte
+	switch v := payload.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return payload
+	}
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	return string(data)
+}
+
+func (t *Tracer) closeLocked(s *session, reason string) {
+	delete(t.sessions, s.DeviceNumber)
+	s.f.Close()
+	t.logger.WithFields(logrus.Fields{
+		"device_number": s.DeviceNumber,
+		"file":          s.File,
+		"records":       s.Records,
+		"dropped":       s.Dropped,
+		"reason":        reason,
+	}).Info("设备追踪已关闭")
+}
+
+// expireLoop 定期关闭到期的追踪会话，没有流量的设备也能按时释放文件句柄
+func (t *Tracer) expireLoop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stopCh:
+			return
+		case now := <-ticker.C:
+			t.mu.Lock()
+			for _, s := range t.sessions {
+				if !now.Before(s.ExpiresAt) {
+					t.closeLocked(s, "expired")
+				}
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// Close 关闭全部追踪会话
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.sessions {
+		t.closeLocked(s, "shutdown")
+	}
+}
@@ -0,0 +1,111 @@
+// internal/transform/binary.go
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"tp-plugin/internal/metrics"
+)
+
+// 二进制参数对象的字段，形如 {"base64": "...", "sha256": "...", "content_type": "audio/opus"}
+const (
+	BinaryDataKey     = "base64"
+	BinaryChecksumKey = "sha256"
+)
+
+// DefaultBinaryMaxBytes 未配置时单个二进制参数解码后的最大字节数
+const DefaultBinaryMaxBytes = 1 << 20
+
+var binaryPayloads = metrics.NewCounterVec("tp_plugin_binary_payloads_total",
+	"下行二进制参数的校验结果", "result")
+
+// BinaryLimits 二进制参数的限制
+type BinaryLimits struct {
+	MaxBytes        int  // 解码后的最大字节数，0使用默认值1MB
+	RequireChecksum bool // 要求携带 sha256 校验值
+}
+
+// isBinary 参数值是否为二进制参数对象
+func isBinary(v interface{}) (map[string]interface{}, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	_, ok = m[BinaryDataKey].(string)
+	return m, ok
+}
+
+// checkBinary 校验base64编码、大小和校验值
+func checkBinary(key string, m map[string]interface{}, limits BinaryLimits) error {
+	data := m[BinaryDataKey].(string)
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		if raw, err = base64.URLEncoding.DecodeString(data); err != nil {
+			binaryPayloads.WithLabelValues("invalid").Inc()
+			return fmt.Errorf("二进制参数 %s 不是有效的base64: %v", key, err)
+		}
+	}
+	max := limits.MaxBytes
+	if max <= 0 {
+		max = DefaultBinaryMaxBytes
+	}
+	if len(raw) > max {
+		binaryPayloads.WithLabelValues("too_large").Inc()
+		return fmt.Errorf("二进制参数 %s 大小 %d 字节超出限制 %d 字节", key, len(raw), max)
+	}
+	sum, _ := m[BinaryChecksumKey].(string)
+	if sum == "" {
+		if limits.RequireChecksum {
+			binaryPayloads.WithLabelValues("checksum").Inc()
+			return fmt.Errorf("二进制参数 %s 缺少 %s 校验值", key, BinaryChecksumKey)
+		}
+		binaryPayloads.WithLabelValues("ok").Inc()
+		return nil
+	}
+	actual := sha256.Sum256(raw)
+	if !strings.EqualFold(sum, hex.EncodeToString(actual[:])) {
+		binaryPayloads.WithLabelValues("checksum").Inc()
+		return fmt.Errorf("二进制参数 %s 的 %s 校验值不匹配", key, BinaryChecksumKey)
+	}
+	binaryPayloads.WithLabelValues("ok").Inc()
+	return nil
+}
+
+// ApplyPassthrough 校验并取出顶层的二进制参数，其余参数经 Apply 转换后再原样放回二进制参数，
+// 转换插件看不到也无法修改二进制参数；nil Registry 同样校验
+func (r *Registry) ApplyPassthrough(voucherKey string, direction Direction, deviceNumber string, values map[string]interface{}, limits BinaryLimits) (map[string]interface{}, error) {
+	var blobs map[string]interface{}
+	rest := values
+	for k, v := range values {
+		m, ok := isBinary(v)
+		if !ok {
+			continue
+		}
+		if err := checkBinary(k, m, limits); err != nil {
+			return nil, err
+		}
+		if blobs == nil {
+			blobs = make(map[string]interface{})
+			rest = make(map[string]interface{}, len(values))
+			for k2, v2 := range values {
+				rest[k2] = v2
+			}
+		}
+		blobs[k] = v
+		delete(rest, k)
+	}
+	out, err := r.Apply(voucherKey, direction, deviceNumber, rest)
+	if err != nil || len(blobs) == 0 {
+		return out, err
+	}
+	if out == nil {
+		out = make(map[string]interface{}, len(blobs))
+	}
+	for k, v := range blobs {
+		out[k] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,35 @@
+//go:build cgo && (linux || darwin || freebsd)
+
+// internal/transform/plugin.go
+package transform
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// Load 加载Go插件(go build -buildmode=plugin)导出的 Transform 函数，签名为
+// func(direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error)
+// 插件须使用与主程序相同的Go版本和依赖版本编译
+func Load(path string) (Func, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("加载转换插件失败: %v", err)
+	}
+	sym, err := p.Lookup("Transform")
+	if err != nil {
+		return nil, fmt.Errorf("转换插件 %s 未导出 Transform: %v", path, err)
+	}
+	var fn func(string, string, map[string]interface{}) (map[string]interface{}, error)
+	switch f := sym.(type) {
+	case func(string, string, map[string]interface{}) (map[string]interface{}, error):
+		fn = f
+	case *func(string, string, map[string]interface{}) (map[string]interface{}, error):
+		fn = *f
+	default:
+		return nil, fmt.Errorf("转换插件 %s 的 Transform 签名不匹配: %T", path, sym)
+	}
+	return func(direction Direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+		return fn(string(direction), deviceNumber, values)
+	}, nil
+}
@@ -0,0 +1,11 @@
+//go:build !cgo || !(linux || darwin || freebsd)
+
+// internal/transform/plugin_stub.go
+package transform
+
+import "fmt"
+
+// Load 当前平台或未启用cgo的构建不支持Go插件
+func Load(path string) (Func, error) {
+	return nil, fmt.Errorf("当前构建不支持加载转换插件(需启用cgo，且仅支持linux/darwin/freebsd): %s", path)
+}
@@ -0,0 +1,140 @@
+// internal/transform/transform.go
+package transform
+
+import (
+	"fmt"
+	"tp-plugin/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Direction 消息方向
+type Direction string
+
+const (
+	Uplink   Direction = "uplink"   // 设备上报到平台的遥测
+	Downlink Direction = "downlink" // 下发到设备的配置
+)
+
+// AnyVoucher 匹配全部凭证的规则
+const AnyVoucher = "*"
+
+// Func 消息转换函数，返回的数据替换原数据；返回错误时该消息不再发送。
+// values 是 Apply 复制出的数据(含嵌套的对象和数组)，插件可直接修改后返回，不影响调用方持有的原数据
+type Func func(direction Direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error)
+
+// Rule 为某个凭证加载的转换插件
+type Rule struct {
+	Voucher string // 凭证摘要(见 formjson.VoucherKey)，"*" 匹配全部
+	Path    string // 插件文件路径
+}
+
+var transforms = metrics.NewCounterVec("tp_plugin_transform_total",
+	"消息转换插件执行次数", "direction", "result")
+
+// namedFunc 已加载的转换函数
+type namedFunc struct {
+	path string
+	fn   Func
+}
+
+// Registry 按凭证组织的转换函数，先执行凭证专属的转换，再执行 "*" 规则的转换
+type Registry struct {
+	byVoucher map[string][]namedFunc
+	logger    *logrus.Logger
+}
+
+// New 加载规则中的插件，规则为空时返回nil
+func New(rules []Rule, logger *logrus.Logger) (*Registry, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Registry{byVoucher: make(map[string][]namedFunc), logger: logger}
+	for _, rule := range rules {
+		if rule.Voucher == "" || rule.Path == "" {
+			return nil, fmt.Errorf("转换规则缺少 voucher 或 path")
+		}
+		fn, err := Load(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		r.Register(rule.Voucher, rule.Path, fn)
+		logger.WithFields(logrus.Fields{
+			"voucher": rule.Voucher,
+			"path":    rule.Path,
+		}).Info("消息转换插件已加载")
+	}
+	return r, nil
+}
+
+// Register 注册转换函数，name 用于日志和错误信息
+func (r *Registry) Register(voucher, name string, fn Func) {
+	r.byVoucher[voucher] = append(r.byVoucher[voucher], namedFunc{path: name, fn: fn})
+}
+
+// Apply 依次执行匹配凭证的转换函数，nil Registry 或没有匹配的转换函数时原样返回；
+// 转换函数收到 values 的副本，调用方的数据(如快照、重试时再次发送的内容)不会被修改
+func (r *Registry) Apply(voucherKey string, direction Direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+	if r == nil {
+		return values, nil
+	}
+	chain := r.byVoucher[voucherKey]
+	if voucherKey != AnyVoucher {
+		chain = append(chain[:len(chain):len(chain)], r.byVoucher[AnyVoucher]...)
+	}
+	if len(chain) == 0 {
+		return values, nil
+	}
+	values = cloneValues(values)
+	for _, f := range chain {
+		out, err := call(f.fn, direction, deviceNumber, values)
+		if err != nil {
+			transforms.WithLabelValues(string(direction), "error").Inc()
+			return nil, fmt.Errorf("转换插件 %s 执行失败: %v", f.path, err)
+		}
+		transforms.WithLabelValues(string(direction), "ok").Inc()
+		values = out
+	}
+	return values, nil
+}
+
+// call 执行转换函数，插件内的panic转为错误，避免影响插件主进程
+func call(fn Func, direction Direction, deviceNumber string, values map[string]interface{}) (out map[string]interface{}, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	out, err = fn(direction, deviceNumber, values)
+	if err == nil && out == nil {
+		out = map[string]interface{}{}
+	}
+	return out, err
+}
+
+// cloneValues 深拷贝转换数据中的对象和数组，其余值(字符串、数字等)不可变，直接复用
+func cloneValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return cloneValues(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = cloneValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,173 @@
+package transform
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestRegistry() *Registry {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return &Registry{byVoucher: make(map[string][]namedFunc), logger: logger}
+}
+
+// appendStep 在 values["steps"] 末尾追加 name，记录转换函数的执行顺序
+func appendStep(name string) Func {
+	return func(direction Direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+		steps, _ := values["steps"].(string)
+		values["steps"] = steps + name
+		return values, nil
+	}
+}
+
+func TestApplyChainOrder(t *testing.T) {
+	r := newTestRegistry()
+	r.Register(AnyVoucher, "any1", appendStep("*1"))
+	r.Register("acme", "acme1", appendStep("a1"))
+	r.Register("acme", "acme2", appendStep("a2"))
+	r.Register(AnyVoucher, "any2", appendStep("*2"))
+	r.Register("other", "other", appendStep("o"))
+
+	cases := []struct {
+		voucher string
+		want    string
+	}{
+		{"acme", "a1a2*1*2"}, // 凭证专属的转换先于 "*" 规则，各自按注册顺序
+		{"unknown", "*1*2"},  // 没有专属规则的凭证只执行 "*" 规则
+		{AnyVoucher, "*1*2"}, // 未缓存的设备按 "*" 查找，不重复执行
+		{"other", "o*1*2"},
+	}
+	for _, c := range cases {
+		out, err := r.Apply(c.voucher, Uplink, "d1", map[string]interface{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out["steps"] != c.want {
+			t.Errorf("%s: 执行顺序 %v，期望 %s", c.voucher, out["steps"], c.want)
+		}
+	}
+	// 多次调用不会因 append 共享底层数组而改变已注册的链
+	if n := len(r.byVoucher["acme"]); n != 2 {
+		t.Fatalf("acme 的转换函数 %d 个", n)
+	}
+}
+
+func TestApplyWithoutRules(t *testing.T) {
+	values := map[string]interface{}{"temp_c": 21.5}
+	var nilRegistry *Registry
+	if out, err := nilRegistry.Apply("acme", Uplink, "d1", values); err != nil || !reflect.DeepEqual(out, values) {
+		t.Fatalf("nil Registry 返回 %v, %v", out, err)
+	}
+	r := newTestRegistry()
+	r.Register("other", "other", appendStep("o"))
+	if out, err := r.Apply("acme", Uplink, "d1", values); err != nil || !reflect.DeepEqual(out, values) {
+		t.Fatalf("没有匹配的规则时返回 %v, %v", out, err)
+	}
+}
+
+func TestApplyDoesNotMutateInput(t *testing.T) {
+	r := newTestRegistry()
+	r.Register("acme", "rename", func(direction Direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+		values["temperature"] = values["temp_c"]
+		delete(values, "temp_c")
+		values["nested"].(map[string]interface{})["unit"] = "F"
+		values["list"].([]interface{})[0] = "changed"
+		return values, nil
+	})
+	values := map[string]interface{}{
+		"temp_c": 21.5,
+		"nested": map[string]interface{}{"unit": "C"},
+		"list":   []interface{}{"original"},
+	}
+	want := map[string]interface{}{
+		"temp_c": 21.5,
+		"nested": map[string]interface{}{"unit": "C"},
+		"list":   []interface{}{"original"},
+	}
+	out, err := r.Apply("acme", Uplink, "d1", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["temperature"] != 21.5 || out["nested"].(map[string]interface{})["unit"] != "F" {
+		t.Fatalf("转换结果 %v", out)
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("调用方的数据被修改: %v", values)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	errRejected := errors.New("rejected")
+	cases := []struct {
+		name   string
+		fn     Func
+		errSub string
+		out    map[string]interface{}
+	}{
+		{
+			name: "panic",
+			fn: func(Direction, string, map[string]interface{}) (map[string]interface{}, error) {
+				var m map[string]int
+				m["x"] = 1 // 向nil map写入
+				return nil, nil
+			},
+			errSub: "转换插件 broken 执行失败: panic: assignment to entry in nil map",
+		},
+		{
+			name: "error",
+			fn: func(Direction, string, map[string]interface{}) (map[string]interface{}, error) {
+				return nil, errRejected
+			},
+			errSub: "转换插件 broken 执行失败: rejected",
+		},
+		{
+			name: "nil result",
+			fn: func(Direction, string, map[string]interface{}) (map[string]interface{}, error) {
+				return nil, nil
+			},
+			out: map[string]interface{}{"steps": "*"}, // 返回nil视为清空，后续转换从空数据继续
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newTestRegistry()
+			r.Register("acme", "broken", c.fn)
+			r.Register(AnyVoucher, "after", appendStep("*"))
+			out, err := r.Apply("acme", Downlink, "d1", map[string]interface{}{"temperature": 20})
+			if c.errSub != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errSub) || out != nil {
+					t.Fatalf("返回 %v, %v，期望错误包含 %q", out, err, c.errSub)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(out, c.out) {
+				t.Fatalf("返回 %v, %v，期望 %v", out, err, c.out)
+			}
+		})
+	}
+}
+
+func TestApplyPassthroughHidesBinary(t *testing.T) {
+	r := newTestRegistry()
+	r.Register("acme", "inspect", func(direction Direction, deviceNumber string, values map[string]interface{}) (map[string]interface{}, error) {
+		if _, ok := values["clip"]; ok {
+			return nil, errors.New("转换插件看到了二进制参数")
+		}
+		values["volume"] = 80
+		return values, nil
+	})
+	clip := map[string]interface{}{BinaryDataKey: "aGVsbG8="}
+	values := map[string]interface{}{"clip": clip, "volume": 50}
+	out, err := r.ApplyPassthrough("acme", Downlink, "d1", values, BinaryLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out["volume"] != 80 || !reflect.DeepEqual(out["clip"], clip) || values["volume"] != 50 {
+		t.Fatalf("转换结果 %v，原数据 %v", out, values)
+	}
+}
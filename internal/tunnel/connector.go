@@ -0,0 +1,153 @@
+// internal/tunnel/connector.go
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"tp-plugin/internal/httpclient"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// 重连退避
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Connector 部署在小智服务端一侧，主动连接插件的隧道入口，并将收到的请求转发到本地小智服务
+type Connector struct {
+	URL     string       // 插件隧道入口，如 wss://plugin.example.com/tunnel
+	Name    string       // 隧道名称，与插件 tunnel.agents 中一致
+	Token   string       // 隧道令牌
+	Target  string       // 本地小智服务的主机地址，如 http://127.0.0.1:8002
+	Client  *http.Client // 调用本地小智服务的客户端，为nil时使用共享客户端
+	Timeout time.Duration
+	Logger  *logrus.Logger
+}
+
+// Run 保持隧道连接，断开后按指数退避重连，直到 ctx 取消
+func (c *Connector) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := c.serve(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		c.Logger.WithError(err).WithField("retry_in", backoff.String()).Warn("隧道断开，稍后重连")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// serve 建立一次连接并处理请求，连接断开时返回
+func (c *Connector) serve(ctx context.Context) error {
+	header := http.Header{}
+	header.Set(NameHeader, c.Name)
+	header.Set("Authorization", "Bearer "+c.Token)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, c.URL, header)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	ws.SetReadLimit(maxFrameSize)
+	c.Logger.WithField("url", c.URL).WithField("name", c.Name).Info("隧道已连接")
+
+	// ctx 取消时关闭连接以结束读取
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	var writeMu sync.Mutex
+	ws.SetPingHandler(func(data string) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+	})
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var req Frame
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.Logger.WithError(err).Warn("解析隧道请求失败")
+			continue
+		}
+		go func() {
+			resp := c.forward(ctx, req)
+			out, err := json.Marshal(resp)
+			if err != nil {
+				return
+			}
+			writeMu.Lock()
+			ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = ws.WriteMessage(websocket.TextMessage, out)
+			writeMu.Unlock()
+			if err != nil {
+				c.Logger.WithError(err).Warn("写入隧道响应失败")
+			}
+		}()
+	}
+}
+
+// forward 将请求转发到本地小智服务，请求路径(含插件侧凭证 ServerURL 中的路径前缀)原样拼接到 Target 后
+func (c *Connector) forward(ctx context.Context, req Frame) Frame {
+	resp := Frame{ID: req.ID}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, strings.TrimRight(c.Target, "/")+req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	for k, v := range req.Header {
+		httpReq.Header[k] = v
+	}
+	client := c.Client
+	if client == nil {
+		client = httpclient.Client()
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxFrameSize))
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Status = httpResp.StatusCode
+	resp.Header = httpResp.Header
+	resp.Body = body
+	return resp
+}
@@ -0,0 +1,417 @@
+// internal/tunnel/tunnel.go
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Scheme 经隧道访问的小智服务端地址协议，凭证中的 ServerURL 写为 tunnel://<名称>/<路径前缀>
+const Scheme = "tunnel"
+
+// 隧道连接参数
+const (
+	NameHeader   = "X-Tunnel-Name" // 连接时携带的隧道名称
+	pingInterval = 30 * time.Second
+	readTimeout  = 90 * time.Second // 超过该时长未收到任何消息(含pong)视为断开
+	writeTimeout = 10 * time.Second
+	maxFrameSize = 32 << 20
+)
+
+var (
+	tunnelAgents = metrics.NewGaugeVec("tp_plugin_tunnel_agents",
+		"已连接的隧道数")
+	tunnelRequests = metrics.NewCounterVec("tp_plugin_tunnel_requests_total",
+		"经隧道转发的请求数", "agent", "result")
+)
+
+// AnyTenant 允许全部租户使用隧道，只适用于单租户部署
+const AnyTenant = "*"
+
+// ErrForbidden 租户无权使用凭证中指定的隧道
+var ErrForbidden = errors.New("租户无权使用该隧道")
+
+// Agent 允许连接的隧道，小智服务端以名称和令牌主动连接插件
+type Agent struct {
+	Name  string
+	Token string
+	// Tenants 允许经该隧道调用的租户(凭证摘要，见 formjson.VoucherKey)，"*" 为全部；
+	// 凭证 ServerURL 可由租户任意填写，未列出的租户指向该隧道的调用一律拒绝
+	Tenants []string
+}
+
+// tenantKey 请求所属租户在 ctx 中的键
+type tenantKey struct{}
+
+// WithTenant 标记请求所属的租户(凭证摘要)，经隧道转发前据此校验
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// Config 反向隧道配置
+type Config struct {
+	Enabled bool
+	Agents  []Agent
+}
+
+// Frame 隧道中的一条消息：插件发出的请求或小智侧返回的响应，以ID对应
+type Frame struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method,omitempty"`
+	Path   string      `json:"path,omitempty"` // 请求路径及查询参数
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Error  string      `json:"error,omitempty"` // 小智侧转发失败的原因
+}
+
+// AgentStatus 隧道连接状态
+type AgentStatus struct {
+	Name        string    `json:"name"`
+	Connected   bool      `json:"connected"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	ConnectedAt time.Time `json:"connected_at,omitempty"`
+	InFlight    int       `json:"in_flight"`
+	Requests    uint64    `json:"requests"`
+}
+
+// conn 一条已建立的隧道
+type conn struct {
+	ws          *websocket.Conn
+	remoteAddr  string
+	connectedAt time.Time
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+	pending     map[uint64]chan Frame
+	nextID      uint64
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.ws.Close()
+	})
+}
+
+func (c *conn) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// Hub 接受部署在内网的小智服务端主动建立的WebSocket隧道，并将发往 tunnel:// 地址的请求经隧道转发，
+// 云端插件无需小智服务端有公网地址即可管理NAT后的设备
+type Hub struct {
+	tokens   map[string]string
+	tenants  map[string]map[string]bool // 隧道名称 -> 允许的租户
+	logger   *logrus.Logger
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	conns    map[string]*conn
+	requests map[string]uint64
+}
+
+// New 创建隧道中心，未启用时返回nil
+func New(cfg Config, logger *logrus.Logger) (*Hub, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	h := &Hub{
+		tokens:   make(map[string]string, len(cfg.Agents)),
+		tenants:  make(map[string]map[string]bool, len(cfg.Agents)),
+		logger:   logger,
+		upgrader: websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096},
+		conns:    make(map[string]*conn),
+		requests: make(map[string]uint64),
+	}
+	for i, a := range cfg.Agents {
+		if a.Name == "" || a.Token == "" {
+			return nil, fmt.Errorf("第%d个隧道缺少 name 或 token", i+1)
+		}
+		if _, ok := h.tokens[a.Name]; ok {
+			return nil, fmt.Errorf("隧道名称重复: %s", a.Name)
+		}
+		if len(a.Tenants) == 0 {
+			return nil, fmt.Errorf("隧道 %s 未配置允许使用的租户(tenants)", a.Name)
+		}
+		h.tokens[a.Name] = a.Token
+		h.tenants[a.Name] = make(map[string]bool, len(a.Tenants))
+		for _, t := range a.Tenants {
+			h.tenants[a.Name][t] = true
+		}
+	}
+	return h, nil
+}
+
+// Handler 隧道连接入口，连接时以 X-Tunnel-Name 请求头携带名称、Authorization: Bearer 携带令牌；
+// 同名隧道重复连接时替换旧连接
+func (h *Hub) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(NameHeader)
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		want, ok := h.tokens[name]
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			h.logger.WithField("name", name).WithField("remote_addr", r.RemoteAddr).Warn("隧道鉴权失败")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ws, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			h.logger.WithError(err).WithField("name", name).Warn("建立隧道失败")
+			return
+		}
+		ws.SetReadLimit(maxFrameSize)
+		c := &conn{
+			ws:          ws,
+			remoteAddr:  r.RemoteAddr,
+			connectedAt: time.Now(),
+			pending:     make(map[uint64]chan Frame),
+			closed:      make(chan struct{}),
+		}
+		h.mu.Lock()
+		old := h.conns[name]
+		h.conns[name] = c
+		tunnelAgents.WithLabelValues().Set(float64(len(h.conns)))
+		h.mu.Unlock()
+		if old != nil {
+			old.close()
+		}
+		h.logger.WithField("name", name).WithField("remote_addr", r.RemoteAddr).Info("隧道已连接")
+		go h.ping(c)
+		h.serve(name, c)
+	})
+}
+
+// serve 读取响应并交给等待中的请求，连接断开时返回
+func (h *Hub) serve(name string, c *conn) {
+	defer func() {
+		c.close()
+		h.mu.Lock()
+		if h.conns[name] == c {
+			delete(h.conns, name)
+		}
+		tunnelAgents.WithLabelValues().Set(float64(len(h.conns)))
+		h.mu.Unlock()
+		h.logger.WithField("name", name).Info("隧道已断开")
+	}()
+	c.ws.SetReadDeadline(time.Now().Add(readTimeout))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(readTimeout))
+		var f Frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			h.logger.WithError(err).WithField("name", name).Warn("解析隧道消息失败")
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[f.ID]
+		delete(c.pending, f.ID)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- f
+		}
+	}
+}
+
+func (h *Hub) ping(c *conn) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// Transport 包装传输层，tunnel:// 地址的请求经隧道转发，其余交给 next；h 为nil时直接返回 next
+func (h *Hub) Transport(next http.RoundTripper) http.RoundTripper {
+	if h == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{hub: h, next: next}
+}
+
+type transport struct {
+	hub  *Hub
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != Scheme {
+		return t.next.RoundTrip(req)
+	}
+	resp, err := t.hub.roundTrip(req)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	tunnelRequests.WithLabelValues(req.URL.Host, result).Inc()
+	return resp, err
+}
+
+// Authorize 校验租户能否访问 serverURL，非 tunnel:// 地址不校验；h 为nil时不校验(tunnel:// 地址无法转发)
+func (h *Hub) Authorize(serverURL, tenant string) error {
+	if h == nil {
+		return nil
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Scheme != Scheme {
+		return nil
+	}
+	return h.authorize(u.Host, tenant)
+}
+
+func (h *Hub) authorize(name, tenant string) error {
+	allowed := h.tenants[name]
+	if allowed[AnyTenant] || (tenant != "" && allowed[tenant]) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrForbidden, name)
+}
+
+func (h *Hub) roundTrip(req *http.Request) (*http.Response, error) {
+	name := req.URL.Host
+	if err := h.authorize(name, tenantFromContext(req.Context())); err != nil {
+		h.logger.WithField("name", name).Warn("拒绝未授权租户经隧道调用")
+		return nil, err
+	}
+	h.mu.Lock()
+	c := h.conns[name]
+	if c != nil {
+		h.requests[name]++
+	}
+	h.mu.Unlock()
+	if c == nil {
+		return nil, fmt.Errorf("隧道 %s 未连接", name)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+	ch := make(chan Frame, 1)
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(Frame{ID: id, Method: req.Method, Path: req.URL.RequestURI(), Header: req.Header, Body: body})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write(websocket.TextMessage, data); err != nil {
+		c.close()
+		return nil, fmt.Errorf("写入隧道 %s 失败: %v", name, err)
+	}
+
+	select {
+	case f := <-ch:
+		if f.Error != "" {
+			return nil, fmt.Errorf("隧道 %s 转发失败: %s", name, f.Error)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+			StatusCode:    f.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        f.Header,
+			Body:          io.NopCloser(bytes.NewReader(f.Body)),
+			ContentLength: int64(len(f.Body)),
+			Request:       req,
+		}, nil
+	case <-c.closed:
+		return nil, fmt.Errorf("隧道 %s 已断开", name)
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// Agents 返回配置的全部隧道及其连接状态
+func (h *Hub) Agents() []AgentStatus {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := make([]AgentStatus, 0, len(h.tokens))
+	for name := range h.tokens {
+		s := AgentStatus{Name: name, Requests: h.requests[name]}
+		if c := h.conns[name]; c != nil {
+			s.Connected = true
+			s.RemoteAddr = c.remoteAddr
+			s.ConnectedAt = c.connectedAt
+			c.mu.Lock()
+			s.InFlight = len(c.pending)
+			c.mu.Unlock()
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Close 断开全部隧道
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	conns := make([]*conn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+}
@@ -0,0 +1,264 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testName   = "factory-a"
+	testToken  = "token-a"
+	testTenant = "tenant-a"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// startHub 启动隧道入口，返回隧道中心和 ws:// 连接地址
+func startHub(t *testing.T, agents ...Agent) (*Hub, string) {
+	t.Helper()
+	if len(agents) == 0 {
+		agents = []Agent{{Name: testName, Token: testToken, Tenants: []string{testTenant}}}
+	}
+	hub, err := New(Config{Enabled: true, Agents: agents}, testLogger())
+	if err != nil {
+		t.Fatalf("创建隧道中心失败: %v", err)
+	}
+	srv := httptest.NewServer(hub.Handler())
+	t.Cleanup(func() {
+		hub.Close()
+		srv.Close()
+	})
+	return hub, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialAgent 以小智侧身份连接隧道，等待插件登记连接后返回
+func dialAgent(t *testing.T, hub *Hub, url, name, token string) *websocket.Conn {
+	t.Helper()
+	ws, err := dial(url, name, token)
+	if err != nil {
+		t.Fatalf("连接隧道失败: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	waitFor(t, func() bool {
+		for _, a := range hub.Agents() {
+			if a.Name == name && a.RemoteAddr == ws.LocalAddr().String() {
+				return true
+			}
+		}
+		return false
+	})
+	return ws
+}
+
+func dial(url, name, token string) (*websocket.Conn, error) {
+	header := http.Header{}
+	header.Set(NameHeader, name)
+	header.Set("Authorization", "Bearer "+token)
+	ws, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return ws, err
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readFrame 读取插件经隧道发出的一个请求
+func readFrame(t *testing.T, ws *websocket.Conn) Frame {
+	t.Helper()
+	var f Frame
+	if err := ws.ReadJSON(&f); err != nil {
+		t.Errorf("读取隧道请求失败: %v", err)
+	}
+	return f
+}
+
+// get 以租户身份经隧道发送请求
+func get(hub *Hub, tenant, target string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(WithTenant(context.Background(), tenant), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return hub.Transport(nil).RoundTrip(req)
+}
+
+func TestMultiplexFrames(t *testing.T) {
+	hub, url := startHub(t)
+	agent := dialAgent(t, hub, url, testName, testToken)
+
+	const n = 8
+	// 收齐全部请求后倒序应答，验证响应按ID交给对应的请求
+	go func() {
+		frames := make([]Frame, 0, n)
+		for len(frames) < n {
+			frames = append(frames, readFrame(t, agent))
+		}
+		for i := len(frames) - 1; i >= 0; i-- {
+			f := frames[i]
+			agent.WriteJSON(Frame{ID: f.ID, Status: http.StatusOK, Body: []byte(f.Path)})
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/xiaozhi/device/list?page=%d", i)
+			resp, err := get(hub, testTenant, "tunnel://"+testName+path)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(body) != path {
+				errCh <- fmt.Errorf("请求 %s 收到响应 %d %s", path, resp.StatusCode, body)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+	if s := hub.Agents()[0]; s.Requests != n || s.InFlight != 0 {
+		t.Fatalf("隧道状态 %+v，期望累计 %d 个请求且无进行中请求", s, n)
+	}
+}
+
+func TestDisconnectDuringRequest(t *testing.T) {
+	hub, url := startHub(t)
+	agent := dialAgent(t, hub, url, testName, testToken)
+	go func() {
+		readFrame(t, agent)
+		agent.Close()
+	}()
+
+	start := time.Now()
+	_, err := get(hub, testTenant, "tunnel://"+testName+"/xiaozhi/device/bind")
+	if err == nil || !strings.Contains(err.Error(), "已断开") {
+		t.Fatalf("隧道断开时返回 %v，期望断开错误", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("隧道断开 %v 后才返回", elapsed)
+	}
+	waitFor(t, func() bool { return !hub.Agents()[0].Connected })
+	if _, err := get(hub, testTenant, "tunnel://"+testName+"/xiaozhi/device/bind"); err == nil || !strings.Contains(err.Error(), "未连接") {
+		t.Fatalf("隧道未连接时返回 %v", err)
+	}
+}
+
+func TestReplaceConnection(t *testing.T) {
+	hub, url := startHub(t)
+	old := dialAgent(t, hub, url, testName, testToken)
+	current := dialAgent(t, hub, url, testName, testToken)
+
+	// 旧连接被插件关闭
+	old.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := old.ReadMessage(); err == nil {
+		t.Fatal("同名隧道重连后旧连接仍可读取")
+	}
+	// 旧连接关闭不影响新连接的登记
+	if s := hub.Agents()[0]; !s.Connected || s.RemoteAddr != current.LocalAddr().String() {
+		t.Fatalf("隧道状态 %+v，期望为新连接", s)
+	}
+
+	go func() {
+		f := readFrame(t, current)
+		current.WriteJSON(Frame{ID: f.ID, Status: http.StatusOK, Body: []byte("new")})
+	}()
+	resp, err := get(hub, testTenant, "tunnel://"+testName+"/xiaozhi/device/list")
+	if err != nil {
+		t.Fatalf("经新连接转发失败: %v", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "new" {
+		t.Fatalf("响应 %s 不是新连接返回的", body)
+	}
+}
+
+func TestAuthRejected(t *testing.T) {
+	hub, url := startHub(t)
+	cases := []struct{ name, token string }{
+		{testName, "wrong"},
+		{testName, ""},
+		{"unknown", testToken},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if ws, err := dial(url, c.name, c.token); err == nil || err.Error() != "HTTP 401" {
+			if ws != nil {
+				ws.Close()
+			}
+			t.Fatalf("名称 %q 令牌 %q 连接返回 %v，期望 HTTP 401", c.name, c.token, err)
+		}
+	}
+	if s := hub.Agents()[0]; s.Connected {
+		t.Fatalf("鉴权失败后隧道状态 %+v", s)
+	}
+}
+
+func TestTenantRejected(t *testing.T) {
+	hub, url := startHub(t,
+		Agent{Name: testName, Token: testToken, Tenants: []string{testTenant}},
+		Agent{Name: "shared", Token: "token-shared", Tenants: []string{AnyTenant}},
+	)
+	agent := dialAgent(t, hub, url, testName, testToken)
+
+	for _, tenant := range []string{"tenant-b", ""} {
+		if _, err := get(hub, tenant, "tunnel://"+testName+"/xiaozhi/device/bind"); !errors.Is(err, ErrForbidden) {
+			t.Fatalf("租户 %q 经隧道调用返回 %v，期望 ErrForbidden", tenant, err)
+		}
+		if err := hub.Authorize("tunnel://"+testName+"/xiaozhi", tenant); !errors.Is(err, ErrForbidden) {
+			t.Fatalf("租户 %q 校验返回 %v，期望 ErrForbidden", tenant, err)
+		}
+	}
+	// 被拒绝的请求不发往隧道
+	agent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := agent.ReadMessage(); err == nil {
+		t.Fatal("未授权租户的请求被转发到隧道")
+	}
+
+	for _, c := range []struct{ url, tenant string }{
+		{"tunnel://" + testName + "/xiaozhi", testTenant},
+		{"tunnel://shared/xiaozhi", "tenant-b"},
+		{"http://xiaozhi.example.com/xiaozhi", "tenant-b"},
+	} {
+		if err := hub.Authorize(c.url, c.tenant); err != nil {
+			t.Fatalf("租户 %s 访问 %s 被拒绝: %v", c.tenant, c.url, err)
+		}
+	}
+}
+
+func TestNewRequiresTenants(t *testing.T) {
+	_, err := New(Config{Enabled: true, Agents: []Agent{{Name: testName, Token: testToken}}}, testLogger())
+	if err == nil || !strings.Contains(err.Error(), "tenants") {
+		t.Fatalf("未配置租户时返回 %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+// Package upstream 小智(ESP32)服务端接口的类型定义
+//
+// 类型由 schema/xiaozhi.json 生成，新增或修改接口时修改 schema 后执行 go generate，不要手工编辑 types_gen.go
+package upstream
+
+//go:generate go run ../../tools/schemagen -in schema/xiaozhi.json -out types_gen.go -pkg upstream
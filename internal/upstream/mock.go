@@ -0,0 +1,134 @@
+// internal/upstream/mock.go
+package upstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MockTransport 离线开发用的小智服务端模拟
+// 按请求路径的最后两段查找夹具文件，如 .../device/list 对应 <dir>/device_list.json，
+// 不实际发出网络请求；设备列表按请求中的 search 过滤、page/page_size 分页返回
+type MockTransport struct {
+	dir    string
+	logger *logrus.Logger
+}
+
+// NewMockTransport 创建读取 dir 下夹具文件的模拟传输层
+func NewMockTransport(dir string, logger *logrus.Logger) (*MockTransport, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("夹具目录不可用: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("夹具路径不是目录: %s", dir)
+	}
+	return &MockTransport{dir: dir, logger: logger}, nil
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = data
+	}
+
+	name := fixtureName(req.URL.Path)
+	path := filepath.Join(t.dir, name)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.logger.WithField("fixture", path).Warn("模拟模式下夹具文件不存在")
+		return jsonResponse(req, http.StatusNotFound, []byte(`{"code":404,"msg":"fixture not found"}`)), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取夹具文件失败: %v", err)
+	}
+
+	if name == "device_list.json" {
+		if data, err = paginate(data, reqBody); err != nil {
+			return nil, err
+		}
+	}
+
+	t.logger.WithFields(logrus.Fields{
+		"path":    req.URL.Path,
+		"fixture": path,
+	}).Debug("模拟模式返回夹具数据")
+	return jsonResponse(req, http.StatusOK, data), nil
+}
+
+// fixtureName 将 /xiaozhi/device/list 映射为 device_list.json
+func fixtureName(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	return strings.Join(parts, "_") + ".json"
+}
+
+// paginate 按请求的搜索关键字(设备名称或编号包含，不区分大小写)过滤、按分页参数截取夹具中的设备列表，
+// total 为夹具中的总数，搜索时为匹配的设备数
+func paginate(fixture, reqBody []byte) ([]byte, error) {
+	var resp DeviceListResponse
+	if err := json.Unmarshal(fixture, &resp); err != nil {
+		return nil, fmt.Errorf("解析设备列表夹具失败: %v", err)
+	}
+	var req DeviceListRequest
+	if len(reqBody) > 0 {
+		if err := json.Unmarshal(reqBody, &req); err != nil {
+			return nil, fmt.Errorf("解析设备列表请求失败: %v", err)
+		}
+	}
+	if resp.Data.Total == 0 {
+		resp.Data.Total = len(resp.Data.List)
+	}
+	if search := strings.ToLower(req.Search); search != "" {
+		matched := resp.Data.List[:0]
+		for _, d := range resp.Data.List {
+			if strings.Contains(strings.ToLower(d.DeviceName), search) || strings.Contains(strings.ToLower(d.DeviceNumber), search) {
+				matched = append(matched, d)
+			}
+		}
+		resp.Data.List = matched
+		resp.Data.Total = len(matched)
+	}
+	if req.Page > 0 && req.PageSize > 0 {
+		start := (req.Page - 1) * req.PageSize
+		if start > len(resp.Data.List) {
+			start = len(resp.Data.List)
+		}
+		end := start + req.PageSize
+		if end > len(resp.Data.List) {
+			end = len(resp.Data.List)
+		}
+		resp.Data.List = resp.Data.List[start:end]
+	}
+	return json.Marshal(resp)
+}
+
+func jsonResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
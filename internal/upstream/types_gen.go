@@ -0,0 +1,89 @@
+// Code generated by tools/schemagen from schema/xiaozhi.json; DO NOT EDIT.
+
+package upstream
+
+// AuthLoginData 会话令牌
+type AuthLoginData struct {
+	ExpiresIn int    `json:"expires_in,omitempty"` // 有效期(秒)，0 表示由插件按默认有效期刷新
+	Token     string `json:"token"`                // 会话令牌，后续请求放在 x-token 请求头
+}
+
+// AuthLoginRequest POST {ServerURL}/auth/login 请求体，以服务密钥换取会话令牌(凭证 AuthType 为 session 时使用)
+type AuthLoginRequest struct {
+	Secret string `json:"secret"` // 服务密钥(凭证中的 Secret)
+}
+
+// AuthLoginResponse /auth/login 响应
+type AuthLoginResponse struct {
+	Code int           `json:"code"` // 业务状态码，0 或 200 表示成功
+	Data AuthLoginData `json:"data"`
+	Msg  string        `json:"msg,omitempty"` // 错误信息
+}
+
+// CommonResponse 不带数据的通用响应
+type CommonResponse struct {
+	Code int    `json:"code"`          // 业务状态码，0 或 200 表示成功
+	Msg  string `json:"msg,omitempty"` // 错误信息
+}
+
+// Device 第三方服务中的设备
+type Device struct {
+	Description  string `json:"description"`        // 设备描述
+	DeviceName   string `json:"device_name"`        // 设备名称
+	DeviceNumber string `json:"device_number"`      // 设备编号(通常为MAC地址)
+	Firmware     string `json:"firmware,omitempty"` // 固件版本
+	Location     string `json:"location,omitempty"` // 安装位置
+	Model        string `json:"model,omitempty"`    // 设备型号(开发板)
+}
+
+// DeviceBindRequest POST {ServerURL}/device/bind 请求体，将设备绑定到智能体
+// 设备已绑定到其他智能体或租户时返回 code=409(或信息中包含“已绑定”)
+type DeviceBindRequest struct {
+	AgentID      string `json:"agent_id,omitempty"` // 智能体ID
+	Code         string `json:"code,omitempty"`     // 设备屏幕上显示的绑定验证码
+	DeviceNumber string `json:"device_number"`      // 设备编号(通常为MAC地址)
+	Voucher      string `json:"voucher,omitempty"`  // 服务接入点凭证(JSON字符串)
+}
+
+// DeviceCommandRequest POST {ServerURL}/device/command 请求体，向在线设备下发命令(命令标识见设备能力模型)
+type DeviceCommandRequest struct {
+	Command      string                 `json:"command"`           // 命令标识，如 reboot、set_volume
+	DeviceNumber string                 `json:"device_number"`     // 设备编号(通常为MAC地址)
+	Params       map[string]interface{} `json:"params,omitempty"`  // 命令参数
+	Voucher      string                 `json:"voucher,omitempty"` // 服务接入点凭证(JSON字符串)
+}
+
+// DeviceConfigRequest POST {ServerURL}/device/config 请求体，下发设备初始配置(如唤醒词、音量)
+type DeviceConfigRequest struct {
+	Config       map[string]interface{} `json:"config"`            // 配置项，键值由小智服务端定义
+	DeviceNumber string                 `json:"device_number"`     // 设备编号(通常为MAC地址)
+	Voucher      string                 `json:"voucher,omitempty"` // 服务接入点凭证(JSON字符串)
+}
+
+// DeviceListData 设备列表分页数据
+type DeviceListData struct {
+	List  []Device `json:"list"`
+	Total int      `json:"total"` // 设备总数
+}
+
+// DeviceListRequest POST {ServerURL}/device/list 请求体，原样透传平台的查询参数
+type DeviceListRequest struct {
+	Page              int    `json:"page"`               // 页码，从1开始
+	PageSize          int    `json:"page_size"`          // 每页数量
+	Search            string `json:"search,omitempty"`   // 按设备名称或编号搜索的关键字，为空时不过滤；不支持的服务端忽略该字段
+	ServiceIdentifier string `json:"service_identifier"` // 服务标识符
+	Voucher           string `json:"voucher"`            // 服务接入点凭证(JSON字符串)
+}
+
+// DeviceListResponse /device/list 响应
+type DeviceListResponse struct {
+	Code int            `json:"code"` // 业务状态码，0 或 200 表示成功
+	Data DeviceListData `json:"data"`
+	Msg  string         `json:"msg,omitempty"` // 错误信息
+}
+
+// DeviceUnbindRequest POST {ServerURL}/device/unbind 请求体，释放设备与智能体的绑定
+type DeviceUnbindRequest struct {
+	DeviceNumber string `json:"device_number"`     // 设备编号(通常为MAC地址)
+	Voucher      string `json:"voucher,omitempty"` // 服务接入点凭证(JSON字符串)
+}
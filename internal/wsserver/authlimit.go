@@ -0,0 +1,92 @@
+package wsserver
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 设备鉴权失败限制：同一设备编号或同一来源地址在窗口内连续鉴权失败达到次数后，窗口剩余时间内直接拒绝，
+// 不再向平台查询设备，避免知道设备编号的客户端以大量握手请求冲击平台接口
+const (
+	authFailureLimit   = 5
+	authFailureWindow  = time.Minute
+	authLimiterMaxKeys = 10000 // 记录的键超过该数量时清理已过期的记录
+)
+
+// authFailures 一个键在当前窗口内的鉴权失败次数
+type authFailures struct {
+	count   int
+	resetAt time.Time
+}
+
+// authLimiter 按设备编号和来源地址统计鉴权失败
+type authLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*authFailures
+}
+
+// deviceKey 和 addrKey 区分两类键，设备编号与地址相同时互不影响
+func deviceKey(number string) string { return "device:" + number }
+func addrKey(addr string) string     { return "addr:" + addr }
+
+// remoteHost 来源地址去掉端口
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// claimedNumber 握手请求声明的设备编号，鉴权前只用于占用会话名额和限制鉴权失败
+func claimedNumber(r *http.Request) string {
+	if number := strings.TrimSpace(r.Header.Get(DeviceIDHeader)); number != "" {
+		return number
+	}
+	return r.URL.Query().Get("device_id")
+}
+
+// blocked 任一键在窗口内的失败次数已达上限时返回true
+func (l *authLimiter) blocked(now time.Time, keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if e := l.entries[key]; e != nil && now.Before(e.resetAt) && e.count >= authFailureLimit {
+			return true
+		}
+	}
+	return false
+}
+
+// fail 记录一次鉴权失败
+func (l *authLimiter) fail(now time.Time, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.entries == nil {
+		l.entries = make(map[string]*authFailures)
+	}
+	if len(l.entries) >= authLimiterMaxKeys {
+		for key, e := range l.entries {
+			if !now.Before(e.resetAt) {
+				delete(l.entries, key)
+			}
+		}
+	}
+	for _, key := range keys {
+		e := l.entries[key]
+		if e == nil || !now.Before(e.resetAt) {
+			e = &authFailures{resetAt: now.Add(authFailureWindow)}
+			l.entries[key] = e
+		}
+		e.count++
+	}
+}
+
+// reset 鉴权成功后清除该键的失败记录；来源地址可能由多台设备共用，只清除设备编号
+func (l *authLimiter) reset(key string) {
+	l.mu.Lock()
+	delete(l.entries, key)
+	l.mu.Unlock()
+}
@@ -0,0 +1,103 @@
+// internal/wsserver/probe.go
+package wsserver
+
+import (
+	"errors"
+	"strconv"
+	"time"
+	"tp-plugin/internal/metrics"
+
+	"github.com/gorilla/websocket"
+)
+
+// 连通性探测参数
+const (
+	DefaultProbeTimeout = 5 * time.Second
+	MaxProbeTimeout     = 30 * time.Second
+)
+
+// ErrProbeTimeout 设备未在超时前应答探测
+var ErrProbeTimeout = errors.New("等待设备应答超时")
+
+var wsProbes = metrics.NewCounterVec("tp_plugin_ws_probes_total",
+	"管理接口发起的设备连通性探测次数", "result")
+
+// ProbeResult 一次连通性探测的结果
+type ProbeResult struct {
+	DeviceNumber string    `json:"device_number"`
+	SessionID    string    `json:"session_id,omitempty"`
+	Reachable    bool      `json:"reachable"`
+	RTTMs        float64   `json:"rtt_ms,omitempty"`
+	SentAt       time.Time `json:"sent_at"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Probe 向设备会话发送一个ping并等待对应的pong，返回往返时延；WebSocket协议要求设备自动应答pong，固件无需额外支持。
+// timeout 为0时使用默认值5秒，最长30秒；设备未连接时返回 ErrNotConnected
+func (s *Server) Probe(deviceNumber string, timeout time.Duration) (ProbeResult, error) {
+	result := ProbeResult{DeviceNumber: deviceNumber, SentAt: time.Now()}
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	if timeout > MaxProbeTimeout {
+		timeout = MaxProbeTimeout
+	}
+	var sess *session
+	if s != nil {
+		s.mu.Lock()
+		sess = s.sessions[deviceNumber]
+		s.mu.Unlock()
+	}
+	if sess == nil {
+		wsProbes.WithLabelValues("offline").Inc()
+		return result, ErrNotConnected
+	}
+	result.SessionID = sess.info.SessionID
+
+	// 载荷与周期ping相同为发送时间(纳秒)，设备原样应答，按载荷区分本次探测
+	payload := strconv.FormatInt(result.SentAt.UnixNano(), 10)
+	ch := make(chan time.Time, 1)
+	sess.mu.Lock()
+	if sess.probes == nil {
+		sess.probes = make(map[string]chan time.Time)
+	}
+	sess.probes[payload] = ch
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		delete(sess.probes, payload)
+		sess.mu.Unlock()
+	}()
+
+	if err := sess.write(websocket.PingMessage, []byte(payload)); err != nil {
+		sess.close()
+		wsProbes.WithLabelValues("error").Inc()
+		result.Error = err.Error()
+		return result, nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case at := <-ch:
+		result.Reachable = true
+		result.RTTMs = float64(at.Sub(result.SentAt).Microseconds()) / 1000
+		wsProbes.WithLabelValues("ok").Inc()
+	case <-sess.closed:
+		result.Error = ErrNotConnected.Error()
+		wsProbes.WithLabelValues("closed").Inc()
+	case <-timer.C:
+		result.Error = ErrProbeTimeout.Error()
+		wsProbes.WithLabelValues("timeout").Inc()
+	}
+	return result, nil
+}
+
+// probeAnswered 收到pong时通知等待中的探测，调用方需持有 sess.mu
+func (sess *session) probeAnswered(payload string, at time.Time) {
+	if ch := sess.probes[payload]; ch != nil {
+		select {
+		case ch <- at:
+		default:
+		}
+	}
+}
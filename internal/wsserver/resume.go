@@ -0,0 +1,160 @@
+// internal/wsserver/resume.go
+package wsserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+	"tp-plugin/internal/metrics"
+)
+
+// ResumeHeader 设备重连时提交断线续连令牌的请求头，也可使用 resume_token 查询参数
+const ResumeHeader = "Resume-Token"
+
+var wsResumes = metrics.NewCounterVec("tp_plugin_ws_resumes_total",
+	"设备WebSocket断线续连次数", "result")
+
+// resumeEntry 一个续连令牌对应的会话
+type resumeEntry struct {
+	deviceNumber string
+	info         SessionInfo // 断开时的会话状态，续连后沿用会话ID和统计
+	expires      time.Time   // 会话断开后设置，连接中为零值
+}
+
+// pendingOffline 推迟中的下线上报
+type pendingOffline struct {
+	timer    *time.Timer
+	deviceID string
+}
+
+func newResumeToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// issueResume 为会话生成新的续连令牌，旧令牌作废；未启用续连时返回空字符串
+func (s *Server) issueResume(sess *session) string {
+	if s.cfg.ResumeTTL <= 0 {
+		return ""
+	}
+	token := newResumeToken()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.resumes, sess.resume)
+	sess.resume = token
+	s.resumes[token] = &resumeEntry{deviceNumber: sess.info.DeviceNumber}
+	return token
+}
+
+// resume 以请求携带的续连令牌恢复会话，令牌只能使用一次；返回断开时的会话状态。
+// 令牌无效、过期或与 Device-Id 不符时返回false，调用方按设备凭证重新鉴权
+func (s *Server) resume(r *http.Request) (SessionInfo, bool) {
+	token := r.Header.Get(ResumeHeader)
+	if token == "" {
+		token = r.URL.Query().Get("resume_token")
+	}
+	if token == "" || s.cfg.ResumeTTL <= 0 {
+		return SessionInfo{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.resumes[token]
+	if e == nil {
+		wsResumes.WithLabelValues("invalid").Inc()
+		return SessionInfo{}, false
+	}
+	delete(s.resumes, token)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		wsResumes.WithLabelValues("expired").Inc()
+		return SessionInfo{}, false
+	}
+	if number := strings.TrimSpace(r.Header.Get(DeviceIDHeader)); number != "" && number != e.deviceNumber {
+		wsResumes.WithLabelValues("invalid").Inc()
+		return SessionInfo{}, false
+	}
+	info := e.info
+	// 插件尚未发现旧连接断开时令牌仍属于已连接的会话，沿用其当前状态
+	if e.expires.IsZero() {
+		if sess := s.sessions[e.deviceNumber]; sess != nil && sess.resume == token {
+			info = sess.snapshot()
+		}
+	}
+	if info.DeviceNumber == "" {
+		wsResumes.WithLabelValues("invalid").Inc()
+		return SessionInfo{}, false
+	}
+	wsResumes.WithLabelValues("ok").Inc()
+	return info, true
+}
+
+// deferOffline 会话持有续连令牌时将下线上报推迟到令牌过期，期间设备续连或重新连接则不再上报；
+// 未启用续连、会话未取得令牌或服务关闭时返回false，调用方立即上报
+func (s *Server) deferOffline(sess *session) bool {
+	if s.cfg.ResumeTTL <= 0 || s.closing.Load() {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := sess.resume
+	e := s.resumes[token]
+	if e == nil {
+		return false
+	}
+	e.info = sess.snapshot()
+	e.expires = time.Now().Add(s.cfg.ResumeTTL)
+	number, deviceID := sess.info.DeviceNumber, sess.info.DeviceID
+	p := &pendingOffline{deviceID: deviceID}
+	p.timer = time.AfterFunc(s.cfg.ResumeTTL, func() {
+		s.mu.Lock()
+		pending := s.offline[number] == p
+		if pending {
+			delete(s.offline, number)
+		}
+		delete(s.resumes, token)
+		connected := s.sessions[number] != nil
+		s.mu.Unlock()
+		if !pending || connected {
+			return
+		}
+		if err := s.platform.SendDeviceStatus(deviceID, "0"); err != nil {
+			s.logger.WithError(err).WithField("device_number", number).Warn("上报设备下线失败")
+		}
+		s.logger.WithField("device_number", number).Info("设备未在续连有效期内重连，已上报下线")
+	})
+	if old := s.offline[number]; old != nil {
+		old.timer.Stop()
+	}
+	s.offline[number] = p
+	return true
+}
+
+// cancelOffline 设备重新连接时取消推迟的下线上报，返回是否有推迟中的下线(即平台上仍为在线)；调用方需持有锁
+func (s *Server) cancelOffline(number string) bool {
+	p := s.offline[number]
+	if p == nil {
+		return false
+	}
+	p.timer.Stop()
+	delete(s.offline, number)
+	return true
+}
+
+// flushOffline 服务关闭时立即上报全部推迟中的下线
+func (s *Server) flushOffline() {
+	s.mu.Lock()
+	pending := s.offline
+	s.offline = make(map[string]*pendingOffline)
+	s.resumes = make(map[string]*resumeEntry)
+	s.mu.Unlock()
+	for number, p := range pending {
+		if !p.timer.Stop() {
+			continue // 已到期，由定时器上报
+		}
+		if err := s.platform.SendDeviceStatus(p.deviceID, "0"); err != nil {
+			s.logger.WithError(err).WithField("device_number", number).Warn("上报设备下线失败")
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// internal/wsserver/throttle.go
+package wsserver
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/platform"
+
+	"github.com/gorilla/websocket"
+)
+
+// 过载限速默认参数
+const (
+	DefaultThrottleHigh   = 0.8
+	DefaultThrottleLow    = 0.5
+	throttleCheckInterval = time.Second
+)
+
+var (
+	wsThrottled = metrics.NewGaugeVec("tp_plugin_ws_throttled",
+		"是否正在要求设备降低上报频率(1为是)")
+	wsThrottleTransitions = metrics.NewCounterVec("tp_plugin_ws_throttle_transitions_total",
+		"设备上报限速状态切换次数", "state")
+)
+
+// ThrottleConfig 过载时要求设备降低上报频率的配置。遥测上报队列占用超过 High 时向全部设备下发 throttle 消息，
+// 降到 Low 以下时下发恢复消息；占用比例在两者之间时保持当前状态，避免频繁切换
+type ThrottleConfig struct {
+	ReportInterval time.Duration // 限速期间建议设备使用的上报间隔，0为不启用
+	High           float64       // 队列占用比例达到该值时限速，0使用默认值0.8
+	Low            float64       // 队列占用比例低于该值时恢复，0使用默认值0.5
+}
+
+// Throttle throttle 消息的内容，active 为 false 时设备恢复原上报间隔
+type Throttle struct {
+	Active         bool    `json:"active"`
+	ReportInterval int     `json:"report_interval,omitempty"` // 建议的上报间隔(秒)
+	Pressure       float64 `json:"pressure"`                  // 插件遥测上报队列占用比例
+}
+
+func (c ThrottleConfig) thresholds() (high, low float64) {
+	high, low = c.High, c.Low
+	if high <= 0 || high > 1 {
+		high = DefaultThrottleHigh
+	}
+	if low <= 0 || low >= high {
+		low = DefaultThrottleLow
+		if low >= high {
+			low = high / 2
+		}
+	}
+	return high, low
+}
+
+// watchPressure 定期检查遥测上报队列占用，按阈值切换限速状态，Close 后退出
+func (s *Server) watchPressure() {
+	if s.cfg.Throttle.ReportInterval <= 0 {
+		return
+	}
+	high, low := s.cfg.Throttle.thresholds()
+	ticker := time.NewTicker(throttleCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.closing.Load() {
+			return
+		}
+		s.applyPressure(s.platform.TelemetryPressure(), high, low)
+	}
+}
+
+// applyPressure 队列占用达到 high 时限速，低于 low 时恢复，两者之间保持当前状态
+func (s *Server) applyPressure(pressure, high, low float64) {
+	switch {
+	case pressure >= high:
+		s.setThrottle(true, pressure)
+	case pressure < low:
+		s.setThrottle(false, pressure)
+	}
+}
+
+// overloaded 遥测因上报队列已满被拒绝时立即限速，不等下一次检查
+func (s *Server) overloaded(err error) {
+	if s.cfg.Throttle.ReportInterval > 0 && errors.Is(err, platform.ErrTelemetryBacklogged) {
+		s.setThrottle(true, 1)
+	}
+}
+
+// setThrottle 切换限速状态，状态变化时向全部已连接的设备下发 throttle 消息
+func (s *Server) setThrottle(active bool, pressure float64) {
+	if s.throttled.Swap(active) == active {
+		return
+	}
+	state := "restored"
+	if active {
+		state = "throttled"
+		wsThrottled.WithLabelValues().Set(1)
+		s.logger.WithField("pressure", pressure).WithField("report_interval", s.cfg.Throttle.ReportInterval).
+			Warn("遥测上报队列积压，要求设备降低上报频率")
+	} else {
+		wsThrottled.WithLabelValues().Set(0)
+		s.logger.WithField("pressure", pressure).Info("遥测上报队列已恢复，通知设备恢复上报频率")
+	}
+	wsThrottleTransitions.WithLabelValues(state).Inc()
+
+	data := s.throttleMessage(active, pressure)
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+	// 逐个会话并发发送，个别设备写入缓慢不影响其他设备
+	for _, sess := range sessions {
+		go func(sess *session) {
+			if err := sess.write(websocket.TextMessage, data); err != nil {
+				sess.close()
+			}
+		}(sess)
+	}
+}
+
+func (s *Server) throttleMessage(active bool, pressure float64) []byte {
+	t := &Throttle{Active: active, Pressure: pressure}
+	if active {
+		t.ReportInterval = int(s.cfg.Throttle.ReportInterval / time.Second)
+	}
+	data, _ := json.Marshal(Message{Type: "throttle", Throttle: t})
+	return data
+}
@@ -0,0 +1,715 @@
+// internal/wsserver/wsserver.go
+package wsserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	formjson "tp-plugin/internal/form_json"
+	"tp-plugin/internal/metrics"
+	"tp-plugin/internal/pkg/listen"
+	"tp-plugin/internal/platform"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultPath 小智固件默认的WebSocket地址路径
+const DefaultPath = "/xiaozhi/v1/"
+
+// 小智固件连接时携带的请求头
+const (
+	DeviceIDHeader        = "Device-Id"        // 设备编号(MAC地址)
+	ClientIDHeader        = "Client-Id"        // 固件生成的客户端UUID
+	ProtocolVersionHeader = "Protocol-Version" // 固件协议版本
+)
+
+// 连接参数
+const (
+	DefaultHeartbeatTimeout = 60 * time.Second
+	DefaultMaxMessageSize   = 1 << 20
+	writeTimeout            = 10 * time.Second
+)
+
+var (
+	wsSessions = metrics.NewGaugeVec("tp_plugin_ws_sessions",
+		"已连接的设备WebSocket会话数")
+	wsMessages = metrics.NewCounterVec("tp_plugin_ws_messages_total",
+		"设备WebSocket上行消息数", "type", "result")
+	wsRejected = metrics.NewCounterVec("tp_plugin_ws_rejected_total",
+		"拒绝的设备WebSocket连接数", "reason")
+)
+
+// ErrNotConnected 设备没有已建立的会话
+var ErrNotConnected = errors.New("设备未连接")
+
+// Config 设备WebSocket服务配置
+type Config struct {
+	Port             int           // 监听端口，0为不启用
+	Bind             []string      // 绑定的地址，为空时监听全部网卡
+	Network          string        // tcp(IPv4/IPv6双栈)/tcp4/tcp6，为空时为 tcp
+	Path             string        // 连接路径，为空时使用 DefaultPath
+	MaxConnections   int           // 最大会话数，0为不限制
+	HeartbeatTimeout time.Duration // 超过该时长未收到任何消息(含pong)视为断开，0使用默认值60秒
+	PingInterval     time.Duration // 发送ping的间隔，0为心跳超时的一半
+	PongTimeout      time.Duration // 发送ping后等待设备应答的时长，期间未收到pong或其他消息即断开，0为只按心跳超时判断
+	MaxMessageSize   int64         // 单条上行消息的字节上限，超出时断开连接，0使用默认值1MB
+	ResumeTTL        time.Duration // 断线续连令牌在连接断开后的有效期，期间重连无需重新鉴权且不上报下线，0为不启用
+	// 过载时要求设备降低上报频率
+	Throttle ThrottleConfig
+}
+
+// Message 设备与插件之间的JSON文本消息，字段与小智固件协议一致
+type Message struct {
+	Type      string                 `json:"type"`
+	SessionID string                 `json:"session_id,omitempty"`
+	Transport string                 `json:"transport,omitempty"`
+	Version   int                    `json:"version,omitempty"`
+	Values    map[string]interface{} `json:"values,omitempty"` // telemetry 消息的遥测数据
+	States    []IoTState             `json:"states,omitempty"` // iot 消息的设备状态
+	// 下行消息及设备的 ack 应答，ack 以 id 对应下行消息，code 为0且 error 为空表示执行成功
+	ID     string                 `json:"id,omitempty"`
+	Method string                 `json:"method,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	Code   int                    `json:"code,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	// hello 应答携带的断线续连令牌，设备重连时以 Resume-Token 请求头提交；resumed 表示本次连接为续连
+	ResumeToken string `json:"resume_token,omitempty"`
+	Resumed     bool   `json:"resumed,omitempty"`
+	// 插件过载时下发的 throttle 消息，要求设备临时调大上报间隔或恢复
+	Throttle *Throttle `json:"throttle,omitempty"`
+}
+
+// IoTState 小智固件 iot 消息中一个物联网对象的状态
+type IoTState struct {
+	Name  string                 `json:"name"`
+	State map[string]interface{} `json:"state"`
+}
+
+// SessionInfo 会话状态
+type SessionInfo struct {
+	SessionID       string     `json:"session_id"`
+	DeviceNumber    string     `json:"device_number"`
+	DeviceID        string     `json:"device_id"`
+	ClientID        string     `json:"client_id,omitempty"`
+	ProtocolVersion string     `json:"protocol_version,omitempty"`
+	RemoteAddr      string     `json:"remote_addr"`
+	ConnectedAt     time.Time  `json:"connected_at"`
+	LastMessageAt   *time.Time `json:"last_message_at,omitempty"` // 尚未收到消息时为nil；每次替换为新值，不修改指向的时间
+	Messages        uint64     `json:"messages"`
+	BytesIn         uint64     `json:"bytes_in"`
+	BytesOut        uint64     `json:"bytes_out"`
+	MessagesOut     uint64     `json:"messages_out"`
+	Pings           uint64     `json:"pings"`            // 已发送的ping
+	Pongs           uint64     `json:"pongs"`            // 收到的pong
+	RTTMs           float64    `json:"rtt_ms,omitempty"` // 最近一次ping往返时延
+	Resumes         uint64     `json:"resumes"`          // 以续连令牌恢复的次数
+}
+
+// newSessionID 会话ID，hello 应答中返回给设备
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// session 一个设备的WebSocket连接
+type session struct {
+	ws        *websocket.Conn
+	writeMu   sync.Mutex
+	mu        sync.Mutex
+	info      SessionInfo
+	closed    chan struct{}
+	closeOnce sync.Once
+	resumed   bool   // 本次连接以续连令牌恢复
+	resume    string // 当前的续连令牌，由 Server.mu 保护
+	// probes 进行中的连通性探测(ping载荷 -> 等待pong的通道)，由 mu 保护
+	probes map[string]chan time.Time
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.ws.Close()
+	})
+}
+
+func (s *session) write(messageType int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := s.ws.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	switch messageType {
+	case websocket.PingMessage:
+		s.info.Pings++
+	case websocket.TextMessage, websocket.BinaryMessage:
+		s.info.MessagesOut++
+		s.info.BytesOut += uint64(len(data))
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *session) snapshot() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
+// Server 接受小智固件直接建立的WebSocket连接：以设备凭证鉴权，按设备编号维护会话，
+// 将上行的遥测和物联网状态发布到平台，会话建立和断开时上报设备上下线
+type Server struct {
+	cfg      Config
+	platform *platform.PlatformClient
+	logger   *logrus.Logger
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	sessions map[string]*session // 设备编号 -> 会话
+	reserved int                 // 已占用名额、尚未登记会话的握手数，由 mu 保护
+	// authFailures 按设备编号和来源地址统计的鉴权失败
+	authFailures authLimiter
+	httpSrv      *http.Server
+	onAck        func(deviceNumber string, ack Message)
+	onHello      func(deviceNumber string)
+	onOTA        func(deviceNumber string, progress Message)
+	resumes      map[string]*resumeEntry    // 续连令牌 -> 会话
+	offline      map[string]*pendingOffline // 设备编号 -> 推迟的下线上报
+	closing      atomic.Bool
+	serving      sync.WaitGroup // 进行中的会话读循环，Close 等待其上报下线后返回
+	// throttled 当前是否要求设备降低上报频率
+	throttled atomic.Bool
+	// heartbeat 当前的心跳超时(纳秒)，可通过 SetHeartbeatTimeout 热更新
+	heartbeat atomic.Int64
+}
+
+// New 创建设备WebSocket服务，端口为0时返回nil
+func New(cfg Config, platform *platform.PlatformClient, logger *logrus.Logger) *Server {
+	if cfg.Port <= 0 {
+		return nil
+	}
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if cfg.HeartbeatTimeout <= 0 {
+		cfg.HeartbeatTimeout = DefaultHeartbeatTimeout
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultMaxMessageSize
+	}
+	s := &Server{
+		cfg:      cfg,
+		platform: platform,
+		logger:   logger,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			// 固件不发送 Origin，浏览器调试工具发送的 Origin 同样允许
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+		sessions: make(map[string]*session),
+		resumes:  make(map[string]*resumeEntry),
+		offline:  make(map[string]*pendingOffline),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, s.Handler())
+	s.httpSrv = &http.Server{Handler: mux}
+	s.heartbeat.Store(int64(cfg.HeartbeatTimeout))
+	return s
+}
+
+// SetHeartbeatTimeout 修改心跳超时，已连接的会话在收到下一条消息后按新值计算；d 为0时使用默认值
+func (s *Server) SetHeartbeatTimeout(d time.Duration) {
+	if s == nil {
+		return
+	}
+	if d <= 0 {
+		d = DefaultHeartbeatTimeout
+	}
+	s.heartbeat.Store(int64(d))
+}
+
+func (s *Server) heartbeatTimeout() time.Duration {
+	return time.Duration(s.heartbeat.Load())
+}
+
+// OnAck 设置设备 ack 消息的处理函数，需在 ListenAndServe 之前调用
+func (s *Server) OnAck(fn func(deviceNumber string, ack Message)) {
+	if s != nil {
+		s.onAck = fn
+	}
+}
+
+// OnHello 设置设备 hello 握手完成后的处理函数(如下发离线期间排队的消息)，需在 ListenAndServe 之前调用
+func (s *Server) OnHello(fn func(deviceNumber string)) {
+	if s != nil {
+		s.onHello = fn
+	}
+}
+
+// OnOTAProgress 设置设备 ota_progress 消息(固件升级进度、分片确认)的处理函数，需在 ListenAndServe 之前调用
+func (s *Server) OnOTAProgress(fn func(deviceNumber string, progress Message)) {
+	if s != nil {
+		s.onOTA = fn
+	}
+}
+
+// ListenAndServe 在配置的地址和端口上监听设备连接，s 为nil时直接返回
+func (s *Server) ListenAndServe() error {
+	if s == nil {
+		return nil
+	}
+	l, err := listen.Hosts(s.cfg.Network, s.cfg.Bind, s.cfg.Port)
+	if err != nil {
+		return err
+	}
+	s.logger.WithField("addr", l.Addr().String()).WithField("path", s.cfg.Path).Info("正在启动设备WebSocket服务")
+	go s.watchPressure()
+	if err := s.httpSrv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 停止接受新的设备连接，已建立的会话由 Close 断开
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	return s.httpSrv.Shutdown(ctx)
+}
+
+// credentials 取设备提交的凭证：HTTP Basic 认证，或 Authorization: Bearer <用户名:密码>，
+// Bearer 令牌不含冒号时作为密码、用户名为空
+func credentials(r *http.Request) (string, string) {
+	if username, password, ok := r.BasicAuth(); ok {
+		return username, password
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if username, password, ok := strings.Cut(token, ":"); ok {
+		return username, password
+	}
+	return "", token
+}
+
+// authenticate 按设备编号获取设备并校验设备凭证(form_voucher.json)，设备不存在与凭证不匹配不作区分
+func (s *Server) authenticate(r *http.Request) (deviceNumber, deviceID string, ok bool) {
+	deviceNumber = claimedNumber(r)
+	username, password := credentials(r)
+	if deviceNumber == "" || password == "" {
+		return deviceNumber, "", false
+	}
+	device, err := s.platform.GetDevice(deviceNumber)
+	if err != nil {
+		s.logger.WithError(err).WithField("device_number", deviceNumber).Debug("获取设备信息失败")
+		return deviceNumber, "", false
+	}
+	var voucher formjson.DeviceVoucher
+	if json.Unmarshal([]byte(device.Voucher), &voucher) != nil || !voucher.Match(username, password) {
+		return deviceNumber, "", false
+	}
+	return deviceNumber, device.ID, true
+}
+
+// reserve 在鉴权之前占用一个会话名额，与已建立的会话一起计入 MaxConnections，并发握手不会超出上限；
+// 声明的设备编号已有会话时为替换旧会话，不受上限限制。占用的名额在登记会话时转为会话，失败时由 unreserve 归还
+func (s *Server) reserve(number string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.MaxConnections > 0 && s.sessions[number] == nil && len(s.sessions)+s.reserved >= s.cfg.MaxConnections {
+		return false
+	}
+	s.reserved++
+	return true
+}
+
+func (s *Server) unreserve() {
+	s.mu.Lock()
+	s.reserved--
+	s.mu.Unlock()
+}
+
+// Handler 设备连接入口；同一设备重复连接时替换旧会话。
+// 先占用会话名额，再按续连令牌或设备凭证鉴权，鉴权失败过多的设备编号和来源地址暂时直接拒绝
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := s.logger.WithField("remote_addr", r.RemoteAddr)
+		if s.closing.Load() {
+			http.Error(w, "服务正在关闭", http.StatusServiceUnavailable)
+			return
+		}
+		claimed := claimedNumber(r)
+		if !s.reserve(claimed) {
+			wsRejected.WithLabelValues("max_connections").Inc()
+			log.WithField("device_number", claimed).Warn("设备WebSocket会话数已达上限")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		registered := false
+		defer func() {
+			if !registered {
+				s.unreserve()
+			}
+		}()
+
+		resumed, resumedOK := s.resume(r)
+		number, deviceID := resumed.DeviceNumber, resumed.DeviceID
+		if !resumedOK {
+			keys := []string{addrKey(remoteHost(r))}
+			if claimed != "" {
+				keys = append(keys, deviceKey(claimed))
+			}
+			if s.authFailures.blocked(time.Now(), keys...) {
+				wsRejected.WithLabelValues("auth_throttled").Inc()
+				log.WithField("device_number", claimed).Warn("设备WebSocket鉴权失败次数过多，暂时拒绝")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			var ok bool
+			number, deviceID, ok = s.authenticate(r)
+			if !ok {
+				s.authFailures.fail(time.Now(), keys...)
+				wsRejected.WithLabelValues("unauthorized").Inc()
+				log.WithField("device_number", number).Warn("设备WebSocket鉴权失败")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			s.authFailures.reset(deviceKey(number))
+		}
+		ws, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.WithError(err).WithField("device_number", number).Warn("建立设备WebSocket连接失败")
+			return
+		}
+		ws.SetReadLimit(s.cfg.MaxMessageSize)
+		sess := &session{
+			ws:     ws,
+			closed: make(chan struct{}),
+			info: SessionInfo{
+				SessionID:       newSessionID(),
+				DeviceNumber:    number,
+				DeviceID:        deviceID,
+				ClientID:        r.Header.Get(ClientIDHeader),
+				ProtocolVersion: r.Header.Get(ProtocolVersionHeader),
+				RemoteAddr:      r.RemoteAddr,
+				ConnectedAt:     time.Now(),
+			},
+			resumed: resumedOK,
+		}
+		if resumedOK {
+			// 续连沿用原会话ID和统计
+			sess.info = resumed
+			sess.info.RemoteAddr = r.RemoteAddr
+			sess.info.Resumes++
+		}
+		s.mu.Lock()
+		// 与 Close 在同一把锁内判断，Close 开始等待后不再登记新会话
+		if s.closing.Load() {
+			s.mu.Unlock()
+			ws.Close()
+			return
+		}
+		s.serving.Add(1)
+		s.reserved--
+		registered = true
+		old := s.sessions[number]
+		s.sessions[number] = sess
+		if old != nil {
+			delete(s.resumes, old.resume)
+		}
+		stillOnline := s.cancelOffline(number)
+		wsSessions.WithLabelValues().Set(float64(len(s.sessions)))
+		s.mu.Unlock()
+		if old != nil {
+			old.close()
+		}
+		// 续连时平台上仍为在线(下线上报尚在推迟中)，不再重复上报
+		if !resumedOK || !stillOnline {
+			if err := s.platform.SendDeviceStatus(deviceID, "1"); err != nil {
+				log.WithError(err).WithField("device_number", number).Warn("上报设备上线失败")
+			}
+		}
+		log.WithFields(logrus.Fields{
+			"device_number": number,
+			"session_id":    sess.info.SessionID,
+			"client_id":     sess.info.ClientID,
+			"resumed":       resumedOK,
+		}).Info("设备WebSocket已连接")
+		go s.ping(sess)
+		s.serve(sess)
+	})
+}
+
+// serve 读取设备上行消息，连接断开时移除会话；被新会话替换时不上报下线
+func (s *Server) serve(sess *session) {
+	number, deviceID := sess.info.DeviceNumber, sess.info.DeviceID
+	defer s.serving.Done()
+	defer func() {
+		sess.close()
+		s.mu.Lock()
+		current := s.sessions[number] == sess
+		if current {
+			delete(s.sessions, number)
+		}
+		wsSessions.WithLabelValues().Set(float64(len(s.sessions)))
+		s.mu.Unlock()
+		if current && !s.deferOffline(sess) {
+			if err := s.platform.SendDeviceStatus(deviceID, "0"); err != nil {
+				s.logger.WithError(err).WithField("device_number", number).Warn("上报设备下线失败")
+			}
+		}
+		s.logger.WithField("device_number", number).WithField("session_id", sess.info.SessionID).Info("设备WebSocket已断开")
+	}()
+	sess.ws.SetReadDeadline(time.Now().Add(s.heartbeatTimeout()))
+	sess.ws.SetPongHandler(func(data string) error {
+		now := time.Now()
+		sess.mu.Lock()
+		sess.info.Pongs++
+		// ping 载荷为发送时间(纳秒)，设备按协议原样应答
+		if sent, err := strconv.ParseInt(data, 10, 64); err == nil && sent > 0 {
+			sess.info.RTTMs = float64(now.Sub(time.Unix(0, sent)).Microseconds()) / 1000
+		}
+		sess.probeAnswered(data, now)
+		sess.mu.Unlock()
+		return sess.ws.SetReadDeadline(now.Add(s.heartbeatTimeout()))
+	})
+	for {
+		messageType, data, err := sess.ws.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				wsRejected.WithLabelValues("message_too_large").Inc()
+				s.logger.WithField("device_number", number).WithField("limit", s.cfg.MaxMessageSize).Warn("设备WebSocket消息超出大小上限，断开连接")
+			}
+			return
+		}
+		sess.ws.SetReadDeadline(time.Now().Add(s.heartbeatTimeout()))
+		sess.mu.Lock()
+		now := time.Now()
+		sess.info.LastMessageAt = &now
+		sess.info.Messages++
+		sess.info.BytesIn += uint64(len(data))
+		sess.mu.Unlock()
+		if messageType != websocket.TextMessage {
+			// 语音数据由小智服务端处理，插件不转发
+			wsMessages.WithLabelValues("binary", "ignored").Inc()
+			continue
+		}
+		s.handleMessage(sess, data)
+	}
+}
+
+// handleMessage 处理一条JSON文本消息：hello 应答会话ID，telemetry 和 iot 发布为遥测，ack 交给 OnAck 设置的处理函数，
+// ota_progress 交给 OnOTAProgress 设置的处理函数，其余类型忽略；遥测上报队列已满时要求设备降低上报频率
+func (s *Server) handleMessage(sess *session, data []byte) {
+	log := s.logger.WithField("device_number", sess.info.DeviceNumber)
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		wsMessages.WithLabelValues("invalid", "error").Inc()
+		log.WithError(err).Warn("解析设备WebSocket消息失败")
+		return
+	}
+	var values map[string]interface{}
+	switch msg.Type {
+	case "hello":
+		reply, _ := json.Marshal(Message{
+			Type:        "hello",
+			Transport:   "websocket",
+			SessionID:   sess.info.SessionID,
+			Version:     msg.Version,
+			ResumeToken: s.issueResume(sess),
+			Resumed:     sess.resumed,
+		})
+		if err := sess.write(websocket.TextMessage, reply); err != nil {
+			sess.close()
+			return
+		}
+		// 限速期间连接的设备在 hello 应答后立即收到 throttle 消息
+		if s.throttled.Load() {
+			if err := sess.write(websocket.TextMessage, s.throttleMessage(true, s.platform.TelemetryPressure())); err != nil {
+				sess.close()
+				return
+			}
+		}
+		if s.onHello != nil {
+			go s.onHello(sess.info.DeviceNumber)
+		}
+		wsMessages.WithLabelValues(msg.Type, "ok").Inc()
+		return
+	case "ack":
+		if s.onAck == nil || msg.ID == "" {
+			wsMessages.WithLabelValues(msg.Type, "ignored").Inc()
+			return
+		}
+		s.onAck(sess.info.DeviceNumber, msg)
+		wsMessages.WithLabelValues(msg.Type, "ok").Inc()
+		return
+	case "ota_progress":
+		if s.onOTA == nil {
+			wsMessages.WithLabelValues(msg.Type, "ignored").Inc()
+			return
+		}
+		s.onOTA(sess.info.DeviceNumber, msg)
+		wsMessages.WithLabelValues(msg.Type, "ok").Inc()
+		return
+	case "telemetry":
+		values = msg.Values
+	case "iot":
+		values = iotValues(msg.States)
+	default:
+		wsMessages.WithLabelValues("other", "ignored").Inc()
+		log.WithField("type", msg.Type).Debug("忽略设备WebSocket消息")
+		return
+	}
+	if len(values) == 0 {
+		wsMessages.WithLabelValues(msg.Type, "ignored").Inc()
+		return
+	}
+	if err := s.platform.SendTelemetry(sess.info.DeviceID, values); err != nil {
+		wsMessages.WithLabelValues(msg.Type, "error").Inc()
+		log.WithError(err).Error("发布设备遥测失败")
+		s.overloaded(err)
+		return
+	}
+	wsMessages.WithLabelValues(msg.Type, "ok").Inc()
+}
+
+// iotValues 将物联网对象状态展开为遥测字段 <对象名>.<状态名>，如 Speaker.volume
+func iotValues(states []IoTState) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, st := range states {
+		for k, v := range st.State {
+			if st.Name == "" {
+				values[k] = v
+				continue
+			}
+			values[st.Name+"."+k] = v
+		}
+	}
+	return values
+}
+
+// pingInterval 配置了 PingInterval 时按配置，否则为当前心跳超时的一半
+func (s *Server) pingInterval() time.Duration {
+	if s.cfg.PingInterval > 0 {
+		return s.cfg.PingInterval
+	}
+	return s.heartbeatTimeout() / 2
+}
+
+// ping 定期发送ping，载荷为发送时间用于计算往返时延；配置了 PongTimeout 时将读超时缩短到该时长，
+// 设备在此期间应答pong或发送任何消息后恢复按心跳超时计算
+func (s *Server) ping(sess *session) {
+	interval := s.pingInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.closed:
+			return
+		case <-ticker.C:
+			// 先缩短读超时再发送，避免应答先于设置到达时被覆盖
+			now := time.Now()
+			if t := s.cfg.PongTimeout; t > 0 && t < s.heartbeatTimeout() {
+				sess.ws.SetReadDeadline(now.Add(t))
+			}
+			if err := sess.write(websocket.PingMessage, []byte(strconv.FormatInt(now.UnixNano(), 10))); err != nil {
+				sess.close()
+				return
+			}
+			if next := s.pingInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// Send 向已连接的设备发送一条JSON文本消息
+func (s *Server) Send(deviceNumber string, v interface{}) error {
+	if s == nil {
+		return ErrNotConnected
+	}
+	s.mu.Lock()
+	sess := s.sessions[deviceNumber]
+	s.mu.Unlock()
+	if sess == nil {
+		return ErrNotConnected
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := sess.write(websocket.TextMessage, data); err != nil {
+		sess.close()
+		return fmt.Errorf("发送消息到设备失败: %v", err)
+	}
+	return nil
+}
+
+// Connected 判断设备是否有已建立的会话
+func (s *Server) Connected(deviceNumber string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[deviceNumber] != nil
+}
+
+// Sessions 返回全部会话，按设备编号排序
+func (s *Server) Sessions() []SessionInfo {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	list := make([]SessionInfo, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		list = append(list, sess.snapshot())
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].DeviceNumber < list[j].DeviceNumber })
+	return list
+}
+
+// Close 断开全部会话，等待各会话上报下线后返回，ctx 到期时不再等待并返回 ctx 的错误；
+// 需在关闭平台客户端之前调用，可重复调用
+func (s *Server) Close(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	s.closing.Store(true)
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.close()
+	}
+	s.flushOffline()
+
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,387 @@
+package wsserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"tp-plugin/internal/platform"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/types"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// testPassword 模拟平台中全部设备的凭证密码，编号以 unknown 开头的设备不存在
+const testPassword = "p"
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// testPlatform 模拟ThingsPanel平台的设备查询接口，记录查询次数
+type testPlatform struct {
+	*platform.PlatformClient
+	lookups atomic.Int64
+}
+
+func newTestPlatform(t *testing.T) *testPlatform {
+	t.Helper()
+	tp := &testPlatform{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tp.lookups.Add(1)
+		var req struct {
+			DeviceNumber string `json:"device_number"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if strings.HasPrefix(req.DeviceNumber, "unknown") {
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": 404, "message": "device not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":    200,
+			"message": "success",
+			"data": types.Device{
+				ID:           "id-" + req.DeviceNumber,
+				DeviceNumber: req.DeviceNumber,
+				Voucher:      `{"username":"u","password":"` + testPassword + `"}`,
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	p, err := platform.NewPlatformClient(platform.Config{
+		BaseURL:     srv.URL,
+		MQTTBroker:  "tcp://127.0.0.1:1",
+		LazyConnect: true,
+	}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	tp.PlatformClient = p
+	return tp
+}
+
+// startServer 启动设备WebSocket服务，返回服务和 ws:// 连接地址
+func startServer(t *testing.T, cfg Config) (*Server, *testPlatform, string) {
+	t.Helper()
+	tp := newTestPlatform(t)
+	cfg.Port = 1
+	s := New(cfg, tp.PlatformClient, testLogger())
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		s.Close(context.Background())
+		srv.Close()
+	})
+	return s, tp, "ws" + strings.TrimPrefix(srv.URL, "http") + DefaultPath
+}
+
+// dialDevice 以设备身份连接，header 为附加的请求头；失败时返回HTTP状态码
+func dialDevice(url, number, password string, header http.Header) (*websocket.Conn, int, error) {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(DeviceIDHeader, number)
+	if password != "" {
+		header.Set("Authorization", "Bearer u:"+password)
+	}
+	ws, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		return nil, status, err
+	}
+	return ws, http.StatusSwitchingProtocols, nil
+}
+
+// connect 连接设备并等待服务登记会话
+func connect(t *testing.T, s *Server, url, number string, header http.Header) *websocket.Conn {
+	t.Helper()
+	ws, status, err := dialDevice(url, number, testPassword, header)
+	if err != nil {
+		t.Fatalf("设备 %s 连接失败: %d %v", number, status, err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	waitFor(t, func() bool { return s.Connected(number) })
+	return ws
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// hello 发送 hello 并返回应答
+func hello(t *testing.T, ws *websocket.Conn) Message {
+	t.Helper()
+	if err := ws.WriteJSON(Message{Type: "hello", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return readMessage(t, ws)
+}
+
+func readMessage(t *testing.T, ws *websocket.Conn) Message {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg Message
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatalf("读取消息失败: %v", err)
+	}
+	return msg
+}
+
+func TestMaxConnectionsConcurrentHandshakes(t *testing.T) {
+	const max = 3
+	s, _, url := startServer(t, Config{MaxConnections: max})
+
+	const n = 12
+	var wg sync.WaitGroup
+	var accepted, rejected atomic.Int64
+	conns := make(chan *websocket.Conn, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ws, status, err := dialDevice(url, fmt.Sprintf("A4:CF:12:00:01:%02d", i), testPassword, nil)
+			switch {
+			case err == nil:
+				accepted.Add(1)
+				conns <- ws
+			case status == http.StatusServiceUnavailable:
+				rejected.Add(1)
+			default:
+				t.Errorf("连接返回 %d %v", status, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(conns)
+	for ws := range conns {
+		defer ws.Close()
+	}
+	if accepted.Load() != max || rejected.Load() != n-max {
+		t.Fatalf("接受 %d 个、拒绝 %d 个连接，期望接受 %d 个", accepted.Load(), rejected.Load(), max)
+	}
+	waitFor(t, func() bool { return len(s.Sessions()) == max })
+
+	// 已连接的设备重连时替换旧会话，不受上限限制
+	number := s.Sessions()[0].DeviceNumber
+	ws, status, err := dialDevice(url, number, testPassword, nil)
+	if err != nil {
+		t.Fatalf("已连接设备重连失败: %d %v", status, err)
+	}
+	defer ws.Close()
+	// 鉴权失败归还占用的名额
+	if _, status, _ := dialDevice(url, number, "wrong", nil); status != http.StatusUnauthorized {
+		t.Fatalf("错误密码返回 %d", status)
+	}
+	if s.mu.Lock(); s.reserved != 0 {
+		t.Errorf("握手结束后仍占用 %d 个名额", s.reserved)
+	}
+	s.mu.Unlock()
+	if got := len(s.Sessions()); got != max {
+		t.Fatalf("会话数 %d，期望 %d", got, max)
+	}
+}
+
+func TestMaxConnectionsCheckedBeforeAuth(t *testing.T) {
+	s, tp, url := startServer(t, Config{MaxConnections: 1})
+	connect(t, s, url, "A4:CF:12:00:02:01", nil)
+	before := tp.lookups.Load()
+	if _, status, _ := dialDevice(url, "A4:CF:12:00:02:02", testPassword, nil); status != http.StatusServiceUnavailable {
+		t.Fatalf("会话数已满时返回 %d", status)
+	}
+	if tp.lookups.Load() != before {
+		t.Fatal("会话数已满时仍向平台查询设备")
+	}
+}
+
+func TestAuthFailureRateLimit(t *testing.T) {
+	s, tp, url := startServer(t, Config{})
+	// 同一地址逐个尝试不存在的设备编号
+	for i := 0; i < authFailureLimit; i++ {
+		if _, status, _ := dialDevice(url, fmt.Sprintf("unknown-%d", i), testPassword, nil); status != http.StatusUnauthorized {
+			t.Fatalf("第%d次鉴权失败返回 %d", i+1, status)
+		}
+	}
+	lookups := tp.lookups.Load()
+	for _, number := range []string{"unknown-x", "A4:CF:12:00:03:01"} {
+		if _, status, _ := dialDevice(url, number, testPassword, nil); status != http.StatusTooManyRequests {
+			t.Fatalf("失败次数达到上限后设备 %s 返回 %d", number, status)
+		}
+	}
+	if tp.lookups.Load() != lookups {
+		t.Fatalf("限制期间向平台查询了 %d 次设备", tp.lookups.Load()-lookups)
+	}
+	if s.Connected("A4:CF:12:00:03:01") {
+		t.Fatal("限制期间建立了会话")
+	}
+}
+
+func TestAuthLimiter(t *testing.T) {
+	var l authLimiter
+	now := time.Now()
+	// 同一设备从不同地址尝试
+	for i := 0; i < authFailureLimit; i++ {
+		if l.blocked(now, deviceKey("d1"), addrKey(fmt.Sprint("10.0.0.", i))) {
+			t.Fatalf("第%d次尝试前已被限制", i+1)
+		}
+		l.fail(now, deviceKey("d1"), addrKey(fmt.Sprint("10.0.0.", i)))
+	}
+	if !l.blocked(now, deviceKey("d1"), addrKey("10.0.0.99")) {
+		t.Fatal("设备失败次数达到上限后未限制")
+	}
+	if l.blocked(now, deviceKey("d2"), addrKey("10.0.0.1")) {
+		t.Fatal("其他设备和地址被限制")
+	}
+	if l.blocked(now.Add(authFailureWindow), deviceKey("d1")) {
+		t.Fatal("窗口结束后仍被限制")
+	}
+	l.reset(deviceKey("d1"))
+	if l.blocked(now, deviceKey("d1")) {
+		t.Fatal("鉴权成功后仍被限制")
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	s, tp, url := startServer(t, Config{ResumeTTL: time.Minute})
+	const number = "A4:CF:12:00:04:01"
+	ws := connect(t, s, url, number, nil)
+	first := hello(t, ws)
+	if first.ResumeToken == "" || first.Resumed {
+		t.Fatalf("hello 应答 %+v", first)
+	}
+	ws.Close()
+	waitFor(t, func() bool { return !s.Connected(number) })
+	// 续连有效期内不上报下线
+	if !tp.Online("id-" + number) {
+		t.Fatal("断开后立即上报了下线")
+	}
+
+	// 续连无需设备凭证，沿用会话ID
+	header := http.Header{ResumeHeader: {first.ResumeToken}}
+	resumed, status, err := dialDevice(url, number, "", header)
+	if err != nil {
+		t.Fatalf("续连失败: %d %v", status, err)
+	}
+	defer resumed.Close()
+	waitFor(t, func() bool { return s.Connected(number) })
+	second := hello(t, resumed)
+	if !second.Resumed || second.SessionID != first.SessionID || second.ResumeToken == first.ResumeToken {
+		t.Fatalf("续连的 hello 应答 %+v，首次 %+v", second, first)
+	}
+	if info := s.Sessions()[0]; info.Resumes != 1 {
+		t.Fatalf("续连次数 %d", info.Resumes)
+	}
+
+	// 令牌只能使用一次，与设备编号不符的令牌无效
+	if _, status, _ := dialDevice(url, number, "", header); status != http.StatusUnauthorized {
+		t.Fatalf("重复使用令牌返回 %d", status)
+	}
+	other := http.Header{ResumeHeader: {second.ResumeToken}}
+	if _, status, _ := dialDevice(url, "A4:CF:12:00:04:02", "", other); status != http.StatusUnauthorized {
+		t.Fatalf("其他设备使用令牌返回 %d", status)
+	}
+}
+
+func TestResumeExpiredReportsOffline(t *testing.T) {
+	s, tp, url := startServer(t, Config{ResumeTTL: 50 * time.Millisecond})
+	const number = "A4:CF:12:00:05:01"
+	ws := connect(t, s, url, number, nil)
+	token := hello(t, ws).ResumeToken
+	ws.Close()
+	waitFor(t, func() bool { return !tp.Online("id-" + number) })
+	if _, status, _ := dialDevice(url, number, "", http.Header{ResumeHeader: {token}}); status != http.StatusUnauthorized {
+		t.Fatalf("过期令牌返回 %d", status)
+	}
+}
+
+func TestThrottleHysteresis(t *testing.T) {
+	s, _, url := startServer(t, Config{Throttle: ThrottleConfig{ReportInterval: 30 * time.Second}})
+	ws := connect(t, s, url, "A4:CF:12:00:06:01", nil)
+	high, low := s.cfg.Throttle.thresholds()
+
+	steps := []struct {
+		pressure  float64
+		throttled bool
+		message   bool // 是否下发 throttle 消息
+	}{
+		{0.6, false, false}, // 未达到 high
+		{0.9, true, true},
+		{0.6, true, false}, // 介于两者之间保持限速
+		{0.85, true, false},
+		{0.3, false, true},
+		{0.6, false, false}, // 介于两者之间保持恢复
+	}
+	for i, step := range steps {
+		s.applyPressure(step.pressure, high, low)
+		if s.throttled.Load() != step.throttled {
+			t.Fatalf("第%d步占用 %v 后限速状态为 %v", i+1, step.pressure, !step.throttled)
+		}
+		if !step.message {
+			continue
+		}
+		msg := readMessage(t, ws)
+		if msg.Type != "throttle" || msg.Throttle == nil || msg.Throttle.Active != step.throttled {
+			t.Fatalf("第%d步收到消息 %+v", i+1, msg)
+		}
+		if step.throttled && msg.Throttle.ReportInterval != 30 {
+			t.Fatalf("建议上报间隔 %d", msg.Throttle.ReportInterval)
+		}
+	}
+	// 未切换状态的步骤不下发消息：再切换一次，收到的下一条即为本次消息
+	s.applyPressure(1, high, low)
+	if msg := readMessage(t, ws); msg.Throttle == nil || !msg.Throttle.Active || msg.Throttle.Pressure != 1 {
+		t.Fatalf("收到多余的消息 %+v", msg)
+	}
+}
+
+func TestThrottleThresholds(t *testing.T) {
+	cases := []struct {
+		cfg       ThrottleConfig
+		high, low float64
+	}{
+		{ThrottleConfig{}, DefaultThrottleHigh, DefaultThrottleLow},
+		{ThrottleConfig{High: 0.9, Low: 0.7}, 0.9, 0.7},
+		{ThrottleConfig{High: 0.9, Low: 0.95}, 0.9, DefaultThrottleLow}, // low 不小于 high 时使用默认值
+		{ThrottleConfig{High: 0.4}, 0.4, 0.2},
+	}
+	for _, c := range cases {
+		if high, low := c.cfg.thresholds(); high != c.high || low != c.low {
+			t.Errorf("%+v 的阈值为 %v/%v，期望 %v/%v", c.cfg, high, low, c.high, c.low)
+		}
+	}
+}
+
+func TestLastMessageAt(t *testing.T) {
+	s, _, url := startServer(t, Config{})
+	ws := connect(t, s, url, "A4:CF:12:00:07:01", nil)
+
+	info := s.Sessions()[0]
+	data, _ := json.Marshal(info)
+	if info.LastMessageAt != nil || strings.Contains(string(data), "last_message_at") {
+		t.Fatalf("尚未收到消息时的会话状态 %s", data)
+	}
+	hello(t, ws)
+	info = s.Sessions()[0]
+	if info.LastMessageAt == nil || info.LastMessageAt.Before(info.ConnectedAt) || info.Messages != 1 {
+		t.Fatalf("收到消息后的会话状态 %+v", info)
+	}
+}
@@ -0,0 +1,184 @@
+// tools/schemagen/main.go
+// schemagen 根据 JSON Schema(definitions 子集)生成 Go 结构体
+//
+// 支持的类型: object/array/string/integer/number/boolean 及 "#/definitions/X" 引用；
+// 非 required 字段生成 omitempty 标签。用法:
+//
+//	go run ../../tools/schemagen -in schema/xiaozhi.json -out types_gen.go -pkg upstream
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"sort"
+	"strings"
+)
+
+// schema JSON Schema 子集
+type schema struct {
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Type        string             `json:"type"`
+	Ref         string             `json:"$ref"`
+	Properties  map[string]*schema `json:"properties"`
+	Required    []string           `json:"required"`
+	Items       *schema            `json:"items"`
+	Definitions map[string]*schema `json:"definitions"`
+}
+
+// initialisms 字段名中需要全大写的缩写
+var initialisms = map[string]string{
+	"id": "ID", "url": "URL", "ip": "IP", "mac": "MAC", "ota": "OTA", "api": "API", "ts": "TS",
+}
+
+func main() {
+	in := flag.String("in", "", "schema file")
+	out := flag.String("out", "", "output go file")
+	pkg := flag.String("pkg", "", "package name")
+	flag.Parse()
+	if *in == "" || *out == "" || *pkg == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*in, *out, *pkg); err != nil {
+		fmt.Fprintf(os.Stderr, "schemagen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out, pkg string) error {
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	var root schema
+	if err := json.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("解析schema失败: %v", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by tools/schemagen from %s; DO NOT EDIT.\n\n", in)
+	fmt.Fprintf(&buf, "package %s\n", pkg)
+
+	names := make([]string, 0, len(root.Definitions))
+	for name := range root.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeStruct(&buf, name, root.Definitions[name]); err != nil {
+			return err
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("格式化生成代码失败: %v\n%s", err, buf.String())
+	}
+	return os.WriteFile(out, src, 0644)
+}
+
+func writeStruct(buf *bytes.Buffer, name string, s *schema) error {
+	if s.Type != "object" {
+		return fmt.Errorf("%s: 顶层定义必须为 object", name)
+	}
+	buf.WriteString("\n")
+	writeComment(buf, name, s.Description)
+	fmt.Fprintf(buf, "type %s struct {\n", name)
+
+	required := make(map[string]bool, len(s.Required))
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	props := make([]string, 0, len(s.Properties))
+	for p := range s.Properties {
+		props = append(props, p)
+	}
+	sort.Strings(props)
+
+	for _, p := range props {
+		prop := s.Properties[p]
+		typ, err := goType(prop)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", name, p, err)
+		}
+		tag := p
+		if !required[p] {
+			tag += ",omitempty"
+		}
+		line := fmt.Sprintf("\t%s %s `json:\"%s\"`", fieldName(p), typ, tag)
+		if prop.Description != "" {
+			line += " // " + prop.Description
+		}
+		buf.WriteString(line + "\n")
+	}
+	buf.WriteString("}\n")
+	return nil
+}
+
+func writeComment(buf *bytes.Buffer, name, desc string) {
+	if desc == "" {
+		return
+	}
+	lines := strings.Split(desc, "\n")
+	fmt.Fprintf(buf, "// %s %s\n", name, lines[0])
+	for _, l := range lines[1:] {
+		fmt.Fprintf(buf, "// %s\n", l)
+	}
+}
+
+func goType(s *schema) (string, error) {
+	if s.Ref != "" {
+		const prefix = "#/definitions/"
+		if !strings.HasPrefix(s.Ref, prefix) {
+			return "", fmt.Errorf("不支持的引用: %s", s.Ref)
+		}
+		return strings.TrimPrefix(s.Ref, prefix), nil
+	}
+	switch s.Type {
+	case "string":
+		return "string", nil
+	case "integer":
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array 缺少 items")
+		}
+		item, err := goType(s.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + item, nil
+	case "object":
+		// 未引用具体定义的对象按自由结构处理
+		return "map[string]interface{}", nil
+	case "":
+		return "", fmt.Errorf("缺少 type")
+	default:
+		return "", fmt.Errorf("不支持的类型: %s", s.Type)
+	}
+}
+
+// fieldName 将 snake_case 转为导出的 Go 字段名
+func fieldName(p string) string {
+	parts := strings.Split(p, "_")
+	for i, part := range parts {
+		if up, ok := initialisms[strings.ToLower(part)]; ok {
+			parts[i] = up
+			continue
+		}
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}